@@ -0,0 +1,166 @@
+package c4fs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"iter"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Avalanche-io/c4"
+)
+
+// DefaultLogChunkSize is the chunk size OpenAppendLog uses when given zero.
+const DefaultLogChunkSize = 1 << 20
+
+// AppendLog is an append-only log of records kept in a directory of an FS.
+// Records are stored in numbered chunk files; once a chunk reaches the
+// chunk size the log rolls over to a new one. A file's content is a single
+// blob, so each append rewrites the current chunk, but never the chunks
+// before it: the cost of an append is bounded by the chunk size rather
+// than growing with the log, and closed chunks are stored once and
+// deduplicated like any other content. The superseded versions of the
+// current chunk are left for garbage collection.
+//
+// Each record is stored as its length, a uvarint, followed by its bytes.
+//
+// An AppendLog is safe for concurrent use. Two logs open on the same
+// directory detect each other's appends: the later append fails, with a
+// *WriteConflictError or, if it was starting a new chunk, fs.ErrExist,
+// and the log must be reopened to continue.
+type AppendLog struct {
+	fsys      *FS
+	dir       string
+	chunkSize int
+
+	mu   sync.Mutex
+	seq  int    // number of the current chunk
+	tail []byte // content of the current chunk
+	id   c4.ID  // ID of tail as stored; nil if the chunk does not exist yet
+}
+
+// OpenAppendLog opens the log in dir, creating the directory if needed.
+// Appends go to the last chunk already in dir. A chunkSize of zero means
+// DefaultLogChunkSize.
+func OpenAppendLog(fsys *FS, dir string, chunkSize int) (*AppendLog, error) {
+	if chunkSize <= 0 {
+		chunkSize = DefaultLogChunkSize
+	}
+	if err := fsys.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	l := &AppendLog{fsys: fsys, dir: dir, chunkSize: chunkSize}
+
+	chunks, err := l.chunks()
+	if err != nil {
+		return nil, err
+	}
+	if len(chunks) > 0 {
+		l.seq = chunks[len(chunks)-1]
+		if l.tail, err = fsys.ReadFile(l.chunkName(l.seq)); err != nil {
+			return nil, err
+		}
+		l.id = c4.Identify(bytes.NewReader(l.tail))
+	}
+	return l, nil
+}
+
+// Append adds a record to the end of the log. A record larger than the
+// chunk size gets a chunk of its own.
+func (l *AppendLog) Append(record []byte) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	seq, tail, prev := l.seq, l.tail, l.id
+	var size [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(size[:], uint64(len(record))) + len(record)
+	if len(tail) > 0 && len(tail)+n > l.chunkSize {
+		seq, tail, prev = seq+1, nil, c4.ID{}
+	}
+	next := make([]byte, len(tail), len(tail)+n)
+	copy(next, tail)
+	next = append(next, size[:n-len(record)]...)
+	next = append(next, record...)
+
+	opts := WriteFileOptions{IfMatch: prev}
+	if prev.IsNil() {
+		opts.Exclusive = true
+	}
+	if err := l.fsys.WriteFileWithOptions(l.chunkName(seq), next, 0644, opts); err != nil {
+		return err
+	}
+	l.seq, l.tail, l.id = seq, next, c4.Identify(bytes.NewReader(next))
+	return nil
+}
+
+// Records yields the records in the log, oldest first. Chunks are read one
+// at a time, so records appended while iterating may or may not be seen.
+// If a chunk cannot be read or is corrupt, the error is yielded and
+// iteration stops.
+func (l *AppendLog) Records() iter.Seq2[[]byte, error] {
+	return func(yield func([]byte, error) bool) {
+		chunks, err := l.chunks()
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		for _, seq := range chunks {
+			name := l.chunkName(seq)
+			data, err := l.fsys.ReadFile(name)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			for len(data) > 0 {
+				size, n := binary.Uvarint(data)
+				if n <= 0 || uint64(len(data)-n) < size {
+					yield(nil, fmt.Errorf("append log %s: %w", name, io.ErrUnexpectedEOF))
+					return
+				}
+				data = data[n:]
+				if !yield(data[:size:size], nil) {
+					return
+				}
+				data = data[size:]
+			}
+		}
+	}
+}
+
+// Chunks returns the number of chunks in the log.
+func (l *AppendLog) Chunks() (int, error) {
+	chunks, err := l.chunks()
+	return len(chunks), err
+}
+
+// chunks returns the numbers of the chunk files in the log directory, in
+// order. Other files are ignored.
+func (l *AppendLog) chunks() ([]int, error) {
+	entries, err := l.fsys.ReadDir(l.dir)
+	if err != nil {
+		return nil, err
+	}
+	var seqs []int
+	for _, e := range entries {
+		num, ok := strings.CutSuffix(e.Name(), ".log")
+		if !ok || e.IsDir() {
+			continue
+		}
+		if seq, err := strconv.Atoi(num); err == nil && seq >= 0 {
+			seqs = append(seqs, seq)
+		}
+	}
+	slices.Sort(seqs)
+	return seqs, nil
+}
+
+// chunkName returns the path of chunk seq. Numbers are zero-padded so the
+// chunks also list in order.
+func (l *AppendLog) chunkName(seq int) string {
+	return path.Join(l.dir, fmt.Sprintf("%08d.log", seq))
+}
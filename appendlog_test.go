@@ -0,0 +1,86 @@
+package c4fs
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"testing"
+
+	"github.com/Avalanche-io/c4/store"
+)
+
+func TestAppendLog(t *testing.T) {
+	fsys := New(NewStoreAdapter(store.NewRAM()))
+	log, err := OpenAppendLog(fsys, "events", 64)
+	if err != nil {
+		t.Fatalf("OpenAppendLog failed: %v", err)
+	}
+
+	var want []string
+	for i := range 20 {
+		rec := fmt.Sprintf("event %d", i)
+		if err := log.Append([]byte(rec)); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+		want = append(want, rec)
+	}
+	// An empty record and one larger than a chunk
+	log.Append(nil)
+	want = append(want, "")
+	big := string(make([]byte, 200))
+	log.Append([]byte(big))
+	want = append(want, big)
+
+	if n, _ := log.Chunks(); n < 3 {
+		t.Errorf("log has %d chunks, want rollover into at least 3", n)
+	}
+	if info, _ := fsys.Stat(log.chunkName(0)); info.Size() > 64 {
+		t.Errorf("first chunk is %d bytes, over the chunk size", info.Size())
+	}
+
+	// A reopened log sees every record and appends after them
+	reopened, err := OpenAppendLog(fsys, "events", 64)
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	if err := reopened.Append([]byte("after reopen")); err != nil {
+		t.Fatalf("Append after reopen failed: %v", err)
+	}
+	want = append(want, "after reopen")
+
+	var got []string
+	for rec, err := range reopened.Records() {
+		if err != nil {
+			t.Fatalf("Records failed: %v", err)
+		}
+		got = append(got, string(rec))
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Records = %q, want %q", got, want)
+	}
+
+	// The first log missed the reopened log's append
+	var conflict *WriteConflictError
+	if err := log.Append([]byte("stale")); !errors.As(err, &conflict) && !errors.Is(err, fs.ErrExist) {
+		t.Errorf("Append from a stale log = %v, want a conflict", err)
+	}
+}
+
+func TestAppendLogCorrupt(t *testing.T) {
+	fsys := New(NewStoreAdapter(store.NewRAM()))
+	log, _ := OpenAppendLog(fsys, "events", 0)
+	log.Append([]byte("ok"))
+	fsys.WriteFile("events/00000001.log", []byte{0x05, 'a'}, 0644)
+
+	var records int
+	var err error
+	for _, err = range log.Records() {
+		if err != nil {
+			break
+		}
+		records++
+	}
+	if records != 1 || err == nil {
+		t.Errorf("read %d records and error %v, want 1 record then an error", records, err)
+	}
+}
@@ -0,0 +1,247 @@
+package c4fs
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/store"
+)
+
+// archiveMagic identifies a repository archive written by Backup. It is
+// followed by a single format version byte.
+var archiveMagic = []byte("C4BK")
+
+// archiveVersion is the current archive format version.
+const archiveVersion = 1
+
+// Record tags in an archive.
+const (
+	archiveTagEnd  = 0
+	archiveTagBlob = 1
+	archiveTagRef  = 2
+)
+
+// archiveChunkSize is the size of the chunks blob data is framed in.
+// Chunking lets blobs be streamed without knowing their size up front.
+const archiveChunkSize = 64 << 10
+
+// ErrArchiveVersion is returned when an archive was written by a newer,
+// unsupported format version.
+var ErrArchiveVersion = errors.New("unsupported archive version")
+
+// Backup writes every blob in src, and every reference in reg, to w as a
+// single self-contained archive. Snapshots stored in the registry are
+// blobs in its store, so backing up that store captures them too. reg may
+// be nil to archive blobs only. The source must be listable (see ListIDs).
+//
+// The archive is streamed: blobs are copied one at a time in chunks, so
+// memory use does not depend on blob size.
+func Backup(ctx context.Context, src store.Store, reg *Registry, w io.Writer) error {
+	ids, err := ListIDs(src)
+	if err != nil {
+		return fmt.Errorf("failed to list store: %w", err)
+	}
+	var refs map[string]c4.ID
+	if reg != nil {
+		if refs, err = reg.Refs(); err != nil {
+			return fmt.Errorf("failed to list references: %w", err)
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	bw.Write(archiveMagic)
+	bw.WriteByte(archiveVersion)
+
+	buf := make([]byte, archiveChunkSize)
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if _, err := backupBlob(bw, src, id, buf); err != nil {
+			return err
+		}
+	}
+
+	// Write references in a stable order
+	names := make([]string, 0, len(refs))
+	for name := range refs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		id := refs[name]
+		b := []byte{archiveTagRef}
+		b = binary.AppendUvarint(b, uint64(len(name)))
+		b = append(b, name...)
+		b = append(b, id[:]...)
+		bw.Write(b)
+	}
+
+	bw.WriteByte(archiveTagEnd)
+	return bw.Flush()
+}
+
+// backupBlob writes a single blob record and returns the blob's size.
+func backupBlob(bw *bufio.Writer, src store.Store, id c4.ID, buf []byte) (int64, error) {
+	rc, err := src.Open(id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open %s: %w", id, err)
+	}
+	defer rc.Close()
+
+	bw.WriteByte(archiveTagBlob)
+	bw.Write(id[:])
+
+	var hdr [binary.MaxVarintLen64]byte
+	var size int64
+	for {
+		n, err := io.ReadFull(rc, buf)
+		if n > 0 {
+			bw.Write(hdr[:binary.PutUvarint(hdr[:], uint64(n))])
+			if _, err := bw.Write(buf[:n]); err != nil {
+				return size, err
+			}
+			size += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return size, fmt.Errorf("failed to read %s: %w", id, err)
+		}
+	}
+	return size, bw.WriteByte(0)
+}
+
+// Restore reads an archive written by Backup, writing its blobs to dst
+// and its references to reg. Blobs already present in dst are skipped,
+// and every restored blob is verified against its C4 ID. If reg is nil,
+// references in the archive are ignored.
+func Restore(ctx context.Context, r io.Reader, dst store.Store, reg *Registry) error {
+	br := bufio.NewReader(r)
+	head := make([]byte, len(archiveMagic)+1)
+	if _, err := io.ReadFull(br, head); err != nil {
+		return fmt.Errorf("failed to read archive header: %w", err)
+	}
+	if !bytes.Equal(head[:len(archiveMagic)], archiveMagic) {
+		return fmt.Errorf("not a c4fs archive")
+	}
+	if v := head[len(archiveMagic)]; v != archiveVersion {
+		return fmt.Errorf("%w: %d", ErrArchiveVersion, v)
+	}
+
+	dstAdapter := NewStoreAdapter(dst)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		tag, err := br.ReadByte()
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+
+		switch tag {
+		case archiveTagEnd:
+			return nil
+
+		case archiveTagBlob:
+			var id c4.ID
+			if _, err := io.ReadFull(br, id[:]); err != nil {
+				return unexpectedEOF(err)
+			}
+			if dstAdapter.Has(id) {
+				err = restoreChunks(br, io.Discard)
+			} else {
+				err = restoreBlob(br, dst, id)
+			}
+			if err != nil {
+				return err
+			}
+
+		case archiveTagRef:
+			n, err := binary.ReadUvarint(br)
+			if err != nil {
+				return unexpectedEOF(err)
+			}
+			if n > 4096 {
+				return fmt.Errorf("corrupt archive: reference name length %d too large", n)
+			}
+			name := make([]byte, n)
+			if _, err := io.ReadFull(br, name); err != nil {
+				return unexpectedEOF(err)
+			}
+			var id c4.ID
+			if _, err := io.ReadFull(br, id[:]); err != nil {
+				return unexpectedEOF(err)
+			}
+			if reg != nil {
+				if err := reg.SetRef(string(name), id); err != nil {
+					return err
+				}
+			}
+
+		default:
+			return fmt.Errorf("corrupt archive: unknown record tag %d", tag)
+		}
+	}
+}
+
+// restoreBlob streams one blob's chunks into dst, verifying its ID.
+func restoreBlob(br *bufio.Reader, dst store.Store, id c4.ID) error {
+	wc, err := dst.Create(id)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", id, err)
+	}
+
+	pr, pw := io.Pipe()
+	var got c4.ID
+	done := make(chan struct{})
+	go func() {
+		got = c4.Identify(pr)
+		close(done)
+	}()
+	err = restoreChunks(br, io.MultiWriter(wc, pw))
+	pw.CloseWithError(err)
+	<-done
+
+	if err != nil {
+		wc.Close()
+		dst.Remove(id)
+		return err
+	}
+	if err := wc.Close(); err != nil {
+		return fmt.Errorf("failed to close %s: %w", id, err)
+	}
+	if got != id {
+		dst.Remove(id)
+		return fmt.Errorf("verification failed for %s: content hashes to %s", id, got)
+	}
+	return nil
+}
+
+// restoreChunks copies length-prefixed chunks to w until the zero-length
+// terminator.
+func restoreChunks(br *bufio.Reader, w io.Writer) error {
+	for {
+		n, err := binary.ReadUvarint(br)
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+		if n == 0 {
+			return nil
+		}
+		if n > archiveChunkSize {
+			return fmt.Errorf("corrupt archive: chunk length %d too large", n)
+		}
+		if _, err := io.CopyN(w, br, int64(n)); err != nil {
+			return unexpectedEOF(err)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package c4fs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/Avalanche-io/c4/store"
+)
+
+func TestBackupRestore(t *testing.T) {
+	src := store.NewRAM()
+	reg, err := NewRegistry(NewStoreAdapter(src), t.TempDir())
+	if err != nil {
+		t.Fatalf("NewRegistry failed: %v", err)
+	}
+
+	c4fs := New(reg.Store())
+	c4fs.WriteFile("small.txt", []byte("small"), 0644)
+	c4fs.WriteFile("large.bin", bytes.Repeat([]byte("0123456789"), 20000), 0644)
+	id, err := reg.Put(c4fs.Flatten())
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	reg.SetRef("main", id)
+
+	var archive bytes.Buffer
+	if err := Backup(context.Background(), src, reg, &archive); err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+
+	dst := store.NewRAM()
+	restored, err := NewRegistry(NewStoreAdapter(dst), t.TempDir())
+	if err != nil {
+		t.Fatalf("NewRegistry failed: %v", err)
+	}
+	if err := Restore(context.Background(), bytes.NewReader(archive.Bytes()), dst, restored); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+
+	if len(*dst) != len(*src) {
+		t.Errorf("Restored %d blobs, want %d", len(*dst), len(*src))
+	}
+	mainID, err := restored.Ref("main")
+	if err != nil || mainID != id {
+		t.Fatalf("Restored ref: got %s, %v", mainID, err)
+	}
+	m, err := restored.Get(mainID)
+	if err != nil {
+		t.Fatalf("Get restored snapshot failed: %v", err)
+	}
+	data, err := New(restored.Store(), WithBase(m)).ReadFile("large.bin")
+	if err != nil || len(data) != 200000 {
+		t.Errorf("Restored large.bin: got %d bytes, %v", len(data), err)
+	}
+
+	// Restoring again skips existing blobs
+	if err := Restore(context.Background(), bytes.NewReader(archive.Bytes()), dst, nil); err != nil {
+		t.Errorf("Second restore failed: %v", err)
+	}
+}
+
+func TestRestoreCorrupt(t *testing.T) {
+	src := store.NewRAM()
+	NewStoreAdapter(src).Put(bytes.NewReader([]byte("some content to corrupt")))
+
+	var archive bytes.Buffer
+	if err := Backup(context.Background(), src, nil, &archive); err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+	data := archive.Bytes()
+
+	// Flip a byte of blob content: header, tag, ID and chunk length precede it
+	corrupt := bytes.Clone(data)
+	corrupt[len(archiveMagic)+1+1+64+1] ^= 0xff
+	dst := store.NewRAM()
+	if err := Restore(context.Background(), bytes.NewReader(corrupt), dst, nil); err == nil {
+		t.Error("Expected verification error")
+	}
+	if len(*dst) != 0 {
+		t.Error("Corrupt blob should not be left in the store")
+	}
+
+	// Truncation is detected
+	err := Restore(context.Background(), bytes.NewReader(data[:len(data)-3]), store.NewRAM(), nil)
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Expected io.ErrUnexpectedEOF, got %v", err)
+	}
+
+	// Unknown versions are rejected
+	bad := bytes.Clone(data)
+	bad[len(archiveMagic)] = 99
+	if err := Restore(context.Background(), bytes.NewReader(bad), store.NewRAM(), nil); !errors.Is(err, ErrArchiveVersion) {
+		t.Errorf("Expected ErrArchiveVersion, got %v", err)
+	}
+}
@@ -0,0 +1,143 @@
+package c4fs
+
+import (
+	"strings"
+	"unsafe"
+
+	"github.com/Avalanche-io/c4/c4m"
+)
+
+// Arena block sizes.
+const (
+	arenaEntries   = 1024     // entries per block
+	arenaNameBytes = 64 << 10 // bytes per name buffer
+)
+
+// entryArena allocates entries in blocks, and their names in shared
+// buffers, so that loading or renaming millions of entries makes a few
+// thousand allocations rather than millions, and the garbage collector
+// has far fewer objects to scan.
+//
+// The cost is retention: a block stays alive as long as any entry or name
+// in it does. That suits base manifests, which live and die as a whole,
+// better than long-lived layers with much churn.
+//
+// An arena is not safe for concurrent use.
+type entryArena struct {
+	entries []c4m.Entry
+	names   strings.Builder
+}
+
+// newEntry returns a zeroed entry.
+func (a *entryArena) newEntry() *c4m.Entry {
+	if len(a.entries) == cap(a.entries) {
+		a.entries = make([]c4m.Entry, 0, arenaEntries)
+	}
+	a.entries = a.entries[:len(a.entries)+1]
+	return &a.entries[len(a.entries)-1]
+}
+
+// concat returns prefix+suffix, stored in a shared name buffer.
+func (a *entryArena) concat(prefix, suffix string) string {
+	n := len(prefix) + len(suffix)
+	if n > arenaNameBytes/4 {
+		return prefix + suffix
+	}
+	start := a.reserve(n)
+	a.names.WriteString(prefix)
+	a.names.WriteString(suffix)
+	return a.names.String()[start:]
+}
+
+// concatBytes is concat for a suffix read into a reusable buffer.
+func (a *entryArena) concatBytes(prefix string, suffix []byte) string {
+	n := len(prefix) + len(suffix)
+	if n > arenaNameBytes/4 {
+		return prefix + string(suffix)
+	}
+	start := a.reserve(n)
+	a.names.WriteString(prefix)
+	a.names.Write(suffix)
+	return a.names.String()[start:]
+}
+
+// reserve makes room for n bytes in the name buffer and returns the
+// offset they will be written at.
+func (a *entryArena) reserve(n int) int {
+	if a.names.Cap()-a.names.Len() < n {
+		// Start a new buffer rather than growing this one: growing would
+		// copy it, leaving names handed out pointing at the old copy
+		a.names = strings.Builder{}
+		a.names.Grow(arenaNameBytes)
+	}
+	return a.names.Len()
+}
+
+// allocEntry returns a new entry, from the arena if the filesystem uses
+// one. The caller must hold c4fs.mu for writing.
+func (c4fs *FS) allocEntry() *c4m.Entry {
+	if c4fs.arena == nil {
+		return new(c4m.Entry)
+	}
+	return c4fs.arena.newEntry()
+}
+
+// concatName returns prefix+suffix, from the arena if the filesystem uses
+// one. The caller must hold c4fs.mu for writing.
+func (c4fs *FS) concatName(prefix, suffix string) string {
+	if c4fs.arena == nil {
+		return prefix + suffix
+	}
+	return c4fs.arena.concat(prefix, suffix)
+}
+
+// MemoryStats describes the memory held by a filesystem's manifests.
+// Byte counts are estimates: they cover entries and their strings but not
+// allocator or map overhead.
+type MemoryStats struct {
+	BaseEntries  int
+	LayerEntries int
+	EntryBytes   int64 // Fixed-size entry structs
+	NameBytes    int64 // Path names and symlink targets
+	IndexBytes   int64 // Path index keys and values
+}
+
+// Total returns the estimated total in bytes.
+func (s MemoryStats) Total() int64 {
+	return s.EntryBytes + s.NameBytes + s.IndexBytes
+}
+
+// MemoryStats estimates the memory held by the base and layer manifests
+// and their indexes, for capacity planning and for comparing the effect
+// of options such as WithEntryArena. Use runtime/pprof heap profiles for
+// exact numbers.
+func (c4fs *FS) MemoryStats() MemoryStats {
+	c4fs.mu.RLock()
+	defer c4fs.mu.RUnlock()
+
+	const (
+		entrySize = int64(unsafe.Sizeof(c4m.Entry{}))
+		indexSlot = int64(unsafe.Sizeof("") + unsafe.Sizeof((*c4m.Entry)(nil)))
+	)
+	s := MemoryStats{
+		BaseEntries:  len(c4fs.base.Entries),
+		LayerEntries: len(c4fs.layer.Entries),
+	}
+	for _, m := range []*c4m.Manifest{c4fs.base, c4fs.layer} {
+		for _, e := range m.Entries {
+			s.NameBytes += int64(len(e.Name) + len(e.Target))
+		}
+	}
+	s.EntryBytes = int64(s.BaseEntries+s.LayerEntries) * entrySize
+	s.IndexBytes = int64(len(c4fs.layerIndex)) * indexSlot
+	switch idx := c4fs.baseIndex.(type) {
+	case *lazyIndex:
+		_, held := idx.cached()
+		s.IndexBytes += int64(idx.len())*int64(unsafe.Sizeof(int32(0))) + int64(len(idx.dirs)+held)*indexSlot
+	case *trieIndex:
+		s.IndexBytes += int64(c4fs.baseIndex.len()) * int64(unsafe.Sizeof((*c4m.Entry)(nil)))
+	default:
+		s.IndexBytes += int64(c4fs.baseIndex.len()) * indexSlot
+	}
+	return s
+}
@@ -0,0 +1,99 @@
+package c4fs
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Avalanche-io/c4/store"
+)
+
+func TestEntryArena(t *testing.T) {
+	var a entryArena
+	var names []string
+	for i := range 20000 {
+		names = append(names, a.concat("some/long/directory/prefix/", fmt.Sprintf("file%05d.txt", i)))
+	}
+	long := strings.Repeat("x", arenaNameBytes)
+	if got := a.concat("dir/", long); got != "dir/"+long {
+		t.Error("long name corrupted")
+	}
+	for i, name := range names {
+		if want := fmt.Sprintf("some/long/directory/prefix/file%05d.txt", i); name != want {
+			t.Fatalf("name %d = %q, want %q", i, name, want)
+		}
+	}
+
+	seen := make(map[any]bool)
+	for range 3 * arenaEntries {
+		e := a.newEntry()
+		if e.Name != "" || e.Size != 0 {
+			t.Fatal("arena entry not zeroed")
+		}
+		if seen[e] {
+			t.Fatal("arena returned an entry twice")
+		}
+		seen[e] = true
+	}
+}
+
+func TestOpenSnapshotEntryArena(t *testing.T) {
+	adapter := NewStoreAdapter(store.NewRAM())
+	src := New(adapter)
+	for d := range 7 {
+		src.Mkdir(fmt.Sprintf("dir%d", d), 0755)
+	}
+	for i := range 3000 {
+		src.WriteFile(fmt.Sprintf("dir%d/file%d.txt", i%7, i), []byte(fmt.Sprint(i)), 0644)
+	}
+	src.Symlink("dir0/file0.txt", "link")
+
+	var buf bytes.Buffer
+	if err := src.SaveSnapshot(&buf, SnapshotBinary); err != nil {
+		t.Fatalf("SaveSnapshot failed: %v", err)
+	}
+	fsys, err := OpenSnapshot(&buf, adapter, WithEntryArena())
+	if err != nil {
+		t.Fatalf("OpenSnapshot failed: %v", err)
+	}
+	if fsys.arena == nil {
+		t.Fatal("OpenSnapshot ignored WithEntryArena")
+	}
+
+	var want, got bytes.Buffer
+	WriteSnapshot(&want, src.Flatten(), SnapshotText)
+	WriteSnapshot(&got, fsys.Flatten(), SnapshotText)
+	if want.String() != got.String() {
+		t.Error("snapshot loaded with an arena differs from the original")
+	}
+
+	// Renames allocate from the arena too
+	if err := fsys.Rename("dir3", "moved"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	data, err := fsys.ReadFile("moved/file3.txt")
+	if err != nil || string(data) != "3" {
+		t.Errorf("ReadFile(moved/file3.txt) = %q, %v", data, err)
+	}
+	if fsys.Exists("dir3/file3.txt") {
+		t.Error("old path still exists after Rename")
+	}
+}
+
+func TestMemoryStats(t *testing.T) {
+	fsys := New(NewStoreAdapter(store.NewRAM()))
+	fsys.WriteFile("a.txt", []byte("a"), 0644)
+	fsys.WriteFile("dir/b.txt", []byte("b"), 0644)
+
+	s := fsys.MemoryStats()
+	if s.BaseEntries != 0 || s.LayerEntries != 2 {
+		t.Errorf("entries = %d base, %d layer; want 0, 2", s.BaseEntries, s.LayerEntries)
+	}
+	if want := int64(len("a.txt") + len("dir/b.txt")); s.NameBytes != want {
+		t.Errorf("NameBytes = %d, want %d", s.NameBytes, want)
+	}
+	if s.EntryBytes == 0 || s.IndexBytes == 0 || s.Total() <= s.NameBytes {
+		t.Errorf("implausible stats %+v", s)
+	}
+}
@@ -0,0 +1,113 @@
+package c4fs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/c4m"
+)
+
+// Operations recorded in audit records.
+const (
+	AuditRemove    = "remove"
+	AuditRemoveAll = "removeall"
+	AuditGC        = "gc"
+	AuditPurge     = "purge" // Removal purged from the trash
+)
+
+// AuditRecord describes a destructive operation: who did what to which
+// path, and the IDs of the content that was removed with it.
+type AuditRecord struct {
+	Time  time.Time `json:"time"`
+	Actor string    `json:"actor,omitempty"`
+	Op    string    `json:"op"`
+	Path  string    `json:"path"`          // Path removed, or namespace collected
+	IDs   []c4.ID   `json:"ids,omitempty"` // Content removed, sorted
+}
+
+// AuditSink receives audit records. Records are delivered after the
+// operation has taken effect and outside the filesystem's locks, so a
+// sink may write to the filesystem it audits, for example through an
+// AppendLog.
+type AuditSink interface {
+	Audit(AuditRecord) error
+}
+
+// AuditFunc adapts a function to an AuditSink.
+type AuditFunc func(AuditRecord) error
+
+// Audit calls f(r).
+func (f AuditFunc) Audit(r AuditRecord) error {
+	return f(r)
+}
+
+// NewAuditWriter returns a sink that writes each record to w as a line
+// of JSON. Writes are serialized; w should be opened for appending.
+func NewAuditWriter(w io.Writer) AuditSink {
+	var mu sync.Mutex
+	return AuditFunc(func(r AuditRecord) error {
+		line, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		_, err = w.Write(append(line, '\n'))
+		return err
+	})
+}
+
+// Audit makes l an AuditSink, appending each record as JSON.
+func (l *AppendLog) Audit(r AuditRecord) error {
+	record, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return l.Append(record)
+}
+
+// WithAudit records every Remove and RemoveAll in sink, naming actor as
+// the one who made them. Clones share the sink.
+func WithAudit(sink AuditSink, actor string) Option {
+	return func(o *options) {
+		o.audit = sink
+		o.auditActor = actor
+	}
+}
+
+// audit delivers a record to the filesystem's sink, if it has one. The
+// caller must not hold c4fs.mu. An error means the operation took
+// effect but was not recorded.
+func (c4fs *FS) audit(op, name string, ids []c4.ID) error {
+	if c4fs.auditSink == nil {
+		return nil
+	}
+	return auditTo(c4fs.auditSink, c4fs.auditActor, op, name, ids)
+}
+
+func auditTo(sink AuditSink, actor, op, name string, ids []c4.ID) error {
+	sortIDs(ids)
+	err := sink.Audit(AuditRecord{
+		Time:  time.Now().UTC(),
+		Actor: actor,
+		Op:    op,
+		Path:  name,
+		IDs:   ids,
+	})
+	if err != nil {
+		return fmt.Errorf("audit %s %s: %w", op, name, err)
+	}
+	return nil
+}
+
+// contentID returns the ID of the content e holds, if any.
+func contentID(e *c4m.Entry) (c4.ID, bool) {
+	if e.IsDir() || e.Size <= 0 || e.C4ID.IsNil() {
+		return c4.ID{}, false
+	}
+	return e.C4ID, true
+}
@@ -0,0 +1,129 @@
+package c4fs
+
+import (
+	"bytes"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/store"
+)
+
+func TestAudit(t *testing.T) {
+	var records []AuditRecord
+	sink := AuditFunc(func(r AuditRecord) error {
+		records = append(records, r)
+		return nil
+	})
+	c4fs := New(NewStoreAdapter(store.NewRAM()), WithAudit(sink, "alice"))
+	c4fs.MkdirAll("shots/010", 0755)
+	c4fs.WriteFile("shots/010/a.exr", []byte("frame a"), 0644)
+	c4fs.WriteFile("shots/010/b.exr", []byte("frame b"), 0644)
+	c4fs.WriteFile("notes.txt", []byte("notes"), 0644)
+
+	if err := c4fs.Remove("notes.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c4fs.RemoveAll("shots"); err != nil {
+		t.Fatal(err)
+	}
+	// Removing nothing is not recorded
+	if err := c4fs.RemoveAll("missing"); err != nil {
+		t.Fatal(err)
+	}
+	c4fs.Remove("missing")
+
+	if len(records) != 2 {
+		t.Fatalf("got %d records, want 2: %+v", len(records), records)
+	}
+	notes := c4.Identify(strings.NewReader("notes"))
+	if r := records[0]; r.Op != AuditRemove || r.Path != "notes.txt" || r.Actor != "alice" ||
+		r.Time.IsZero() || !slices.Equal(r.IDs, []c4.ID{notes}) {
+		t.Errorf("Remove record = %+v", r)
+	}
+	frames := []c4.ID{c4.Identify(strings.NewReader("frame a")), c4.Identify(strings.NewReader("frame b"))}
+	sortIDs(frames)
+	if r := records[1]; r.Op != AuditRemoveAll || r.Path != "shots" || !slices.Equal(r.IDs, frames) {
+		t.Errorf("RemoveAll record = %+v", r)
+	}
+
+	// Clones share the sink
+	clone := c4fs.Clone()
+	clone.WriteFile("x", []byte("x"), 0644)
+	clone.Remove("x")
+	if len(records) != 3 {
+		t.Errorf("clone removal not recorded")
+	}
+}
+
+func TestAuditWriter(t *testing.T) {
+	var buf bytes.Buffer
+	c4fs := New(NewStoreAdapter(store.NewRAM()), WithAudit(NewAuditWriter(&buf), "bob"))
+	c4fs.WriteFile("a.txt", []byte("a"), 0644)
+	if err := c4fs.Remove("a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	var r AuditRecord
+	if err := json.Unmarshal(buf.Bytes(), &r); err != nil {
+		t.Fatalf("record %q: %v", buf.String(), err)
+	}
+	if r.Actor != "bob" || r.Path != "a.txt" || len(r.IDs) != 1 {
+		t.Errorf("record = %+v", r)
+	}
+
+	// An append log, here in a filesystem of its own on the same store
+	logs := New(c4fs.Store())
+	log, err := OpenAppendLog(logs, "audit", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c4fs = New(c4fs.Store(), WithAudit(log, "bob"))
+	c4fs.WriteFile("b.txt", []byte("b"), 0644)
+	if err := c4fs.Remove("b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for rec, err := range log.Records() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(rec), `"path":"b.txt"`) {
+			t.Errorf("log record = %s", rec)
+		}
+		n++
+	}
+	if n != 1 {
+		t.Errorf("log has %d records, want 1", n)
+	}
+}
+
+func TestNamespaceGCAudit(t *testing.T) {
+	ns, err := NewNamespaces(store.NewRAM(), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []AuditRecord
+	ns.SetAudit(AuditFunc(func(r AuditRecord) error {
+		records = append(records, r)
+		return nil
+	}), "gc-job")
+	s, _ := ns.Store("proj")
+	a := NewStoreAdapter(s)
+	keep, _ := a.Put(strings.NewReader("keep"))
+	drop, _ := a.Put(strings.NewReader("drop"))
+
+	if _, err := s.GC(map[c4.ID]bool{keep: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GC(map[c4.ID]bool{keep: true}); err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("got %d records, want 1", len(records))
+	}
+	if r := records[0]; r.Op != AuditGC || r.Path != "proj" || r.Actor != "gc-job" || !slices.Equal(r.IDs, []c4.ID{drop}) {
+		t.Errorf("GC record = %+v", r)
+	}
+}
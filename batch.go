@@ -0,0 +1,20 @@
+package c4fs
+
+// Batch runs fn, which makes a group of changes that must be seen whole,
+// such as writing a file and the index that lists it. Snapshots taken
+// with Flatten, Entries or SaveSnapshot, and the services built on them,
+// wait for the batches in progress to finish, and batches starting while
+// a snapshot is taken wait for it, so a snapshot holds either all of a
+// batch's changes or none of them. Batch returns fn's error; changes
+// made before it are kept.
+//
+// Unlike Sandbox, a batch changes the filesystem directly: readers other
+// than snapshots see its changes as they are made, and batches run
+// concurrently with each other and with writes made outside batches,
+// which snapshots do not wait for. Within fn, do not take a snapshot or
+// start another batch, as they would wait for fn forever.
+func (c4fs *FS) Batch(fn func() error) error {
+	c4fs.epoch.RLock()
+	defer c4fs.epoch.RUnlock()
+	return fn()
+}
@@ -0,0 +1,84 @@
+package c4fs
+
+import (
+	"fmt"
+	"path"
+	"sync"
+	"testing"
+
+	"github.com/Avalanche-io/c4/store"
+)
+
+func TestBatchSnapshotConsistency(t *testing.T) {
+	c4fs := New(NewStoreAdapter(newSyncRAM()))
+	c4fs.MkdirAll("data", 0755)
+	c4fs.MkdirAll("index", 0755)
+
+	// Each batch writes a file and its index entry; snapshots must never
+	// hold one without the other
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				name := fmt.Sprintf("%d-%d", w, i)
+				err := c4fs.Batch(func() error {
+					if err := c4fs.WriteFile("data/"+name, []byte(name), 0644); err != nil {
+						return err
+					}
+					return c4fs.WriteFile("index/"+name, []byte(name), 0644)
+				})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	for snapshots := 0; ; snapshots++ {
+		select {
+		case <-done:
+			if snapshots == 0 {
+				t.Log("No snapshot taken while batches ran")
+			}
+			return
+		default:
+		}
+		names := make(map[string]int)
+		for _, e := range c4fs.Flatten().Entries {
+			if !e.IsDir() {
+				names[path.Base(e.Name)]++
+			}
+		}
+		for name, n := range names {
+			if n != 2 {
+				t.Fatalf("Snapshot %d holds part of batch %s", snapshots, name)
+			}
+		}
+	}
+}
+
+func TestBatchSandbox(t *testing.T) {
+	c4fs := New(NewStoreAdapter(store.NewRAM()))
+	c4fs.WriteFile("a.txt", []byte("a"), 0644)
+
+	// A sandbox inside a batch does not wait for the batch
+	err := c4fs.Batch(func() error {
+		return c4fs.Sandbox(func(scratch *FS) error {
+			return scratch.WriteFile("b.txt", []byte("b"), 0644)
+		})
+	})
+	if err != nil {
+		t.Fatalf("Batch failed: %v", err)
+	}
+	if data, err := c4fs.ReadFile("b.txt"); err != nil || string(data) != "b" {
+		t.Errorf("ReadFile(b.txt) = %q, %v", data, err)
+	}
+}
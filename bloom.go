@@ -0,0 +1,97 @@
+package c4fs
+
+import (
+	"hash/maphash"
+	"math/bits"
+
+	"github.com/Avalanche-io/c4/c4m"
+)
+
+// bloomBitsPerPath and bloomHashes size the bloom filter for a false
+// positive rate of about 1%.
+const (
+	bloomBitsPerPath = 10
+	bloomHashes      = 7
+)
+
+// WithBloomFilter keeps a bloom filter over the paths of the base
+// manifest, so that lookups of paths the base does not have, common in
+// overlay lookups and build tools probing for files, usually skip the base
+// index. It costs about 1.25 bytes per base entry. It helps most with the
+// trie and lazy indexes, whose lookups cost more than a map probe; with
+// WithLazyIndex it also keeps misses from indexing their directories.
+// Clones share the filter, and bases swapped in by a Mirror get their own.
+func WithBloomFilter() Option {
+	return func(o *options) {
+		o.bloom = true
+	}
+}
+
+// bloomFilter is a set of paths that may report paths not added to it
+// but never misses one that was.
+type bloomFilter struct {
+	seed maphash.Seed
+	bits []uint64
+	mask uint64 // len(bits)*64 - 1; the length is a power of two
+}
+
+// newBloomFilter returns a filter holding the paths of m.
+func newBloomFilter(m *c4m.Manifest) *bloomFilter {
+	n := uint64(max(len(m.Entries), 1)) * bloomBitsPerPath
+	size := uint64(1) << bits.Len64(n-1)
+	f := &bloomFilter{
+		seed: maphash.MakeSeed(),
+		bits: make([]uint64, max(size/64, 1)),
+	}
+	f.mask = uint64(len(f.bits))*64 - 1
+	for _, e := range m.Entries {
+		f.add(e.Name)
+	}
+	return f
+}
+
+func (f *bloomFilter) add(name string) {
+	h := maphash.String(f.seed, name)
+	h1, h2 := h, h>>32|1
+	for range bloomHashes {
+		i := h1 & f.mask
+		f.bits[i/64] |= 1 << (i % 64)
+		h1 += h2
+	}
+}
+
+// mayHave reports whether name may have been added. A nil filter may
+// have every path.
+func (f *bloomFilter) mayHave(name string) bool {
+	if f == nil {
+		return true
+	}
+	h := maphash.String(f.seed, name)
+	h1, h2 := h, h>>32|1
+	for range bloomHashes {
+		i := h1 & f.mask
+		if f.bits[i/64]&(1<<(i%64)) == 0 {
+			return false
+		}
+		h1 += h2
+	}
+	return true
+}
+
+// baseEntry returns the base entry at name, consulting the base index
+// only if the bloom filter, if any, does not rule name out.
+func (c4fs *FS) baseEntry(name string) (*c4m.Entry, bool) {
+	if !c4fs.baseFilter.mayHave(name) {
+		return nil, false
+	}
+	return c4fs.baseIndex.get(name)
+}
+
+// newBaseFilter returns a bloom filter over base if the filesystem keeps
+// one, or nil.
+func (c4fs *FS) newBaseFilter(base *c4m.Manifest) *bloomFilter {
+	if c4fs.baseFilter == nil {
+		return nil
+	}
+	return newBloomFilter(base)
+}
@@ -0,0 +1,76 @@
+package c4fs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"testing"
+
+	"github.com/Avalanche-io/c4/store"
+)
+
+func TestBloomFilter(t *testing.T) {
+	m := randomTree(1, 5000)
+	f := newBloomFilter(m)
+	for _, e := range m.Entries {
+		if !f.mayHave(e.Name) {
+			t.Fatalf("mayHave(%q) = false for an added path", e.Name)
+		}
+	}
+	index := buildIndex(m)
+	var misses, positives int
+	for i := range 10000 {
+		name := fmt.Sprintf("n%d/missing%d", i%100, i)
+		if _, ok := index[name]; ok {
+			continue
+		}
+		misses++
+		if f.mayHave(name) {
+			positives++
+		}
+	}
+	if rate := float64(positives) / float64(misses); rate > 0.03 {
+		t.Errorf("False positive rate %.3f, want about 0.01", rate)
+	}
+}
+
+func TestWithBloomFilter(t *testing.T) {
+	src := New(NewStoreAdapter(store.NewRAM()))
+	src.MkdirAll("a/b", 0755)
+	src.WriteFile("a/b/file.txt", []byte("data"), 0644)
+
+	var buf bytes.Buffer
+	if err := WriteSnapshot(&buf, src.Flatten(), SnapshotBinary); err != nil {
+		t.Fatal(err)
+	}
+	opened, err := OpenSnapshot(&buf, src.store, WithBloomFilter())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fsys := range []*FS{New(src.store, WithBase(src.Flatten()), WithBloomFilter()), opened} {
+		if fsys.baseFilter == nil {
+			t.Fatal("WithBloomFilter did not keep a filter")
+		}
+		for _, name := range []string{"a", "a/b", "a/b/file.txt"} {
+			if _, err := fsys.Stat(name); err != nil {
+				t.Errorf("Stat(%s) failed: %v", name, err)
+			}
+		}
+		if _, err := fsys.Stat("a/b/missing.txt"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Stat of a missing path = %v, want ErrNotExist", err)
+		}
+		if err := fsys.Mkdir("a/b", 0755); !errors.Is(err, fs.ErrExist) {
+			t.Errorf("Mkdir of a base directory = %v, want ErrExist", err)
+		}
+
+		// Paths added to the layer are found whatever the filter says
+		fsys.WriteFile("a/b/new.txt", []byte("new"), 0644)
+		if _, err := fsys.Stat("a/b/new.txt"); err != nil {
+			t.Errorf("Stat of a layer file failed: %v", err)
+		}
+		if fsys.Clone().baseFilter != fsys.baseFilter {
+			t.Error("Clone does not share the filter")
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package c4fs
+
+import (
+	"errors"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/c4m"
+)
+
+// buildCacheRefs is the reference namespace used by the build cache.
+const buildCacheRefs = "build"
+
+// CacheBuild records that building the input tree produced output.
+// Both manifests are stored in the registry; the returned ID is the
+// output's SnapshotID.
+func (r *Registry) CacheBuild(input, output *c4m.Manifest) (c4.ID, error) {
+	outID, err := r.Put(output)
+	if err != nil {
+		return c4.ID{}, err
+	}
+	return outID, r.CacheResult(SnapshotID(input), outID)
+}
+
+// CacheResult records that the input snapshot built into the output
+// snapshot. The output must already be stored in the registry.
+func (r *Registry) CacheResult(input, output c4.ID) error {
+	return r.SetRef(buildCacheRefs+"/"+input.String(), output)
+}
+
+// LookupResult returns the output snapshot ID cached for an input
+// snapshot ID, and whether there was one.
+func (r *Registry) LookupResult(input c4.ID) (c4.ID, bool, error) {
+	id, err := r.Ref(buildCacheRefs + "/" + input.String())
+	if errors.Is(err, ErrRefNotFound) {
+		return c4.ID{}, false, nil
+	}
+	if err != nil {
+		return c4.ID{}, false, err
+	}
+	return id, true, nil
+}
+
+// LookupBuild returns the cached output manifest for an input tree, or
+// nil if that exact tree has not been built. Inputs are matched by
+// SnapshotID, so entry order and path spelling do not matter.
+func (r *Registry) LookupBuild(input *c4m.Manifest) (*c4m.Manifest, error) {
+	id, ok, err := r.LookupResult(SnapshotID(input))
+	if err != nil || !ok {
+		return nil, err
+	}
+	return r.Get(id)
+}
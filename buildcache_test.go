@@ -0,0 +1,51 @@
+package c4fs
+
+import (
+	"testing"
+
+	"github.com/Avalanche-io/c4/c4m"
+)
+
+func TestBuildCache(t *testing.T) {
+	r := newTestRegistry(t)
+
+	src := New(r.Store())
+	src.WriteFile("main.c", []byte("int main() { return 0; }"), 0644)
+	src.WriteFile("util.h", []byte("#pragma once"), 0644)
+	input := src.Flatten()
+
+	if out, err := r.LookupBuild(input); err != nil || out != nil {
+		t.Fatalf("Expected cache miss, got %v, %v", out, err)
+	}
+
+	build := New(r.Store())
+	build.WriteFile("a.out", []byte("binary"), 0755)
+	outID, err := r.CacheBuild(input, build.Flatten())
+	if err != nil {
+		t.Fatalf("CacheBuild failed: %v", err)
+	}
+
+	// The same tree described in a different order still hits
+	reordered := c4m.NewManifest()
+	for i := len(input.Entries) - 1; i >= 0; i-- {
+		reordered.AddEntry(input.Entries[i])
+	}
+	out, err := r.LookupBuild(reordered)
+	if err != nil {
+		t.Fatalf("LookupBuild failed: %v", err)
+	}
+	if out == nil || SnapshotID(out) != outID {
+		t.Fatal("Expected cached output manifest")
+	}
+
+	cached := New(r.Store(), WithBase(out))
+	if data, err := cached.ReadFile("a.out"); err != nil || string(data) != "binary" {
+		t.Errorf("Cached output: got %q, %v", data, err)
+	}
+
+	// A changed input misses
+	src.WriteFile("main.c", []byte("int main() { return 1; }"), 0644)
+	if out, _ := r.LookupBuild(src.Flatten()); out != nil {
+		t.Error("Changed input should not hit the cache")
+	}
+}
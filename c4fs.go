@@ -2,29 +2,77 @@ package c4fs
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
+	"iter"
+	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/Avalanche-io/c4"
 	"github.com/Avalanche-io/c4/c4m"
 )
 
+var (
+	_ fs.FS         = (*FS)(nil)
+	_ fs.ReadDirFS  = (*FS)(nil)
+	_ fs.ReadFileFS = (*FS)(nil)
+	_ fs.StatFS     = (*FS)(nil)
+	_ fs.GlobFS     = (*FS)(nil)
+	_ fs.SubFS      = (*FS)(nil)
+
+	_ fs.ReadDirFS  = (*subFS)(nil)
+	_ fs.ReadFileFS = (*subFS)(nil)
+	_ fs.StatFS     = (*subFS)(nil)
+	_ fs.GlobFS     = (*subFS)(nil)
+	_ fs.SubFS      = (*subFS)(nil)
+)
+
 // FS implements a content-addressable filesystem using C4 IDs.
 // It uses a copy-on-write architecture with an immutable base manifest
 // and a mutable layer manifest for changes.
 type FS struct {
-	mu         sync.RWMutex
-	base       *c4m.Manifest           // Immutable base (snapshot)
-	layer      *c4m.Manifest           // Mutable overlay (starts empty)
-	store      *StoreAdapter           // Content storage
-	baseIndex  map[string]*c4m.Entry   // Index for fast base lookups
-	layerIndex map[string]*c4m.Entry   // Index for fast layer lookups
+	mu          sync.RWMutex
+	base        *c4m.Manifest         // Immutable base (snapshot)
+	layer       *c4m.Manifest         // Mutable overlay (starts empty)
+	store       *StoreAdapter         // Content storage
+	baseIndex   pathIndex             // Index for fast base lookups
+	layerIndex  map[string]*c4m.Entry // Index for fast layer lookups
+	layerDirs   map[string][]string   // Names in the layer by parent directory
+	hydration   *hydrationLimiter     // Limits open files; nil if unlimited
+	watchers    map[*watcher]struct{} // Change subscriptions
+	dehydration *dehydrationQueue     // Background uploads; nil if synchronous
+	arena       *entryArena           // Bulk entry allocation; nil if disabled
+	root        *c4m.Entry            // Root directory metadata, replaced on change
+	cwd         string                // Working directory, absolute; "" for the root
+	layerFile   string                // Where Shutdown saves the layer; "" if not saved
+	closed      atomic.Bool           // Set by Shutdown
+	handles     *handleTracker        // Open files; nil if not tracked
+	meta        map[string]metaEntry  // Metadata c4m entries cannot hold
+	nextLink    uint64                // Last link or asset group used
+	auditSink   AuditSink             // Records removals; nil if not audited
+	auditActor  string                // Actor named in audit records
+	trash       bool                  // Removals move to the trash
+	trashAge    time.Duration         // How long removals stay in the trash; 0 if until emptied
+	history     SnapshotHistory       // Past snapshots; nil if none
+	staleGuard  bool                  // Writable files fail on Close if changed since open
+	epoch       sync.RWMutex          // Held for reading by batches, for writing by snapshots
+	proxies     *Registry             // Records proxies; nil if none are made
+	extractors  map[string]Extractor  // Proxy extractors by kind
+	sparse      *sparseFilter         // Selects the base entries shown; nil if all
+	baseFilter  *bloomFilter          // Paths the base may have; nil if not kept
+	slowAfter   time.Duration         // Operations this slow are reported
+	slowReport  func(SlowOp)          // Reports slow operations; nil if not reported
+	temps       map[string]bool       // Scratch paths made by CreateTemp
 }
 
 // cleanPath normalizes a path using forward slashes for internal storage.
@@ -39,6 +87,21 @@ func cleanPath(p string) string {
 	return p
 }
 
+// buildLayerDirs indexes the names of a layer manifest by parent
+// directory.
+func buildLayerDirs(layer *c4m.Manifest) map[string][]string {
+	dirs := make(map[string][]string)
+	seen := make(map[string]bool, len(layer.Entries))
+	for _, e := range layer.Entries {
+		if !seen[e.Name] {
+			seen[e.Name] = true
+			dir := parentPath(e.Name)
+			dirs[dir] = append(dirs[dir], e.Name)
+		}
+	}
+	return dirs
+}
+
 // buildIndex creates a path -> entry index from a manifest for O(1) lookups.
 func buildIndex(manifest *c4m.Manifest) map[string]*c4m.Entry {
 	index := make(map[string]*c4m.Entry, len(manifest.Entries))
@@ -48,38 +111,79 @@ func buildIndex(manifest *c4m.Manifest) map[string]*c4m.Entry {
 	return index
 }
 
-// New creates a new C4FS filesystem.
-// If base is nil, an empty manifest is created.
-func New(base *c4m.Manifest, store *StoreAdapter) *FS {
-	if base == nil {
-		base = c4m.NewManifest()
+// New creates a new C4FS filesystem backed by store.
+// Without options the filesystem starts with an empty base and layer.
+func New(store *StoreAdapter, opts ...Option) *FS {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
 	}
 
-	return &FS{
-		base:       base,
-		layer:      c4m.NewManifest(),
-		store:      store,
-		baseIndex:  buildIndex(base),
-		layerIndex: make(map[string]*c4m.Entry),
-	}
-}
-
-// NewWithLayer creates a new C4FS filesystem with an existing layer.
-func NewWithLayer(base, layer *c4m.Manifest, store *StoreAdapter) *FS {
+	base, layer := o.base, o.layer
 	if base == nil {
 		base = c4m.NewManifest()
 	}
+	sparse := newSparseFilter(o.sparse)
+	base = implicitDirs(sparse.apply(base))
 	if layer == nil {
 		layer = c4m.NewManifest()
 	}
 
-	return &FS{
+	c4fs := &FS{
 		base:       base,
 		layer:      layer,
 		store:      store,
-		baseIndex:  buildIndex(base),
 		layerIndex: buildIndex(layer),
+		layerDirs:  buildLayerDirs(layer),
+		root:       newRootEntry(o),
+		layerFile:  o.layerFile,
+		auditSink:  o.audit,
+		auditActor: o.auditActor,
+		trash:      o.trash,
+		trashAge:   o.trashRetention,
+		history:    o.history,
+		staleGuard: o.staleGuard,
+		proxies:    o.proxies,
+		extractors: o.extractors,
+		sparse:     sparse,
+		slowAfter:  o.slowThreshold,
+		slowReport: o.slowReport,
+	}
+	if o.lazyIndex {
+		c4fs.baseIndex = newLazyIndex(base, o.lazyBudget)
+	} else if o.trieIndex {
+		c4fs.baseIndex = newTrieIndex(base)
+	} else {
+		c4fs.baseIndex = mapIndex(buildIndex(base))
+	}
+	if o.bloom {
+		c4fs.baseFilter = newBloomFilter(base)
 	}
+	if o.hydrationLimit > 0 {
+		c4fs.hydration = newHydrationLimiter(o.hydrationLimit)
+	}
+	if o.arena {
+		c4fs.arena = &entryArena{}
+	}
+	if o.trackHandles {
+		c4fs.handles = &handleTracker{stacks: o.handleStacks, open: make(map[uint64]HandleInfo)}
+	}
+	if o.staging != nil {
+		c4fs.dehydration = newDehydrationQueue(o.staging, store)
+		c4fs.dehydration.journalPath = o.journal
+		c4fs.store = NewStoreAdapter(c4fs.dehydration)
+	}
+	if o.base != nil {
+		c4fs.loadBaseMeta(o.base)
+	}
+	return c4fs
+}
+
+// NewWithLayer creates a new C4FS filesystem with an existing layer.
+//
+// Deprecated: use New with WithBase and WithLayer.
+func NewWithLayer(base, layer *c4m.Manifest, store *StoreAdapter) *FS {
+	return New(store, WithBase(base), WithLayer(layer))
 }
 
 // getEntry looks up an entry in the filesystem.
@@ -88,7 +192,11 @@ func NewWithLayer(base, layer *c4m.Manifest, store *StoreAdapter) *FS {
 func (c4fs *FS) getEntry(p string) (*c4m.Entry, error) {
 	c4fs.mu.RLock()
 	defer c4fs.mu.RUnlock()
+	return c4fs.lookup(p)
+}
 
+// lookup is getEntry for callers that already hold c4fs.mu.
+func (c4fs *FS) lookup(p string) (*c4m.Entry, error) {
 	// Normalize path using forward slashes
 	p = cleanPath(p)
 	if p == "/" {
@@ -97,13 +205,7 @@ func (c4fs *FS) getEntry(p string) (*c4m.Entry, error) {
 
 	// Special case: root directory always exists as a virtual directory
 	if p == "" {
-		return &c4m.Entry{
-			Mode:      fs.ModeDir | 0755,
-			Timestamp: time.Now().UTC(),
-			Size:      0,
-			Name:      "",
-			C4ID:      c4.ID{},
-		}, nil
+		return c4fs.root, nil
 	}
 
 	// Check layer first using index for O(1) lookup
@@ -120,7 +222,7 @@ func (c4fs *FS) getEntry(p string) (*c4m.Entry, error) {
 	}
 
 	// Fall back to base using index for O(1) lookup
-	if entry, exists := c4fs.baseIndex[p]; exists {
+	if entry, exists := c4fs.baseEntry(p); exists {
 		return entry, nil
 	}
 
@@ -133,7 +235,9 @@ func (c4fs *FS) getEntry(p string) (*c4m.Entry, error) {
 
 // Stat returns file information for the given path.
 // Unlike Lstat, this follows symbolic links.
-func (c4fs *FS) Stat(name string) (fs.FileInfo, error) {
+func (c4fs *FS) Stat(name string) (_ fs.FileInfo, err error) {
+	defer c4fs.startOp("stat", name).done(-1, &err)
+
 	// Resolve symlinks (max depth 40, same as Linux)
 	entry, err := c4fs.resolveSymlink(name, 40)
 	if err != nil {
@@ -146,31 +250,29 @@ func (c4fs *FS) Stat(name string) (fs.FileInfo, error) {
 		mode:    entry.Mode,
 		modTime: entry.Timestamp,
 		isDir:   entry.IsDir(),
+		entry:   entry,
 	}, nil
 }
 
 // Open opens the named file for reading.
 // This follows symbolic links.
+//
+// Every Open returns an independent handle with its own position, so any
+// number of handles on the same path, or on files sharing content in the
+// store, can read and seek concurrently without affecting one another. A
+// single handle is not safe for concurrent Read and Seek, but its ReadAt
+// is, so io.NewSectionReader over one handle gives any number of
+// independent readers. Regular file handles also have a
+// Dup() (File, error) method that forks a handle at its current offset.
 func (c4fs *FS) Open(name string) (fs.File, error) {
-	// Resolve symlinks (max depth 40)
-	entry, err := c4fs.resolveSymlink(name, 40)
-	if err != nil {
-		return nil, err
-	}
-
-	if entry.IsDir() {
-		// For directories, use the resolved path
-		return c4fs.openDir(entry.Name, entry)
-	}
-
-	return c4fs.openFile(name, entry)
+	return c4fs.OpenContext(context.Background(), name)
 }
 
 // OpenFile opens a file with the specified flags and permissions.
 // This is required by the absfs.Filer interface.
 func (c4fs *FS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
 	// For read-only access, use Open
-	if flag == 0 || flag == 1 { // O_RDONLY
+	if flag&(os.O_WRONLY|os.O_RDWR) == 0 {
 		f, err := c4fs.Open(name)
 		if err != nil {
 			return nil, err
@@ -186,13 +288,58 @@ func (c4fs *FS) OpenFile(name string, flag int, perm fs.FileMode) (File, error)
 		}
 	}
 
+	// Appends must not truncate the file
+	if flag&os.O_APPEND != 0 {
+		return c4fs.openAppend(name, flag, perm)
+	}
+
 	// For write operations, use Create
 	// Note: This is a simplified implementation that doesn't handle all flag combinations
 	return c4fs.Create(name)
 }
 
+// openAppend opens a file for appending. A file's content is a single
+// blob, so appending to content would store all of it again; only empty
+// and missing files can be appended to, and appending to others fails
+// with errors.ErrUnsupported. Logs should use AppendLog instead. If
+// nothing is written before Close, the entry is left untouched.
+func (c4fs *FS) openAppend(name string, flag int, perm fs.FileMode) (File, error) {
+	entry, err := c4fs.resolveSymlink(name, 40)
+	if err != nil {
+		if isPathErrorWithNotExist(err) && flag&os.O_CREATE != 0 {
+			return newDehydratingFile(c4fs, name, perm)
+		}
+		return nil, err
+	}
+	if entry.IsDir() {
+		return nil, &fs.PathError{
+			Op:   "open",
+			Path: name,
+			Err:  syscall.EISDIR,
+		}
+	}
+	if isSpecial(entry.Mode) {
+		return nil, specialError("open", name)
+	}
+	if entry.Size > 0 {
+		return nil, &fs.PathError{
+			Op:   "open",
+			Path: name,
+			Err:  fmt.Errorf("appending to a file with content: %w", errors.ErrUnsupported),
+		}
+	}
+
+	f, err := newDehydratingFile(c4fs, entry.Name, entry.Mode)
+	if err != nil {
+		return nil, err
+	}
+	f.appending = true
+	return f, nil
+}
+
 // openFile opens a regular file for reading (hydration).
-func (c4fs *FS) openFile(name string, entry *c4m.Entry) (fs.File, error) {
+// release is called when the file is closed.
+func (c4fs *FS) openFile(name string, entry *c4m.Entry, release func()) (fs.File, error) {
 	// Get content from store
 	rc, err := c4fs.store.Get(entry.C4ID)
 	if err != nil {
@@ -209,13 +356,30 @@ func (c4fs *FS) openFile(name string, entry *c4m.Entry) (fs.File, error) {
 		mode:    entry.Mode,
 		modTime: entry.Timestamp,
 		isDir:   false,
+		entry:   entry,
 	}
 
-	return &readOnlyFile{
+	f := &readOnlyFile{
 		ReadCloser: rc,
 		info:       info,
 		pos:        0,
-	}, nil
+		openRange: func(off, length int64) (io.ReadCloser, error) {
+			return c4fs.store.GetRange(entry.C4ID, off, length)
+		},
+		release: release,
+		closed:  c4fs.isClosed,
+		track: func() func() {
+			return c4fs.trackHandle(name, false)
+		},
+	}
+	// A reader that also seeks, such as a Folder store's *os.File, is kept
+	// for the life of the handle, so it can serve ReadAt concurrently
+	if ra, ok := rc.(io.ReaderAt); ok {
+		if _, ok := rc.(io.Seeker); ok {
+			f.at = ra
+		}
+	}
+	return f, nil
 }
 
 // openDir opens a directory for reading.
@@ -231,74 +395,143 @@ func (c4fs *FS) openDir(name string, entry *c4m.Entry) (fs.File, error) {
 		mode:    entry.Mode | fs.ModeDir,
 		modTime: entry.Timestamp,
 		isDir:   true,
+		entry:   entry,
 	}
 
 	return &dirFile{
 		entries: entries,
 		info:    info,
+		closed:  c4fs.isClosed,
+		release: c4fs.trackHandle(name, false),
 	}, nil
 }
 
 // readDir reads the contents of a directory.
 func (c4fs *FS) readDir(name string) ([]fs.DirEntry, error) {
+	children := c4fs.dirChildren(name)
+
+	entries := make([]fs.DirEntry, 0, len(children))
+	for _, e := range children {
+		entries = append(entries, &dirEntry{
+			info: &fileInfo{
+				name:    path.Base(e.Name),
+				size:    e.Size,
+				mode:    e.Mode,
+				modTime: e.Timestamp,
+				isDir:   e.IsDir(),
+				entry:   e,
+			},
+		})
+	}
+
+	// fs.ReadDirFS requires entries sorted by filename
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+// dirChildren returns the manifest entries that are direct children of
+// the named directory, with layer entries shadowing base entries and
+// tombstoned paths omitted.
+func (c4fs *FS) dirChildren(name string) []*c4m.Entry {
 	c4fs.mu.RLock()
 	defer c4fs.mu.RUnlock()
+	return c4fs.children(name)
+}
 
+// children is dirChildren for callers that already hold c4fs.mu.
+func (c4fs *FS) children(name string) []*c4m.Entry {
 	// Normalize path using forward slashes
 	name = cleanPath(name)
 	if name == "/" {
 		name = ""
 	}
 
-	// Collect entries from both layer and base
-	seen := make(map[string]bool)
-	tombstones := make(map[string]bool)
-	var entries []fs.DirEntry
+	// Layer entries first, then base entries the layer does not shadow
+	var entries []*c4m.Entry
+	for _, n := range c4fs.layerDirs[name] {
+		if e := c4fs.layerIndex[n]; e.Size != -1 {
+			entries = append(entries, e)
+		}
+	}
+	for e := range c4fs.baseChildren(name) {
+		if _, shadowed := c4fs.layerIndex[e.Name]; !shadowed {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
 
-	// Add entries from layer (and track tombstones)
-	for _, e := range c4fs.layer.Entries {
-		if c4fs.isDirectChild(name, e.Name) {
-			basename := path.Base(e.Name)
-			if !seen[basename] {
-				seen[basename] = true
-				// Check for tombstone
-				if e.Size == -1 {
-					tombstones[basename] = true
-					continue
-				}
-				entries = append(entries, &dirEntry{
-					info: &fileInfo{
-						name:    basename,
-						size:    e.Size,
-						mode:    e.Mode,
-						modTime: e.Timestamp,
-						isDir:   e.IsDir(),
-					},
-				})
-			}
+// layerSubtree returns the names in the layer of dir, which must be clean
+// and not the root, and of everything below it. It visits the layer's
+// directories rather than its entries. The caller must hold c4fs.mu.
+func (c4fs *FS) layerSubtree(dir string) []string {
+	var dirs []string
+	for d := range c4fs.layerDirs {
+		if d == dir || strings.HasPrefix(d, dir+"/") {
+			dirs = append(dirs, d)
 		}
 	}
+	sort.Strings(dirs)
 
-	// Add entries from base (if not already in layer and not tombstoned)
-	for _, e := range c4fs.base.Entries {
-		if c4fs.isDirectChild(name, e.Name) {
-			basename := path.Base(e.Name)
-			if !seen[basename] && !tombstones[basename] {
-				seen[basename] = true
-				entries = append(entries, &dirEntry{
-					info: &fileInfo{
-						name:    basename,
-						size:    e.Size,
-						mode:    e.Mode,
-						modTime: e.Timestamp,
-						isDir:   e.IsDir(),
-					},
-				})
+	var names []string
+	if _, ok := c4fs.layerIndex[dir]; ok {
+		names = append(names, dir)
+	}
+	for _, d := range dirs {
+		names = append(names, c4fs.layerDirs[d]...)
+	}
+	return names
+}
+
+// ChildCount returns the number of entries directly inside the named
+// directory. Layer entries are indexed by directory, so with
+// WithTrieIndex it costs the number of children rather than a scan of
+// the manifests; without, the base is scanned.
+func (c4fs *FS) ChildCount(name string) (int, error) {
+	c4fs.mu.RLock()
+	defer c4fs.mu.RUnlock()
+
+	entry, err := c4fs.lookup(name)
+	if err != nil {
+		return 0, err
+	}
+	if !entry.IsDir() {
+		return 0, &fs.PathError{Op: "readdir", Path: name, Err: syscall.ENOTDIR}
+	}
+	return c4fs.childCount(entry.Name, false), nil
+}
+
+// isEmptyDir reports whether the directory name has no entries, stopping
+// at the first one found. The caller must hold c4fs.mu.
+func (c4fs *FS) isEmptyDir(name string) bool {
+	return c4fs.childCount(name, true) == 0
+}
+
+// childCount counts the entries directly inside the directory name, or
+// stops at the first if any is set. The caller must hold c4fs.mu.
+func (c4fs *FS) childCount(name string, any bool) int {
+	name = cleanPath(name)
+	if name == "/" {
+		name = ""
+	}
+	n := 0
+	for _, child := range c4fs.layerDirs[name] {
+		if c4fs.layerIndex[child].Size != -1 {
+			if n++; any {
+				return n
 			}
 		}
 	}
-
-	return entries, nil
+	for e := range c4fs.baseChildren(name) {
+		if _, shadowed := c4fs.layerIndex[e.Name]; !shadowed {
+			if n++; any {
+				return n
+			}
+		}
+	}
+	return n
 }
 
 // isDirectChild checks if childPath is a direct child of parentPath.
@@ -329,19 +562,113 @@ func (c4fs *FS) isDirectChild(parentPath, childPath string) bool {
 
 // ReadDir reads the directory named by dirname and returns
 // a list of directory entries.
-func (c4fs *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+func (c4fs *FS) ReadDir(name string) (_ []fs.DirEntry, err error) {
+	defer c4fs.startOp("readdir", name).done(-1, &err)
 	return c4fs.readDir(name)
 }
 
+// EntryInfo describes a directory entry together with its content identity.
+type EntryInfo struct {
+	Name    string      // Base name of the entry
+	Size    int64       // Size in bytes
+	Mode    fs.FileMode // File mode bits
+	ModTime time.Time   // Modification time
+	C4ID    c4.ID       // Content ID (zero for directories and symlinks)
+	Target  string      // Symlink target, if the entry is a symlink
+}
+
+// ReadDirEntries reads the named directory and returns its entries with
+// their C4 IDs and symlink targets, saving callers a Stat per child.
+// Symlinks among the entries are reported as links, not followed; a
+// symlink to a directory may be named. Reading a path that does not exist
+// fails with fs.ErrNotExist, and one that is not a directory with
+// syscall.ENOTDIR.
+func (c4fs *FS) ReadDirEntries(name string) ([]EntryInfo, error) {
+	dir, err := c4fs.resolveSymlink(name, 40)
+	if err != nil {
+		return nil, err
+	}
+	if !dir.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: syscall.ENOTDIR}
+	}
+	children := c4fs.dirChildren(dir.Name)
+
+	entries := make([]EntryInfo, 0, len(children))
+	for _, e := range children {
+		entries = append(entries, EntryInfo{
+			Name:    path.Base(e.Name),
+			Size:    e.Size,
+			Mode:    e.Mode,
+			ModTime: e.Timestamp,
+			C4ID:    e.C4ID,
+			Target:  e.Target,
+		})
+	}
+	return entries, nil
+}
+
 // ReadFile reads the named file and returns its contents.
+// The buffer is allocated once at the size recorded for the file.
 func (c4fs *FS) ReadFile(name string) ([]byte, error) {
+	return c4fs.ReadFileInto(name, nil)
+}
+
+// ReadFileInto reads the named file into buf and returns the contents,
+// which share buf's memory if its capacity is large enough and are newly
+// allocated otherwise. Callers reading many files in a loop can pass the
+// previous result back in to avoid an allocation per file.
+func (c4fs *FS) ReadFileInto(name string, buf []byte) (data []byte, err error) {
+	op := c4fs.startOp("readfile", name)
+	defer func() { op.done(int64(len(data)), &err) }()
+
 	f, err := c4fs.Open(name)
 	if err != nil {
 		return nil, err
 	}
 	defer f.Close()
 
-	return io.ReadAll(f)
+	var size int64
+	if info, err := f.Stat(); err == nil && info.Mode().IsRegular() {
+		size = info.Size()
+	}
+	if int64(cap(buf)) < size {
+		buf = make([]byte, size)
+	}
+	buf = buf[:size]
+
+	n, err := io.ReadFull(f, buf)
+	buf = buf[:n]
+	switch err {
+	case nil:
+	case io.EOF, io.ErrUnexpectedEOF:
+		return buf, nil
+	default:
+		return buf, err
+	}
+
+	// The content may be longer than recorded; read the rest
+	var probe [1]byte
+	n, err = io.ReadFull(f, probe[:])
+	if err == io.EOF {
+		return buf, nil
+	}
+	if err != nil {
+		return buf, err
+	}
+	buf = append(buf, probe[:n]...)
+	for {
+		if len(buf) == cap(buf) {
+			buf = append(buf, 0)[:len(buf)]
+		}
+		n, err := f.Read(buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+n]
+		if err == io.EOF {
+			return buf, nil
+		}
+		if err != nil {
+			return buf, err
+		}
+	}
 }
 
 // dirFile implements fs.File and fs.ReadDirFile for directories.
@@ -349,6 +676,8 @@ type dirFile struct {
 	entries []fs.DirEntry
 	info    *fileInfo
 	pos     int
+	closed  func() bool // reports whether the filesystem was shut down
+	release func()      // called on Close
 }
 
 func (d *dirFile) Stat() (fs.FileInfo, error) {
@@ -359,17 +688,21 @@ func (d *dirFile) Read([]byte) (int, error) {
 	return 0, &fs.PathError{
 		Op:   "read",
 		Path: d.info.name,
-		Err:  fmt.Errorf("is a directory"),
+		Err:  syscall.EISDIR,
 	}
 }
 
 func (d *dirFile) Close() error {
+	d.release()
 	return nil
 }
 
 // ReadDir reads the contents of the directory.
 // This implements fs.ReadDirFile for better compatibility.
 func (d *dirFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	if d.closed() {
+		return nil, closedError("readdir", d.info.name)
+	}
 	if n <= 0 {
 		// Return all remaining entries
 		entries := d.entries[d.pos:]
@@ -396,7 +729,7 @@ func (d *dirFile) Write(p []byte) (int, error) {
 	return 0, &fs.PathError{
 		Op:   "write",
 		Path: d.info.name,
-		Err:  fs.ErrPermission,
+		Err:  syscall.EBADF,
 	}
 }
 
@@ -404,7 +737,7 @@ func (d *dirFile) WriteAt(p []byte, off int64) (int, error) {
 	return 0, &fs.PathError{
 		Op:   "write",
 		Path: d.info.name,
-		Err:  fs.ErrPermission,
+		Err:  syscall.EBADF,
 	}
 }
 
@@ -412,7 +745,7 @@ func (d *dirFile) WriteString(s string) (int, error) {
 	return 0, &fs.PathError{
 		Op:   "write",
 		Path: d.info.name,
-		Err:  fs.ErrPermission,
+		Err:  syscall.EBADF,
 	}
 }
 
@@ -420,7 +753,7 @@ func (d *dirFile) ReadAt(p []byte, off int64) (int, error) {
 	return 0, &fs.PathError{
 		Op:   "read",
 		Path: d.info.name,
-		Err:  fmt.Errorf("is a directory"),
+		Err:  syscall.EISDIR,
 	}
 }
 
@@ -453,7 +786,7 @@ func (d *dirFile) Truncate(size int64) error {
 	return &fs.PathError{
 		Op:   "truncate",
 		Path: d.info.name,
-		Err:  fs.ErrPermission,
+		Err:  syscall.EBADF,
 	}
 }
 
@@ -473,41 +806,107 @@ func (d *dirFile) Name() string {
 	return d.info.name
 }
 
-// Flatten merges the base and layer manifests into a new manifest.
-// This creates a new snapshot of the current filesystem state.
-// Tombstones in the layer cause corresponding base entries to be excluded.
+// Flatten merges the base and layer manifests into a new manifest,
+// a snapshot of the current filesystem state. Base entries tombstoned or
+// replaced in the layer are excluded, and files made by CreateTemp are not
+// part of the snapshot. Flatten waits for batches in progress, so the
+// snapshot never holds part of a batch (see Batch); to visit the same
+// entries without building a manifest, use Entries.
+//
+// Flatten only reads the filesystem, so the manifest does not carry the
+// metadata of the entries that manifests cannot hold, such as hard links,
+// device numbers and asset groups; use Snapshot to keep it.
 func (c4fs *FS) Flatten() *c4m.Manifest {
-	c4fs.mu.RLock()
-	defer c4fs.mu.RUnlock()
-
-	result := c4m.NewManifest()
+	result, _ := c4fs.flattenMeta()
+	return result
+}
 
-	// Collect tombstones from layer
-	tombstones := make(map[string]bool)
-	for _, e := range c4fs.layer.Entries {
-		if e.Size == -1 {
-			tombstones[e.Name] = true
-		}
+// Snapshot is like Flatten, but also stores the metadata of the entries
+// in the store and references it from the manifest's Data (see
+// ManifestMeta), so that filesystems created from the manifest keep it.
+// Access times are not kept, as they would make the snapshot's ID change
+// whenever a file is read.
+func (c4fs *FS) Snapshot() (*c4m.Manifest, error) {
+	result, meta := c4fs.flattenMeta()
+	if err := SetManifestMeta(result, c4fs.store, meta); err != nil {
+		return nil, err
 	}
+	return result, nil
+}
 
-	// Add entries from base (excluding tombstoned ones)
-	for _, e := range c4fs.base.Entries {
-		if !tombstones[e.Name] {
-			result.AddEntry(e)
+// flattenMeta builds the manifest Flatten returns and collects the
+// metadata of its entries.
+func (c4fs *FS) flattenMeta() (*c4m.Manifest, map[string]EntryMeta) {
+	result := c4m.NewManifest()
+	var meta map[string]EntryMeta
+	for e := range c4fs.Entries() {
+		result.AddEntry(e)
+		if m := c4fs.entryMetaLocked(e); m != (EntryMeta{}) {
+			if meta == nil {
+				meta = make(map[string]EntryMeta)
+			}
+			meta[e.Name] = m
 		}
 	}
+	return result, meta
+}
 
-	// Add non-tombstone entries from layer
-	for _, e := range c4fs.layer.Entries {
-		if e.Size != -1 {
-			result.AddEntry(e)
-		}
+// flattenEntries builds a manifest of entries.
+func flattenEntries(entries iter.Seq[*c4m.Entry]) *c4m.Manifest {
+	result := c4m.NewManifest()
+	for e := range entries {
+		result.AddEntry(e)
 	}
-
 	return result
 }
 
+// Clone returns a new filesystem sharing this filesystem's base manifest
+// and store, with an empty layer. Changes in the current layer are not
+// carried over. The base is immutable, so it is shared rather than copied
+// and cloning is cheap regardless of its size; clones can be modified
+// concurrently and independently. A clone starts in the root directory.
+func (c4fs *FS) Clone() *FS {
+	meta, nextLink := c4fs.copyMeta()
+	return &FS{
+		base:        c4fs.base,
+		layer:       c4m.NewManifest(),
+		store:       c4fs.store,
+		baseIndex:   c4fs.baseIndex,
+		layerIndex:  make(map[string]*c4m.Entry),
+		layerDirs:   make(map[string][]string),
+		hydration:   c4fs.hydration,
+		dehydration: c4fs.dehydration,
+		arena:       c4fs.newArena(),
+		root:        c4fs.root,
+		handles:     c4fs.newHandleTracker(),
+		meta:        meta,
+		nextLink:    nextLink,
+		auditSink:   c4fs.auditSink,
+		auditActor:  c4fs.auditActor,
+		trash:       c4fs.trash,
+		trashAge:    c4fs.trashAge,
+		history:     c4fs.history,
+		staleGuard:  c4fs.staleGuard,
+		proxies:     c4fs.proxies,
+		extractors:  c4fs.extractors,
+		sparse:      c4fs.sparse,
+		baseFilter:  c4fs.baseFilter,
+		slowAfter:   c4fs.slowAfter,
+		slowReport:  c4fs.slowReport,
+	}
+}
+
+// newArena returns a new arena if the filesystem uses one, for a copy
+// that allocates independently.
+func (c4fs *FS) newArena() *entryArena {
+	if c4fs.arena == nil {
+		return nil
+	}
+	return &entryArena{}
+}
+
 // Base returns a copy of the base manifest.
+// Use BaseView to read it without copying.
 func (c4fs *FS) Base() *c4m.Manifest {
 	c4fs.mu.RLock()
 	defer c4fs.mu.RUnlock()
@@ -515,6 +914,7 @@ func (c4fs *FS) Base() *c4m.Manifest {
 }
 
 // Layer returns a copy of the layer manifest.
+// Use LayerView to read it without copying.
 func (c4fs *FS) Layer() *c4m.Manifest {
 	c4fs.mu.RLock()
 	defer c4fs.mu.RUnlock()
@@ -526,6 +926,15 @@ func (c4fs *FS) Store() *StoreAdapter {
 	return c4fs.store
 }
 
+// Ping checks that the underlying content store is available.
+// This is intended for readiness probes in services embedding c4fs.
+func (c4fs *FS) Ping(ctx context.Context) error {
+	if c4fs.store == nil {
+		return fmt.Errorf("c4fs: no store configured")
+	}
+	return c4fs.store.Ping(ctx)
+}
+
 // ReferencedIDs returns a set of all C4 IDs currently referenced by the filesystem.
 // This includes IDs from both the base and layer manifests, excluding tombstones
 // and shadowed entries. The returned map can be used for garbage collection to
@@ -566,7 +975,13 @@ func (c4fs *FS) ReferencedIDs() map[c4.ID]bool {
 
 // WriteFile writes data to the named file, creating it if necessary.
 // This is a dehydration operation: content → C4 ID → layer manifest.
-func (c4fs *FS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+func (c4fs *FS) WriteFile(name string, data []byte, perm fs.FileMode) (err error) {
+	defer c4fs.startOp("writefile", name).done(int64(len(data)), &err)
+
+	if err := c4fs.checkNotDir("open", name); err != nil {
+		return err
+	}
+
 	// Dehydrate content to store
 	id, err := c4fs.store.Put(bytes.NewReader(data))
 	if err != nil {
@@ -593,16 +1008,99 @@ func (c4fs *FS) WriteFile(name string, data []byte, perm fs.FileMode) error {
 	return nil
 }
 
-// Create creates a file for writing.
+// WriteFileExpecting writes the content of r to the named file only if it
+// hashes to expected. On mismatch nothing is stored or linked, and the
+// returned error wraps an *IDMismatchError.
+func (c4fs *FS) WriteFileExpecting(name string, r io.Reader, expected c4.ID, perm fs.FileMode) error {
+	if err := c4fs.checkNotDir("open", name); err != nil {
+		return err
+	}
+
+	// Count bytes as they are read so the entry gets the real size
+	cr := &countingReader{r: r}
+	id, err := c4fs.store.PutExpecting(cr, expected)
+	if err != nil {
+		return &fs.PathError{
+			Op:   "write",
+			Path: name,
+			Err:  err,
+		}
+	}
+
+	entry := &c4m.Entry{
+		Mode:      perm,
+		Timestamp: time.Now().UTC(),
+		Size:      cr.n,
+		Name:      cleanPath(name),
+		C4ID:      id,
+	}
+
+	c4fs.mu.Lock()
+	c4fs.updateEntryInLayer(entry)
+	c4fs.mu.Unlock()
+
+	return nil
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Create creates a file for writing. It fails with syscall.EISDIR if
+// name is a directory.
 func (c4fs *FS) Create(name string) (File, error) {
+	if err := c4fs.checkNotDir("open", name); err != nil {
+		return nil, err
+	}
 	return newDehydratingFile(c4fs, name, 0644)
 }
 
+// checkNotDir returns a syscall.EISDIR error if name is a directory,
+// which cannot be written as a file.
+func (c4fs *FS) checkNotDir(op, name string) error {
+	if entry, err := c4fs.lstatEntry(name); err == nil && entry.IsDir() {
+		return &fs.PathError{Op: op, Path: name, Err: syscall.EISDIR}
+	}
+	return nil
+}
+
+// checkCreateLocked returns the error making a file at name gets:
+// syscall.EISDIR if name is a directory, and fs.ErrNotExist or
+// syscall.ENOTDIR if its parent is missing or not a directory.
+// The caller must hold c4fs.mu.
+func (c4fs *FS) checkCreateLocked(op, name string) error {
+	if entry, err := c4fs.lookup(name); err == nil && entry.IsDir() {
+		return &fs.PathError{Op: op, Path: name, Err: syscall.EISDIR}
+	}
+	if dir := parentPath(name); dir != "" {
+		entry, err := c4fs.lookup(dir)
+		if err != nil {
+			return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		if !entry.IsDir() {
+			return &fs.PathError{Op: op, Path: name, Err: syscall.ENOTDIR}
+		}
+	}
+	return nil
+}
+
 // Mkdir creates a new directory.
 func (c4fs *FS) Mkdir(name string, perm fs.FileMode) error {
 	c4fs.mu.Lock()
 	defer c4fs.mu.Unlock()
+	return c4fs.mkdir(name, perm)
+}
 
+// mkdir is Mkdir for callers that already hold c4fs.mu for writing.
+func (c4fs *FS) mkdir(name string, perm fs.FileMode) error {
 	name = cleanPath(name)
 	if name == "/" {
 		name = ""
@@ -617,15 +1115,17 @@ func (c4fs *FS) Mkdir(name string, perm fs.FileMode) error {
 		}
 	}
 
-	// Check if already exists
-	if entry := c4fs.layer.GetEntry(name); entry != nil {
-		return &fs.PathError{
-			Op:   "mkdir",
-			Path: name,
-			Err:  fs.ErrExist,
+	// Check if already exists. A tombstone in the layer means the path
+	// was removed and may be created again.
+	if entry, exists := c4fs.layerIndex[name]; exists {
+		if entry.Size != -1 {
+			return &fs.PathError{
+				Op:   "mkdir",
+				Path: name,
+				Err:  fs.ErrExist,
+			}
 		}
-	}
-	if entry := c4fs.base.GetEntry(name); entry != nil {
+	} else if _, exists := c4fs.baseEntry(name); exists {
 		return &fs.PathError{
 			Op:   "mkdir",
 			Path: name,
@@ -646,37 +1146,52 @@ func (c4fs *FS) Mkdir(name string, perm fs.FileMode) error {
 }
 
 // MkdirAll creates a directory and all necessary parents.
+// The whole path is checked and created under a single lock, so a
+// concurrent writer cannot replace a parent between the check and the
+// creation of its children.
 func (c4fs *FS) MkdirAll(name string, perm fs.FileMode) error {
+	c4fs.mu.Lock()
+	defer c4fs.mu.Unlock()
+	return c4fs.mkdirAll(name, perm)
+}
+
+// mkdirAll is MkdirAll for callers that already hold c4fs.mu for writing.
+func (c4fs *FS) mkdirAll(name string, perm fs.FileMode) error {
 	name = cleanPath(name)
 
-	// If already exists, check if it's a directory
-	if c4fs.Exists(name) {
-		if !c4fs.IsDir(name) {
-			return &fs.PathError{
-				Op:   "mkdir",
-				Path: name,
-				Err:  fmt.Errorf("not a directory"),
+	// Collect the path and its parents, outermost first
+	var dirs []string
+	for p := name; p != "." && p != "/" && p != ""; p = path.Dir(p) {
+		dirs = append(dirs, p)
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		dir := dirs[i]
+
+		// If already exists, check if it's a directory
+		if entry, err := c4fs.lookup(dir); err == nil {
+			if !entry.IsDir() {
+				return &fs.PathError{
+					Op:   "mkdir",
+					Path: dir,
+					Err:  syscall.ENOTDIR,
+				}
 			}
+			continue
 		}
-		return nil
-	}
 
-	// Get parent directory
-	parent := path.Dir(name)
-	if parent != "." && parent != "/" && parent != "" {
-		// Recursively create parent
-		if err := c4fs.MkdirAll(parent, perm); err != nil {
+		if err := c4fs.mkdir(dir, perm); err != nil {
 			return err
 		}
 	}
-
-	// Create this directory
-	return c4fs.Mkdir(name, perm)
+	return nil
 }
 
 // Remove removes the named file or empty directory.
 // In a copy-on-write filesystem, this adds a tombstone marker to the layer.
-func (c4fs *FS) Remove(name string) error {
+func (c4fs *FS) Remove(name string) (err error) {
+	defer c4fs.startOp("remove", name).done(-1, &err)
+
 	name = cleanPath(name)
 	if name == "/" {
 		name = ""
@@ -691,30 +1206,38 @@ func (c4fs *FS) Remove(name string) error {
 		}
 	}
 
-	// Check if file exists
-	entry, err := c4fs.getEntry(name)
+	var ids []c4.ID
+	c4fs.mu.Lock()
+	if c4fs.trashing(name) {
+		ids, err = c4fs.trashLocked(name, false)
+	} else {
+		ids, err = c4fs.remove(name)
+	}
+	c4fs.mu.Unlock()
 	if err != nil {
 		return err
 	}
+	return errors.Join(c4fs.audit(AuditRemove, name, ids), c4fs.expireTrash())
+}
+
+// remove is Remove for callers that already hold c4fs.mu for writing. It
+// returns the ID of the content removed, if any.
+func (c4fs *FS) remove(name string) ([]c4.ID, error) {
+	// Check if file exists
+	entry, err := c4fs.lookup(name)
+	if err != nil {
+		return nil, err
+	}
 
 	// If it's a directory, check that it's empty
-	if entry.IsDir() {
-		entries, err := c4fs.readDir(name)
-		if err != nil {
-			return err
-		}
-		if len(entries) > 0 {
-			return &fs.PathError{
-				Op:   "remove",
-				Path: name,
-				Err:  fmt.Errorf("directory not empty"),
-			}
+	if entry.IsDir() && !c4fs.isEmptyDir(name) {
+		return nil, &fs.PathError{
+			Op:   "remove",
+			Path: name,
+			Err:  fmt.Errorf("directory not empty"),
 		}
 	}
 
-	c4fs.mu.Lock()
-	defer c4fs.mu.Unlock()
-
 	// Add tombstone marker to layer
 	// Tombstone is an entry with Size = -1
 	tombstone := &c4m.Entry{
@@ -726,44 +1249,70 @@ func (c4fs *FS) Remove(name string) error {
 	}
 
 	c4fs.updateEntryInLayer(tombstone)
-	return nil
+	if id, ok := contentID(entry); ok {
+		return []c4.ID{id}, nil
+	}
+	return nil, nil
 }
 
 // RemoveAll removes a path and any children it contains.
 // For directories, it recursively removes all contents.
-func (c4fs *FS) RemoveAll(name string) error {
+func (c4fs *FS) RemoveAll(name string) (err error) {
+	defer c4fs.startOp("removeall", name).done(-1, &err)
+
+	name = cleanPath(name)
+	if c4fs.trashing(name) {
+		c4fs.mu.Lock()
+		ids, err := c4fs.trashLocked(name, true)
+		c4fs.mu.Unlock()
+		if isPathErrorWithNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return errors.Join(c4fs.audit(AuditRemoveAll, name, ids), c4fs.expireTrash())
+	}
+
+	var ids []c4.ID
+	c4fs.mu.Lock()
+	removed, err := c4fs.removeAll(name, &ids)
+	c4fs.mu.Unlock()
+	if !removed {
+		return err
+	}
+	// A partial removal is recorded too
+	return errors.Join(err, c4fs.audit(AuditRemoveAll, name, ids))
+}
+
+// removeAll is RemoveAll for callers that already hold c4fs.mu for writing.
+// It reports whether name existed and appends the IDs of the content
+// removed to ids.
+func (c4fs *FS) removeAll(name string, ids *[]c4.ID) (bool, error) {
 	name = cleanPath(name)
 
 	// Check if exists
-	entry, err := c4fs.getEntry(name)
+	entry, err := c4fs.lookup(name)
 	if err != nil {
 		// If doesn't exist, RemoveAll succeeds (like os.RemoveAll)
 		if isPathErrorWithNotExist(err) {
-			return nil
+			return false, nil
 		}
-		return err
+		return false, err
 	}
 
-	// If it's a directory, remove all children first
+	// If it's a directory, recursively remove all children first
 	if entry.IsDir() {
-		entries, err := c4fs.readDir(name)
-		if err != nil {
-			return err
-		}
-
-		// Recursively remove all children
-		for _, e := range entries {
-			childPath := path.Join(name, e.Name())
-			if err := c4fs.RemoveAll(childPath); err != nil {
-				return err
+		for _, e := range c4fs.children(name) {
+			if _, err := c4fs.removeAll(e.Name, ids); err != nil {
+				return true, err
 			}
 		}
+	} else if id, ok := contentID(entry); ok {
+		*ids = append(*ids, id)
 	}
 
 	// Now remove the entry itself (directory is now empty)
-	c4fs.mu.Lock()
-	defer c4fs.mu.Unlock()
-
 	// Add tombstone marker to layer
 	tombstone := &c4m.Entry{
 		Mode:      0,
@@ -774,7 +1323,7 @@ func (c4fs *FS) RemoveAll(name string) error {
 	}
 
 	c4fs.updateEntryInLayer(tombstone)
-	return nil
+	return true, nil
 }
 
 // Helper function to check if error is a PathError with ErrNotExist
@@ -787,7 +1336,9 @@ func isPathErrorWithNotExist(err error) bool {
 
 // Rename renames (moves) oldpath to newpath.
 // For directories, all children are recursively renamed.
-func (c4fs *FS) Rename(oldname, newname string) error {
+func (c4fs *FS) Rename(oldname, newname string) (err error) {
+	defer c4fs.startOp("rename", oldname).done(-1, &err)
+
 	oldname = cleanPath(oldname)
 	newname = cleanPath(newname)
 	if oldname == "/" {
@@ -806,14 +1357,24 @@ func (c4fs *FS) Rename(oldname, newname string) error {
 		}
 	}
 
+	// Validate and mutate under one lock so a concurrent writer cannot
+	// create the destination or remove the source in between
+	c4fs.mu.Lock()
+	defer c4fs.mu.Unlock()
+	return c4fs.rename(oldname, newname)
+}
+
+// rename is Rename for callers that already hold c4fs.mu for writing.
+// The names must be clean and not the root.
+func (c4fs *FS) rename(oldname, newname string) error {
 	// Check source exists
-	oldEntry, err := c4fs.getEntry(oldname)
+	oldEntry, err := c4fs.lookup(oldname)
 	if err != nil {
 		return err
 	}
 
 	// Check if destination already exists
-	if c4fs.Exists(newname) {
+	if _, err := c4fs.lookup(newname); err == nil {
 		return &fs.PathError{
 			Op:   "rename",
 			Path: newname,
@@ -821,61 +1382,47 @@ func (c4fs *FS) Rename(oldname, newname string) error {
 		}
 	}
 
-	c4fs.mu.Lock()
-	defer c4fs.mu.Unlock()
-
 	// If it's a directory, we need to rename all children
 	if oldEntry.IsDir() {
 		// Get all entries that are descendants of oldname
 		var toRename []*c4m.Entry
 
-		// Check both base and layer for children
-		for _, e := range c4fs.base.Entries {
-			if e.Name == oldname || strings.HasPrefix(e.Name, oldname+"/") {
+		// Check both base and layer for children. Base entries that were
+		// removed or replaced in the layer are handled by the layer pass.
+		for e := range c4fs.baseSubtree(oldname) {
+			if _, inLayer := c4fs.layerIndex[e.Name]; !inLayer {
 				toRename = append(toRename, e)
 			}
 		}
-		for _, e := range c4fs.layer.Entries {
+		for _, name := range c4fs.layerSubtree(oldname) {
 			// Skip tombstones
-			if e.Size == -1 {
-				continue
-			}
-			if e.Name == oldname || strings.HasPrefix(e.Name, oldname+"/") {
-				// Check if already in toRename (from base)
-				found := false
-				for i, existing := range toRename {
-					if existing.Name == e.Name {
-						// Replace with layer version
-						toRename[i] = e
-						found = true
-						break
-					}
-				}
-				if !found {
-					toRename = append(toRename, e)
-				}
+			if e := c4fs.layerIndex[name]; e.Size != -1 {
+				toRename = append(toRename, e)
 			}
 		}
 
 		// Create new entries with updated paths
 		for _, e := range toRename {
-			newPath := strings.Replace(e.Name, oldname, newname, 1)
-			newEntry := &c4m.Entry{
+			newEntry := c4fs.allocEntry()
+			*newEntry = c4m.Entry{
 				Mode:      e.Mode,
 				Timestamp: e.Timestamp,
 				Size:      e.Size,
-				Name:      newPath,
+				Name:      c4fs.concatName(newname, e.Name[len(oldname):]),
 				C4ID:      e.C4ID,
 				Target:    e.Target,
 			}
 			c4fs.updateEntryInLayer(newEntry)
+			c4fs.setEntryMetaLocked(newEntry, c4fs.entryMetaLocked(e))
 		}
 
 		// Add tombstones for all old paths
+		now := time.Now().UTC()
 		for _, e := range toRename {
-			tombstone := &c4m.Entry{
+			tombstone := c4fs.allocEntry()
+			*tombstone = c4m.Entry{
 				Mode:      0,
-				Timestamp: time.Now().UTC(),
+				Timestamp: now,
 				Size:      -1,
 				Name:      e.Name,
 				C4ID:      c4.ID{},
@@ -893,6 +1440,7 @@ func (c4fs *FS) Rename(oldname, newname string) error {
 			Target:    oldEntry.Target,
 		}
 		c4fs.updateEntryInLayer(newEntry)
+		c4fs.setEntryMetaLocked(newEntry, c4fs.entryMetaLocked(oldEntry))
 
 		// Add tombstone for old name
 		tombstone := &c4m.Entry{
@@ -927,7 +1475,7 @@ func (c4fs *FS) Sub(dir string) (fs.FS, error) {
 			return nil, &fs.PathError{
 				Op:   "sub",
 				Path: dir,
-				Err:  fmt.Errorf("not a directory"),
+				Err:  syscall.ENOTDIR,
 			}
 		}
 	}
@@ -1054,6 +1602,24 @@ func (s *subFS) Sub(dir string) (fs.FS, error) {
 	return s.parent.Sub(fullPath)
 }
 
+// ReadLink reads the target of a symbolic link in the sub filesystem.
+func (s *subFS) ReadLink(name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	fullPath := path.Join(s.prefix, name)
+	return s.parent.ReadLink(fullPath)
+}
+
+// Lstat returns file information without following symlinks in the sub filesystem.
+func (s *subFS) Lstat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "lstat", Path: name, Err: fs.ErrInvalid}
+	}
+	fullPath := path.Join(s.prefix, name)
+	return s.parent.Lstat(fullPath)
+}
+
 // OpenFile opens a file in the sub filesystem.
 func (s *subFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
 	if !fs.ValidPath(name) {
@@ -1127,6 +1693,24 @@ func (s *subFS) Chown(name string, uid, gid int) error {
 func (c4fs *FS) updateEntryInLayer(entry *c4m.Entry) {
 	name := entry.Name
 
+	if len(c4fs.watchers) > 0 && !c4fs.isTemp(name) {
+		prev, _ := c4fs.lookup(name)
+		// Removing something already absent is not a change
+		if prev != nil || entry.Size != -1 {
+			defer c4fs.notify(changeEvent(prev, entry))
+		}
+	}
+	if len(c4fs.temps) > 0 {
+		c4fs.untrackTemp(entry)
+	}
+
+	// Asset groups outlive rewrites of their files
+	if m, ok := c4fs.meta[name]; ok && m.meta.Asset != 0 && entry.Size != -1 && !entry.IsDir() {
+		if prev, _ := c4fs.lookup(name); prev == m.entry {
+			defer c4fs.setEntryMetaLocked(entry, EntryMeta{Asset: m.meta.Asset, Sidecar: m.meta.Sidecar})
+		}
+	}
+
 	// Check if entry already exists in layer
 	if oldEntry, exists := c4fs.layerIndex[name]; exists {
 		// Remove old entry from manifest (linear scan, but only when updating)
@@ -1137,6 +1721,9 @@ func (c4fs *FS) updateEntryInLayer(entry *c4m.Entry) {
 			}
 		}
 		_ = oldEntry // Suppress unused warning
+	} else {
+		dir := parentPath(name)
+		c4fs.layerDirs[dir] = append(c4fs.layerDirs[dir], name)
 	}
 
 	// Add new entry to manifest
@@ -1147,12 +1734,25 @@ func (c4fs *FS) updateEntryInLayer(entry *c4m.Entry) {
 }
 
 // Chmod changes the mode of the named file in the layer.
+// Changing the root directory's mode only changes its permission bits.
 func (c4fs *FS) Chmod(name string, mode fs.FileMode) error {
+	if isRoot(name) {
+		c4fs.updateRoot(func(root *c4m.Entry) {
+			root.Mode = fs.ModeDir | mode.Perm()
+		})
+		return nil
+	}
+
 	entry, err := c4fs.getEntry(name)
 	if err != nil {
 		return err
 	}
 
+	// Special files keep their type, and devices their number
+	if isSpecial(entry.Mode) {
+		mode = entry.Mode.Type() | mode.Perm()
+	}
+
 	// Create updated entry in layer with new mode
 	newEntry := &c4m.Entry{
 		Mode:      mode,
@@ -1164,34 +1764,57 @@ func (c4fs *FS) Chmod(name string, mode fs.FileMode) error {
 	}
 
 	c4fs.mu.Lock()
+	rdev := c4fs.rdevLocked(entry)
 	c4fs.updateEntryInLayer(newEntry)
+	c4fs.setRdevLocked(newEntry, rdev)
 	c4fs.mu.Unlock()
 
 	return nil
 }
 
 // Chtimes changes the access and modification times of the named file in the layer.
+// C4M entries hold only the modification time, so the access time is kept
+// beside the entry; see Atime. As with os.Chtimes, a zero time leaves that
+// time unchanged.
 func (c4fs *FS) Chtimes(name string, atime, mtime time.Time) error {
-	entry, err := c4fs.getEntry(name)
+	c4fs.mu.Lock()
+	defer c4fs.mu.Unlock()
+
+	entry, err := c4fs.lookup(name)
 	if err != nil {
 		return err
 	}
-
-	// Create updated entry in layer with new timestamp
-	// Note: C4M only stores one timestamp, so we use mtime
-	newEntry := &c4m.Entry{
-		Mode:      entry.Mode,
-		Timestamp: mtime,
-		Size:      entry.Size,
-		Name:      cleanPath(name),
-		C4ID:      entry.C4ID,
-		Target:    entry.Target,
+	if mtime.IsZero() {
+		mtime = entry.Timestamp
+	}
+	if atime.IsZero() {
+		atime, _ = c4fs.atimeLocked(entry)
 	}
 
-	c4fs.mu.Lock()
-	c4fs.updateEntryInLayer(newEntry)
-	c4fs.mu.Unlock()
+	if isRoot(name) {
+		root := *c4fs.root
+		root.Timestamp = mtime.UTC()
+		c4fs.root = &root
+		entry = c4fs.root
+	} else {
+		// Create updated entry in layer with new timestamp, keeping its
+		// device number, hard link and asset groups
+		meta := c4fs.entryMetaLocked(entry)
+		entry = &c4m.Entry{
+			Mode:      entry.Mode,
+			Timestamp: mtime,
+			Size:      entry.Size,
+			Name:      cleanPath(name),
+			C4ID:      entry.C4ID,
+			Target:    entry.Target,
+		}
+		c4fs.updateEntryInLayer(entry)
+		c4fs.setEntryMetaLocked(entry, meta)
+	}
 
+	if !atime.IsZero() {
+		c4fs.setAtimeLocked(entry, atime)
+	}
 	return nil
 }
 
@@ -1208,12 +1831,105 @@ func (c4fs *FS) Chown(name string, uid, gid int) error {
 	return nil
 }
 
+// Truncate changes the size of the named file.
+// Shrinking only reads the bytes that are kept, as a range of the blob
+// (see StoreAdapter.GetRange), so the cost is proportional to the new
+// size rather than the old one. Growing pads the file with zeros, and
+// truncating to the current size does nothing.
+func (c4fs *FS) Truncate(name string, size int64) error {
+	if size < 0 {
+		return &fs.PathError{
+			Op:   "truncate",
+			Path: name,
+			Err:  fs.ErrInvalid,
+		}
+	}
+
+	entry, err := c4fs.resolveSymlink(name, 40)
+	if err != nil {
+		return err
+	}
+	if entry.IsDir() {
+		return &fs.PathError{
+			Op:   "truncate",
+			Path: name,
+			Err:  syscall.EISDIR,
+		}
+	}
+	if size == entry.Size {
+		return nil
+	}
+
+	var r io.Reader = bytes.NewReader(nil)
+	if keep := min(size, entry.Size); keep > 0 {
+		rc, err := c4fs.store.GetRange(entry.C4ID, 0, keep)
+		if err != nil {
+			return &fs.PathError{
+				Op:   "truncate",
+				Path: name,
+				Err:  fmt.Errorf("failed to hydrate content: %w", err),
+			}
+		}
+		defer rc.Close()
+		r = io.LimitReader(rc, keep)
+	}
+	if size > entry.Size {
+		r = io.MultiReader(r, io.LimitReader(zeroReader{}, size-entry.Size))
+	}
+
+	id, err := c4fs.store.Put(r)
+	if err != nil {
+		return &fs.PathError{
+			Op:   "truncate",
+			Path: name,
+			Err:  fmt.Errorf("failed to dehydrate content: %w", err),
+		}
+	}
+
+	newEntry := &c4m.Entry{
+		Mode:      entry.Mode,
+		Timestamp: time.Now().UTC(),
+		Size:      size,
+		Name:      entry.Name,
+		C4ID:      id,
+	}
+
+	c4fs.mu.Lock()
+	c4fs.updateEntryInLayer(newEntry)
+	c4fs.mu.Unlock()
+
+	return nil
+}
+
+// zeroReader is an io.Reader that returns an endless stream of zeros.
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
 // Exists checks if a file or directory exists.
 func (c4fs *FS) Exists(name string) bool {
 	_, err := c4fs.getEntry(name)
 	return err == nil
 }
 
+// ExistsAll reports for each of names whether it exists, as Exists does,
+// keyed by the names as given. All are checked under one read lock, so
+// the result is a consistent view and checking many paths, as sync
+// planners do, avoids locking for each.
+func (c4fs *FS) ExistsAll(names []string) map[string]bool {
+	result := make(map[string]bool, len(names))
+	c4fs.mu.RLock()
+	defer c4fs.mu.RUnlock()
+	for _, name := range names {
+		_, err := c4fs.lookup(name)
+		result[name] = err == nil
+	}
+	return result
+}
+
 // IsDir checks if the path is a directory.
 func (c4fs *FS) IsDir(name string) bool {
 	entry, err := c4fs.getEntry(name)
@@ -1312,6 +2028,7 @@ func (c4fs *FS) Lstat(name string) (fs.FileInfo, error) {
 		mode:    entry.Mode,
 		modTime: entry.Timestamp,
 		isDir:   entry.IsDir(),
+		entry:   entry,
 	}, nil
 }
 
@@ -1328,13 +2045,7 @@ func (c4fs *FS) lstatEntry(p string) (*c4m.Entry, error) {
 
 	// Special case: root directory always exists as a virtual directory
 	if p == "" {
-		return &c4m.Entry{
-			Mode:      fs.ModeDir | 0755,
-			Timestamp: time.Now().UTC(),
-			Size:      0,
-			Name:      "",
-			C4ID:      c4.ID{},
-		}, nil
+		return c4fs.root, nil
 	}
 
 	// Check layer first using index for O(1) lookup
@@ -1351,7 +2062,7 @@ func (c4fs *FS) lstatEntry(p string) (*c4m.Entry, error) {
 	}
 
 	// Fall back to base using index for O(1) lookup
-	if entry, exists := c4fs.baseIndex[p]; exists {
+	if entry, exists := c4fs.baseEntry(p); exists {
 		return entry, nil
 	}
 
@@ -1431,3 +2142,34 @@ func (c4fs *FS) resolveSymlink(p string, maxDepth int) (*c4m.Entry, error) {
 
 	return entry, nil
 }
+
+// newRootEntry returns the root directory entry configured by o.
+func newRootEntry(o options) *c4m.Entry {
+	root := &c4m.Entry{
+		Mode:      fs.ModeDir | 0755,
+		Timestamp: time.Now().UTC(),
+	}
+	if o.rootPerm != 0 {
+		root.Mode = fs.ModeDir | o.rootPerm
+	}
+	if !o.rootTime.IsZero() {
+		root.Timestamp = o.rootTime.UTC()
+	}
+	return root
+}
+
+// isRoot reports whether name refers to the root directory.
+func isRoot(name string) bool {
+	p := cleanPath(name)
+	return p == "" || p == "/"
+}
+
+// updateRoot replaces the root entry with a copy changed by fn. Entries
+// handed out by lookups are never modified.
+func (c4fs *FS) updateRoot(fn func(*c4m.Entry)) {
+	c4fs.mu.Lock()
+	defer c4fs.mu.Unlock()
+	root := *c4fs.root
+	fn(&root)
+	c4fs.root = &root
+}
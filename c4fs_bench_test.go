@@ -4,8 +4,14 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"io/fs"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/Avalanche-io/c4"
 	"github.com/Avalanche-io/c4/c4m"
 	"github.com/Avalanche-io/c4/store"
 )
@@ -69,7 +75,7 @@ func BenchmarkStoreAdapterHas(b *testing.B) {
 
 func BenchmarkWriteFile(b *testing.B) {
 	adapter := NewStoreAdapter(store.NewRAM())
-	c4fs := New(nil, adapter)
+	c4fs := New(adapter)
 	data := bytes.Repeat([]byte("test"), 256) // 1KB
 
 	b.ResetTimer()
@@ -83,7 +89,7 @@ func BenchmarkWriteFile(b *testing.B) {
 
 func BenchmarkWriteFile_1MB(b *testing.B) {
 	adapter := NewStoreAdapter(store.NewRAM())
-	c4fs := New(nil, adapter)
+	c4fs := New(adapter)
 	data := bytes.Repeat([]byte("test"), 256*1024) // 1MB
 
 	b.ResetTimer()
@@ -97,7 +103,7 @@ func BenchmarkWriteFile_1MB(b *testing.B) {
 
 func BenchmarkReadFile(b *testing.B) {
 	adapter := NewStoreAdapter(store.NewRAM())
-	c4fs := New(nil, adapter)
+	c4fs := New(adapter)
 	data := bytes.Repeat([]byte("test"), 256) // 1KB
 	c4fs.WriteFile("test.txt", data, 0644)
 
@@ -110,9 +116,43 @@ func BenchmarkReadFile(b *testing.B) {
 	}
 }
 
+func BenchmarkReadFile_1MB(b *testing.B) {
+	adapter := NewStoreAdapter(store.NewRAM())
+	c4fs := New(adapter)
+	data := bytes.Repeat([]byte("test"), 256*1024) // 1MB
+	c4fs.WriteFile("test.txt", data, 0644)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := c4fs.ReadFile("test.txt")
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReadFileInto_1MB(b *testing.B) {
+	adapter := NewStoreAdapter(store.NewRAM())
+	c4fs := New(adapter)
+	data := bytes.Repeat([]byte("test"), 256*1024) // 1MB
+	c4fs.WriteFile("test.txt", data, 0644)
+
+	var buf []byte
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var err error
+		buf, err = c4fs.ReadFileInto("test.txt", buf)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
 func BenchmarkStat(b *testing.B) {
 	adapter := NewStoreAdapter(store.NewRAM())
-	c4fs := New(nil, adapter)
+	c4fs := New(adapter)
 	c4fs.WriteFile("test.txt", []byte("content"), 0644)
 
 	b.ResetTimer()
@@ -126,7 +166,7 @@ func BenchmarkStat(b *testing.B) {
 
 func BenchmarkExists(b *testing.B) {
 	adapter := NewStoreAdapter(store.NewRAM())
-	c4fs := New(nil, adapter)
+	c4fs := New(adapter)
 	c4fs.WriteFile("test.txt", []byte("content"), 0644)
 
 	b.ResetTimer()
@@ -135,11 +175,28 @@ func BenchmarkExists(b *testing.B) {
 	}
 }
 
+func BenchmarkExistsAll_1000Paths(b *testing.B) {
+	adapter := NewStoreAdapter(store.NewRAM())
+	c4fs := New(adapter)
+	names := make([]string, 1000)
+	for i := range names {
+		names[i] = fmt.Sprintf("dir/file%d.txt", i)
+		if i%2 == 0 {
+			c4fs.WriteFile(names[i], []byte("content"), 0644)
+		}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = c4fs.ExistsAll(names)
+	}
+}
+
 // Benchmark Directory Operations
 
 func BenchmarkMkdir(b *testing.B) {
 	adapter := NewStoreAdapter(store.NewRAM())
-	c4fs := New(nil, adapter)
+	c4fs := New(adapter)
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
@@ -152,7 +209,7 @@ func BenchmarkMkdir(b *testing.B) {
 
 func BenchmarkMkdirAll(b *testing.B) {
 	adapter := NewStoreAdapter(store.NewRAM())
-	c4fs := New(nil, adapter)
+	c4fs := New(adapter)
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
@@ -165,7 +222,7 @@ func BenchmarkMkdirAll(b *testing.B) {
 
 func BenchmarkReadDir(b *testing.B) {
 	adapter := NewStoreAdapter(store.NewRAM())
-	c4fs := New(nil, adapter)
+	c4fs := New(adapter)
 
 	// Create directory with 100 files
 	c4fs.Mkdir("testdir", 0755)
@@ -184,7 +241,7 @@ func BenchmarkReadDir(b *testing.B) {
 
 func BenchmarkReadDir_1000Files(b *testing.B) {
 	adapter := NewStoreAdapter(store.NewRAM())
-	c4fs := New(nil, adapter)
+	c4fs := New(adapter)
 
 	// Create directory with 1000 files
 	c4fs.Mkdir("testdir", 0755)
@@ -205,7 +262,7 @@ func BenchmarkReadDir_1000Files(b *testing.B) {
 
 func BenchmarkFlatten(b *testing.B) {
 	adapter := NewStoreAdapter(store.NewRAM())
-	c4fs := New(nil, adapter)
+	c4fs := New(adapter)
 
 	// Create filesystem with some files
 	for i := 0; i < 100; i++ {
@@ -220,7 +277,7 @@ func BenchmarkFlatten(b *testing.B) {
 
 func BenchmarkFlatten_1000Files(b *testing.B) {
 	adapter := NewStoreAdapter(store.NewRAM())
-	c4fs := New(nil, adapter)
+	c4fs := New(adapter)
 
 	// Create filesystem with 1000 files
 	for i := 0; i < 1000; i++ {
@@ -237,7 +294,7 @@ func BenchmarkFlatten_1000Files(b *testing.B) {
 
 func BenchmarkSymlink(b *testing.B) {
 	adapter := NewStoreAdapter(store.NewRAM())
-	c4fs := New(nil, adapter)
+	c4fs := New(adapter)
 	c4fs.WriteFile("target.txt", []byte("content"), 0644)
 
 	b.ResetTimer()
@@ -251,7 +308,7 @@ func BenchmarkSymlink(b *testing.B) {
 
 func BenchmarkReadLink(b *testing.B) {
 	adapter := NewStoreAdapter(store.NewRAM())
-	c4fs := New(nil, adapter)
+	c4fs := New(adapter)
 	c4fs.WriteFile("target.txt", []byte("content"), 0644)
 	c4fs.Symlink("target.txt", "link.txt")
 
@@ -266,7 +323,7 @@ func BenchmarkReadLink(b *testing.B) {
 
 func BenchmarkResolveSymlink(b *testing.B) {
 	adapter := NewStoreAdapter(store.NewRAM())
-	c4fs := New(nil, adapter)
+	c4fs := New(adapter)
 	c4fs.WriteFile("target.txt", []byte("content"), 0644)
 	c4fs.Symlink("target.txt", "link1")
 	c4fs.Symlink("link1", "link2")
@@ -285,7 +342,7 @@ func BenchmarkResolveSymlink(b *testing.B) {
 
 func BenchmarkRemove(b *testing.B) {
 	adapter := NewStoreAdapter(store.NewRAM())
-	c4fs := New(nil, adapter)
+	c4fs := New(adapter)
 
 	// Pre-create files
 	for i := 0; i < b.N; i++ {
@@ -303,7 +360,7 @@ func BenchmarkRemove(b *testing.B) {
 
 func BenchmarkRemoveAll(b *testing.B) {
 	adapter := NewStoreAdapter(store.NewRAM())
-	c4fs := New(nil, adapter)
+	c4fs := New(adapter)
 
 	// Pre-create directory trees
 	for i := 0; i < b.N; i++ {
@@ -326,7 +383,7 @@ func BenchmarkRemoveAll(b *testing.B) {
 
 func BenchmarkRename(b *testing.B) {
 	adapter := NewStoreAdapter(store.NewRAM())
-	c4fs := New(nil, adapter)
+	c4fs := New(adapter)
 
 	// Pre-create files
 	for i := 0; i < b.N; i++ {
@@ -344,7 +401,7 @@ func BenchmarkRename(b *testing.B) {
 
 func BenchmarkRenameDirectory(b *testing.B) {
 	adapter := NewStoreAdapter(store.NewRAM())
-	c4fs := New(nil, adapter)
+	c4fs := New(adapter)
 
 	// Pre-create directories with files
 	for i := 0; i < b.N; i++ {
@@ -367,7 +424,7 @@ func BenchmarkRenameDirectory(b *testing.B) {
 
 func BenchmarkGlob(b *testing.B) {
 	adapter := NewStoreAdapter(store.NewRAM())
-	c4fs := New(nil, adapter)
+	c4fs := New(adapter)
 
 	// Create files with various extensions
 	for i := 0; i < 100; i++ {
@@ -403,7 +460,7 @@ func BenchmarkStatWithLayer(b *testing.B) {
 		})
 	}
 
-	c4fs := New(base, adapter)
+	c4fs := New(adapter, WithBase(base))
 
 	// Add 100 files to layer
 	for i := 1000; i < 1100; i++ {
@@ -436,7 +493,7 @@ func BenchmarkStatWithBase(b *testing.B) {
 		})
 	}
 
-	c4fs := New(base, adapter)
+	c4fs := New(adapter, WithBase(base))
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
@@ -452,7 +509,7 @@ func BenchmarkStatWithBase(b *testing.B) {
 
 func BenchmarkDeepDirectoryAccess(b *testing.B) {
 	adapter := NewStoreAdapter(store.NewRAM())
-	c4fs := New(nil, adapter)
+	c4fs := New(adapter)
 
 	// Create deep directory hierarchy
 	c4fs.MkdirAll("a/b/c/d/e/f/g/h/i/j", 0755)
@@ -485,7 +542,7 @@ func BenchmarkReadDir_MixedBaseAndLayer(b *testing.B) {
 		})
 	}
 
-	c4fs := New(base, adapter)
+	c4fs := New(adapter, WithBase(base))
 
 	// Add 500 files to layer
 	for i := 500; i < 1000; i++ {
@@ -500,3 +557,314 @@ func BenchmarkReadDir_MixedBaseAndLayer(b *testing.B) {
 		}
 	}
 }
+
+// Benchmark Large Base Manifests
+//
+// These run against a base of 1000 directories holding 1000 files each,
+// built once and shared, since the base is immutable. Record a baseline
+// and compare a change against it with benchstat:
+//
+//	go test -run '^$' -bench '_1M$' -benchmem -count 6 > old.txt
+//	go test -run '^$' -bench '_1M$' -benchmem -count 6 > new.txt
+//	benchstat old.txt new.txt
+
+const (
+	largeBaseDirs  = 1000
+	largeBaseFiles = 1000 // per directory
+)
+
+// largeBase returns the shared 1M-entry base manifest.
+var largeBase = sync.OnceValue(func() *c4m.Manifest {
+	id := c4.Identify(strings.NewReader("content"))
+	now := time.Now().UTC()
+	base := c4m.NewManifest()
+	base.Entries = make([]*c4m.Entry, 0, largeBaseDirs*(largeBaseFiles+1))
+	for d := range largeBaseDirs {
+		dir := fmt.Sprintf("dir%04d", d)
+		base.AddEntry(&c4m.Entry{Mode: fs.ModeDir | 0755, Timestamp: now, Name: dir})
+		for f := range largeBaseFiles {
+			base.AddEntry(&c4m.Entry{
+				Mode:      0644,
+				Timestamp: now,
+				Size:      7,
+				Name:      fmt.Sprintf("%s/file%04d.txt", dir, f),
+				C4ID:      id,
+			})
+		}
+	}
+	return base
+})
+
+// largeFS returns a filesystem over the 1M-entry base, with a layer
+// overriding every tenth file of dir0500.
+func largeFS(b *testing.B, opts ...Option) *FS {
+	if testing.Short() {
+		b.Skip("skipping 1M-entry benchmark in short mode")
+	}
+	base := largeBase()
+	b.StopTimer()
+	defer b.StartTimer()
+
+	fsys := New(NewStoreAdapter(store.NewRAM()), append(opts, WithBase(base))...)
+	for f := 0; f < largeBaseFiles; f += 10 {
+		fsys.WriteFile(fmt.Sprintf("dir0500/file%04d.txt", f), []byte("changed"), 0644)
+	}
+	return fsys
+}
+
+func BenchmarkNew_1M(b *testing.B) {
+	benchmarkNew(b)
+}
+
+func BenchmarkNewTrie_1M(b *testing.B) {
+	benchmarkNew(b, WithTrieIndex())
+}
+
+func BenchmarkNewLazy_1M(b *testing.B) {
+	benchmarkNew(b, WithLazyIndex(1<<16))
+}
+
+// benchmarkNew indexes the 1M-entry base and reports the heap the index
+// retains per entry.
+func benchmarkNew(b *testing.B, opts ...Option) {
+	base := largeBase()
+	adapter := NewStoreAdapter(store.NewRAM())
+	benchmarkRetained(b, func() any {
+		return New(adapter, append(opts, WithBase(base))...)
+	})
+}
+
+// benchmarkRetained runs build b.N times and reports the heap retained by
+// its result per entry of the 1M-entry base.
+func benchmarkRetained(b *testing.B, build func() any) {
+	if testing.Short() {
+		b.Skip("skipping 1M-entry benchmark in short mode")
+	}
+	var retained uint64
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		b.StartTimer()
+
+		v := build()
+
+		b.StopTimer()
+		runtime.GC()
+		runtime.ReadMemStats(&after)
+		retained += after.HeapAlloc - before.HeapAlloc
+		runtime.KeepAlive(v)
+		b.StartTimer()
+	}
+	entries := float64(largeBaseDirs * (largeBaseFiles + 1))
+	b.ReportMetric(float64(retained)/float64(b.N)/entries, "heap-B/entry")
+}
+
+func BenchmarkStatBase_1M(b *testing.B) {
+	fsys := largeFS(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := fsys.Stat("dir0999/file0999.txt"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkStatLayer_1M(b *testing.B) {
+	fsys := largeFS(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := fsys.Stat("dir0500/file0500.txt"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkStatMissing_1M(b *testing.B) {
+	benchmarkStatMissing(b)
+}
+
+func BenchmarkStatMissingBloom_1M(b *testing.B) {
+	benchmarkStatMissing(b, WithBloomFilter())
+}
+
+func BenchmarkStatMissingTrie_1M(b *testing.B) {
+	benchmarkStatMissing(b, WithTrieIndex())
+}
+
+func BenchmarkStatMissingTrieBloom_1M(b *testing.B) {
+	benchmarkStatMissing(b, WithTrieIndex(), WithBloomFilter())
+}
+
+// benchmarkStatMissing stats paths missing from the 1M-entry base, in
+// directories that exist.
+func benchmarkStatMissing(b *testing.B, opts ...Option) {
+	fsys := largeFS(b, opts...)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := fsys.Stat("dir0500/missing.txt"); err == nil {
+			b.Fatal("Stat of a missing file succeeded")
+		}
+	}
+}
+
+func BenchmarkReadDir_1M(b *testing.B) {
+	fsys := largeFS(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		entries, err := fsys.ReadDir("dir0999")
+		if err != nil || len(entries) != largeBaseFiles {
+			b.Fatalf("ReadDir = %d entries, %v", len(entries), err)
+		}
+	}
+}
+
+func BenchmarkReadDirLayered_1M(b *testing.B) {
+	fsys := largeFS(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		entries, err := fsys.ReadDir("dir0500")
+		if err != nil || len(entries) != largeBaseFiles {
+			b.Fatalf("ReadDir = %d entries, %v", len(entries), err)
+		}
+	}
+}
+
+func BenchmarkReadDirRoot_1M(b *testing.B) {
+	fsys := largeFS(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		entries, err := fsys.ReadDir(".")
+		if err != nil || len(entries) != largeBaseDirs {
+			b.Fatalf("ReadDir = %d entries, %v", len(entries), err)
+		}
+	}
+}
+
+func BenchmarkFlatten_1M(b *testing.B) {
+	fsys := largeFS(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		fsys.Flatten()
+	}
+}
+
+func BenchmarkRenameDir_1M(b *testing.B) {
+	fsys := largeFS(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// Rename a 1000-file directory of the base, back and forth
+		from, to := "dir0999", "renamed"
+		if i%2 == 1 {
+			from, to = to, from
+		}
+		if err := fsys.Rename(from, to); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// largeSnapshot returns the 1M-entry base as a binary snapshot.
+var largeSnapshot = sync.OnceValue(func() []byte {
+	var buf bytes.Buffer
+	New(NewStoreAdapter(store.NewRAM()), WithBase(largeBase())).SaveSnapshot(&buf, SnapshotBinary)
+	return buf.Bytes()
+})
+
+// benchmarkOpenSnapshot loads the 1M-entry snapshot and reports the heap
+// the loaded filesystem retains per entry.
+func benchmarkOpenSnapshot(b *testing.B, opts ...Option) {
+	data := largeSnapshot()
+	adapter := NewStoreAdapter(store.NewRAM())
+	benchmarkRetained(b, func() any {
+		fsys, err := OpenSnapshot(bytes.NewReader(data), adapter, opts...)
+		if err != nil {
+			b.Fatal(err)
+		}
+		return fsys
+	})
+}
+
+func BenchmarkOpenSnapshot_1M(b *testing.B) {
+	benchmarkOpenSnapshot(b)
+}
+
+func BenchmarkOpenSnapshotArena_1M(b *testing.B) {
+	benchmarkOpenSnapshot(b, WithEntryArena())
+}
+
+func BenchmarkRenameDirArena_1M(b *testing.B) {
+	fsys := largeFS(b)
+	fsys.arena = &entryArena{}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		from, to := "dir0999", "renamed"
+		if i%2 == 1 {
+			from, to = to, from
+		}
+		if err := fsys.Rename(from, to); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkStatBaseTrie_1M(b *testing.B) {
+	fsys := largeFS(b, WithTrieIndex())
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := fsys.Stat("dir0999/file0999.txt"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkStatBaseLazy_1M(b *testing.B) {
+	fsys := largeFS(b, WithLazyIndex(1<<16))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := fsys.Stat("dir0999/file0999.txt"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReadDirTrie_1M(b *testing.B) {
+	fsys := largeFS(b, WithTrieIndex())
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		entries, err := fsys.ReadDir("dir0999")
+		if err != nil || len(entries) != largeBaseFiles {
+			b.Fatalf("ReadDir = %d entries, %v", len(entries), err)
+		}
+	}
+}
+
+func BenchmarkRenameDirTrie_1M(b *testing.B) {
+	fsys := largeFS(b, WithTrieIndex())
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		from, to := "dir0999", "renamed"
+		if i%2 == 1 {
+			from, to = to, from
+		}
+		if err := fsys.Rename(from, to); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
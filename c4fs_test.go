@@ -2,12 +2,22 @@ package c4fs
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"io"
 	"io/fs"
+	"maps"
+	"os"
 	"strings"
+	"sync"
+	"syscall"
 	"testing"
+	"testing/fstest"
+	"testing/iotest"
 	"time"
 
+	"github.com/Avalanche-io/c4"
 	"github.com/Avalanche-io/c4/c4m"
 	"github.com/Avalanche-io/c4/store"
 )
@@ -54,10 +64,113 @@ func TestStoreAdapter(t *testing.T) {
 	}
 }
 
+// uploadStore counts the blobs written to a RAM store.
+type uploadStore struct {
+	*store.RAM
+	creates int
+}
+
+func (s *uploadStore) Create(id c4.ID) (io.WriteCloser, error) {
+	s.creates++
+	return s.RAM.Create(id)
+}
+
+// negotiatingStore is an uploadStore that checks and uploads in one call.
+type negotiatingStore struct {
+	uploadStore
+	calls int
+}
+
+func (s *negotiatingStore) PutIfAbsent(id c4.ID, r io.Reader) (bool, error) {
+	s.calls++
+	if _, ok := (*s.RAM)[id]; ok {
+		return false, nil
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return false, err
+	}
+	(*s.RAM)[id] = data
+	return true, nil
+}
+
+func TestStoreAdapterPutHashFirst(t *testing.T) {
+	us := &uploadStore{RAM: store.NewRAM()}
+	adapter := NewStoreAdapter(us)
+
+	small := []byte("small content")
+	large := bytes.Repeat([]byte("0123456789"), putMemoryLimit/10+100)
+	for _, content := range [][]byte{small, large} {
+		for range 2 {
+			id, err := adapter.Put(bytes.NewReader(content))
+			if err != nil {
+				t.Fatalf("Put failed: %v", err)
+			}
+			if id != c4.Identify(bytes.NewReader(content)) {
+				t.Fatalf("Put returned wrong ID for %d bytes", len(content))
+			}
+			if !bytes.Equal((*us.RAM)[id], content) {
+				t.Fatalf("stored content of %d bytes differs", len(content))
+			}
+		}
+	}
+	if us.creates != 2 {
+		t.Errorf("stored %d blobs, want 2 (repeated content must not be uploaded)", us.creates)
+	}
+}
+
+func TestStoreAdapterPutIfAbsent(t *testing.T) {
+	us := &uploadStore{RAM: store.NewRAM()}
+	adapter := NewStoreAdapter(us)
+	content := []byte("negotiated")
+	id := c4.Identify(bytes.NewReader(content))
+
+	stored, err := adapter.PutIfAbsent(id, bytes.NewReader(content))
+	if err != nil || !stored {
+		t.Fatalf("PutIfAbsent = %v, %v; want true, nil", stored, err)
+	}
+	stored, err = adapter.PutIfAbsent(id, iotest.ErrReader(errors.New("must not be read")))
+	if err != nil || stored {
+		t.Fatalf("PutIfAbsent of existing blob = %v, %v; want false, nil", stored, err)
+	}
+
+	// Content that does not match the ID is rejected and not kept
+	bad := c4.Identify(strings.NewReader("other"))
+	_, err = adapter.PutIfAbsent(bad, bytes.NewReader(content))
+	var mismatch *IDMismatchError
+	if !errors.As(err, &mismatch) || mismatch.Actual != id {
+		t.Fatalf("PutIfAbsent with wrong ID: got %v, want IDMismatchError", err)
+	}
+	if adapter.Has(bad) {
+		t.Error("mismatched content was kept")
+	}
+}
+
+func TestStoreAdapterPutIfAbsentDelegates(t *testing.T) {
+	ns := &negotiatingStore{uploadStore: uploadStore{RAM: store.NewRAM()}}
+	adapter := NewStoreAdapter(ns)
+	content := []byte("delegated")
+
+	for range 2 {
+		if _, err := adapter.Put(bytes.NewReader(content)); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	if ns.calls != 2 {
+		t.Errorf("store asked %d times, want 2", ns.calls)
+	}
+	if ns.creates != 0 {
+		t.Errorf("Create called %d times, want 0", ns.creates)
+	}
+	if len(*ns.RAM) != 1 {
+		t.Errorf("store holds %d blobs, want 1", len(*ns.RAM))
+	}
+}
+
 func TestC4FSBasicOperations(t *testing.T) {
 	// Create filesystem with RAM store
 	adapter := NewStoreAdapter(store.NewRAM())
-	c4fs := New(nil, adapter)
+	c4fs := New(adapter)
 
 	// Test WriteFile (dehydration)
 	testContent := []byte("This is a test file")
@@ -97,7 +210,7 @@ func TestC4FSBasicOperations(t *testing.T) {
 
 func TestC4FSDeduplication(t *testing.T) {
 	adapter := NewStoreAdapter(store.NewRAM())
-	c4fs := New(nil, adapter)
+	c4fs := New(adapter)
 
 	// Write same content to different files
 	content := []byte("Duplicate content")
@@ -151,7 +264,7 @@ func TestC4FSLayering(t *testing.T) {
 	})
 
 	// Create filesystem with base
-	c4fs := New(base, adapter)
+	c4fs := New(adapter, WithBase(base))
 
 	// File from base should be readable
 	data, err := c4fs.ReadFile("base.txt")
@@ -201,7 +314,7 @@ func TestC4FSLayering(t *testing.T) {
 
 func TestC4FSOpen(t *testing.T) {
 	adapter := NewStoreAdapter(store.NewRAM())
-	c4fs := New(nil, adapter)
+	c4fs := New(adapter)
 
 	// Write test file
 	content := []byte("Test file for Open")
@@ -240,7 +353,7 @@ func TestC4FSOpen(t *testing.T) {
 
 func TestC4FSNonExistentFile(t *testing.T) {
 	adapter := NewStoreAdapter(store.NewRAM())
-	c4fs := New(nil, adapter)
+	c4fs := New(adapter)
 
 	// Try to read non-existent file
 	_, err := c4fs.ReadFile("nonexistent.txt")
@@ -256,7 +369,7 @@ func TestC4FSNonExistentFile(t *testing.T) {
 
 func TestC4FSCreate(t *testing.T) {
 	adapter := NewStoreAdapter(store.NewRAM())
-	c4fs := New(nil, adapter)
+	c4fs := New(adapter)
 
 	// Create file for writing
 	f, err := c4fs.Create("created.txt")
@@ -290,7 +403,7 @@ func TestC4FSCreate(t *testing.T) {
 
 func TestC4FSMkdir(t *testing.T) {
 	adapter := NewStoreAdapter(store.NewRAM())
-	c4fs := New(nil, adapter)
+	c4fs := New(adapter)
 
 	// Create directory
 	err := c4fs.Mkdir("testdir", 0755)
@@ -317,7 +430,7 @@ func TestC4FSMkdir(t *testing.T) {
 
 func TestC4FSGlob(t *testing.T) {
 	adapter := NewStoreAdapter(store.NewRAM())
-	c4fs := New(nil, adapter)
+	c4fs := New(adapter)
 
 	// Create test files
 	c4fs.WriteFile("test1.txt", []byte("test"), 0644)
@@ -348,7 +461,7 @@ func TestC4FSGlob(t *testing.T) {
 
 func TestC4FSSub(t *testing.T) {
 	adapter := NewStoreAdapter(store.NewRAM())
-	c4fs := New(nil, adapter)
+	c4fs := New(adapter)
 
 	// Create directory structure
 	c4fs.Mkdir("docs", 0755)
@@ -391,7 +504,7 @@ func TestC4FSSub(t *testing.T) {
 
 func TestC4FSReadDirFile(t *testing.T) {
 	adapter := NewStoreAdapter(store.NewRAM())
-	c4fs := New(nil, adapter)
+	c4fs := New(adapter)
 
 	// Create directory with files
 	c4fs.Mkdir("testdir", 0755)
@@ -439,7 +552,7 @@ func TestC4FSReadDirFile(t *testing.T) {
 
 func TestC4FSInterfaceCompliance(t *testing.T) {
 	adapter := NewStoreAdapter(store.NewRAM())
-	c4fs := New(nil, adapter)
+	c4fs := New(adapter)
 
 	// Verify c4fs implements standard interfaces
 	var _ fs.FS = c4fs
@@ -452,9 +565,27 @@ func TestC4FSInterfaceCompliance(t *testing.T) {
 	t.Log("FS implements all standard fs interfaces")
 }
 
+// TestC4FSFSTest runs the standard library's fstest conformance checks
+// against a validated (Sub) view of the filesystem.
+func TestC4FSFSTest(t *testing.T) {
+	c4fs := New(NewStoreAdapter(store.NewRAM()))
+	c4fs.MkdirAll("dir/sub", 0755)
+	c4fs.WriteFile("dir/b.txt", []byte("b content"), 0644)
+	c4fs.WriteFile("dir/a.txt", []byte("a content"), 0644)
+	c4fs.WriteFile("top.txt", []byte("top"), 0644)
+
+	sub, err := c4fs.Sub(".")
+	if err != nil {
+		t.Fatalf("Sub failed: %v", err)
+	}
+	if err := fstest.TestFS(sub, "dir/a.txt", "dir/b.txt", "dir/sub", "top.txt"); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestC4FSUtilityMethods(t *testing.T) {
 	adapter := NewStoreAdapter(store.NewRAM())
-	c4fs := New(nil, adapter)
+	c4fs := New(adapter)
 
 	// Test Exists with non-existent file
 	if c4fs.Exists("nonexistent.txt") {
@@ -469,6 +600,12 @@ func TestC4FSUtilityMethods(t *testing.T) {
 		t.Error("Exists should return true for existing file")
 	}
 
+	// Test ExistsAll, keyed by the names as given
+	want := map[string]bool{"test.txt": true, "nonexistent.txt": false, "": true}
+	if got := c4fs.ExistsAll([]string{"test.txt", "nonexistent.txt", ""}); !maps.Equal(got, want) {
+		t.Errorf("ExistsAll = %v, want %v", got, want)
+	}
+
 	// Test IsFile
 	if !c4fs.IsFile("test.txt") {
 		t.Error("IsFile should return true for regular file")
@@ -504,7 +641,7 @@ func TestC4FSUtilityMethods(t *testing.T) {
 
 func TestC4FSChmod(t *testing.T) {
 	adapter := NewStoreAdapter(store.NewRAM())
-	c4fs := New(nil, adapter)
+	c4fs := New(adapter)
 
 	// Create a file
 	c4fs.WriteFile("test.txt", []byte("content"), 0644)
@@ -530,7 +667,7 @@ func TestC4FSChmod(t *testing.T) {
 
 func TestC4FSChtimes(t *testing.T) {
 	adapter := NewStoreAdapter(store.NewRAM())
-	c4fs := New(nil, adapter)
+	c4fs := New(adapter)
 
 	// Create a file
 	c4fs.WriteFile("test.txt", []byte("content"), 0644)
@@ -560,7 +697,7 @@ func TestC4FSChtimes(t *testing.T) {
 
 func TestC4FSMkdirAll(t *testing.T) {
 	adapter := NewStoreAdapter(store.NewRAM())
-	c4fs := New(nil, adapter)
+	c4fs := New(adapter)
 
 	// Create nested directory structure
 	err := c4fs.MkdirAll("a/b/c/d", 0755)
@@ -593,7 +730,7 @@ func TestC4FSMkdirAll(t *testing.T) {
 
 func TestC4FSRemove(t *testing.T) {
 	adapter := NewStoreAdapter(store.NewRAM())
-	c4fs := New(nil, adapter)
+	c4fs := New(adapter)
 
 	// Create and remove a file
 	c4fs.WriteFile("test.txt", []byte("content"), 0644)
@@ -639,7 +776,7 @@ func TestC4FSRemove(t *testing.T) {
 
 func TestC4FSRemoveAll(t *testing.T) {
 	adapter := NewStoreAdapter(store.NewRAM())
-	c4fs := New(nil, adapter)
+	c4fs := New(adapter)
 
 	// Create nested structure
 	c4fs.MkdirAll("dir/subdir1", 0755)
@@ -684,7 +821,7 @@ func TestC4FSRemoveAll(t *testing.T) {
 
 func TestC4FSRename(t *testing.T) {
 	adapter := NewStoreAdapter(store.NewRAM())
-	c4fs := New(nil, adapter)
+	c4fs := New(adapter)
 
 	// Test file rename
 	content := []byte("test content")
@@ -777,7 +914,7 @@ func TestC4FSRemoveFromLayer(t *testing.T) {
 	})
 
 	// Create filesystem with base
-	c4fs := New(base, adapter)
+	c4fs := New(adapter, WithBase(base))
 
 	// Verify file exists
 	if !c4fs.Exists("base.txt") {
@@ -804,7 +941,7 @@ func TestC4FSRemoveFromLayer(t *testing.T) {
 
 func TestC4FSSymlink(t *testing.T) {
 	adapter := NewStoreAdapter(store.NewRAM())
-	c4fs := New(nil, adapter)
+	c4fs := New(adapter)
 
 	// Create a file
 	content := []byte("target file content")
@@ -864,7 +1001,7 @@ func TestC4FSSymlink(t *testing.T) {
 
 func TestC4FSSymlinkToDirectory(t *testing.T) {
 	adapter := NewStoreAdapter(store.NewRAM())
-	c4fs := New(nil, adapter)
+	c4fs := New(adapter)
 
 	// Create a directory with a file
 	c4fs.Mkdir("dir", 0755)
@@ -909,7 +1046,7 @@ func TestC4FSSymlinkToDirectory(t *testing.T) {
 
 func TestC4FSSymlinkRelative(t *testing.T) {
 	adapter := NewStoreAdapter(store.NewRAM())
-	c4fs := New(nil, adapter)
+	c4fs := New(adapter)
 
 	// Create directory structure
 	c4fs.MkdirAll("a/b", 0755)
@@ -943,7 +1080,7 @@ func TestC4FSSymlinkRelative(t *testing.T) {
 
 func TestC4FSSymlinkChain(t *testing.T) {
 	adapter := NewStoreAdapter(store.NewRAM())
-	c4fs := New(nil, adapter)
+	c4fs := New(adapter)
 
 	// Create file and chain of symlinks
 	c4fs.WriteFile("file.txt", []byte("content"), 0644)
@@ -983,7 +1120,7 @@ func TestC4FSSymlinkChain(t *testing.T) {
 
 func TestC4FSSymlinkBroken(t *testing.T) {
 	adapter := NewStoreAdapter(store.NewRAM())
-	c4fs := New(nil, adapter)
+	c4fs := New(adapter)
 
 	// Create symlink to non-existent file
 	err := c4fs.Symlink("nonexistent.txt", "broken.txt")
@@ -1023,7 +1160,7 @@ func TestC4FSSymlinkBroken(t *testing.T) {
 
 func TestC4FSSymlinkLoop(t *testing.T) {
 	adapter := NewStoreAdapter(store.NewRAM())
-	c4fs := New(nil, adapter)
+	c4fs := New(adapter)
 
 	// Create symlink loop
 	c4fs.Symlink("link2", "link1")
@@ -1047,7 +1184,7 @@ func TestC4FSSymlinkLoop(t *testing.T) {
 
 func TestC4FSSymlinkRemove(t *testing.T) {
 	adapter := NewStoreAdapter(store.NewRAM())
-	c4fs := New(nil, adapter)
+	c4fs := New(adapter)
 
 	// Create file and symlink
 	c4fs.WriteFile("file.txt", []byte("content"), 0644)
@@ -1080,7 +1217,7 @@ func TestC4FSSymlinkRemove(t *testing.T) {
 
 func TestC4FSSymlinkRename(t *testing.T) {
 	adapter := NewStoreAdapter(store.NewRAM())
-	c4fs := New(nil, adapter)
+	c4fs := New(adapter)
 
 	// Create file and symlink
 	c4fs.WriteFile("file.txt", []byte("content"), 0644)
@@ -1118,7 +1255,7 @@ func TestC4FSSymlinkRename(t *testing.T) {
 
 func TestC4FSReadLinkOnNonSymlink(t *testing.T) {
 	adapter := NewStoreAdapter(store.NewRAM())
-	c4fs := New(nil, adapter)
+	c4fs := New(adapter)
 
 	// Create regular file
 	c4fs.WriteFile("file.txt", []byte("content"), 0644)
@@ -1136,3 +1273,898 @@ func TestC4FSReadLinkOnNonSymlink(t *testing.T) {
 		t.Error("ReadLink on directory should fail")
 	}
 }
+
+// unhealthyStore is a store whose health check always fails.
+type unhealthyStore struct {
+	*store.RAM
+}
+
+func (unhealthyStore) Ping(ctx context.Context) error {
+	return errors.New("backend down")
+}
+
+func TestReadFileInto(t *testing.T) {
+	c4fs := New(NewStoreAdapter(store.NewRAM()))
+	c4fs.WriteFile("big.txt", []byte("a larger file"), 0644)
+	c4fs.WriteFile("small.txt", []byte("small"), 0644)
+	c4fs.WriteFile("empty.txt", nil, 0644)
+
+	buf, err := c4fs.ReadFileInto("big.txt", nil)
+	if err != nil || string(buf) != "a larger file" {
+		t.Fatalf("ReadFileInto(big.txt) = %q, %v", buf, err)
+	}
+	if cap(buf) != len(buf) {
+		t.Errorf("buffer capacity %d, want exactly %d", cap(buf), len(buf))
+	}
+
+	// A large enough buffer is reused
+	reused, err := c4fs.ReadFileInto("small.txt", buf)
+	if err != nil || string(reused) != "small" {
+		t.Fatalf("ReadFileInto(small.txt) = %q, %v", reused, err)
+	}
+	if &reused[0] != &buf[0] {
+		t.Error("buffer was not reused")
+	}
+
+	empty, err := c4fs.ReadFileInto("empty.txt", buf)
+	if err != nil || len(empty) != 0 {
+		t.Errorf("ReadFileInto(empty.txt) = %q, %v", empty, err)
+	}
+	if _, err := c4fs.ReadFileInto("missing.txt", buf); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("ReadFileInto(missing.txt) = %v, want ErrNotExist", err)
+	}
+}
+
+// TestReadFileSizeMismatch checks that ReadFile returns the stored
+// content even when the recorded size is wrong.
+func TestReadFileSizeMismatch(t *testing.T) {
+	adapter := NewStoreAdapter(store.NewRAM())
+	id, _ := adapter.Put(strings.NewReader("actual content"))
+	for _, size := range []int64{4, 100} {
+		m := c4m.NewManifest()
+		m.AddEntry(&c4m.Entry{Name: "f.txt", Mode: 0644, Size: size, C4ID: id, Timestamp: time.Now()})
+		c4fs := New(adapter, WithBase(m))
+		got, err := c4fs.ReadFile("f.txt")
+		if err != nil || string(got) != "actual content" {
+			t.Errorf("size %d: ReadFile = %q, %v", size, got, err)
+		}
+	}
+}
+
+func TestC4FSPing(t *testing.T) {
+	c4fs := New(NewStoreAdapter(store.NewRAM()))
+	if err := c4fs.Ping(context.Background()); err != nil {
+		t.Errorf("Ping on RAM store failed: %v", err)
+	}
+
+	c4fs = New(NewStoreAdapter(unhealthyStore{store.NewRAM()}))
+	if err := c4fs.Ping(context.Background()); err == nil {
+		t.Error("Ping should surface store health check failure")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := New(NewStoreAdapter(store.NewRAM())).Ping(ctx); err == nil {
+		t.Error("Ping should fail with cancelled context")
+	}
+}
+
+func TestC4FSWriteFileExpecting(t *testing.T) {
+	c4fs := New(NewStoreAdapter(store.NewRAM()))
+	content := []byte("declared content")
+	id := c4.Identify(bytes.NewReader(content))
+
+	if err := c4fs.WriteFileExpecting("ok.txt", bytes.NewReader(content), id, 0644); err != nil {
+		t.Fatalf("WriteFileExpecting failed: %v", err)
+	}
+	data, err := c4fs.ReadFile("ok.txt")
+	if err != nil || !bytes.Equal(data, content) {
+		t.Fatalf("ReadFile: got %q, %v", data, err)
+	}
+	if size, _ := c4fs.Size("ok.txt"); size != int64(len(content)) {
+		t.Errorf("Size: got %d, want %d", size, len(content))
+	}
+
+	err = c4fs.WriteFileExpecting("bad.txt", strings.NewReader("tampered"), id, 0644)
+	var mismatch *IDMismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("Expected IDMismatchError, got %v", err)
+	}
+	if mismatch.Expected != id {
+		t.Errorf("Mismatch expected ID: got %s, want %s", mismatch.Expected, id)
+	}
+	if c4fs.Exists("bad.txt") {
+		t.Error("File created despite ID mismatch")
+	}
+	if c4fs.Store().Has(mismatch.Actual) {
+		t.Error("Mismatched content was stored")
+	}
+}
+
+func TestC4FSOpenFileAppend(t *testing.T) {
+	c4fs := New(NewStoreAdapter(store.NewRAM()))
+	if err := c4fs.WriteFile("empty.log", nil, 0600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	f, err := c4fs.OpenFile("empty.log", os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile append failed: %v", err)
+	}
+	f.WriteString("line 1\n")
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if data, _ := c4fs.ReadFile("empty.log"); string(data) != "line 1\n" {
+		t.Errorf("Appended content: got %q", data)
+	}
+	info, _ := c4fs.Stat("empty.log")
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Append changed mode: got %v, want 0600", info.Mode().Perm())
+	}
+
+	// Appending to content would store all of it again, so it is refused
+	// rather than truncating the file
+	if _, err := c4fs.OpenFile("empty.log", os.O_WRONLY|os.O_APPEND, 0644); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("OpenFile append to content = %v, want ErrUnsupported", err)
+	}
+	if data, _ := c4fs.ReadFile("empty.log"); string(data) != "line 1\n" {
+		t.Errorf("Refused append changed the content: got %q", data)
+	}
+
+	// An append that writes nothing must not touch the entry
+	c4fs.WriteFile("quiet.log", nil, 0644)
+	before, _ := c4fs.Stat("quiet.log")
+	f, _ = c4fs.OpenFile("quiet.log", os.O_WRONLY|os.O_APPEND, 0644)
+	f.Close()
+	after, _ := c4fs.Stat("quiet.log")
+	if !after.ModTime().Equal(before.ModTime()) {
+		t.Error("Empty append rewrote the entry")
+	}
+
+	// O_CREATE creates a missing file
+	f, err = c4fs.OpenFile("new.log", os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile append+create failed: %v", err)
+	}
+	f.WriteString("first")
+	f.Close()
+	if data, _ := c4fs.ReadFile("new.log"); string(data) != "first" {
+		t.Errorf("Created append file: got %q", data)
+	}
+
+	// Without O_CREATE a missing file is an error
+	if _, err := c4fs.OpenFile("missing.log", os.O_WRONLY|os.O_APPEND, 0644); err == nil {
+		t.Error("Expected error appending to missing file without O_CREATE")
+	}
+}
+
+func TestC4FSTruncate(t *testing.T) {
+	c4fs := New(NewStoreAdapter(store.NewRAM()))
+	c4fs.WriteFile("file.txt", []byte("hello world"), 0644)
+
+	if err := c4fs.Truncate("file.txt", 5); err != nil {
+		t.Fatalf("Truncate shrink failed: %v", err)
+	}
+	if data, _ := c4fs.ReadFile("file.txt"); string(data) != "hello" {
+		t.Errorf("After shrink: got %q, want %q", data, "hello")
+	}
+
+	if err := c4fs.Truncate("file.txt", 8); err != nil {
+		t.Fatalf("Truncate grow failed: %v", err)
+	}
+	if data, _ := c4fs.ReadFile("file.txt"); string(data) != "hello\x00\x00\x00" {
+		t.Errorf("After grow: got %q", data)
+	}
+	if size, _ := c4fs.Size("file.txt"); size != 8 {
+		t.Errorf("Size after grow: got %d, want 8", size)
+	}
+
+	if err := c4fs.Truncate("file.txt", 0); err != nil {
+		t.Fatalf("Truncate to zero failed: %v", err)
+	}
+	if data, _ := c4fs.ReadFile("file.txt"); len(data) != 0 {
+		t.Errorf("After truncate to zero: got %q", data)
+	}
+
+	c4fs.Mkdir("dir", 0755)
+	if err := c4fs.Truncate("dir", 0); err == nil {
+		t.Error("Expected error truncating a directory")
+	}
+	if err := c4fs.Truncate("missing", 0); err == nil {
+		t.Error("Expected error truncating a missing file")
+	}
+}
+
+func TestC4FSTruncateRange(t *testing.T) {
+	rs := &rangeStore{streamStore: streamStore{store.NewRAM()}}
+	c4fs := New(NewStoreAdapter(rs))
+	c4fs.WriteFile("big.bin", bytes.Repeat([]byte("x"), 1<<16), 0644)
+
+	// A shrink reads only the kept prefix
+	if err := c4fs.Truncate("big.bin", 10); err != nil {
+		t.Fatalf("Truncate failed: %v", err)
+	}
+	if len(rs.ranges) != 1 || rs.ranges[0] != [2]int64{0, 10} {
+		t.Errorf("Truncate read ranges %v, want [[0 10]]", rs.ranges)
+	}
+
+	// Truncating to the current size does nothing
+	before, _ := c4fs.Stat("big.bin")
+	if err := c4fs.Truncate("big.bin", 10); err != nil {
+		t.Fatalf("Truncate to the same size failed: %v", err)
+	}
+	after, _ := c4fs.Stat("big.bin")
+	if len(rs.ranges) != 1 || !after.ModTime().Equal(before.ModTime()) {
+		t.Error("Truncate to the same size rewrote the file")
+	}
+}
+
+func TestC4FSReadDirEntries(t *testing.T) {
+	c4fs := New(NewStoreAdapter(store.NewRAM()))
+	content := []byte("file content")
+	c4fs.Mkdir("dir", 0755)
+	c4fs.WriteFile("dir/file.txt", content, 0644)
+	c4fs.Mkdir("dir/sub", 0755)
+	c4fs.Symlink("file.txt", "dir/link")
+
+	entries, err := c4fs.ReadDirEntries("dir")
+	if err != nil {
+		t.Fatalf("ReadDirEntries failed: %v", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 entries, got %d", len(entries))
+	}
+
+	byName := make(map[string]EntryInfo)
+	for _, e := range entries {
+		byName[e.Name] = e
+	}
+
+	file := byName["file.txt"]
+	if file.C4ID != c4.Identify(bytes.NewReader(content)) {
+		t.Errorf("file.txt C4ID mismatch: got %s", file.C4ID)
+	}
+	if file.Size != int64(len(content)) {
+		t.Errorf("file.txt size: got %d, want %d", file.Size, len(content))
+	}
+	if !byName["sub"].Mode.IsDir() {
+		t.Error("sub should be a directory")
+	}
+	if link := byName["link"]; link.Target != "file.txt" || link.Mode&fs.ModeSymlink == 0 {
+		t.Errorf("link: got target %q mode %v", link.Target, link.Mode)
+	}
+}
+
+func TestC4FSReadDirEntriesErrors(t *testing.T) {
+	c4fs := New(NewStoreAdapter(store.NewRAM()))
+	c4fs.Mkdir("dir", 0755)
+	c4fs.WriteFile("dir/file.txt", []byte("content"), 0644)
+	c4fs.Symlink("dir", "dirlink")
+
+	var pathErr *fs.PathError
+	_, err := c4fs.ReadDirEntries("missing")
+	if !errors.Is(err, fs.ErrNotExist) || !errors.As(err, &pathErr) {
+		t.Errorf("ReadDirEntries of a missing path = %v, want a *fs.PathError for fs.ErrNotExist", err)
+	}
+	_, err = c4fs.ReadDirEntries("dir/file.txt")
+	if !errors.Is(err, syscall.ENOTDIR) || !errors.As(err, &pathErr) {
+		t.Errorf("ReadDirEntries of a file = %v, want a *fs.PathError for ENOTDIR", err)
+	}
+
+	// A symlink to a directory lists the directory
+	entries, err := c4fs.ReadDirEntries("dirlink")
+	if err != nil || len(entries) != 1 || entries[0].Name != "file.txt" {
+		t.Errorf("ReadDirEntries through a symlink = %v, %v", entries, err)
+	}
+	if entries, err := c4fs.ReadDirEntries("/"); err != nil || len(entries) != 2 {
+		t.Errorf("ReadDirEntries of the root = %v, %v", entries, err)
+	}
+}
+
+func TestC4FSMkdirAfterRemove(t *testing.T) {
+	c4fs := New(NewStoreAdapter(store.NewRAM()))
+
+	if err := c4fs.Mkdir("dir", 0755); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+	if err := c4fs.Remove("dir"); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if err := c4fs.Mkdir("dir", 0755); err != nil {
+		t.Fatalf("Mkdir after Remove failed: %v", err)
+	}
+	if !c4fs.IsDir("dir") {
+		t.Error("dir should exist after being recreated")
+	}
+}
+
+func TestC4FSFlattenShadowedBase(t *testing.T) {
+	adapter := NewStoreAdapter(store.NewRAM())
+	base := New(adapter)
+	base.WriteFile("file.txt", []byte("old"), 0644)
+
+	c4fs := New(adapter, WithBase(base.Flatten()))
+	c4fs.WriteFile("file.txt", []byte("new"), 0644)
+
+	flat := c4fs.Flatten()
+	if len(flat.Entries) != 1 {
+		t.Fatalf("Expected 1 entry, got %d", len(flat.Entries))
+	}
+	if flat.Entries[0].Size != 3 || flat.Entries[0].C4ID != c4.Identify(bytes.NewReader([]byte("new"))) {
+		t.Error("Flatten should keep the layer version of a replaced base entry")
+	}
+}
+
+func TestC4FSRenameDirSkipsRemovedBaseChildren(t *testing.T) {
+	adapter := NewStoreAdapter(store.NewRAM())
+	base := New(adapter)
+	base.MkdirAll("dir/sub", 0755)
+	base.WriteFile("dir/sub/removed.txt", []byte("removed"), 0644)
+	base.WriteFile("dir/kept.txt", []byte("kept"), 0644)
+
+	c4fs := New(adapter, WithBase(base.Flatten()))
+	c4fs.Remove("dir/sub/removed.txt")
+
+	if err := c4fs.Rename("dir", "moved"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	if c4fs.Exists("moved/sub/removed.txt") {
+		t.Error("Removed base file reappeared after renaming its directory")
+	}
+	if data, err := c4fs.ReadFile("moved/kept.txt"); err != nil || string(data) != "kept" {
+		t.Errorf("moved/kept.txt: got %q, %v", data, err)
+	}
+}
+
+func TestC4FSNewOptions(t *testing.T) {
+	adapter := NewStoreAdapter(store.NewRAM())
+	orig := New(adapter)
+	orig.WriteFile("base.txt", []byte("base"), 0644)
+
+	session := New(adapter, WithBase(orig.Flatten()))
+	session.WriteFile("layer.txt", []byte("layer"), 0644)
+	session.Remove("base.txt")
+
+	// Resume the session from its saved base and layer
+	resumed := New(adapter, WithBase(session.Base()), WithLayer(session.Layer()))
+	if resumed.Exists("base.txt") {
+		t.Error("base.txt should stay removed in the resumed layer")
+	}
+	if data, err := resumed.ReadFile("layer.txt"); err != nil || string(data) != "layer" {
+		t.Errorf("layer.txt: got %q, %v", data, err)
+	}
+	if resumed.BaseView().Len() != 1 || resumed.LayerView().Len() != 2 {
+		t.Errorf("Unexpected manifest sizes: base %d, layer %d",
+			resumed.BaseView().Len(), resumed.LayerView().Len())
+	}
+}
+
+func TestC4FSClone(t *testing.T) {
+	adapter := NewStoreAdapter(store.NewRAM())
+	orig := New(adapter)
+	orig.WriteFile("shared.txt", []byte("shared"), 0644)
+
+	parent := New(adapter, WithBase(orig.Flatten()))
+	parent.WriteFile("parent-only.txt", []byte("parent"), 0644)
+
+	a, b := parent.Clone(), parent.Clone()
+	if a.Exists("parent-only.txt") {
+		t.Error("Clone should start with an empty layer")
+	}
+
+	a.WriteFile("shared.txt", []byte("changed in a"), 0644)
+	b.Remove("shared.txt")
+
+	if data, _ := a.ReadFile("shared.txt"); string(data) != "changed in a" {
+		t.Errorf("a: got %q", data)
+	}
+	if b.Exists("shared.txt") {
+		t.Error("b: shared.txt should be removed")
+	}
+	if data, _ := parent.ReadFile("shared.txt"); string(data) != "shared" {
+		t.Errorf("parent should be unaffected by clones, got %q", data)
+	}
+}
+
+// streamStore serves blobs as plain streams without seeking, like a
+// remote store.
+type streamStore struct {
+	*store.RAM
+}
+
+func (s *streamStore) Open(id c4.ID) (io.ReadCloser, error) {
+	rc, err := s.RAM.Open(id)
+	if err != nil {
+		return nil, err
+	}
+	return struct{ io.ReadCloser }{rc}, nil
+}
+
+// rangeStore is a streamStore that also serves range requests and
+// records them.
+type rangeStore struct {
+	streamStore
+	ranges [][2]int64 // requested (offset, length) pairs
+}
+
+func (s *rangeStore) OpenRange(id c4.ID, off, length int64) (io.ReadCloser, error) {
+	s.ranges = append(s.ranges, [2]int64{off, length})
+	data, ok := (*s.RAM)[id]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	return io.NopCloser(bytes.NewReader(sliceRange(data, off, length))), nil
+}
+
+func TestStoreAdapterGetRange(t *testing.T) {
+	content := []byte("0123456789abcdef")
+	folder := store.Folder(t.TempDir())
+	stores := map[string]store.Store{
+		"ram":    store.NewRAM(),
+		"folder": folder,
+		"stream": &streamStore{store.NewRAM()},
+		"range":  &rangeStore{streamStore: streamStore{store.NewRAM()}},
+	}
+
+	for name, s := range stores {
+		adapter := NewStoreAdapter(s)
+		id, err := adapter.Put(bytes.NewReader(content))
+		if err != nil {
+			t.Fatalf("%s: Put failed: %v", name, err)
+		}
+
+		for _, tc := range []struct {
+			off, length int64
+			want        string
+		}{
+			{0, -1, "0123456789abcdef"},
+			{4, 3, "456"},
+			{10, -1, "abcdef"},
+			{14, 10, "ef"},
+			{20, 5, ""},
+		} {
+			rc, err := adapter.GetRange(id, tc.off, tc.length)
+			if err != nil {
+				t.Fatalf("%s: GetRange(%d, %d) failed: %v", name, tc.off, tc.length, err)
+			}
+			got, _ := io.ReadAll(rc)
+			rc.Close()
+			if string(got) != tc.want {
+				t.Errorf("%s: GetRange(%d, %d): got %q, want %q", name, tc.off, tc.length, got, tc.want)
+			}
+		}
+	}
+}
+
+func TestStoreAdapterStat(t *testing.T) {
+	content := []byte("0123456789abcdef")
+	packs, err := NewPackStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewPackStore failed: %v", err)
+	}
+	defer packs.Close()
+	stores := map[string]store.Store{
+		"ram":       store.NewRAM(),
+		"folder":    store.Folder(t.TempDir()),
+		"stream":    &streamStore{store.NewRAM()},
+		"pack":      packs,
+		"union":     NewUnionStore(store.NewRAM(), store.NewRAM()),
+		"throttled": NewThrottledStore(store.NewRAM(), ThrottleOptions{}),
+	}
+
+	for name, s := range stores {
+		adapter := NewStoreAdapter(s)
+		id, err := adapter.Put(bytes.NewReader(content))
+		if err != nil {
+			t.Fatalf("%s: Put failed: %v", name, err)
+		}
+		info, err := adapter.Stat(id)
+		if err != nil {
+			t.Errorf("%s: Stat failed: %v", name, err)
+		} else if info.Size != int64(len(content)) {
+			t.Errorf("%s: Stat size = %d, want %d", name, info.Size, len(content))
+		}
+		if name == "folder" && info.ModTime.IsZero() {
+			t.Errorf("%s: Stat has no ModTime", name)
+		}
+
+		missing := c4.Identify(strings.NewReader("missing"))
+		if _, err := adapter.Stat(missing); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("%s: Stat of a missing blob = %v, want ErrNotExist", name, err)
+		}
+	}
+}
+
+// openCountingStore counts the blobs opened from it, and passes existence
+// checks through to the store it wraps.
+type openCountingStore struct {
+	store.Store
+	opens int
+}
+
+func (s *openCountingStore) Open(id c4.ID) (io.ReadCloser, error) {
+	s.opens++
+	return s.Store.Open(id)
+}
+
+func (s *openCountingStore) HasBlob(id c4.ID) bool {
+	return NewStoreAdapter(s.Store).Has(id)
+}
+
+func TestStoreAdapterHasWithoutOpen(t *testing.T) {
+	packs, err := NewPackStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewPackStore failed: %v", err)
+	}
+	defer packs.Close()
+	id := c4.Identify(strings.NewReader("content"))
+	missing := c4.Identify(strings.NewReader("missing"))
+
+	for name, backend := range map[string]store.Store{
+		"ram":    store.NewRAM(),
+		"folder": store.Folder(t.TempDir()),
+		"pack":   packs,
+	} {
+		NewStoreAdapter(backend).Put(strings.NewReader("content"))
+		counted := &openCountingStore{Store: backend}
+		wrapped := NewStoreAdapter(NewUnionStore(NewThrottledStore(counted, ThrottleOptions{})))
+		if !wrapped.Has(id) || wrapped.Has(missing) {
+			t.Errorf("%s: Has reported the wrong blobs", name)
+		}
+		if counted.opens != 0 {
+			t.Errorf("%s: Has opened %d blobs", name, counted.opens)
+		}
+	}
+
+	// Checking a mirror's store does not copy the blob into the cache
+	remote, cache := store.NewRAM(), store.NewRAM()
+	NewStoreAdapter(remote).Put(strings.NewReader("content"))
+	filling := NewStoreAdapter(&fillingStore{local: cache, remote: remote})
+	if !filling.Has(id) {
+		t.Error("fillingStore does not have a remote blob")
+	}
+	if len(*cache) != 0 {
+		t.Error("Has copied the blob into the cache")
+	}
+}
+
+func TestC4FSSeekUsesRange(t *testing.T) {
+	rs := &rangeStore{streamStore: streamStore{store.NewRAM()}}
+	c4fs := New(NewStoreAdapter(rs))
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	c4fs.WriteFile("big.bin", content, 0644)
+
+	f, err := c4fs.Open("big.bin")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer f.Close()
+	file := f.(File)
+
+	if _, err := file.Seek(9995, io.SeekStart); err != nil {
+		t.Fatalf("Seek failed: %v", err)
+	}
+	tail, _ := io.ReadAll(file)
+	if string(tail) != "56789" {
+		t.Errorf("Read after seek: got %q", tail)
+	}
+
+	buf := make([]byte, 4)
+	if _, err := file.ReadAt(buf, 5002); err != nil || string(buf) != "2345" {
+		t.Errorf("ReadAt: got %q, %v", buf, err)
+	}
+
+	// Seeking back within the ranged stream still works
+	if _, err := file.Seek(3, io.SeekStart); err != nil {
+		t.Fatalf("Seek back failed: %v", err)
+	}
+	if _, err := io.ReadFull(file, buf); err != nil || string(buf) != "3456" {
+		t.Errorf("Read after seeking back: got %q, %v", buf, err)
+	}
+
+	want := [][2]int64{{9995, -1}, {5002, 4}, {3, -1}}
+	if len(rs.ranges) != len(want) {
+		t.Fatalf("Requested ranges: got %v, want %v", rs.ranges, want)
+	}
+	for i := range want {
+		if rs.ranges[i] != want[i] {
+			t.Errorf("Range %d: got %v, want %v", i, rs.ranges[i], want[i])
+		}
+	}
+}
+
+func TestC4FSIndependentHandles(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	stores := map[string]store.Store{
+		"ram":    store.NewRAM(),
+		"folder": store.Folder(t.TempDir()),
+		"stream": &streamStore{store.NewRAM()},
+	}
+	for name, st := range stores {
+		t.Run(name, func(t *testing.T) {
+			c4fs := New(NewStoreAdapter(st))
+			c4fs.WriteFile("a.bin", content, 0644)
+			c4fs.WriteFile("b.bin", content, 0644)
+
+			// Handles on one path, and on another path sharing the content,
+			// each read from their own position
+			var wg sync.WaitGroup
+			errs := make(chan error, 8)
+			for i := range 8 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					f, err := c4fs.Open([]string{"a.bin", "b.bin"}[i%2])
+					if err != nil {
+						errs <- err
+						return
+					}
+					defer f.Close()
+					file := f.(File)
+					buf := make([]byte, 10)
+					for off := int64(i); off < int64(len(content))-10; off += 997 {
+						if _, err := file.Seek(off, io.SeekStart); err != nil {
+							errs <- err
+							return
+						}
+						if _, err := io.ReadFull(file, buf); err != nil || !bytes.Equal(buf, content[off:off+10]) {
+							errs <- fmt.Errorf("handle %d at %d: got %q, %v", i, off, buf, err)
+							return
+						}
+					}
+				}()
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				t.Error(err)
+			}
+
+			// ReadAt on one handle is independent of its position and of
+			// concurrent seeks and reads through it
+			f, err := c4fs.Open("a.bin")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			file := f.(File)
+			section := io.NewSectionReader(file, 5000, 10)
+			done := make(chan []byte)
+			go func() {
+				file.Seek(100, io.SeekStart)
+				data, _ := io.ReadAll(file)
+				done <- data
+			}()
+			for range 10 {
+				buf := make([]byte, 10)
+				if _, err := section.ReadAt(buf, 0); err != nil || string(buf) != "0123456789" {
+					t.Errorf("Section read: got %q, %v", buf, err)
+				}
+			}
+			if data := <-done; !bytes.Equal(data, content[100:]) {
+				t.Errorf("Concurrent Read got %d bytes, want %d", len(data), len(content)-100)
+			}
+		})
+	}
+}
+
+func TestC4FSDup(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	stores := map[string]store.Store{
+		"ram":    store.NewRAM(),
+		"folder": store.Folder(t.TempDir()),
+	}
+	for name, st := range stores {
+		t.Run(name, func(t *testing.T) {
+			c4fs := New(NewStoreAdapter(st), WithHandleTracking(false))
+			c4fs.WriteFile("big.bin", content, 0644)
+
+			f, err := c4fs.Open("big.bin")
+			if err != nil {
+				t.Fatal(err)
+			}
+			file := f.(File)
+			file.Seek(4000, io.SeekStart)
+			dup, err := f.(interface{ Dup() (File, error) }).Dup()
+			if err != nil {
+				t.Fatalf("Dup failed: %v", err)
+			}
+			if n := len(c4fs.OpenHandles()); n != 2 {
+				t.Errorf("OpenHandles after Dup = %d, want 2", n)
+			}
+
+			// The duplicate starts at the offset and then moves on its own
+			buf := make([]byte, 5)
+			if _, err := io.ReadFull(dup, buf); err != nil || string(buf) != "01234" {
+				t.Errorf("Dup read: got %q, %v", buf, err)
+			}
+			if _, err := io.ReadFull(file, buf); err != nil || string(buf) != "01234" {
+				t.Errorf("Read after Dup read: got %q, %v", buf, err)
+			}
+			if pos, _ := dup.Seek(-2, io.SeekEnd); pos != int64(len(content))-2 {
+				t.Errorf("Dup seek: got %d", pos)
+			}
+			if pos, _ := file.Seek(0, io.SeekCurrent); pos != 4005 {
+				t.Errorf("Position after Dup seek: got %d, want 4005", pos)
+			}
+
+			// The duplicate outlives the original
+			if err := f.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if rest, err := io.ReadAll(dup); err != nil || string(rest) != "89" {
+				t.Errorf("Dup read after original closed: got %q, %v", rest, err)
+			}
+			if _, err := dup.ReadAt(buf, 10); err != nil || string(buf) != "01234" {
+				t.Errorf("Dup ReadAt: got %q, %v", buf, err)
+			}
+			if err := dup.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if n := len(c4fs.OpenHandles()); n != 0 {
+				t.Errorf("OpenHandles after Close = %d, want 0", n)
+			}
+		})
+	}
+}
+
+func TestC4FSCopyFastPaths(t *testing.T) {
+	c4fs := New(NewStoreAdapter(store.Folder(t.TempDir())))
+	content := bytes.Repeat([]byte("fast path "), 10000)
+
+	w, err := c4fs.Create("copy.bin")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, ok := w.(io.ReaderFrom); !ok {
+		t.Fatal("Write handles should implement io.ReaderFrom")
+	}
+	if n, err := io.Copy(w, bytes.NewReader(content)); err != nil || n != int64(len(content)) {
+		t.Fatalf("Copy into file: got %d, %v", n, err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	r, err := c4fs.Open("copy.bin")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer r.Close()
+	if _, ok := r.(io.WriterTo); !ok {
+		t.Fatal("Read handles should implement io.WriterTo")
+	}
+
+	// Copy to a real file so the sendfile path can be taken
+	out, err := os.CreateTemp(t.TempDir(), "out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	if n, err := io.Copy(out, r); err != nil || n != int64(len(content)) {
+		t.Fatalf("Copy out of file: got %d, %v", n, err)
+	}
+	got, _ := os.ReadFile(out.Name())
+	if !bytes.Equal(got, content) {
+		t.Error("Copied content mismatch")
+	}
+	if pos, _ := r.(File).Seek(0, io.SeekCurrent); pos != int64(len(content)) {
+		t.Errorf("Position after WriteTo: got %d", pos)
+	}
+}
+
+func TestC4FSErrnoErrors(t *testing.T) {
+	c4fs := New(NewStoreAdapter(store.NewRAM()))
+	if err := c4fs.Mkdir("dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := c4fs.WriteFile("file", []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c4fs.Create("dir"); !errors.Is(err, syscall.EISDIR) {
+		t.Errorf("Create(dir) = %v, want EISDIR", err)
+	}
+	if _, err := c4fs.OpenFile("dir", os.O_WRONLY|os.O_TRUNC, 0644); !errors.Is(err, syscall.EISDIR) {
+		t.Errorf("OpenFile(dir, O_WRONLY) = %v, want EISDIR", err)
+	}
+	if _, err := c4fs.OpenFile("dir", os.O_WRONLY|os.O_APPEND, 0644); !errors.Is(err, syscall.EISDIR) {
+		t.Errorf("OpenFile(dir, O_APPEND) = %v, want EISDIR", err)
+	}
+	if err := c4fs.WriteFile("dir", nil, 0644); !errors.Is(err, syscall.EISDIR) {
+		t.Errorf("WriteFile(dir) = %v, want EISDIR", err)
+	}
+	if err := c4fs.Truncate("dir", 0); !errors.Is(err, syscall.EISDIR) {
+		t.Errorf("Truncate(dir) = %v, want EISDIR", err)
+	}
+	if info, err := c4fs.Stat("dir"); err != nil || !info.IsDir() {
+		t.Errorf("dir was replaced: %v, %v", info, err)
+	}
+	if err := c4fs.MkdirAll("file/sub", 0755); !errors.Is(err, syscall.ENOTDIR) {
+		t.Errorf("MkdirAll(file/sub) = %v, want ENOTDIR", err)
+	}
+
+	d, err := c4fs.OpenFile("dir", os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Read(make([]byte, 1)); !errors.Is(err, syscall.EISDIR) {
+		t.Errorf("Read of a directory = %v, want EISDIR", err)
+	}
+	if _, err := d.Write([]byte("x")); !errors.Is(err, syscall.EBADF) {
+		t.Errorf("Write to a directory = %v, want EBADF", err)
+	}
+	d.Close()
+
+	f, err := c4fs.OpenFile("file", os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("x")); !errors.Is(err, syscall.EBADF) {
+		t.Errorf("Write to a read-only file = %v, want EBADF", err)
+	}
+	if _, err := f.Readdirnames(-1); !errors.Is(err, syscall.ENOTDIR) {
+		t.Errorf("Readdirnames of a file = %v, want ENOTDIR", err)
+	}
+	f.Close()
+
+	w, err := c4fs.Create("new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Read(make([]byte, 1)); !errors.Is(err, syscall.EBADF) {
+		t.Errorf("Read of a write-only file = %v, want EBADF", err)
+	}
+	w.Close()
+}
+
+func TestC4FSFileInfoSys(t *testing.T) {
+	c4fs := New(NewStoreAdapter(store.NewRAM()))
+	if err := c4fs.Mkdir("dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := c4fs.WriteFile("dir/a.txt", []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := c4fs.Symlink("dir/a.txt", "link"); err != nil {
+		t.Fatal(err)
+	}
+	want := c4.Identify(strings.NewReader("hello"))
+
+	info, err := c4fs.Stat("link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, ok := info.Sys().(*c4m.Entry); !ok || e.C4ID != want {
+		t.Errorf("Stat(link).Sys() = %#v, want entry with ID %s", info.Sys(), want)
+	}
+	info, err = c4fs.Lstat("link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, ok := info.Sys().(*c4m.Entry); !ok || e.Target != "dir/a.txt" {
+		t.Errorf("Lstat(link).Sys() = %#v, want entry with target", info.Sys())
+	}
+
+	entries, err := c4fs.ReadDir("dir")
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("ReadDir = %v, %v", entries, err)
+	}
+	info, _ = entries[0].Info()
+	if e, ok := info.Sys().(*c4m.Entry); !ok || e.C4ID != want {
+		t.Errorf("ReadDir Info().Sys() = %#v", info.Sys())
+	}
+	if got, want := fmt.Sprint(entries[0]), fs.FormatDirEntry(fs.FileInfoToDirEntry(info)); got != want {
+		t.Errorf("dir entry formats as %q, want %q", got, want)
+	}
+
+	w, err := c4fs.Create("new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if info, _ := w.Stat(); info.Sys() != nil {
+		t.Errorf("Sys() of a file being written = %#v, want nil", info.Sys())
+	}
+}
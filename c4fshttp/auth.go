@@ -0,0 +1,156 @@
+package c4fshttp
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Scopes a principal may be granted.
+const (
+	ScopeRead  = "read"  // GET, HEAD and OPTIONS requests
+	ScopeWrite = "write" // All other requests
+)
+
+// Principal is an authenticated caller and the scopes it was granted.
+type Principal struct {
+	Name   string
+	Scopes []string
+}
+
+// Can reports whether p was granted scope.
+func (p *Principal) Can(scope string) bool {
+	return slices.Contains(p.Scopes, scope)
+}
+
+// ErrNoCredentials is returned by an Authenticator when the request
+// carries no credentials of the kind it checks, so that the next one may
+// be tried.
+var ErrNoCredentials = errors.New("no credentials")
+
+// Authenticator identifies the caller of a request.
+type Authenticator interface {
+	// Authenticate returns the principal making r. It returns
+	// ErrNoCredentials if r has no credentials for it, and another error
+	// if they are invalid.
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+// AuthenticatorFunc adapts a function to an Authenticator.
+type AuthenticatorFunc func(r *http.Request) (*Principal, error)
+
+// Authenticate calls f(r).
+func (f AuthenticatorFunc) Authenticate(r *http.Request) (*Principal, error) {
+	return f(r)
+}
+
+// AnyOf returns an authenticator trying each of auths in turn, for
+// servers accepting several kinds of credentials. The first that finds
+// credentials decides.
+func AnyOf(auths ...Authenticator) Authenticator {
+	return AuthenticatorFunc(func(r *http.Request) (*Principal, error) {
+		for _, a := range auths {
+			p, err := a.Authenticate(r)
+			if !errors.Is(err, ErrNoCredentials) {
+				return p, err
+			}
+		}
+		return nil, ErrNoCredentials
+	})
+}
+
+// APIKeys returns an authenticator accepting the API keys in keys, given
+// as a bearer token or in an X-API-Key header. A bearer token that is not
+// one of the keys is left to the other authenticators of AnyOf, as it may
+// be another kind of token, such as a JWT for OIDC.
+func APIKeys(keys map[string]Principal) Authenticator {
+	return AuthenticatorFunc(func(r *http.Request) (*Principal, error) {
+		key, bearer := r.Header.Get("X-API-Key"), false
+		if key == "" {
+			key, bearer = bearerToken(r), true
+		}
+		if key == "" {
+			return nil, ErrNoCredentials
+		}
+		// Compare with every key so timing does not reveal a prefix match
+		var found *Principal
+		for k, p := range keys {
+			if subtle.ConstantTimeCompare([]byte(k), []byte(key)) == 1 {
+				found = &p
+			}
+		}
+		if found == nil && bearer {
+			return nil, ErrNoCredentials
+		}
+		if found == nil {
+			return nil, errors.New("unknown API key")
+		}
+		return found, nil
+	})
+}
+
+// ClientCerts returns an authenticator for mutual TLS, mapping the
+// verified client certificate of a request to a principal with lookup.
+// The server's tls.Config must verify client certificates, with
+// ClientAuth set to tls.VerifyClientCertIfGiven or
+// tls.RequireAndVerifyClientCert; unverified certificates are ignored.
+func ClientCerts(lookup func(cert *x509.Certificate) (*Principal, bool)) Authenticator {
+	return AuthenticatorFunc(func(r *http.Request) (*Principal, error) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			return nil, ErrNoCredentials
+		}
+		p, ok := lookup(r.TLS.VerifiedChains[0][0])
+		if !ok {
+			return nil, errors.New("unknown client certificate")
+		}
+		return p, nil
+	})
+}
+
+// bearerToken returns the bearer token of r, or "".
+func bearerToken(r *http.Request) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+type principalKey struct{}
+
+// PrincipalFrom returns the principal RequireAuth authenticated for the
+// request with context ctx.
+func PrincipalFrom(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok
+}
+
+// RequireAuth wraps next so that only requests auth authenticates reach
+// it, and only with the scope their method needs: ScopeRead for GET,
+// HEAD and OPTIONS and ScopeWrite for the rest. Other requests fail with
+// 401 Unauthorized or 403 Forbidden. The principal is available to next
+// through PrincipalFrom.
+func RequireAuth(auth Authenticator, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, err := auth.Authenticate(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="c4fs"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		scope := ScopeWrite
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			scope = ScopeRead
+		}
+		if !p.Can(scope) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+	})
+}
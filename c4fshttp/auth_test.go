@@ -0,0 +1,187 @@
+package c4fshttp
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRequireAuth(t *testing.T) {
+	auth := APIKeys(map[string]Principal{
+		"reader-key": {Name: "reader", Scopes: []string{ScopeRead}},
+		"writer-key": {Name: "writer", Scopes: []string{ScopeRead, ScopeWrite}},
+	})
+	h := RequireAuth(auth, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, _ := PrincipalFrom(r.Context())
+		w.Write([]byte(p.Name))
+	}))
+
+	for _, tc := range []struct {
+		method, header, value string
+		status                int
+		body                  string
+	}{
+		{"GET", "", "", http.StatusUnauthorized, ""},
+		{"GET", "Authorization", "Bearer wrong", http.StatusUnauthorized, ""},
+		{"GET", "Authorization", "Bearer reader-key", http.StatusOK, "reader"},
+		{"GET", "X-API-Key", "reader-key", http.StatusOK, "reader"},
+		{"PUT", "X-API-Key", "reader-key", http.StatusForbidden, ""},
+		{"PUT", "Authorization", "bearer writer-key", http.StatusOK, "writer"},
+	} {
+		r := httptest.NewRequest(tc.method, "/file", nil)
+		if tc.header != "" {
+			r.Header.Set(tc.header, tc.value)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tc.status || tc.body != "" && w.Body.String() != tc.body {
+			t.Errorf("%s with %s %q: %d %q, want %d %q", tc.method, tc.header, tc.value, w.Code, w.Body, tc.status, tc.body)
+		}
+		if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+			t.Error("401 without WWW-Authenticate")
+		}
+	}
+}
+
+func TestClientCerts(t *testing.T) {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "render-farm"}}
+	auth := ClientCerts(func(c *x509.Certificate) (*Principal, bool) {
+		if c.Subject.CommonName != "render-farm" {
+			return nil, false
+		}
+		return &Principal{Name: c.Subject.CommonName, Scopes: []string{ScopeRead}}, true
+	})
+
+	r := httptest.NewRequest("GET", "/", nil)
+	if _, err := auth.Authenticate(r); err != ErrNoCredentials {
+		t.Errorf("plain request: %v, want ErrNoCredentials", err)
+	}
+	r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	if _, err := auth.Authenticate(r); err != ErrNoCredentials {
+		t.Errorf("unverified certificate: %v, want ErrNoCredentials", err)
+	}
+	r.TLS.VerifiedChains = [][]*x509.Certificate{{cert}}
+	if p, err := auth.Authenticate(r); err != nil || p.Name != "render-farm" {
+		t.Errorf("verified certificate: %v, %v", p, err)
+	}
+}
+
+// signJWT returns a compact JWT of claims signed by key.
+func signJWT(t *testing.T, kid string, key crypto.Signer, claims map[string]any) string {
+	t.Helper()
+	alg := "RS256"
+	if _, ok := key.(*ecdsa.PrivateKey); ok {
+		alg = "ES256"
+	}
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	body, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(body)
+	digest := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		sig, _ = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		r, s, _ := ecdsa.Sign(rand.Reader, k, digest[:])
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestOIDC(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	b64 := base64.RawURLEncoding.EncodeToString
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{
+			{"kty": "RSA", "kid": "rsa", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+			{"kty": "EC", "kid": "ec", "crv": "P-256", "x": b64(ecKey.X.Bytes()), "y": b64(ecKey.Y.Bytes())},
+		}})
+	}))
+	t.Cleanup(jwks.Close)
+
+	oidc := OIDC(OIDCOptions{Issuer: "https://idp", Audience: "c4fs", Keys: JWKSKeys(jwks.Client(), jwks.URL)})
+	keys := APIKeys(map[string]Principal{"key": {Name: "script"}})
+	auth := AnyOf(oidc, keys)
+	claims := func(mod func(map[string]any)) map[string]any {
+		c := map[string]any{
+			"iss": "https://idp", "aud": []string{"c4fs"}, "sub": "alice",
+			"exp": time.Now().Add(time.Hour).Unix(), "scope": "openid read write",
+		}
+		if mod != nil {
+			mod(c)
+		}
+		return c
+	}
+	authenticate := func(token string) (*Principal, error) {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		return auth.Authenticate(r)
+	}
+
+	for _, kid := range []string{"rsa", "ec"} {
+		key := crypto.Signer(rsaKey)
+		if kid == "ec" {
+			key = ecKey
+		}
+		p, err := authenticate(signJWT(t, kid, key, claims(nil)))
+		if err != nil || p.Name != "alice" || !p.Can(ScopeWrite) {
+			t.Errorf("%s token: %+v, %v", kid, p, err)
+		}
+	}
+	if p, err := authenticate("key"); err != nil || p.Name != "script" {
+		t.Errorf("API key after OIDC: %+v, %v", p, err)
+	}
+
+	for name, token := range map[string]string{
+		"expired":        signJWT(t, "rsa", rsaKey, claims(func(c map[string]any) { c["exp"] = time.Now().Add(-time.Minute).Unix() })),
+		"wrong issuer":   signJWT(t, "rsa", rsaKey, claims(func(c map[string]any) { c["iss"] = "https://evil" })),
+		"wrong audience": signJWT(t, "rsa", rsaKey, claims(func(c map[string]any) { c["aud"] = "other" })),
+		"wrong key":      signJWT(t, "ec", rsaKey, claims(nil)),
+		"unknown key":    signJWT(t, "gone", rsaKey, claims(nil)),
+	} {
+		if _, err := authenticate(token); err == nil {
+			t.Errorf("%s token accepted", name)
+		}
+	}
+	// A tampered payload breaks the signature
+	token := signJWT(t, "rsa", rsaKey, claims(nil))
+	forged := signJWT(t, "rsa", rsaKey, claims(func(c map[string]any) { c["sub"] = "mallory" }))
+	if _, err := authenticate(token[:len(token)-20] + forged[len(forged)-20:]); err == nil {
+		t.Error("forged token accepted")
+	}
+
+	// API keys tried first leave the tokens they do not know to OIDC
+	auth = AnyOf(keys, oidc)
+	if p, err := authenticate(token); err != nil || p.Name != "alice" {
+		t.Errorf("OIDC token after API keys: %+v, %v", p, err)
+	}
+	if p, err := authenticate("key"); err != nil || p.Name != "script" {
+		t.Errorf("API key before OIDC: %+v, %v", p, err)
+	}
+	if _, err := authenticate(token[:len(token)-20] + forged[len(forged)-20:]); err == nil {
+		t.Error("forged token accepted after API keys")
+	}
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-API-Key", "wrong")
+	if _, err := auth.Authenticate(r); err == nil || errors.Is(err, ErrNoCredentials) {
+		t.Errorf("unknown X-API-Key: %v, want an error", err)
+	}
+}
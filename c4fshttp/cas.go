@@ -0,0 +1,147 @@
+package c4fshttp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/absfs/c4fs"
+)
+
+// CASHandler serves a content store over the HTTP caching protocol of
+// build systems speaking the Remote Execution API, such as Bazel's
+// --remote_cache=http://..., so their outputs land in the same content
+// pool c4fs manifests reference. Blobs are addressed by SHA-256 under
+// /cas/ and action results by the SHA-256 of their action under /ac/,
+// optionally below an instance name. GET and HEAD read an entry, and PUT
+// writes one; uploads to /cas/ must hash to their digest.
+//
+// Build systems address content by SHA-256, so the handler keeps a
+// digest index mapping each digest it has stored to the blob's C4 ID, as
+// references named "cas/<digest>" and "ac/<digest>" in a registry. Give
+// it a registry of its own: the values are plain blobs rather than
+// snapshots, and Registry.CollectGarbage would fail on them. Pass Live to
+// GCOptions.Live to keep the blobs through garbage collection.
+//
+// The handler does not authenticate; wrap it with RequireAuth.
+type CASHandler struct {
+	store *c4fs.StoreAdapter
+	index *c4fs.Registry
+}
+
+// NewCASHandler returns a handler storing blobs in s and its digest index
+// in index.
+func NewCASHandler(s *c4fs.StoreAdapter, index *c4fs.Registry) *CASHandler {
+	return &CASHandler{store: s, index: index}
+}
+
+func (h *CASHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// The last two segments are the kind and the digest; any before
+	// them are the instance name, which shares the content pool
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 2 || !isSHA256(parts[len(parts)-1]) {
+		http.NotFound(w, r)
+		return
+	}
+	kind, digest := parts[len(parts)-2], parts[len(parts)-1]
+	if kind != "cas" && kind != "ac" {
+		http.NotFound(w, r)
+		return
+	}
+	ref := kind + "/" + digest
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		h.get(w, r, ref)
+	case http.MethodPut:
+		h.put(w, r, ref, kind == "cas", digest)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *CASHandler) get(w http.ResponseWriter, r *http.Request, ref string) {
+	id, err := h.index.Ref(ref)
+	if errors.Is(err, c4fs.ErrRefNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	info, err := h.store.Stat(id)
+	if err != nil {
+		// Indexed but collected since
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	if r.Method == http.MethodHead {
+		return
+	}
+	rc, err := h.store.Get(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rc.Close()
+	io.Copy(w, rc)
+}
+
+func (h *CASHandler) put(w http.ResponseWriter, r *http.Request, ref string, verify bool, digest string) {
+	hash := sha256.New()
+	id, err := h.store.Put(io.TeeReader(r.Body, hash))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if verify && hex.EncodeToString(hash.Sum(nil)) != digest {
+		// The blob is left for garbage collection, since another
+		// manifest may share it
+		http.Error(w, "content does not match digest", http.StatusBadRequest)
+		return
+	}
+	if err := h.index.SetRef(ref, id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// Live returns the C4 IDs of the blobs in the digest index, for
+// GCOptions.Live.
+func (h *CASHandler) Live() (map[c4.ID]bool, error) {
+	refs, err := h.index.Refs()
+	if err != nil {
+		return nil, err
+	}
+	live := make(map[c4.ID]bool, len(refs))
+	for name, id := range refs {
+		if strings.HasPrefix(name, "cas/") || strings.HasPrefix(name, "ac/") {
+			live[id] = true
+		}
+	}
+	return live, nil
+}
+
+// isSHA256 reports whether s is a SHA-256 digest in lowercase hex.
+func isSHA256(s string) bool {
+	if len(s) != sha256.Size*2 {
+		return false
+	}
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,87 @@
+package c4fshttp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Avalanche-io/c4/store"
+	"github.com/absfs/c4fs"
+)
+
+func TestCASHandler(t *testing.T) {
+	s := c4fs.NewStoreAdapter(store.NewRAM())
+	index, err := c4fs.NewRegistry(s, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewCASHandler(s, index)
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+
+	put := func(path, body string) int {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPut, srv.URL+path, strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("PUT %s: %v", path, err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	sum := sha256.Sum256([]byte("output"))
+	digest := hex.EncodeToString(sum[:])
+
+	if code := put("/cas/"+digest, "output"); code != http.StatusOK {
+		t.Fatalf("PUT blob: %d", code)
+	}
+	resp, body := get(t, srv.URL+"/cas/"+digest)
+	if resp.StatusCode != http.StatusOK || body != "output" {
+		t.Errorf("GET blob: %d %q", resp.StatusCode, body)
+	}
+	if resp, body := get(t, srv.URL+"/main/cas/"+digest); resp.StatusCode != http.StatusOK || body != "output" {
+		t.Errorf("GET blob under an instance name: %d %q", resp.StatusCode, body)
+	}
+
+	// The blob is the same one a manifest would reference
+	id, err := s.Put(strings.NewReader("output"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	live, err := h.Live()
+	if err != nil || !live[id] {
+		t.Errorf("Live = %v, %v; want %s", live, err, id)
+	}
+
+	if code := put("/cas/"+digest, "tampered"); code != http.StatusBadRequest {
+		t.Errorf("PUT with a wrong digest: %d, want 400", code)
+	}
+	other := strings.Repeat("0", 64)
+	if resp, _ := get(t, srv.URL+"/cas/"+other); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET unknown blob: %d, want 404", resp.StatusCode)
+	}
+	if resp, _ := get(t, srv.URL+"/cas/"+strings.ToUpper(digest)); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET malformed digest: %d, want 404", resp.StatusCode)
+	}
+
+	// Action results are stored as given under the action's digest
+	if code := put("/ac/"+other, "result"); code != http.StatusOK {
+		t.Fatalf("PUT action result: %d", code)
+	}
+	if resp, body := get(t, srv.URL+"/ac/"+other); resp.StatusCode != http.StatusOK || body != "result" {
+		t.Errorf("GET action result: %d %q", resp.StatusCode, body)
+	}
+
+	req, _ := http.NewRequest(http.MethodDelete, srv.URL+"/cas/"+digest, nil)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("DELETE: %d, want 405", resp.StatusCode)
+	}
+}
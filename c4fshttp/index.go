@@ -0,0 +1,200 @@
+// Package c4fshttp provides HTTP frontends for c4fs filesystems.
+package c4fshttp
+
+import (
+	"errors"
+	"html/template"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/absfs/c4fs"
+)
+
+// IndexHandler serves a read-only, browsable view of a filesystem.
+// Directories are rendered as HTML index pages listing names, sizes,
+// modification times and C4 IDs; files are served for download with
+// their C4 ID as the ETag, so browsers and proxies can cache them
+// indefinitely. Only GET and HEAD requests are allowed.
+type IndexHandler struct {
+	fsys *c4fs.FS
+}
+
+// NewIndexHandler returns a handler serving fsys.
+// Mount it under a prefix with http.StripPrefix.
+func NewIndexHandler(fsys *c4fs.FS) *IndexHandler {
+	return &IndexHandler{fsys: fsys}
+}
+
+func (h *IndexHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	urlPath := r.URL.Path
+	if !strings.HasPrefix(urlPath, "/") {
+		urlPath = "/" + urlPath
+	}
+	name := strings.TrimPrefix(path.Clean(urlPath), "/")
+
+	info, err := h.fsys.Stat(name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if info.IsDir() {
+		// Directory URLs end in a slash so relative links resolve. The
+		// redirect is relative so it works behind http.StripPrefix.
+		if !strings.HasSuffix(urlPath, "/") {
+			w.Header().Set("Location", path.Base(urlPath)+"/")
+			w.WriteHeader(http.StatusMovedPermanently)
+			return
+		}
+		h.serveDir(w, r, name)
+		return
+	}
+	h.serveFile(w, r, name, info)
+}
+
+func (h *IndexHandler) serveFile(w http.ResponseWriter, r *http.Request, name string, info fs.FileInfo) {
+	f, err := h.fsys.Open(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	if id := h.contentID(name); id != "" {
+		w.Header().Set("ETag", `"`+id+`"`)
+	}
+	rs, ok := f.(io.ReadSeeker)
+	if !ok {
+		http.Error(w, "file is not seekable", http.StatusInternalServerError)
+		return
+	}
+	http.ServeContent(w, r, info.Name(), info.ModTime(), rs)
+}
+
+// contentID returns the C4 ID of the named file, or "" if it is not a
+// regular file, such as a symlink.
+func (h *IndexHandler) contentID(name string) string {
+	entries, err := h.fsys.ReadDirEntries(path.Dir(name))
+	if err != nil {
+		return ""
+	}
+	for _, e := range entries {
+		if e.Name == path.Base(name) && e.Mode.IsRegular() && !e.C4ID.IsNil() {
+			return e.C4ID.String()
+		}
+	}
+	return ""
+}
+
+// indexRow is one line of a directory index page.
+type indexRow struct {
+	Name    string
+	Href    string
+	IsDir   bool
+	Size    int64
+	ModTime time.Time
+	C4ID    string
+	Target  string
+}
+
+func (h *IndexHandler) serveDir(w http.ResponseWriter, r *http.Request, name string) {
+	entries, err := h.fsys.ReadDirEntries(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+
+	rows := make([]indexRow, 0, len(entries))
+	for _, e := range entries {
+		row := indexRow{
+			Name:    e.Name,
+			Href:    "./" + url.PathEscape(e.Name), // "./" keeps "a:b" from parsing as a scheme
+			IsDir:   e.Mode.IsDir(),
+			Size:    e.Size,
+			ModTime: e.ModTime,
+			Target:  e.Target,
+		}
+		if row.IsDir {
+			row.Href += "/"
+		}
+		if !e.C4ID.IsNil() {
+			row.C4ID = e.C4ID.String()
+		}
+		rows = append(rows, row)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if r.Method == http.MethodHead {
+		return
+	}
+	indexTemplate.Execute(w, struct {
+		Path string
+		Root bool
+		Rows []indexRow
+	}{"/" + name, name == "", rows})
+}
+
+var indexTemplate = template.Must(template.New("index").Funcs(template.FuncMap{
+	"size": func(n int64) string {
+		const unit = 1024
+		if n < unit {
+			return strconv.FormatInt(n, 10) + " B"
+		}
+		div, exp := int64(unit), 0
+		for m := n / unit; m >= unit; m /= unit {
+			div *= unit
+			exp++
+		}
+		return strconv.FormatFloat(float64(n)/float64(div), 'f', 1, 64) + " " + string("KMGTPE"[exp]) + "iB"
+	},
+	"time": func(t time.Time) string {
+		return t.UTC().Format("2006-01-02 15:04:05")
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Index of {{.Path}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { text-align: left; padding: 0.2em 1em 0.2em 0; }
+td.size { text-align: right; }
+td.id { font-family: monospace; font-size: 0.8em; color: #666; }
+</style>
+</head>
+<body>
+<h1>Index of {{.Path}}</h1>
+<table>
+<tr><th>Name</th><th>Size</th><th>Modified (UTC)</th><th>C4 ID</th></tr>
+{{if not .Root}}<tr><td><a href="../">../</a></td><td></td><td></td><td></td></tr>
+{{end}}{{range .Rows}}<tr>
+<td><a href="{{.Href}}">{{.Name}}{{if .IsDir}}/{{end}}</a>{{if .Target}} &rarr; {{.Target}}{{end}}</td>
+<td class="size">{{if not .IsDir}}{{size .Size}}{{end}}</td>
+<td>{{time .ModTime}}</td>
+<td class="id">{{.C4ID}}</td>
+</tr>
+{{end}}</table>
+</body>
+</html>
+`))
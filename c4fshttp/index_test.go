@@ -0,0 +1,134 @@
+package c4fshttp
+
+import (
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/absfs/c4fs/c4fstest"
+)
+
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	fsys := c4fstest.FromMapFS(fstest.MapFS{
+		"readme.txt":         {Data: []byte("hello"), Mode: 0644},
+		"shots/a b.exr":      {Data: []byte("pixels"), Mode: 0644},
+		"shots/<script>.txt": {Data: []byte("x"), Mode: 0644},
+		"shots/latest.exr":   {Data: []byte("a b.exr"), Mode: fs.ModeSymlink | 0777},
+		"shots/empty/.keep":  {Data: []byte{}, Mode: 0644},
+	})
+	srv := httptest.NewServer(NewIndexHandler(fsys))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func get(t *testing.T, url string) (*http.Response, string) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp, string(body)
+}
+
+func TestIndexHandlerDir(t *testing.T) {
+	srv := newTestServer(t)
+
+	resp, body := get(t, srv.URL+"/shots/")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status: got %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("Content-Type: got %q", ct)
+	}
+	for _, want := range []string{
+		`href="./a%20b.exr"`,
+		`href="./empty/"`,
+		`&lt;script&gt;.txt`,
+		`&rarr; a b.exr`,
+		`href="../"`,
+		"c4", // C4 IDs are listed
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("index page missing %q", want)
+		}
+	}
+	if strings.Contains(body, "<script>") {
+		t.Error("file names must be escaped")
+	}
+
+	// Directories without a trailing slash redirect
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	r, err := client.Get(srv.URL + "/shots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Body.Close()
+	if r.StatusCode != http.StatusMovedPermanently || r.Header.Get("Location") != "shots/" {
+		t.Errorf("redirect: got %d %q", r.StatusCode, r.Header.Get("Location"))
+	}
+}
+
+func TestIndexHandlerFile(t *testing.T) {
+	srv := newTestServer(t)
+
+	resp, body := get(t, srv.URL+"/shots/a%20b.exr")
+	if resp.StatusCode != http.StatusOK || body != "pixels" {
+		t.Fatalf("download: got %d %q", resp.StatusCode, body)
+	}
+	etag := resp.Header.Get("ETag")
+	if !strings.HasPrefix(etag, `"c4`) {
+		t.Errorf("ETag: got %q", etag)
+	}
+
+	// Conditional requests use the C4 ID
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/shots/a%20b.exr", nil)
+	req.Header.Set("If-None-Match", etag)
+	r, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Body.Close()
+	if r.StatusCode != http.StatusNotModified {
+		t.Errorf("If-None-Match: got %d", r.StatusCode)
+	}
+
+	// Range requests
+	req, _ = http.NewRequest(http.MethodGet, srv.URL+"/readme.txt", nil)
+	req.Header.Set("Range", "bytes=1-3")
+	r, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part, _ := io.ReadAll(r.Body)
+	r.Body.Close()
+	if r.StatusCode != http.StatusPartialContent || string(part) != "ell" {
+		t.Errorf("Range: got %d %q", r.StatusCode, part)
+	}
+
+	// Symlinks are followed
+	if _, body := get(t, srv.URL+"/shots/latest.exr"); body != "pixels" {
+		t.Errorf("symlink: got %q", body)
+	}
+
+	if resp, _ := get(t, srv.URL+"/missing"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("missing file: got %d", resp.StatusCode)
+	}
+
+	resp, err = http.Post(srv.URL+"/readme.txt", "text/plain", strings.NewReader("x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("POST: got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,249 @@
+package c4fshttp
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OIDCOptions configure an authenticator for OpenID Connect bearer
+// tokens.
+type OIDCOptions struct {
+	// Issuer is the required "iss" claim.
+	Issuer string
+
+	// Audience is the required "aud" claim, usually the client ID.
+	Audience string
+
+	// Keys returns the issuer's public key with the given key ID. Use
+	// JWKSKeys for the key set an issuer publishes.
+	Keys func(ctx context.Context, kid string) (crypto.PublicKey, error)
+
+	// Scopes maps the claims of a token to the scopes of its principal.
+	// If nil, the space-separated "scope" claim is used.
+	Scopes func(claims map[string]any) []string
+}
+
+// OIDC returns an authenticator validating OpenID Connect bearer tokens:
+// JWTs signed with RS256 or ES256 by the issuer, for the audience, and
+// neither expired nor used before their time. The principal is named by
+// the "sub" claim.
+func OIDC(opts OIDCOptions) Authenticator {
+	return AuthenticatorFunc(func(r *http.Request) (*Principal, error) {
+		token := bearerToken(r)
+		if token == "" || strings.Count(token, ".") != 2 {
+			// Not a JWT; perhaps an API key
+			return nil, ErrNoCredentials
+		}
+		claims, err := verifyJWT(r.Context(), token, opts.Keys)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkClaims(claims, opts.Issuer, opts.Audience, time.Now()); err != nil {
+			return nil, err
+		}
+		sub, _ := claims["sub"].(string)
+		p := &Principal{Name: sub}
+		if opts.Scopes != nil {
+			p.Scopes = opts.Scopes(claims)
+		} else if scope, ok := claims["scope"].(string); ok {
+			p.Scopes = strings.Fields(scope)
+		}
+		return p, nil
+	})
+}
+
+// verifyJWT checks the signature of a compact JWT and returns its claims.
+func verifyJWT(ctx context.Context, token string, keys func(context.Context, string) (crypto.PublicKey, error)) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("token header: %w", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("token signature: %w", err)
+	}
+	key, err := keys(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if header.Alg != "RS256" {
+			return nil, fmt.Errorf("token algorithm %q does not match an RSA key", header.Alg)
+		}
+		if err := rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig); err != nil {
+			return nil, errors.New("invalid token signature")
+		}
+	case *ecdsa.PublicKey:
+		if header.Alg != "ES256" || len(sig) != 64 {
+			return nil, fmt.Errorf("token algorithm %q does not match an EC key", header.Alg)
+		}
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(k, digest[:], r, s) {
+			return nil, errors.New("invalid token signature")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("token claims: %w", err)
+	}
+	return claims, nil
+}
+
+func decodeSegment(seg string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// checkClaims checks the issuer, audience and validity period of a token
+// at time now.
+func checkClaims(claims map[string]any, issuer, audience string, now time.Time) error {
+	if iss, _ := claims["iss"].(string); iss != issuer {
+		return fmt.Errorf("token issuer %q not accepted", iss)
+	}
+	var aud []string
+	switch v := claims["aud"].(type) {
+	case string:
+		aud = []string{v}
+	case []any:
+		for _, a := range v {
+			if s, ok := a.(string); ok {
+				aud = append(aud, s)
+			}
+		}
+	}
+	if !slices.Contains(aud, audience) {
+		return errors.New("token audience not accepted")
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok || !now.Before(time.Unix(int64(exp), 0)) {
+		return errors.New("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Before(time.Unix(int64(nbf), 0)) {
+		return errors.New("token not yet valid")
+	}
+	return nil
+}
+
+// jwksRefresh is how often JWKSKeys refetches a key set to pick up new
+// keys.
+const jwksRefresh = time.Hour
+
+// JWKSKeys returns a key lookup for OIDCOptions.Keys reading the JSON Web
+// Key Set at url, such as the jwks_uri of an issuer's discovery document.
+// The set is cached, and fetched again hourly or when a token names an
+// unknown key, at most once a minute.
+func JWKSKeys(client *http.Client, url string) func(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	var (
+		mu      sync.Mutex
+		keys    map[string]crypto.PublicKey
+		fetched time.Time
+	)
+	return func(ctx context.Context, kid string) (crypto.PublicKey, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		_, known := keys[kid]
+		if age := time.Since(fetched); age > jwksRefresh || !known && age > time.Minute {
+			set, err := fetchJWKS(ctx, client, url)
+			if err != nil && keys == nil {
+				return nil, err
+			}
+			if err == nil {
+				keys, fetched = set, time.Now()
+			}
+		}
+		key, ok := keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+		return key, nil
+	}
+}
+
+// fetchJWKS fetches and parses the RSA and P-256 keys of a JSON Web Key
+// Set. Keys of other types are ignored.
+func fetchJWKS(ctx context.Context, client *http.Client, url string) (map[string]crypto.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching key set: %s", resp.Status)
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("parsing key set: %w", err)
+	}
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		switch {
+		case k.Kty == "RSA":
+			n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+			e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+			if err1 != nil || err2 != nil || len(e) > 4 {
+				continue
+			}
+			keys[k.Kid] = &rsa.PublicKey{
+				N: new(big.Int).SetBytes(n),
+				E: int(new(big.Int).SetBytes(e).Int64()),
+			}
+		case k.Kty == "EC" && k.Crv == "P-256":
+			x, err1 := base64.RawURLEncoding.DecodeString(k.X)
+			y, err2 := base64.RawURLEncoding.DecodeString(k.Y)
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{
+				Curve: elliptic.P256(),
+				X:     new(big.Int).SetBytes(x),
+				Y:     new(big.Int).SetBytes(y),
+			}
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,246 @@
+package c4fshttp
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/absfs/c4fs"
+)
+
+// ShareHandler serves individual files through signed, expiring links,
+// so they can be shared outside without exposing the rest of the
+// filesystem. A link names a file and the C4 ID of its content when the
+// link was made; it keeps serving that content after the file changes,
+// as long as Versions can find it, and never serves anything else. Links
+// may cap the bytes they serve in total and the bandwidth they use,
+// across all downloads. Only GET and HEAD requests are allowed.
+//
+// Links are signed with HMAC-SHA256 over the path, ID, expiry and caps,
+// so they cannot be altered without the key. Byte counts are kept in
+// memory, so caps reset when the handler is recreated.
+type ShareHandler struct {
+	fsys *c4fs.FS
+	key  []byte
+
+	mu     sync.Mutex
+	tokens map[string]*shareToken // by signature
+}
+
+// ShareOptions are the limits of a shared link.
+type ShareOptions struct {
+	// Expires is when the link stops working. It is required.
+	Expires time.Time
+
+	// MaxBytes caps the bytes the link serves over all its downloads.
+	// Zero means no cap.
+	MaxBytes int64
+
+	// Rate caps the bandwidth of the link, in bytes per second, shared
+	// by its concurrent downloads. Zero means no cap.
+	Rate int64
+}
+
+// shareToken is the usage of one link.
+type shareToken struct {
+	expires time.Time
+	served  int64     // bytes served
+	next    time.Time // when the rate cap allows the next byte
+}
+
+// NewShareHandler returns a handler serving links to files of fsys signed
+// with key. Mount it under a prefix with http.StripPrefix; the links Sign
+// returns are relative to it.
+func NewShareHandler(fsys *c4fs.FS, key []byte) *ShareHandler {
+	return &ShareHandler{
+		fsys:   fsys,
+		key:    key,
+		tokens: make(map[string]*shareToken),
+	}
+}
+
+// Sign returns a link to the current content of the regular file name,
+// as a path and query relative to the handler.
+func (h *ShareHandler) Sign(name string, opts ShareOptions) (string, error) {
+	if opts.Expires.IsZero() {
+		return "", errors.New("share: link needs an expiry")
+	}
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	e, err := h.fsys.Entry(name)
+	if err != nil {
+		return "", err
+	}
+	if !e.Mode.IsRegular() {
+		return "", &fs.PathError{Op: "share", Path: name, Err: fs.ErrInvalid}
+	}
+
+	q := url.Values{}
+	q.Set("id", e.C4ID.String())
+	q.Set("exp", strconv.FormatInt(opts.Expires.Unix(), 10))
+	if opts.MaxBytes > 0 {
+		q.Set("max", strconv.FormatInt(opts.MaxBytes, 10))
+	}
+	if opts.Rate > 0 {
+		q.Set("rate", strconv.FormatInt(opts.Rate, 10))
+	}
+	q.Set("sig", h.sign(name, q))
+	return (&url.URL{Path: "/" + name, RawQuery: q.Encode()}).String(), nil
+}
+
+// sign returns the signature of a link to name with query q.
+func (h *ShareHandler) sign(name string, q url.Values) string {
+	mac := hmac.New(sha256.New, h.key)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s\n%s", name, q.Get("id"), q.Get("exp"), q.Get("max"), q.Get("rate"))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (h *ShareHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	q := r.URL.Query()
+	sig := q.Get("sig")
+	if !hmac.Equal([]byte(sig), []byte(h.sign(name, q))) {
+		http.Error(w, "invalid link", http.StatusForbidden)
+		return
+	}
+	exp, err1 := strconv.ParseInt(q.Get("exp"), 10, 64)
+	id, err2 := c4.Parse(q.Get("id"))
+	maxBytes, err3 := parseLimit(q.Get("max"))
+	rate, err4 := parseLimit(q.Get("rate"))
+	if err := errors.Join(err1, err2, err3, err4); err != nil {
+		http.Error(w, "invalid link", http.StatusBadRequest)
+		return
+	}
+	expires := time.Unix(exp, 0)
+	if !time.Now().Before(expires) {
+		http.Error(w, "link expired", http.StatusGone)
+		return
+	}
+
+	tok := h.token(sig, expires)
+	if maxBytes > 0 && h.served(tok) >= maxBytes {
+		http.Error(w, "download limit reached", http.StatusTooManyRequests)
+		return
+	}
+
+	f, err := h.fsys.OpenVersion(name, id)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) || errors.Is(err, c4fs.ErrNoHistory) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rs, ok := f.(io.ReadSeeker)
+	if !ok {
+		http.Error(w, "file is not seekable", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("ETag", `"`+id.String()+`"`)
+	w.Header().Set("Cache-Control", "private")
+	lw := &limitedWriter{ResponseWriter: w, h: h, tok: tok, max: maxBytes, rate: rate}
+	http.ServeContent(lw, r, path.Base(name), info.ModTime(), rs)
+}
+
+func parseLimit(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err == nil && n <= 0 {
+		err = errors.New("limit must be positive")
+	}
+	return n, err
+}
+
+// token returns the usage of the link with signature sig, dropping that
+// of expired links.
+func (h *ShareHandler) token(sig string, expires time.Time) *shareToken {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now()
+	for s, t := range h.tokens {
+		if !now.Before(t.expires) {
+			delete(h.tokens, s)
+		}
+	}
+	t, ok := h.tokens[sig]
+	if !ok {
+		t = &shareToken{expires: expires}
+		h.tokens[sig] = t
+	}
+	return t
+}
+
+func (h *ShareHandler) served(t *shareToken) int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return t.served
+}
+
+// errShareLimit ends a download that reached its link's byte cap.
+var errShareLimit = errors.New("download limit reached")
+
+// limitedWriter counts the bytes a download serves against its link's
+// cap and paces them to its rate.
+type limitedWriter struct {
+	http.ResponseWriter
+	h    *ShareHandler
+	tok  *shareToken
+	max  int64
+	rate int64
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	var err error
+	w.h.mu.Lock()
+	if w.max > 0 {
+		if left := max(w.max-w.tok.served, 0); int64(len(p)) > left {
+			p, err = p[:left], errShareLimit
+		}
+	}
+	w.tok.served += int64(len(p))
+	var wait time.Duration
+	if w.rate > 0 {
+		now := time.Now()
+		start := w.tok.next
+		if start.Before(now) {
+			start = now
+		}
+		w.tok.next = start.Add(time.Duration(len(p)) * time.Second / time.Duration(w.rate))
+		wait = start.Sub(now)
+	}
+	w.h.mu.Unlock()
+
+	time.Sleep(wait)
+	n, werr := w.ResponseWriter.Write(p)
+	if werr != nil {
+		return n, werr
+	}
+	return n, err
+}
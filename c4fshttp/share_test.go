@@ -0,0 +1,85 @@
+package c4fshttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/absfs/c4fs/c4fstest"
+)
+
+func TestShareHandler(t *testing.T) {
+	fsys := c4fstest.FromMapFS(fstest.MapFS{
+		"shots/a b.exr": {Data: []byte("pixels"), Mode: 0644},
+		"private.txt":   {Data: []byte("secret"), Mode: 0644},
+	})
+	h := NewShareHandler(fsys, []byte("key"))
+	srv := httptest.NewServer(http.StripPrefix("/share", h))
+	t.Cleanup(srv.Close)
+
+	link, err := h.Sign("shots/a b.exr", ShareOptions{Expires: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, body := get(t, srv.URL+"/share"+link)
+	if resp.StatusCode != http.StatusOK || body != "pixels" {
+		t.Fatalf("GET link: %d %q", resp.StatusCode, body)
+	}
+	if etag := resp.Header.Get("ETag"); !strings.HasPrefix(etag, `"c4`) {
+		t.Errorf("ETag = %q", etag)
+	}
+
+	// The link covers only its file and content
+	other := strings.Replace(link, "/shots/a%20b.exr", "/private.txt", 1)
+	if resp, _ := get(t, srv.URL+"/share"+other); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("GET of another path: %d, want 403", resp.StatusCode)
+	}
+	longer := strings.Replace(link, "exp=", "exp=9", 1)
+	if resp, _ := get(t, srv.URL+"/share"+longer); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("GET with a changed expiry: %d, want 403", resp.StatusCode)
+	}
+	fsys.WriteFile("shots/a b.exr", []byte("new pixels"), 0644)
+	if resp, _ := get(t, srv.URL+"/share"+link); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET after the file changed: %d, want 404", resp.StatusCode)
+	}
+
+	expired, _ := h.Sign("private.txt", ShareOptions{Expires: time.Now().Add(-time.Second)})
+	if resp, _ := get(t, srv.URL+"/share"+expired); resp.StatusCode != http.StatusGone {
+		t.Errorf("GET of an expired link: %d, want 410", resp.StatusCode)
+	}
+	if _, err := h.Sign("shots", ShareOptions{Expires: time.Now().Add(time.Hour)}); err == nil {
+		t.Error("Sign of a directory succeeded")
+	}
+}
+
+func TestShareHandlerLimits(t *testing.T) {
+	fsys := c4fstest.FromMapFS(fstest.MapFS{
+		"clip.mov": {Data: []byte(strings.Repeat("x", 100)), Mode: 0644},
+	})
+	h := NewShareHandler(fsys, []byte("key"))
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+
+	link, _ := h.Sign("clip.mov", ShareOptions{Expires: time.Now().Add(time.Hour), MaxBytes: 150})
+	if resp, body := get(t, srv.URL+link); resp.StatusCode != http.StatusOK || len(body) != 100 {
+		t.Fatalf("first download: %d, %d bytes", resp.StatusCode, len(body))
+	}
+	// The second download is cut off at the cap, and a third refused
+	if _, body := get(t, srv.URL+link); len(body) != 50 {
+		t.Errorf("second download: %d bytes, want 50", len(body))
+	}
+	if resp, _ := get(t, srv.URL+link); resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("third download: %d, want 429", resp.StatusCode)
+	}
+
+	link, _ = h.Sign("clip.mov", ShareOptions{Expires: time.Now().Add(time.Hour), Rate: 1000})
+	start := time.Now()
+	get(t, srv.URL+link)
+	get(t, srv.URL+link)
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("200 bytes at 1000 B/s took %v", elapsed)
+	}
+}
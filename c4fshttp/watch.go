@@ -0,0 +1,108 @@
+package c4fshttp
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/absfs/c4fs"
+)
+
+// watchBuffer is the number of events queued per client before events
+// are dropped and an overflow is reported.
+const watchBuffer = 1024
+
+// WatchHandler streams filesystem changes to clients as Server-Sent
+// Events, so downstream caches and mirrors can stay current without
+// polling. Each event's type is the change operation ("create", "modify",
+// "remove" or "overflow") and its data a JSON object describing the entry.
+// The optional "path" query parameter limits events to a file or directory
+// tree. An "overflow" event means events were lost and the client should
+// resynchronize.
+type WatchHandler struct {
+	fsys *c4fs.FS
+}
+
+// NewWatchHandler returns a handler streaming changes to fsys.
+func NewWatchHandler(fsys *c4fs.FS) *WatchHandler {
+	return &WatchHandler{fsys: fsys}
+}
+
+// watchEvent is the JSON form of a change event.
+type watchEvent struct {
+	Path   string `json:"path,omitempty"`
+	Dir    bool   `json:"dir,omitempty"`
+	Size   int64  `json:"size,omitempty"`
+	Mode   uint32 `json:"mode,omitempty"`
+	MTime  string `json:"mtime,omitempty"` // RFC 3339 with nanoseconds
+	C4ID   string `json:"c4id,omitempty"`
+	Target string `json:"target,omitempty"`
+}
+
+func (h *WatchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	prefix := strings.Trim(path.Clean("/"+r.URL.Query().Get("path")), "/")
+
+	events, cancel := h.fsys.Subscribe(watchBuffer)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	enc := json.NewEncoder(w)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev := <-events:
+			if ev.Op != c4fs.ChangeOverflow && !underPrefix(ev.Path, prefix) {
+				continue
+			}
+			if _, err := w.Write([]byte("event: " + ev.Op.String() + "\ndata: ")); err != nil {
+				return
+			}
+			// The encoder's trailing newline ends the data line
+			if err := enc.Encode(toWatchEvent(ev)); err != nil {
+				return
+			}
+			w.Write([]byte("\n"))
+			flusher.Flush()
+		}
+	}
+}
+
+// underPrefix reports whether name is prefix or inside it.
+func underPrefix(name, prefix string) bool {
+	return prefix == "" || name == prefix || strings.HasPrefix(name, prefix+"/")
+}
+
+func toWatchEvent(ev c4fs.ChangeEvent) watchEvent {
+	we := watchEvent{Path: ev.Path}
+	if e := ev.Entry; e != nil {
+		we.Dir = e.IsDir()
+		we.Mode = uint32(e.Mode.Perm())
+		we.MTime = e.Timestamp.UTC().Format(time.RFC3339Nano)
+		we.Target = e.Target
+		if !we.Dir {
+			we.Size = e.Size
+		}
+		if !e.C4ID.IsNil() {
+			we.C4ID = e.C4ID.String()
+		}
+	}
+	return we
+}
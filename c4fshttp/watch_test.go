@@ -0,0 +1,74 @@
+package c4fshttp
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Avalanche-io/c4/store"
+	"github.com/absfs/c4fs"
+)
+
+func TestWatchHandler(t *testing.T) {
+	fsys := c4fs.New(c4fs.NewStoreAdapter(store.NewRAM()))
+	fsys.MkdirAll("shots", 0755)
+	srv := httptest.NewServer(NewWatchHandler(fsys))
+	t.Cleanup(srv.Close)
+
+	resp, err := http.Get(srv.URL + "/?path=shots")
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type: got %q", ct)
+	}
+
+	// The subscription is in place once the headers have arrived
+	fsys.WriteFile("other.txt", []byte("ignored"), 0644)
+	fsys.WriteFile("shots/a.exr", []byte("pixels"), 0644)
+	fsys.Remove("shots/a.exr")
+
+	sc := bufio.NewScanner(resp.Body)
+	next := func() (string, watchEvent) {
+		t.Helper()
+		var typ string
+		var ev watchEvent
+		for sc.Scan() {
+			line := sc.Text()
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				typ = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &ev); err != nil {
+					t.Fatalf("bad event data %q: %v", line, err)
+				}
+			case line == "":
+				return typ, ev
+			}
+		}
+		t.Fatalf("stream ended: %v", sc.Err())
+		return "", ev
+	}
+
+	typ, ev := next()
+	if typ != "create" || ev.Path != "shots/a.exr" || ev.Size != 6 || ev.C4ID == "" {
+		t.Errorf("first event: %s %+v", typ, ev)
+	}
+	typ, ev = next()
+	if typ != "remove" || ev.Path != "shots/a.exr" {
+		t.Errorf("second event: %s %+v", typ, ev)
+	}
+}
+
+func TestWatchHandlerMethod(t *testing.T) {
+	fsys := c4fs.New(c4fs.NewStoreAdapter(store.NewRAM()))
+	rec := httptest.NewRecorder()
+	NewWatchHandler(fsys).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: got status %d", rec.Code)
+	}
+}
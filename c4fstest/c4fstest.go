@@ -0,0 +1,118 @@
+// Package c4fstest provides helpers for building c4fs filesystems from
+// literal fixtures in tests and for asserting on the resulting trees.
+package c4fstest
+
+import (
+	"io/fs"
+	"path"
+	"sort"
+	"testing/fstest"
+
+	"github.com/Avalanche-io/c4/store"
+	"github.com/absfs/c4fs"
+)
+
+// FromMapFS returns a filesystem whose base snapshot contains the files,
+// directories and symlinks in m, backed by an in-memory store.
+// Parent directories are created implicitly. For symlinks, the MapFile's
+// Data is the link target. It panics if the fixture cannot be built.
+func FromMapFS(m fstest.MapFS) *c4fs.FS {
+	fsys := c4fs.New(c4fs.NewStoreAdapter(store.NewRAM()))
+
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		f := m[name]
+		if err := addMapFile(fsys, name, f); err != nil {
+			panic("c4fstest: " + err.Error())
+		}
+	}
+
+	return c4fs.New(fsys.Store(), c4fs.WithBase(fsys.Flatten()))
+}
+
+func addMapFile(fsys *c4fs.FS, name string, f *fstest.MapFile) error {
+	if dir := path.Dir(name); dir != "." {
+		if err := fsys.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case f.Mode.IsDir():
+		perm := f.Mode.Perm()
+		if perm == 0 {
+			perm = 0755
+		}
+		if err := fsys.MkdirAll(name, perm); err != nil {
+			return err
+		}
+		// MkdirAll leaves an implicitly created directory's mode alone
+		if err := fsys.Chmod(name, perm|fs.ModeDir); err != nil {
+			return err
+		}
+	case f.Mode&fs.ModeSymlink != 0:
+		return fsys.Symlink(string(f.Data), name)
+	default:
+		perm := f.Mode.Perm()
+		if perm == 0 {
+			perm = 0644
+		}
+		if err := fsys.WriteFile(name, f.Data, perm); err != nil {
+			return err
+		}
+	}
+
+	if !f.ModTime.IsZero() {
+		return fsys.Chtimes(name, f.ModTime, f.ModTime)
+	}
+	return nil
+}
+
+// ToMapFS returns the contents of fsys as a MapFS. Symlinks are not
+// followed; their MapFile holds the link target as Data.
+func ToMapFS(fsys *c4fs.FS) (fstest.MapFS, error) {
+	m := make(fstest.MapFS)
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if name == "." {
+			return nil
+		}
+
+		info, err := fsys.Lstat(name)
+		if err != nil {
+			return err
+		}
+		f := &fstest.MapFile{
+			Mode:    info.Mode(),
+			ModTime: info.ModTime(),
+		}
+
+		switch {
+		case info.IsDir():
+		case info.Mode()&fs.ModeSymlink != 0:
+			target, err := fsys.ReadLink(name)
+			if err != nil {
+				return err
+			}
+			f.Data = []byte(target)
+		default:
+			if f.Data, err = fsys.ReadFile(name); err != nil {
+				return err
+			}
+		}
+
+		m[name] = f
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
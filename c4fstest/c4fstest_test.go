@@ -0,0 +1,53 @@
+package c4fstest
+
+import (
+	"io/fs"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestMapFSRoundTrip(t *testing.T) {
+	mtime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	fixture := fstest.MapFS{
+		"README.md":        {Data: []byte("# readme"), Mode: 0644, ModTime: mtime},
+		"src/main.go":      {Data: []byte("package main"), Mode: 0600},
+		"bin":              {Mode: fs.ModeDir | 0700},
+		"latest":           {Data: []byte("src/main.go"), Mode: fs.ModeSymlink | 0777},
+		"deep/nested/file": {Data: []byte("x")},
+	}
+
+	fsys := FromMapFS(fixture)
+
+	if len(fsys.Layer().Entries) != 0 {
+		t.Error("Fixture content should be in the base snapshot, not the layer")
+	}
+	if data, err := fsys.ReadFile("latest"); err != nil || string(data) != "package main" {
+		t.Errorf("ReadFile through symlink: got %q, %v", data, err)
+	}
+
+	got, err := ToMapFS(fsys)
+	if err != nil {
+		t.Fatalf("ToMapFS failed: %v", err)
+	}
+
+	for name, want := range fixture {
+		f, ok := got[name]
+		if !ok {
+			t.Errorf("%s missing from ToMapFS result", name)
+			continue
+		}
+		if string(f.Data) != string(want.Data) {
+			t.Errorf("%s data: got %q, want %q", name, f.Data, want.Data)
+		}
+		if want.Mode != 0 && f.Mode != want.Mode {
+			t.Errorf("%s mode: got %v, want %v", name, f.Mode, want.Mode)
+		}
+	}
+	if !got["README.md"].ModTime.Equal(mtime) {
+		t.Errorf("README.md mtime: got %v, want %v", got["README.md"].ModTime, mtime)
+	}
+	if _, ok := got["deep/nested"]; !ok {
+		t.Error("Implicit parent directory missing from ToMapFS result")
+	}
+}
@@ -0,0 +1,258 @@
+package c4fstest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/store"
+	"github.com/absfs/c4fs"
+)
+
+// ErrInjected is the error a FaultyStore injects. Injected errors are
+// *fs.PathError values wrapping it, naming the operation and the blob.
+var ErrInjected = errors.New("injected fault")
+
+// FaultOptions configures the faults a FaultyStore injects. The zero
+// value injects none.
+type FaultOptions struct {
+	// Seed seeds the choice of failing operations, so that a sequence of
+	// operations fails the same way on every run.
+	Seed int64
+
+	// ErrorRate is the probability, from 0 to 1, that an operation fails
+	// before reaching the wrapped store: Open, OpenRange, Create, Remove,
+	// StatBlob, ListIDs or Ping.
+	ErrorRate float64
+
+	// StreamErrorRate is the probability that a Read of an opened blob,
+	// or a Write or Close of a created one, fails. A failed Write or
+	// Close leaves nothing stored.
+	StreamErrorRate float64
+
+	// ShortReads makes each Read return at most half the bytes asked
+	// for, as network streams may, to exercise callers that assume a
+	// full buffer.
+	ShortReads bool
+
+	// Latency is added to every operation, including each Read and Write.
+	Latency time.Duration
+
+	// Fail, if set, is called for every operation and fails it when it
+	// returns true, in addition to ErrorRate, for targeting particular
+	// blobs. op is the method name, such as "Open" or "Read".
+	Fail func(op string, id c4.ID) bool
+}
+
+// FaultyStore wraps a store and injects errors, latency and short reads,
+// so that embedders can test how their code, and the filesystem's retry
+// and error paths, handle a misbehaving store. It passes RangeOpener,
+// BlobStater, BlobChecker, BlobLister and HealthChecker through to the
+// wrapped store, with faults. It is safe for concurrent use, though the
+// faults are then only deterministic for a given interleaving.
+type FaultyStore struct {
+	store store.Store
+
+	mu       sync.Mutex
+	opts     FaultOptions
+	rng      *rand.Rand
+	injected int
+}
+
+var (
+	_ store.Store        = (*FaultyStore)(nil)
+	_ c4fs.RangeOpener   = (*FaultyStore)(nil)
+	_ c4fs.BlobStater    = (*FaultyStore)(nil)
+	_ c4fs.BlobChecker   = (*FaultyStore)(nil)
+	_ c4fs.BlobLister    = (*FaultyStore)(nil)
+	_ c4fs.HealthChecker = (*FaultyStore)(nil)
+)
+
+// NewFaultyStore returns s injecting the faults described by opts.
+func NewFaultyStore(s store.Store, opts FaultOptions) *FaultyStore {
+	return &FaultyStore{store: s, opts: opts, rng: rand.New(rand.NewSource(opts.Seed))}
+}
+
+// SetOptions replaces the faults injected from now on, for example to
+// let the store recover partway through a test. The random sequence is
+// reseeded from opts.Seed.
+func (s *FaultyStore) SetOptions(opts FaultOptions) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.opts = opts
+	s.rng = rand.New(rand.NewSource(opts.Seed))
+}
+
+// Injected returns the number of faults injected so far.
+func (s *FaultyStore) Injected() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.injected
+}
+
+// fault waits out the latency of op on id and returns the error to
+// inject, or nil. stream selects StreamErrorRate over ErrorRate.
+func (s *FaultyStore) fault(op string, id c4.ID, stream bool) error {
+	s.mu.Lock()
+	opts := s.opts
+	rate := opts.ErrorRate
+	if stream {
+		rate = opts.StreamErrorRate
+	}
+	fail := rate > 0 && s.rng.Float64() < rate
+	s.mu.Unlock()
+
+	if opts.Latency > 0 {
+		time.Sleep(opts.Latency)
+	}
+	if !fail && opts.Fail != nil {
+		fail = opts.Fail(op, id)
+	}
+	if !fail {
+		return nil
+	}
+	s.mu.Lock()
+	s.injected++
+	s.mu.Unlock()
+	return &fs.PathError{Op: op, Path: id.String(), Err: ErrInjected}
+}
+
+// Open opens the blob for reading.
+func (s *FaultyStore) Open(id c4.ID) (io.ReadCloser, error) {
+	if err := s.fault("Open", id, false); err != nil {
+		return nil, err
+	}
+	rc, err := s.store.Open(id)
+	if err != nil {
+		return nil, err
+	}
+	return &faultyReader{rc: rc, store: s, id: id}, nil
+}
+
+// OpenRange opens part of the blob for reading.
+func (s *FaultyStore) OpenRange(id c4.ID, off, length int64) (io.ReadCloser, error) {
+	if err := s.fault("OpenRange", id, false); err != nil {
+		return nil, err
+	}
+	rc, err := c4fs.NewStoreAdapter(s.store).GetRange(id, off, length)
+	if err != nil {
+		return nil, err
+	}
+	return &faultyReader{rc: rc, store: s, id: id}, nil
+}
+
+// Create opens a writer for the blob.
+func (s *FaultyStore) Create(id c4.ID) (io.WriteCloser, error) {
+	if err := s.fault("Create", id, false); err != nil {
+		return nil, err
+	}
+	wc, err := s.store.Create(id)
+	if err != nil {
+		return nil, err
+	}
+	return &faultyWriter{wc: wc, store: s, id: id}, nil
+}
+
+// Remove removes the blob from the wrapped store.
+func (s *FaultyStore) Remove(id c4.ID) error {
+	if err := s.fault("Remove", id, false); err != nil {
+		return err
+	}
+	return s.store.Remove(id)
+}
+
+// StatBlob describes the blob in the wrapped store.
+func (s *FaultyStore) StatBlob(id c4.ID) (c4fs.BlobInfo, error) {
+	if err := s.fault("StatBlob", id, false); err != nil {
+		return c4fs.BlobInfo{}, err
+	}
+	return c4fs.NewStoreAdapter(s.store).Stat(id)
+}
+
+// HasBlob checks the wrapped store. It cannot report an error, so only
+// latency is injected.
+func (s *FaultyStore) HasBlob(id c4.ID) bool {
+	s.mu.Lock()
+	latency := s.opts.Latency
+	s.mu.Unlock()
+	if latency > 0 {
+		time.Sleep(latency)
+	}
+	return c4fs.NewStoreAdapter(s.store).Has(id)
+}
+
+// ListIDs lists the wrapped store.
+func (s *FaultyStore) ListIDs() ([]c4.ID, error) {
+	if err := s.fault("ListIDs", c4.ID{}, false); err != nil {
+		return nil, err
+	}
+	return c4fs.ListIDs(s.store)
+}
+
+// Ping checks the wrapped store.
+func (s *FaultyStore) Ping(ctx context.Context) error {
+	if err := s.fault("Ping", c4.ID{}, false); err != nil {
+		return err
+	}
+	return c4fs.NewStoreAdapter(s.store).Ping(ctx)
+}
+
+// faultyReader injects faults into the reads of a blob.
+type faultyReader struct {
+	rc    io.ReadCloser
+	store *FaultyStore
+	id    c4.ID
+}
+
+func (r *faultyReader) Read(p []byte) (int, error) {
+	if err := r.store.fault("Read", r.id, true); err != nil {
+		return 0, err
+	}
+	r.store.mu.Lock()
+	short := r.store.opts.ShortReads
+	r.store.mu.Unlock()
+	if short && len(p) > 1 {
+		p = p[:(len(p)+1)/2]
+	}
+	return r.rc.Read(p)
+}
+
+func (r *faultyReader) Close() error {
+	return r.rc.Close()
+}
+
+// faultyWriter injects faults into the writes of a blob. Once a fault is
+// injected the blob is not stored.
+type faultyWriter struct {
+	wc     io.WriteCloser
+	store  *FaultyStore
+	id     c4.ID
+	failed bool
+}
+
+func (w *faultyWriter) Write(p []byte) (int, error) {
+	if err := w.store.fault("Write", w.id, true); err != nil {
+		w.failed = true
+		return 0, err
+	}
+	return w.wc.Write(p)
+}
+
+func (w *faultyWriter) Close() error {
+	err := w.store.fault("Close", w.id, true)
+	if err != nil || w.failed {
+		// Stores keep what was written once the writer is closed
+		w.wc.Close()
+		w.store.store.Remove(w.id)
+		if err == nil {
+			err = &fs.PathError{Op: "Close", Path: w.id.String(), Err: ErrInjected}
+		}
+		return err
+	}
+	return w.wc.Close()
+}
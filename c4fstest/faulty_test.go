@@ -0,0 +1,117 @@
+package c4fstest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/store"
+	"github.com/absfs/c4fs"
+)
+
+func TestFaultyStoreErrors(t *testing.T) {
+	s := NewFaultyStore(store.NewRAM(), FaultOptions{ErrorRate: 1})
+	fsys := c4fs.New(c4fs.NewStoreAdapter(s))
+
+	err := fsys.WriteFile("a.txt", []byte("hello"), 0644)
+	if !errors.Is(err, ErrInjected) {
+		t.Fatalf("WriteFile = %v, want ErrInjected", err)
+	}
+	if s.Injected() == 0 {
+		t.Error("Injected() = 0 after a fault")
+	}
+
+	s.SetOptions(FaultOptions{})
+	if err := fsys.WriteFile("a.txt", []byte("hello"), 0644); err != nil {
+		t.Fatalf("WriteFile after recovery failed: %v", err)
+	}
+	s.SetOptions(FaultOptions{StreamErrorRate: 1})
+	if _, err := fsys.ReadFile("a.txt"); !errors.Is(err, ErrInjected) {
+		t.Errorf("ReadFile = %v, want ErrInjected", err)
+	}
+}
+
+func TestFaultyStoreDeterministic(t *testing.T) {
+	run := func() []bool {
+		ram := store.NewRAM()
+		id := c4.Identify(bytes.NewReader([]byte("x")))
+		s := NewFaultyStore(ram, FaultOptions{Seed: 42, ErrorRate: 0.5})
+		var failed []bool
+		for range 64 {
+			_, err := s.Open(id)
+			failed = append(failed, errors.Is(err, ErrInjected))
+		}
+		return failed
+	}
+	a, b := run(), run()
+	var n int
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("operation %d failed differently with the same seed", i)
+		}
+		if a[i] {
+			n++
+		}
+	}
+	if n == 0 || n == len(a) {
+		t.Errorf("%d of %d operations failed at rate 0.5", n, len(a))
+	}
+}
+
+func TestFaultyStoreTargeted(t *testing.T) {
+	ram := store.NewRAM()
+	fsys := c4fs.New(c4fs.NewStoreAdapter(ram))
+	fsys.WriteFile("good.txt", []byte("good"), 0644)
+	fsys.WriteFile("bad.txt", []byte("bad"), 0644)
+	bad := c4.Identify(bytes.NewReader([]byte("bad")))
+
+	s := NewFaultyStore(ram, FaultOptions{Fail: func(op string, id c4.ID) bool {
+		return op == "Open" && id == bad
+	}})
+	fsys = c4fs.New(c4fs.NewStoreAdapter(s), c4fs.WithBase(fsys.Flatten()))
+	if data, err := fsys.ReadFile("good.txt"); err != nil || string(data) != "good" {
+		t.Errorf("ReadFile(good.txt) = %q, %v", data, err)
+	}
+	if _, err := fsys.ReadFile("bad.txt"); !errors.Is(err, ErrInjected) {
+		t.Errorf("ReadFile(bad.txt) = %v, want ErrInjected", err)
+	}
+}
+
+func TestFaultyStoreShortReads(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	s := NewFaultyStore(store.NewRAM(), FaultOptions{ShortReads: true})
+	fsys := c4fs.New(c4fs.NewStoreAdapter(s))
+	if err := fsys.WriteFile("big.bin", content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := fsys.Open("big.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	buf := make([]byte, 100)
+	if n, err := f.Read(buf); err != nil || n >= len(buf) {
+		t.Errorf("Read = %d, %v; want a short read", n, err)
+	}
+
+	if data, err := fsys.ReadFile("big.bin"); err != nil || !bytes.Equal(data, content) {
+		t.Errorf("ReadFile over short reads = %d bytes, %v", len(data), err)
+	}
+	if _, err := io.ReadFull(f, buf); err != nil {
+		t.Errorf("ReadFull over short reads failed: %v", err)
+	}
+}
+
+func TestFaultyStoreLatency(t *testing.T) {
+	s := NewFaultyStore(store.NewRAM(), FaultOptions{Latency: 20 * time.Millisecond})
+	start := time.Now()
+	s.Ping(context.Background())
+	if d := time.Since(start); d < 20*time.Millisecond {
+		t.Errorf("Ping took %v, want at least the injected latency", d)
+	}
+}
@@ -0,0 +1,129 @@
+package c4fs
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/c4m"
+)
+
+// Canonicalize returns the canonical form of m: paths are normalized,
+// entries are replayed in order so later entries replace earlier ones and
+// tombstones delete what they shadow, and the survivors are sorted by path.
+// The result contains no duplicates or tombstones. m is not modified.
+//
+// Two manifests describing the same tree canonicalize to identical entry
+// lists, which makes the canonical form suitable for SnapshotID and signing.
+// The metadata stored with m (see Snapshot) is kept.
+func Canonicalize(m *c4m.Manifest) *c4m.Manifest {
+	byPath := make(map[string]*c4m.Entry, len(m.Entries))
+	for _, e := range m.Entries {
+		name := strings.TrimPrefix(cleanPath(e.Name), "/")
+		if name == "" {
+			continue
+		}
+		if e.Size == -1 {
+			delete(byPath, name)
+			continue
+		}
+		c := *e
+		c.Name = name
+		c.Depth = 0
+		byPath[name] = &c
+	}
+
+	names := make([]string, 0, len(byPath))
+	for name := range byPath {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := c4m.NewManifest()
+	result.Version = m.Version
+	result.Data = m.Data
+	for _, name := range names {
+		result.AddEntry(byPath[name])
+	}
+	return result
+}
+
+// SnapshotID returns the C4 ID identifying the tree described by m.
+// It is computed over the canonical encoding of the canonical form, so it
+// does not depend on entry order, duplicates or path spelling. The
+// canonical encoding is fixed, so IDs stay valid as the snapshot file
+// format evolves.
+func SnapshotID(m *c4m.Manifest) c4.ID {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeCanonical(pw, Canonicalize(m)))
+	}()
+	return c4.Identify(pr)
+}
+
+// canonicalVersion is the version byte of the canonical encoding. The
+// encoding is version 1 of the binary snapshot format, which holds the
+// entries alone, and must never change: SnapshotIDs are stored by
+// registries and build caches. Manifests with metadata (see Snapshot) are
+// encoded as version canonicalDataVersion instead, with no required
+// features and the record of their Data before the entries, which are
+// encoded as in version 1.
+const (
+	canonicalVersion     = 1
+	canonicalDataVersion = 5
+)
+
+// writeCanonical writes the entries of m to w in the canonical encoding.
+func writeCanonical(w io.Writer, m *c4m.Manifest) error {
+	bw := bufio.NewWriter(w)
+	bw.Write(snapshotMagic)
+	if m.Data.IsNil() {
+		bw.WriteByte(canonicalVersion)
+	} else {
+		bw.WriteByte(canonicalDataVersion)
+		bw.WriteByte(0) // No required features
+		bw.Write(appendData(nil, m.Data))
+	}
+
+	var prev string
+	var b []byte
+	for _, e := range m.Entries {
+		shared := 0
+		for shared < len(e.Name) && shared < len(prev) && e.Name[shared] == prev[shared] {
+			shared++
+		}
+		suffix := e.Name[shared:]
+
+		var flags byte
+		if !e.C4ID.IsNil() {
+			flags |= snapshotHasID
+		}
+		if e.Target != "" {
+			flags |= snapshotHasTarget
+		}
+
+		b = append(b[:0], snapshotTagEntry, flags)
+		b = binary.AppendUvarint(b, uint64(shared))
+		b = binary.AppendUvarint(b, uint64(len(suffix)))
+		b = append(b, suffix...)
+		b = binary.AppendUvarint(b, uint64(e.Mode))
+		b = binary.AppendVarint(b, e.Timestamp.UnixNano())
+		b = binary.AppendVarint(b, e.Size)
+		if flags&snapshotHasID != 0 {
+			b = append(b, e.C4ID[:]...)
+		}
+		if flags&snapshotHasTarget != 0 {
+			b = binary.AppendUvarint(b, uint64(len(e.Target)))
+			b = append(b, e.Target...)
+		}
+		prev = e.Name
+		if _, err := bw.Write(b); err != nil {
+			return err
+		}
+	}
+	bw.WriteByte(snapshotTagEnd)
+	return bw.Flush()
+}
@@ -0,0 +1,71 @@
+package c4fs
+
+import (
+	"io/fs"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/c4m"
+)
+
+func TestCanonicalize(t *testing.T) {
+	ts := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	m := c4m.NewManifest()
+	m.AddEntry(&c4m.Entry{Name: "b.txt", Size: 1, Mode: 0644, Timestamp: ts})
+	m.AddEntry(&c4m.Entry{Name: "./a//x.txt", Size: 2, Mode: 0644, Timestamp: ts})
+	m.AddEntry(&c4m.Entry{Name: "b.txt", Size: 3, Mode: 0644, Timestamp: ts}) // duplicate, wins
+	m.AddEntry(&c4m.Entry{Name: "gone.txt", Size: 4, Mode: 0644, Timestamp: ts})
+	m.AddEntry(&c4m.Entry{Name: "gone.txt", Size: -1})     // tombstone
+	m.AddEntry(&c4m.Entry{Name: "dangling.txt", Size: -1}) // dangling tombstone
+
+	c := Canonicalize(m)
+	if len(c.Entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(c.Entries))
+	}
+	if c.Entries[0].Name != "a/x.txt" || c.Entries[1].Name != "b.txt" {
+		t.Errorf("Unexpected order/names: %q, %q", c.Entries[0].Name, c.Entries[1].Name)
+	}
+	if c.Entries[1].Size != 3 {
+		t.Errorf("Later duplicate should win: got size %d", c.Entries[1].Size)
+	}
+	if len(m.Entries) != 6 {
+		t.Error("Canonicalize modified its input")
+	}
+}
+
+func TestSnapshotIDStable(t *testing.T) {
+	ts := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	a := c4m.NewManifest()
+	a.AddEntry(&c4m.Entry{Name: "x", Size: 1, Mode: 0644, Timestamp: ts})
+	a.AddEntry(&c4m.Entry{Name: "y", Size: 2, Mode: 0644, Timestamp: ts})
+
+	b := c4m.NewManifest()
+	b.AddEntry(&c4m.Entry{Name: "/y", Size: 2, Mode: 0644, Timestamp: ts})
+	b.AddEntry(&c4m.Entry{Name: "x", Size: 1, Mode: 0644, Timestamp: ts})
+
+	if SnapshotID(a) != SnapshotID(b) {
+		t.Error("Equivalent manifests have different snapshot IDs")
+	}
+
+	b.AddEntry(&c4m.Entry{Name: "z", Size: 3, Mode: 0644, Timestamp: ts})
+	if SnapshotID(a) == SnapshotID(b) {
+		t.Error("Different manifests have the same snapshot ID")
+	}
+}
+
+func TestSnapshotIDPinned(t *testing.T) {
+	// The ID must not change when the snapshot file format does
+	ts := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	m := c4m.NewManifest()
+	m.AddEntry(&c4m.Entry{Name: "src", Mode: fs.ModeDir | 0755, Timestamp: ts})
+	m.AddEntry(&c4m.Entry{Name: "src/main.go", Size: 12, Mode: 0644, Timestamp: ts,
+		C4ID: c4.Identify(strings.NewReader("package main"))})
+	m.AddEntry(&c4m.Entry{Name: "latest", Mode: fs.ModeSymlink | 0777, Timestamp: ts, Target: "src/main.go"})
+
+	const want = "c43ypK2KfrDAe3ZgcPgqGXboktpyUmCrmws6bdYeGfugzaxtJcZ1FmhtpNTHhfM7QPfrisLJp1kwwc5uehmgKDEywy"
+	if got := SnapshotID(m).String(); got != want {
+		t.Errorf("SnapshotID = %s, want %s", got, want)
+	}
+}
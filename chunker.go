@@ -0,0 +1,93 @@
+package c4fs
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+
+	"github.com/Avalanche-io/c4"
+)
+
+// Content-defined chunking parameters. Boundaries depend only on the
+// content near them, so an insertion or deletion changes the chunks around
+// the edit but not the rest of the file.
+const (
+	minChunkSize = 2 << 10
+	maxChunkSize = 64 << 10
+	chunkMask    = 1<<13 - 1 // 8 KiB average
+)
+
+// gearTable maps each byte to a pseudo-random value for the rolling hash.
+var gearTable = func() (t [256]uint64) {
+	// splitmix64, fixed seed, so boundaries are stable across releases
+	x := uint64(0x6334667363686e6b)
+	for i := range t {
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+		z = (z ^ z>>27) * 0x94d049bb133111eb
+		t[i] = z ^ z>>31
+	}
+	return t
+}()
+
+// chunker splits a stream into content-defined chunks using a gear
+// rolling hash.
+type chunker struct {
+	r   *bufio.Reader
+	buf []byte
+}
+
+func newChunker(r io.Reader) *chunker {
+	return &chunker{
+		r:   bufio.NewReaderSize(r, maxChunkSize),
+		buf: make([]byte, 0, maxChunkSize),
+	}
+}
+
+// next returns the next chunk, or io.EOF after the last one. The chunk is
+// only valid until the following call.
+func (c *chunker) next() ([]byte, error) {
+	c.buf = c.buf[:0]
+	var h uint64
+	for len(c.buf) < maxChunkSize {
+		b, err := c.r.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		c.buf = append(c.buf, b)
+		h = h<<1 + gearTable[b]
+		if len(c.buf) >= minChunkSize && h&chunkMask == 0 {
+			break
+		}
+	}
+	if len(c.buf) == 0 {
+		return nil, io.EOF
+	}
+	return c.buf, nil
+}
+
+// chunkRef identifies one chunk of a file.
+type chunkRef struct {
+	ID   c4.ID
+	Size int64
+}
+
+// chunkRefs splits the content of r into chunks and identifies them.
+func chunkRefs(r io.Reader) ([]chunkRef, error) {
+	var refs []chunkRef
+	c := newChunker(r)
+	for {
+		data, err := c.next()
+		if err == io.EOF {
+			return refs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		refs = append(refs, chunkRef{ID: c4.Identify(bytes.NewReader(data)), Size: int64(len(data))})
+	}
+}
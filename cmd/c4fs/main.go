@@ -0,0 +1,460 @@
+// Command c4fs provides maintenance tools for c4fs content stores.
+//
+// Stores are named as "kind:path", where kind is one of:
+//
+//	folder  a c4/store.Folder directory (one file per blob)
+//	pack    a c4fs.PackStore directory
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/store"
+	"github.com/absfs/c4fs"
+	"github.com/absfs/c4fs/ninep"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: c4fs <command> [flags] [args]\n\n")
+	fmt.Fprintf(os.Stderr, "commands:\n")
+	fmt.Fprintf(os.Stderr, "  migrate    copy all blobs from one store to another\n")
+	fmt.Fprintf(os.Stderr, "  backup     archive a store and its registry to a file\n")
+	fmt.Fprintf(os.Stderr, "  restore    restore an archive into a store and registry\n")
+	fmt.Fprintf(os.Stderr, "  serve9p    serve a snapshot read-only over 9P2000\n")
+	fmt.Fprintf(os.Stderr, "  push       send a snapshot's missing blobs to a remote registry\n")
+	fmt.Fprintf(os.Stderr, "  receive    accept pushes into a store and registry\n")
+	fmt.Fprintf(os.Stderr, "  inventory  list or compare the blob IDs of a store\n")
+	fmt.Fprintf(os.Stderr, "  repack     reclaim the space of removed blobs in a store\n")
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "migrate":
+		err = runMigrate(os.Args[2:])
+	case "backup":
+		err = runBackup(os.Args[2:])
+	case "restore":
+		err = runRestore(os.Args[2:])
+	case "serve9p":
+		err = runServe9P(os.Args[2:])
+	case "push":
+		err = runPush(os.Args[2:])
+	case "receive":
+		err = runReceive(os.Args[2:])
+	case "inventory":
+		err = runInventory(os.Args[2:])
+	case "repack":
+		err = runRepack(os.Args[2:])
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "c4fs: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// openStore opens a store from a "kind:path" specification.
+// The returned close function must be called when done.
+func openStore(spec string) (store.Store, func() error, error) {
+	kind, dir, ok := strings.Cut(spec, ":")
+	if !ok {
+		return nil, nil, fmt.Errorf("invalid store %q: expected kind:path", spec)
+	}
+	noop := func() error { return nil }
+
+	switch kind {
+	case "folder":
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, nil, err
+		}
+		return store.Folder(dir), noop, nil
+	case "pack":
+		ps, err := c4fs.NewPackStore(dir)
+		if err != nil {
+			return nil, nil, err
+		}
+		return ps, ps.Close, nil
+	}
+	return nil, nil, fmt.Errorf("unknown store kind %q", kind)
+}
+
+func runMigrate(args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	verify := flags.Bool("verify", true, "verify C4 IDs while copying")
+	overwrite := flags.Bool("overwrite", false, "copy blobs already present in the destination")
+	quiet := flags.Bool("q", false, "suppress progress output")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: c4fs migrate [flags] <src> <dst>\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	src, closeSrc, err := openStore(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer closeSrc()
+
+	dst, closeDst, err := openStore(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	defer closeDst()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	opts := c4fs.MigrateOptions{
+		Verify:    *verify,
+		Overwrite: *overwrite,
+	}
+	if !*quiet {
+		opts.Progress = func(p c4fs.MigrateProgress) {
+			fmt.Fprintf(os.Stderr, "\r%d/%d blobs (%d copied, %d skipped) %.1f MB/s",
+				p.Done, p.Total, p.Copied, p.Skipped, p.Throughput()/1e6)
+		}
+	}
+
+	p, err := c4fs.MigrateStore(ctx, src, dst, opts)
+	if !*quiet {
+		fmt.Fprintln(os.Stderr)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("migrated %d blobs (%d bytes) in %s\n", p.Copied, p.Bytes, p.Elapsed.Round(1e6))
+	return closeDst()
+}
+
+func runBackup(args []string) error {
+	flags := flag.NewFlagSet("backup", flag.ExitOnError)
+	refs := flags.String("registry", "", "registry reference directory to include")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: c4fs backup [flags] <store> <archive>\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	src, closeSrc, err := openStore(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer closeSrc()
+
+	var reg *c4fs.Registry
+	if *refs != "" {
+		if reg, err = c4fs.NewRegistry(c4fs.NewStoreAdapter(src), *refs); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.Create(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err := c4fs.Backup(ctx, src, reg, f); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return f.Close()
+}
+
+func runRestore(args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	refs := flags.String("registry", "", "registry reference directory to restore into")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: c4fs restore [flags] <archive> <store>\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dst, closeDst, err := openStore(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	defer closeDst()
+
+	var reg *c4fs.Registry
+	if *refs != "" {
+		if reg, err = c4fs.NewRegistry(c4fs.NewStoreAdapter(dst), *refs); err != nil {
+			return err
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err := c4fs.Restore(ctx, f, dst, reg); err != nil {
+		return err
+	}
+	return closeDst()
+}
+
+func runServe9P(args []string) error {
+	flags := flag.NewFlagSet("serve9p", flag.ExitOnError)
+	addr := flags.String("addr", ":5640", "address to listen on")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: c4fs serve9p [flags] <store> <snapshot>\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	st, closeStore, err := openStore(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+
+	f, err := os.Open(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	fsys, err := c4fs.OpenSnapshot(f, c4fs.NewStoreAdapter(st))
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	l, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+
+	fmt.Fprintf(os.Stderr, "serving %s on %s\n", flags.Arg(1), l.Addr())
+	if err := ninep.NewServer(fsys).Serve(l); ctx.Err() == nil {
+		return err
+	}
+	return nil
+}
+
+func runPush(args []string) error {
+	flags := flag.NewFlagSet("push", flag.ExitOnError)
+	base := flags.String("base", "", "snapshot ID the remote already has")
+	ref := flags.String("ref", "", "reference to set on the remote")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: c4fs push [flags] <store> <registry> <snapshot-id> <addr>\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 4 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	snapshot, err := c4.Parse(flags.Arg(2))
+	if err != nil {
+		return fmt.Errorf("invalid snapshot ID: %w", err)
+	}
+	opts := c4fs.PushOptions{Ref: *ref}
+	if *base != "" {
+		if opts.Base, err = c4.Parse(*base); err != nil {
+			return fmt.Errorf("invalid base snapshot ID: %w", err)
+		}
+	}
+
+	st, closeStore, err := openStore(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+	reg, err := c4fs.NewRegistry(c4fs.NewStoreAdapter(st), flags.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.Dial("tcp", flags.Arg(3))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	stats, err := c4fs.Push(ctx, reg, snapshot, conn, opts)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("sent %d of %d blobs (%d bytes)\n", stats.Sent, stats.Offered, stats.Bytes)
+	return nil
+}
+
+func runReceive(args []string) error {
+	flags := flag.NewFlagSet("receive", flag.ExitOnError)
+	addr := flags.String("addr", ":5641", "address to listen on")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: c4fs receive [flags] <store> <registry>\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	st, closeStore, err := openStore(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+	reg, err := c4fs.NewRegistry(c4fs.NewStoreAdapter(st), flags.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	l, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+
+	fmt.Fprintf(os.Stderr, "receiving on %s\n", l.Addr())
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		// Sessions are handled one at a time; stores need not be safe
+		// for concurrent writers
+		if err := reg.ServePush(ctx, conn); err != nil {
+			fmt.Fprintf(os.Stderr, "push from %s: %v\n", conn.RemoteAddr(), err)
+		}
+		conn.Close()
+	}
+}
+
+func runInventory(args []string) error {
+	flags := flag.NewFlagSet("inventory", flag.ExitOnError)
+	compare := flags.String("compare", "", "inventory file to compare the store with")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: c4fs inventory [flags] <store>\n\n")
+		fmt.Fprintf(os.Stderr, "Writes the IDs of every blob in the store to standard output, one\n")
+		fmt.Fprintf(os.Stderr, "per line. With -compare, writes \"+ ID\" for blobs missing from the\n")
+		fmt.Fprintf(os.Stderr, "file and \"- ID\" for those missing from the store instead.\n\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	src, closeSrc, err := openStore(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer closeSrc()
+
+	if *compare == "" {
+		_, err := c4fs.WriteInventory(os.Stdout, src)
+		return err
+	}
+
+	f, err := os.Open(*compare)
+	if err != nil {
+		return err
+	}
+	want, err := c4fs.ReadInventory(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	have, err := c4fs.ListIDs(src)
+	if err != nil {
+		return err
+	}
+	extra, missing := c4fs.CompareInventory(have, want)
+	for _, id := range extra {
+		fmt.Printf("+ %s\n", id)
+	}
+	for _, id := range missing {
+		fmt.Printf("- %s\n", id)
+	}
+	if len(extra) > 0 || len(missing) > 0 {
+		return fmt.Errorf("store differs from %s: %d extra, %d missing", *compare, len(extra), len(missing))
+	}
+	return nil
+}
+
+func runRepack(args []string) error {
+	flags := flag.NewFlagSet("repack", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: c4fs repack <store>\n\n")
+		fmt.Fprintf(os.Stderr, "Reclaims the space of removed blobs and reports how much was freed.\n")
+		fmt.Fprintf(os.Stderr, "Pack stores rewrite their live blobs into fresh packs and delete the\n")
+		fmt.Fprintf(os.Stderr, "old ones; folder stores have nothing to reclaim.\n")
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	src, closeSrc, err := openStore(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer closeSrc()
+
+	reclaimed, err := c4fs.CompactStore(src)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("reclaimed %d bytes\n", reclaimed)
+	return closeSrc()
+}
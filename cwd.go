@@ -0,0 +1,61 @@
+package c4fs
+
+import (
+	"io/fs"
+	"path"
+	"strings"
+	"syscall"
+)
+
+// The working directory is always an absolute, clean, slash-separated
+// path: "/" for the root, "/a/b" below it. Other methods resolve names
+// against the root whether or not they have a leading slash, so callers
+// that track a working directory join names with Getwd themselves, as
+// with path.Join(wd, name).
+
+// Chdir changes the working directory to dir. A relative dir is resolved
+// against the current working directory; ".." never leaves the root. The
+// directory must exist; symbolic links to directories are followed but
+// kept in the working directory, as the shell's cd does.
+func (c4fs *FS) Chdir(dir string) error {
+	c4fs.mu.RLock()
+	wd := c4fs.absPath(dir)
+	c4fs.mu.RUnlock()
+
+	info, err := c4fs.Stat(strings.TrimPrefix(wd, "/"))
+	if err != nil {
+		return &fs.PathError{Op: "chdir", Path: dir, Err: fs.ErrNotExist}
+	}
+	if !info.IsDir() {
+		return &fs.PathError{Op: "chdir", Path: dir, Err: syscall.ENOTDIR}
+	}
+
+	c4fs.mu.Lock()
+	defer c4fs.mu.Unlock()
+	if wd == "/" {
+		wd = ""
+	}
+	c4fs.cwd = wd
+	return nil
+}
+
+// Getwd returns the absolute path of the working directory, "/" until
+// Chdir is called.
+func (c4fs *FS) Getwd() (string, error) {
+	c4fs.mu.RLock()
+	defer c4fs.mu.RUnlock()
+	if c4fs.cwd == "" {
+		return "/", nil
+	}
+	return c4fs.cwd, nil
+}
+
+// absPath resolves name against the working directory and returns it in
+// canonical absolute form. The caller must hold c4fs.mu.
+func (c4fs *FS) absPath(name string) string {
+	name = cleanPath(name)
+	if !strings.HasPrefix(name, "/") {
+		name = path.Join("/", c4fs.cwd, name)
+	}
+	return name
+}
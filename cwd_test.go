@@ -0,0 +1,72 @@
+package c4fs
+
+import (
+	"errors"
+	"io/fs"
+	"path"
+	"testing"
+
+	"github.com/Avalanche-io/c4/store"
+)
+
+func TestGetwd(t *testing.T) {
+	c4fs := New(NewStoreAdapter(store.NewRAM()))
+	if err := c4fs.MkdirAll("testdir/sub", 0755); err != nil {
+		t.Fatalf("MkdirAll failed: %v", err)
+	}
+	if err := c4fs.WriteFile("testdir/file.txt", []byte("x"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if err := c4fs.Symlink("testdir/sub", "link"); err != nil {
+		t.Fatalf("Symlink failed: %v", err)
+	}
+
+	if wd, _ := c4fs.Getwd(); wd != "/" {
+		t.Errorf("initial Getwd = %q, want /", wd)
+	}
+
+	tests := []struct {
+		dir  string
+		want string
+	}{
+		{"testdir", "/testdir"},
+		{"sub/", "/testdir/sub"},
+		{"..", "/testdir"},
+		{"/testdir/./sub", "/testdir/sub"},
+		{"../../..", "/"},
+		{"/", "/"},
+		{"link", "/link"},
+		{".", "/link"},
+		{"", "/link"},
+	}
+	for _, tt := range tests {
+		if err := c4fs.Chdir(tt.dir); err != nil {
+			t.Fatalf("Chdir(%q) failed: %v", tt.dir, err)
+		}
+		wd, err := c4fs.Getwd()
+		if err != nil || wd != tt.want {
+			t.Errorf("Getwd after Chdir(%q) = %q, %v; want %q", tt.dir, wd, err, tt.want)
+		}
+	}
+
+	// Joining the working directory with a relative name reaches the file
+	c4fs.Chdir("/testdir")
+	wd, _ := c4fs.Getwd()
+	if _, err := c4fs.Stat(path.Join(wd, "file.txt")); err != nil {
+		t.Errorf("Stat of joined path failed: %v", err)
+	}
+
+	if err := c4fs.Chdir("missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Chdir(missing) = %v, want ErrNotExist", err)
+	}
+	if err := c4fs.Chdir("file.txt"); err == nil {
+		t.Error("Chdir to a file succeeded")
+	}
+	if wd, _ := c4fs.Getwd(); wd != "/testdir" {
+		t.Errorf("Getwd after failed Chdir = %q, want /testdir", wd)
+	}
+
+	if wd, _ := c4fs.Clone().Getwd(); wd != "/" {
+		t.Errorf("Clone Getwd = %q, want /", wd)
+	}
+}
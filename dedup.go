@@ -0,0 +1,58 @@
+package c4fs
+
+import (
+	"sort"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/c4m"
+)
+
+// DuplicateGroup is a set of files with identical content.
+type DuplicateGroup struct {
+	ID    c4.ID
+	Size  int64    // Size of each copy
+	Paths []string // Sorted
+}
+
+// Redundant returns the bytes taken up by all but one copy.
+func (g DuplicateGroup) Redundant() int64 {
+	return g.Size * int64(len(g.Paths)-1)
+}
+
+// Duplicates returns groups of regular files that share a C4 ID, ignoring
+// files smaller than minSize. Empty files are never reported. Groups are
+// ordered by Redundant, largest first, so the copies worth deduplicating
+// come first.
+func (c4fs *FS) Duplicates(minSize int64) []DuplicateGroup {
+	minSize = max(minSize, 1)
+
+	byID := make(map[c4.ID]*DuplicateGroup)
+	c4fs.walkFlattened(func(e *c4m.Entry) error {
+		if !e.Mode.IsRegular() || e.C4ID.IsNil() || e.Size < minSize {
+			return nil
+		}
+		g, ok := byID[e.C4ID]
+		if !ok {
+			g = &DuplicateGroup{ID: e.C4ID, Size: e.Size}
+			byID[e.C4ID] = g
+		}
+		g.Paths = append(g.Paths, e.Name)
+		return nil
+	})
+
+	var groups []DuplicateGroup
+	for _, g := range byID {
+		if len(g.Paths) < 2 {
+			continue
+		}
+		sort.Strings(g.Paths)
+		groups = append(groups, *g)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if ri, rj := groups[i].Redundant(), groups[j].Redundant(); ri != rj {
+			return ri > rj
+		}
+		return groups[i].Paths[0] < groups[j].Paths[0]
+	})
+	return groups
+}
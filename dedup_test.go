@@ -0,0 +1,55 @@
+package c4fs
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Avalanche-io/c4/store"
+)
+
+// TestC4FSDuplicates tests grouping of files by content.
+func TestC4FSDuplicates(t *testing.T) {
+	c4fs := New(NewStoreAdapter(store.NewRAM()))
+	c4fs.MkdirAll("a/b", 0755)
+
+	big := strings.Repeat("big", 100)
+	files := map[string]string{
+		"one.txt":     "small",
+		"a/two.txt":   "small",
+		"a/b/big1":    big,
+		"a/b/big2":    big,
+		"a/big3":      big,
+		"unique.txt":  "unique",
+		"empty1":      "",
+		"empty2":      "",
+		"shadowed":    "small",
+		"removed.txt": big,
+	}
+	for name, data := range files {
+		if err := c4fs.WriteFile(name, []byte(data), 0644); err != nil {
+			t.Fatalf("WriteFile %s failed: %v", name, err)
+		}
+	}
+	c4fs.WriteFile("shadowed", []byte("changed"), 0644)
+	c4fs.Remove("removed.txt")
+
+	groups := c4fs.Duplicates(0)
+	if len(groups) != 2 {
+		t.Fatalf("got %d groups, want 2: %+v", len(groups), groups)
+	}
+
+	if got := strings.Join(groups[0].Paths, ","); got != "a/b/big1,a/b/big2,a/big3" {
+		t.Errorf("first group paths: got %s", got)
+	}
+	if groups[0].Redundant() != int64(2*len(big)) {
+		t.Errorf("first group redundant bytes: got %d", groups[0].Redundant())
+	}
+	if got := strings.Join(groups[1].Paths, ","); got != "a/two.txt,one.txt" {
+		t.Errorf("second group paths: got %s", got)
+	}
+
+	groups = c4fs.Duplicates(int64(len(big)))
+	if len(groups) != 1 || groups[0].Size != int64(len(big)) {
+		t.Errorf("with minimum size: got %+v", groups)
+	}
+}
@@ -6,8 +6,10 @@ import (
 	"io"
 	"io/fs"
 	"path"
+	"syscall"
 	"time"
 
+	"github.com/Avalanche-io/c4"
 	"github.com/Avalanche-io/c4/c4m"
 )
 
@@ -18,23 +20,58 @@ type dehydratingFile struct {
 	perm fs.FileMode
 	buf  *bytes.Buffer
 	pos  int64
+
+	appending bool   // Opened with O_APPEND on an existing file
+	dirty     bool   // Content changed since open
+	release   func() // called on Close
+
+	guarded bool  // Close fails if the file no longer has ifMatch
+	ifMatch c4.ID // C4 ID the file must have on Close; nil if absent
 }
 
 // newDehydratingFile creates a new file for writing.
 func newDehydratingFile(c4fs *FS, name string, perm fs.FileMode) (*dehydratingFile, error) {
-	return &dehydratingFile{
-		c4fs: c4fs,
-		name: path.Clean(name),
-		perm: perm,
-		buf:  new(bytes.Buffer),
-		pos:  0,
-	}, nil
+	if c4fs.isClosed() {
+		return nil, closedError("open", name)
+	}
+	f := &dehydratingFile{
+		c4fs:    c4fs,
+		name:    path.Clean(name),
+		perm:    perm,
+		buf:     new(bytes.Buffer),
+		pos:     0,
+		release: c4fs.trackHandle(name, true),
+	}
+	if c4fs.staleGuard {
+		f.guarded, f.ifMatch = true, c4fs.currentID(f.name)
+	}
+	return f, nil
 }
 
 // Write writes data to the buffer.
 func (f *dehydratingFile) Write(p []byte) (int, error) {
+	if f.c4fs.isClosed() {
+		return 0, closedError("write", f.name)
+	}
 	n, err := f.buf.Write(p)
 	f.pos += int64(n)
+	if n > 0 {
+		f.dirty = true
+	}
+	return n, err
+}
+
+// ReadFrom implements io.ReaderFrom, reading r directly into the buffer
+// so io.Copy into the file skips its intermediate copy buffer.
+func (f *dehydratingFile) ReadFrom(r io.Reader) (int64, error) {
+	if f.c4fs.isClosed() {
+		return 0, closedError("write", f.name)
+	}
+	n, err := f.buf.ReadFrom(r)
+	f.pos += n
+	if n > 0 {
+		f.dirty = true
+	}
 	return n, err
 }
 
@@ -57,7 +94,7 @@ func (f *dehydratingFile) Read(p []byte) (int, error) {
 	return 0, &fs.PathError{
 		Op:   "read",
 		Path: f.name,
-		Err:  fmt.Errorf("file opened for writing"),
+		Err:  syscall.EBADF,
 	}
 }
 
@@ -95,12 +132,12 @@ func (f *dehydratingFile) Sync() error {
 	return nil
 }
 
-// ReadDir is not supported on write-only files.
+// ReadDir fails: the file is not a directory.
 func (f *dehydratingFile) ReadDir(n int) ([]fs.DirEntry, error) {
 	return nil, &fs.PathError{
 		Op:   "readdir",
 		Path: f.name,
-		Err:  fmt.Errorf("file opened for writing"),
+		Err:  syscall.ENOTDIR,
 	}
 }
 
@@ -109,12 +146,16 @@ func (f *dehydratingFile) ReadAt(p []byte, off int64) (int, error) {
 	return 0, &fs.PathError{
 		Op:   "read",
 		Path: f.name,
-		Err:  fmt.Errorf("file opened for writing"),
+		Err:  syscall.EBADF,
 	}
 }
 
 // Truncate changes the size of the file.
 func (f *dehydratingFile) Truncate(size int64) error {
+	if f.c4fs.isClosed() {
+		return closedError("truncate", f.name)
+	}
+	f.dirty = true
 	if size == 0 {
 		f.buf.Reset()
 		f.pos = 0
@@ -134,12 +175,12 @@ func (f *dehydratingFile) Truncate(size int64) error {
 	return nil
 }
 
-// Readdirnames is not supported on write-only files.
+// Readdirnames fails: the file is not a directory.
 func (f *dehydratingFile) Readdirnames(n int) ([]string, error) {
 	return nil, &fs.PathError{
 		Op:   "readdirnames",
 		Path: f.name,
-		Err:  fmt.Errorf("file opened for writing"),
+		Err:  syscall.ENOTDIR,
 	}
 }
 
@@ -149,7 +190,20 @@ func (f *dehydratingFile) Name() string {
 }
 
 // Close dehydrates the buffered content to the store and updates the manifest.
-func (f *dehydratingFile) Close() error {
+// After Shutdown the content is discarded.
+func (f *dehydratingFile) Close() (err error) {
+	defer f.c4fs.startOp("close", f.name).done(int64(f.buf.Len()), &err)
+
+	f.release()
+	if f.c4fs.isClosed() {
+		return closedError("close", f.name)
+	}
+
+	// An append handle that wrote nothing leaves the existing entry as is
+	if f.appending && !f.dirty {
+		return nil
+	}
+
 	// Get buffered data
 	data := f.buf.Bytes()
 
@@ -173,8 +227,12 @@ func (f *dehydratingFile) Close() error {
 	}
 
 	f.c4fs.mu.Lock()
+	defer f.c4fs.mu.Unlock()
+	if f.guarded {
+		if actual := f.c4fs.currentIDLocked(f.name); actual != f.ifMatch {
+			return &WriteConflictError{Path: f.name, Expected: f.ifMatch, Actual: actual}
+		}
+	}
 	f.c4fs.updateEntryInLayer(entry)
-	f.c4fs.mu.Unlock()
-
 	return nil
 }
@@ -0,0 +1,159 @@
+package c4fs
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Avalanche-io/c4"
+)
+
+// The dehydration journal records which staged blobs have not been
+// uploaded yet, one record per line: "+ <id>" when a blob is staged and
+// "- <id>" when it is uploaded or removed. Staging records are synced
+// before the write that staged the blob returns, so after a crash the
+// journal lists every blob that may still need uploading.
+
+// Recover resumes the uploads recorded as pending in the dehydration
+// journal, typically by a process that crashed or exited before Flush.
+// Blobs that are no longer in staging are skipped. It returns the number
+// of uploads resumed, and compacts the journal. Without a journal it does
+// nothing.
+func (c4fs *FS) Recover() (int, error) {
+	q := c4fs.dehydration
+	if q == nil || q.journalPath == "" {
+		return 0, nil
+	}
+	return q.recover()
+}
+
+func (q *dehydrationQueue) recover() (int, error) {
+	pending, err := readJournal(q.journalPath)
+	if err != nil {
+		return 0, err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	staging := NewStoreAdapter(q.staging)
+	resumed := 0
+	for _, id := range pending {
+		if q.staged[id] || !staging.Has(id) {
+			continue
+		}
+		q.staged[id] = true
+		q.order = append(q.order, id)
+		resumed++
+	}
+	if err := q.compactJournalLocked(); err != nil {
+		return resumed, err
+	}
+	q.startLocked()
+	return resumed, nil
+}
+
+// readJournal returns the IDs a journal lists as pending, in the order
+// they were staged. A missing journal lists nothing.
+func readJournal(path string) ([]c4.ID, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var order []c4.ID
+	pending := make(map[c4.ID]bool)
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		fields := strings.Fields(sc.Text())
+		if len(fields) != 2 {
+			continue // torn final write
+		}
+		id, err := c4.Parse(fields[1])
+		if err != nil {
+			continue // torn final write
+		}
+		switch fields[0] {
+		case "+":
+			if !pending[id] {
+				order = append(order, id)
+			}
+			pending[id] = true
+		case "-":
+			delete(pending, id)
+		default:
+			return nil, fmt.Errorf("dehydration journal: line %d: unknown record %q", line, fields[0])
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	ids := order[:0]
+	for _, id := range order {
+		if pending[id] {
+			ids = append(ids, id)
+			delete(pending, id) // staged again after an upload
+		}
+	}
+	return ids, nil
+}
+
+// recordLocked appends a record to the journal, if there is one. Staging
+// records are synced to disk. The caller must hold q.mu.
+func (q *dehydrationQueue) recordLocked(op byte, id c4.ID) error {
+	if q.journalPath == "" {
+		return nil
+	}
+	if q.journal == nil {
+		f, err := os.OpenFile(q.journalPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		q.journal = f
+	}
+	if _, err := fmt.Fprintf(q.journal, "%c %s\n", op, id); err != nil {
+		return err
+	}
+	if op == '+' {
+		return q.journal.Sync()
+	}
+	return nil
+}
+
+// compactJournalLocked rewrites the journal to list only the blobs
+// currently staged. The caller must hold q.mu.
+func (q *dehydrationQueue) compactJournalLocked() error {
+	tmp, err := os.CreateTemp(filepath.Dir(q.journalPath), ".journal-*")
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(tmp)
+	for id := range q.staged {
+		fmt.Fprintf(bw, "+ %s\n", id)
+	}
+	err = bw.Flush()
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if q.journal != nil {
+		q.journal.Close()
+		q.journal = nil
+	}
+	return os.Rename(tmp.Name(), q.journalPath)
+}
@@ -0,0 +1,368 @@
+package c4fs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/store"
+)
+
+// dehydrationRetry is how long a failed background upload waits before
+// it is retried.
+const dehydrationRetry = 30 * time.Second
+
+// dehydrationErrorBuffer is the number of errors kept for
+// DehydrationErrors before further errors are dropped.
+const dehydrationErrorBuffer = 64
+
+// DehydrationError reports a failed background upload of a blob. The blob
+// stays staged, readable, and queued for a retry.
+type DehydrationError struct {
+	ID  c4.ID
+	Err error
+}
+
+func (e *DehydrationError) Error() string {
+	return fmt.Sprintf("failed to dehydrate %s: %v", e.ID, e.Err)
+}
+
+func (e *DehydrationError) Unwrap() error {
+	return e.Err
+}
+
+// Flush waits until every staged blob has been uploaded to the store,
+// retrying failed uploads immediately. It returns the errors of uploads
+// that failed again, or ctx.Err() if ctx is done first. Without
+// asynchronous dehydration it returns nil.
+func (c4fs *FS) Flush(ctx context.Context) error {
+	if c4fs.dehydration == nil {
+		return nil
+	}
+	return c4fs.dehydration.flush(ctx)
+}
+
+// PendingDehydrations returns the number of blobs staged but not yet
+// uploaded to the store.
+func (c4fs *FS) PendingDehydrations() int {
+	if c4fs.dehydration == nil {
+		return 0
+	}
+	c4fs.dehydration.mu.Lock()
+	defer c4fs.dehydration.mu.Unlock()
+	return len(c4fs.dehydration.staged)
+}
+
+// DehydrationErrors returns a channel reporting failed background uploads
+// as *DehydrationError values. Errors are dropped if the channel is not
+// read. Without asynchronous dehydration the channel is nil.
+func (c4fs *FS) DehydrationErrors() <-chan error {
+	if c4fs.dehydration == nil {
+		return nil
+	}
+	return c4fs.dehydration.errs
+}
+
+// dehydrationQueue is the store of a filesystem using asynchronous
+// dehydration. New content is written to a local staging store and
+// uploaded to the remote store by a background worker, which runs while
+// there is work queued. Reads are served from staging until the upload is
+// done.
+type dehydrationQueue struct {
+	staging store.Store
+	remote  *StoreAdapter
+	errs    chan error
+	retry   time.Duration
+
+	mu     sync.Mutex
+	idle   sync.Cond       // broadcast when the worker stops
+	staged map[c4.ID]bool  // blobs in staging not yet uploaded
+	order  []c4.ID         // uploads to attempt, oldest first
+	failed map[c4.ID]error // uploads waiting for a retry
+	active bool            // worker running
+	timer  *time.Timer     // pending retry, or nil
+
+	journalPath string   // empty if not journaled
+	journal     *os.File // opened on first record
+}
+
+var (
+	_ store.Store   = (*dehydrationQueue)(nil)
+	_ PutIfAbsenter = (*dehydrationQueue)(nil)
+	_ RangeOpener   = (*dehydrationQueue)(nil)
+	_ BlobLister    = (*dehydrationQueue)(nil)
+	_ BlobStater    = (*dehydrationQueue)(nil)
+	_ BlobChecker   = (*dehydrationQueue)(nil)
+	_ HealthChecker = (*dehydrationQueue)(nil)
+)
+
+func newDehydrationQueue(staging store.Store, remote *StoreAdapter) *dehydrationQueue {
+	q := &dehydrationQueue{
+		staging: staging,
+		remote:  remote,
+		errs:    make(chan error, dehydrationErrorBuffer),
+		retry:   dehydrationRetry,
+		staged:  make(map[c4.ID]bool),
+		failed:  make(map[c4.ID]error),
+	}
+	q.idle.L = &q.mu
+	return q
+}
+
+// PutIfAbsent stages the content and queues it for upload, unless it is
+// already queued. The remote store is not contacted.
+func (q *dehydrationQueue) PutIfAbsent(id c4.ID, r io.Reader) (bool, error) {
+	q.mu.Lock()
+	queued := q.staged[id]
+	q.mu.Unlock()
+	if queued {
+		return false, nil
+	}
+
+	if _, err := NewStoreAdapter(q.staging).PutIfAbsent(id, r); err != nil {
+		return false, fmt.Errorf("failed to stage content: %w", err)
+	}
+	return true, q.enqueue(id)
+}
+
+// Open opens the blob from staging, or from the remote store once it has
+// been uploaded.
+func (q *dehydrationQueue) Open(id c4.ID) (io.ReadCloser, error) {
+	if rc, err := q.staging.Open(id); err == nil {
+		return rc, nil
+	}
+	return q.remote.Get(id)
+}
+
+// OpenRange opens part of the blob from staging or the remote store.
+func (q *dehydrationQueue) OpenRange(id c4.ID, off, length int64) (io.ReadCloser, error) {
+	if rc, err := NewStoreAdapter(q.staging).GetRange(id, off, length); err == nil {
+		return rc, nil
+	}
+	return q.remote.GetRange(id, off, length)
+}
+
+// Create returns a writer staging the blob; it is queued for upload when
+// the writer is closed.
+func (q *dehydrationQueue) Create(id c4.ID) (io.WriteCloser, error) {
+	wc, err := q.staging.Create(id)
+	if err != nil {
+		return nil, err
+	}
+	return &stagingWriter{WriteCloser: wc, queue: q, id: id}, nil
+}
+
+// Remove drops the blob from staging and the queue, and removes it from
+// the remote store.
+func (q *dehydrationQueue) Remove(id c4.ID) error {
+	q.mu.Lock()
+	staged := q.staged[id]
+	if staged {
+		q.recordLocked('-', id)
+	}
+	delete(q.staged, id)
+	delete(q.failed, id)
+	q.mu.Unlock()
+
+	if staged {
+		q.staging.Remove(id)
+	}
+	err := q.remote.Delete(id)
+	if staged && errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// HasBlob reports whether the blob is staged or in the remote store.
+func (q *dehydrationQueue) HasBlob(id c4.ID) bool {
+	return NewStoreAdapter(q.staging).Has(id) || q.remote.Has(id)
+}
+
+// StatBlob describes the blob in staging or the remote store.
+func (q *dehydrationQueue) StatBlob(id c4.ID) (BlobInfo, error) {
+	if info, err := NewStoreAdapter(q.staging).Stat(id); err == nil {
+		return info, nil
+	}
+	return q.remote.Stat(id)
+}
+
+// ListIDs lists the staged and uploaded blobs.
+func (q *dehydrationQueue) ListIDs() ([]c4.ID, error) {
+	ids, err := ListIDs(q.remote.store)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[c4.ID]bool, len(ids))
+	for _, id := range ids {
+		seen[id] = true
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for id := range q.staged {
+		if !seen[id] {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// Ping checks the remote store.
+func (q *dehydrationQueue) Ping(ctx context.Context) error {
+	return q.remote.Ping(ctx)
+}
+
+// Offline reports whether the blob is offline in the remote store. Staged
+// blobs are always online.
+func (q *dehydrationQueue) Offline(id c4.ID) bool {
+	return !NewStoreAdapter(q.staging).Has(id) && q.remote.Offline(id)
+}
+
+// Recall brings the blob back online in the remote store.
+func (q *dehydrationQueue) Recall(ctx context.Context, id c4.ID) error {
+	if NewStoreAdapter(q.staging).Has(id) {
+		return nil
+	}
+	return q.remote.Recall(ctx, id)
+}
+
+// enqueue queues a staged blob for upload and starts the worker. With a
+// journal, it returns once the blob is durably recorded as pending.
+func (q *dehydrationQueue) enqueue(id c4.ID) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.staged[id] {
+		return nil
+	}
+	if err := q.recordLocked('+', id); err != nil {
+		return fmt.Errorf("failed to journal %s: %w", id, err)
+	}
+	q.staged[id] = true
+	q.order = append(q.order, id)
+	q.startLocked()
+	return nil
+}
+
+// startLocked starts the worker if there is work and it is not running.
+// The caller must hold q.mu.
+func (q *dehydrationQueue) startLocked() {
+	if !q.active && len(q.order) > 0 {
+		q.active = true
+		go q.run()
+	}
+}
+
+// requeueLocked moves failed uploads back to the queue.
+// The caller must hold q.mu.
+func (q *dehydrationQueue) requeueLocked() {
+	if q.timer != nil {
+		q.timer.Stop()
+		q.timer = nil
+	}
+	for id := range q.failed {
+		q.order = append(q.order, id)
+	}
+	clear(q.failed)
+}
+
+// run uploads queued blobs until the queue is empty, then schedules a
+// retry of the failed ones.
+func (q *dehydrationQueue) run() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.order) > 0 {
+		id := q.order[0]
+		q.order = q.order[1:]
+		if !q.staged[id] {
+			continue // removed
+		}
+
+		q.mu.Unlock()
+		err := q.upload(id)
+		q.mu.Lock()
+
+		if !q.staged[id] {
+			continue
+		}
+		if err != nil {
+			q.failed[id] = err
+			select {
+			case q.errs <- &DehydrationError{ID: id, Err: err}:
+			default:
+			}
+			continue
+		}
+		// Record the upload before the staged copy goes away
+		q.recordLocked('-', id)
+		delete(q.staged, id)
+		q.staging.Remove(id)
+	}
+
+	q.active = false
+	if len(q.failed) > 0 && q.timer == nil {
+		q.timer = time.AfterFunc(q.retry, func() {
+			q.mu.Lock()
+			defer q.mu.Unlock()
+			q.timer = nil
+			q.requeueLocked()
+			q.startLocked()
+		})
+	}
+	q.idle.Broadcast()
+}
+
+// upload copies a staged blob to the remote store, unless it is there
+// already.
+func (q *dehydrationQueue) upload(id c4.ID) error {
+	if q.remote.Has(id) {
+		return nil
+	}
+	_, err := migrateBlob(q.staging, q.remote.store, id, false, false)
+	return err
+}
+
+func (q *dehydrationQueue) flush(ctx context.Context) error {
+	stop := context.AfterFunc(ctx, func() {
+		q.mu.Lock()
+		q.idle.Broadcast()
+		q.mu.Unlock()
+	})
+	defer stop()
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.requeueLocked()
+	q.startLocked()
+	for q.active {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		q.idle.Wait()
+	}
+
+	var errs []error
+	for id, err := range q.failed {
+		errs = append(errs, &DehydrationError{ID: id, Err: err})
+	}
+	return errors.Join(errs...)
+}
+
+// stagingWriter queues its blob for upload once it is staged.
+type stagingWriter struct {
+	io.WriteCloser
+	queue *dehydrationQueue
+	id    c4.ID
+}
+
+func (w *stagingWriter) Close() error {
+	if err := w.WriteCloser.Close(); err != nil {
+		return err
+	}
+	return w.queue.enqueue(w.id)
+}
@@ -0,0 +1,163 @@
+package c4fs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/store"
+)
+
+func TestAsyncDehydration(t *testing.T) {
+	remote := &flakyStore{syncRAM: newSyncRAM()}
+	remote.down.Store(true)
+	staging := newSyncRAM()
+	fsys := New(NewStoreAdapter(remote), WithAsyncDehydration(staging))
+
+	// Writes succeed and are readable while the remote store is down
+	if err := fsys.WriteFile("a.txt", []byte("alpha"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	f, err := fsys.Create("b.txt")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	f.Write([]byte("bravo"))
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	for name, want := range map[string]string{"a.txt": "alpha", "b.txt": "bravo"} {
+		got, err := fsys.ReadFile(name)
+		if err != nil || string(got) != want {
+			t.Fatalf("ReadFile(%s) = %q, %v; want %q", name, got, err, want)
+		}
+	}
+
+	select {
+	case err := <-fsys.DehydrationErrors():
+		var de *DehydrationError
+		if !errors.As(err, &de) {
+			t.Errorf("got %T, want *DehydrationError", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no dehydration error reported")
+	}
+	if err := fsys.Flush(context.Background()); err == nil {
+		t.Error("Flush succeeded with the remote store down")
+	}
+	if n := fsys.PendingDehydrations(); n != 2 {
+		t.Errorf("PendingDehydrations = %d, want 2", n)
+	}
+
+	remote.down.Store(false)
+	if err := fsys.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if n := fsys.PendingDehydrations(); n != 0 {
+		t.Errorf("PendingDehydrations = %d after Flush, want 0", n)
+	}
+	if n := len(*staging.ram); n != 0 {
+		t.Errorf("staging holds %d blobs after Flush, want 0", n)
+	}
+	for name, want := range map[string]string{"a.txt": "alpha", "b.txt": "bravo"} {
+		e, _ := fsys.getEntry(name)
+		if !NewStoreAdapter(remote).Has(e.C4ID) {
+			t.Errorf("%s was not uploaded", name)
+		}
+		got, err := fsys.ReadFile(name)
+		if err != nil || string(got) != want {
+			t.Errorf("ReadFile(%s) after Flush = %q, %v; want %q", name, got, err, want)
+		}
+	}
+}
+
+func TestAsyncDehydrationRetries(t *testing.T) {
+	remote := &flakyStore{syncRAM: newSyncRAM()}
+	remote.down.Store(true)
+	fsys := New(NewStoreAdapter(remote), WithAsyncDehydration(newSyncRAM()))
+	fsys.dehydration.retry = 10 * time.Millisecond
+
+	if err := fsys.WriteFile("a.txt", []byte("alpha"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	<-fsys.DehydrationErrors()
+	remote.down.Store(false)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for fsys.PendingDehydrations() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("failed upload was not retried")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestFlushSynchronous(t *testing.T) {
+	fsys := New(NewStoreAdapter(newSyncRAM()))
+	if err := fsys.Flush(context.Background()); err != nil {
+		t.Errorf("Flush failed: %v", err)
+	}
+	if fsys.DehydrationErrors() != nil {
+		t.Error("DehydrationErrors is not nil without async dehydration")
+	}
+}
+
+func TestDehydrationJournalRecover(t *testing.T) {
+	dir := t.TempDir()
+	staging := store.Folder(filepath.Join(dir, "staging"))
+	os.Mkdir(string(staging), 0755)
+	journal := filepath.Join(dir, "journal")
+
+	remote := &flakyStore{syncRAM: newSyncRAM()}
+	remote.down.Store(true)
+	crashed := New(NewStoreAdapter(remote), WithAsyncDehydration(staging), WithDehydrationJournal(journal))
+	crashed.dehydration.retry = time.Hour
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if err := crashed.WriteFile(name, []byte("content of "+name), 0644); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+	}
+	if err := crashed.Flush(context.Background()); err == nil {
+		t.Fatal("Flush succeeded with the remote store down")
+	}
+
+	// Simulate a torn write at the time of the crash
+	f, _ := os.OpenFile(journal, os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString("+ c4")
+	f.Close()
+
+	remote.down.Store(false)
+	restarted := New(NewStoreAdapter(remote), WithAsyncDehydration(staging), WithDehydrationJournal(journal))
+	n, err := restarted.Recover()
+	if err != nil {
+		t.Fatalf("Recover failed: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("Recover resumed %d uploads, want 2", n)
+	}
+	if err := restarted.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	for _, name := range []string{"a.txt", "b.txt"} {
+		id := c4.Identify(strings.NewReader("content of " + name))
+		if !NewStoreAdapter(remote).Has(id) {
+			t.Errorf("%s was not uploaded after recovery", name)
+		}
+	}
+
+	pending, err := readJournal(journal)
+	if err != nil {
+		t.Fatalf("readJournal failed: %v", err)
+	}
+	if len(pending) != 0 {
+		t.Errorf("journal lists %d pending uploads after Flush, want 0", len(pending))
+	}
+	if n, _ := restarted.Recover(); n != 0 {
+		t.Errorf("second Recover resumed %d uploads, want 0", n)
+	}
+}
@@ -0,0 +1,159 @@
+package c4fs
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Avalanche-io/c4"
+)
+
+// descriptorVersion is the current repository descriptor format version.
+const descriptorVersion = 1
+
+// ErrDescriptorVersion is returned when a repository descriptor was
+// written by a newer, unsupported format version.
+var ErrDescriptorVersion = errors.New("unsupported repository descriptor version")
+
+// Descriptor describes a repository: where its state lives and the
+// formats it is written in. Descriptors are small JSON blobs stored by
+// their C4 ID, each naming the one it replaced, and a root pointer file
+// holds the ID of the current one, so that a tool given only the root
+// pointer can discover the repository and check that it can read it.
+type Descriptor struct {
+	Version         int       `json:"version"`            // Descriptor format version
+	SnapshotVersion int       `json:"snapshot_version"`   // Binary snapshot format version written
+	Snapshot        c4.ID     `json:"snapshot"`           // Current snapshot; nil if none
+	Registry        string    `json:"registry,omitempty"` // Location of the registry
+	Store           string    `json:"store,omitempty"`    // Fingerprint of the store configuration
+	Parent          c4.ID     `json:"parent"`             // Descriptor replaced; nil for the first
+	Time            time.Time `json:"time"`
+}
+
+// StoreFingerprint returns a fingerprint of a store configuration, such
+// as its type and location, for Descriptor.Store. Tools compare it with
+// the fingerprint of their own configuration to detect that they are
+// pointed at a different store than the repository was written to.
+func StoreFingerprint(config string) string {
+	return c4.Identify(strings.NewReader(config)).String()
+}
+
+// PublishDescriptor stores d in s and points the root pointer file root at
+// it, provided root still points at parent, the descriptor d replaces.
+// Use a nil parent to create the root pointer. The version fields, Parent
+// and a zero Time are filled in. On conflict the descriptor is stored but
+// root is unchanged, and a *RefConflictError is returned.
+func PublishDescriptor(s *StoreAdapter, root string, parent c4.ID, d Descriptor) (c4.ID, error) {
+	d.Version = descriptorVersion
+	d.SnapshotVersion = snapshotVersion
+	d.Parent = parent
+	if d.Time.IsZero() {
+		d.Time = time.Now().UTC()
+	}
+	data, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return c4.ID{}, err
+	}
+	id, err := s.Put(bytes.NewReader(append(data, '\n')))
+	if err != nil {
+		return c4.ID{}, fmt.Errorf("failed to store descriptor: %w", err)
+	}
+
+	unlock, err := lockRef(root)
+	if err != nil {
+		return c4.ID{}, err
+	}
+	defer unlock()
+	current, err := readRootPointer(root)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return c4.ID{}, err
+	}
+	if current != parent {
+		return id, &RefConflictError{Name: root, Expected: parent, Actual: current}
+	}
+	return id, writeRef(root, id)
+}
+
+// OpenDescriptor reads the descriptor the root pointer file root points
+// at from s, and returns it with its ID. The descriptor's content is
+// verified against its ID, and its formats must be ones this package
+// reads: otherwise the error wraps ErrDescriptorVersion or
+// ErrSnapshotVersion.
+func OpenDescriptor(s *StoreAdapter, root string) (Descriptor, c4.ID, error) {
+	id, err := readRootPointer(root)
+	if err != nil {
+		return Descriptor{}, c4.ID{}, err
+	}
+	d, err := GetDescriptor(s, id)
+	return d, id, err
+}
+
+// GetDescriptor reads the descriptor with the given ID from s, verifying
+// it as OpenDescriptor does. Use it to follow Parent.
+func GetDescriptor(s *StoreAdapter, id c4.ID) (Descriptor, error) {
+	rc, err := s.Get(id)
+	if err != nil {
+		return Descriptor{}, fmt.Errorf("descriptor %s: %w", id, err)
+	}
+	data, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		return Descriptor{}, fmt.Errorf("descriptor %s: %w", id, err)
+	}
+	if actual := c4.Identify(bytes.NewReader(data)); actual != id {
+		return Descriptor{}, fmt.Errorf("descriptor %s: %w", id, &IDMismatchError{Expected: id, Actual: actual})
+	}
+
+	var d Descriptor
+	if err := json.Unmarshal(data, &d); err != nil {
+		return Descriptor{}, fmt.Errorf("corrupt descriptor %s: %w", id, err)
+	}
+	if d.Version < 1 || d.Version > descriptorVersion {
+		return Descriptor{}, fmt.Errorf("descriptor %s: version %d: %w", id, d.Version, ErrDescriptorVersion)
+	}
+	if d.SnapshotVersion > snapshotVersion {
+		return Descriptor{}, fmt.Errorf("descriptor %s: snapshot version %d: %w", id, d.SnapshotVersion, ErrSnapshotVersion)
+	}
+	return d, nil
+}
+
+// Validate checks that the repository d describes is usable with the
+// store s and the store configuration fingerprinted by store: that the
+// fingerprints agree, if both are set, that s holds the current snapshot,
+// and that the registry directory exists. It returns all problems found.
+func (d Descriptor) Validate(s *StoreAdapter, store string) error {
+	var errs []error
+	if d.Store != "" && store != "" && d.Store != store {
+		errs = append(errs, fmt.Errorf("store fingerprint %s does not match the repository's %s", store, d.Store))
+	}
+	if !d.Snapshot.IsNil() && !s.Has(d.Snapshot) {
+		errs = append(errs, fmt.Errorf("snapshot %s: %w", d.Snapshot, fs.ErrNotExist))
+	}
+	if d.Registry != "" {
+		if info, err := os.Stat(d.Registry); err != nil {
+			errs = append(errs, fmt.Errorf("registry: %w", err))
+		} else if !info.IsDir() {
+			errs = append(errs, fmt.Errorf("registry %s is not a directory", d.Registry))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// readRootPointer returns the ID the root pointer file root holds.
+func readRootPointer(root string) (c4.ID, error) {
+	data, err := os.ReadFile(root)
+	if err != nil {
+		return c4.ID{}, err
+	}
+	id, err := c4.Parse(strings.TrimSpace(string(data)))
+	if err != nil {
+		return c4.ID{}, fmt.Errorf("corrupt root pointer %s: %w", root, err)
+	}
+	return id, nil
+}
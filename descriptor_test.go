@@ -0,0 +1,85 @@
+package c4fs
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/store"
+)
+
+func TestDescriptor(t *testing.T) {
+	s := NewStoreAdapter(store.NewRAM())
+	dir := t.TempDir()
+	reg, err := NewRegistry(s, filepath.Join(dir, "refs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys := New(s)
+	fsys.WriteFile("a.txt", []byte("a"), 0644)
+	snap, err := reg.Put(fsys.Flatten())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := filepath.Join(dir, "ROOT")
+	fingerprint := StoreFingerprint("ram")
+	first, err := PublishDescriptor(s, root, c4.ID{}, Descriptor{Snapshot: snap, Registry: filepath.Join(dir, "refs"), Store: fingerprint})
+	if err != nil {
+		t.Fatalf("PublishDescriptor failed: %v", err)
+	}
+	d, id, err := OpenDescriptor(s, root)
+	if err != nil || id != first {
+		t.Fatalf("OpenDescriptor = %s, %v; want %s", id, err, first)
+	}
+	if d.Snapshot != snap || d.Version != descriptorVersion || d.SnapshotVersion != snapshotVersion || d.Time.IsZero() {
+		t.Errorf("Descriptor = %+v", d)
+	}
+	if err := d.Validate(s, fingerprint); err != nil {
+		t.Errorf("Validate failed: %v", err)
+	}
+	if err := d.Validate(s, StoreFingerprint("elsewhere")); err == nil {
+		t.Error("Expected an error for another store")
+	}
+
+	// Updates must build on the current descriptor
+	if _, err := PublishDescriptor(s, root, c4.ID{}, d); !errors.As(err, new(*RefConflictError)) {
+		t.Errorf("PublishDescriptor with a stale parent = %v, want RefConflictError", err)
+	}
+	d.Snapshot = c4.Identify(strings.NewReader("missing"))
+	second, err := PublishDescriptor(s, root, first, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, _, err = OpenDescriptor(s, root)
+	if err != nil || d.Parent != first {
+		t.Errorf("Parent = %s, %v; want %s", d.Parent, err, first)
+	}
+	if err := d.Validate(s, ""); err == nil {
+		t.Error("Expected an error for a missing snapshot")
+	}
+
+	// Descriptors of newer formats are refused
+	newer := bytes.Replace(mustReadAll(t, s, second), []byte(`"version": 1`), []byte(`"version": 99`), 1)
+	newID, _ := s.Put(bytes.NewReader(newer))
+	os.WriteFile(root, []byte(newID.String()+"\n"), 0644)
+	if _, _, err := OpenDescriptor(s, root); !errors.Is(err, ErrDescriptorVersion) {
+		t.Errorf("OpenDescriptor of a newer version = %v, want ErrDescriptorVersion", err)
+	}
+}
+
+func mustReadAll(t *testing.T, s *StoreAdapter, id c4.ID) []byte {
+	t.Helper()
+	rc, err := s.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	var buf bytes.Buffer
+	buf.ReadFrom(rc)
+	return buf.Bytes()
+}
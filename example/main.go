@@ -16,7 +16,7 @@ func main() {
 	storeAdapter := c4fs.NewStoreAdapter(ramStore)
 
 	// Create a new C4 filesystem
-	fs := c4fs.New(nil, storeAdapter)
+	fs := c4fs.New(storeAdapter)
 
 	fmt.Println("=== C4FS Demo ===")
 	fmt.Println()
@@ -129,7 +129,7 @@ func main() {
 	snapshotData.Close()
 
 	// Create new filesystem from snapshot
-	fs2 := c4fs.New(loadedManifest, storeAdapter)
+	fs2 := c4fs.New(storeAdapter, c4fs.WithBase(loadedManifest))
 
 	// Verify we can read files from restored snapshot
 	data, err = fs2.ReadFile("readme.md")
@@ -147,8 +147,8 @@ func main() {
 	}
 	fmt.Println("   ✓ Added new-file.txt to layer")
 
-	fmt.Printf("   ✓ Base has %d entries\n", len(fs2.Base().Entries))
-	fmt.Printf("   ✓ Layer has %d entries\n", len(fs2.Layer().Entries))
+	fmt.Printf("   ✓ Base has %d entries\n", fs2.BaseView().Len())
+	fmt.Printf("   ✓ Layer has %d entries\n", fs2.LayerView().Len())
 
 	// Clean up
 	os.Remove("snapshot.c4m")
@@ -0,0 +1,245 @@
+package c4fs
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"strconv"
+	"time"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/c4m"
+)
+
+// exportRecord is the interchange form of a manifest entry used by the
+// JSON Lines and CSV exporters. The fields after Target hold the entry's
+// metadata (see EntryMeta) and are omitted when zero.
+type exportRecord struct {
+	Path    string `json:"path"`
+	Type    string `json:"type"` // "file", "dir", "symlink", "blockdev", "chardev", "fifo" or "socket"
+	Perm    uint32 `json:"perm"` // Permission bits, e.g. 420 for 0644
+	Size    int64  `json:"size"`
+	MTime   string `json:"mtime"` // RFC 3339 with nanoseconds
+	C4ID    string `json:"c4id,omitempty"`
+	Target  string `json:"target,omitempty"`
+	Rdev    uint64 `json:"rdev,omitempty"`
+	Link    uint64 `json:"link,omitempty"`  // Hard link group
+	ATime   string `json:"atime,omitempty"` // RFC 3339 with nanoseconds
+	Asset   uint64 `json:"asset,omitempty"` // Asset group (see AddSidecar)
+	Sidecar bool   `json:"sidecar,omitempty"`
+}
+
+// csvHeader lists the CSV columns in order.
+var csvHeader = []string{"path", "type", "perm", "size", "mtime", "c4id", "target", "rdev", "link", "atime", "asset", "sidecar"}
+
+func toExportRecord(e *c4m.Entry, meta EntryMeta) exportRecord {
+	rec := exportRecord{
+		Path:    e.Name,
+		Type:    "file",
+		Perm:    uint32(e.Mode.Perm()),
+		Size:    e.Size,
+		MTime:   e.Timestamp.UTC().Format(time.RFC3339Nano),
+		Target:  e.Target,
+		Rdev:    meta.Rdev,
+		Link:    meta.Link,
+		Asset:   meta.Asset,
+		Sidecar: meta.Sidecar,
+	}
+	if !meta.Atime.IsZero() {
+		rec.ATime = meta.Atime.UTC().Format(time.RFC3339Nano)
+	}
+	switch {
+	case e.IsDir():
+		rec.Type = "dir"
+	case e.IsSymlink():
+		rec.Type = "symlink"
+	case e.Mode&fs.ModeCharDevice != 0:
+		rec.Type = "chardev"
+	case e.Mode&fs.ModeDevice != 0:
+		rec.Type = "blockdev"
+	case e.Mode&fs.ModeNamedPipe != 0:
+		rec.Type = "fifo"
+	case e.Mode&fs.ModeSocket != 0:
+		rec.Type = "socket"
+	}
+	if !e.C4ID.IsNil() {
+		rec.C4ID = e.C4ID.String()
+	}
+	return rec
+}
+
+func fromExportRecord(rec exportRecord) (*c4m.Entry, EntryMeta, error) {
+	mode := fs.FileMode(rec.Perm).Perm()
+	switch rec.Type {
+	case "file", "":
+	case "dir":
+		mode |= fs.ModeDir
+	case "symlink":
+		mode |= fs.ModeSymlink
+	case "blockdev":
+		mode |= fs.ModeDevice
+	case "chardev":
+		mode |= fs.ModeDevice | fs.ModeCharDevice
+	case "fifo":
+		mode |= fs.ModeNamedPipe
+	case "socket":
+		mode |= fs.ModeSocket
+	default:
+		return nil, EntryMeta{}, fmt.Errorf("%s: unknown entry type %q", rec.Path, rec.Type)
+	}
+
+	mtime, err := time.Parse(time.RFC3339Nano, rec.MTime)
+	if err != nil {
+		return nil, EntryMeta{}, fmt.Errorf("%s: invalid mtime: %w", rec.Path, err)
+	}
+
+	meta := EntryMeta{Rdev: rec.Rdev, Link: rec.Link, Asset: rec.Asset, Sidecar: rec.Sidecar}
+	if rec.ATime != "" {
+		if meta.Atime, err = time.Parse(time.RFC3339Nano, rec.ATime); err != nil {
+			return nil, EntryMeta{}, fmt.Errorf("%s: invalid atime: %w", rec.Path, err)
+		}
+	}
+
+	var id c4.ID
+	if rec.C4ID != "" {
+		id, err = c4.Parse(rec.C4ID)
+		if err != nil {
+			return nil, EntryMeta{}, fmt.Errorf("%s: invalid c4id: %w", rec.Path, err)
+		}
+	}
+
+	return &c4m.Entry{
+		Mode:      mode,
+		Timestamp: mtime,
+		Size:      rec.Size,
+		Name:      cleanPath(rec.Path),
+		Target:    rec.Target,
+		C4ID:      id,
+	}, meta, nil
+}
+
+// WriteManifestJSON writes m as JSON Lines, one object per entry with the
+// fields path, type, perm, size, mtime, c4id and target.
+func WriteManifestJSON(w io.Writer, m *c4m.Manifest) error {
+	return writeManifestJSON(w, m, nil)
+}
+
+// writeManifestJSON is WriteManifestJSON with the metadata of the entries
+// by path, written in the fields rdev, link, atime, asset and sidecar.
+func writeManifestJSON(w io.Writer, m *c4m.Manifest, meta map[string]EntryMeta) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	for _, e := range m.Entries {
+		if err := enc.Encode(toExportRecord(e, meta[e.Name])); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// ReadManifestJSON reads a manifest written by WriteManifestJSON.
+func ReadManifestJSON(r io.Reader) (*c4m.Manifest, error) {
+	m, _, err := ReadManifestJSONMeta(r)
+	return m, err
+}
+
+// ReadManifestJSONMeta reads a manifest written by WriteManifestJSON or
+// ExportJSON, and the metadata of its entries by path. Use SetManifestMeta
+// to keep the metadata with the manifest.
+func ReadManifestJSONMeta(r io.Reader) (*c4m.Manifest, map[string]EntryMeta, error) {
+	m := c4m.NewManifest()
+	var meta map[string]EntryMeta
+	dec := json.NewDecoder(r)
+	for line := 1; ; line++ {
+		var rec exportRecord
+		if err := dec.Decode(&rec); err != nil {
+			if err == io.EOF {
+				return m, meta, nil
+			}
+			return nil, nil, fmt.Errorf("record %d: %w", line, err)
+		}
+		e, em, err := fromExportRecord(rec)
+		if err != nil {
+			return nil, nil, fmt.Errorf("record %d: %w", line, err)
+		}
+		m.AddEntry(e)
+		if em != (EntryMeta{}) {
+			if meta == nil {
+				meta = make(map[string]EntryMeta)
+			}
+			meta[e.Name] = em
+		}
+	}
+}
+
+// WriteManifestCSV writes m as CSV with a header row. Columns are
+// path, type, perm (octal), size, mtime, c4id, target, rdev, link,
+// atime, asset and sidecar; the last five are empty unless written by
+// ExportCSV.
+func WriteManifestCSV(w io.Writer, m *c4m.Manifest) error {
+	return writeManifestCSV(w, m, nil)
+}
+
+// writeManifestCSV is WriteManifestCSV with the metadata of the entries
+// by path.
+func writeManifestCSV(w io.Writer, m *c4m.Manifest, meta map[string]EntryMeta) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, e := range m.Entries {
+		rec := toExportRecord(e, meta[e.Name])
+		row := []string{
+			rec.Path,
+			rec.Type,
+			fmt.Sprintf("%04o", rec.Perm),
+			strconv.FormatInt(rec.Size, 10),
+			rec.MTime,
+			rec.C4ID,
+			rec.Target,
+			formatNonZero(rec.Rdev),
+			formatNonZero(rec.Link),
+			rec.ATime,
+			formatNonZero(rec.Asset),
+			formatSidecar(rec.Sidecar),
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// formatNonZero formats n in decimal, or as an empty string if zero.
+func formatNonZero(n uint64) string {
+	if n == 0 {
+		return ""
+	}
+	return strconv.FormatUint(n, 10)
+}
+
+// formatSidecar formats the sidecar column: "true", or empty.
+func formatSidecar(sidecar bool) string {
+	if !sidecar {
+		return ""
+	}
+	return "true"
+}
+
+// ExportJSON writes the flattened filesystem as JSON Lines, with the
+// metadata of its entries.
+func (c4fs *FS) ExportJSON(w io.Writer) error {
+	m, meta := c4fs.flattenMeta()
+	return writeManifestJSON(w, m, meta)
+}
+
+// ExportCSV writes the flattened filesystem as CSV, with the metadata of
+// its entries.
+func (c4fs *FS) ExportCSV(w io.Writer) error {
+	m, meta := c4fs.flattenMeta()
+	return writeManifestCSV(w, m, meta)
+}
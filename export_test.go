@@ -0,0 +1,119 @@
+package c4fs
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Avalanche-io/c4/store"
+)
+
+func TestManifestJSONRoundTrip(t *testing.T) {
+	c4fs := New(NewStoreAdapter(store.NewRAM()))
+	c4fs.Mkdir("docs", 0755)
+	c4fs.WriteFile("docs/readme.md", []byte("# readme"), 0644)
+	c4fs.Symlink("docs/readme.md", "README")
+
+	var buf bytes.Buffer
+	if err := c4fs.ExportJSON(&buf); err != nil {
+		t.Fatalf("ExportJSON failed: %v", err)
+	}
+	if lines := strings.Count(buf.String(), "\n"); lines != 3 {
+		t.Errorf("Expected 3 JSON lines, got %d", lines)
+	}
+
+	m, err := ReadManifestJSON(&buf)
+	if err != nil {
+		t.Fatalf("ReadManifestJSON failed: %v", err)
+	}
+
+	restored := New(c4fs.Store(), WithBase(m))
+	data, err := restored.ReadFile("README")
+	if err != nil {
+		t.Fatalf("ReadFile through restored symlink failed: %v", err)
+	}
+	if string(data) != "# readme" {
+		t.Errorf("Restored content: got %q", data)
+	}
+
+	orig, _ := c4fs.Stat("docs/readme.md")
+	got, _ := restored.Stat("docs/readme.md")
+	if got.Mode() != orig.Mode() || !got.ModTime().Equal(orig.ModTime()) {
+		t.Errorf("Metadata mismatch: got %v %v, want %v %v", got.Mode(), got.ModTime(), orig.Mode(), orig.ModTime())
+	}
+	if !restored.IsDir("docs") {
+		t.Error("docs should be a directory after import")
+	}
+}
+
+func TestReadManifestJSONInvalid(t *testing.T) {
+	_, err := ReadManifestJSON(strings.NewReader(`{"path":"x","type":"weird","mtime":"2024-01-01T00:00:00Z"}`))
+	if err == nil {
+		t.Error("Expected error for unknown entry type")
+	}
+}
+
+func TestManifestCSV(t *testing.T) {
+	c4fs := New(NewStoreAdapter(store.NewRAM()))
+	c4fs.WriteFile("a.txt", []byte("a"), 0640)
+
+	var buf bytes.Buffer
+	if err := c4fs.ExportCSV(&buf); err != nil {
+		t.Fatalf("ExportCSV failed: %v", err)
+	}
+
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("Failed to parse CSV: %v", err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("Expected header + 1 row, got %d rows", len(rows))
+	}
+	if strings.Join(rows[0], ",") != "path,type,perm,size,mtime,c4id,target,rdev,link,atime,asset,sidecar" {
+		t.Errorf("Unexpected header: %v", rows[0])
+	}
+	if rows[1][0] != "a.txt" || rows[1][1] != "file" || rows[1][2] != "0640" || rows[1][3] != "1" {
+		t.Errorf("Unexpected row: %v", rows[1])
+	}
+}
+
+func TestExportMeta(t *testing.T) {
+	c4fs := newMetaFS(t, NewStoreAdapter(store.NewRAM()))
+
+	var buf bytes.Buffer
+	if err := c4fs.ExportJSON(&buf); err != nil {
+		t.Fatalf("ExportJSON failed: %v", err)
+	}
+	for _, field := range []string{`"rdev":34817`, `"link":`, `"atime":"2024-05-01T12:00:00.000000042Z"`} {
+		if !strings.Contains(buf.String(), field) {
+			t.Errorf("ExportJSON lacks %s:\n%s", field, buf.String())
+		}
+	}
+	m, meta, err := ReadManifestJSONMeta(&buf)
+	if err != nil {
+		t.Fatalf("ReadManifestJSONMeta failed: %v", err)
+	}
+	if want := time.Date(2024, 5, 1, 12, 0, 0, 42, time.UTC); !meta["c.txt"].Atime.Equal(want) {
+		t.Errorf("ReadManifestJSONMeta atime = %v, want %v", meta["c.txt"].Atime, want)
+	}
+	if err := SetManifestMeta(m, c4fs.Store(), meta); err != nil {
+		t.Fatalf("SetManifestMeta failed: %v", err)
+	}
+	checkMeta(t, "JSON", New(c4fs.Store(), WithBase(m)))
+
+	buf.Reset()
+	if err := c4fs.ExportCSV(&buf); err != nil {
+		t.Fatalf("ExportCSV failed: %v", err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("Failed to parse CSV: %v", err)
+	}
+	for _, row := range rows[1:] {
+		if row[0] == "tty" && row[7] != "34817" {
+			t.Errorf("CSV rdev of tty = %q", row[7])
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package c4fs
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ExportOptions controls the behavior of ExportDir.
+type ExportOptions struct {
+	// Symlinks says how symbolic links are exported. Under
+	// SymlinkFollow, links are resolved within the filesystem.
+	Symlinks SymlinkPolicy
+
+	// SkipSpecial skips devices and named pipes, which are otherwise
+	// recreated. Creating devices usually needs superuser privileges.
+	// Sockets are always skipped.
+	SkipSpecial bool
+}
+
+// ExportDir writes the tree at src in the filesystem to the directory dst
+// on the local disk, hydrating file content from the store and creating
+// dst if needed. Modes, modification times, access times, hard links and
+// special files are restored. Existing files are overwritten; other files in dst are
+// left alone. The export stops early if ctx is cancelled.
+func (c4fs *FS) ExportDir(ctx context.Context, src, dst string, opts ExportOptions) error {
+	src = strings.TrimPrefix(cleanPath(src), "/")
+	entry, err := c4fs.resolveSymlink(src, 40)
+	if err != nil {
+		return err
+	}
+	if !entry.IsDir() {
+		return &fs.PathError{Op: "export", Path: src, Err: syscall.ENOTDIR}
+	}
+	exp := &exporter{fsys: c4fs, opts: opts}
+	return exp.exportDir(ctx, entry.Name, dst, entry.Mode.Perm(), entry.Timestamp)
+}
+
+// exporter holds the state of an ExportDir call.
+type exporter struct {
+	fsys   *FS
+	opts   ExportOptions
+	dirs   []string          // directories being exported, to detect link cycles
+	linked map[uint64]string // local path of each hard-linked file exported
+}
+
+// exportDir writes the directory name to the local directory p.
+func (exp *exporter) exportDir(ctx context.Context, name, p string, perm fs.FileMode, mtime time.Time) error {
+	if slices.Contains(exp.dirs, name) {
+		return &fs.PathError{Op: "export", Path: name, Err: errSymlinkCycle}
+	}
+	// Keep the directory writable until its contents are written
+	if err := os.MkdirAll(p, perm|0700); err != nil {
+		return err
+	}
+
+	entries, err := exp.fsys.ReadDirEntries(name)
+	if err != nil {
+		return err
+	}
+	exp.dirs = append(exp.dirs, name)
+	defer func() { exp.dirs = exp.dirs[:len(exp.dirs)-1] }()
+
+	for _, e := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		childName := path.Join(name, e.Name)
+		childPath := filepath.Join(p, e.Name)
+
+		if e.Mode&fs.ModeSymlink != 0 {
+			switch exp.opts.Symlinks {
+			case SymlinkReject:
+				return &fs.PathError{Op: "export", Path: childName, Err: ErrSymlink}
+			case SymlinkPreserve:
+				if err := exportSymlink(e.Target, childPath); err != nil {
+					return err
+				}
+				continue
+			}
+			target, err := exp.fsys.resolveSymlink(childName, 40)
+			if err != nil {
+				return err
+			}
+			// Export the target under the link's name
+			e.Mode, e.ModTime = target.Mode, target.Timestamp
+			childName = target.Name
+		}
+
+		if e.Mode.IsDir() {
+			if err := exp.exportDir(ctx, childName, childPath, e.Mode.Perm(), e.ModTime); err != nil {
+				return err
+			}
+			continue
+		}
+		if isSpecial(e.Mode) {
+			if exp.opts.SkipSpecial || e.Mode&fs.ModeSocket != 0 {
+				continue
+			}
+			if err := exp.exportSpecial(childName, childPath, e.Mode, e.ModTime); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := exp.exportFile(childName, childPath, e.Mode.Perm(), e.ModTime); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Chmod(p, perm); err != nil {
+		return err
+	}
+	return exp.chtimes(name, p, mtime)
+}
+
+// chtimes sets the times of the local file p to those of name.
+func (exp *exporter) chtimes(name, p string, mtime time.Time) error {
+	atime, err := exp.fsys.Atime(name)
+	if err != nil {
+		return err
+	}
+	return os.Chtimes(p, atime, mtime)
+}
+
+// exportFile writes the content of the file name to the local file p, or
+// links p to the file exported for another of its hard links.
+func (exp *exporter) exportFile(name, p string, perm fs.FileMode, mtime time.Time) error {
+	group, hasLinks := exp.fsys.linkGroup(name)
+	if first, ok := exp.linked[group]; hasLinks && ok {
+		if err := removeNonDir(p); err != nil {
+			return err
+		}
+		return os.Link(first, p)
+	}
+	if hasLinks {
+		if exp.linked == nil {
+			exp.linked = make(map[uint64]string)
+		}
+		exp.linked[group] = p
+	}
+
+	src, err := exp.fsys.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	// Replace rather than truncate, so read-only files can be updated
+	if err := removeNonDir(p); err != nil {
+		return err
+	}
+	dst, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	return exp.chtimes(name, p, mtime)
+}
+
+// exportSpecial creates the local special file p like name.
+func (exp *exporter) exportSpecial(name, p string, mode fs.FileMode, mtime time.Time) error {
+	dev, err := exp.fsys.Rdev(name)
+	if err != nil {
+		return err
+	}
+	if err := removeNonDir(p); err != nil {
+		return err
+	}
+	if err := mknod(p, mode, dev); err != nil {
+		return err
+	}
+	// The umask applies to mknod
+	if err := os.Chmod(p, mode.Perm()); err != nil {
+		return err
+	}
+	return exp.chtimes(name, p, mtime)
+}
+
+// exportSymlink creates the local link p pointing to target. The time of
+// the link is not restored, which os cannot do portably.
+func exportSymlink(target, p string) error {
+	if err := removeNonDir(p); err != nil {
+		return err
+	}
+	return os.Symlink(filepath.FromSlash(target), p)
+}
+
+// removeNonDir removes the local file p, if it exists and is not a
+// directory.
+func removeNonDir(p string) error {
+	info, err := os.Lstat(p)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return &fs.PathError{Op: "export", Path: p, Err: fs.ErrExist}
+	}
+	return os.Remove(p)
+}
@@ -0,0 +1,111 @@
+package c4fs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Avalanche-io/c4/store"
+)
+
+func TestExportDir(t *testing.T) {
+	c4fs := New(NewStoreAdapter(store.NewRAM()))
+	mtime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	if err := c4fs.MkdirAll("proj/sub", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := c4fs.WriteFile("proj/a.txt", []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := c4fs.WriteFile("proj/sub/ro.txt", []byte("read only"), 0444); err != nil {
+		t.Fatal(err)
+	}
+	atime := time.Date(2024, 3, 2, 12, 0, 0, 0, time.UTC)
+	if err := c4fs.Chtimes("proj/a.txt", atime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := filepath.Join(t.TempDir(), "out")
+	for range 2 { // exporting again overwrites
+		if err := c4fs.ExportDir(context.Background(), "/proj", dst, ExportOptions{}); err != nil {
+			t.Fatalf("ExportDir failed: %v", err)
+		}
+	}
+	info, err := os.Stat(filepath.Join(dst, "a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(mtime) {
+		t.Errorf("ModTime = %v, want %v", info.ModTime(), mtime)
+	}
+	if got := fileAtime(info); !got.IsZero() && !got.Equal(atime) {
+		t.Errorf("access time = %v, want %v", got, atime)
+	}
+	data, err := os.ReadFile(filepath.Join(dst, "a.txt"))
+	if err != nil || string(data) != "hello" {
+		t.Errorf("a.txt = %q, %v", data, err)
+	}
+	if info, err := os.Stat(filepath.Join(dst, "sub", "ro.txt")); err != nil || info.Mode().Perm() != 0444 {
+		t.Errorf("ro.txt mode = %v, %v", info.Mode(), err)
+	}
+
+	if err := c4fs.ExportDir(context.Background(), "proj/a.txt", dst, ExportOptions{}); err == nil {
+		t.Error("ExportDir of a file succeeded")
+	}
+}
+
+func TestExportDirSymlinks(t *testing.T) {
+	c4fs := New(NewStoreAdapter(store.NewRAM()))
+	if err := c4fs.MkdirAll("proj/data", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := c4fs.WriteFile("proj/data/file.txt", []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := c4fs.Symlink("data/file.txt", "proj/file.lnk"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c4fs.Symlink("data", "proj/dir.lnk"); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := t.TempDir()
+	if err := c4fs.ExportDir(context.Background(), "proj", dst, ExportOptions{}); err != nil {
+		t.Fatalf("ExportDir with SymlinkPreserve failed: %v", err)
+	}
+	if target, err := os.Readlink(filepath.Join(dst, "dir.lnk")); err != nil || target != "data" {
+		t.Errorf("Readlink(dir.lnk) = %q, %v", target, err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dst, "file.lnk")); err != nil || string(data) != "content" {
+		t.Errorf("file.lnk = %q, %v", data, err)
+	}
+
+	err := c4fs.ExportDir(context.Background(), "proj", t.TempDir(), ExportOptions{Symlinks: SymlinkReject})
+	if !errors.Is(err, ErrSymlink) {
+		t.Errorf("ExportDir with SymlinkReject = %v, want ErrSymlink", err)
+	}
+
+	dst = t.TempDir()
+	if err := c4fs.ExportDir(context.Background(), "proj", dst, ExportOptions{Symlinks: SymlinkFollow}); err != nil {
+		t.Fatalf("ExportDir with SymlinkFollow failed: %v", err)
+	}
+	for _, name := range []string{"file.lnk", "dir.lnk"} {
+		if info, err := os.Lstat(filepath.Join(dst, name)); err != nil || info.Mode()&os.ModeSymlink != 0 {
+			t.Errorf("Lstat(%s) = %v, %v; want a copy", name, info, err)
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(dst, "dir.lnk", "file.txt")); err != nil || string(data) != "content" {
+		t.Errorf("dir.lnk/file.txt = %q, %v", data, err)
+	}
+
+	if err := c4fs.Symlink("..", "proj/data/loop.lnk"); err != nil {
+		t.Fatal(err)
+	}
+	err = c4fs.ExportDir(context.Background(), "proj", t.TempDir(), ExportOptions{Symlinks: SymlinkFollow})
+	if !errors.Is(err, errSymlinkCycle) {
+		t.Errorf("ExportDir following a loop = %v, want a cycle error", err)
+	}
+}
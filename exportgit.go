@@ -0,0 +1,264 @@
+package c4fs
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Avalanche-io/c4/c4m"
+	"github.com/Avalanche-io/c4/store"
+)
+
+// GitExportOptions controls the behavior of ExportGit.
+type GitExportOptions struct {
+	// Ref, if set, is the reference to point at the new commit, such as
+	// "refs/heads/main". The commit it pointed at becomes the parent.
+	Ref string
+
+	// Message is the commit message.
+	Message string
+
+	// Author is the author and committer, as "Name <email>". If empty,
+	// "c4fs <c4fs@localhost>" is used.
+	Author string
+
+	// Time is the commit time. If zero, the current time is used.
+	Time time.Time
+}
+
+// ExportGit writes the snapshot m as a commit in the git repository at
+// repo, a working tree or a git directory that must already exist, and
+// returns the commit's object name in hex. File content is read from src
+// and streamed into loose objects, so pushing the repository to a remote
+// publishes the snapshot for tools that speak git. The working tree and
+// index are not touched.
+//
+// Regular files keep their executable bit and symbolic links their
+// target. Git cannot record empty directories, other permissions or
+// times of entries, or special files, so those are left out.
+func ExportGit(ctx context.Context, m *c4m.Manifest, src store.Store, repo string, opts GitExportOptions) (string, error) {
+	r, err := openGitRepo(repo)
+	if err != nil {
+		return "", err
+	}
+	defer r.close()
+
+	exp := &gitExporter{repo: r, store: NewStoreAdapter(src), dirs: make(map[string][]*c4m.Entry)}
+	for _, e := range Canonicalize(m).Entries {
+		dir := path.Dir(e.Name)
+		if dir == "." {
+			dir = ""
+		}
+		exp.dirs[dir] = append(exp.dirs[dir], e)
+	}
+	tree, err := exp.tree(ctx, "")
+	if err != nil {
+		return "", err
+	}
+	if tree == nil {
+		// An empty snapshot is the empty tree
+		if tree, err = r.writeObject(gitTree, 0, bytes.NewReader(nil)); err != nil {
+			return "", err
+		}
+	}
+
+	var parent *gitHash
+	if opts.Ref != "" {
+		if !strings.HasPrefix(opts.Ref, "refs/") || path.Clean(opts.Ref) != opts.Ref || strings.Contains(opts.Ref, "..") {
+			return "", fmt.Errorf("invalid git reference %q", opts.Ref)
+		}
+		sha, ok, err := r.ref(opts.Ref, 0)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			parent = &sha
+		}
+	}
+
+	author := opts.Author
+	if author == "" {
+		author = "c4fs <c4fs@localhost>"
+	}
+	when := opts.Time
+	if when.IsZero() {
+		when = time.Now()
+	}
+	var commit bytes.Buffer
+	fmt.Fprintf(&commit, "tree %s\n", tree)
+	if parent != nil {
+		fmt.Fprintf(&commit, "parent %s\n", parent)
+	}
+	stamp := fmt.Sprintf("%d %s", when.Unix(), when.Format("-0700"))
+	fmt.Fprintf(&commit, "author %s %s\ncommitter %s %s\n\n%s", author, stamp, author, stamp, opts.Message)
+	if !strings.HasSuffix(opts.Message, "\n") {
+		commit.WriteByte('\n')
+	}
+	sha, err := r.writeObject(gitCommit, int64(commit.Len()), &commit)
+	if err != nil {
+		return "", err
+	}
+
+	if opts.Ref != "" {
+		if err := writeRefFile(filepath.Join(r.dir, filepath.FromSlash(opts.Ref)), sha.String()); err != nil {
+			return "", err
+		}
+	}
+	return sha.String(), nil
+}
+
+// gitExporter holds the state of an ExportGit call.
+type gitExporter struct {
+	repo  *gitRepo
+	store *StoreAdapter
+	dirs  map[string][]*c4m.Entry // entries by parent directory
+}
+
+// tree writes the git tree of the directory dir and returns its name, or
+// nil if it holds nothing git can record.
+func (exp *gitExporter) tree(ctx context.Context, dir string) (*gitHash, error) {
+	type treeEntry struct {
+		mode string
+		name string
+		sha  gitHash
+	}
+	var entries []treeEntry
+	for _, e := range exp.dirs[dir] {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		name := path.Base(e.Name)
+		switch {
+		case e.IsDir():
+			sha, err := exp.tree(ctx, e.Name)
+			if err != nil {
+				return nil, err
+			}
+			if sha != nil {
+				entries = append(entries, treeEntry{"40000", name, *sha})
+			}
+		case e.IsSymlink():
+			sha, err := exp.repo.writeObject(gitBlob, int64(len(e.Target)), strings.NewReader(e.Target))
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, treeEntry{"120000", name, *sha})
+		case e.Mode.IsRegular():
+			sha, err := exp.blob(e)
+			if err != nil {
+				return nil, err
+			}
+			mode := "100644"
+			if e.Mode&0111 != 0 {
+				mode = "100755"
+			}
+			entries = append(entries, treeEntry{mode, name, *sha})
+		}
+	}
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	// Git sorts trees by name, comparing directories as if they ended in
+	// a slash
+	key := func(e treeEntry) string {
+		if e.mode == "40000" {
+			return e.name + "/"
+		}
+		return e.name
+	}
+	sort.Slice(entries, func(i, j int) bool { return key(entries[i]) < key(entries[j]) })
+	var buf bytes.Buffer
+	for _, e := range entries {
+		fmt.Fprintf(&buf, "%s %s\x00", e.mode, e.name)
+		buf.Write(e.sha[:])
+	}
+	return exp.repo.writeObject(gitTree, int64(buf.Len()), &buf)
+}
+
+// blob streams the content of the regular file e into a git blob.
+func (exp *gitExporter) blob(e *c4m.Entry) (*gitHash, error) {
+	if e.Size <= 0 {
+		return exp.repo.writeObject(gitBlob, 0, bytes.NewReader(nil))
+	}
+	rc, err := exp.store.Get(e.C4ID)
+	if err != nil {
+		return nil, &fs.PathError{Op: "export", Path: e.Name, Err: err}
+	}
+	defer rc.Close()
+	sha, err := exp.repo.writeObject(gitBlob, e.Size, rc)
+	if err != nil {
+		return nil, &fs.PathError{Op: "export", Path: e.Name, Err: err}
+	}
+	return sha, nil
+}
+
+// has reports whether the repository holds the object sha.
+func (r *gitRepo) has(sha gitHash) bool {
+	for _, p := range r.packs {
+		if _, ok := p.find(sha); ok {
+			return true
+		}
+	}
+	s := sha.String()
+	_, err := os.Stat(filepath.Join(r.common, "objects", s[:2], s[2:]))
+	return err == nil
+}
+
+// writeObject stores size bytes from content as a loose object of type
+// typ, unless the repository already has it, and returns its name.
+func (r *gitRepo) writeObject(typ gitType, size int64, content io.Reader) (*gitHash, error) {
+	objects := filepath.Join(r.common, "objects")
+	tmp, err := os.CreateTemp(objects, "tmp_obj_")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha1.New()
+	zw := zlib.NewWriter(tmp)
+	w := io.MultiWriter(h, zw)
+	fmt.Fprintf(w, "%s %d\x00", typ, size)
+	n, err := io.Copy(w, content)
+	if err == nil && n != size {
+		err = fmt.Errorf("content is %d bytes, expected %d", n, size)
+	}
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var sha gitHash
+	h.Sum(sha[:0])
+	if r.has(sha) {
+		return &sha, nil
+	}
+	s := sha.String()
+	dir := filepath.Join(objects, s[:2])
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	// Objects are read-only, as git writes them
+	if err := os.Chmod(tmp.Name(), 0444); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, s[2:])); err != nil {
+		return nil, err
+	}
+	return &sha, nil
+}
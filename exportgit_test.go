@@ -0,0 +1,93 @@
+package c4fs
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Avalanche-io/c4/store"
+)
+
+func TestExportGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	repo := t.TempDir()
+	gitRun(t, repo, "init", "-q", "-b", "main")
+
+	ram := store.NewRAM()
+	fsys := New(NewStoreAdapter(ram))
+	fsys.WriteFile("README", []byte("hello\n"), 0644)
+	fsys.MkdirAll("bin", 0755)
+	fsys.WriteFile("bin/run.sh", []byte("#!/bin/sh\n"), 0755)
+	fsys.WriteFile("bin.txt", []byte("sorts before bin/ in git\n"), 0644)
+	fsys.WriteFile("empty", nil, 0644)
+	fsys.MkdirAll("nothing/here", 0755)
+	if err := fsys.Symlink("README", "link"); err != nil {
+		t.Fatalf("Symlink failed: %v", err)
+	}
+
+	when := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	first, err := ExportGit(context.Background(), fsys.Flatten(), ram, repo, GitExportOptions{
+		Ref:     "refs/heads/main",
+		Message: "first",
+		Author:  "Tester <tester@example.com>",
+		Time:    when,
+	})
+	if err != nil {
+		t.Fatalf("ExportGit failed: %v", err)
+	}
+	gitRun(t, repo, "fsck", "--strict")
+
+	if got := strings.TrimSpace(gitRun(t, repo, "rev-parse", "main")); got != first {
+		t.Errorf("main = %s, want %s", got, first)
+	}
+	want := map[string]string{"README": "hello\n", "bin/run.sh": "#!/bin/sh\n", "empty": "", "link": "README"}
+	for name, content := range want {
+		if got := gitRun(t, repo, "show", "main:"+name); got != content {
+			t.Errorf("%s = %q, want %q", name, got, content)
+		}
+	}
+	tree := gitRun(t, repo, "ls-tree", "-r", "main")
+	for _, line := range []string{"100755 blob", "120000 blob"} {
+		if !strings.Contains(tree, line) {
+			t.Errorf("Tree lacks a %q entry:\n%s", line, tree)
+		}
+	}
+	if strings.Contains(tree, "nothing") {
+		t.Errorf("Empty directory exported:\n%s", tree)
+	}
+	if got := gitRun(t, repo, "log", "-1", "--format=%an <%ae> %at %s", "main"); got != "Tester <tester@example.com> 1709294400 first\n" {
+		t.Errorf("Commit = %q", got)
+	}
+
+	// A second export builds on the first
+	fsys.WriteFile("README", []byte("changed\n"), 0644)
+	second, err := ExportGit(context.Background(), fsys.Flatten(), ram, repo, GitExportOptions{Ref: "refs/heads/main", Message: "second"})
+	if err != nil {
+		t.Fatalf("Second ExportGit failed: %v", err)
+	}
+	if got := strings.TrimSpace(gitRun(t, repo, "rev-parse", second+"^")); got != first {
+		t.Errorf("Parent of second commit = %s, want %s", got, first)
+	}
+
+	// Importing the commit gives back the same files
+	m, err := ImportGit(context.Background(), repo, "main", ram, GitImportOptions{})
+	if err != nil {
+		t.Fatalf("ImportGit failed: %v", err)
+	}
+	back := New(NewStoreAdapter(ram), WithBase(m))
+	for _, name := range []string{"README", "bin/run.sh", "bin.txt", "empty"} {
+		a, _ := fsys.ReadFile(name)
+		b, err := back.ReadFile(name)
+		if err != nil || string(a) != string(b) {
+			t.Errorf("%s after round trip = %q, %v; want %q", name, b, err, a)
+		}
+	}
+
+	if _, err := ExportGit(context.Background(), fsys.Flatten(), ram, repo, GitExportOptions{Ref: "refs/../HEAD"}); err == nil {
+		t.Error("Expected an error for an invalid reference")
+	}
+}
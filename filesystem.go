@@ -5,7 +5,12 @@ import (
 	"io"
 	"io/fs"
 	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
+
+	"github.com/Avalanche-io/c4/c4m"
 )
 
 // FileSystem represents a filesystem interface compatible with io/fs.FS
@@ -67,6 +72,7 @@ type fileInfo struct {
 	mode    fs.FileMode
 	modTime time.Time
 	isDir   bool
+	entry   *c4m.Entry // returned by Sys; nil for files being written
 }
 
 func (fi *fileInfo) Name() string       { return fi.name }
@@ -74,9 +80,21 @@ func (fi *fileInfo) Size() int64        { return fi.size }
 func (fi *fileInfo) Mode() fs.FileMode  { return fi.mode }
 func (fi *fileInfo) ModTime() time.Time { return fi.modTime }
 func (fi *fileInfo) IsDir() bool        { return fi.isDir }
-func (fi *fileInfo) Sys() interface{}   { return nil }
+func (fi *fileInfo) String() string     { return fs.FormatFileInfo(fi) }
+
+// Sys returns the *c4m.Entry the information was read from, which holds
+// the C4 ID of a file and the target of a symbolic link. The entry is
+// shared with the filesystem and must not be modified. Sys returns nil
+// for a file still being written.
+func (fi *fileInfo) Sys() interface{} {
+	if fi.entry == nil {
+		return nil
+	}
+	return fi.entry
+}
 
-// dirEntry implements fs.DirEntry for C4M entries.
+// dirEntry implements fs.DirEntry for C4M entries, like the result of
+// fs.FileInfoToDirEntry.
 type dirEntry struct {
 	info *fileInfo
 }
@@ -85,29 +103,72 @@ func (d *dirEntry) Name() string             { return d.info.Name() }
 func (d *dirEntry) IsDir() bool              { return d.info.IsDir() }
 func (d *dirEntry) Type() fs.FileMode        { return d.info.Mode().Type() }
 func (d *dirEntry) Info() (fs.FileInfo, error) { return d.info, nil }
+func (d *dirEntry) String() string           { return fs.FormatDirEntry(d) }
 
 // readOnlyFile wraps a ReadCloser to implement fs.File.
+// Seeking and ReadAt are served by the underlying reader when it supports
+// them; otherwise the requested range is fetched from the store, so a
+// seek into a large blob does not transfer the content before it.
+//
+// Each Open gets its own readOnlyFile and its own reader from the store,
+// so handles on the same content never share a position. Within a handle,
+// ReadAt uses only state fixed at open and is safe to call concurrently
+// with other ReadAt calls and with Read and Seek, as for an *os.File.
 type readOnlyFile struct {
 	io.ReadCloser
-	info *fileInfo
-	pos  int64
+	info      *fileInfo
+	pos       int64
+	base      int64       // offset in the content where ReadCloser starts
+	at        io.ReaderAt // store reader serving ReadAt; never replaced by Seek
+	openRange func(off, length int64) (io.ReadCloser, error)
+	release   func()        // called once on Close
+	closed    func() bool   // reports whether the filesystem was shut down
+	track     func() func() // records a duplicate as open; returns its release
+	shared    *sharedReader // store reader shared with duplicates, if any
+	unref     func() error  // drops this handle's reference to shared
 }
 
 func (f *readOnlyFile) Stat() (fs.FileInfo, error) {
 	return f.info, nil
 }
 
+func (f *readOnlyFile) Close() error {
+	if f.release != nil {
+		f.release()
+		f.release = nil
+	}
+	if f.unref != nil {
+		return f.unref()
+	}
+	return f.ReadCloser.Close()
+}
+
 func (f *readOnlyFile) Read(p []byte) (int, error) {
+	if f.closed() {
+		return 0, closedError("read", f.info.name)
+	}
 	n, err := f.ReadCloser.Read(p)
 	f.pos += int64(n)
 	return n, err
 }
 
+// WriteTo implements io.WriterTo by copying straight from the store's
+// reader. When that is an *os.File, as with a Folder store, copies to
+// sockets and files can use the kernel's sendfile or splice paths.
+func (f *readOnlyFile) WriteTo(w io.Writer) (int64, error) {
+	if f.closed() {
+		return 0, closedError("read", f.info.name)
+	}
+	n, err := io.Copy(w, f.ReadCloser)
+	f.pos += n
+	return n, err
+}
+
 func (f *readOnlyFile) ReadDir(n int) ([]fs.DirEntry, error) {
 	return nil, &fs.PathError{
 		Op:   "readdir",
 		Path: f.info.name,
-		Err:  fs.ErrInvalid,
+		Err:  syscall.ENOTDIR,
 	}
 }
 
@@ -115,7 +176,7 @@ func (f *readOnlyFile) Write(p []byte) (int, error) {
 	return 0, &fs.PathError{
 		Op:   "write",
 		Path: f.info.name,
-		Err:  fs.ErrPermission,
+		Err:  syscall.EBADF,
 	}
 }
 
@@ -123,7 +184,7 @@ func (f *readOnlyFile) WriteAt(p []byte, off int64) (int, error) {
 	return 0, &fs.PathError{
 		Op:   "write",
 		Path: f.info.name,
-		Err:  fs.ErrPermission,
+		Err:  syscall.EBADF,
 	}
 }
 
@@ -131,24 +192,100 @@ func (f *readOnlyFile) WriteString(s string) (int, error) {
 	return 0, &fs.PathError{
 		Op:   "write",
 		Path: f.info.name,
-		Err:  fs.ErrPermission,
+		Err:  syscall.EBADF,
 	}
 }
 
 func (f *readOnlyFile) ReadAt(p []byte, off int64) (int, error) {
-	return 0, &fs.PathError{
-		Op:   "read",
-		Path: f.info.name,
-		Err:  fmt.Errorf("ReadAt not supported on streaming files"),
+	if off < 0 {
+		return 0, &fs.PathError{Op: "read", Path: f.info.name, Err: fs.ErrInvalid}
+	}
+	if f.closed() {
+		return 0, closedError("read", f.info.name)
+	}
+	if f.at != nil {
+		return f.at.ReadAt(p, off)
+	}
+	if f.openRange == nil {
+		return 0, &fs.PathError{
+			Op:   "read",
+			Path: f.info.name,
+			Err:  fmt.Errorf("ReadAt not supported on streaming files"),
+		}
 	}
+	if off >= f.info.size {
+		return 0, io.EOF
+	}
+
+	// Read from an independent stream so the file position is unchanged
+	rc, err := f.openRange(off, int64(len(p)))
+	if err != nil {
+		return 0, &fs.PathError{Op: "read", Path: f.info.name, Err: err}
+	}
+	defer rc.Close()
+	n, err := io.ReadFull(rc, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
 }
 
 func (f *readOnlyFile) Seek(offset int64, whence int) (int64, error) {
-	return 0, &fs.PathError{
-		Op:   "seek",
-		Path: f.info.name,
-		Err:  fmt.Errorf("Seek not supported on streaming files"),
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = f.pos + offset
+	case io.SeekEnd:
+		abs = f.info.size + offset
+	default:
+		return 0, &fs.PathError{Op: "seek", Path: f.info.name, Err: fmt.Errorf("invalid whence")}
+	}
+	if abs < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.info.name, Err: fmt.Errorf("negative position")}
+	}
+	if abs == f.pos {
+		return abs, nil
+	}
+
+	if sk, ok := f.ReadCloser.(io.Seeker); ok && abs >= f.base {
+		n, err := sk.Seek(abs-f.base, io.SeekStart)
+		if err != nil {
+			return 0, err
+		}
+		f.pos = f.base + n
+		return f.pos, nil
+	}
+
+	if f.openRange == nil {
+		if abs < f.pos {
+			return 0, &fs.PathError{
+				Op:   "seek",
+				Path: f.info.name,
+				Err:  fmt.Errorf("Seek not supported on streaming files"),
+			}
+		}
+
+		// Skip forward; seeking past the end is allowed and reads return EOF
+		n, err := io.CopyN(io.Discard, f.ReadCloser, abs-f.pos)
+		f.pos += n
+		if err != nil && err != io.EOF {
+			return f.pos, err
+		}
+		f.pos = abs
+		return abs, nil
+	}
+
+	// Continue reading from a stream starting at the new position
+	rc, err := f.openRange(abs, -1)
+	if err != nil {
+		return 0, &fs.PathError{Op: "seek", Path: f.info.name, Err: err}
 	}
+	f.ReadCloser.Close()
+	f.ReadCloser = rc
+	f.pos, f.base = abs, abs
+	return abs, nil
 }
 
 func (f *readOnlyFile) Sync() error {
@@ -159,7 +296,7 @@ func (f *readOnlyFile) Truncate(size int64) error {
 	return &fs.PathError{
 		Op:   "truncate",
 		Path: f.info.name,
-		Err:  fs.ErrPermission,
+		Err:  syscall.EBADF,
 	}
 }
 
@@ -167,7 +304,7 @@ func (f *readOnlyFile) Readdirnames(n int) ([]string, error) {
 	return nil, &fs.PathError{
 		Op:   "readdirnames",
 		Path: f.info.name,
-		Err:  fs.ErrInvalid,
+		Err:  syscall.ENOTDIR,
 	}
 }
 
@@ -175,6 +312,87 @@ func (f *readOnlyFile) Name() string {
 	return f.info.name
 }
 
+// Dup returns a new read handle on the same content, positioned at f's
+// current offset, which then reads and seeks independently of f. When the
+// store's reader supports ReadAt, as the files of a Folder store do, the
+// duplicate reads through it without opening the content again; otherwise
+// it opens a stream from the store starting at the offset. Parsers can
+// use it to fork lookahead readers over large files.
+//
+// Each duplicate must be closed, and the shared store reader stays open
+// until f and all its duplicates are. Duplicates do not take a hydration
+// slot. Like Read, Dup must not be called concurrently with other calls
+// on f other than ReadAt.
+func (f *readOnlyFile) Dup() (File, error) {
+	if f.closed() {
+		return nil, closedError("dup", f.info.name)
+	}
+	d := &readOnlyFile{
+		info:      f.info,
+		pos:       f.pos,
+		openRange: f.openRange,
+		closed:    f.closed,
+		track:     f.track,
+	}
+	switch {
+	case f.at != nil:
+		if f.shared == nil {
+			f.shared = &sharedReader{Closer: f.ReadCloser}
+			f.unref = f.shared.ref()
+		}
+		section := io.NewSectionReader(f.at, 0, f.info.size)
+		section.Seek(f.pos, io.SeekStart)
+		d.ReadCloser, d.at = sectionFile{section}, f.at
+		d.shared, d.unref = f.shared, f.shared.ref()
+	case f.openRange != nil:
+		rc, err := f.openRange(f.pos, -1)
+		if err != nil {
+			return nil, &fs.PathError{Op: "dup", Path: f.info.name, Err: err}
+		}
+		d.ReadCloser, d.base = rc, f.pos
+	default:
+		return nil, &fs.PathError{
+			Op:   "dup",
+			Path: f.info.name,
+			Err:  fmt.Errorf("Dup not supported on streaming files"),
+		}
+	}
+	if d.track != nil {
+		d.release = d.track()
+	}
+	return d, nil
+}
+
+// sharedReader is a store reader shared by a file and its duplicates,
+// closed when the last of them is.
+type sharedReader struct {
+	io.Closer
+	refs atomic.Int64
+}
+
+// ref adds a reference and returns the function that drops it, which may
+// be called more than once.
+func (s *sharedReader) ref() func() error {
+	s.refs.Add(1)
+	var once sync.Once
+	return func() (err error) {
+		once.Do(func() {
+			if s.refs.Add(-1) == 0 {
+				err = s.Closer.Close()
+			}
+		})
+		return err
+	}
+}
+
+// sectionFile is a duplicate's view of a shared store reader. Closing it
+// leaves the shared reader open.
+type sectionFile struct {
+	*io.SectionReader
+}
+
+func (sectionFile) Close() error { return nil }
+
 // writeFile implements File for write operations.
 type writeFile struct {
 	*os.File
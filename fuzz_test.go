@@ -0,0 +1,146 @@
+package c4fs
+
+import (
+	"bytes"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/Avalanche-io/c4/store"
+)
+
+func FuzzCleanPath(f *testing.F) {
+	for _, seed := range []string{"", ".", "/", "a/b", "/a/../b", "a//b/./c/", "../..", `a\b`} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, p string) {
+		c := cleanPath(p)
+		if cleanPath(c) != c {
+			t.Errorf("cleanPath not idempotent: %q -> %q -> %q", p, c, cleanPath(c))
+		}
+		if strings.Contains(c, "//") {
+			t.Errorf("cleanPath(%q) = %q contains empty element", p, c)
+		}
+		for _, elem := range strings.Split(strings.TrimPrefix(c, "/"), "/") {
+			if elem == "." {
+				t.Errorf("cleanPath(%q) = %q contains '.' element", p, c)
+			}
+		}
+	})
+}
+
+func FuzzResolveSymlink(f *testing.F) {
+	f.Add("target.txt", "link")
+	f.Add("../../etc/passwd", "link")
+	f.Add("/", "link/x")
+	f.Add("link", "link")
+	f.Add("dir/../link", "dir/link/a/b")
+	f.Fuzz(func(t *testing.T, target, name string) {
+		c4fs := New(NewStoreAdapter(store.NewRAM()))
+		c4fs.Mkdir("dir", 0755)
+		c4fs.WriteFile("target.txt", []byte("content"), 0644)
+		c4fs.Symlink(target, "link")
+		c4fs.Symlink(target, "dir/link")
+
+		// Must not panic; any resolved entry must lie inside the tree
+		entry, err := c4fs.resolveSymlink(name, 40)
+		if err != nil {
+			return
+		}
+		if entry.Name == ".." || strings.HasPrefix(entry.Name, "../") {
+			t.Errorf("resolveSymlink(%q) escaped the root: %q", name, entry.Name)
+		}
+		c4fs.Stat(name)
+		c4fs.ReadFile(name)
+	})
+}
+
+func FuzzTombstones(f *testing.F) {
+	f.Add([]byte{0, 1, 2, 3, 4, 5})
+	f.Add([]byte{2, 2, 0, 0, 1, 1})
+	f.Fuzz(func(t *testing.T, ops []byte) {
+		adapter := NewStoreAdapter(store.NewRAM())
+		base := New(adapter)
+		base.WriteFile("a", []byte("a"), 0644)
+		base.Mkdir("d", 0755)
+		base.WriteFile("d/b", []byte("b"), 0644)
+		c4fs := New(adapter, WithBase(base.Flatten()))
+
+		names := []string{"a", "d", "d/b", "c", "d/c"}
+		for i, op := range ops {
+			name := names[int(op>>3)%len(names)]
+			other := names[i%len(names)]
+			switch op % 6 {
+			case 0:
+				c4fs.WriteFile(name, []byte{op}, 0644)
+			case 1:
+				c4fs.Remove(name)
+			case 2:
+				c4fs.RemoveAll(name)
+			case 3:
+				c4fs.Rename(name, other)
+			case 4:
+				c4fs.Mkdir(name, 0755)
+			case 5:
+				c4fs.Symlink(other, name)
+			}
+		}
+
+		// Every listed child must exist, and every existing path must be listed
+		for _, dir := range []string{"", "d"} {
+			if dir != "" && !c4fs.IsDir(dir) {
+				continue
+			}
+			entries, _ := c4fs.ReadDir(dir)
+			listed := make(map[string]bool)
+			for _, e := range entries {
+				p := path.Join(dir, e.Name())
+				listed[p] = true
+				if _, err := c4fs.Lstat(p); err != nil {
+					t.Errorf("ReadDir(%q) lists %q but Lstat fails: %v", dir, p, err)
+				}
+			}
+			for _, name := range names {
+				if path.Dir(name) != path.Clean("/" + dir)[1:] && !(dir == "" && path.Dir(name) == ".") {
+					continue
+				}
+				if _, err := c4fs.Lstat(name); err == nil && !listed[name] {
+					t.Errorf("%q exists but is not listed by ReadDir(%q)", name, dir)
+				}
+			}
+		}
+	})
+}
+
+func FuzzLoadSnapshot(f *testing.F) {
+	c4fs := New(NewStoreAdapter(store.NewRAM()))
+	c4fs.WriteFile("a.txt", []byte("a"), 0644)
+	c4fs.Symlink("a.txt", "link")
+	var buf bytes.Buffer
+	c4fs.SaveSnapshot(&buf, SnapshotBinary)
+	f.Add(buf.Bytes())
+	f.Add([]byte("C4FS\x01\x01\x00\xff\xff\xff\xff\x0f"))
+	f.Add([]byte("@c4m 1.0\n"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		m, err := LoadSnapshot(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		// A loaded snapshot must be usable without panicking
+		fsys := New(NewStoreAdapter(store.NewRAM()), WithBase(m))
+		for _, e := range m.Entries {
+			fsys.Stat(e.Name)
+			fsys.ReadDir(path.Dir(e.Name))
+		}
+		Canonicalize(m)
+	})
+}
+
+func FuzzReadManifestJSON(f *testing.F) {
+	f.Add([]byte(`{"path":"a","type":"file","perm":420,"size":1,"mtime":"2024-01-01T00:00:00Z"}`))
+	f.Add([]byte(`{"path":"../x","type":"symlink","target":"/etc"}`))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		ReadManifestJSON(bytes.NewReader(data))
+	})
+}
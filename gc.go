@@ -0,0 +1,542 @@
+package c4fs
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"reflect"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/store"
+)
+
+// GCOptions controls the behavior of CollectGarbage.
+type GCOptions struct {
+	// Workers is the number of snapshots loaded and marked concurrently.
+	// Zero means runtime.GOMAXPROCS(0).
+	Workers int
+
+	// Live adds content referenced outside the snapshots, such as that
+	// of mounted filesystems (see FS.ReferencedIDs).
+	Live map[c4.ID]bool
+
+	// Checkpoint, if set, is a file recording the progress of the
+	// collection, so that running it again with the same roots after an
+	// interruption resumes where it stopped. It is removed once the
+	// collection completes.
+	Checkpoint string
+
+	// DryRun finds the garbage without removing it.
+	DryRun bool
+
+	// Progress, if set, is called after each snapshot is marked and each
+	// blob is swept.
+	Progress func(GCProgress)
+}
+
+// GCProgress reports the state of a running garbage collection.
+type GCProgress struct {
+	Snapshots int           // Number of snapshots to mark
+	Marked    int           // Snapshots marked so far
+	Live      int           // Distinct blobs found to be live
+	Blobs     int           // Number of blobs in the store; zero until the sweep
+	Swept     int           // Blobs swept so far
+	Removed   int           // Blobs removed, or found to be garbage on a dry run
+	Bytes     int64         // Bytes held by the removed blobs
+	Elapsed   time.Duration // Time since the collection started
+}
+
+// CollectGarbage removes the blobs of s that are not referenced by any of
+// the snapshots roots, which are stored in s (see Registry), or by
+// opts.Live. The snapshots themselves are kept. It marks the snapshots
+// concurrently, then sweeps the store, which must be listable.
+//
+// Blobs stored after the collection started are never removed, so that
+// content being written is safe as long as s reports blob times (see
+// BlobInfo); with other stores, writers must be paused. A resumed
+// collection counts from the original start. Content that a StoreAdapter
+// of s checks for or puts while the collection runs is kept too, so that
+// a writer finding a garbage blob already stored can reference it rather
+// than write it again; writers going through another store wrapping s
+// are not seen.
+//
+// A snapshot that cannot be loaded stops the collection before anything
+// is removed. The collection also stops if ctx is cancelled; the returned
+// progress describes what was done.
+func CollectGarbage(ctx context.Context, s store.Store, roots []c4.ID, opts GCOptions) (GCProgress, error) {
+	start := time.Now()
+	var p GCProgress
+	report := func() {
+		p.Elapsed = time.Since(start)
+		if opts.Progress != nil {
+			opts.Progress(p)
+		}
+	}
+
+	roots = append([]c4.ID(nil), roots...)
+	sort.Slice(roots, func(i, j int) bool { return roots[i].Less(roots[j]) })
+	p.Snapshots = len(roots)
+
+	cp, err := openGCCheckpoint(opts.Checkpoint, roots, start)
+	if err != nil {
+		return p, err
+	}
+	defer cp.close()
+	p.Removed, p.Bytes = cp.removed, cp.bytes
+
+	touched := watchStore(s)
+	defer touched.stop()
+
+	live := cp.live
+	for id := range opts.Live {
+		live[id] = true
+	}
+	for _, id := range roots {
+		live[id] = true
+	}
+	var pending []c4.ID
+	for _, id := range roots {
+		if cp.marked[id] {
+			p.Marked++
+		} else {
+			pending = append(pending, id)
+		}
+	}
+	p.Live = len(live)
+
+	if err := markSnapshots(ctx, s, pending, opts.Workers, func(root c4.ID, ids []c4.ID) error {
+		var found []c4.ID
+		for _, id := range ids {
+			if !live[id] {
+				live[id] = true
+				found = append(found, id)
+			}
+		}
+		if err := cp.mark(root, found); err != nil {
+			return err
+		}
+		p.Marked++
+		p.Live = len(live)
+		report()
+		return nil
+	}); err != nil {
+		p.Elapsed = time.Since(start)
+		return p, err
+	}
+
+	ids, err := ListIDs(s)
+	if err != nil {
+		p.Elapsed = time.Since(start)
+		return p, fmt.Errorf("failed to list store: %w", err)
+	}
+	p.Blobs = len(ids)
+	adapter := NewStoreAdapter(s)
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			p.Elapsed = time.Since(start)
+			return p, err
+		}
+		if !live[id] {
+			info, err := adapter.Stat(id)
+			if err == nil && !info.ModTime.After(cp.started) {
+				removed, err := touched.removeUnless(id, opts.DryRun)
+				if err != nil && !errors.Is(err, fs.ErrNotExist) {
+					p.Elapsed = time.Since(start)
+					return p, fmt.Errorf("failed to remove %s: %w", id, err)
+				}
+				if removed && !opts.DryRun {
+					if err := cp.remove(id, info.Size); err != nil {
+						p.Elapsed = time.Since(start)
+						return p, err
+					}
+				}
+				if removed {
+					p.Removed++
+					p.Bytes += info.Size
+				}
+			}
+		}
+		p.Swept++
+		report()
+	}
+
+	p.Elapsed = time.Since(start)
+	return p, cp.finish()
+}
+
+// collections are the stores being garbage collected, with the blobs
+// their StoreAdapters checked for or put since the collection started.
+var collections struct {
+	sync.Mutex
+	active []*gcWatch
+	n      atomic.Int32 // len(active), read without the lock
+}
+
+// gcWatch records the blobs used in a store during a collection.
+type gcWatch struct {
+	store store.Store
+
+	mu  sync.Mutex
+	ids map[c4.ID]bool
+}
+
+// watchStore starts recording the blobs used in s. Stores that cannot be
+// compared, which no StoreAdapter could be matched with, are not watched.
+func watchStore(s store.Store) *gcWatch {
+	w := &gcWatch{store: s, ids: make(map[c4.ID]bool)}
+	if !reflect.TypeOf(s).Comparable() {
+		return w
+	}
+	collections.Lock()
+	defer collections.Unlock()
+	collections.active = append(collections.active, w)
+	collections.n.Store(int32(len(collections.active)))
+	return w
+}
+
+// stop stops recording.
+func (w *gcWatch) stop() {
+	collections.Lock()
+	defer collections.Unlock()
+	collections.active = slices.DeleteFunc(collections.active, func(a *gcWatch) bool { return a == w })
+	collections.n.Store(int32(len(collections.active)))
+}
+
+// removeUnless removes id from the store unless it was used since the
+// collection started, and reports whether it did. With dryRun, it only
+// reports whether it would. Uses of id wait for the removal, so a writer
+// checking for id either sees it removed or keeps it.
+func (w *gcWatch) removeUnless(id c4.ID, dryRun bool) (bool, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.ids[id] {
+		return false, nil
+	}
+	if dryRun {
+		return true, nil
+	}
+	if err := w.store.Remove(id); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// touchBlob records that id is being used in s, for the collections of s
+// in progress. It must be called before checking that s holds id.
+func touchBlob(s store.Store, id c4.ID) {
+	if collections.n.Load() == 0 {
+		return
+	}
+	collections.Lock()
+	var watches []*gcWatch
+	for _, w := range collections.active {
+		// Only comparable stores are watched, so this cannot panic
+		if w.store == s {
+			watches = append(watches, w)
+		}
+	}
+	collections.Unlock()
+	for _, w := range watches {
+		w.mu.Lock()
+		w.ids[id] = true
+		w.mu.Unlock()
+	}
+}
+
+// markSnapshots loads the snapshots roots from s with the given number of
+// workers and calls mark with the content IDs of each. Calls to mark are
+// serialized; an error from mark or a failure to load a snapshot stops
+// the marking.
+func markSnapshots(ctx context.Context, s store.Store, roots []c4.ID, workers int, mark func(root c4.ID, ids []c4.ID) error) error {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type marked struct {
+		root c4.ID
+		ids  []c4.ID
+		err  error
+	}
+	todo := make(chan c4.ID)
+	results := make(chan marked)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for root := range todo {
+				ids, err := snapshotContent(s, root)
+				select {
+				case results <- marked{root, ids, err}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		defer close(todo)
+		for _, root := range roots {
+			select {
+			case todo <- root:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	for i := 0; i < len(roots); i++ {
+		var m marked
+		select {
+		case m = <-results:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if m.err != nil {
+			return m.err
+		}
+		if err := mark(m.root, m.ids); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// snapshotContent loads the snapshot id from s and returns the IDs of the
+// content and metadata it references.
+func snapshotContent(s store.Store, id c4.ID) ([]c4.ID, error) {
+	rc, err := s.Open(id)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot %s: %w", id, err)
+	}
+	defer rc.Close()
+	m, err := LoadSnapshot(rc)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot %s: %w", id, err)
+	}
+	var ids []c4.ID
+	if !m.Data.IsNil() {
+		ids = append(ids, m.Data)
+	}
+	for _, e := range m.Entries {
+		if id, ok := contentID(e); ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// gcCheckpoint is the progress of a garbage collection, recorded in an
+// append-only file of lines:
+//
+//	start <time> <roots digest>
+//	live <id>           content found live
+//	marked <id>         a snapshot whose content has all been recorded
+//	removed <id> <size> a blob swept
+//
+// A checkpoint for other roots is discarded. Without a file, progress is
+// only kept in memory.
+type gcCheckpoint struct {
+	path    string
+	f       *os.File
+	w       *bufio.Writer
+	started time.Time
+	live    map[c4.ID]bool
+	marked  map[c4.ID]bool
+	removed int
+	bytes   int64
+}
+
+// openGCCheckpoint loads the checkpoint at path for roots, or starts one
+// at start.
+func openGCCheckpoint(path string, roots []c4.ID, start time.Time) (*gcCheckpoint, error) {
+	cp := &gcCheckpoint{
+		path:    path,
+		started: start,
+		live:    make(map[c4.ID]bool),
+		marked:  make(map[c4.ID]bool),
+	}
+	if path == "" {
+		return cp, nil
+	}
+
+	var b strings.Builder
+	for _, id := range roots {
+		b.WriteString(id.String())
+	}
+	digest := c4.Identify(strings.NewReader(b.String())).String()
+
+	resumed, err := cp.load(digest)
+	if err != nil {
+		return nil, err
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if !resumed {
+		flags |= os.O_TRUNC
+	}
+	cp.f, err = os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open checkpoint: %w", err)
+	}
+	cp.w = bufio.NewWriter(cp.f)
+	if !resumed {
+		fmt.Fprintf(cp.w, "start %s %s\n", start.UTC().Format(time.RFC3339Nano), digest)
+		if err := cp.sync(); err != nil {
+			return nil, err
+		}
+	}
+	return cp, nil
+}
+
+// load reads the checkpoint file and reports whether it was made for the
+// roots with the given digest. A truncated last line is ignored.
+func (cp *gcCheckpoint) load(digest string) (bool, error) {
+	f, err := os.Open(cp.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to open checkpoint: %w", err)
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	if !sc.Scan() {
+		return false, nil
+	}
+	header := strings.Fields(sc.Text())
+	if len(header) != 3 || header[0] != "start" || header[2] != digest {
+		return false, nil
+	}
+	started, err := time.Parse(time.RFC3339Nano, header[1])
+	if err != nil {
+		return false, nil
+	}
+	cp.started = started
+
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		id, err := c4.Parse(fields[1])
+		if err != nil {
+			continue
+		}
+		switch {
+		case fields[0] == "live":
+			cp.live[id] = true
+		case fields[0] == "marked":
+			cp.marked[id] = true
+		case fields[0] == "removed" && len(fields) == 3:
+			size, _ := strconv.ParseInt(fields[2], 10, 64)
+			cp.removed++
+			cp.bytes += size
+		}
+	}
+	return true, sc.Err()
+}
+
+// mark records the newly found live content of the snapshot root.
+func (cp *gcCheckpoint) mark(root c4.ID, found []c4.ID) error {
+	if cp.w == nil {
+		return nil
+	}
+	for _, id := range found {
+		fmt.Fprintf(cp.w, "live %s\n", id)
+	}
+	fmt.Fprintf(cp.w, "marked %s\n", root)
+	return cp.sync()
+}
+
+// remove records a swept blob.
+func (cp *gcCheckpoint) remove(id c4.ID, size int64) error {
+	if cp.w == nil {
+		return nil
+	}
+	fmt.Fprintf(cp.w, "removed %s %d\n", id, size)
+	return cp.w.Flush()
+}
+
+func (cp *gcCheckpoint) sync() error {
+	if err := cp.w.Flush(); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	if err := cp.f.Sync(); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	return nil
+}
+
+// finish removes the checkpoint of a completed collection.
+func (cp *gcCheckpoint) finish() error {
+	if cp.f == nil {
+		return nil
+	}
+	cp.close()
+	return os.Remove(cp.path)
+}
+
+func (cp *gcCheckpoint) close() {
+	if cp.f != nil {
+		cp.w.Flush()
+		cp.f.Close()
+		cp.f, cp.w = nil, nil
+	}
+}
+
+// CollectGarbage removes the blobs of the registry's store that are
+// neither pinned, recorded as proxies, nor referenced by a snapshot one of
+// its references points at, including the build cache, or by opts.Live.
+// Content that is only in use by a filesystem must be passed in opts.Live
+// (see FS.ReferencedIDs), or snapshotted and referenced first. See the CollectGarbage function
+// for the other options.
+func (r *Registry) CollectGarbage(ctx context.Context, opts GCOptions) (GCProgress, error) {
+	refs, err := r.Refs()
+	if err != nil {
+		return GCProgress{}, fmt.Errorf("failed to list references: %w", err)
+	}
+	pins, err := r.Pins()
+	if err != nil {
+		return GCProgress{}, fmt.Errorf("failed to list pins: %w", err)
+	}
+	proxies, err := r.proxies()
+	if err != nil {
+		return GCProgress{}, fmt.Errorf("failed to list proxies: %w", err)
+	}
+
+	seen := make(map[c4.ID]bool, len(refs))
+	var roots []c4.ID
+	for _, id := range refs {
+		if !seen[id] {
+			seen[id] = true
+			roots = append(roots, id)
+		}
+	}
+	live := make(map[c4.ID]bool, len(opts.Live)+len(pins))
+	for id := range opts.Live {
+		live[id] = true
+	}
+	for _, id := range append(pins, proxies...) {
+		live[id] = true
+	}
+	opts.Live = live
+	return CollectGarbage(ctx, r.store.store, roots, opts)
+}
@@ -1,8 +1,15 @@
 package c4fs
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/Avalanche-io/c4"
 	"github.com/Avalanche-io/c4/c4m"
 	"github.com/Avalanche-io/c4/store"
 )
@@ -10,7 +17,7 @@ import (
 // TestReferencedIDs tests that ReferencedIDs correctly identifies all referenced C4 IDs.
 func TestReferencedIDs(t *testing.T) {
 	adapter := NewStoreAdapter(store.NewRAM())
-	c4fs := New(nil, adapter)
+	c4fs := New(adapter)
 
 	// Write some files
 	err := c4fs.WriteFile("file1.txt", []byte("content1"), 0644)
@@ -60,7 +67,7 @@ func TestReferencedIDsWithLayer(t *testing.T) {
 
 	// Create base manifest with some files
 	base := c4m.NewManifest()
-	c4fs := New(base, adapter)
+	c4fs := New(adapter, WithBase(base))
 
 	err := c4fs.WriteFile("base_file.txt", []byte("base content"), 0644)
 	if err != nil {
@@ -76,7 +83,7 @@ func TestReferencedIDsWithLayer(t *testing.T) {
 	base = c4fs.Flatten()
 
 	// Create new filesystem with this base
-	c4fs2 := New(base, adapter)
+	c4fs2 := New(adapter, WithBase(base))
 
 	// Overwrite a file in the layer
 	err = c4fs2.WriteFile("overwrite_file.txt", []byte("layer version"), 0644)
@@ -117,7 +124,7 @@ func TestReferencedIDsWithLayer(t *testing.T) {
 // TestReferencedIDsEmptyFiles tests that empty files (Size = 0) are not included.
 func TestReferencedIDsEmptyFiles(t *testing.T) {
 	adapter := NewStoreAdapter(store.NewRAM())
-	c4fs := New(nil, adapter)
+	c4fs := New(adapter)
 
 	// Write an empty file
 	err := c4fs.WriteFile("empty.txt", []byte(""), 0644)
@@ -138,3 +145,242 @@ func TestReferencedIDsEmptyFiles(t *testing.T) {
 		t.Errorf("Expected 1 referenced ID (empty files excluded), got %d", len(refs))
 	}
 }
+
+// gcFixture stores three snapshots, each with a file of its own and one
+// they share, plus unreferenced blobs.
+func gcFixture(t *testing.T) (*store.RAM, []c4.ID, []c4.ID) {
+	t.Helper()
+	ram := store.NewRAM()
+	adapter := NewStoreAdapter(ram)
+	reg, err := NewRegistry(adapter, t.TempDir())
+	if err != nil {
+		t.Fatalf("NewRegistry failed: %v", err)
+	}
+
+	var roots []c4.ID
+	for i := 0; i < 3; i++ {
+		fsys := New(adapter)
+		fsys.WriteFile("shared.txt", []byte("shared"), 0644)
+		fsys.WriteFile(fmt.Sprintf("own%d.txt", i), []byte(fmt.Sprintf("own %d", i)), 0644)
+		id, err := reg.Put(fsys.Flatten())
+		if err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		roots = append(roots, id)
+	}
+
+	var garbage []c4.ID
+	for i := 0; i < 4; i++ {
+		id, _ := adapter.Put(strings.NewReader(fmt.Sprintf("garbage %d", i)))
+		garbage = append(garbage, id)
+	}
+	return ram, roots, garbage
+}
+
+func TestCollectGarbage(t *testing.T) {
+	ram, roots, garbage := gcFixture(t)
+	kept, _ := NewStoreAdapter(ram).Put(strings.NewReader("mounted"))
+
+	p, err := CollectGarbage(context.Background(), ram, roots, GCOptions{
+		DryRun: true,
+		Live:   map[c4.ID]bool{kept: true},
+	})
+	if err != nil {
+		t.Fatalf("Dry run failed: %v", err)
+	}
+	if p.Removed != len(garbage) || len(*ram) != 12 {
+		t.Errorf("Dry run: removed=%d, store has %d blobs; want 4 and 12", p.Removed, len(*ram))
+	}
+
+	var calls int
+	p, err = CollectGarbage(context.Background(), ram, roots, GCOptions{
+		Workers:  2,
+		Live:     map[c4.ID]bool{kept: true},
+		Progress: func(GCProgress) { calls++ },
+	})
+	if err != nil {
+		t.Fatalf("CollectGarbage failed: %v", err)
+	}
+	// 3 snapshots, 4 files, 1 mounted blob
+	if p.Snapshots != 3 || p.Marked != 3 || p.Live != 8 {
+		t.Errorf("Mark: got snapshots=%d marked=%d live=%d, want 3/3/8", p.Snapshots, p.Marked, p.Live)
+	}
+	if p.Blobs != 12 || p.Swept != 12 || p.Removed != 4 || p.Bytes != int64(4*len("garbage 0")) {
+		t.Errorf("Sweep: got blobs=%d swept=%d removed=%d bytes=%d", p.Blobs, p.Swept, p.Removed, p.Bytes)
+	}
+	if calls != 15 {
+		t.Errorf("Progress callback called %d times, want 15", calls)
+	}
+	for _, id := range garbage {
+		if _, ok := (*ram)[id]; ok {
+			t.Errorf("Garbage %s not removed", id)
+		}
+	}
+	if _, ok := (*ram)[kept]; !ok {
+		t.Error("Live blob removed")
+	}
+
+	// A missing snapshot stops the collection before the sweep
+	delete(*ram, roots[0])
+	if _, err := CollectGarbage(context.Background(), ram, roots, GCOptions{}); err == nil {
+		t.Error("Expected an error for a missing snapshot")
+	}
+	if len(*ram) != 7 {
+		t.Errorf("Store has %d blobs after a failed collection, want 7", len(*ram))
+	}
+}
+
+func TestCollectGarbageKeepsDeduplicated(t *testing.T) {
+	ram, roots, garbage := gcFixture(t)
+	adapter := NewStoreAdapter(ram)
+
+	// A writer stores content the store already holds as garbage while
+	// the snapshots are marked
+	var reused c4.ID
+	p, err := CollectGarbage(context.Background(), ram, roots, GCOptions{
+		Progress: func(p GCProgress) {
+			if p.Marked == 1 && reused.IsNil() {
+				reused, _ = adapter.Put(strings.NewReader("garbage 0"))
+			}
+		},
+	})
+	if err != nil {
+		t.Fatalf("CollectGarbage failed: %v", err)
+	}
+	if reused != garbage[0] {
+		t.Fatal("The writer did not reuse the garbage blob")
+	}
+	if _, ok := (*ram)[reused]; !ok {
+		t.Error("Blob reused during the collection was removed")
+	}
+	if p.Removed != len(garbage)-1 {
+		t.Errorf("Removed %d blobs, want %d", p.Removed, len(garbage)-1)
+	}
+
+	// Once the collection is over, it is garbage again
+	if p, _ := CollectGarbage(context.Background(), ram, roots, GCOptions{}); p.Removed != 1 {
+		t.Errorf("Next collection removed %d blobs, want 1", p.Removed)
+	}
+}
+
+func TestCollectGarbageResume(t *testing.T) {
+	ram, roots, _ := gcFixture(t)
+	checkpoint := filepath.Join(t.TempDir(), "gc.checkpoint")
+
+	// Interrupt the collection after the first snapshot is marked
+	ctx, cancel := context.WithCancel(context.Background())
+	p, err := CollectGarbage(ctx, ram, roots, GCOptions{
+		Workers:    1,
+		Checkpoint: checkpoint,
+		Progress: func(p GCProgress) {
+			if p.Marked == 1 {
+				cancel()
+			}
+		},
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected cancellation, got %v", err)
+	}
+	if p.Marked != 1 || p.Removed != 0 {
+		t.Errorf("Interrupted: marked=%d removed=%d, want 1/0", p.Marked, p.Removed)
+	}
+
+	var marked []int
+	p, err = CollectGarbage(context.Background(), ram, roots, GCOptions{
+		Checkpoint: checkpoint,
+		Progress:   func(p GCProgress) { marked = append(marked, p.Marked) },
+	})
+	if err != nil {
+		t.Fatalf("Resumed collection failed: %v", err)
+	}
+	if marked[0] != 2 {
+		t.Errorf("First snapshot marked again on resume: progress %v", marked)
+	}
+	if p.Marked != 3 || p.Removed != 4 || len(*ram) != 7 {
+		t.Errorf("Resumed: marked=%d removed=%d, %d blobs left; want 3/4/7", p.Marked, p.Removed, len(*ram))
+	}
+	if _, err := os.Stat(checkpoint); !os.IsNotExist(err) {
+		t.Errorf("Checkpoint not removed: %v", err)
+	}
+}
+
+func TestRegistryCollectGarbage(t *testing.T) {
+	ram := store.NewRAM()
+	adapter := NewStoreAdapter(ram)
+	reg, err := NewRegistry(adapter, t.TempDir())
+	if err != nil {
+		t.Fatalf("NewRegistry failed: %v", err)
+	}
+
+	snapshot := func(name, content string) c4.ID {
+		t.Helper()
+		fsys := New(adapter)
+		fsys.WriteFile("file.txt", []byte(content), 0644)
+		id, err := reg.Publish(name, c4.ID{}, fsys.Flatten())
+		if err != nil {
+			t.Fatalf("Publish failed: %v", err)
+		}
+		return id
+	}
+	kept := snapshot("backups/1", "kept")
+	nested := snapshot("team/wip/snap", "nested")
+	dropped := snapshot("backups/2", "dropped")
+	if err := reg.CacheResult(dropped, kept); err != nil {
+		t.Fatalf("CacheResult failed: %v", err)
+	}
+	if err := reg.DeleteRef("backups/2"); err != nil {
+		t.Fatalf("DeleteRef failed: %v", err)
+	}
+
+	pinned, _ := adapter.Put(strings.NewReader("upload in progress"))
+	garbage, _ := adapter.Put(strings.NewReader("garbage"))
+	if err := reg.Pin(pinned); err != nil {
+		t.Fatalf("Pin failed: %v", err)
+	}
+	refs, err := reg.Refs()
+	if err != nil || len(refs) != 3 {
+		t.Fatalf("Refs = %v, %v; want the backup, the nested ref and the build cache entry", refs, err)
+	}
+
+	p, err := reg.CollectGarbage(context.Background(), GCOptions{})
+	if err != nil {
+		t.Fatalf("CollectGarbage failed: %v", err)
+	}
+	// The dropped snapshot, its file and the garbage go
+	if p.Snapshots != 2 || p.Removed != 3 {
+		t.Errorf("Got snapshots=%d removed=%d, want 2/3", p.Snapshots, p.Removed)
+	}
+	if _, ok := (*ram)[pinned]; !ok {
+		t.Error("Pinned blob removed")
+	}
+	if _, ok := (*ram)[garbage]; ok {
+		t.Error("Garbage not removed")
+	}
+	for _, id := range []c4.ID{kept, nested} {
+		if _, err := reg.Get(id); err != nil {
+			t.Errorf("Referenced snapshot removed: %v", err)
+		}
+	}
+	if data, err := New(adapter, WithBase(mustGet(t, reg, kept))).ReadFile("file.txt"); err != nil || string(data) != "kept" {
+		t.Errorf("Referenced content = %q, %v", data, err)
+	}
+
+	if err := reg.Unpin(pinned); err != nil {
+		t.Fatalf("Unpin failed: %v", err)
+	}
+	if pins, _ := reg.Pins(); len(pins) != 0 {
+		t.Errorf("Pins after Unpin = %v", pins)
+	}
+	if p, _ := reg.CollectGarbage(context.Background(), GCOptions{}); p.Removed != 1 {
+		t.Errorf("Collection after Unpin removed %d blobs, want 1", p.Removed)
+	}
+}
+
+func mustGet(t *testing.T, reg *Registry, id c4.ID) *c4m.Manifest {
+	t.Helper()
+	m, err := reg.Get(id)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	return m
+}
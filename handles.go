@@ -0,0 +1,87 @@
+package c4fs
+
+import (
+	"runtime/debug"
+	"slices"
+	"sync"
+	"time"
+)
+
+// HandleInfo describes an open file, as reported by OpenHandles.
+type HandleInfo struct {
+	Name   string    // Name the file was opened with
+	Write  bool      // Opened for writing
+	Opened time.Time // When the file was opened
+	Stack  string    // Goroutine stack at open, if stacks are captured
+}
+
+// handleTracker records the files open on a filesystem.
+type handleTracker struct {
+	stacks bool
+
+	mu   sync.Mutex
+	next uint64
+	open map[uint64]HandleInfo
+}
+
+// track records a file as open and returns the function that records it
+// as closed, which may be called more than once.
+func (t *handleTracker) track(name string, write bool) func() {
+	info := HandleInfo{Name: name, Write: write, Opened: time.Now()}
+	if t.stacks {
+		info.Stack = string(debug.Stack())
+	}
+
+	t.mu.Lock()
+	id := t.next
+	t.next++
+	t.open[id] = info
+	t.mu.Unlock()
+
+	return func() {
+		t.mu.Lock()
+		delete(t.open, id)
+		t.mu.Unlock()
+	}
+}
+
+// trackHandle records a file as open if the filesystem tracks handles,
+// and returns the function to call when it is closed.
+func (c4fs *FS) trackHandle(name string, write bool) func() {
+	if c4fs.handles == nil {
+		return func() {}
+	}
+	return c4fs.handles.track(name, write)
+}
+
+// OpenHandles returns the files currently open on the filesystem, oldest
+// first, or nil unless handle tracking is enabled with WithHandleTracking.
+// Services can log handles open for longer than expected to find leaks,
+// which keep write buffers, hydration slots and store readers alive.
+func (c4fs *FS) OpenHandles() []HandleInfo {
+	t := c4fs.handles
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ids := make([]uint64, 0, len(t.open))
+	for id := range t.open {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	handles := make([]HandleInfo, len(ids))
+	for i, id := range ids {
+		handles[i] = t.open[id]
+	}
+	return handles
+}
+
+// newHandleTracker returns a tracker with the same settings as the
+// filesystem's, for a copy that tracks its own handles.
+func (c4fs *FS) newHandleTracker() *handleTracker {
+	if c4fs.handles == nil {
+		return nil
+	}
+	return &handleTracker{stacks: c4fs.handles.stacks, open: make(map[uint64]HandleInfo)}
+}
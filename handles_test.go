@@ -0,0 +1,62 @@
+package c4fs
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Avalanche-io/c4/store"
+)
+
+func TestOpenHandles(t *testing.T) {
+	fsys := New(NewStoreAdapter(store.NewRAM()), WithHandleTracking(true), WithHydrationLimit(4))
+	fsys.WriteFile("a.txt", []byte("alpha"), 0644)
+	fsys.Mkdir("dir", 0755)
+
+	r, _ := fsys.Open("a.txt")
+	d, _ := fsys.Open("dir")
+	w, _ := fsys.Create("b.txt")
+
+	handles := fsys.OpenHandles()
+	if len(handles) != 3 {
+		t.Fatalf("OpenHandles returned %d handles, want 3", len(handles))
+	}
+	for i, want := range []struct {
+		name  string
+		write bool
+	}{{"a.txt", false}, {"dir", false}, {"b.txt", true}} {
+		h := handles[i]
+		if h.Name != want.name || h.Write != want.write || h.Opened.IsZero() {
+			t.Errorf("handle %d = %+v, want %s (write %v)", i, h, want.name, want.write)
+		}
+		if !strings.Contains(h.Stack, "TestOpenHandles") {
+			t.Errorf("handle %d stack does not show the opener:\n%s", i, h.Stack)
+		}
+	}
+
+	r.Close()
+	d.Close()
+	w.Close()
+	r.Close() // closing twice does not disturb the count
+	if n := len(fsys.OpenHandles()); n != 0 {
+		t.Errorf("%d handles open after Close, want 0", n)
+	}
+
+	// Clones track their own handles
+	clone := fsys.Clone()
+	clone.WriteFile("c.txt", []byte("charlie"), 0644)
+	f, _ := clone.Open("c.txt")
+	defer f.Close()
+	if n, m := len(clone.OpenHandles()), len(fsys.OpenHandles()); n != 1 || m != 0 {
+		t.Errorf("clone has %d handles and original %d, want 1 and 0", n, m)
+	}
+}
+
+func TestOpenHandlesDisabled(t *testing.T) {
+	fsys := New(NewStoreAdapter(store.NewRAM()))
+	fsys.WriteFile("a.txt", []byte("alpha"), 0644)
+	f, _ := fsys.Open("a.txt")
+	defer f.Close()
+	if h := fsys.OpenHandles(); h != nil {
+		t.Errorf("OpenHandles = %v without tracking, want nil", h)
+	}
+}
@@ -0,0 +1,239 @@
+package c4fs
+
+import (
+	"context"
+	"crypto/sha512"
+	"hash"
+	"io"
+	"io/fs"
+	"sync"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/c4m"
+)
+
+// hydrationCallerKey is the context key for WithHydrationCaller.
+type hydrationCallerKey struct{}
+
+// WithHydrationCaller returns a context that identifies its hydrations as
+// belonging to caller, such as a user, request or job ID. When the
+// filesystem's hydration limit is reached, waiting opens are admitted
+// round-robin across callers, so one caller opening thousands of files
+// cannot starve another opening a few. Opens without a caller share a
+// single queue.
+func WithHydrationCaller(ctx context.Context, caller any) context.Context {
+	return context.WithValue(ctx, hydrationCallerKey{}, caller)
+}
+
+// OpenContext is Open with a context. If the filesystem has a hydration
+// limit (see WithHydrationLimit), the context identifies the caller for
+// fair queuing and cancels the wait for a free slot.
+func (c4fs *FS) OpenContext(ctx context.Context, name string) (_ fs.File, err error) {
+	defer c4fs.startOp("open", name).done(-1, &err)
+
+	if c4fs.isClosed() {
+		return nil, closedError("open", name)
+	}
+
+	// Resolve symlinks (max depth 40)
+	entry, err := c4fs.resolveSymlink(name, 40)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry.IsDir() {
+		return c4fs.openDir(entry.Name, entry)
+	}
+	if isSpecial(entry.Mode) {
+		return nil, specialError("open", name)
+	}
+	return c4fs.openContent(ctx, name, entry)
+}
+
+// openContent opens the content of the regular file entry under the
+// filesystem's hydration limit and handle tracking.
+func (c4fs *FS) openContent(ctx context.Context, name string, entry *c4m.Entry) (fs.File, error) {
+	release, err := c4fs.admit(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := c4fs.openFile(name, entry, release)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return f, nil
+}
+
+// OpenBlob opens the content with the given C4 ID for reading, without
+// resolving a path, for tools that have IDs from manifests or their own
+// databases. The content is verified as it is read: at the end of content
+// that does not hash to id, Read returns an error wrapping an
+// *IDMismatchError instead of io.EOF, so data must not be trusted until
+// io.EOF is seen. Blobs count against the hydration limit and appear in
+// OpenHandles like files.
+func (c4fs *FS) OpenBlob(id c4.ID) (io.ReadCloser, error) {
+	name := id.String()
+	if c4fs.isClosed() {
+		return nil, closedError("open", name)
+	}
+	if id.IsNil() {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	release, err := c4fs.admit(context.Background(), name)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := c4fs.store.Get(id)
+	if err != nil {
+		release()
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &verifiedReader{ReadCloser: rc, id: id, h: sha512.New(), release: release}, nil
+}
+
+// admit waits for a hydration slot, if the filesystem has a limit, and
+// records name as open, if it tracks handles. It returns the function to
+// call when the content is closed.
+func (c4fs *FS) admit(ctx context.Context, name string) (func(), error) {
+	release := func() {}
+	if c4fs.hydration != nil {
+		if err := c4fs.hydration.acquire(ctx); err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		release = c4fs.hydration.release
+	}
+	if c4fs.handles != nil {
+		untrack, unlimit := c4fs.trackHandle(name, false), release
+		release = func() {
+			untrack()
+			unlimit()
+		}
+	}
+	return release, nil
+}
+
+// verifiedReader hashes a blob as it is read and checks it against its ID
+// at the end.
+type verifiedReader struct {
+	io.ReadCloser
+	id      c4.ID
+	h       hash.Hash
+	release func() // called once on Close
+}
+
+func (r *verifiedReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.h.Write(p[:n])
+	if err == io.EOF {
+		if actual := digestID(r.h); actual != r.id {
+			err = &IDMismatchError{Expected: r.id, Actual: actual}
+		}
+	}
+	return n, err
+}
+
+func (r *verifiedReader) Close() error {
+	if r.release != nil {
+		r.release()
+		r.release = nil
+	}
+	return r.ReadCloser.Close()
+}
+
+// hydrationLimiter is a counting semaphore whose waiters are admitted
+// round-robin by caller, and in arrival order within each caller.
+type hydrationLimiter struct {
+	mu     sync.Mutex
+	free   int
+	queues map[any][]chan struct{} // waiters by caller
+	order  []any                   // callers with waiters, next to admit first
+}
+
+func newHydrationLimiter(n int) *hydrationLimiter {
+	return &hydrationLimiter{
+		free:   n,
+		queues: make(map[any][]chan struct{}),
+	}
+}
+
+// acquire waits for a free slot or for ctx to be done.
+func (l *hydrationLimiter) acquire(ctx context.Context) error {
+	caller := ctx.Value(hydrationCallerKey{})
+
+	l.mu.Lock()
+	if l.free > 0 && len(l.order) == 0 {
+		l.free--
+		l.mu.Unlock()
+		return nil
+	}
+	ready := make(chan struct{})
+	if _, waiting := l.queues[caller]; !waiting {
+		l.order = append(l.order, caller)
+	}
+	l.queues[caller] = append(l.queues[caller], ready)
+	l.mu.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	select {
+	case <-ready:
+		// Granted while we were giving up; pass the slot on
+		l.releaseLocked()
+	default:
+		l.dequeueLocked(caller, ready)
+	}
+	return ctx.Err()
+}
+
+// release frees a slot, handing it to the next waiter if there is one.
+func (l *hydrationLimiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.releaseLocked()
+}
+
+func (l *hydrationLimiter) releaseLocked() {
+	if len(l.order) == 0 {
+		l.free++
+		return
+	}
+	caller := l.order[0]
+	l.order = l.order[1:]
+	q := l.queues[caller]
+	close(q[0])
+	if len(q) > 1 {
+		l.queues[caller] = q[1:]
+		l.order = append(l.order, caller)
+	} else {
+		delete(l.queues, caller)
+	}
+}
+
+// dequeueLocked removes an abandoned waiter.
+func (l *hydrationLimiter) dequeueLocked(caller any, ready chan struct{}) {
+	q := l.queues[caller]
+	for i, ch := range q {
+		if ch == ready {
+			q = append(q[:i], q[i+1:]...)
+			break
+		}
+	}
+	if len(q) > 0 {
+		l.queues[caller] = q
+		return
+	}
+	delete(l.queues, caller)
+	for i, c := range l.order {
+		if c == caller {
+			l.order = append(l.order[:i], l.order[i+1:]...)
+			break
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package c4fs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/store"
+)
+
+// waiting returns the number of opens queued on the limiter.
+func (l *hydrationLimiter) waiting() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var n int
+	for _, q := range l.queues {
+		n += len(q)
+	}
+	return n
+}
+
+func waitQueued(t *testing.T, l *hydrationLimiter, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for l.waiting() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %d queued opens, have %d", n, l.waiting())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestC4FSHydrationLimit tests that opens beyond the limit wait for a
+// file to be closed and give up when their context is done.
+func TestC4FSHydrationLimit(t *testing.T) {
+	c4fs := New(NewStoreAdapter(store.NewRAM()), WithHydrationLimit(1))
+	if err := c4fs.WriteFile("file.txt", []byte("content"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if err := c4fs.Mkdir("dir", 0755); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+
+	first, err := c4fs.Open("file.txt")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	// Directories do not hydrate anything
+	d, err := c4fs.Open("dir")
+	if err != nil {
+		t.Fatalf("Open of directory failed: %v", err)
+	}
+	d.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = c4fs.OpenContext(ctx, "file.txt")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("OpenContext at the limit: got %v, want deadline exceeded", err)
+	}
+	var pe *fs.PathError
+	if !errors.As(err, &pe) || pe.Path != "file.txt" {
+		t.Errorf("expected a PathError for file.txt, got %v", err)
+	}
+
+	first.Close()
+	first.Close() // closing twice must not free a second slot
+
+	second, err := c4fs.Open("file.txt")
+	if err != nil {
+		t.Fatalf("Open after Close failed: %v", err)
+	}
+	defer second.Close()
+	if n := c4fs.hydration.free; n != 0 {
+		t.Errorf("free slots: got %d, want 0", n)
+	}
+
+	// Sandbox scratch filesystems share the limit
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := c4fs.fork().OpenContext(ctx, "file.txt"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("scratch OpenContext at the limit: got %v", err)
+	}
+}
+
+// TestC4FSHydrationFairness tests that waiting opens are admitted
+// round-robin across callers.
+func TestC4FSHydrationFairness(t *testing.T) {
+	c4fs := New(NewStoreAdapter(store.NewRAM()), WithHydrationLimit(1))
+	if err := c4fs.WriteFile("file.txt", []byte("content"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	held, err := c4fs.Open("file.txt")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	type admitted struct {
+		caller string
+		f      fs.File
+	}
+	admissions := make(chan admitted)
+	open := func(caller string) {
+		ctx := WithHydrationCaller(context.Background(), caller)
+		f, err := c4fs.OpenContext(ctx, "file.txt")
+		if err != nil {
+			t.Errorf("OpenContext for %s failed: %v", caller, err)
+			return
+		}
+		admissions <- admitted{caller, f}
+	}
+
+	// A bulk job queues three opens before an interactive user queues one
+	for i, caller := range []string{"bulk", "bulk", "bulk", "user"} {
+		go open(caller)
+		waitQueued(t, c4fs.hydration, i+1)
+	}
+
+	held.Close()
+	var order []string
+	for range 4 {
+		a := <-admissions
+		order = append(order, a.caller)
+		a.f.Close()
+	}
+
+	want := []string{"bulk", "user", "bulk", "bulk"}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("admission order: got %v, want %v", order, want)
+		}
+	}
+	if c4fs.hydration.free != 1 || c4fs.hydration.waiting() != 0 {
+		t.Errorf("limiter not drained: %d free, %d waiting", c4fs.hydration.free, c4fs.hydration.waiting())
+	}
+}
+
+func TestC4FSOpenBlob(t *testing.T) {
+	ram := store.NewRAM()
+	c4fs := New(NewStoreAdapter(ram), WithHandleTracking(false))
+	content := []byte("blob content")
+	c4fs.WriteFile("file.txt", content, 0644)
+	id := c4.Identify(bytes.NewReader(content))
+
+	rc, err := c4fs.OpenBlob(id)
+	if err != nil {
+		t.Fatalf("OpenBlob failed: %v", err)
+	}
+	if n := len(c4fs.OpenHandles()); n != 1 {
+		t.Errorf("OpenHandles = %d, want 1", n)
+	}
+	if data, err := io.ReadAll(rc); err != nil || !bytes.Equal(data, content) {
+		t.Errorf("ReadAll = %q, %v", data, err)
+	}
+	rc.Close()
+	if n := len(c4fs.OpenHandles()); n != 0 {
+		t.Errorf("OpenHandles after Close = %d, want 0", n)
+	}
+
+	if _, err := c4fs.OpenBlob(c4.Identify(strings.NewReader("missing"))); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("OpenBlob of a missing blob = %v, want ErrNotExist", err)
+	}
+
+	// Corrupt content is reported at the end instead of io.EOF
+	bad := c4.Identify(strings.NewReader("expected"))
+	w, _ := ram.Create(bad)
+	w.Write([]byte("corrupt"))
+	w.Close()
+	rc, err = c4fs.OpenBlob(bad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	var mismatch *IDMismatchError
+	if _, err := io.ReadAll(rc); !errors.As(err, &mismatch) || mismatch.Expected != bad {
+		t.Errorf("ReadAll of a corrupt blob = %v, want IDMismatchError", err)
+	}
+}
@@ -0,0 +1,111 @@
+package c4fs
+
+import (
+	"bufio"
+	"io"
+	"path"
+	"strings"
+)
+
+// ignoreRule is one line of an ignore file or one ImportOptions.Exclude
+// pattern, in the syntax of .gitignore.
+type ignoreRule struct {
+	base     string   // directory the rule applies under, relative to the import root
+	segs     []string // pattern split on "/"
+	anchored bool     // matches paths from base rather than any name
+	dirOnly  bool     // pattern ended in "/"
+	negate   bool     // pattern started with "!"
+}
+
+// parseIgnoreRule parses a pattern applying under base. It returns false
+// for blank lines and comments.
+func parseIgnoreRule(base, line string) (ignoreRule, bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false
+	}
+	r := ignoreRule{base: base}
+	if rest, ok := strings.CutPrefix(line, "!"); ok {
+		r.negate, line = true, rest
+	} else if strings.HasPrefix(line, `\`) {
+		line = line[1:] // "\!" and "\#" escape the first character
+	}
+	if rest, ok := strings.CutSuffix(line, "/"); ok {
+		r.dirOnly, line = true, rest
+	}
+	if strings.Contains(line, "/") {
+		r.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+	if line == "" {
+		return ignoreRule{}, false
+	}
+	r.segs = strings.Split(line, "/")
+	return r, true
+}
+
+// parseIgnoreFile parses the rules in an ignore file found in base.
+func parseIgnoreFile(base string, r io.Reader) ([]ignoreRule, error) {
+	var rules []ignoreRule
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		if rule, ok := parseIgnoreRule(base, sc.Text()); ok {
+			rules = append(rules, rule)
+		}
+	}
+	return rules, sc.Err()
+}
+
+// match reports whether the rule matches rel, a path relative to the
+// import root.
+func (r ignoreRule) match(rel string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	sub := rel
+	if r.base != "" {
+		var ok bool
+		if sub, ok = strings.CutPrefix(rel, r.base+"/"); !ok {
+			return false
+		}
+	}
+	if !r.anchored {
+		ok, _ := path.Match(r.segs[0], path.Base(sub))
+		return ok
+	}
+	return matchSegments(r.segs, strings.Split(sub, "/"))
+}
+
+// matchSegments matches path segments against pattern segments, where a
+// "**" segment matches any number of path segments.
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := len(name); i >= 0; i-- {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// ignored reports whether rel is excluded by rules. As in .gitignore the
+// last matching rule decides, so a later "!" rule re-includes a path.
+func ignored(rules []ignoreRule, rel string, isDir bool) bool {
+	for i := len(rules) - 1; i >= 0; i-- {
+		if rules[i].match(rel, isDir) {
+			return !rules[i].negate
+		}
+	}
+	return false
+}
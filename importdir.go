@@ -0,0 +1,341 @@
+package c4fs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+
+	"github.com/Avalanche-io/c4/c4m"
+)
+
+// SymlinkPolicy says how ImportDir and ExportDir treat symbolic links.
+type SymlinkPolicy int
+
+const (
+	// SymlinkPreserve copies links as links, with their targets unchanged.
+	SymlinkPreserve SymlinkPolicy = iota
+
+	// SymlinkFollow copies what links point to in their place, the
+	// content of files and the trees of directories. Dangling links and
+	// links to a directory being copied are errors.
+	SymlinkFollow
+
+	// SymlinkReject fails with ErrSymlink at the first link.
+	SymlinkReject
+)
+
+// ErrSymlink is returned by ImportDir and ExportDir for a symbolic link
+// under SymlinkReject.
+var ErrSymlink = errors.New("symbolic link not allowed")
+
+// errSymlinkCycle is returned for a followed link to a directory that
+// contains it.
+var errSymlinkCycle = errors.New("symbolic link cycle")
+
+// ImportOptions controls the behavior of ImportDir.
+//
+// Patterns use the syntax of .gitignore: "*", "?" and "[...]" match
+// within a name and "**" matches any number of directories; a pattern
+// ending in "/" matches only directories; a pattern containing a "/"
+// other than at its end matches paths relative to the import root (or
+// to the directory of the ignore file it came from), and any other
+// pattern matches names at any depth. The last matching pattern
+// decides, and a pattern starting with "!" re-includes what earlier
+// ones excluded. Excluded directories are not read at all.
+type ImportOptions struct {
+	// Exclude lists patterns of paths to skip, like the lines of an
+	// ignore file at the import root.
+	Exclude []string
+
+	// Include, if set, limits the files imported to those matching one
+	// of its patterns. Directories are still walked to find them.
+	Include []string
+
+	// IgnoreFile names per-directory ignore files, such as ".gitignore"
+	// or ".c4ignore". The patterns of each apply to its directory and
+	// below, after Exclude and those of parent directories.
+	IgnoreFile string
+
+	// SkipHidden skips files and directories whose names start with ".".
+	SkipHidden bool
+
+	// MaxSize skips files larger than this many bytes. Zero imports
+	// files of any size.
+	MaxSize int64
+
+	// Symlinks says how symbolic links are imported. Filters apply to
+	// the link's name, and under SymlinkFollow to the type and size of
+	// what it points to.
+	Symlinks SymlinkPolicy
+
+	// SkipSpecial skips devices, named pipes and sockets, which are
+	// otherwise imported with their device numbers.
+	SkipSpecial bool
+}
+
+// ImportDir copies the directory tree at src on the local disk into the
+// filesystem at dst, dehydrating file content into the store. Modes,
+// modification times and, where the platform reports them, access times
+// and hard links are preserved. Regular files, directories, special
+// files and, as set by opts.Symlinks, symbolic links are imported. The
+// import stops early if ctx is cancelled.
+func (c4fs *FS) ImportDir(ctx context.Context, src, dst string, opts ImportOptions) error {
+	dst = strings.TrimPrefix(cleanPath(dst), "/")
+
+	var rules, include []ignoreRule
+	for _, p := range opts.Exclude {
+		if r, ok := parseIgnoreRule("", p); ok {
+			rules = append(rules, r)
+		}
+	}
+	for _, p := range opts.Include {
+		if r, ok := parseIgnoreRule("", p); ok {
+			include = append(include, r)
+		}
+	}
+
+	imp := &importer{fsys: c4fs, opts: opts, include: include}
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return &fs.PathError{Op: "import", Path: src, Err: syscall.ENOTDIR}
+	}
+	if dst != "" {
+		if err := imp.importDirEntry(dst, info); err != nil {
+			return err
+		}
+	}
+	imp.dirs = []fs.FileInfo{info}
+	return imp.walk(ctx, src, "", dst, rules)
+}
+
+// importer holds the state of an ImportDir call.
+type importer struct {
+	fsys    *FS
+	opts    ImportOptions
+	include []ignoreRule
+	dirs    []fs.FileInfo    // directories being walked, to detect link cycles
+	inodes  map[inode]string // first name imported of files with hard links
+}
+
+// walk imports the contents of the local directory dir, which is rel
+// relative to the import root and is imported as name.
+func (imp *importer) walk(ctx context.Context, dir, rel, name string, rules []ignoreRule) error {
+	if imp.opts.IgnoreFile != "" {
+		f, err := os.Open(filepath.Join(dir, imp.opts.IgnoreFile))
+		if err == nil {
+			more, err := parseIgnoreFile(rel, f)
+			f.Close()
+			if err != nil {
+				return err
+			}
+			// Clip so sibling directories do not share appended rules
+			rules = append(slices.Clip(rules), more...)
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if imp.opts.SkipHidden && strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		childRel := path.Join(rel, e.Name())
+		childName := path.Join(name, e.Name())
+		childPath := filepath.Join(dir, e.Name())
+
+		info, err := e.Info()
+		if err != nil {
+			return err
+		}
+		followed := false
+		if info.Mode()&fs.ModeSymlink != 0 && imp.opts.Symlinks == SymlinkFollow {
+			if info, err = os.Stat(childPath); err != nil {
+				return err
+			}
+			followed = true
+		}
+		if ignored(rules, childRel, info.IsDir()) {
+			continue
+		}
+
+		switch {
+		case info.IsDir():
+			if slices.ContainsFunc(imp.dirs, func(d fs.FileInfo) bool { return os.SameFile(d, info) }) {
+				return &fs.PathError{Op: "import", Path: childPath, Err: errSymlinkCycle}
+			}
+			if err := imp.importDirEntry(childName, info); err != nil {
+				return err
+			}
+			imp.dirs = append(imp.dirs, info)
+			err := imp.walk(ctx, childPath, childRel, childName, rules)
+			imp.dirs = imp.dirs[:len(imp.dirs)-1]
+			if err != nil {
+				return err
+			}
+		case info.Mode().IsRegular():
+			if imp.opts.MaxSize > 0 && info.Size() > imp.opts.MaxSize {
+				continue
+			}
+			if !imp.included(childRel) {
+				continue
+			}
+			if !followed {
+				linked, err := imp.importLink(childName, info)
+				if err != nil {
+					return err
+				}
+				if linked {
+					continue
+				}
+			}
+			if err := imp.importFile(childPath, childName, info); err != nil {
+				return err
+			}
+		case info.Mode()&fs.ModeSymlink != 0:
+			if imp.opts.Symlinks == SymlinkReject {
+				return &fs.PathError{Op: "import", Path: childPath, Err: ErrSymlink}
+			}
+			if !imp.included(childRel) {
+				continue
+			}
+			if err := imp.importSymlink(childPath, childName, info); err != nil {
+				return err
+			}
+		case isSpecial(info.Mode()):
+			if imp.opts.SkipSpecial || !imp.included(childRel) {
+				continue
+			}
+			imp.importSpecial(childName, info)
+		}
+	}
+	return nil
+}
+
+// included reports whether the file rel passes the Include patterns.
+func (imp *importer) included(rel string) bool {
+	return len(imp.include) == 0 || slices.ContainsFunc(imp.include, func(r ignoreRule) bool {
+		return r.match(rel, false)
+	})
+}
+
+// importDirEntry creates the directory name with the mode and time of info.
+func (imp *importer) importDirEntry(name string, info fs.FileInfo) error {
+	if err := imp.fsys.MkdirAll(name, info.Mode().Perm()); err != nil {
+		return err
+	}
+	return imp.fsys.Chtimes(name, fileAtime(info), info.ModTime().UTC())
+}
+
+// importFile stores the content of the local file p and links it as name.
+func (imp *importer) importFile(p, name string, info fs.FileInfo) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Count bytes as they are read in case the file changes under us
+	cr := &countingReader{r: f}
+	id, err := imp.fsys.store.Put(cr)
+	if err != nil {
+		return &fs.PathError{
+			Op:   "import",
+			Path: p,
+			Err:  fmt.Errorf("failed to dehydrate content: %w", err),
+		}
+	}
+
+	entry := &c4m.Entry{
+		Mode:      info.Mode().Perm(),
+		Timestamp: info.ModTime().UTC(),
+		Size:      cr.n,
+		Name:      name,
+		C4ID:      id,
+	}
+
+	imp.fsys.mu.Lock()
+	imp.fsys.updateEntryInLayer(entry)
+	if atime := fileAtime(info); !atime.IsZero() {
+		imp.fsys.setAtimeLocked(entry, atime)
+	}
+	imp.fsys.mu.Unlock()
+
+	return nil
+}
+
+// importLink links name to the file already imported with the same
+// inode as info, if any, and reports whether it did. Otherwise it records
+// name as the first link of the inode.
+func (imp *importer) importLink(name string, info fs.FileInfo) (bool, error) {
+	id, ok := fileInode(info)
+	if !ok {
+		return false, nil
+	}
+	first, seen := imp.inodes[id]
+	if !seen {
+		if imp.inodes == nil {
+			imp.inodes = make(map[inode]string)
+		}
+		imp.inodes[id] = name
+		return false, nil
+	}
+
+	imp.fsys.mu.Lock()
+	defer imp.fsys.mu.Unlock()
+	old, err := imp.fsys.lookup(first)
+	if err != nil {
+		return false, err
+	}
+	imp.fsys.linkLocked(old, name)
+	return true, nil
+}
+
+// importSymlink links name to the target of the local link p.
+func (imp *importer) importSymlink(p, name string, info fs.FileInfo) error {
+	target, err := os.Readlink(p)
+	if err != nil {
+		return err
+	}
+	entry := &c4m.Entry{
+		Mode:      fs.ModeSymlink | 0777,
+		Timestamp: info.ModTime().UTC(),
+		Name:      name,
+		Target:    filepath.ToSlash(target),
+	}
+
+	imp.fsys.mu.Lock()
+	imp.fsys.updateEntryInLayer(entry)
+	if atime := fileAtime(info); !atime.IsZero() {
+		imp.fsys.setAtimeLocked(entry, atime)
+	}
+	imp.fsys.mu.Unlock()
+
+	return nil
+}
+
+// importSpecial adds name as a special file like the one of info.
+func (imp *importer) importSpecial(name string, info fs.FileInfo) {
+	imp.fsys.mu.Lock()
+	defer imp.fsys.mu.Unlock()
+	entry := imp.fsys.mknodLocked(name, info.Mode(), fileRdev(info), info.ModTime().UTC())
+	if atime := fileAtime(info); !atime.IsZero() {
+		imp.fsys.setAtimeLocked(entry, atime)
+	}
+}
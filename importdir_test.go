@@ -0,0 +1,231 @@
+package c4fs
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Avalanche-io/c4/store"
+)
+
+// writeTree creates the files of tree under dir, creating parents.
+func writeTree(t *testing.T, dir string, tree map[string]string) {
+	t.Helper()
+	for name, data := range tree {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// fileNames returns the names of the regular files in c4fs, sorted.
+func fileNames(t *testing.T, c4fs *FS) []string {
+	t.Helper()
+	var names []string
+	for _, e := range c4fs.Flatten().Entries {
+		if !e.IsDir() && e.Size >= 0 {
+			names = append(names, e.Name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+func TestImportDir(t *testing.T) {
+	src := t.TempDir()
+	writeTree(t, src, map[string]string{
+		"a.txt":         "hello",
+		"sub/b.txt":     "world",
+		"sub/deep/c.go": "package c",
+	})
+	mtime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	atime := time.Date(2024, 3, 2, 12, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(src, "a.txt"), atime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(src, "sub", "b.txt"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c4fs := New(NewStoreAdapter(store.NewRAM()))
+	if err := c4fs.ImportDir(context.Background(), src, "/data", ImportOptions{}); err != nil {
+		t.Fatalf("ImportDir failed: %v", err)
+	}
+
+	want := []string{"data/a.txt", "data/sub/b.txt", "data/sub/deep/c.go"}
+	if got := fileNames(t, c4fs); !slices.Equal(got, want) {
+		t.Errorf("files = %v, want %v", got, want)
+	}
+	data, err := c4fs.ReadFile("data/sub/deep/c.go")
+	if err != nil || string(data) != "package c" {
+		t.Errorf("ReadFile = %q, %v", data, err)
+	}
+	info, err := c4fs.Stat("data/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(mtime) {
+		t.Errorf("ModTime = %v, want %v", info.ModTime(), mtime)
+	}
+	localInfo, err := os.Stat(filepath.Join(src, "a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fileAtime(localInfo).IsZero() {
+		if got, _ := c4fs.Atime("data/a.txt"); !got.Equal(atime) {
+			t.Errorf("Atime = %v, want %v", got, atime)
+		}
+	}
+	if info, err := c4fs.Stat("data/sub/b.txt"); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Stat(b.txt) mode = %v, %v", info.Mode(), err)
+	}
+	if info, err := c4fs.Stat("data/sub"); err != nil || !info.IsDir() {
+		t.Errorf("Stat(sub) = %v, %v; want directory", info, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := New(NewStoreAdapter(store.NewRAM())).ImportDir(ctx, src, "", ImportOptions{}); err != context.Canceled {
+		t.Errorf("cancelled ImportDir = %v, want context.Canceled", err)
+	}
+	if err := c4fs.ImportDir(context.Background(), filepath.Join(src, "a.txt"), "", ImportOptions{}); err == nil {
+		t.Error("ImportDir of a file succeeded")
+	}
+}
+
+func TestImportDirFilters(t *testing.T) {
+	src := t.TempDir()
+	writeTree(t, src, map[string]string{
+		".hidden":                "h",
+		".cache/blob":            "c",
+		"keep.txt":               "k",
+		"big.bin":                "0123456789",
+		"build/out.o":            "o",
+		"src/main.go":            "m",
+		"src/main.tmp":           "t",
+		"src/important.tmp":      "i",
+		"src/vendor/lib.go":      "l",
+		"src/.c4ignore":          "vendor/\n# comment\n\n!important.tmp\n",
+		"docs/a/b/notes.tmp":     "n",
+		"docs/readme.md":         "r",
+		"logs/2024/app.log":      "a",
+		"logs/2024/keep/app.log": "k",
+	})
+
+	tests := []struct {
+		name string
+		opts ImportOptions
+		want []string
+	}{
+		{
+			name: "exclude patterns",
+			opts: ImportOptions{
+				Exclude:    []string{"*.tmp", "/build/", "logs/**/app.log", "!logs/**/keep/*"},
+				IgnoreFile: ".c4ignore",
+				SkipHidden: true,
+				MaxSize:    5,
+			},
+			want: []string{"docs/readme.md", "keep.txt", "logs/2024/keep/app.log", "src/important.tmp", "src/main.go"},
+		},
+		{
+			name: "include patterns",
+			opts: ImportOptions{Include: []string{"*.go", "docs/**/*.md"}},
+			want: []string{"docs/readme.md", "src/main.go", "src/vendor/lib.go"},
+		},
+		{
+			name: "hidden files kept by default",
+			opts: ImportOptions{Include: []string{".*", "blob"}},
+			want: []string{".cache/blob", ".hidden", "src/.c4ignore"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c4fs := New(NewStoreAdapter(store.NewRAM()))
+			if err := c4fs.ImportDir(context.Background(), src, "", tt.opts); err != nil {
+				t.Fatalf("ImportDir failed: %v", err)
+			}
+			if got := fileNames(t, c4fs); !slices.Equal(got, tt.want) {
+				t.Errorf("files = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Excluded directories are not created
+	c4fs := New(NewStoreAdapter(store.NewRAM()))
+	if err := c4fs.ImportDir(context.Background(), src, "", ImportOptions{Exclude: []string{"build"}}); err != nil {
+		t.Fatal(err)
+	}
+	if c4fs.Exists("build") {
+		t.Error("excluded directory was imported")
+	}
+}
+
+func TestImportDirSymlinks(t *testing.T) {
+	src := t.TempDir()
+	writeTree(t, src, map[string]string{
+		"data/file.txt": "content",
+	})
+	for link, target := range map[string]string{
+		"file.lnk":      "data/file.txt",
+		"dir.lnk":       "data",
+		"data/loop.lnk": "..",
+	} {
+		if err := os.Symlink(target, filepath.Join(src, link)); err != nil {
+			t.Skipf("symlinks not supported: %v", err)
+		}
+	}
+
+	c4fs := New(NewStoreAdapter(store.NewRAM()))
+	if err := c4fs.ImportDir(context.Background(), src, "", ImportOptions{}); err != nil {
+		t.Fatalf("ImportDir with SymlinkPreserve failed: %v", err)
+	}
+	if target, err := c4fs.ReadLink("dir.lnk"); err != nil || target != "data" {
+		t.Errorf("ReadLink(dir.lnk) = %q, %v", target, err)
+	}
+	if data, err := c4fs.ReadFile("file.lnk"); err != nil || string(data) != "content" {
+		t.Errorf("ReadFile(file.lnk) = %q, %v", data, err)
+	}
+
+	err := New(NewStoreAdapter(store.NewRAM())).ImportDir(context.Background(), src, "", ImportOptions{Symlinks: SymlinkReject})
+	if !errors.Is(err, ErrSymlink) {
+		t.Errorf("ImportDir with SymlinkReject = %v, want ErrSymlink", err)
+	}
+
+	// Following the loop back to the root is an error
+	opts := ImportOptions{Symlinks: SymlinkFollow}
+	err = New(NewStoreAdapter(store.NewRAM())).ImportDir(context.Background(), src, "", opts)
+	if !errors.Is(err, errSymlinkCycle) {
+		t.Errorf("ImportDir following a loop = %v, want a cycle error", err)
+	}
+
+	opts.Exclude = []string{"loop.lnk"}
+	c4fs = New(NewStoreAdapter(store.NewRAM()))
+	if err := c4fs.ImportDir(context.Background(), src, "", opts); err != nil {
+		t.Fatalf("ImportDir with SymlinkFollow failed: %v", err)
+	}
+	want := []string{"data/file.txt", "dir.lnk/file.txt", "file.lnk"}
+	if got := fileNames(t, c4fs); !slices.Equal(got, want) {
+		t.Errorf("files = %v, want %v", got, want)
+	}
+	if info, err := c4fs.Lstat("dir.lnk"); err != nil || !info.IsDir() {
+		t.Errorf("Lstat(dir.lnk) = %v, %v; want a directory", info, err)
+	}
+
+	// Dangling links cannot be followed
+	if err := os.Symlink("missing", filepath.Join(src, "dangling.lnk")); err != nil {
+		t.Fatal(err)
+	}
+	err = New(NewStoreAdapter(store.NewRAM())).ImportDir(context.Background(), src, "", opts)
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("ImportDir following a dangling link = %v, want ErrNotExist", err)
+	}
+}
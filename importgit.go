@@ -0,0 +1,625 @@
+package c4fs
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/c4m"
+	"github.com/Avalanche-io/c4/store"
+)
+
+// GitImportOptions controls the behavior of ImportGit.
+type GitImportOptions struct {
+	// IDs maps the object names of git blobs, in hex, to the C4 IDs of
+	// their content. ImportGit adds the blobs it imports and does not read
+	// the ones already mapped again, so passing the same map when
+	// importing many commits of a repository copies each blob once.
+	IDs map[string]c4.ID
+}
+
+// ImportGit converts the tree of a commit in the git repository at repo
+// into a manifest, copying the content of its files into dst, without
+// checking anything out. repo is a working tree or a git directory, bare
+// or not. rev is an object name in hex or a reference such as "HEAD",
+// "main", "v1.0" or "refs/heads/main"; tags are followed to the commit
+// they point at, and a tree may be named directly.
+//
+// Directories, regular and executable files and symbolic links are
+// imported, timestamped with the commit time, or the Unix epoch for a
+// bare tree. Submodules are skipped. Objects are read from loose files
+// and pack files, each held in memory while it is copied.
+func ImportGit(ctx context.Context, repo, rev string, dst store.Store, opts GitImportOptions) (*c4m.Manifest, error) {
+	r, err := openGitRepo(repo)
+	if err != nil {
+		return nil, err
+	}
+	defer r.close()
+
+	sha, err := r.resolve(rev)
+	if err != nil {
+		return nil, err
+	}
+	tree, when, err := r.peelTree(sha)
+	if err != nil {
+		return nil, err
+	}
+
+	imp := &gitImporter{
+		repo:     r,
+		store:    NewStoreAdapter(dst),
+		ids:      opts.IDs,
+		when:     when,
+		manifest: c4m.NewManifest(),
+	}
+	if imp.ids == nil {
+		imp.ids = make(map[string]c4.ID)
+	}
+	if err := imp.tree(ctx, tree, ""); err != nil {
+		return nil, err
+	}
+	return Canonicalize(imp.manifest), nil
+}
+
+// gitImporter holds the state of an ImportGit call.
+type gitImporter struct {
+	repo     *gitRepo
+	store    *StoreAdapter
+	ids      map[string]c4.ID
+	when     time.Time
+	manifest *c4m.Manifest
+}
+
+// tree adds the entries of the git tree sha below the directory dir.
+func (imp *gitImporter) tree(ctx context.Context, sha gitHash, dir string) error {
+	typ, data, err := imp.repo.object(sha)
+	if err != nil {
+		return err
+	}
+	if typ != gitTree {
+		return fmt.Errorf("git object %s is a %s, not a tree", sha, typ)
+	}
+
+	for len(data) > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		sp := bytes.IndexByte(data, ' ')
+		nul := bytes.IndexByte(data, 0)
+		if sp < 0 || nul < sp || len(data) < nul+21 {
+			return fmt.Errorf("git tree %s is corrupt", sha)
+		}
+		mode, name := string(data[:sp]), string(data[sp+1:nul])
+		var child gitHash
+		copy(child[:], data[nul+1:nul+21])
+		data = data[nul+21:]
+		name = path.Join(dir, name)
+
+		switch mode {
+		case "40000":
+			imp.manifest.AddEntry(&c4m.Entry{
+				Mode:      fs.ModeDir | 0755,
+				Timestamp: imp.when,
+				Name:      name,
+			})
+			if err := imp.tree(ctx, child, name); err != nil {
+				return err
+			}
+		case "100644", "100755", "100664":
+			perm := fs.FileMode(0644)
+			if mode == "100755" {
+				perm = 0755
+			}
+			id, size, err := imp.blob(child)
+			if err != nil {
+				return err
+			}
+			imp.manifest.AddEntry(&c4m.Entry{
+				Mode:      perm,
+				Timestamp: imp.when,
+				Size:      size,
+				Name:      name,
+				C4ID:      id,
+			})
+		case "120000":
+			_, target, err := imp.repo.object(child)
+			if err != nil {
+				return err
+			}
+			imp.manifest.AddEntry(&c4m.Entry{
+				Mode:      fs.ModeSymlink | 0777,
+				Timestamp: imp.when,
+				Name:      name,
+				Target:    string(target),
+			})
+		case "160000":
+			// A submodule; its commit is in another repository
+		default:
+			return fmt.Errorf("git tree %s: unknown mode %s for %s", sha, mode, name)
+		}
+	}
+	return nil
+}
+
+// blob copies the git blob sha into the store, unless it is already
+// mapped, and returns its C4 ID and size.
+func (imp *gitImporter) blob(sha gitHash) (c4.ID, int64, error) {
+	if id, ok := imp.ids[sha.String()]; ok {
+		info, err := imp.store.Stat(id)
+		if err == nil {
+			return id, info.Size, nil
+		}
+	}
+	typ, data, err := imp.repo.object(sha)
+	if err != nil {
+		return c4.ID{}, 0, err
+	}
+	if typ != gitBlob {
+		return c4.ID{}, 0, fmt.Errorf("git object %s is a %s, not a blob", sha, typ)
+	}
+	id, err := imp.store.Put(bytes.NewReader(data))
+	if err != nil {
+		return c4.ID{}, 0, err
+	}
+	imp.ids[sha.String()] = id
+	return id, int64(len(data)), nil
+}
+
+// gitHash is a SHA-1 git object name.
+type gitHash [20]byte
+
+func (h gitHash) String() string {
+	return hex.EncodeToString(h[:])
+}
+
+func parseGitHash(s string) (gitHash, bool) {
+	var h gitHash
+	if len(s) != 40 {
+		return h, false
+	}
+	_, err := hex.Decode(h[:], []byte(s))
+	return h, err == nil
+}
+
+// gitType is the type of a git object, numbered as in pack files.
+type gitType int
+
+const (
+	gitCommit gitType = 1
+	gitTree   gitType = 2
+	gitBlob   gitType = 3
+	gitTag    gitType = 4
+
+	// Pack entries holding a delta against another object
+	gitOfsDelta gitType = 6
+	gitRefDelta gitType = 7
+)
+
+func (t gitType) String() string {
+	switch t {
+	case gitCommit:
+		return "commit"
+	case gitTree:
+		return "tree"
+	case gitBlob:
+		return "blob"
+	case gitTag:
+		return "tag"
+	}
+	return "object of type " + strconv.Itoa(int(t))
+}
+
+// gitRepo reads the objects and references of a git directory.
+type gitRepo struct {
+	dir    string // git directory, holding HEAD
+	common string // directory holding objects and shared references
+	packs  []*gitPack
+}
+
+// gitPack is a pack file and its version 2 index.
+type gitPack struct {
+	idx  []byte
+	n    int // objects in the pack
+	pack *os.File
+}
+
+// openGitRepo opens the git directory of repo, which is either a git
+// directory or a working tree holding one in .git.
+func openGitRepo(repo string) (*gitRepo, error) {
+	dir := repo
+	dotgit := filepath.Join(repo, ".git")
+	if info, err := os.Stat(dotgit); err == nil {
+		dir = dotgit
+		if !info.IsDir() {
+			// A linked working tree or submodule: ".git" names the directory
+			data, err := os.ReadFile(dotgit)
+			if err != nil {
+				return nil, err
+			}
+			gitdir, ok := strings.CutPrefix(strings.TrimSpace(string(data)), "gitdir: ")
+			if !ok {
+				return nil, fmt.Errorf("%s is not a git directory link", dotgit)
+			}
+			if !filepath.IsAbs(gitdir) {
+				gitdir = filepath.Join(repo, gitdir)
+			}
+			dir = gitdir
+		}
+	}
+	r := &gitRepo{dir: dir, common: dir}
+	// Linked working trees share the objects and refs of the main one
+	if data, err := os.ReadFile(filepath.Join(dir, "commondir")); err == nil {
+		r.common = strings.TrimSpace(string(data))
+		if !filepath.IsAbs(r.common) {
+			r.common = filepath.Join(dir, r.common)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(r.common, "objects")); err != nil {
+		return nil, fmt.Errorf("%s is not a git repository: %w", repo, err)
+	}
+
+	idxs, err := filepath.Glob(filepath.Join(r.common, "objects", "pack", "pack-*.idx"))
+	if err != nil {
+		return nil, err
+	}
+	for _, idx := range idxs {
+		p, err := openGitPack(idx)
+		if err != nil {
+			r.close()
+			return nil, err
+		}
+		r.packs = append(r.packs, p)
+	}
+	return r, nil
+}
+
+func (r *gitRepo) close() {
+	for _, p := range r.packs {
+		p.pack.Close()
+	}
+}
+
+// openGitPack loads the index idx and opens its pack.
+func openGitPack(idx string) (*gitPack, error) {
+	data, err := os.ReadFile(idx)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 8+256*4 || !bytes.Equal(data[:4], []byte("\377tOc")) || binary.BigEndian.Uint32(data[4:]) != 2 {
+		return nil, fmt.Errorf("%s: unsupported pack index version", idx)
+	}
+	n := int(binary.BigEndian.Uint32(data[8+255*4:]))
+	if len(data) < 8+256*4+n*(20+4+4) {
+		return nil, fmt.Errorf("%s: truncated pack index", idx)
+	}
+	f, err := os.Open(strings.TrimSuffix(idx, ".idx") + ".pack")
+	if err != nil {
+		return nil, err
+	}
+	return &gitPack{idx: data, n: n, pack: f}, nil
+}
+
+// find returns the offset of the object sha in the pack.
+func (p *gitPack) find(sha gitHash) (int64, bool) {
+	const fanout, names = 8, 8 + 256*4
+	lo := 0
+	if sha[0] > 0 {
+		lo = int(binary.BigEndian.Uint32(p.idx[fanout+(int(sha[0])-1)*4:]))
+	}
+	hi := int(binary.BigEndian.Uint32(p.idx[fanout+int(sha[0])*4:]))
+	i := lo + sort.Search(hi-lo, func(i int) bool {
+		return bytes.Compare(p.idx[names+(lo+i)*20:names+(lo+i+1)*20], sha[:]) >= 0
+	})
+	if i >= hi || !bytes.Equal(p.idx[names+i*20:names+(i+1)*20], sha[:]) {
+		return 0, false
+	}
+
+	offsets := names + p.n*(20+4)
+	off := binary.BigEndian.Uint32(p.idx[offsets+i*4:])
+	if off&0x80000000 == 0 {
+		return int64(off), true
+	}
+	// Offsets past 2 GiB are kept in a table of 64-bit offsets
+	large := offsets + p.n*4 + int(off&0x7fffffff)*8
+	if large+8 > len(p.idx) {
+		return 0, false
+	}
+	return int64(binary.BigEndian.Uint64(p.idx[large:])), true
+}
+
+// object reads the object sha from the packs or a loose file.
+func (r *gitRepo) object(sha gitHash) (gitType, []byte, error) {
+	for _, p := range r.packs {
+		if off, ok := p.find(sha); ok {
+			typ, data, err := r.packObject(p, off)
+			if err != nil {
+				return 0, nil, fmt.Errorf("git object %s: %w", sha, err)
+			}
+			return typ, data, nil
+		}
+	}
+
+	s := sha.String()
+	f, err := os.Open(filepath.Join(r.common, "objects", s[:2], s[2:]))
+	if err != nil {
+		return 0, nil, fmt.Errorf("git object %s: %w", sha, err)
+	}
+	defer f.Close()
+	zr, err := zlib.NewReader(f)
+	if err != nil {
+		return 0, nil, fmt.Errorf("git object %s: %w", sha, err)
+	}
+	defer zr.Close()
+	raw, err := io.ReadAll(zr)
+	if err != nil {
+		return 0, nil, fmt.Errorf("git object %s: %w", sha, err)
+	}
+	header, data, ok := bytes.Cut(raw, []byte{0})
+	name, size, _ := strings.Cut(string(header), " ")
+	if !ok || size != strconv.Itoa(len(data)) {
+		return 0, nil, fmt.Errorf("git object %s is corrupt", sha)
+	}
+	for _, t := range []gitType{gitCommit, gitTree, gitBlob, gitTag} {
+		if t.String() == name {
+			return t, data, nil
+		}
+	}
+	return 0, nil, fmt.Errorf("git object %s has unknown type %q", sha, name)
+}
+
+// packObject reads the object at off in the pack p, resolving deltas.
+func (r *gitRepo) packObject(p *gitPack, off int64) (gitType, []byte, error) {
+	br := bufio.NewReader(io.NewSectionReader(p.pack, off, 1<<62))
+	b, err := br.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	typ := gitType(b >> 4 & 7)
+	size := int64(b & 15)
+	for shift := 4; b&0x80 != 0; shift += 7 {
+		if b, err = br.ReadByte(); err != nil {
+			return 0, nil, err
+		}
+		size |= int64(b&0x7f) << shift
+	}
+
+	var baseType gitType
+	var base []byte
+	switch typ {
+	case gitCommit, gitTree, gitBlob, gitTag:
+	case gitOfsDelta:
+		b, err := br.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		rel := int64(b & 0x7f)
+		for b&0x80 != 0 {
+			if b, err = br.ReadByte(); err != nil {
+				return 0, nil, err
+			}
+			rel = (rel+1)<<7 | int64(b&0x7f)
+		}
+		if rel <= 0 || rel > off {
+			return 0, nil, errors.New("corrupt delta offset")
+		}
+		if baseType, base, err = r.packObject(p, off-rel); err != nil {
+			return 0, nil, err
+		}
+	case gitRefDelta:
+		var sha gitHash
+		if _, err := io.ReadFull(br, sha[:]); err != nil {
+			return 0, nil, err
+		}
+		if baseType, base, err = r.object(sha); err != nil {
+			return 0, nil, err
+		}
+	default:
+		return 0, nil, fmt.Errorf("unknown pack object type %d", typ)
+	}
+
+	zr, err := zlib.NewReader(br)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer zr.Close()
+	data := make([]byte, size)
+	if _, err := io.ReadFull(zr, data); err != nil {
+		return 0, nil, err
+	}
+	if typ != gitOfsDelta && typ != gitRefDelta {
+		return typ, data, nil
+	}
+	data, err = applyGitDelta(base, data)
+	return baseType, data, err
+}
+
+// errGitDelta reports a delta that does not apply to its base.
+var errGitDelta = errors.New("corrupt delta")
+
+// applyGitDelta applies a pack delta to base.
+func applyGitDelta(base, delta []byte) ([]byte, error) {
+	varint := func() int {
+		var n, shift int
+		for len(delta) > 0 {
+			b := delta[0]
+			delta = delta[1:]
+			n |= int(b&0x7f) << shift
+			if b&0x80 == 0 {
+				return n
+			}
+			shift += 7
+		}
+		return -1
+	}
+	if varint() != len(base) {
+		return nil, errGitDelta
+	}
+	size := varint()
+	if size < 0 {
+		return nil, errGitDelta
+	}
+
+	out := make([]byte, 0, size)
+	for len(delta) > 0 {
+		op := delta[0]
+		delta = delta[1:]
+		switch {
+		case op&0x80 != 0:
+			// Copy a range of the base; the op bits say which bytes of
+			// the offset and length follow
+			var off, n int
+			for i := 0; i < 7; i++ {
+				if op&(1<<i) == 0 {
+					continue
+				}
+				if len(delta) == 0 {
+					return nil, errGitDelta
+				}
+				if i < 4 {
+					off |= int(delta[0]) << (8 * i)
+				} else {
+					n |= int(delta[0]) << (8 * (i - 4))
+				}
+				delta = delta[1:]
+			}
+			if n == 0 {
+				n = 0x10000
+			}
+			if off+n > len(base) {
+				return nil, errGitDelta
+			}
+			out = append(out, base[off:off+n]...)
+		case op != 0:
+			// Insert the next op bytes of the delta
+			n := int(op)
+			if n > len(delta) {
+				return nil, errGitDelta
+			}
+			out = append(out, delta[:n]...)
+			delta = delta[n:]
+		default:
+			return nil, errGitDelta
+		}
+	}
+	if len(out) != size {
+		return nil, errGitDelta
+	}
+	return out, nil
+}
+
+// resolve returns the object named by rev: an object name in hex, or a
+// reference looked up as git rev-parse does.
+func (r *gitRepo) resolve(rev string) (gitHash, error) {
+	if sha, ok := parseGitHash(rev); ok {
+		return sha, nil
+	}
+	for _, name := range []string{rev, "refs/" + rev, "refs/tags/" + rev, "refs/heads/" + rev, "refs/remotes/" + rev} {
+		if sha, ok, err := r.ref(name, 0); err != nil || ok {
+			return sha, err
+		}
+	}
+	return gitHash{}, fmt.Errorf("git revision %q: %w", rev, ErrRefNotFound)
+}
+
+// ref looks up the reference name in its file or in packed-refs,
+// following symbolic references.
+func (r *gitRepo) ref(name string, depth int) (gitHash, bool, error) {
+	if depth > 10 {
+		return gitHash{}, false, fmt.Errorf("git reference %s: too many levels of symbolic references", name)
+	}
+	for _, dir := range []string{r.dir, r.common} {
+		data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			continue
+		}
+		line := strings.TrimSpace(string(data))
+		if target, ok := strings.CutPrefix(line, "ref: "); ok {
+			return r.ref(target, depth+1)
+		}
+		if sha, ok := parseGitHash(line); ok {
+			return sha, true, nil
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(r.common, "packed-refs"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return gitHash{}, false, nil
+	}
+	if err != nil {
+		return gitHash{}, false, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		hexSHA, ref, ok := strings.Cut(line, " ")
+		if ok && ref == name {
+			if sha, ok := parseGitHash(hexSHA); ok {
+				return sha, true, nil
+			}
+		}
+	}
+	return gitHash{}, false, nil
+}
+
+// peelTree follows tags and commits from sha to a tree, and returns it
+// with the time of the commit, or the Unix epoch for a bare tree.
+func (r *gitRepo) peelTree(sha gitHash) (gitHash, time.Time, error) {
+	for depth := 0; depth < 10; depth++ {
+		typ, data, err := r.object(sha)
+		if err != nil {
+			return gitHash{}, time.Time{}, err
+		}
+		switch typ {
+		case gitTree:
+			return sha, time.Unix(0, 0).UTC(), nil
+		case gitTag:
+			next, ok := parseGitHash(gitHeader(data, "object"))
+			if !ok {
+				return gitHash{}, time.Time{}, fmt.Errorf("git tag %s is corrupt", sha)
+			}
+			sha = next
+		case gitCommit:
+			tree, ok := parseGitHash(gitHeader(data, "tree"))
+			if !ok {
+				return gitHash{}, time.Time{}, fmt.Errorf("git commit %s is corrupt", sha)
+			}
+			// "committer Name <email> 1700000000 +0100"
+			when := time.Unix(0, 0).UTC()
+			if f := strings.Fields(gitHeader(data, "committer")); len(f) >= 2 {
+				if secs, err := strconv.ParseInt(f[len(f)-2], 10, 64); err == nil {
+					when = time.Unix(secs, 0).UTC()
+				}
+			}
+			return tree, when, nil
+		default:
+			return gitHash{}, time.Time{}, fmt.Errorf("git object %s is a %s, not a commit", sha, typ)
+		}
+	}
+	return gitHash{}, time.Time{}, fmt.Errorf("git object %s: too many levels of tags", sha)
+}
+
+// gitHeader returns the value of the header key of a commit or tag.
+func gitHeader(data []byte, key string) string {
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			break
+		}
+		if v, ok := strings.CutPrefix(line, key+" "); ok {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,103 @@
+package c4fs
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/store"
+)
+
+// gitRun runs git in dir and returns its output.
+func gitRun(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", append([]string{"-c", "user.name=Test", "-c", "user.email=test@example.com"}, args...)...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return string(out)
+}
+
+func TestImportGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	repo := t.TempDir()
+	write := func(name, content string, perm os.FileMode) {
+		t.Helper()
+		p := filepath.Join(repo, name)
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err := os.WriteFile(p, []byte(content), perm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	gitRun(t, repo, "init", "-q", "-b", "main")
+	var big strings.Builder
+	for i := 0; i < 2000; i++ {
+		big.WriteString("line of a large file that compresses into a delta\n")
+	}
+	write("README", "hello\n", 0644)
+	write("bin/run.sh", "#!/bin/sh\n", 0755)
+	write("docs/big.txt", big.String(), 0644)
+	if err := os.Symlink("README", filepath.Join(repo, "link")); err != nil {
+		t.Fatal(err)
+	}
+	gitRun(t, repo, "add", "-A")
+	gitRun(t, repo, "commit", "-q", "-m", "one")
+	gitRun(t, repo, "tag", "-a", "-m", "first", "v1")
+	write("docs/big.txt", big.String()+"one more line\n", 0644)
+	gitRun(t, repo, "commit", "-q", "-am", "two")
+
+	ram := store.NewRAM()
+	check := func(rev string) {
+		t.Helper()
+		ids := make(map[string]c4.ID)
+		m, err := ImportGit(context.Background(), repo, rev, ram, GitImportOptions{IDs: ids})
+		if err != nil {
+			t.Fatalf("ImportGit(%s) failed: %v", rev, err)
+		}
+		if len(ids) != 3 {
+			t.Errorf("ImportGit(%s) mapped %d blobs, want 3", rev, len(ids))
+		}
+		fsys := New(NewStoreAdapter(ram), WithBase(m))
+		for _, name := range strings.Fields(gitRun(t, repo, "ls-tree", "-r", "--name-only", rev)) {
+			want := gitRun(t, repo, "show", rev+":"+name)
+			if name == "link" {
+				if target, err := fsys.ReadLink(name); err != nil || target != want {
+					t.Errorf("%s: link = %q, %v; want %q", rev, target, err, want)
+				}
+				continue
+			}
+			if got, err := fsys.ReadFile(name); err != nil || string(got) != want {
+				t.Errorf("%s: %s differs from git (err %v)", rev, name, err)
+			}
+		}
+		if info, err := fsys.Stat("bin/run.sh"); err != nil || info.Mode().Perm() != 0755 {
+			t.Errorf("%s: run.sh mode = %v, %v", rev, info, err)
+		}
+		if info, err := fsys.Stat("docs"); err != nil || !info.IsDir() {
+			t.Errorf("%s: docs = %v, %v", rev, info, err)
+		}
+	}
+
+	// Loose objects
+	check("HEAD")
+	check("v1")
+
+	// Packed objects with deltas, and packed references
+	gitRun(t, repo, "gc", "-q", "--aggressive")
+	check("HEAD")
+	check("v1")
+	check(strings.TrimSpace(gitRun(t, repo, "rev-parse", "main~1")))
+
+	if _, err := ImportGit(context.Background(), repo, "missing", ram, GitImportOptions{}); err == nil {
+		t.Error("Expected an error for an unknown revision")
+	}
+}
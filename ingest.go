@@ -0,0 +1,115 @@
+package c4fs
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// IngestStats reports how content stored during an ingest session was
+// deduplicated. Every Put either stored new content or found the store
+// already held it.
+type IngestStats struct {
+	Stored            int64 // Puts that stored new content
+	Deduplicated      int64 // Puts satisfied by content already stored
+	BytesStored       int64 // Bytes of new content stored
+	BytesDeduplicated int64 // Bytes that did not need storing
+}
+
+// Puts returns the number of Put calls counted.
+func (s IngestStats) Puts() int64 {
+	return s.Stored + s.Deduplicated
+}
+
+// SavedRatio returns the fraction of the bytes put that deduplication
+// saved storing, between 0 and 1.
+func (s IngestStats) SavedRatio() float64 {
+	total := s.BytesStored + s.BytesDeduplicated
+	if total == 0 {
+		return 0
+	}
+	return float64(s.BytesDeduplicated) / float64(total)
+}
+
+// IngestSession counts the content put through a StoreAdapter between
+// BeginIngest and End. Sessions count every Put on the adapter, including
+// those of other goroutines and of filesystems sharing it, so concurrent
+// imports that must be reported separately need their own adapters.
+type IngestSession struct {
+	adapter *StoreAdapter
+
+	stored, dedup           atomic.Int64
+	bytesStored, bytesDedup atomic.Int64
+}
+
+// ingestSessions is the set of sessions open on an adapter.
+type ingestSessions struct {
+	mu   sync.Mutex
+	open map[*IngestSession]struct{}
+}
+
+// BeginIngest starts counting the content put through s.
+func (s *StoreAdapter) BeginIngest() *IngestSession {
+	sess := &IngestSession{adapter: s}
+	s.sessions.mu.Lock()
+	if s.sessions.open == nil {
+		s.sessions.open = make(map[*IngestSession]struct{})
+	}
+	s.sessions.open[sess] = struct{}{}
+	s.sessions.mu.Unlock()
+	return sess
+}
+
+// BeginIngest starts counting the content written to the filesystem's
+// store, for example around a bulk import. See StoreAdapter.BeginIngest.
+// With WithAsyncDehydration, content is counted as it is staged: content
+// the remote store already holds, but staging does not, counts as stored.
+func (c4fs *FS) BeginIngest() *IngestSession {
+	return c4fs.store.BeginIngest()
+}
+
+// Stats returns the counts so far.
+func (sess *IngestSession) Stats() IngestStats {
+	return IngestStats{
+		Stored:            sess.stored.Load(),
+		Deduplicated:      sess.dedup.Load(),
+		BytesStored:       sess.bytesStored.Load(),
+		BytesDeduplicated: sess.bytesDedup.Load(),
+	}
+}
+
+// End stops counting and returns the final counts. It may be called more
+// than once.
+func (sess *IngestSession) End() IngestStats {
+	s := sess.adapter
+	s.sessions.mu.Lock()
+	delete(s.sessions.open, sess)
+	s.sessions.mu.Unlock()
+	return sess.Stats()
+}
+
+// recordPut counts a Put of size bytes in the open sessions. A negative
+// size is unknown and counts the call only.
+func (s *StoreAdapter) recordPut(stored bool, size int64) {
+	s.sessions.mu.Lock()
+	defer s.sessions.mu.Unlock()
+	size = max(size, 0)
+	for sess := range s.sessions.open {
+		if stored {
+			sess.stored.Add(1)
+			sess.bytesStored.Add(size)
+		} else {
+			sess.dedup.Add(1)
+			sess.bytesDedup.Add(size)
+		}
+	}
+}
+
+// sizeOf returns the number of bytes left in r if it reports them, as
+// bytes.Reader and strings.Reader do, or -1.
+func sizeOf(r io.Reader) int64 {
+	if l, ok := r.(interface{ Len() int }); ok {
+		return int64(l.Len())
+	}
+	return -1
+}
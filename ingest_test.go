@@ -0,0 +1,69 @@
+package c4fs
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/store"
+)
+
+func TestIngestSession(t *testing.T) {
+	adapter := NewStoreAdapter(store.NewRAM())
+	c4fs := New(adapter)
+
+	if _, err := adapter.Put(strings.NewReader("before")); err != nil {
+		t.Fatal(err)
+	}
+
+	sess := c4fs.BeginIngest()
+	for _, f := range []struct{ name, data string }{
+		{"a.txt", "hello"},
+		{"b.txt", "hello"},
+		{"c.txt", "world!"},
+		{"d.txt", "before"},
+	} {
+		if err := c4fs.WriteFile(f.name, []byte(f.data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := IngestStats{Stored: 2, Deduplicated: 2, BytesStored: 11, BytesDeduplicated: 11}
+	if got := sess.Stats(); got != want {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+
+	// PutIfAbsent counts skipped bytes when the reader reports its length
+	id := c4.Identify(strings.NewReader("hello"))
+	if _, err := adapter.PutIfAbsent(id, bytes.NewReader([]byte("hello"))); err != nil {
+		t.Fatal(err)
+	}
+	want.Deduplicated++
+	want.BytesDeduplicated += 5
+
+	got := sess.End()
+	if got != want {
+		t.Errorf("End() = %+v, want %+v", got, want)
+	}
+	if got.Puts() != 5 {
+		t.Errorf("Puts() = %d, want 5", got.Puts())
+	}
+	if r := got.SavedRatio(); r != 16.0/27 {
+		t.Errorf("SavedRatio() = %v, want %v", r, 16.0/27)
+	}
+
+	// Ended sessions stop counting; others keep their own counts
+	other := adapter.BeginIngest()
+	if err := c4fs.WriteFile("e.txt", []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := sess.Stats(); got != want {
+		t.Errorf("ended session counted a put: %+v", got)
+	}
+	if got := other.End(); got != (IngestStats{Stored: 1, BytesStored: 3}) {
+		t.Errorf("second session = %+v", got)
+	}
+	if r := (IngestStats{}).SavedRatio(); r != 0 {
+		t.Errorf("empty SavedRatio() = %v", r)
+	}
+}
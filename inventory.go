@@ -0,0 +1,89 @@
+package c4fs
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/store"
+)
+
+// An inventory is the list of the IDs of every blob in a store, one per
+// line in sorted order. It records what content a store holds
+// independently of any manifest, for reconciling mirrors, seeding
+// filters and audits.
+
+// WriteInventory writes the inventory of s to w and returns the number of
+// IDs written. The store must support ListIDs.
+func WriteInventory(w io.Writer, s store.Store) (int, error) {
+	ids, err := ListIDs(s)
+	if err != nil {
+		return 0, err
+	}
+	sortIDs(ids)
+
+	bw := bufio.NewWriter(w)
+	for _, id := range ids {
+		bw.WriteString(id.String())
+		bw.WriteByte('\n')
+	}
+	return len(ids), bw.Flush()
+}
+
+// ReadInventory reads an inventory written by WriteInventory. Blank lines
+// and lines starting with "#" are ignored; the IDs are returned sorted,
+// without duplicates.
+func ReadInventory(r io.Reader) ([]c4.ID, error) {
+	var ids []c4.ID
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		id, err := c4.Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("inventory line %d: %w", line, err)
+		}
+		ids = append(ids, id)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	sortIDs(ids)
+	return slices.Compact(ids), nil
+}
+
+// CompareInventory compares two inventories, such as those of a store
+// and its mirror, and returns the IDs only in a and those only in b, in
+// sorted order. The inventories may be in any order.
+func CompareInventory(a, b []c4.ID) (onlyA, onlyB []c4.ID) {
+	a, b = slices.Clone(a), slices.Clone(b)
+	sortIDs(a)
+	sortIDs(b)
+	a, b = slices.Compact(a), slices.Compact(b)
+	for len(a) > 0 && len(b) > 0 {
+		switch c := compareIDs(a[0], b[0]); {
+		case c < 0:
+			onlyA, a = append(onlyA, a[0]), a[1:]
+		case c > 0:
+			onlyB, b = append(onlyB, b[0]), b[1:]
+		default:
+			a, b = a[1:], b[1:]
+		}
+	}
+	return append(onlyA, a...), append(onlyB, b...)
+}
+
+// sortIDs sorts ids in the order of their string forms.
+func sortIDs(ids []c4.ID) {
+	slices.SortFunc(ids, compareIDs)
+}
+
+func compareIDs(a, b c4.ID) int {
+	return bytes.Compare(a[:], b[:])
+}
@@ -0,0 +1,56 @@
+package c4fs
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/store"
+)
+
+func TestInventory(t *testing.T) {
+	src := store.NewRAM()
+	adapter := NewStoreAdapter(src)
+	var ids []c4.ID
+	for _, data := range []string{"one", "two", "three"} {
+		id, err := adapter.Put(strings.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	var buf bytes.Buffer
+	n, err := WriteInventory(&buf, src)
+	if err != nil || n != 3 {
+		t.Fatalf("WriteInventory = %d, %v", n, err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if !slices.IsSorted(lines) {
+		t.Errorf("inventory is not sorted: %v", lines)
+	}
+
+	// Comments, blank lines and duplicates are ignored on reading
+	text := "# store A\n\n" + buf.String() + lines[0] + "\n"
+	got, err := ReadInventory(strings.NewReader(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := slices.Clone(ids)
+	sortIDs(want)
+	if !slices.Equal(got, want) {
+		t.Errorf("ReadInventory = %v, want %v", got, want)
+	}
+	if _, err := ReadInventory(strings.NewReader("not an id\n")); err == nil || !strings.Contains(err.Error(), "line 1") {
+		t.Errorf("ReadInventory of a bad line = %v", err)
+	}
+
+	// A mirror missing one blob and holding another
+	extra := c4.Identify(strings.NewReader("four"))
+	onlyStore, onlyMirror := CompareInventory(ids, []c4.ID{extra, ids[2], ids[0]})
+	if !slices.Equal(onlyStore, []c4.ID{ids[1]}) || !slices.Equal(onlyMirror, []c4.ID{extra}) {
+		t.Errorf("CompareInventory = %v, %v", onlyStore, onlyMirror)
+	}
+}
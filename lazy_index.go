@@ -0,0 +1,138 @@
+package c4fs
+
+import (
+	"container/list"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/Avalanche-io/c4/c4m"
+)
+
+// lazyScanLimit is the size up to which a directory is searched directly
+// instead of being indexed on first access.
+const lazyScanLimit = 8
+
+// lazyIndex indexes a base manifest one directory at a time, on first
+// access. Building it records only where each directory's children are in
+// the manifest, one map slot per directory and four bytes per entry. A
+// lookup in a directory builds a map of its children's names, which is
+// kept in a cache of recently used directories limited to budget entries
+// in all, least recently used first out. Small directories are searched
+// without building a map.
+type lazyIndex struct {
+	m      *c4m.Manifest
+	dirs   map[string][]int32 // Positions in m.Entries of each directory's children
+	budget int                // Most entries held by built maps; 0 if unlimited
+
+	mu    sync.Mutex // guards the cache, as lookups hold c4fs.mu only for reading
+	built map[string]*list.Element
+	lru   list.List // *lazyDir, most recently used first
+	held  int       // Entries held by built maps
+}
+
+// lazyDir is the built map of a directory's children by base name.
+type lazyDir struct {
+	dir   string
+	names map[string]*c4m.Entry
+}
+
+// newLazyIndex records where the children of each directory of m are.
+func newLazyIndex(m *c4m.Manifest, budget int) *lazyIndex {
+	l := &lazyIndex{
+		m:      m,
+		dirs:   make(map[string][]int32),
+		budget: max(budget, 0),
+		built:  make(map[string]*list.Element),
+	}
+	for i, e := range m.Entries {
+		dir := parentPath(e.Name)
+		l.dirs[dir] = append(l.dirs[dir], int32(i))
+	}
+	return l
+}
+
+func (l *lazyIndex) get(name string) (*c4m.Entry, bool) {
+	pos, ok := l.dirs[parentPath(name)]
+	if !ok {
+		return nil, false
+	}
+	if len(pos) <= lazyScanLimit {
+		// The last of several entries with the same path wins
+		for _, i := range slices.Backward(pos) {
+			if e := l.m.Entries[i]; e.Name == name {
+				return e, true
+			}
+		}
+		return nil, false
+	}
+	e, ok := l.dir(parentPath(name), pos)[baseName(name)]
+	return e, ok
+}
+
+// dir returns the map of the children of dir, at positions pos, building
+// it if it is not cached. The map must not be modified.
+func (l *lazyIndex) dir(dir string, pos []int32) map[string]*c4m.Entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if el, ok := l.built[dir]; ok {
+		l.lru.MoveToFront(el)
+		return el.Value.(*lazyDir).names
+	}
+
+	names := make(map[string]*c4m.Entry, len(pos))
+	for _, i := range pos {
+		e := l.m.Entries[i]
+		names[baseName(e.Name)] = e
+	}
+	l.built[dir] = l.lru.PushFront(&lazyDir{dir, names})
+	l.held += len(names)
+
+	// Evict down to the budget, keeping the directory just built
+	for l.budget > 0 && l.held > l.budget && l.lru.Len() > 1 {
+		d := l.lru.Remove(l.lru.Back()).(*lazyDir)
+		delete(l.built, d.dir)
+		l.held -= len(d.names)
+	}
+	return names
+}
+
+func (l *lazyIndex) len() int {
+	return len(l.m.Entries)
+}
+
+// children yields the entries directly inside dir.
+func (l *lazyIndex) children(dir string, yield func(*c4m.Entry) bool) {
+	for _, i := range l.dirs[dir] {
+		if !yield(l.m.Entries[i]) {
+			return
+		}
+	}
+}
+
+// subtree yields the entries below dir, directory by directory in name
+// order.
+func (l *lazyIndex) subtree(dir string, yield func(*c4m.Entry) bool) {
+	var dirs []string
+	for d := range l.dirs {
+		if d == dir || strings.HasPrefix(d, dir+"/") {
+			dirs = append(dirs, d)
+		}
+	}
+	slices.Sort(dirs)
+	for _, d := range dirs {
+		for _, i := range l.dirs[d] {
+			if !yield(l.m.Entries[i]) {
+				return
+			}
+		}
+	}
+}
+
+// cached returns the number of directories with built maps and the
+// entries they hold.
+func (l *lazyIndex) cached() (dirs, entries int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lru.Len(), l.held
+}
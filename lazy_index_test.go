@@ -0,0 +1,89 @@
+package c4fs
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+	"testing/fstest"
+
+	"github.com/Avalanche-io/c4/store"
+)
+
+func TestLazyIndexMatchesMap(t *testing.T) {
+	for seed := range int64(5) {
+		m := randomTree(seed, 2000)
+		want := mapIndex(buildIndex(m))
+		lazy := newLazyIndex(m, 50)
+
+		for name, e := range want {
+			if got, ok := lazy.get(name); !ok || got != e {
+				t.Fatalf("seed %d: get(%q) = %v, %v; want %v", seed, name, got, ok, e)
+			}
+			if dirs, held := lazy.cached(); held > 50 && dirs > 1 {
+				t.Fatalf("seed %d: %d directories hold %d entries, over the budget", seed, dirs, held)
+			}
+		}
+		for _, name := range []string{"", "missing", "n1/missing", "n1/n2/n3/n4"} {
+			if _, ok := want[name]; ok {
+				continue
+			}
+			if _, ok := lazy.get(name); ok {
+				t.Errorf("seed %d: get(%q) found a missing path", seed, name)
+			}
+		}
+
+		mapFS := New(NewStoreAdapter(store.NewRAM()), WithBase(m))
+		lazyFS := New(NewStoreAdapter(store.NewRAM()), WithBase(m), WithLazyIndex(50))
+		for name := range want {
+			if !mapFS.baseIndex.(mapIndex)[name].IsDir() {
+				continue
+			}
+			if a, b := names(mapFS.baseChildren(name)), names(lazyFS.baseChildren(name)); !slices.Equal(a, b) {
+				t.Fatalf("seed %d: children(%q) = %v, want %v", seed, name, b, a)
+			}
+			if a, b := names(mapFS.baseSubtree(name)), names(lazyFS.baseSubtree(name)); !slices.Equal(a, b) {
+				t.Fatalf("seed %d: subtree(%q) = %v, want %v", seed, name, b, a)
+			}
+		}
+	}
+}
+
+func TestWithLazyIndex(t *testing.T) {
+	src := New(NewStoreAdapter(store.NewRAM()))
+	src.MkdirAll("a/b/c", 0755)
+	src.WriteFile("a/one.txt", []byte("1"), 0644)
+	src.WriteFile("a/b/two.txt", []byte("2"), 0644)
+	src.WriteFile("a/b/c/three.txt", []byte("3"), 0644)
+	src.WriteFile("top.txt", []byte("top"), 0644)
+
+	var buf bytes.Buffer
+	if err := WriteSnapshot(&buf, src.Flatten(), SnapshotBinary); err != nil {
+		t.Fatal(err)
+	}
+	fsys, err := OpenSnapshot(&buf, src.store, WithLazyIndex(0), WithTrieIndex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fsys.baseIndex.(*lazyIndex); !ok {
+		t.Fatal("WithLazyIndex did not select the lazy index")
+	}
+	sub, _ := fsys.Sub("a")
+	if err := fstest.TestFS(sub, "one.txt", "b/two.txt", "b/c/three.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fsys.Rename("a/b", "moved"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	if data, err := fsys.ReadFile("moved/c/three.txt"); err != nil || string(data) != "3" {
+		t.Errorf("ReadFile(moved/c/three.txt) = %q, %v", data, err)
+	}
+	if fsys.Exists("a/b/two.txt") {
+		t.Error("a/b/two.txt exists after Rename")
+	}
+
+	// Clones keep the index
+	if _, ok := fsys.Clone().baseIndex.(*lazyIndex); !ok {
+		t.Error("Clone dropped the lazy index")
+	}
+}
@@ -0,0 +1,101 @@
+package c4fs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// ErrFSClosed is returned by files opened before the filesystem was shut
+// down, and by attempts to open files after it.
+var ErrFSClosed = errors.New("filesystem closed")
+
+// Shutdown closes the filesystem, flushing pending state:
+//
+//   - outstanding file handles are invalidated, so further reads, writes
+//     and closes that would commit content fail with ErrFSClosed, and no
+//     new files can be opened;
+//   - the files made by CreateTemp are removed;
+//   - pending background uploads are waited for, until ctx is done;
+//   - the layer is saved to the file set with WithLayerFile, and the
+//     dehydration journal is compacted and closed.
+//
+// Every step is attempted and their errors are joined. Shutdown does not
+// affect clones, which have their own handles and layer, but they share
+// the dehydration queue, which keeps running for them.
+func (c4fs *FS) Shutdown(ctx context.Context) error {
+	c4fs.closed.Store(true)
+
+	var errs []error
+	if err := c4fs.CleanTemp(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c4fs.Flush(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	if c4fs.layerFile != "" {
+		if err := c4fs.saveLayer(c4fs.layerFile); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if q := c4fs.dehydration; q != nil && q.journalPath != "" {
+		q.mu.Lock()
+		err := q.compactJournalLocked()
+		q.mu.Unlock()
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// isClosed reports whether Shutdown has been called.
+func (c4fs *FS) isClosed() bool {
+	return c4fs.closed.Load()
+}
+
+// closedError returns the error for an operation on a closed filesystem.
+func closedError(op, name string) error {
+	return &fs.PathError{Op: op, Path: name, Err: ErrFSClosed}
+}
+
+// saveLayer writes the layer to p in the binary snapshot format, replacing
+// the file atomically.
+func (c4fs *FS) saveLayer(p string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".layer-*")
+	if err != nil {
+		return err
+	}
+	c4fs.mu.RLock()
+	err = c4fs.writeLayerLocked(tmp)
+	c4fs.mu.RUnlock()
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+// writeLayerLocked writes the layer, with the metadata of its entries, in
+// the binary snapshot format. The caller must hold c4fs.mu.
+func (c4fs *FS) writeLayerLocked(w io.Writer) error {
+	sw, err := NewSnapshotWriter(w)
+	if err != nil {
+		return err
+	}
+	for _, e := range c4fs.layer.Entries {
+		if err := sw.WriteEntryMeta(e, c4fs.entryMetaLocked(e)); err != nil {
+			return err
+		}
+	}
+	return sw.Close()
+}
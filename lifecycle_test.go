@@ -0,0 +1,102 @@
+package c4fs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestShutdown(t *testing.T) {
+	dir := t.TempDir()
+	layerFile := filepath.Join(dir, "layer")
+	remote := &flakyStore{syncRAM: newSyncRAM()}
+	fsys := New(NewStoreAdapter(remote), WithAsyncDehydration(newSyncRAM()),
+		WithDehydrationJournal(filepath.Join(dir, "journal")), WithLayerFile(layerFile))
+
+	fsys.WriteFile("keep.txt", []byte("kept"), 0644)
+	fsys.WriteFile("gone.txt", []byte("gone"), 0644)
+	fsys.Remove("gone.txt")
+	scratch, scratchName, _ := fsys.CreateTemp("", "scratch-*")
+	scratch.Write([]byte("scratch"))
+	scratch.Close()
+
+	reader, _ := fsys.Open("keep.txt")
+	dir1, _ := fsys.Open("/")
+	writer, _ := fsys.Create("unfinished.txt")
+	writer.Write([]byte("partial"))
+
+	if err := fsys.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	if n := fsys.PendingDehydrations(); n != 0 {
+		t.Errorf("%d uploads pending after Shutdown, want 0", n)
+	}
+
+	// Outstanding handles fail with ErrFSClosed
+	if _, err := reader.Read(make([]byte, 4)); !errors.Is(err, ErrFSClosed) {
+		t.Errorf("Read after Shutdown = %v, want ErrFSClosed", err)
+	}
+	if _, err := io.Copy(io.Discard, reader); !errors.Is(err, ErrFSClosed) {
+		t.Errorf("Copy after Shutdown = %v, want ErrFSClosed", err)
+	}
+	reader.Close()
+	if _, err := dir1.(interface {
+		ReadDir(int) ([]os.DirEntry, error)
+	}).ReadDir(-1); !errors.Is(err, ErrFSClosed) {
+		t.Errorf("ReadDir after Shutdown = %v, want ErrFSClosed", err)
+	}
+	if _, err := writer.Write([]byte("more")); !errors.Is(err, ErrFSClosed) {
+		t.Errorf("Write after Shutdown = %v, want ErrFSClosed", err)
+	}
+	if err := writer.Close(); !errors.Is(err, ErrFSClosed) {
+		t.Errorf("Close after Shutdown = %v, want ErrFSClosed", err)
+	}
+	if fsys.Exists("unfinished.txt") {
+		t.Error("a write handle committed content after Shutdown")
+	}
+	if _, err := fsys.Open("keep.txt"); !errors.Is(err, ErrFSClosed) {
+		t.Errorf("Open after Shutdown = %v, want ErrFSClosed", err)
+	}
+	if _, err := fsys.Create("new.txt"); !errors.Is(err, ErrFSClosed) {
+		t.Errorf("Create after Shutdown = %v, want ErrFSClosed", err)
+	}
+
+	// The saved layer restores the session, tombstones included
+	f, err := os.Open(layerFile)
+	if err != nil {
+		t.Fatalf("layer file not written: %v", err)
+	}
+	layer, err := LoadSnapshot(f)
+	f.Close()
+	if err != nil {
+		t.Fatalf("LoadSnapshot failed: %v", err)
+	}
+	base := New(NewStoreAdapter(remote))
+	base.WriteFile("gone.txt", []byte("in base"), 0644)
+	restored := New(NewStoreAdapter(remote), WithBase(base.Flatten()), WithLayer(layer))
+	if data, err := restored.ReadFile("keep.txt"); err != nil || string(data) != "kept" {
+		t.Errorf("restored keep.txt = %q, %v", data, err)
+	}
+	if restored.Exists("gone.txt") {
+		t.Error("restored layer lost the tombstone for gone.txt")
+	}
+	if restored.Exists(scratchName) {
+		t.Error("restored layer kept a temp file")
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	remote := &flakyStore{syncRAM: newSyncRAM()}
+	remote.down.Store(true)
+	fsys := New(NewStoreAdapter(remote), WithAsyncDehydration(newSyncRAM()))
+	fsys.WriteFile("a.txt", []byte("alpha"), 0644)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := fsys.Shutdown(ctx); err == nil {
+		t.Error("Shutdown succeeded with uploads failing")
+	}
+}
@@ -0,0 +1,118 @@
+package c4fs
+
+import (
+	"io/fs"
+	"slices"
+
+	"github.com/Avalanche-io/c4/c4m"
+)
+
+// Hard links are recorded rather than shared, as entries are copied on
+// write: the names of a file each have an entry with the same content,
+// and share a link group kept in their EntryMeta. Once one of the names
+// is changed, its entry is replaced and it is no longer a link.
+
+// inode identifies a local file, for detecting hard links on import.
+type inode struct {
+	dev, ino uint64
+}
+
+// Link creates newname as a hard link to the regular file oldname. Both
+// names have the same content, mode and times, and Links reports them
+// together; snapshots, ImportDir and ExportDir preserve the link. As the
+// filesystem is copy-on-write, changing a file through one of its names
+// does not change the others: it makes that name a separate file.
+func (c4fs *FS) Link(oldname, newname string) error {
+	c4fs.mu.Lock()
+	defer c4fs.mu.Unlock()
+
+	old, err := c4fs.lookup(oldname)
+	if err != nil {
+		return err
+	}
+	if !old.Mode.IsRegular() {
+		return &fs.PathError{Op: "link", Path: oldname, Err: fs.ErrPermission}
+	}
+	if _, err := c4fs.lookup(newname); err == nil {
+		return &fs.PathError{Op: "link", Path: newname, Err: fs.ErrExist}
+	}
+
+	c4fs.linkLocked(old, cleanPath(newname))
+	return nil
+}
+
+// Links returns the names of the hard links of the named file, itself
+// included, sorted. A file without other links has only its own name.
+func (c4fs *FS) Links(name string) ([]string, error) {
+	c4fs.mu.RLock()
+	defer c4fs.mu.RUnlock()
+
+	entry, err := c4fs.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	group, ok := c4fs.linkGroupLocked(entry)
+	if !ok {
+		return []string{entry.Name}, nil
+	}
+	var names []string
+	for n, m := range c4fs.meta {
+		if m.meta.Link != group {
+			continue
+		}
+		if e, err := c4fs.lookup(n); err == nil && e == m.entry {
+			names = append(names, n)
+		}
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+// linkLocked creates name as a link to the file of old, and returns its
+// entry. The caller must hold c4fs.mu for writing.
+func (c4fs *FS) linkLocked(old *c4m.Entry, name string) *c4m.Entry {
+	group, ok := c4fs.linkGroupLocked(old)
+	if !ok {
+		c4fs.nextLink++
+		group = c4fs.nextLink
+		c4fs.setLinkLocked(old, group)
+	}
+	entry := &c4m.Entry{
+		Mode:      old.Mode,
+		Timestamp: old.Timestamp,
+		Size:      old.Size,
+		Name:      name,
+		C4ID:      old.C4ID,
+	}
+	c4fs.updateEntryInLayer(entry)
+	c4fs.setLinkLocked(entry, group)
+	if atime, ok := c4fs.atimeLocked(old); ok {
+		c4fs.setAtimeLocked(entry, atime)
+	}
+	return entry
+}
+
+// linkGroup returns the link group of the named file, if it is a hard
+// link.
+func (c4fs *FS) linkGroup(name string) (uint64, bool) {
+	c4fs.mu.RLock()
+	defer c4fs.mu.RUnlock()
+	entry, err := c4fs.lookup(name)
+	if err != nil {
+		return 0, false
+	}
+	return c4fs.linkGroupLocked(entry)
+}
+
+// linkGroupLocked returns the link group of e, if it is a hard link.
+// The caller must hold c4fs.mu.
+func (c4fs *FS) linkGroupLocked(e *c4m.Entry) (uint64, bool) {
+	group := c4fs.entryMetaLocked(e).Link
+	return group, group != 0
+}
+
+// setLinkLocked records e as a member of a link group.
+// The caller must hold c4fs.mu for writing.
+func (c4fs *FS) setLinkLocked(e *c4m.Entry, group uint64) {
+	c4fs.updateMetaLocked(e, func(m *EntryMeta) { m.Link = group })
+}
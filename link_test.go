@@ -0,0 +1,181 @@
+package c4fs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/Avalanche-io/c4/store"
+)
+
+func TestLink(t *testing.T) {
+	c4fs := New(NewStoreAdapter(store.NewRAM()))
+	if err := c4fs.WriteFile("a.txt", []byte("shared"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := c4fs.Link("a.txt", "b.txt"); err != nil {
+		t.Fatalf("Link failed: %v", err)
+	}
+	if err := c4fs.Link("b.txt", "c.txt"); err != nil {
+		t.Fatalf("Link failed: %v", err)
+	}
+
+	data, err := c4fs.ReadFile("c.txt")
+	if err != nil || string(data) != "shared" {
+		t.Errorf("ReadFile(c.txt) = %q, %v", data, err)
+	}
+	if info, err := c4fs.Stat("c.txt"); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Stat(c.txt) mode = %v, %v", info.Mode(), err)
+	}
+	want := []string{"a.txt", "b.txt", "c.txt"}
+	if got, err := c4fs.Links("b.txt"); err != nil || !slices.Equal(got, want) {
+		t.Errorf("Links = %v, %v; want %v", got, err, want)
+	}
+
+	if err := c4fs.Link("a.txt", "b.txt"); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("Link to an existing name = %v, want ErrExist", err)
+	}
+	if err := c4fs.Mkdir("dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := c4fs.Link("dir", "dir2"); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("Link of a directory = %v, want ErrPermission", err)
+	}
+	if err := c4fs.Link("missing", "x"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Link of a missing file = %v, want ErrNotExist", err)
+	}
+
+	// Writing one name makes it a separate file
+	if err := c4fs.WriteFile("b.txt", []byte("changed"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := c4fs.Links("a.txt"); !slices.Equal(got, []string{"a.txt", "c.txt"}) {
+		t.Errorf("Links after write = %v", got)
+	}
+	if got, _ := c4fs.Links("b.txt"); !slices.Equal(got, []string{"b.txt"}) {
+		t.Errorf("Links of the rewritten file = %v", got)
+	}
+	if err := c4fs.Remove("c.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := c4fs.Links("a.txt"); !slices.Equal(got, []string{"a.txt"}) {
+		t.Errorf("Links after remove = %v", got)
+	}
+}
+
+func TestLinkSnapshot(t *testing.T) {
+	c4fs := New(NewStoreAdapter(store.NewRAM()))
+	for _, name := range []string{"a.txt", "x.txt"} {
+		if err := c4fs.WriteFile(name, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c4fs.Link("a.txt", "b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c4fs.Link("x.txt", "y.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := c4fs.SaveSnapshot(&buf, SnapshotBinary); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := OpenSnapshot(&buf, c4fs.Store())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := restored.Links("b.txt"); !slices.Equal(got, []string{"a.txt", "b.txt"}) {
+		t.Errorf("Links(b.txt) = %v", got)
+	}
+	if got, _ := restored.Links("x.txt"); !slices.Equal(got, []string{"x.txt", "y.txt"}) {
+		t.Errorf("Links(x.txt) = %v", got)
+	}
+
+	// New links in a clone do not join existing groups
+	clone := restored.Clone()
+	if err := clone.Link("a.txt", "c.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := clone.WriteFile("z.txt", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := clone.Link("z.txt", "zz.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := clone.Links("a.txt"); !slices.Equal(got, []string{"a.txt", "b.txt", "c.txt"}) {
+		t.Errorf("Links(a.txt) in clone = %v", got)
+	}
+	if got, _ := clone.Links("z.txt"); !slices.Equal(got, []string{"z.txt", "zz.txt"}) {
+		t.Errorf("Links(z.txt) in clone = %v", got)
+	}
+}
+
+func TestImportExportHardLinks(t *testing.T) {
+	src := t.TempDir()
+	writeTree(t, src, map[string]string{
+		"a.txt":     "shared",
+		"other.txt": "other",
+	})
+	if err := os.Mkdir(filepath.Join(src, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(filepath.Join(src, "a.txt"), filepath.Join(src, "sub", "b.txt")); err != nil {
+		t.Skipf("hard links not supported: %v", err)
+	}
+	info, err := os.Stat(filepath.Join(src, "a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fileInode(info); !ok {
+		t.Skip("hard links not detected on this platform")
+	}
+
+	c4fs := New(NewStoreAdapter(store.NewRAM()))
+	if err := c4fs.ImportDir(context.Background(), src, "", ImportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := c4fs.Links("a.txt"); !slices.Equal(got, []string{"a.txt", "sub/b.txt"}) {
+		t.Errorf("Links(a.txt) = %v", got)
+	}
+	if got, _ := c4fs.Links("other.txt"); !slices.Equal(got, []string{"other.txt"}) {
+		t.Errorf("Links(other.txt) = %v", got)
+	}
+
+	// The links survive a snapshot and are restored on export
+	var buf bytes.Buffer
+	if err := c4fs.SaveSnapshot(&buf, SnapshotBinary); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := OpenSnapshot(&buf, c4fs.Store())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := t.TempDir()
+	if err := restored.ExportDir(context.Background(), "", dst, ExportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	a, err := os.Stat(filepath.Join(dst, "a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.Stat(filepath.Join(dst, "sub", "b.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := os.Stat(filepath.Join(dst, "other.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(a, b) {
+		t.Error("exported links are separate files")
+	}
+	if os.SameFile(a, other) {
+		t.Error("unrelated files were linked")
+	}
+}
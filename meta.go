@@ -0,0 +1,227 @@
+package c4fs
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/c4m"
+)
+
+// EntryMeta is the metadata of an entry that c4m entries cannot hold,
+// kept by the filesystem beside them and stored in binary snapshots and,
+// for manifests made by Snapshot, in the store (see ManifestMeta).
+type EntryMeta struct {
+	Atime time.Time // Access time set with Chtimes; zero if none
+	Link  uint64    // Hard link group, shared by the links of a file; zero if none
+	Rdev  uint64    // Device number of a device file; zero if none
+
+	// Asset is the asset group of a primary file and its sidecars, and
+	// Sidecar is set on the sidecars; zero and false if none.
+	Asset   uint64
+	Sidecar bool
+}
+
+// metaEntry is the metadata kept for an entry, keyed by its path. The
+// entry it was set on is recorded too: once the file is rewritten,
+// removed or has its mode changed, its entry is replaced and the metadata
+// no longer applies, so other operations need not clear it. Rename moves
+// it with the entry. Asset groups are the exception: they are kept when
+// the file is rewritten or has its mode changed, so that editing a sidecar
+// does not take it out of its group.
+type metaEntry struct {
+	entry *c4m.Entry
+	meta  EntryMeta
+}
+
+// entryMetaLocked returns the metadata kept beside e.
+// The caller must hold c4fs.mu.
+func (c4fs *FS) entryMetaLocked(e *c4m.Entry) EntryMeta {
+	m, ok := c4fs.meta[e.Name]
+	if !ok || m.entry != e {
+		return EntryMeta{}
+	}
+	return m.meta
+}
+
+// updateMetaLocked changes the metadata kept beside e with fn.
+// The caller must hold c4fs.mu for writing.
+func (c4fs *FS) updateMetaLocked(e *c4m.Entry, fn func(*EntryMeta)) {
+	meta := c4fs.entryMetaLocked(e)
+	fn(&meta)
+	c4fs.setEntryMetaLocked(e, meta)
+}
+
+// setEntryMetaLocked keeps meta beside e.
+// The caller must hold c4fs.mu for writing.
+func (c4fs *FS) setEntryMetaLocked(e *c4m.Entry, meta EntryMeta) {
+	if meta == (EntryMeta{}) {
+		delete(c4fs.meta, e.Name)
+		return
+	}
+	if c4fs.meta == nil {
+		c4fs.meta = make(map[string]metaEntry)
+	}
+	c4fs.meta[e.Name] = metaEntry{entry: e, meta: meta}
+	c4fs.nextLink = max(c4fs.nextLink, meta.Link, meta.Asset)
+}
+
+// copyMeta returns the metadata that still applies and the last link or
+// asset group used, for a copy of the filesystem sharing its entries. Metadata
+// of entries the copy does not have is ignored by it.
+func (c4fs *FS) copyMeta() (map[string]metaEntry, uint64) {
+	c4fs.mu.RLock()
+	defer c4fs.mu.RUnlock()
+	var meta map[string]metaEntry
+	for name, m := range c4fs.meta {
+		if e, err := c4fs.lookup(name); err == nil && e == m.entry {
+			if meta == nil {
+				meta = make(map[string]metaEntry)
+			}
+			meta[name] = m
+		}
+	}
+	return meta, c4fs.nextLink
+}
+
+// Manifests carry no metadata, so Snapshot stores the metadata of their
+// entries as a blob referenced by the manifest's Data. The blob is a
+// binary snapshot holding only the paths of the entries with metadata and
+// their metadata, sorted by path, with link and asset groups numbered from
+// one in order of first use. Its encoding is fixed like the canonical
+// encoding (see SnapshotID), which refers to it.
+const metaVersion = 5
+
+// ManifestMeta returns the metadata of the entries of m, by path, from the
+// blob in store that its Data refers to (see Snapshot). It returns nil if
+// m has none.
+func ManifestMeta(m *c4m.Manifest, store *StoreAdapter) (map[string]EntryMeta, error) {
+	if m.Data.IsNil() {
+		return nil, nil
+	}
+	if store == nil {
+		return nil, fmt.Errorf("metadata %s: no store", m.Data)
+	}
+	rc, err := store.Get(m.Data)
+	if err != nil {
+		return nil, fmt.Errorf("metadata %s: %w", m.Data, err)
+	}
+	defer rc.Close()
+
+	sr, err := NewSnapshotReader(rc)
+	if err != nil {
+		return nil, fmt.Errorf("metadata %s: %w", m.Data, err)
+	}
+	meta := make(map[string]EntryMeta)
+	for {
+		e, err := sr.ReadEntry()
+		if err == io.EOF {
+			return meta, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("metadata %s: %w", m.Data, err)
+		}
+		meta[e.Name] = sr.Meta()
+	}
+}
+
+// SetManifestMeta stores meta, the metadata of the entries of m by path,
+// in store and points m's Data at it, so that filesystems created from m
+// with WithBase have it. Access times are left out, as Data is part of
+// the snapshot's ID. Metadata that is empty without them clears m's Data.
+func SetManifestMeta(m *c4m.Manifest, store *StoreAdapter, meta map[string]EntryMeta) error {
+	stored := make(map[string]EntryMeta, len(meta))
+	for name, em := range meta {
+		em.Atime = time.Time{}
+		if em != (EntryMeta{}) {
+			stored[name] = em
+		}
+	}
+	if len(stored) == 0 {
+		m.Data = c4.ID{}
+		return nil
+	}
+	if store == nil {
+		return errors.New("failed to store metadata: no store")
+	}
+	var buf bytes.Buffer
+	if err := writeMeta(&buf, stored); err != nil {
+		return err
+	}
+	id, err := store.Put(&buf)
+	if err != nil {
+		return fmt.Errorf("failed to store metadata: %w", err)
+	}
+	m.Data = id
+	return nil
+}
+
+// writeMeta writes meta to w in the encoding of metadata blobs.
+func writeMeta(w io.Writer, meta map[string]EntryMeta) error {
+	names := make([]string, 0, len(meta))
+	for name := range meta {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	bw := bufio.NewWriter(w)
+	bw.Write(snapshotMagic)
+	bw.WriteByte(metaVersion)
+	bw.WriteByte(0) // No required features
+	sw := &SnapshotWriter{w: bw}
+
+	groups := make(map[uint64]uint64)
+	renumber := func(group uint64) uint64 {
+		if group == 0 {
+			return 0
+		}
+		n, ok := groups[group]
+		if !ok {
+			n = uint64(len(groups) + 1)
+			groups[group] = n
+		}
+		return n
+	}
+	epoch := time.Unix(0, 0).UTC()
+	for _, name := range names {
+		m := meta[name]
+		m.Link, m.Asset = renumber(m.Link), renumber(m.Asset)
+		if err := sw.WriteEntryMeta(&c4m.Entry{Name: name, Timestamp: epoch}, m); err != nil {
+			return err
+		}
+	}
+	return sw.Close()
+}
+
+// loadBaseMeta applies the metadata stored with base (see Snapshot) to the
+// base entries it names that are not shadowed by the layer. Its groups are
+// numbered after those in use. Metadata that cannot be read is ignored, as
+// Data may have been set by another application.
+func (c4fs *FS) loadBaseMeta(base *c4m.Manifest) {
+	meta, err := ManifestMeta(base, c4fs.store)
+	if err != nil || len(meta) == 0 {
+		return
+	}
+
+	c4fs.mu.Lock()
+	defer c4fs.mu.Unlock()
+	shift := c4fs.nextLink
+	for name, m := range meta {
+		e, ok := c4fs.baseEntry(name)
+		if _, shadowed := c4fs.layerIndex[name]; !ok || shadowed {
+			continue
+		}
+		if m.Link != 0 {
+			m.Link += shift
+		}
+		if m.Asset != 0 {
+			m.Asset += shift
+		}
+		c4fs.setEntryMetaLocked(e, m)
+	}
+}
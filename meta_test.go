@@ -0,0 +1,180 @@
+package c4fs
+
+import (
+	"bytes"
+	"context"
+	"io/fs"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Avalanche-io/c4/c4m"
+)
+
+// newMetaFS returns a filesystem whose entries have a device number, hard
+// links and an access time.
+func newMetaFS(t *testing.T, store *StoreAdapter) *FS {
+	t.Helper()
+	fsys := New(store)
+	addMeta(t, fsys)
+	return fsys
+}
+
+// addMeta creates the entries of newMetaFS in fsys.
+func addMeta(t *testing.T, fsys *FS) {
+	t.Helper()
+	const char = fs.ModeDevice | fs.ModeCharDevice
+	if err := fsys.Mknod("tty", char|0620, 0x8801); err != nil {
+		t.Fatal(err)
+	}
+	fsys.WriteFile("a.txt", []byte("a"), 0644)
+	if err := fsys.Link("a.txt", "b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	fsys.WriteFile("c.txt", []byte("c"), 0644)
+	atime := time.Date(2024, 5, 1, 12, 0, 0, 42, time.UTC)
+	if err := fsys.Chtimes("c.txt", atime, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// checkMeta reports metadata of newMetaFS missing from fsys. Access times
+// are not stored with manifests, so they are not checked.
+func checkMeta(t *testing.T, how string, fsys *FS) {
+	t.Helper()
+	if dev, err := fsys.Rdev("tty"); err != nil || dev != 0x8801 {
+		t.Errorf("%s: Rdev(tty) = %#x, %v", how, dev, err)
+	}
+	if got, _ := fsys.Links("a.txt"); !slices.Equal(got, []string{"a.txt", "b.txt"}) {
+		t.Errorf("%s: Links(a.txt) = %v", how, got)
+	}
+}
+
+// mustSnapshot returns fsys.Snapshot(), failing t on error.
+func mustSnapshot(t *testing.T, fsys *FS) *c4m.Manifest {
+	t.Helper()
+	m, err := fsys.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	return m
+}
+
+func TestSnapshotKeepsMeta(t *testing.T) {
+	r := newTestRegistry(t)
+	fsys := newMetaFS(t, r.Store())
+	if !fsys.Flatten().Data.IsNil() {
+		t.Error("Flatten stored metadata")
+	}
+	m := mustSnapshot(t, fsys)
+	if m.Data.IsNil() {
+		t.Fatal("Snapshot did not store the metadata")
+	}
+	checkMeta(t, "WithBase", New(r.Store(), WithBase(m)))
+
+	// The metadata blob does not depend on how groups were numbered
+	other := New(r.Store())
+	other.WriteFile("x.txt", []byte("x"), 0644)
+	other.Link("x.txt", "y.txt")
+	other.Remove("x.txt")
+	other.Remove("y.txt")
+	addMeta(t, other)
+	if got := mustSnapshot(t, other).Data; got != m.Data {
+		t.Error("Snapshot stored different metadata for the same links")
+	}
+
+	for _, format := range []SnapshotFormat{SnapshotBinary, SnapshotText} {
+		var buf bytes.Buffer
+		if err := WriteSnapshot(&buf, m, format); err != nil {
+			t.Fatal(err)
+		}
+		loaded, err := LoadSnapshot(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if loaded.Data != m.Data {
+			t.Errorf("format %d: LoadSnapshot lost the metadata", format)
+		}
+		opened, err := OpenSnapshot(&buf, r.Store())
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkMeta(t, "OpenSnapshot", opened)
+	}
+
+	id, err := r.Put(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != SnapshotID(m) {
+		t.Errorf("Put returned %s, want SnapshotID %s", id, SnapshotID(m))
+	}
+	got, err := r.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if SnapshotID(got) != id {
+		t.Error("Loaded snapshot does not match the stored one")
+	}
+	checkMeta(t, "Registry", New(r.Store(), WithBase(got)))
+
+	// The metadata is part of the snapshot's identity
+	bare := *m
+	bare.Data[0]++
+	if SnapshotID(&bare) == id {
+		t.Error("SnapshotID ignores the metadata")
+	}
+}
+
+func TestSnapshotIDIgnoresAtime(t *testing.T) {
+	r := newTestRegistry(t)
+	fsys := newMetaFS(t, r.Store())
+	before := SnapshotID(mustSnapshot(t, fsys))
+
+	info, err := fsys.Stat("a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fsys.Chtimes("a.txt", time.Now().Add(time.Hour), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	if after := SnapshotID(mustSnapshot(t, fsys)); after != before {
+		t.Errorf("Changing an access time changed the SnapshotID from %s to %s", before, after)
+	}
+}
+
+func TestSnapshotWithoutStore(t *testing.T) {
+	fsys := New(nil)
+	fsys.Mkdir("dir", 0755)
+	if err := fsys.Chtimes("dir", time.Now(), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if m := fsys.Flatten(); len(m.Entries) != 1 {
+		t.Errorf("Flatten = %d entries, want 1", len(m.Entries))
+	}
+	if _, err := fsys.Snapshot(); err != nil {
+		t.Errorf("Snapshot with only access times failed: %v", err)
+	}
+
+	if err := fsys.Mknod("tty", fs.ModeDevice|fs.ModeCharDevice|0620, 0x8801); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fsys.Snapshot(); err == nil {
+		t.Error("Snapshot of a device number without a store succeeded")
+	}
+}
+
+func TestSnapshotterKeepsMeta(t *testing.T) {
+	r := newTestRegistry(t)
+	fsys := newMetaFS(t, r.Store())
+	s := NewSnapshotter(fsys, Every(time.Hour), NewRegistrySink(r, "snap/"), SnapshotterOptions{})
+	id, err := s.Snapshot(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := r.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkMeta(t, "Snapshotter", New(r.Store(), WithBase(m)))
+}
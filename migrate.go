@@ -0,0 +1,239 @@
+package c4fs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/c4m"
+	"github.com/Avalanche-io/c4/store"
+)
+
+// MigrateOptions controls the behavior of MigrateStore.
+type MigrateOptions struct {
+	// Verify recomputes the C4 ID of every blob as it is copied and removes
+	// it from the destination if it does not match. Blobs the destination
+	// already has are read and checked too, and copied again if they do
+	// not match, so a resumed migration repairs blobs left incomplete.
+	Verify bool
+
+	// Overwrite copies blobs even if the destination already has them.
+	// By default existing blobs are skipped, which makes an interrupted
+	// migration resumable by simply running it again.
+	Overwrite bool
+
+	// Progress, if set, is called after each blob is processed.
+	Progress func(MigrateProgress)
+}
+
+// MigrateProgress reports the state of a running migration.
+type MigrateProgress struct {
+	Total   int           // Number of blobs in the source
+	Done    int           // Blobs processed so far (copied + skipped)
+	Copied  int           // Blobs written to the destination
+	Skipped int           // Blobs already present in the destination
+	Bytes   int64         // Bytes written to the destination
+	Elapsed time.Duration // Time since the migration started
+}
+
+// Throughput returns the average copy rate in bytes per second.
+func (p MigrateProgress) Throughput() float64 {
+	if p.Elapsed <= 0 {
+		return 0
+	}
+	return float64(p.Bytes) / p.Elapsed.Seconds()
+}
+
+// MigrateStore copies every blob from src to dst.
+// The source must be listable (see ListIDs). Blobs are streamed one at a
+// time, so memory use does not depend on blob size. Folder destinations
+// are written under a temporary name and renamed once complete, so an
+// interrupted copy never leaves a partial blob under its ID. The migration
+// stops early if ctx is cancelled; the returned progress describes what
+// was done.
+func MigrateStore(ctx context.Context, src, dst store.Store, opts MigrateOptions) (MigrateProgress, error) {
+	start := time.Now()
+	var p MigrateProgress
+
+	ids, err := ListIDs(src)
+	if err != nil {
+		return p, fmt.Errorf("failed to list source: %w", err)
+	}
+	p.Total = len(ids)
+	dstAdapter := NewStoreAdapter(dst)
+
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			p.Elapsed = time.Since(start)
+			return p, err
+		}
+
+		has := dstAdapter.Has(id)
+		if has && !opts.Overwrite && (!opts.Verify || blobMatches(dst, id)) {
+			p.Skipped++
+		} else {
+			n, err := migrateBlob(src, dst, id, opts.Verify, has)
+			if err != nil {
+				p.Elapsed = time.Since(start)
+				return p, err
+			}
+			p.Copied++
+			p.Bytes += n
+		}
+
+		p.Done++
+		p.Elapsed = time.Since(start)
+		if opts.Progress != nil {
+			opts.Progress(p)
+		}
+	}
+
+	p.Elapsed = time.Since(start)
+	return p, nil
+}
+
+// Hydrate ensures dst holds every blob the manifest m references, copying
+// the missing ones from src, so that m can be read from dst alone: for
+// example, to provision a laptop for offline work from a snapshot. Copies
+// are always verified. Blobs already in dst are skipped, so an interrupted
+// hydration resumes by running it again. It stops at the first blob that
+// cannot be copied, or when ctx is cancelled; the returned progress
+// describes what was done, with Total counting the distinct blobs m
+// references.
+func Hydrate(ctx context.Context, m *c4m.Manifest, src, dst store.Store) (MigrateProgress, error) {
+	start := time.Now()
+	var p MigrateProgress
+
+	ids := slices.SortedFunc(maps.Keys(manifestBlobIDs(m)), c4.ID.Cmp)
+	p.Total = len(ids)
+	dstAdapter := NewStoreAdapter(dst)
+
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			p.Elapsed = time.Since(start)
+			return p, err
+		}
+		if dstAdapter.Has(id) {
+			p.Skipped++
+		} else {
+			n, err := migrateBlob(src, dst, id, true, false)
+			if err != nil {
+				p.Elapsed = time.Since(start)
+				return p, err
+			}
+			p.Copied++
+			p.Bytes += n
+		}
+		p.Done++
+	}
+
+	p.Elapsed = time.Since(start)
+	return p, nil
+}
+
+// blobMatches reports whether the blob id in s hashes to id.
+func blobMatches(s store.Store, id c4.ID) bool {
+	rc, err := s.Open(id)
+	if err != nil {
+		return false
+	}
+	defer rc.Close()
+	return c4.Identify(rc) == id
+}
+
+// migrateBlob streams a single blob from src to dst.
+func migrateBlob(src, dst store.Store, id c4.ID, verify, overwrite bool) (int64, error) {
+	rc, err := src.Open(id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open %s: %w", id, err)
+	}
+	defer rc.Close()
+
+	if overwrite {
+		// Stores refuse to Create an existing ID, so clear it first
+		dst.Remove(id)
+	}
+	wc, commit, err := createBlob(dst, id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create %s: %w", id, err)
+	}
+
+	var n int64
+	var got c4.ID
+	if verify {
+		pr, pw := io.Pipe()
+		done := make(chan struct{})
+		go func() {
+			got = c4.Identify(pr)
+			close(done)
+		}()
+		n, err = io.Copy(io.MultiWriter(wc, pw), rc)
+		pw.CloseWithError(err)
+		<-done
+	} else {
+		n, err = io.Copy(wc, rc)
+	}
+	if err != nil {
+		wc.Close()
+		commit(false)
+		return 0, fmt.Errorf("failed to copy %s: %w", id, err)
+	}
+	if err := wc.Close(); err != nil {
+		commit(false)
+		return 0, fmt.Errorf("failed to close %s: %w", id, err)
+	}
+
+	if verify && got != id {
+		commit(false)
+		return 0, fmt.Errorf("verification failed for %s: content hashes to %s", id, got)
+	}
+	if err := commit(true); err != nil {
+		return 0, fmt.Errorf("failed to store %s: %w", id, err)
+	}
+	return n, nil
+}
+
+// createBlob returns a writer for the blob id in s, and a function that,
+// once the writer is closed, keeps the blob if ok or discards it. Folder
+// stores are written under a temporary name, which ListIDs ignores, and
+// renamed when kept; other stores have the blob removed when discarded.
+func createBlob(s store.Store, id c4.ID) (io.WriteCloser, func(ok bool) error, error) {
+	folder, ok := s.(store.Folder)
+	if !ok {
+		wc, err := s.Create(id)
+		if err != nil {
+			return nil, nil, err
+		}
+		return wc, func(ok bool) error {
+			if !ok {
+				s.Remove(id)
+			}
+			return nil
+		}, nil
+	}
+
+	final := filepath.Join(string(folder), id.String())
+	if _, err := os.Stat(final); err == nil {
+		return nil, nil, &os.PathError{Op: "create", Path: final, Err: os.ErrExist}
+	}
+	tmp, err := os.CreateTemp(string(folder), ".migrate-*")
+	if err != nil {
+		return nil, nil, err
+	}
+	return tmp, func(ok bool) error {
+		if !ok {
+			return os.Remove(tmp.Name())
+		}
+		if err := os.Rename(tmp.Name(), final); err != nil {
+			os.Remove(tmp.Name())
+			return err
+		}
+		return nil
+	}, nil
+}
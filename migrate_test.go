@@ -0,0 +1,147 @@
+package c4fs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/store"
+)
+
+// TestMigrateStore tests copying all blobs between stores, including resume.
+func TestMigrateStore(t *testing.T) {
+	src := store.NewRAM()
+	srcAdapter := NewStoreAdapter(src)
+	for i := 0; i < 10; i++ {
+		if _, err := srcAdapter.Put(bytes.NewReader([]byte(fmt.Sprintf("blob %d", i)))); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+
+	dst, err := NewPackStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewPackStore failed: %v", err)
+	}
+	defer dst.Close()
+
+	// Pre-populate one blob to simulate an interrupted migration
+	NewStoreAdapter(dst).Put(bytes.NewReader([]byte("blob 0")))
+
+	var calls int
+	p, err := MigrateStore(context.Background(), src, dst, MigrateOptions{
+		Verify:   true,
+		Progress: func(MigrateProgress) { calls++ },
+	})
+	if err != nil {
+		t.Fatalf("MigrateStore failed: %v", err)
+	}
+	if p.Total != 10 || p.Copied != 9 || p.Skipped != 1 {
+		t.Errorf("Progress: got total=%d copied=%d skipped=%d, want 10/9/1", p.Total, p.Copied, p.Skipped)
+	}
+	if calls != 10 {
+		t.Errorf("Progress callback called %d times, want 10", calls)
+	}
+	if dst.Len() != 10 {
+		t.Errorf("Destination has %d blobs, want 10", dst.Len())
+	}
+}
+
+// TestMigrateStoreVerifyFailure tests that corrupt source blobs are rejected.
+func TestMigrateStoreVerifyFailure(t *testing.T) {
+	src := store.NewRAM()
+	id := c4.Identify(bytes.NewReader([]byte("expected")))
+	(*src)[id] = []byte("corrupted")
+
+	dst := store.NewRAM()
+	_, err := MigrateStore(context.Background(), src, dst, MigrateOptions{Verify: true})
+	if err == nil {
+		t.Fatal("Expected verification error for corrupt blob")
+	}
+	if _, ok := (*dst)[id]; ok {
+		t.Error("Corrupt blob left in destination")
+	}
+}
+
+// TestMigrateStoreTornBlob tests that a resumed, verified migration copies
+// again a blob left incomplete by an interrupted one, and that Folder
+// destinations are written under a temporary name.
+func TestMigrateStoreTornBlob(t *testing.T) {
+	src := store.NewRAM()
+	id, _ := NewStoreAdapter(src).Put(bytes.NewReader([]byte("complete content")))
+
+	dir := t.TempDir()
+	dst := store.Folder(dir)
+	if err := os.WriteFile(filepath.Join(dir, id.String()), []byte("comp"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := MigrateStore(context.Background(), src, dst, MigrateOptions{Verify: true})
+	if err != nil {
+		t.Fatalf("MigrateStore failed: %v", err)
+	}
+	if p.Copied != 1 || p.Skipped != 0 {
+		t.Errorf("Progress: got copied=%d skipped=%d, want 1/0", p.Copied, p.Skipped)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, id.String())); string(data) != "complete content" {
+		t.Errorf("Destination blob = %q after resuming", data)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("Destination has %d files, want 1", len(entries))
+	}
+}
+
+// TestMigrateStoreCancel tests that a cancelled context stops the migration.
+func TestMigrateStoreCancel(t *testing.T) {
+	src := store.NewRAM()
+	NewStoreAdapter(src).Put(bytes.NewReader([]byte("data")))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	p, err := MigrateStore(ctx, src, store.NewRAM(), MigrateOptions{})
+	if err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if p.Done != 0 {
+		t.Errorf("Expected no blobs processed, got %d", p.Done)
+	}
+}
+
+// TestHydrate tests copying the blobs a manifest references into a store
+// so it can be read offline.
+func TestHydrate(t *testing.T) {
+	src := NewStoreAdapter(store.NewRAM())
+	fsys := New(src)
+	fsys.MkdirAll("shots", 0755)
+	fsys.WriteFile("shots/a.txt", []byte("a"), 0644)
+	fsys.WriteFile("shots/b.txt", []byte("b"), 0644)
+	fsys.WriteFile("shots/copy.txt", []byte("a"), 0644)
+	fsys.WriteFile("empty.txt", nil, 0644)
+	src.Put(bytes.NewReader([]byte("unreferenced")))
+	m := fsys.Flatten()
+
+	dst := store.NewRAM()
+	NewStoreAdapter(dst).Put(bytes.NewReader([]byte("b")))
+	p, err := Hydrate(context.Background(), m, src.store, dst)
+	if err != nil {
+		t.Fatalf("Hydrate failed: %v", err)
+	}
+	if p.Total != 2 || p.Copied != 1 || p.Skipped != 1 || len(*dst) != 2 {
+		t.Errorf("Progress: got total=%d copied=%d skipped=%d with %d blobs, want 2/1/1 with 2", p.Total, p.Copied, p.Skipped, len(*dst))
+	}
+
+	// The hydrated store serves the snapshot on its own
+	offline := New(NewStoreAdapter(dst), WithBase(m))
+	if data, err := offline.ReadFile("shots/copy.txt"); err != nil || string(data) != "a" {
+		t.Errorf("ReadFile offline = %q, %v", data, err)
+	}
+
+	// Missing source blobs are reported
+	if _, err := Hydrate(context.Background(), m, store.NewRAM(), store.NewRAM()); err == nil {
+		t.Error("Expected an error for blobs missing from the source")
+	}
+}
@@ -0,0 +1,158 @@
+package c4fs
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"sync"
+	"time"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/c4m"
+	"github.com/Avalanche-io/c4/store"
+)
+
+// Mirror is a read-only view of the snapshot a registry reference points
+// at, which follows the reference as it moves. Update swaps the view to
+// the latest snapshot atomically: readers see either the old snapshot or
+// the new one, never a mix. Content is fetched from the registry's store
+// only when a file is read.
+//
+// Mirror implements fs.FS, fs.StatFS, fs.ReadDirFS and fs.ReadFileFS.
+type Mirror struct {
+	reg  *Registry
+	ref  string
+	fsys *FS
+
+	mu      sync.Mutex // serializes updates
+	current c4.ID
+}
+
+// NewMirror returns a mirror of the named reference in reg and loads the
+// snapshot it points at. If cache is not nil, content read from the
+// registry's store is kept in cache, so render nodes and similar readers
+// fetch each blob from a remote store only once.
+func NewMirror(reg *Registry, ref string, cache store.Store) (*Mirror, error) {
+	var s store.Store = reg.store.store
+	if cache != nil {
+		s = &fillingStore{local: cache, remote: s}
+	}
+	m := &Mirror{
+		reg:  reg,
+		ref:  ref,
+		fsys: New(NewStoreAdapter(s)),
+	}
+	if _, err := m.Update(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Current returns the ID of the snapshot the mirror shows.
+func (m *Mirror) Current() c4.ID {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.current
+}
+
+// Update checks the reference and, if it has moved, switches the mirror
+// to the snapshot it now points at. It reports whether the view changed.
+// Call it when notified of a change, or use Run to poll.
+func (m *Mirror) Update() (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id, err := m.reg.Ref(m.ref)
+	if err != nil {
+		return false, err
+	}
+	if id == m.current {
+		return false, nil
+	}
+	manifest, err := m.reg.Get(id)
+	if err != nil {
+		return false, err
+	}
+	m.fsys.swapBase(manifest)
+	m.current = id
+	return true, nil
+}
+
+// Run calls Update every interval until ctx is done. Update errors, such
+// as a briefly unreachable registry, do not stop it; the mirror keeps
+// showing its current snapshot. Run returns ctx.Err().
+func (m *Mirror) Run(ctx context.Context, interval time.Duration) error {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+			m.Update()
+		}
+	}
+}
+
+func (m *Mirror) Open(name string) (fs.File, error) {
+	return m.fsys.Open(name)
+}
+
+func (m *Mirror) Stat(name string) (fs.FileInfo, error) {
+	return m.fsys.Stat(name)
+}
+
+func (m *Mirror) ReadDir(name string) ([]fs.DirEntry, error) {
+	return m.fsys.ReadDir(name)
+}
+
+func (m *Mirror) ReadFile(name string) ([]byte, error) {
+	return m.fsys.ReadFile(name)
+}
+
+// swapBase replaces the base manifest, keeping the layer, and applies
+// the metadata stored with it.
+func (c4fs *FS) swapBase(base *c4m.Manifest) {
+	base = implicitDirs(c4fs.sparse.apply(base))
+	index, filter := c4fs.newBaseIndex(base), c4fs.newBaseFilter(base)
+	c4fs.mu.Lock()
+	c4fs.base = base
+	c4fs.baseIndex = index
+	c4fs.baseFilter = filter
+	c4fs.mu.Unlock()
+	c4fs.loadBaseMeta(base)
+}
+
+// fillingStore reads through a local store, copying blobs it lacks from a
+// remote store on first access. Writes go to the local store.
+type fillingStore struct {
+	local  store.Store
+	remote store.Store
+}
+
+func (s *fillingStore) Open(id c4.ID) (io.ReadCloser, error) {
+	if rc, err := s.local.Open(id); err == nil {
+		return rc, nil
+	}
+	if _, err := migrateBlob(s.remote, s.local, id, true, false); err != nil {
+		// Another reader may have filled it in the meantime
+		if rc, lerr := s.local.Open(id); lerr == nil {
+			return rc, nil
+		}
+		return nil, err
+	}
+	return s.local.Open(id)
+}
+
+// HasBlob checks both stores without copying the blob.
+func (s *fillingStore) HasBlob(id c4.ID) bool {
+	return NewStoreAdapter(s.local).Has(id) || NewStoreAdapter(s.remote).Has(id)
+}
+
+func (s *fillingStore) Create(id c4.ID) (io.WriteCloser, error) {
+	return s.local.Create(id)
+}
+
+func (s *fillingStore) Remove(id c4.ID) error {
+	return s.local.Remove(id)
+}
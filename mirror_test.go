@@ -0,0 +1,86 @@
+package c4fs
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"testing"
+	"time"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/store"
+)
+
+func TestMirror(t *testing.T) {
+	reg := newTestRegistry(t)
+	c4fs := New(reg.Store())
+	c4fs.MkdirAll("shots", 0755)
+	c4fs.WriteFile("shots/a.exr", []byte("v1"), 0644)
+	v1, err := reg.Publish("main", c4.ID{}, c4fs.Flatten())
+	if err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+
+	cache := store.NewRAM()
+	m, err := NewMirror(reg, "main", cache)
+	if err != nil {
+		t.Fatalf("NewMirror failed: %v", err)
+	}
+	if m.Current() != v1 {
+		t.Errorf("Current: got %s, want %s", m.Current(), v1)
+	}
+	if len(*cache) != 0 {
+		t.Error("content fetched before it was read")
+	}
+	if data, err := m.ReadFile("shots/a.exr"); err != nil || string(data) != "v1" {
+		t.Fatalf("ReadFile: %q, %v", data, err)
+	}
+	if len(*cache) != 1 {
+		t.Errorf("cache has %d blobs after a read, want 1", len(*cache))
+	}
+
+	// An open file keeps reading the old content across an update
+	f, err := m.Open("shots/a.exr")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer f.Close()
+
+	c4fs.WriteFile("shots/a.exr", []byte("v2"), 0644)
+	c4fs.WriteFile("shots/b.exr", []byte("new"), 0644)
+	v2, _ := reg.Put(c4fs.Flatten())
+	reg.SetRef("main", v2)
+
+	if changed, err := m.Update(); err != nil || !changed {
+		t.Fatalf("Update: changed %v, %v", changed, err)
+	}
+	if changed, _ := m.Update(); changed {
+		t.Error("second Update reported a change")
+	}
+	if data, _ := m.ReadFile("shots/a.exr"); string(data) != "v2" {
+		t.Errorf("after update: got %q", data)
+	}
+	buf := make([]byte, 2)
+	if _, err := f.Read(buf); err != nil || string(buf) != "v1" {
+		t.Errorf("open file after update: %q, %v", buf, err)
+	}
+
+	c4fs.Remove("shots/b.exr")
+	v3, _ := reg.Put(c4fs.Flatten())
+	reg.SetRef("main", v3)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	m.Run(ctx, time.Millisecond)
+	if m.Current() != v3 {
+		t.Error("Run did not follow the reference")
+	}
+	if _, err := m.Stat("shots/b.exr"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("removed file: got %v", err)
+	}
+}
+
+func TestMirrorMissingRef(t *testing.T) {
+	if _, err := NewMirror(newTestRegistry(t), "main", nil); !errors.Is(err, ErrRefNotFound) {
+		t.Errorf("got %v, want ErrRefNotFound", err)
+	}
+}
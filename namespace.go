@@ -0,0 +1,587 @@
+package c4fs
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/store"
+)
+
+// Namespaces partitions one backend store into isolated namespaces, so a
+// single deployment can serve many projects or tenants. Each namespace is
+// a store.Store that can only see the blobs written to it. Content is
+// still stored once in the backend: a blob written to several namespaces
+// is shared, and only removed from the backend when the last namespace
+// holding it removes it.
+//
+// Membership and quotas are recorded in one log file per namespace under
+// a local directory. Blobs written to a namespace are verified against their ID
+// before they are added, so a namespace cannot claim a blob it does not
+// have the content of.
+type Namespaces struct {
+	backend store.Store
+	dir     string
+
+	mu         sync.Mutex
+	spaces     map[string]*NamespacedStore
+	pending    map[c4.ID]int // Writers in progress by blob, see Create
+	auditSink  AuditSink     // Records GC sweeps; nil if not audited
+	auditActor string
+}
+
+// NewNamespaces returns the namespaces of backend recorded under dir,
+// which is created if needed.
+func NewNamespaces(backend store.Store, dir string) (*Namespaces, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create namespace directory: %w", err)
+	}
+	n := &Namespaces{
+		backend: backend,
+		dir:     dir,
+		spaces:  make(map[string]*NamespacedStore),
+		pending: make(map[c4.ID]int),
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), namespaceLogExt)
+		if !ok || e.IsDir() {
+			continue
+		}
+		s, err := n.load(name)
+		if err != nil {
+			return nil, err
+		}
+		n.spaces[name] = s
+	}
+	return n, nil
+}
+
+// namespaceLogExt is the file name extension of membership logs.
+const namespaceLogExt = ".ns"
+
+// Store returns the named namespace, creating it if needed. Names may
+// contain letters, digits, '-', '_' and '.', and may not start with '.'.
+func (n *Namespaces) Store(name string) (*NamespacedStore, error) {
+	if !validNamespace(name) {
+		return nil, fmt.Errorf("invalid namespace name %q", name)
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if s, ok := n.spaces[name]; ok {
+		return s, nil
+	}
+	s := &NamespacedStore{ns: n, name: name, members: make(map[c4.ID]int64)}
+	n.spaces[name] = s
+	return s, nil
+}
+
+// SetAudit records every GC of a namespace in sink, naming actor as the
+// one who ran it, with the IDs of the blobs the namespace dropped. A nil
+// sink stops recording.
+func (n *Namespaces) SetAudit(sink AuditSink, actor string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.auditSink, n.auditActor = sink, actor
+}
+
+// Names returns the names of all namespaces, sorted.
+func (n *Namespaces) Names() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	names := make([]string, 0, len(n.spaces))
+	for name := range n.spaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func validNamespace(name string) bool {
+	if name == "" || name[0] == '.' {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// load replays a namespace's membership log. A final record cut short by
+// a crash is dropped and the log rewritten without it, so that the next
+// record is not appended to it.
+func (n *Namespaces) load(name string) (*NamespacedStore, error) {
+	s := &NamespacedStore{ns: n, name: name, members: make(map[c4.ID]int64)}
+	data, err := os.ReadFile(s.logPath())
+	if err != nil {
+		return nil, err
+	}
+
+	lines := strings.SplitAfter(string(data), "\n")
+	torn := false
+	if last := lines[len(lines)-1]; !strings.HasSuffix(last, "\n") {
+		lines = lines[:len(lines)-1]
+		torn = last != ""
+	}
+	for i, text := range lines {
+		err := s.replay(text)
+		if err != nil && i == len(lines)-1 {
+			torn = true
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("namespace %s: line %d: %w", name, i+1, err)
+		}
+	}
+	if torn {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if err := s.compactLocked(); err != nil {
+			return nil, fmt.Errorf("namespace %s: %w", name, err)
+		}
+	}
+	return s, nil
+}
+
+// replay applies one record of the membership log.
+func (s *NamespacedStore) replay(record string) error {
+	fields := strings.Fields(record)
+	if len(fields) == 0 {
+		return nil
+	}
+	if len(fields) < 2 {
+		return fmt.Errorf("malformed record %q", record)
+	}
+	if fields[0] == "quota" {
+		quota, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return err
+		}
+		s.quota = quota
+		return nil
+	}
+	id, err := c4.Parse(fields[1])
+	if err != nil {
+		return err
+	}
+	switch {
+	case fields[0] == "+" && len(fields) == 3:
+		size, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return err
+		}
+		s.members[id] = size
+	case fields[0] == "-" && len(fields) == 2:
+		delete(s.members, id)
+	default:
+		return fmt.Errorf("malformed record %q", record)
+	}
+	return nil
+}
+
+// shared reports whether a namespace other than except holds id, or a
+// writer is adding it to a namespace. The caller must hold n.mu.
+func (n *Namespaces) shared(id c4.ID, except *NamespacedStore) bool {
+	if n.pending[id] > 0 {
+		return true
+	}
+	for _, s := range n.spaces {
+		if s != except && s.has(id) {
+			return true
+		}
+	}
+	return false
+}
+
+// NamespacedStore is one namespace of a Namespaces. It implements
+// store.Store, BlobLister and HealthChecker, so it can back an FS, a
+// Registry or any store tool.
+type NamespacedStore struct {
+	ns   *Namespaces
+	name string
+
+	mu      sync.Mutex
+	members map[c4.ID]int64 // blob sizes
+	quota   int64           // byte limit; 0 if unlimited
+}
+
+var (
+	_ store.Store   = (*NamespacedStore)(nil)
+	_ BlobLister    = (*NamespacedStore)(nil)
+	_ BlobStater    = (*NamespacedStore)(nil)
+	_ BlobChecker   = (*NamespacedStore)(nil)
+	_ HealthChecker = (*NamespacedStore)(nil)
+)
+
+// Name returns the namespace's name.
+func (s *NamespacedStore) Name() string {
+	return s.name
+}
+
+func (s *NamespacedStore) logPath() string {
+	return filepath.Join(s.ns.dir, s.name+namespaceLogExt)
+}
+
+func (s *NamespacedStore) has(id c4.ID) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.members[id]
+	return ok
+}
+
+// Open opens a blob belonging to the namespace. Blobs of other
+// namespaces do not exist as far as it is concerned.
+func (s *NamespacedStore) Open(id c4.ID) (io.ReadCloser, error) {
+	if !s.has(id) {
+		return nil, &fs.PathError{Op: "open", Path: id.String(), Err: fs.ErrNotExist}
+	}
+	return s.ns.backend.Open(id)
+}
+
+// Create returns a writer adding a blob to the namespace. If the backend
+// already holds the blob, for another namespace, the content is only
+// verified rather than stored again. Until the writer is closed, the blob
+// is kept in the backend even if the other namespaces remove it.
+func (s *NamespacedStore) Create(id c4.ID) (io.WriteCloser, error) {
+	if s.has(id) {
+		return nil, &fs.PathError{Op: "create", Path: id.String(), Err: fs.ErrExist}
+	}
+	w := &namespaceWriter{store: s, id: id, room: -1}
+	if st, quota := s.usage(); quota > 0 {
+		if w.room = quota - st.Bytes; w.room <= 0 {
+			return nil, s.quotaError(id)
+		}
+	}
+
+	s.ns.mu.Lock()
+	defer s.ns.mu.Unlock()
+	if !NewStoreAdapter(s.ns.backend).Has(id) {
+		wc, err := s.ns.backend.Create(id)
+		if err != nil {
+			return nil, err
+		}
+		w.backend = wc
+	}
+	s.ns.pending[id]++
+
+	pr, pw := io.Pipe()
+	w.hash = pw
+	w.done = make(chan c4.ID, 1)
+	go func() {
+		w.done <- c4.Identify(pr)
+	}()
+	return w, nil
+}
+
+// Remove removes a blob from the namespace, and from the backend if no
+// other namespace holds it.
+func (s *NamespacedStore) Remove(id c4.ID) error {
+	s.ns.mu.Lock()
+	defer s.ns.mu.Unlock()
+
+	if !s.has(id) {
+		return &fs.PathError{Op: "remove", Path: id.String(), Err: fs.ErrNotExist}
+	}
+	if err := s.appendLog("- " + id.String()); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	delete(s.members, id)
+	s.mu.Unlock()
+
+	if s.ns.shared(id, s) {
+		return nil
+	}
+	return s.ns.backend.Remove(id)
+}
+
+// HasBlob reports whether the blob belongs to the namespace, without
+// contacting the backend.
+func (s *NamespacedStore) HasBlob(id c4.ID) bool {
+	return s.has(id)
+}
+
+// StatBlob describes a blob belonging to the namespace.
+func (s *NamespacedStore) StatBlob(id c4.ID) (BlobInfo, error) {
+	if !s.has(id) {
+		return BlobInfo{}, &fs.PathError{Op: "stat", Path: id.String(), Err: fs.ErrNotExist}
+	}
+	return NewStoreAdapter(s.ns.backend).Stat(id)
+}
+
+// ListIDs returns the IDs of the namespace's blobs.
+func (s *NamespacedStore) ListIDs() ([]c4.ID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := make([]c4.ID, 0, len(s.members))
+	for id := range s.members {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// Ping checks the backend.
+func (s *NamespacedStore) Ping(ctx context.Context) error {
+	return NewStoreAdapter(s.ns.backend).Ping(ctx)
+}
+
+// ErrQuotaExceeded is returned when a blob would take a namespace over
+// its quota.
+var ErrQuotaExceeded = errors.New("namespace quota exceeded")
+
+// SetQuota limits the logical size of the namespace, as reported by
+// Stats, to bytes; zero removes the limit. Blobs that would exceed it are
+// refused with ErrQuotaExceeded, so one tenant cannot fill a shared
+// backend. The quota is recorded in the namespace's log, so it is kept
+// when the namespaces are opened again.
+func (s *NamespacedStore) SetQuota(bytes int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.appendLog("quota " + strconv.FormatInt(bytes, 10)); err != nil {
+		return fmt.Errorf("namespace %s: %w", s.name, err)
+	}
+	s.quota = bytes
+	return nil
+}
+
+// Quota returns the namespace's quota, or zero if it is unlimited.
+func (s *NamespacedStore) Quota() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.quota
+}
+
+// usage returns the namespace's stats and quota.
+func (s *NamespacedStore) usage() (NamespaceStats, int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.statsLocked(), s.quota
+}
+
+func (s *NamespacedStore) quotaError(id c4.ID) error {
+	return &fs.PathError{Op: "create", Path: id.String(), Err: fmt.Errorf("namespace %s: %w", s.name, ErrQuotaExceeded)}
+}
+
+// Usage returns the stats of every namespace by name, for reporting and
+// billing.
+func (n *Namespaces) Usage() map[string]NamespaceStats {
+	n.mu.Lock()
+	spaces := make([]*NamespacedStore, 0, len(n.spaces))
+	for _, s := range n.spaces {
+		spaces = append(spaces, s)
+	}
+	n.mu.Unlock()
+
+	usage := make(map[string]NamespaceStats, len(spaces))
+	for _, s := range spaces {
+		usage[s.name] = s.Stats()
+	}
+	return usage
+}
+
+// NamespaceStats summarizes the content of a namespace.
+type NamespaceStats struct {
+	Blobs int
+	Bytes int64 // Logical size; blobs shared with other namespaces count in each
+}
+
+// Stats returns the namespace's blob count and size.
+func (s *NamespacedStore) Stats() NamespaceStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.statsLocked()
+}
+
+// statsLocked is Stats for callers that already hold s.mu.
+func (s *NamespacedStore) statsLocked() NamespaceStats {
+	st := NamespaceStats{Blobs: len(s.members)}
+	for _, size := range s.members {
+		st.Bytes += size
+	}
+	return st
+}
+
+// GC removes every blob of the namespace that is not in live, for
+// example the IDs referenced by the namespace's snapshots, and compacts
+// its membership log. Blobs still held by other namespaces stay in the
+// backend. It returns the number of blobs and bytes removed from the
+// namespace. If the namespaces are audited (see Namespaces.SetAudit), a
+// sweep that removes anything is recorded.
+func (s *NamespacedStore) GC(live map[c4.ID]bool) (NamespaceStats, error) {
+	s.ns.mu.Lock()
+	sink, actor := s.ns.auditSink, s.ns.auditActor
+	removed, dead, err := s.gcLocked(live)
+	s.ns.mu.Unlock()
+
+	if sink == nil || len(dead) == 0 {
+		return removed, err
+	}
+	return removed, errors.Join(err, auditTo(sink, actor, AuditGC, s.name, dead))
+}
+
+// gcLocked is GC for callers holding s.ns.mu. It also returns the IDs
+// dropped from the namespace.
+func (s *NamespacedStore) gcLocked(live map[c4.ID]bool) (NamespaceStats, []c4.ID, error) {
+	var removed NamespaceStats
+	var dead []c4.ID
+	s.mu.Lock()
+	for id, size := range s.members {
+		if !live[id] {
+			dead = append(dead, id)
+			removed.Blobs++
+			removed.Bytes += size
+		}
+	}
+	for _, id := range dead {
+		delete(s.members, id)
+	}
+	err := s.compactLocked()
+	s.mu.Unlock()
+	if err != nil {
+		return removed, dead, err
+	}
+
+	var errs []error
+	for _, id := range dead {
+		if !s.ns.shared(id, s) {
+			errs = append(errs, s.ns.backend.Remove(id))
+		}
+	}
+	return removed, dead, errors.Join(errs...)
+}
+
+// compactLocked rewrites the membership log from the current members and
+// quota. The caller must hold s.mu.
+func (s *NamespacedStore) compactLocked() error {
+	tmp, err := os.CreateTemp(s.ns.dir, ".compact-*")
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(tmp)
+	if s.quota != 0 {
+		fmt.Fprintf(bw, "quota %d\n", s.quota)
+	}
+	for id, size := range s.members {
+		fmt.Fprintf(bw, "+ %s %d\n", id, size)
+	}
+	if err := bw.Flush(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.logPath())
+}
+
+// appendLog appends a record to the membership log.
+func (s *NamespacedStore) appendLog(record string) error {
+	f, err := os.OpenFile(s.logPath(), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(record + "\n"); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// namespaceWriter writes a blob to the backend, if it is not there
+// already, while hashing it, and adds it to the namespace on Close if the
+// content matches the ID.
+type namespaceWriter struct {
+	store   *NamespacedStore
+	id      c4.ID
+	backend io.WriteCloser // nil if the backend already has the blob
+	hash    *io.PipeWriter
+	done    chan c4.ID
+	size    int64
+	room    int64 // bytes left under the quota at Create; -1 if unlimited
+	err     error // quota error from Write
+}
+
+func (w *namespaceWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.room >= 0 && w.size+int64(len(p)) > w.room {
+		w.err = w.store.quotaError(w.id)
+		return 0, w.err
+	}
+	if w.backend != nil {
+		if _, err := w.backend.Write(p); err != nil {
+			return 0, err
+		}
+	}
+	w.hash.Write(p)
+	w.size += int64(len(p))
+	return len(p), nil
+}
+
+func (w *namespaceWriter) Close() error {
+	w.hash.Close()
+	got := <-w.done
+
+	var err error
+	if w.backend != nil {
+		err = w.backend.Close()
+	}
+
+	s := w.store
+	s.ns.mu.Lock()
+	defer s.ns.mu.Unlock()
+	if s.ns.pending[w.id]--; s.ns.pending[w.id] <= 0 {
+		delete(s.ns.pending, w.id)
+	}
+	if err != nil {
+		return err
+	}
+
+	if w.err == nil && got != w.id {
+		if w.backend != nil {
+			s.ns.backend.Remove(w.id)
+		}
+		return &IDMismatchError{Expected: w.id, Actual: got}
+	}
+
+	// Writers racing for the last of the quota are checked again together
+	err = w.err
+	if st, quota := s.usage(); err == nil && quota > 0 && st.Bytes+w.size > quota {
+		err = s.quotaError(w.id)
+	}
+	if err != nil {
+		if w.backend != nil && !s.ns.shared(w.id, s) {
+			s.ns.backend.Remove(w.id)
+		}
+		return err
+	}
+	// The backend may have lost the blob since Create found it there
+	if w.backend == nil && !NewStoreAdapter(s.ns.backend).Has(w.id) {
+		return &fs.PathError{Op: "create", Path: w.id.String(), Err: fmt.Errorf("namespace %s: blob removed from the backend while writing", s.name)}
+	}
+	if err := s.appendLog(fmt.Sprintf("+ %s %d", w.id, w.size)); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.members[w.id] = w.size
+	s.mu.Unlock()
+	return nil
+}
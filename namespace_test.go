@@ -0,0 +1,246 @@
+package c4fs
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/store"
+)
+
+func TestNamespaces(t *testing.T) {
+	backend := store.NewRAM()
+	dir := t.TempDir()
+	ns, err := NewNamespaces(backend, dir)
+	if err != nil {
+		t.Fatalf("NewNamespaces failed: %v", err)
+	}
+	alpha, _ := ns.Store("alpha")
+	beta, _ := ns.Store("beta")
+	a, b := NewStoreAdapter(alpha), NewStoreAdapter(beta)
+
+	secret, _ := a.Put(strings.NewReader("alpha only"))
+	shared, _ := a.Put(strings.NewReader("common asset"))
+	b.Put(strings.NewReader("common asset"))
+
+	// Namespaces only see their own blobs
+	if b.Has(secret) {
+		t.Error("beta can read alpha's blob")
+	}
+	if _, err := beta.Open(secret); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Open of another namespace's blob: got %v", err)
+	}
+	if !b.Has(shared) {
+		t.Error("beta cannot read its own blob")
+	}
+	// Shared content is stored once
+	if len(*backend) != 2 {
+		t.Errorf("backend holds %d blobs, want 2", len(*backend))
+	}
+
+	// Claiming a blob requires its content
+	wc, _ := beta.Create(secret)
+	wc.Write([]byte("forged"))
+	var mismatch *IDMismatchError
+	if err := wc.Close(); !errors.As(err, &mismatch) {
+		t.Errorf("forged claim: got %v", err)
+	}
+	if b.Has(secret) || !a.Has(secret) {
+		t.Error("forged claim changed membership")
+	}
+
+	if st := alpha.Stats(); st.Blobs != 2 || st.Bytes != int64(len("alpha only")+len("common asset")) {
+		t.Errorf("alpha stats: %+v", st)
+	}
+
+	// Removing a shared blob keeps it for the other namespace
+	if err := alpha.Remove(shared); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if !b.Has(shared) {
+		t.Error("shared blob removed from the backend")
+	}
+
+	// Membership survives a reopen
+	ns, err = NewNamespaces(backend, dir)
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	if names := ns.Names(); len(names) != 2 || names[0] != "alpha" || names[1] != "beta" {
+		t.Errorf("Names: got %v", names)
+	}
+	alpha, _ = ns.Store("alpha")
+	beta, _ = ns.Store("beta")
+	if ids, _ := alpha.ListIDs(); len(ids) != 1 || ids[0] != secret {
+		t.Errorf("alpha after reopen: %v", ids)
+	}
+
+	// GC removes what is not live, deleting unshared content
+	removed, err := alpha.GC(map[c4.ID]bool{})
+	if err != nil {
+		t.Fatalf("GC failed: %v", err)
+	}
+	if removed.Blobs != 1 {
+		t.Errorf("GC removed %d blobs", removed.Blobs)
+	}
+	if _, ok := (*backend)[secret]; ok {
+		t.Error("unshared blob still in the backend after GC")
+	}
+	if removed, _ := beta.GC(map[c4.ID]bool{shared: true}); removed.Blobs != 0 {
+		t.Error("GC removed a live blob")
+	}
+
+	for _, name := range []string{"", ".hidden", "a/b", "../x"} {
+		if _, err := ns.Store(name); err == nil {
+			t.Errorf("Store(%q) should fail", name)
+		}
+	}
+}
+
+func TestNamespaceQuota(t *testing.T) {
+	backend := store.NewRAM()
+	ns, err := NewNamespaces(backend, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	small, _ := ns.Store("small")
+	big, _ := ns.Store("big")
+	if err := small.SetQuota(10); err != nil {
+		t.Fatalf("SetQuota failed: %v", err)
+	}
+	a := NewStoreAdapter(small)
+
+	if _, err := a.Put(strings.NewReader("12345")); err != nil {
+		t.Fatalf("Put under quota failed: %v", err)
+	}
+	_, err = a.Put(strings.NewReader("too much content"))
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Put over quota = %v, want ErrQuotaExceeded", err)
+	}
+	if len(*backend) != 1 {
+		t.Errorf("refused blob left in the backend: %d blobs", len(*backend))
+	}
+	if _, err := a.Put(strings.NewReader("67890")); err != nil {
+		t.Fatalf("Put filling the quota failed: %v", err)
+	}
+	if _, err := a.Put(strings.NewReader("x")); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Put into a full namespace = %v, want ErrQuotaExceeded", err)
+	}
+
+	// Other namespaces are not limited
+	if _, err := NewStoreAdapter(big).Put(strings.NewReader("too much content")); err != nil {
+		t.Errorf("Put to an unlimited namespace failed: %v", err)
+	}
+
+	usage := ns.Usage()
+	if usage["small"].Bytes != 10 || usage["big"].Bytes != 16 || small.Quota() != 10 {
+		t.Errorf("Usage = %+v", usage)
+	}
+}
+
+func TestNamespaceQuotaPersisted(t *testing.T) {
+	backend, dir := store.NewRAM(), t.TempDir()
+	ns, err := NewNamespaces(backend, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A namespace with a quota and no blobs yet is kept too
+	empty, _ := ns.Store("empty")
+	empty.SetQuota(100)
+	s, _ := ns.Store("limited")
+	s.SetQuota(5)
+	s.SetQuota(8)
+	if _, err := NewStoreAdapter(s).Put(strings.NewReader("1234")); err != nil {
+		t.Fatal(err)
+	}
+
+	ns, err = NewNamespaces(backend, dir)
+	if err != nil {
+		t.Fatalf("reopening failed: %v", err)
+	}
+	if s, _ = ns.Store("limited"); s.Quota() != 8 {
+		t.Errorf("Quota after reopening = %d, want 8", s.Quota())
+	}
+	if e, _ := ns.Store("empty"); e.Quota() != 100 {
+		t.Errorf("Quota of the empty namespace after reopening = %d, want 100", e.Quota())
+	}
+	if _, err := NewStoreAdapter(s).Put(strings.NewReader("56789")); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Put over the reopened quota = %v, want ErrQuotaExceeded", err)
+	}
+
+	// GC rewrites the log, keeping the quota
+	if _, err := s.GC(map[c4.ID]bool{}); err != nil {
+		t.Fatalf("GC failed: %v", err)
+	}
+	if ns, err = NewNamespaces(backend, dir); err != nil {
+		t.Fatalf("reopening after GC failed: %v", err)
+	}
+	if s, _ = ns.Store("limited"); s.Quota() != 8 || s.Stats().Blobs != 0 {
+		t.Errorf("after GC: quota %d, %d blobs", s.Quota(), s.Stats().Blobs)
+	}
+}
+
+func TestNamespaceTornLog(t *testing.T) {
+	for _, torn := range []string{"+ c4abc", "+ c4abc+ c43xyz 10\n"} {
+		backend, dir := store.NewRAM(), t.TempDir()
+		ns, _ := NewNamespaces(backend, dir)
+		s, _ := ns.Store("tenant")
+		first, _ := NewStoreAdapter(s).Put(strings.NewReader("before the crash"))
+
+		// Simulate a crash partway through appending a record
+		f, err := os.OpenFile(s.logPath(), os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteString(torn)
+		f.Close()
+
+		if ns, err = NewNamespaces(backend, dir); err != nil {
+			t.Fatalf("%q: reopening failed: %v", torn, err)
+		}
+		s, _ = ns.Store("tenant")
+		second, err := NewStoreAdapter(s).Put(strings.NewReader("after the crash"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ns, err = NewNamespaces(backend, dir); err != nil {
+			t.Fatalf("%q: reopening after a write failed: %v", torn, err)
+		}
+		s, _ = ns.Store("tenant")
+		if !s.HasBlob(first) || !s.HasBlob(second) || s.Stats().Blobs != 2 {
+			t.Errorf("%q: blobs after reopening: %+v", torn, s.Stats())
+		}
+	}
+}
+
+func TestNamespaceSharedBlobRemovedWhileWriting(t *testing.T) {
+	backend := store.NewRAM()
+	ns, _ := NewNamespaces(backend, t.TempDir())
+	alpha, _ := ns.Store("alpha")
+	beta, _ := ns.Store("beta")
+	content := "common asset"
+	id, _ := NewStoreAdapter(alpha).Put(strings.NewReader(content))
+
+	// beta finds the blob in the backend, then alpha removes it
+	wc, err := beta.Create(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := alpha.Remove(id); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	wc.Write([]byte(content))
+	if err := wc.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	rc, err := beta.Open(id)
+	if err != nil {
+		t.Fatalf("beta lost the blob: %v", err)
+	}
+	rc.Close()
+}
@@ -0,0 +1,227 @@
+package ninep
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Message types of the 9P2000 protocol.
+const (
+	tversion = 100
+	rversion = 101
+	tauth    = 102
+	tattach  = 104
+	rattach  = 105
+	rerror   = 107
+	tflush   = 108
+	rflush   = 109
+	twalk    = 110
+	rwalk    = 111
+	topen    = 112
+	ropen    = 113
+	tcreate  = 114
+	tread    = 116
+	rread    = 117
+	twrite   = 118
+	tclunk   = 120
+	rclunk   = 121
+	tremove  = 122
+	tstat    = 124
+	rstat    = 125
+	twstat   = 126
+)
+
+// Qid types and mode bits.
+const (
+	qtDir     = 0x80
+	qtFile    = 0x00
+	dmDir     = 0x80000000
+	dmDevice  = 0x00800000 // 9P2000.u file types, set for clients that read them
+	dmPipe    = 0x00200000
+	dmSocket  = 0x00100000
+	oWrite    = 1
+	oRDWR     = 2
+	oTrunc    = 0x10
+	oRclose   = 0x40
+	maxWalk   = 16
+	version   = "9P2000"
+	minMsize  = 256
+	headerLen = 7 // size[4] type[1] tag[2]
+)
+
+// errShort is returned when a message is shorter than its fields claim.
+var errShort = errors.New("9p: short message")
+
+// qid is the server's unique identification of a file.
+type qid struct {
+	typ     uint8
+	version uint32
+	path    uint64
+}
+
+// stat is the 9P2000 directory entry.
+type stat struct {
+	typ    uint16
+	dev    uint32
+	qid    qid
+	mode   uint32
+	atime  uint32
+	mtime  uint32
+	length uint64
+	name   string
+	uid    string
+	gid    string
+	muid   string
+}
+
+// encoder appends little-endian protocol fields to a buffer.
+type encoder struct {
+	b []byte
+}
+
+func (e *encoder) u8(v uint8)   { e.b = append(e.b, v) }
+func (e *encoder) u16(v uint16) { e.b = binary.LittleEndian.AppendUint16(e.b, v) }
+func (e *encoder) u32(v uint32) { e.b = binary.LittleEndian.AppendUint32(e.b, v) }
+func (e *encoder) u64(v uint64) { e.b = binary.LittleEndian.AppendUint64(e.b, v) }
+
+func (e *encoder) str(s string) {
+	e.u16(uint16(len(s)))
+	e.b = append(e.b, s...)
+}
+
+func (e *encoder) qid(q qid) {
+	e.u8(q.typ)
+	e.u32(q.version)
+	e.u64(q.path)
+}
+
+// stat encodes st with its leading size field.
+func (e *encoder) stat(st stat) {
+	start := len(e.b)
+	e.u16(0) // patched below
+	e.u16(st.typ)
+	e.u32(st.dev)
+	e.qid(st.qid)
+	e.u32(st.mode)
+	e.u32(st.atime)
+	e.u32(st.mtime)
+	e.u64(st.length)
+	e.str(st.name)
+	e.str(st.uid)
+	e.str(st.gid)
+	e.str(st.muid)
+	binary.LittleEndian.PutUint16(e.b[start:], uint16(len(e.b)-start-2))
+}
+
+// decoder reads little-endian protocol fields from a message body.
+// The first decoding error is sticky and reported by err.
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) take(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if len(d.b) < n {
+		d.err = errShort
+		d.b = nil
+		return nil
+	}
+	v := d.b[:n]
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) u8() uint8 {
+	if b := d.take(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *decoder) u16() uint16 {
+	if b := d.take(2); b != nil {
+		return binary.LittleEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (d *decoder) u32() uint32 {
+	if b := d.take(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (d *decoder) u64() uint64 {
+	if b := d.take(8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (d *decoder) str() string {
+	return string(d.take(int(d.u16())))
+}
+
+func (d *decoder) qid() qid {
+	return qid{typ: d.u8(), version: d.u32(), path: d.u64()}
+}
+
+func (d *decoder) stat() stat {
+	sd := decoder{b: d.take(int(d.u16()))}
+	if d.err != nil {
+		return stat{}
+	}
+	st := stat{
+		typ:    sd.u16(),
+		dev:    sd.u32(),
+		qid:    sd.qid(),
+		mode:   sd.u32(),
+		atime:  sd.u32(),
+		mtime:  sd.u32(),
+		length: sd.u64(),
+		name:   sd.str(),
+		uid:    sd.str(),
+		gid:    sd.str(),
+		muid:   sd.str(),
+	}
+	d.err = sd.err
+	return st
+}
+
+// readMsg reads one message and returns its type, tag and body.
+func readMsg(r io.Reader, msize uint32) (uint8, uint16, []byte, error) {
+	var hdr [headerLen]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, 0, nil, err
+	}
+	size := binary.LittleEndian.Uint32(hdr[:4])
+	if size < headerLen || size > msize {
+		return 0, 0, nil, errors.New("9p: invalid message size")
+	}
+	body := make([]byte, size-headerLen)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, 0, nil, err
+	}
+	return hdr[4], binary.LittleEndian.Uint16(hdr[5:]), body, nil
+}
+
+// newMsg starts a message of the given type and tag. The size is filled
+// in by finish.
+func newMsg(typ uint8, tag uint16) *encoder {
+	e := &encoder{b: make([]byte, 0, 64)}
+	e.u32(0)
+	e.u8(typ)
+	e.u16(tag)
+	return e
+}
+
+// finish patches the message size and returns the encoded message.
+func (e *encoder) finish() []byte {
+	binary.LittleEndian.PutUint32(e.b, uint32(len(e.b)))
+	return e.b
+}
@@ -0,0 +1,483 @@
+// Package ninep serves a filesystem read-only over the 9P2000 protocol,
+// so snapshots can be mounted over the network without kernel modules or
+// FUSE on the serving machine. On Linux a served snapshot can be mounted
+// with the in-kernel v9fs client:
+//
+//	mount -t 9p -o trans=tcp,port=5640,version=9p2000,ro HOST /mnt/snapshot
+//
+// Any fs.FS can be served. With a *c4fs.FS, file content is hydrated from
+// the store on demand as clients read it.
+package ninep
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"net"
+	"path"
+	"strings"
+)
+
+// DefaultMsize is the largest message size the server negotiates.
+const DefaultMsize = 64 << 10
+
+var (
+	errReadOnly = errors.New("read-only file system")
+	errNoFid    = errors.New("unknown fid")
+	errFidInUse = errors.New("fid already in use")
+	errNotOpen  = errors.New("fid not open")
+	errBadName  = errors.New("invalid file name")
+	errNoAuth   = errors.New("authentication not required")
+	errBadOff   = errors.New("invalid directory read offset")
+)
+
+// Server serves an fs.FS over 9P2000.
+type Server struct {
+	fsys  fs.FS
+	msize uint32
+}
+
+// NewServer returns a server for fsys.
+func NewServer(fsys fs.FS) *Server {
+	return &Server{fsys: fsys, msize: DefaultMsize}
+}
+
+// Serve accepts connections on l and serves each in its own goroutine.
+// It returns when Accept fails, for example because l was closed.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.ServeConn(conn)
+	}
+}
+
+// ServeConn serves a single client connection until it is closed or a
+// protocol error occurs. The connection is closed on return.
+func (s *Server) ServeConn(rw io.ReadWriteCloser) error {
+	defer rw.Close()
+	c := &conn{srv: s, msize: s.msize, fids: make(map[uint32]*fid)}
+	defer c.clunkAll()
+
+	for {
+		typ, tag, body, err := readMsg(rw, c.msize)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		resp := c.handle(typ, tag, &decoder{b: body})
+		if _, err := rw.Write(resp); err != nil {
+			return err
+		}
+	}
+}
+
+// fid is a client reference to a file.
+type fid struct {
+	path  string
+	isDir bool
+	file  fs.File // set once opened
+	pos   int64   // next offset for files without ReaderAt or Seeker
+
+	// Directory reads return whole stat entries; the client continues at
+	// the byte offset following the last entry it received.
+	dirents []stat
+	dirpos  int
+	diroff  uint64
+}
+
+// conn holds the state of one client connection.
+type conn struct {
+	srv   *Server
+	msize uint32
+	fids  map[uint32]*fid
+}
+
+func (c *conn) clunkAll() {
+	for n, f := range c.fids {
+		if f.file != nil {
+			f.file.Close()
+		}
+		delete(c.fids, n)
+	}
+}
+
+// handle processes one request and returns the encoded response.
+func (c *conn) handle(typ uint8, tag uint16, d *decoder) []byte {
+	var resp *encoder
+	var err error
+	switch typ {
+	case tversion:
+		resp = c.version(tag, d)
+	case tauth:
+		err = errNoAuth
+	case tattach:
+		resp, err = c.attach(tag, d)
+	case tflush:
+		// Requests are handled in order, so nothing is ever pending
+		resp = newMsg(rflush, tag)
+	case twalk:
+		resp, err = c.walk(tag, d)
+	case topen:
+		resp, err = c.open(tag, d)
+	case tread:
+		resp, err = c.read(tag, d)
+	case tclunk:
+		resp, err = c.clunk(tag, d)
+	case tstat:
+		resp, err = c.stat(tag, d)
+	case tcreate, twrite, tremove, twstat:
+		err = errReadOnly
+	default:
+		err = fmt.Errorf("unsupported message type %d", typ)
+	}
+	if err == nil && d.err != nil {
+		err = d.err
+	}
+	if err != nil {
+		resp = newMsg(rerror, tag)
+		resp.str(err.Error())
+	}
+	return resp.finish()
+}
+
+func (c *conn) version(tag uint16, d *decoder) *encoder {
+	msize := d.u32()
+	v := d.str()
+
+	// A version request aborts all outstanding I/O
+	c.clunkAll()
+	if msize < c.msize {
+		c.msize = msize
+	}
+	if c.msize < minMsize {
+		c.msize = minMsize
+	}
+
+	resp := newMsg(rversion, tag)
+	resp.u32(c.msize)
+	if strings.HasPrefix(v, version) {
+		resp.str(version)
+	} else {
+		resp.str("unknown")
+	}
+	return resp
+}
+
+func (c *conn) attach(tag uint16, d *decoder) (*encoder, error) {
+	n := d.u32()
+	d.u32() // afid; authentication is not used
+	d.str() // uname
+	d.str() // aname
+	if d.err != nil {
+		return nil, d.err
+	}
+	if _, ok := c.fids[n]; ok {
+		return nil, errFidInUse
+	}
+
+	q, isDir, err := c.qid(".")
+	if err != nil {
+		return nil, err
+	}
+	c.fids[n] = &fid{path: ".", isDir: isDir}
+
+	resp := newMsg(rattach, tag)
+	resp.qid(q)
+	return resp, nil
+}
+
+func (c *conn) walk(tag uint16, d *decoder) (*encoder, error) {
+	from, to := d.u32(), d.u32()
+	names := make([]string, d.u16())
+	if len(names) > maxWalk {
+		return nil, fmt.Errorf("too many walk elements")
+	}
+	for i := range names {
+		names[i] = d.str()
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	f, ok := c.fids[from]
+	if !ok {
+		return nil, errNoFid
+	}
+	if f.file != nil {
+		return nil, fmt.Errorf("cannot walk an open fid")
+	}
+	if _, ok := c.fids[to]; ok && to != from {
+		return nil, errFidInUse
+	}
+
+	// Failing on the first element is an error; later failures return
+	// the qids walked so far and leave newfid unset
+	p, isDir := f.path, f.isDir
+	qids := make([]qid, 0, len(names))
+	for i, name := range names {
+		var next string
+		var q qid
+		var err error
+		switch {
+		case !isDir:
+			err = fmt.Errorf("%s: not a directory", p)
+		case name == "" || name == "." || strings.Contains(name, "/"):
+			err = errBadName
+		case name == "..":
+			next = path.Dir(p)
+		case p == ".":
+			next = name
+		default:
+			next = p + "/" + name
+		}
+		if err == nil {
+			q, isDir, err = c.qid(next)
+		}
+		if err != nil {
+			if i == 0 {
+				return nil, err
+			}
+			break
+		}
+		qids = append(qids, q)
+		p = next
+	}
+
+	if len(qids) == len(names) {
+		c.fids[to] = &fid{path: p, isDir: isDir}
+	}
+	resp := newMsg(rwalk, tag)
+	resp.u16(uint16(len(qids)))
+	for _, q := range qids {
+		resp.qid(q)
+	}
+	return resp, nil
+}
+
+func (c *conn) open(tag uint16, d *decoder) (*encoder, error) {
+	n, mode := d.u32(), d.u8()
+	if d.err != nil {
+		return nil, d.err
+	}
+	f, ok := c.fids[n]
+	if !ok {
+		return nil, errNoFid
+	}
+	if f.file != nil {
+		return nil, fmt.Errorf("fid already open")
+	}
+	if mode&3 == oWrite || mode&3 == oRDWR || mode&(oTrunc|oRclose) != 0 {
+		return nil, errReadOnly
+	}
+
+	q, _, err := c.qid(f.path)
+	if err != nil {
+		return nil, err
+	}
+	file, err := c.srv.fsys.Open(f.path)
+	if err != nil {
+		return nil, err
+	}
+	if f.isDir {
+		if f.dirents, err = c.readDir(f.path); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	f.file = file
+
+	resp := newMsg(ropen, tag)
+	resp.qid(q)
+	resp.u32(c.msize - headerLen - 4)
+	return resp, nil
+}
+
+func (c *conn) read(tag uint16, d *decoder) (*encoder, error) {
+	n, off, count := d.u32(), d.u64(), d.u32()
+	if d.err != nil {
+		return nil, d.err
+	}
+	f, ok := c.fids[n]
+	if !ok {
+		return nil, errNoFid
+	}
+	if f.file == nil {
+		return nil, errNotOpen
+	}
+	if max := c.msize - headerLen - 4; count > max {
+		count = max
+	}
+
+	resp := newMsg(rread, tag)
+	resp.u32(0) // count, patched below
+	start := len(resp.b)
+
+	if f.isDir {
+		if off == 0 {
+			f.dirpos, f.diroff = 0, 0
+		} else if off != f.diroff {
+			return nil, errBadOff
+		}
+		for ; f.dirpos < len(f.dirents); f.dirpos++ {
+			before := len(resp.b)
+			resp.stat(f.dirents[f.dirpos])
+			if len(resp.b)-start > int(count) {
+				resp.b = resp.b[:before]
+				break
+			}
+		}
+		f.diroff += uint64(len(resp.b) - start)
+	} else {
+		buf := make([]byte, count)
+		got, err := f.readAt(buf, int64(off))
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		resp.b = append(resp.b, buf[:got]...)
+	}
+
+	binary.LittleEndian.PutUint32(resp.b[start-4:], uint32(len(resp.b)-start))
+	return resp, nil
+}
+
+// readAt reads from the open file at off, using ReadAt or Seek when the
+// file supports them.
+func (f *fid) readAt(p []byte, off int64) (int, error) {
+	if ra, ok := f.file.(io.ReaderAt); ok {
+		return ra.ReadAt(p, off)
+	}
+	if off != f.pos {
+		sk, ok := f.file.(io.Seeker)
+		if !ok {
+			return 0, fmt.Errorf("file does not support random access")
+		}
+		if _, err := sk.Seek(off, io.SeekStart); err != nil {
+			return 0, err
+		}
+		f.pos = off
+	}
+	n, err := io.ReadFull(f.file, p)
+	f.pos += int64(n)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (c *conn) clunk(tag uint16, d *decoder) (*encoder, error) {
+	n := d.u32()
+	f, ok := c.fids[n]
+	if !ok {
+		return nil, errNoFid
+	}
+	delete(c.fids, n)
+	if f.file != nil {
+		f.file.Close()
+	}
+	return newMsg(rclunk, tag), nil
+}
+
+func (c *conn) stat(tag uint16, d *decoder) (*encoder, error) {
+	n := d.u32()
+	f, ok := c.fids[n]
+	if !ok {
+		return nil, errNoFid
+	}
+	info, err := fs.Stat(c.srv.fsys, f.path)
+	if err != nil {
+		return nil, err
+	}
+
+	var st encoder
+	st.stat(statFor(f.path, info))
+	resp := newMsg(rstat, tag)
+	resp.u16(uint16(len(st.b)))
+	resp.b = append(resp.b, st.b...)
+	return resp, nil
+}
+
+// readDir returns the stat entries of a directory. Entries that cannot
+// be stat'ed, such as dangling symlinks, are left out.
+func (c *conn) readDir(dir string) ([]stat, error) {
+	entries, err := fs.ReadDir(c.srv.fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	stats := make([]stat, 0, len(entries))
+	for _, e := range entries {
+		p := e.Name()
+		if dir != "." {
+			p = dir + "/" + p
+		}
+		info, err := fs.Stat(c.srv.fsys, p)
+		if err != nil {
+			continue
+		}
+		stats = append(stats, statFor(p, info))
+	}
+	return stats, nil
+}
+
+// qid stats p and returns its qid and whether it is a directory.
+func (c *conn) qid(p string) (qid, bool, error) {
+	info, err := fs.Stat(c.srv.fsys, p)
+	if err != nil {
+		return qid{}, false, err
+	}
+	return qidFor(p, info), info.IsDir(), nil
+}
+
+// qidFor derives a qid from a path and its file info. The qid path is a
+// hash of the file path and the version changes with the modification
+// time, which lets clients invalidate cached data.
+func qidFor(p string, info fs.FileInfo) qid {
+	h := fnv.New64a()
+	h.Write([]byte(p))
+	q := qid{typ: qtFile, version: uint32(info.ModTime().Unix()), path: h.Sum64()}
+	if info.IsDir() {
+		q.typ = qtDir
+	}
+	return q
+}
+
+func statFor(p string, info fs.FileInfo) stat {
+	name := path.Base(p)
+	if p == "." {
+		name = "/"
+	}
+	mode := uint32(info.Mode().Perm())
+	switch {
+	case info.IsDir():
+		mode |= dmDir
+	case info.Mode()&fs.ModeDevice != 0:
+		mode |= dmDevice
+	case info.Mode()&fs.ModeNamedPipe != 0:
+		mode |= dmPipe
+	case info.Mode()&fs.ModeSocket != 0:
+		mode |= dmSocket
+	}
+	length := uint64(info.Size())
+	if !info.Mode().IsRegular() {
+		length = 0
+	}
+	mtime := uint32(info.ModTime().Unix())
+	return stat{
+		qid:    qidFor(p, info),
+		mode:   mode,
+		atime:  mtime,
+		mtime:  mtime,
+		length: length,
+		name:   name,
+		uid:    "c4fs",
+		gid:    "c4fs",
+		muid:   "c4fs",
+	}
+}
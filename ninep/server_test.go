@@ -0,0 +1,242 @@
+package ninep
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/absfs/c4fs/c4fstest"
+)
+
+// client is a minimal synchronous 9P2000 client for tests.
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	tag  uint16
+}
+
+func newClient(t *testing.T, fsys fstest.MapFS) *client {
+	t.Helper()
+	srvConn, cliConn := net.Pipe()
+	go NewServer(c4fstest.FromMapFS(fsys)).ServeConn(srvConn)
+	t.Cleanup(func() { cliConn.Close() })
+
+	c := &client{t: t, conn: cliConn}
+	e := newMsg(tversion, 0xffff)
+	e.u32(8192)
+	e.str("9P2000")
+	d := c.rpc(e, rversion)
+	if msize, v := d.u32(), d.str(); msize != 8192 || v != "9P2000" {
+		t.Fatalf("Rversion: got %d %q", msize, v)
+	}
+
+	e = c.msg(tattach)
+	e.u32(0)
+	e.u32(0xffffffff)
+	e.str("user")
+	e.str("")
+	if q := c.rpc(e, rattach).qid(); q.typ != qtDir {
+		t.Fatalf("Root qid type: got %#x", q.typ)
+	}
+	return c
+}
+
+func (c *client) msg(typ uint8) *encoder {
+	c.tag++
+	return newMsg(typ, c.tag)
+}
+
+// send writes a request and returns the response type and body.
+func (c *client) send(e *encoder) (uint8, *decoder) {
+	c.t.Helper()
+	if _, err := c.conn.Write(e.finish()); err != nil {
+		c.t.Fatalf("write: %v", err)
+	}
+	typ, _, body, err := readMsg(c.conn, 1<<20)
+	if err != nil {
+		c.t.Fatalf("read: %v", err)
+	}
+	return typ, &decoder{b: body}
+}
+
+// rpc sends a request and fails the test unless the response has type want.
+func (c *client) rpc(e *encoder, want uint8) *decoder {
+	c.t.Helper()
+	typ, d := c.send(e)
+	if typ == rerror {
+		c.t.Fatalf("unexpected error: %s", d.str())
+	}
+	if typ != want {
+		c.t.Fatalf("response type: got %d, want %d", typ, want)
+	}
+	return d
+}
+
+// rpcErr sends a request and returns the error string, failing the test
+// if the request succeeds.
+func (c *client) rpcErr(e *encoder) string {
+	c.t.Helper()
+	typ, d := c.send(e)
+	if typ != rerror {
+		c.t.Fatalf("expected error, got response type %d", typ)
+	}
+	return d.str()
+}
+
+func (c *client) walk(from, to uint32, names ...string) int {
+	e := c.msg(twalk)
+	e.u32(from)
+	e.u32(to)
+	e.u16(uint16(len(names)))
+	for _, n := range names {
+		e.str(n)
+	}
+	return int(c.rpc(e, rwalk).u16())
+}
+
+func (c *client) open(fid uint32) {
+	e := c.msg(topen)
+	e.u32(fid)
+	e.u8(0)
+	c.rpc(e, ropen)
+}
+
+func (c *client) read(fid uint32, off uint64, count uint32) []byte {
+	e := c.msg(tread)
+	e.u32(fid)
+	e.u64(off)
+	e.u32(count)
+	d := c.rpc(e, rread)
+	return d.take(int(d.u32()))
+}
+
+func TestServerReadFile(t *testing.T) {
+	content := strings.Repeat("0123456789", 2000)
+	c := newClient(t, fstest.MapFS{
+		"dir/file.txt": {Data: []byte(content), Mode: 0644},
+	})
+
+	if n := c.walk(0, 1, "dir", "file.txt"); n != 2 {
+		t.Fatalf("walk returned %d qids, want 2", n)
+	}
+	c.open(1)
+
+	var got bytes.Buffer
+	for off := uint64(0); ; {
+		data := c.read(1, off, 4096)
+		if len(data) == 0 {
+			break
+		}
+		got.Write(data)
+		off += uint64(len(data))
+	}
+	if got.String() != content {
+		t.Errorf("content mismatch: got %d bytes, want %d", got.Len(), len(content))
+	}
+
+	// Reads at arbitrary offsets
+	if data := c.read(1, 15, 5); string(data) != "56789" {
+		t.Errorf("read at offset: got %q", data)
+	}
+
+	e := c.msg(tstat)
+	e.u32(1)
+	d := c.rpc(e, rstat)
+	d.u16()
+	st := d.stat()
+	if st.name != "file.txt" || st.length != uint64(len(content)) || st.mode != 0644 {
+		t.Errorf("stat: got name %q length %d mode %o", st.name, st.length, st.mode)
+	}
+}
+
+func TestServerReadDir(t *testing.T) {
+	files := fstest.MapFS{}
+	for i := 0; i < 50; i++ {
+		files["dir/file-with-a-long-name-"+strings.Repeat("x", i)] = &fstest.MapFile{Data: []byte("x")}
+	}
+	files["dir/sub/inner.txt"] = &fstest.MapFile{Data: []byte("inner")}
+	c := newClient(t, files)
+
+	c.walk(0, 1, "dir")
+	c.open(1)
+
+	// Small reads force the listing to span several messages
+	names := make(map[string]bool)
+	var off uint64
+	for {
+		data := c.read(1, off, 512)
+		if len(data) == 0 {
+			break
+		}
+		off += uint64(len(data))
+		d := &decoder{b: data}
+		for len(d.b) > 0 {
+			st := d.stat()
+			if d.err != nil {
+				t.Fatalf("decoding directory entry: %v", d.err)
+			}
+			names[st.name] = true
+			if st.name == "sub" && st.mode&dmDir == 0 {
+				t.Error("sub should be a directory")
+			}
+		}
+	}
+	if len(names) != 51 {
+		t.Errorf("listed %d entries, want 51", len(names))
+	}
+}
+
+func TestServerWalk(t *testing.T) {
+	c := newClient(t, fstest.MapFS{
+		"a/b/file.txt": {Data: []byte("x")},
+	})
+
+	// Partial walks return the qids walked so far and leave newfid unset
+	if n := c.walk(0, 1, "a", "missing"); n != 1 {
+		t.Errorf("partial walk returned %d qids, want 1", n)
+	}
+	e := c.msg(tstat)
+	e.u32(1)
+	c.rpcErr(e)
+
+	// ".." walks back up and stops at the root
+	if n := c.walk(0, 2, "a", "b", "..", "..", ".."); n != 5 {
+		t.Errorf("walk with .. returned %d qids", n)
+	}
+
+	// Failing on the first element is an error
+	e = c.msg(twalk)
+	e.u32(0)
+	e.u32(3)
+	e.u16(1)
+	e.str("missing")
+	c.rpcErr(e)
+}
+
+func TestServerReadOnly(t *testing.T) {
+	c := newClient(t, fstest.MapFS{
+		"file.txt": {Data: []byte("x")},
+	})
+	c.walk(0, 1, "file.txt")
+
+	e := c.msg(topen)
+	e.u32(1)
+	e.u8(oRDWR)
+	if msg := c.rpcErr(e); msg != errReadOnly.Error() {
+		t.Errorf("open for write: got %q", msg)
+	}
+
+	e = c.msg(tremove)
+	e.u32(1)
+	c.rpcErr(e)
+
+	e = c.msg(tclunk)
+	e.u32(1)
+	c.rpc(e, rclunk)
+
+	e = c.msg(tclunk)
+	e.u32(1)
+	c.rpcErr(e)
+}
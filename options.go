@@ -0,0 +1,171 @@
+package c4fs
+
+import (
+	"io/fs"
+	"time"
+
+	"github.com/Avalanche-io/c4/c4m"
+	"github.com/Avalanche-io/c4/store"
+)
+
+// Option configures a filesystem created by New.
+type Option func(*options)
+
+// options holds the settings collected from Option values.
+type options struct {
+	base           *c4m.Manifest
+	layer          *c4m.Manifest
+	hydrationLimit int
+	staging        store.Store
+	journal        string
+	layerFile      string
+	trackHandles   bool
+	handleStacks   bool
+	arena          bool
+	trieIndex      bool
+	lazyIndex      bool
+	lazyBudget     int
+	bloom          bool
+	slowThreshold  time.Duration
+	slowReport     func(SlowOp)
+	rootPerm       fs.FileMode
+	rootTime       time.Time
+	validate       bool
+	audit          AuditSink
+	auditActor     string
+	trash          bool
+	trashRetention time.Duration
+	history        SnapshotHistory
+	staleGuard     bool
+	proxies        *Registry
+	extractors     map[string]Extractor
+	sparse         []string
+}
+
+// WithBase sets the immutable base manifest. A nil manifest is treated
+// as empty. Metadata stored with it by Snapshot is kept beside its entries.
+func WithBase(m *c4m.Manifest) Option {
+	return func(o *options) {
+		o.base = m
+	}
+}
+
+// WithLayer sets the initial mutable layer, for example one saved from a
+// previous session. The filesystem takes ownership of m.
+func WithLayer(m *c4m.Manifest) Option {
+	return func(o *options) {
+		o.layer = m
+	}
+}
+
+// WithHydrationLimit limits the number of files that may be open for
+// reading at once to n. Further opens wait, admitted fairly across the
+// callers named by WithHydrationCaller, until a file is closed. Clones
+// share the limit. Zero means unlimited.
+func WithHydrationLimit(n int) Option {
+	return func(o *options) {
+		o.hydrationLimit = n
+	}
+}
+
+// WithAsyncDehydration makes writes return once their content is in
+// staging, a fast local store, and uploads it to the filesystem's store
+// in the background. Staged content stays readable until it is uploaded.
+// Use Flush to wait for uploads and DehydrationErrors to observe failures.
+// Clones share the queue.
+func WithAsyncDehydration(staging store.Store) Option {
+	return func(o *options) {
+		o.staging = staging
+	}
+}
+
+// WithDehydrationJournal records uploads pending with asynchronous
+// dehydration in a journal file at path, so that after a crash
+// FS.Recover can resume them. The staging store must be durable, such as
+// a store.Folder, for the content to survive as well.
+func WithDehydrationJournal(path string) Option {
+	return func(o *options) {
+		o.journal = path
+	}
+}
+
+// WithLayerFile makes Shutdown save the layer to a file at path in the
+// binary snapshot format, so that a later session can continue from it by
+// passing LoadSnapshot's result to WithLayer.
+func WithLayerFile(path string) Option {
+	return func(o *options) {
+		o.layerFile = path
+	}
+}
+
+// WithHandleTracking records the files open on the filesystem, for
+// FS.OpenHandles to report. If stacks is set, the stack of the goroutine
+// that opened each file is recorded too, which shows where a leaked handle
+// came from but makes opening files much slower; use it when debugging.
+// Clones track their own handles.
+func WithHandleTracking(stacks bool) Option {
+	return func(o *options) {
+		o.trackHandles = true
+		o.handleStacks = stacks
+	}
+}
+
+// WithEntryArena allocates the entries the filesystem creates in bulk,
+// such as when renaming large directories or loading a snapshot with
+// OpenSnapshot, in blocks rather than one by one. This reduces allocation
+// and garbage collection costs for manifests with millions of entries, at
+// the cost of keeping a block alive while any of its entries is.
+func WithEntryArena() Option {
+	return func(o *options) {
+		o.arena = true
+	}
+}
+
+// WithTrieIndex indexes the base manifest by directory instead of by full
+// path. It uses less memory for large manifests, especially deep ones, and
+// lists directories without scanning the manifest, at the cost of
+// somewhat slower single-path lookups. Clones and snapshots opened with
+// the option share the choice.
+func WithTrieIndex() Option {
+	return func(o *options) {
+		o.trieIndex = true
+	}
+}
+
+// WithLazyIndex defers indexing the base manifest until it is used, for
+// read-mostly filesystems over huge bases where only a few directories
+// are looked at. Opening the filesystem records only where each
+// directory's entries are; a directory is indexed on its first lookup,
+// and the indexes of recently used directories are kept up to budget
+// entries in all, least recently used first out. Zero means no limit.
+// Startup is faster and memory use far lower than with the default
+// index, at the cost of a first lookup in each directory that is slower.
+// The option takes precedence over WithTrieIndex. Clones and snapshots
+// opened with the option share the choice.
+func WithLazyIndex(budget int) Option {
+	return func(o *options) {
+		o.lazyIndex = true
+		o.lazyBudget = budget
+	}
+}
+
+// WithRoot sets the mode and modification time reported for the root
+// directory. By default the root has mode 0755 and the time the
+// filesystem was created, so that repeated Stat calls agree. Chmod and
+// Chtimes on the root change these values.
+func WithRoot(perm fs.FileMode, modTime time.Time) Option {
+	return func(o *options) {
+		o.rootPerm = perm.Perm()
+		o.rootTime = modTime
+	}
+}
+
+// WithValidation makes OpenSnapshot check the snapshot with
+// ValidateManifest and return the problems found instead of a
+// filesystem. Use it for snapshots from untrusted sources. New cannot
+// fail and ignores the option; validate a base before passing it to New.
+func WithValidation() Option {
+	return func(o *options) {
+		o.validate = true
+	}
+}
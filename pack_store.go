@@ -0,0 +1,680 @@
+package c4fs
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/store"
+)
+
+var (
+	_ store.Store = (*PackStore)(nil)
+	_ RangeOpener = (*PackStore)(nil)
+	_ BlobStater  = (*PackStore)(nil)
+	_ BlobChecker = (*PackStore)(nil)
+	_ Compacter   = (*PackStore)(nil)
+)
+
+// DefaultPackSize is the size at which a PackStore seals the active pack
+// and starts a new one.
+const DefaultPackSize = 64 << 20
+
+// packRecordHeader is the size of the per-blob header in a pack file:
+// the raw 64-byte C4 ID followed by a big-endian int64 length.
+// A length of -1 marks a deletion.
+const packRecordHeader = 64 + 8
+
+// packLocation records where a blob lives inside a pack file.
+type packLocation struct {
+	pack   int   // Pack sequence number
+	offset int64 // Offset of the blob data (after the header)
+	size   int64 // Length of the blob data
+}
+
+// PackStore is a c4/store.Store that aggregates blobs into append-only
+// pack files, avoiding one-file-per-blob inode overhead for small content.
+//
+// Each pack is a sequence of self-describing records. When a pack is sealed
+// an index file is written next to it so reopening the store only needs to
+// scan the active pack. Removing a blob appends a deletion record; the space
+// is reclaimed by Repack.
+type PackStore struct {
+	mu          sync.RWMutex
+	dir         string
+	maxPackSize int64
+	index       map[c4.ID]packLocation
+	packs       []int    // Sequence numbers of all packs, ascending
+	active      *os.File // Pack currently being appended to
+	activeSeq   int
+	activeSize  int64
+}
+
+// NewPackStore opens (or creates) a pack store rooted at dir.
+// Existing packs are indexed on open.
+func NewPackStore(dir string) (*PackStore, error) {
+	return NewPackStoreSize(dir, DefaultPackSize)
+}
+
+// NewPackStoreSize is like NewPackStore but seals packs once they reach
+// maxPackSize bytes.
+func NewPackStoreSize(dir string, maxPackSize int64) (*PackStore, error) {
+	if maxPackSize <= 0 {
+		maxPackSize = DefaultPackSize
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create pack directory: %w", err)
+	}
+
+	s := &PackStore{
+		dir:         dir,
+		maxPackSize: maxPackSize,
+		index:       make(map[c4.ID]packLocation),
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// packPath returns the path of the pack file with the given sequence number.
+func (s *PackStore) packPath(seq int) string {
+	return filepath.Join(s.dir, fmt.Sprintf("pack-%08d.pack", seq))
+}
+
+// indexPath returns the path of the index file for the given pack.
+func (s *PackStore) indexPath(seq int) string {
+	return filepath.Join(s.dir, fmt.Sprintf("pack-%08d.idx", seq))
+}
+
+// load discovers existing packs and rebuilds the in-memory index.
+func (s *PackStore) load() error {
+	names, err := filepath.Glob(filepath.Join(s.dir, "pack-*.pack"))
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		var seq int
+		if _, err := fmt.Sscanf(filepath.Base(name), "pack-%08d.pack", &seq); err != nil {
+			continue
+		}
+		s.packs = append(s.packs, seq)
+	}
+	sort.Ints(s.packs)
+
+	for i, seq := range s.packs {
+		last := i == len(s.packs)-1
+		if !last {
+			if err := s.loadIndex(seq); err == nil {
+				continue
+			}
+		}
+		end, err := s.scanPack(seq)
+		if err != nil {
+			return err
+		}
+		// Drop a torn trailing record so appends are not written after it
+		// and lost on the next scan
+		if last {
+			if info, err := os.Stat(s.packPath(seq)); err == nil && info.Size() > end {
+				if err := os.Truncate(s.packPath(seq), end); err != nil {
+					return fmt.Errorf("failed to truncate pack %d: %w", seq, err)
+				}
+			}
+		}
+	}
+
+	// Resume appending to the last pack if it still has room
+	if n := len(s.packs); n > 0 {
+		seq := s.packs[n-1]
+		if info, err := os.Stat(s.packPath(seq)); err == nil && info.Size() < s.maxPackSize {
+			if _, err := os.Stat(s.indexPath(seq)); os.IsNotExist(err) {
+				return s.openActive(seq)
+			}
+		}
+	}
+	return nil
+}
+
+// applyRecord updates the index with a single pack record.
+func (s *PackStore) applyRecord(id c4.ID, loc packLocation) {
+	if loc.size < 0 {
+		delete(s.index, id)
+		return
+	}
+	s.index[id] = loc
+}
+
+// loadIndex reads the index file for a sealed pack.
+func (s *PackStore) loadIndex(seq int) error {
+	f, err := os.Open(s.indexPath(seq))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var rec [64 + 8 + 8]byte
+	for {
+		if _, err := io.ReadFull(r, rec[:]); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("corrupt pack index %d: %w", seq, err)
+		}
+		var id c4.ID
+		copy(id[:], rec[:64])
+		s.applyRecord(id, packLocation{
+			pack:   seq,
+			offset: int64(binary.BigEndian.Uint64(rec[64:72])),
+			size:   int64(binary.BigEndian.Uint64(rec[72:80])),
+		})
+	}
+}
+
+// scanPack walks the records of a pack file and applies them to the index.
+// It returns the offset just past the last complete record; a truncated
+// trailing record (from an interrupted write) is ignored.
+func (s *PackStore) scanPack(seq int) (int64, error) {
+	f, err := os.Open(s.packPath(seq))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var hdr [packRecordHeader]byte
+	var end int64
+	for {
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			return end, nil
+		}
+		var id c4.ID
+		copy(id[:], hdr[:64])
+		size := int64(binary.BigEndian.Uint64(hdr[64:]))
+		offset := end + packRecordHeader
+
+		if size > 0 {
+			if _, err := r.Discard(int(size)); err != nil {
+				return end, nil
+			}
+		}
+		s.applyRecord(id, packLocation{pack: seq, offset: offset, size: size})
+		end = offset
+		if size > 0 {
+			end += size
+		}
+	}
+}
+
+// openActive opens the given pack for appending.
+func (s *PackStore) openActive(seq int) error {
+	f, err := os.OpenFile(s.packPath(seq), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open pack: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.active = f
+	s.activeSeq = seq
+	s.activeSize = info.Size()
+	return nil
+}
+
+// sealActive closes the active pack and writes its index file.
+func (s *PackStore) sealActive() error {
+	if s.active == nil {
+		return nil
+	}
+	seq := s.activeSeq
+	if err := s.active.Close(); err != nil {
+		return err
+	}
+	s.active = nil
+	return s.writeIndex(seq)
+}
+
+// writeIndex writes the index file for a pack from a fresh scan of its records.
+// Deletion records are included so sealed indexes replay the same history.
+func (s *PackStore) writeIndex(seq int) error {
+	f, err := os.Open(s.packPath(seq))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var buf bytes.Buffer
+	r := bufio.NewReader(f)
+	var hdr [packRecordHeader]byte
+	var offset int64
+	for {
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			break
+		}
+		size := int64(binary.BigEndian.Uint64(hdr[64:]))
+		offset += packRecordHeader
+		buf.Write(hdr[:64])
+		binary.Write(&buf, binary.BigEndian, offset)
+		binary.Write(&buf, binary.BigEndian, size)
+		if size > 0 {
+			if _, err := r.Discard(int(size)); err != nil {
+				break
+			}
+			offset += size
+		}
+	}
+
+	tmp := s.indexPath(seq) + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.indexPath(seq))
+}
+
+// appendRecord writes a record to the active pack, rolling over to a new
+// pack when the size limit is reached. Caller must hold the write lock.
+func (s *PackStore) appendRecord(id c4.ID, data []byte, size int64) (packLocation, error) {
+	if s.active != nil && s.activeSize >= s.maxPackSize {
+		if err := s.sealActive(); err != nil {
+			return packLocation{}, err
+		}
+	}
+	if s.active == nil {
+		seq := 1
+		if n := len(s.packs); n > 0 {
+			seq = s.packs[n-1] + 1
+		}
+		if err := s.openActive(seq); err != nil {
+			return packLocation{}, err
+		}
+		s.packs = append(s.packs, seq)
+	}
+
+	rec := make([]byte, packRecordHeader, packRecordHeader+len(data))
+	copy(rec, id[:])
+	binary.BigEndian.PutUint64(rec[64:], uint64(size))
+	rec = append(rec, data...)
+
+	if _, err := s.active.Write(rec); err != nil {
+		return packLocation{}, fmt.Errorf("failed to append to pack: %w", err)
+	}
+
+	loc := packLocation{
+		pack:   s.activeSeq,
+		offset: s.activeSize + packRecordHeader,
+		size:   size,
+	}
+	s.activeSize += int64(len(rec))
+	return loc, nil
+}
+
+// Open returns a reader for the blob with the given ID.
+func (s *PackStore) Open(id c4.ID) (io.ReadCloser, error) {
+	// Open the pack under the lock so Repack cannot remove it first
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	loc, ok := s.index[id]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: id.String(), Err: os.ErrNotExist}
+	}
+
+	f, err := os.Open(s.packPath(loc.pack))
+	if err != nil {
+		return nil, err
+	}
+	return &packReader{
+		SectionReader: io.NewSectionReader(f, loc.offset, loc.size),
+		f:             f,
+	}, nil
+}
+
+// OpenRange implements RangeOpener by reading only the requested section
+// of the pack file.
+func (s *PackStore) OpenRange(id c4.ID, off, length int64) (io.ReadCloser, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	loc, ok := s.index[id]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: id.String(), Err: os.ErrNotExist}
+	}
+
+	off = min(max(off, 0), loc.size)
+	if length < 0 || length > loc.size-off {
+		length = loc.size - off
+	}
+	f, err := os.Open(s.packPath(loc.pack))
+	if err != nil {
+		return nil, err
+	}
+	return &packReader{
+		SectionReader: io.NewSectionReader(f, loc.offset+off, length),
+		f:             f,
+	}, nil
+}
+
+// StatBlob implements BlobStater from the pack index. Blobs do not record
+// when they were stored, so ModTime is zero.
+func (s *PackStore) StatBlob(id c4.ID) (BlobInfo, error) {
+	s.mu.RLock()
+	loc, ok := s.index[id]
+	s.mu.RUnlock()
+	if !ok {
+		return BlobInfo{}, &os.PathError{Op: "stat", Path: id.String(), Err: os.ErrNotExist}
+	}
+	return BlobInfo{Size: loc.size}, nil
+}
+
+// HasBlob implements BlobChecker from the pack index.
+func (s *PackStore) HasBlob(id c4.ID) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.index[id]
+	return ok
+}
+
+// Create returns a writer that appends the blob to the active pack on Close.
+// Like the other c4/store implementations, it fails if the ID already exists.
+func (s *PackStore) Create(id c4.ID) (io.WriteCloser, error) {
+	s.mu.RLock()
+	_, ok := s.index[id]
+	s.mu.RUnlock()
+	if ok {
+		return nil, &os.PathError{Op: "create", Path: id.String(), Err: os.ErrExist}
+	}
+	return &packWriter{store: s, id: id}, nil
+}
+
+// Remove marks the blob as deleted by appending a deletion record.
+// The space is not reclaimed until Repack is called.
+func (s *PackStore) Remove(id c4.ID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.index[id]; !ok {
+		return &os.PathError{Op: "remove", Path: id.String(), Err: os.ErrNotExist}
+	}
+	if _, err := s.appendRecord(id, nil, -1); err != nil {
+		return err
+	}
+	delete(s.index, id)
+	return nil
+}
+
+// Len returns the number of live blobs in the store.
+func (s *PackStore) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.index)
+}
+
+// ListIDs returns the IDs of all live blobs in the store.
+func (s *PackStore) ListIDs() ([]c4.ID, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids := make([]c4.ID, 0, len(s.index))
+	for id := range s.index {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// Repack rewrites all live blobs into fresh packs and deletes the old ones,
+// reclaiming space held by removed or duplicate blobs.
+// It returns the number of bytes reclaimed. Blobs are streamed from one
+// reader per old pack, and the new packs are written aside and only
+// replace the old ones once every blob has been copied: if Repack fails,
+// they are removed and the store is left as it was.
+func (s *PackStore) Repack() (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.sealActive(); err != nil {
+		return 0, err
+	}
+
+	var before int64
+	for _, seq := range s.packs {
+		if info, err := os.Stat(s.packPath(seq)); err == nil {
+			before += info.Size()
+		}
+	}
+
+	// Copy live blobs in pack order for sequential reads
+	ids := make([]c4.ID, 0, len(s.index))
+	for id := range s.index {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		a, b := s.index[ids[i]], s.index[ids[j]]
+		if a.pack != b.pack {
+			return a.pack < b.pack
+		}
+		return a.offset < b.offset
+	})
+
+	b := &packBuilder{store: s}
+	if n := len(s.packs); n > 0 {
+		b.seq = s.packs[n-1]
+	}
+	index, err := b.copyBlobs(ids)
+	if err == nil {
+		err = b.finish()
+	}
+	if err != nil {
+		b.abort()
+		return 0, err
+	}
+
+	// Remove the old packs now that every live blob has been copied
+	oldPacks := s.packs
+	s.index, s.packs = index, b.packs
+	for _, seq := range oldPacks {
+		os.Remove(s.packPath(seq))
+		os.Remove(s.indexPath(seq))
+	}
+	var after int64
+	for _, seq := range s.packs {
+		if info, err := os.Stat(s.packPath(seq)); err == nil {
+			after += info.Size()
+		}
+	}
+	return before - after, nil
+}
+
+// packBuilder writes new packs for Repack, after the existing ones and
+// without changing the store's state.
+type packBuilder struct {
+	store *PackStore
+	seq   int      // Sequence number of the last pack started
+	packs []int    // Sequence numbers of the packs written, ascending
+	f     *os.File // Pack being written, or nil
+	w     *bufio.Writer
+	size  int64
+}
+
+// copyBlobs copies the blobs ids, sorted by location, from the store's
+// packs, opening each pack once, and returns their new locations.
+func (b *packBuilder) copyBlobs(ids []c4.ID) (map[c4.ID]packLocation, error) {
+	s := b.store
+	index := make(map[c4.ID]packLocation, len(ids))
+	var src *os.File
+	defer func() {
+		if src != nil {
+			src.Close()
+		}
+	}()
+	srcSeq := 0
+	for _, id := range ids {
+		loc := s.index[id]
+		if src == nil || srcSeq != loc.pack {
+			if src != nil {
+				src.Close()
+			}
+			var err error
+			if src, err = os.Open(s.packPath(loc.pack)); err != nil {
+				return nil, err
+			}
+			srcSeq = loc.pack
+		}
+		newLoc, err := b.add(id, io.NewSectionReader(src, loc.offset, loc.size), loc.size)
+		if err != nil {
+			return nil, err
+		}
+		index[id] = newLoc
+	}
+	return index, nil
+}
+
+// add appends a blob record with size bytes read from r, starting a new
+// pack when the size limit is reached.
+func (b *packBuilder) add(id c4.ID, r io.Reader, size int64) (packLocation, error) {
+	if b.f != nil && b.size >= b.store.maxPackSize {
+		if err := b.finish(); err != nil {
+			return packLocation{}, err
+		}
+	}
+	if b.f == nil {
+		b.seq++
+		f, err := os.OpenFile(b.store.packPath(b.seq), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			return packLocation{}, fmt.Errorf("failed to create pack: %w", err)
+		}
+		b.packs = append(b.packs, b.seq)
+		b.f, b.w, b.size = f, bufio.NewWriter(f), 0
+	}
+
+	var hdr [packRecordHeader]byte
+	copy(hdr[:], id[:])
+	binary.BigEndian.PutUint64(hdr[64:], uint64(size))
+	if _, err := b.w.Write(hdr[:]); err != nil {
+		return packLocation{}, fmt.Errorf("failed to append to pack: %w", err)
+	}
+	if _, err := io.CopyN(b.w, r, size); err != nil {
+		return packLocation{}, fmt.Errorf("failed to copy blob %s: %w", id, err)
+	}
+
+	loc := packLocation{pack: b.seq, offset: b.size + packRecordHeader, size: size}
+	b.size += packRecordHeader + size
+	return loc, nil
+}
+
+// finish flushes, syncs and closes the pack being written, and writes its
+// index file.
+func (b *packBuilder) finish() error {
+	if b.f == nil {
+		return nil
+	}
+	f := b.f
+	b.f = nil
+	err := b.w.Flush()
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write pack: %w", err)
+	}
+	return b.store.writeIndex(b.seq)
+}
+
+// abort removes the packs written so far.
+func (b *packBuilder) abort() {
+	if b.f != nil {
+		b.f.Close()
+		b.f = nil
+	}
+	for _, seq := range b.packs {
+		os.Remove(b.store.packPath(seq))
+		os.Remove(b.store.indexPath(seq))
+	}
+	b.packs = nil
+}
+
+// Compact implements Compacter with Repack.
+func (s *PackStore) Compact() (int64, error) {
+	return s.Repack()
+}
+
+// Ping checks that the pack directory is still accessible.
+func (s *PackStore) Ping(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	info, err := os.Stat(s.dir)
+	if err != nil {
+		return fmt.Errorf("pack store unavailable: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("pack store unavailable: %s is not a directory", s.dir)
+	}
+	return nil
+}
+
+// Close seals the active pack, writing its index.
+func (s *PackStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sealActive()
+}
+
+// packReader reads a single blob out of a pack file.
+type packReader struct {
+	*io.SectionReader
+	f *os.File
+}
+
+func (r *packReader) Close() error {
+	return r.f.Close()
+}
+
+// packWriter buffers a blob and appends it to the pack on Close.
+type packWriter struct {
+	store  *PackStore
+	id     c4.ID
+	buf    bytes.Buffer
+	closed bool
+}
+
+func (w *packWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, os.ErrClosed
+	}
+	return w.buf.Write(p)
+}
+
+func (w *packWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	s := w.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Another writer may have stored the same content in the meantime
+	if _, ok := s.index[w.id]; ok {
+		return nil
+	}
+
+	loc, err := s.appendRecord(w.id, w.buf.Bytes(), int64(w.buf.Len()))
+	if err != nil {
+		return err
+	}
+	s.index[w.id] = loc
+	return nil
+}
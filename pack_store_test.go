@@ -0,0 +1,369 @@
+package c4fs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/store"
+)
+
+// TestPackStore tests basic Put/Get/Delete through a StoreAdapter backed by packs.
+func TestPackStore(t *testing.T) {
+	ps, err := NewPackStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewPackStore failed: %v", err)
+	}
+	defer ps.Close()
+
+	adapter := NewStoreAdapter(ps)
+	content := []byte("packed content")
+
+	id, err := adapter.Put(bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if !adapter.Has(id) {
+		t.Fatal("Has returned false for packed content")
+	}
+
+	rc, err := adapter.Get(id)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	got, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		t.Fatalf("Failed to read packed content: %v", err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Errorf("Content mismatch: got %q, want %q", got, content)
+	}
+
+	if err := adapter.Delete(id); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if adapter.Has(id) {
+		t.Error("Has returned true after deletion")
+	}
+}
+
+// TestPackStoreReopen tests that the index is rebuilt from sealed and active packs.
+func TestPackStoreReopen(t *testing.T) {
+	dir := t.TempDir()
+
+	// Small pack size forces several packs to be sealed
+	ps, err := NewPackStoreSize(dir, 256)
+	if err != nil {
+		t.Fatalf("NewPackStoreSize failed: %v", err)
+	}
+	adapter := NewStoreAdapter(ps)
+
+	ids := make(map[string][]byte)
+	for i := 0; i < 20; i++ {
+		data := []byte(fmt.Sprintf("blob number %d", i))
+		id, err := adapter.Put(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("Put %d failed: %v", i, err)
+		}
+		ids[id.String()] = data
+	}
+
+	// Remove one blob; the deletion must survive a reopen
+	removed := []byte("blob number 3")
+	removedID, _ := adapter.Put(bytes.NewReader(removed))
+	if err := adapter.Delete(removedID); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	delete(ids, removedID.String())
+
+	if err := ps.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	ps, err = NewPackStoreSize(dir, 256)
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	defer ps.Close()
+	adapter = NewStoreAdapter(ps)
+
+	if ps.Len() != len(ids) {
+		t.Errorf("Len after reopen: got %d, want %d", ps.Len(), len(ids))
+	}
+	if adapter.Has(removedID) {
+		t.Error("Removed blob reappeared after reopen")
+	}
+	for _, data := range ids {
+		id, _ := adapter.Put(bytes.NewReader(data))
+		rc, err := adapter.Get(id)
+		if err != nil {
+			t.Fatalf("Get after reopen failed: %v", err)
+		}
+		got, _ := io.ReadAll(rc)
+		rc.Close()
+		if !bytes.Equal(got, data) {
+			t.Errorf("Content mismatch after reopen: got %q, want %q", got, data)
+		}
+	}
+}
+
+// TestPackStoreTornWrite tests that blobs written after reopening a pack
+// with a partial trailing record survive the next reopen.
+func TestPackStoreTornWrite(t *testing.T) {
+	dir := t.TempDir()
+	ps, err := NewPackStore(dir)
+	if err != nil {
+		t.Fatalf("NewPackStore failed: %v", err)
+	}
+	adapter := NewStoreAdapter(ps)
+	kept, _ := adapter.Put(bytes.NewReader([]byte("before the crash")))
+
+	// Simulate a crash partway through appending a record
+	if _, err := ps.active.Write(make([]byte, packRecordHeader/2)); err != nil {
+		t.Fatal(err)
+	}
+	ps.active.Close()
+
+	ps, err = NewPackStore(dir)
+	if err != nil {
+		t.Fatalf("Reopen after torn write failed: %v", err)
+	}
+	adapter = NewStoreAdapter(ps)
+	var ids []c4.ID
+	for _, data := range []string{"after one", "after two"} {
+		id, err := adapter.Put(bytes.NewReader([]byte(data)))
+		if err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := ps.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	ps, err = NewPackStore(dir)
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	defer ps.Close()
+	adapter = NewStoreAdapter(ps)
+	if ps.Len() != 3 {
+		t.Errorf("Len after reopen: got %d, want 3", ps.Len())
+	}
+	for _, id := range append(ids, kept) {
+		rc, err := adapter.Get(id)
+		if err != nil {
+			t.Fatalf("Get %s after reopen failed: %v", id, err)
+		}
+		rc.Close()
+	}
+}
+
+// TestPackStoreRepack tests that Repack reclaims space from removed blobs.
+func TestPackStoreRepack(t *testing.T) {
+	dir := t.TempDir()
+	ps, err := NewPackStoreSize(dir, 512)
+	if err != nil {
+		t.Fatalf("NewPackStoreSize failed: %v", err)
+	}
+	defer ps.Close()
+	adapter := NewStoreAdapter(ps)
+
+	keep, _ := adapter.Put(bytes.NewReader([]byte("keep me")))
+	for i := 0; i < 10; i++ {
+		id, err := adapter.Put(bytes.NewReader(bytes.Repeat([]byte{byte(i)}, 100)))
+		if err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		if err := adapter.Delete(id); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+	}
+
+	reclaimed, err := ps.Repack()
+	if err != nil {
+		t.Fatalf("Repack failed: %v", err)
+	}
+	if reclaimed <= 0 {
+		t.Errorf("Repack reclaimed %d bytes, want > 0", reclaimed)
+	}
+
+	rc, err := adapter.Get(keep)
+	if err != nil {
+		t.Fatalf("Get after repack failed: %v", err)
+	}
+	got, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(got) != "keep me" {
+		t.Errorf("Content after repack: got %q, want %q", got, "keep me")
+	}
+	if ps.Len() != 1 {
+		t.Errorf("Len after repack: got %d, want 1", ps.Len())
+	}
+}
+
+// TestPackStorePing tests that Ping detects a missing pack directory.
+func TestPackStorePing(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "packs")
+	ps, err := NewPackStore(dir)
+	if err != nil {
+		t.Fatalf("NewPackStore failed: %v", err)
+	}
+	defer ps.Close()
+
+	if err := ps.Ping(context.Background()); err != nil {
+		t.Errorf("Ping failed: %v", err)
+	}
+	os.RemoveAll(dir)
+	if err := ps.Ping(context.Background()); err == nil {
+		t.Error("Ping should fail after the pack directory is removed")
+	}
+}
+
+// TestPackStoreOpenRange tests reading part of a packed blob.
+func TestPackStoreOpenRange(t *testing.T) {
+	ps, err := NewPackStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewPackStore failed: %v", err)
+	}
+	defer ps.Close()
+
+	adapter := NewStoreAdapter(ps)
+	adapter.Put(bytes.NewReader([]byte("padding before")))
+	id, _ := adapter.Put(bytes.NewReader([]byte("0123456789")))
+
+	rc, err := ps.OpenRange(id, 3, 4)
+	if err != nil {
+		t.Fatalf("OpenRange failed: %v", err)
+	}
+	got, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(got) != "3456" {
+		t.Errorf("OpenRange: got %q, want %q", got, "3456")
+	}
+
+	rc, _ = ps.OpenRange(id, 8, -1)
+	got, _ = io.ReadAll(rc)
+	rc.Close()
+	if string(got) != "89" {
+		t.Errorf("OpenRange to end: got %q, want %q", got, "89")
+	}
+}
+
+func TestPackStoreRepackMultiplePacks(t *testing.T) {
+	dir := t.TempDir()
+	ps, err := NewPackStoreSize(dir, 512)
+	if err != nil {
+		t.Fatalf("NewPackStoreSize failed: %v", err)
+	}
+	adapter := NewStoreAdapter(ps)
+
+	var kept [][]byte
+	for i := 0; i < 12; i++ {
+		data := bytes.Repeat([]byte{byte(i)}, 300)
+		id, err := adapter.Put(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		if i%3 == 0 {
+			adapter.Delete(id)
+			continue
+		}
+		kept = append(kept, data)
+	}
+	if _, err := ps.Repack(); err != nil {
+		t.Fatalf("Repack failed: %v", err)
+	}
+	ps.Close()
+
+	// The new packs and their indexes are complete on reopening
+	ps, err = NewPackStoreSize(dir, 512)
+	if err != nil {
+		t.Fatalf("reopening failed: %v", err)
+	}
+	defer ps.Close()
+	if ps.Len() != len(kept) {
+		t.Errorf("Len after reopening: got %d, want %d", ps.Len(), len(kept))
+	}
+	adapter = NewStoreAdapter(ps)
+	for _, data := range kept {
+		rc, err := adapter.Get(c4.Identify(bytes.NewReader(data)))
+		if err != nil {
+			t.Fatalf("Get after repack failed: %v", err)
+		}
+		got, _ := io.ReadAll(rc)
+		rc.Close()
+		if !bytes.Equal(got, data) {
+			t.Errorf("Content after repack differs for blob %d", data[0])
+		}
+	}
+}
+
+func TestPackStoreRepackFailure(t *testing.T) {
+	dir := t.TempDir()
+	ps, err := NewPackStoreSize(dir, 512)
+	if err != nil {
+		t.Fatalf("NewPackStoreSize failed: %v", err)
+	}
+	defer ps.Close()
+	adapter := NewStoreAdapter(ps)
+
+	first, _ := adapter.Put(bytes.NewReader(bytes.Repeat([]byte{1}, 600)))
+	last, _ := adapter.Put(bytes.NewReader(bytes.Repeat([]byte{2}, 600)))
+	ps.Close()
+	packs, _ := filepath.Glob(filepath.Join(dir, "pack-*"))
+
+	// Truncate the last pack so that copying its blob fails midway
+	loc := ps.index[last]
+	if err := os.Truncate(ps.packPath(loc.pack), loc.offset+10); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ps.Repack(); err == nil {
+		t.Fatal("Repack of a truncated pack succeeded")
+	}
+
+	after, _ := filepath.Glob(filepath.Join(dir, "pack-*"))
+	if !slices.Equal(after, packs) {
+		t.Errorf("packs after failed Repack = %v, want %v", after, packs)
+	}
+	if !adapter.Has(first) || ps.Len() != 2 {
+		t.Errorf("failed Repack changed the index: Len %d", ps.Len())
+	}
+	rc, err := adapter.Get(first)
+	if err != nil {
+		t.Fatalf("Get after failed repack: %v", err)
+	}
+	rc.Close()
+	if _, err := adapter.Put(bytes.NewReader([]byte("more"))); err != nil {
+		t.Errorf("Put after failed repack: %v", err)
+	}
+}
+
+func TestCompactStore(t *testing.T) {
+	ps, err := NewPackStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewPackStore failed: %v", err)
+	}
+	defer ps.Close()
+	adapter := NewStoreAdapter(ps)
+	id, _ := adapter.Put(bytes.NewReader(bytes.Repeat([]byte("x"), 1000)))
+	adapter.Delete(id)
+	if reclaimed, err := CompactStore(ps); err != nil || reclaimed < 1000 {
+		t.Errorf("CompactStore(pack) = %d, %v", reclaimed, err)
+	}
+
+	if reclaimed, err := CompactStore(store.NewRAM()); err != nil || reclaimed != 0 {
+		t.Errorf("CompactStore(ram) = %d, %v", reclaimed, err)
+	}
+	if _, err := CompactStore(&openCountingStore{Store: store.NewRAM()}); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("CompactStore of a store without compaction = %v, want ErrUnsupported", err)
+	}
+}
@@ -0,0 +1,279 @@
+package c4fs
+
+import (
+	"io/fs"
+	"iter"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Avalanche-io/c4/c4m"
+)
+
+// pathIndex maps the paths of a base manifest to its entries.
+type pathIndex interface {
+	get(name string) (*c4m.Entry, bool)
+	len() int
+}
+
+// mapIndex is the default index, a map keyed by full path. Lookups are a
+// single hash, but every entry costs a map slot, and listing a directory
+// has to scan the whole manifest.
+type mapIndex map[string]*c4m.Entry
+
+func (m mapIndex) get(name string) (*c4m.Entry, bool) {
+	e, ok := m[name]
+	return e, ok
+}
+
+func (m mapIndex) len() int {
+	return len(m)
+}
+
+// trieIndex indexes paths by directory: each directory node maps the
+// names of its subdirectories to their nodes and keeps its direct
+// children in a slice sorted by base name. Keys are path components, so
+// a shared prefix is indexed once, in the node of its directory, rather
+// than once per path, and a file costs one slice slot instead of a map
+// slot. Directory listings read a single node instead of scanning the
+// manifest.
+type trieIndex struct {
+	root trieDir
+	n    int
+}
+
+type trieDir struct {
+	dirs  map[string]*trieDir // Subdirectories by name; nil if none
+	files []*c4m.Entry        // Direct children, sorted by base name
+}
+
+// newTrieIndex indexes m. As with the map index, the last of several
+// entries with the same path wins.
+func newTrieIndex(m *c4m.Manifest) *trieIndex {
+	t := &trieIndex{}
+	for _, e := range m.Entries {
+		dir, _ := t.dir(parentPath(e.Name), true)
+		dir.files = append(dir.files, e)
+	}
+	t.root.finish(&t.n)
+	return t
+}
+
+// finish sorts the children of d and its subdirectories, dropping all but
+// the last entry for each name, and adds their number to n.
+func (d *trieDir) finish(n *int) {
+	sort.SliceStable(d.files, func(i, j int) bool {
+		return baseName(d.files[i].Name) < baseName(d.files[j].Name)
+	})
+	files := d.files[:0]
+	for i, e := range d.files {
+		if i+1 < len(d.files) && baseName(d.files[i+1].Name) == baseName(e.Name) {
+			continue
+		}
+		files = append(files, e)
+	}
+	clear(d.files[len(files):])
+	d.files = files[:len(files):len(files)]
+	*n += len(files)
+
+	for _, sub := range d.dirs {
+		sub.finish(n)
+	}
+}
+
+// dir returns the node of the directory at p, creating it if create is
+// set.
+func (t *trieIndex) dir(p string, create bool) (*trieDir, bool) {
+	d := &t.root
+	for p != "" {
+		name, rest, _ := strings.Cut(p, "/")
+		sub, ok := d.dirs[name]
+		if !ok {
+			if !create {
+				return nil, false
+			}
+			if d.dirs == nil {
+				d.dirs = make(map[string]*trieDir)
+			}
+			sub = &trieDir{}
+			d.dirs[name] = sub
+		}
+		d, p = sub, rest
+	}
+	return d, true
+}
+
+func (t *trieIndex) get(name string) (*c4m.Entry, bool) {
+	d, ok := t.dir(parentPath(name), false)
+	if !ok {
+		return nil, false
+	}
+	base := baseName(name)
+	i := sort.Search(len(d.files), func(i int) bool {
+		return baseName(d.files[i].Name) >= base
+	})
+	if i < len(d.files) && baseName(d.files[i].Name) == base {
+		return d.files[i], true
+	}
+	return nil, false
+}
+
+func (t *trieIndex) len() int {
+	return t.n
+}
+
+// children returns the entries directly inside dir, which the caller
+// must not modify.
+func (t *trieIndex) children(dir string) []*c4m.Entry {
+	d, ok := t.dir(dir, false)
+	if !ok {
+		return nil
+	}
+	return d.files
+}
+
+// walk yields the entries below d, depth first in name order, and reports
+// whether yield asked to continue.
+func (d *trieDir) walk(yield func(*c4m.Entry) bool) bool {
+	for _, e := range d.files {
+		if !yield(e) {
+			return false
+		}
+	}
+	names := make([]string, 0, len(d.dirs))
+	for name := range d.dirs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !d.dirs[name].walk(yield) {
+			return false
+		}
+	}
+	return true
+}
+
+// parentPath returns the directory part of a slash-separated path, or ""
+// for a top-level name. Unlike path.Dir it never returns ".".
+func parentPath(name string) string {
+	if i := strings.LastIndexByte(name, '/'); i >= 0 {
+		return name[:i]
+	}
+	return ""
+}
+
+// baseName returns the last element of a slash-separated path without
+// allocating.
+func baseName(name string) string {
+	return name[strings.LastIndexByte(name, '/')+1:]
+}
+
+// implicitDirs returns m with an entry for each directory implied by
+// the paths of its entries but missing from it, as tar readers do for
+// archives that leave directories out, so every parent of an entry can
+// be listed and stat'ed. Implied directories get mode 0755 and the time
+// of the first entry found below them. If there are none, m is returned
+// as is; otherwise a copy sharing its entries is.
+func implicitDirs(m *c4m.Manifest) *c4m.Manifest {
+	var implied map[string]time.Time
+	for _, e := range m.Entries {
+		for dir := parentPath(e.Name); dir != ""; dir = parentPath(dir) {
+			if _, ok := implied[dir]; ok {
+				break
+			}
+			if implied == nil {
+				implied = make(map[string]time.Time)
+			}
+			implied[dir] = e.Timestamp
+		}
+	}
+	for _, e := range m.Entries {
+		delete(implied, e.Name)
+	}
+	if len(implied) == 0 {
+		return m
+	}
+
+	names := make([]string, 0, len(implied))
+	for name := range implied {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	cp := *m
+	cp.Entries = slices.Grow(slices.Clip(m.Entries), len(names))
+	for _, name := range names {
+		cp.Entries = append(cp.Entries, &c4m.Entry{
+			Mode:      fs.ModeDir | 0755,
+			Timestamp: implied[name],
+			Name:      name,
+		})
+	}
+	return &cp
+}
+
+// newBaseIndex indexes a base manifest the way the filesystem's current
+// base index does.
+func (c4fs *FS) newBaseIndex(base *c4m.Manifest) pathIndex {
+	if l, ok := c4fs.baseIndex.(*lazyIndex); ok {
+		return newLazyIndex(base, l.budget)
+	}
+	if _, ok := c4fs.baseIndex.(*trieIndex); ok {
+		return newTrieIndex(base)
+	}
+	return mapIndex(buildIndex(base))
+}
+
+// baseChildren yields the base entries directly inside dir, which must be
+// clean. The caller must hold c4fs.mu.
+func (c4fs *FS) baseChildren(dir string) iter.Seq[*c4m.Entry] {
+	return func(yield func(*c4m.Entry) bool) {
+		if l, ok := c4fs.baseIndex.(*lazyIndex); ok {
+			l.children(dir, yield)
+			return
+		}
+		if t, ok := c4fs.baseIndex.(*trieIndex); ok {
+			for _, e := range t.children(dir) {
+				if !yield(e) {
+					return
+				}
+			}
+			return
+		}
+		for _, e := range c4fs.base.Entries {
+			if c4fs.isDirectChild(dir, e.Name) && !yield(e) {
+				return
+			}
+		}
+	}
+}
+
+// baseSubtree yields the base entry at dir, which must be clean and not
+// the root, and every base entry below it. The caller must hold c4fs.mu.
+func (c4fs *FS) baseSubtree(dir string) iter.Seq[*c4m.Entry] {
+	return func(yield func(*c4m.Entry) bool) {
+		if l, ok := c4fs.baseIndex.(*lazyIndex); ok {
+			if e, ok := l.get(dir); ok && !yield(e) {
+				return
+			}
+			l.subtree(dir, yield)
+			return
+		}
+		if t, ok := c4fs.baseIndex.(*trieIndex); ok {
+			if e, ok := t.get(dir); ok && !yield(e) {
+				return
+			}
+			if d, ok := t.dir(dir, false); ok {
+				d.walk(yield)
+			}
+			return
+		}
+		for _, e := range c4fs.base.Entries {
+			if e.Name == dir || strings.HasPrefix(e.Name, dir+"/") {
+				if !yield(e) {
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package c4fs
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"math/rand"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/Avalanche-io/c4/c4m"
+	"github.com/Avalanche-io/c4/store"
+)
+
+// randomTree returns a manifest of random nested directories and files.
+func randomTree(seed int64, n int) *c4m.Manifest {
+	rng := rand.New(rand.NewSource(seed))
+	m := c4m.NewManifest()
+	dirs := []string{""}
+	for i := range n {
+		parent := dirs[rng.Intn(len(dirs))]
+		name := fmt.Sprintf("n%d", rng.Intn(n))
+		if parent != "" {
+			name = parent + "/" + name
+		}
+		if rng.Intn(4) == 0 {
+			dirs = append(dirs, name)
+			m.AddEntry(&c4m.Entry{Name: name, Mode: 0755 | 1<<31, Timestamp: time.Unix(int64(i), 0)})
+		} else {
+			m.AddEntry(&c4m.Entry{Name: name, Mode: 0644, Size: int64(i), Timestamp: time.Unix(int64(i), 0)})
+		}
+	}
+	return m
+}
+
+func TestTrieIndexMatchesMap(t *testing.T) {
+	for seed := range int64(5) {
+		m := randomTree(seed, 2000)
+		want := mapIndex(buildIndex(m))
+		trie := newTrieIndex(m)
+
+		if trie.len() != want.len() {
+			t.Fatalf("seed %d: len = %d, want %d", seed, trie.len(), want.len())
+		}
+		for name, e := range want {
+			if got, ok := trie.get(name); !ok || got != e {
+				t.Fatalf("seed %d: get(%q) = %v, %v; want %v", seed, name, got, ok, e)
+			}
+		}
+		for _, name := range []string{"", "missing", "n1/missing", "n1/n2/n3/n4"} {
+			if _, ok := want[name]; ok {
+				continue
+			}
+			if _, ok := trie.get(name); ok {
+				t.Errorf("seed %d: get(%q) found a missing path", seed, name)
+			}
+		}
+
+		mapFS := New(NewStoreAdapter(store.NewRAM()), WithBase(m))
+		trieFS := New(NewStoreAdapter(store.NewRAM()), WithBase(m), WithTrieIndex())
+		for name := range want {
+			if !mapFS.baseIndex.(mapIndex)[name].IsDir() {
+				continue
+			}
+			if a, b := names(mapFS.baseChildren(name)), names(trieFS.baseChildren(name)); !slices.Equal(a, b) {
+				t.Fatalf("seed %d: children(%q) = %v, want %v", seed, name, b, a)
+			}
+			if a, b := names(mapFS.baseSubtree(name)), names(trieFS.baseSubtree(name)); !slices.Equal(a, b) {
+				t.Fatalf("seed %d: subtree(%q) = %v, want %v", seed, name, b, a)
+			}
+		}
+	}
+}
+
+// names returns the sorted, deduplicated names of the entries in seq.
+func names(seq func(func(*c4m.Entry) bool)) []string {
+	var out []string
+	for e := range seq {
+		out = append(out, e.Name)
+	}
+	slices.Sort(out)
+	return slices.Compact(out)
+}
+
+func TestWithTrieIndex(t *testing.T) {
+	src := New(NewStoreAdapter(store.NewRAM()))
+	src.MkdirAll("a/b/c", 0755)
+	src.WriteFile("a/one.txt", []byte("1"), 0644)
+	src.WriteFile("a/b/two.txt", []byte("2"), 0644)
+	src.WriteFile("a/b/c/three.txt", []byte("3"), 0644)
+	src.WriteFile("top.txt", []byte("top"), 0644)
+
+	fsys := New(src.store, WithBase(src.Flatten()), WithTrieIndex())
+	if _, ok := fsys.baseIndex.(*trieIndex); !ok {
+		t.Fatal("WithTrieIndex did not select the trie index")
+	}
+	sub, _ := fsys.Sub("a")
+	if err := fstest.TestFS(sub, "one.txt", "b/two.txt", "b/c/three.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fsys.Rename("a/b", "moved"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	if data, err := fsys.ReadFile("moved/c/three.txt"); err != nil || string(data) != "3" {
+		t.Errorf("ReadFile(moved/c/three.txt) = %q, %v", data, err)
+	}
+	if fsys.Exists("a/b/two.txt") {
+		t.Error("a/b/two.txt exists after Rename")
+	}
+	if err := fsys.RemoveAll("a"); err != nil {
+		t.Fatalf("RemoveAll failed: %v", err)
+	}
+	entries, _ := fsys.ReadDir(".")
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Name())
+	}
+	if want := "moved top.txt"; strings.Join(got, " ") != want {
+		t.Errorf("ReadDir(.) = %v, want %s", got, want)
+	}
+
+	// Clones keep the index
+	if _, ok := fsys.Clone().baseIndex.(*trieIndex); !ok {
+		t.Error("Clone dropped the trie index")
+	}
+}
+
+func TestImplicitDirs(t *testing.T) {
+	m := c4m.NewManifest()
+	mtime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	m.AddEntry(&c4m.Entry{Name: "a/b/file.txt", Mode: 0644, Size: 1, Timestamp: mtime})
+	m.AddEntry(&c4m.Entry{Name: "a/other.txt", Mode: 0644, Size: 1, Timestamp: mtime})
+	m.AddEntry(&c4m.Entry{Name: "c", Mode: fs.ModeDir | 0700, Timestamp: mtime})
+	m.AddEntry(&c4m.Entry{Name: "c/d/e.txt", Mode: 0644, Size: 1, Timestamp: mtime})
+
+	for _, opts := range [][]Option{nil, {WithTrieIndex()}} {
+		fsys := New(NewStoreAdapter(store.NewRAM()), append(opts, WithBase(m))...)
+		for _, dir := range []string{"a", "a/b", "c/d"} {
+			info, err := fsys.Stat(dir)
+			if err != nil || info.Mode() != fs.ModeDir|0755 || !info.ModTime().Equal(mtime) {
+				t.Errorf("Stat(%s) = %v, %v", dir, info, err)
+			}
+		}
+		if info, _ := fsys.Stat("c"); info.Mode() != fs.ModeDir|0700 {
+			t.Errorf("explicit directory mode = %v", info.Mode())
+		}
+		entries, err := fsys.ReadDir(".")
+		var got []string
+		for _, e := range entries {
+			got = append(got, e.Name())
+		}
+		if err != nil || !slices.Equal(got, []string{"a", "c"}) {
+			t.Errorf("ReadDir(.) = %v, %v", got, err)
+		}
+		if err := fsys.Rename("a", "moved"); err != nil {
+			t.Fatalf("Rename of an implied directory failed: %v", err)
+		}
+		if !fsys.Exists("moved/b/file.txt") {
+			t.Error("moved/b/file.txt missing after Rename")
+		}
+	}
+	if len(m.Entries) != 4 {
+		t.Errorf("base manifest was modified: %d entries", len(m.Entries))
+	}
+
+	// Binary snapshots without directory entries get them too
+	var buf bytes.Buffer
+	if err := WriteSnapshot(&buf, m, SnapshotBinary); err != nil {
+		t.Fatal(err)
+	}
+	fsys, err := OpenSnapshot(&buf, NewStoreAdapter(store.NewRAM()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := fsys.Stat("a/b"); err != nil || !info.IsDir() {
+		t.Errorf("Stat(a/b) from snapshot = %v, %v", info, err)
+	}
+}
+
+func TestChildCount(t *testing.T) {
+	base := c4m.NewManifest()
+	base.AddEntry(&c4m.Entry{Name: "shots", Mode: fs.ModeDir | 0755})
+	base.AddEntry(&c4m.Entry{Name: "shots/a", Mode: fs.ModeDir | 0755})
+	base.AddEntry(&c4m.Entry{Name: "shots/a/1.exr", Mode: 0644})
+	base.AddEntry(&c4m.Entry{Name: "shots/a/2.exr", Mode: 0644})
+
+	for _, trie := range []bool{false, true} {
+		opts := []Option{WithBase(base)}
+		if trie {
+			opts = append(opts, WithTrieIndex())
+		}
+		c4fs := New(NewStoreAdapter(store.NewRAM()), opts...)
+		count := func(name string, want int) {
+			t.Helper()
+			if n, err := c4fs.ChildCount(name); err != nil || n != want {
+				t.Errorf("trie %v: ChildCount(%s) = %d, %v; want %d", trie, name, n, err, want)
+			}
+		}
+
+		count("shots/a", 2)
+		c4fs.WriteFile("shots/a/3.exr", []byte("3"), 0644)
+		c4fs.WriteFile("shots/a/1.exr", []byte("1"), 0644)
+		count("shots/a", 3)
+		count("/", 1)
+		c4fs.Remove("shots/a/2.exr")
+		count("shots/a", 2)
+		if _, err := c4fs.ChildCount("shots/a/1.exr"); err == nil {
+			t.Errorf("trie %v: expected an error counting a file", trie)
+		}
+
+		if err := c4fs.Remove("shots/a"); err == nil {
+			t.Errorf("trie %v: removed a directory that is not empty", trie)
+		}
+
+		// Renaming moves layer and base children alike
+		c4fs.MkdirAll("shots/a/sub", 0755)
+		c4fs.WriteFile("shots/a/sub/x", []byte("x"), 0644)
+		if err := c4fs.Rename("shots/a", "shots/b"); err != nil {
+			t.Fatal(err)
+		}
+		count("shots/b", 3)
+		count("shots/b/sub", 1)
+		count("shots", 1)
+		if data, err := c4fs.ReadFile("shots/b/3.exr"); err != nil || string(data) != "3" {
+			t.Errorf("trie %v: renamed file = %q, %v", trie, data, err)
+		}
+
+		for _, name := range []string{"shots/b/1.exr", "shots/b/3.exr", "shots/b/sub/x", "shots/b/sub"} {
+			if err := c4fs.Remove(name); err != nil {
+				t.Fatalf("trie %v: Remove(%s) failed: %v", trie, name, err)
+			}
+		}
+		count("shots/b", 0)
+		if err := c4fs.Remove("shots/b"); err != nil {
+			t.Errorf("trie %v: Remove of an emptied directory failed: %v", trie, err)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package c4fs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Avalanche-io/c4"
+)
+
+// ErrNoProxy is returned by an Extractor for content it cannot make a
+// proxy of, and by FS.Proxy when a file has no proxy of the kind asked.
+var ErrNoProxy = errors.New("no proxy")
+
+// Extractor makes a proxy of a file, such as a thumbnail of an image or a
+// low resolution copy of a video, for tools that need a preview rather
+// than the full content.
+type Extractor interface {
+	// Extract writes the proxy of the file name, whose content is read
+	// from content, to w. It returns ErrNoProxy if it does not handle
+	// the file, for example because of its format.
+	Extract(ctx context.Context, name string, content io.Reader, w io.Writer) error
+}
+
+// ExtractorFunc adapts a function to an Extractor.
+type ExtractorFunc func(ctx context.Context, name string, content io.Reader, w io.Writer) error
+
+// Extract calls f(ctx, name, content, w).
+func (f ExtractorFunc) Extract(ctx context.Context, name string, content io.Reader, w io.Writer) error {
+	return f(ctx, name, content, w)
+}
+
+// WithProxies makes the filesystem generate proxies of its files with
+// extractors, keyed by the kind of proxy each makes, such as "thumbnail".
+// Proxies are stored as blobs and recorded in index by the C4 ID of the
+// original, so files with the same content share them and they survive
+// renames and snapshots. Run RunProxyExtraction to generate them as files
+// are written, or call ExtractProxies. Clones share the settings.
+func WithProxies(index *Registry, extractors map[string]Extractor) Option {
+	return func(o *options) {
+		o.proxies = index
+		o.extractors = extractors
+	}
+}
+
+// Proxy opens the proxy of the given kind made of the file name. It
+// returns an error wrapping ErrNoProxy if none has been made.
+func (c4fs *FS) Proxy(name, kind string) (io.ReadCloser, error) {
+	entry, err := c4fs.resolveSymlink(name, 40)
+	if err != nil {
+		return nil, err
+	}
+	id, ok := contentID(entry)
+	if !ok || c4fs.proxies == nil {
+		return nil, &fs.PathError{Op: "proxy", Path: name, Err: ErrNoProxy}
+	}
+	proxy, ok, err := c4fs.proxies.Proxy(id, kind)
+	if err == nil && !ok {
+		err = ErrNoProxy
+	}
+	if err != nil {
+		return nil, &fs.PathError{Op: "proxy", Path: name, Err: err}
+	}
+	rc, err := c4fs.store.Get(proxy)
+	if err != nil {
+		return nil, &fs.PathError{Op: "proxy", Path: name, Err: err}
+	}
+	return rc, nil
+}
+
+// ExtractProxies makes the missing proxies of the file name, or of every
+// file below it if it is a directory. Content that already has a proxy
+// of a kind, under any name, is not extracted again.
+func (c4fs *FS) ExtractProxies(ctx context.Context, name string) error {
+	if c4fs.proxies == nil {
+		return nil
+	}
+	entry, err := c4fs.resolveSymlink(name, 40)
+	if err != nil {
+		return err
+	}
+	if !entry.IsDir() {
+		if id, ok := contentID(entry); ok && entry.Mode.IsRegular() {
+			return c4fs.extract(ctx, name, id)
+		}
+		return nil
+	}
+
+	// Collect the files first; extraction reads the filesystem
+	type file struct {
+		name string
+		id   c4.ID
+	}
+	var files []file
+	var dirs []string
+	c4fs.mu.RLock()
+	for _, e := range c4fs.children(entry.Name) {
+		if e.IsDir() {
+			dirs = append(dirs, e.Name)
+		} else if id, ok := contentID(e); ok && e.Mode.IsRegular() {
+			files = append(files, file{e.Name, id})
+		}
+	}
+	c4fs.mu.RUnlock()
+
+	for _, f := range files {
+		if err := c4fs.extract(ctx, f.name, f.id); err != nil {
+			return err
+		}
+	}
+	for _, d := range dirs {
+		if err := c4fs.ExtractProxies(ctx, d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RunProxyExtraction makes the missing proxies of the filesystem's files,
+// then those of files as they are written, until ctx is done, and returns
+// ctx.Err(). Failures are passed to report, if not nil, as *fs.PathError
+// values, and extraction goes on with the next file. If changes are
+// written faster than proxies are made, the whole filesystem is scanned
+// again for files still lacking them.
+func (c4fs *FS) RunProxyExtraction(ctx context.Context, report func(error)) error {
+	events, cancel := c4fs.Subscribe(1024)
+	defer cancel()
+	if err := c4fs.ExtractProxies(ctx, ""); err != nil && ctx.Err() == nil && report != nil {
+		report(err)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case ev := <-events:
+			var err error
+			switch {
+			case ev.Op == ChangeOverflow:
+				err = c4fs.ExtractProxies(ctx, "")
+			case ev.Entry != nil && ev.Entry.Mode.IsRegular():
+				if id, ok := contentID(ev.Entry); ok {
+					err = c4fs.extract(ctx, ev.Path, id)
+				}
+			}
+			if err != nil && ctx.Err() == nil && report != nil {
+				report(err)
+			}
+		}
+	}
+}
+
+// extract makes the missing proxies of the content id, stored at name.
+func (c4fs *FS) extract(ctx context.Context, name string, id c4.ID) error {
+	kinds := make([]string, 0, len(c4fs.extractors))
+	for kind := range c4fs.extractors {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	for _, kind := range kinds {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if _, ok, err := c4fs.proxies.Proxy(id, kind); err != nil {
+			return &fs.PathError{Op: "proxy", Path: name, Err: err}
+		} else if ok {
+			continue
+		}
+		proxy, err := c4fs.extractOne(ctx, name, id, c4fs.extractors[kind])
+		if errors.Is(err, ErrNoProxy) {
+			continue
+		}
+		if err == nil {
+			err = c4fs.proxies.SetProxy(id, kind, proxy)
+		}
+		if err != nil {
+			return &fs.PathError{Op: "proxy", Path: name, Err: fmt.Errorf("%s: %w", kind, err)}
+		}
+	}
+	return nil
+}
+
+// extractOne runs ex on the content id and stores the proxy it writes.
+func (c4fs *FS) extractOne(ctx context.Context, name string, id c4.ID, ex Extractor) (c4.ID, error) {
+	rc, err := c4fs.store.Get(id)
+	if err != nil {
+		return c4.ID{}, err
+	}
+	defer rc.Close()
+
+	// Stream the proxy into the store as it is written
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := ex.Extract(ctx, name, rc, pw)
+		pw.CloseWithError(err)
+		done <- err
+	}()
+	proxy, err := c4fs.store.Put(pr)
+	pr.CloseWithError(err)
+	if xerr := <-done; xerr != nil {
+		return c4.ID{}, xerr
+	}
+	return proxy, err
+}
+
+// proxyDir is the directory of the registry recording proxies, as files
+// named kind/original holding the proxy's ID. Reference names cannot
+// start with a dot, so it never clashes with a reference.
+const proxyDir = ".proxies"
+
+// SetProxy records proxy as the proxy of the given kind of the content
+// id. Recorded proxies are kept from garbage collection by
+// CollectGarbage.
+func (r *Registry) SetProxy(id c4.ID, kind string, proxy c4.ID) error {
+	p, err := r.proxyPath(id, kind)
+	if err != nil {
+		return err
+	}
+	return writeRefFile(p, proxy.String())
+}
+
+// Proxy returns the proxy of the given kind recorded for the content id,
+// and whether there was one.
+func (r *Registry) Proxy(id c4.ID, kind string) (c4.ID, bool, error) {
+	p, err := r.proxyPath(id, kind)
+	if err != nil {
+		return c4.ID{}, false, err
+	}
+	data, err := os.ReadFile(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c4.ID{}, false, nil
+	}
+	if err != nil {
+		return c4.ID{}, false, err
+	}
+	proxy, err := c4.Parse(strings.TrimSpace(string(data)))
+	if err != nil {
+		return c4.ID{}, false, fmt.Errorf("corrupt proxy record %s/%s: %w", kind, id, err)
+	}
+	return proxy, true, nil
+}
+
+// proxies returns the IDs of all recorded proxies.
+func (r *Registry) proxies() ([]c4.ID, error) {
+	var ids []c4.ID
+	err := filepath.WalkDir(filepath.Join(r.dir, proxyDir), func(p string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return filepath.SkipAll
+		}
+		if err != nil || d.IsDir() || strings.HasPrefix(d.Name(), ".ref-") {
+			return err
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		if id, err := c4.Parse(strings.TrimSpace(string(data))); err == nil {
+			ids = append(ids, id)
+		}
+		return nil
+	})
+	return ids, err
+}
+
+// proxyPath validates a proxy kind and returns the file recording the
+// proxy of that kind of id.
+func (r *Registry) proxyPath(id c4.ID, kind string) (string, error) {
+	if kind == "" || strings.ContainsAny(kind, `/\`) || strings.HasPrefix(kind, ".") || path.Clean(kind) != kind {
+		return "", fmt.Errorf("invalid proxy kind %q", kind)
+	}
+	return filepath.Join(r.dir, proxyDir, kind, id.String()), nil
+}
@@ -0,0 +1,105 @@
+package c4fs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Avalanche-io/c4/store"
+)
+
+// upperExtractor makes proxies of .mov files by upper-casing them.
+var upperExtractor = ExtractorFunc(func(ctx context.Context, name string, content io.Reader, w io.Writer) error {
+	if !strings.HasSuffix(name, ".mov") {
+		return ErrNoProxy
+	}
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(bytes.ToUpper(data))
+	return err
+})
+
+func readProxy(t *testing.T, fsys *FS, name, kind string) (string, error) {
+	t.Helper()
+	rc, err := fsys.Proxy(name, kind)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	return string(data), err
+}
+
+func TestProxies(t *testing.T) {
+	s := NewStoreAdapter(store.NewRAM())
+	index, err := NewRegistry(s, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys := New(s, WithProxies(index, map[string]Extractor{"upper": upperExtractor}))
+	fsys.MkdirAll("shots/a", 0755)
+	fsys.WriteFile("shots/a/plate.mov", []byte("frames"), 0644)
+	fsys.WriteFile("shots/a/notes.txt", []byte("notes"), 0644)
+
+	if _, err := readProxy(t, fsys, "shots/a/plate.mov", "upper"); !errors.Is(err, ErrNoProxy) {
+		t.Fatalf("Proxy before extraction: %v, want ErrNoProxy", err)
+	}
+	if err := fsys.ExtractProxies(context.Background(), "shots"); err != nil {
+		t.Fatalf("ExtractProxies failed: %v", err)
+	}
+	if got, err := readProxy(t, fsys, "shots/a/plate.mov", "upper"); err != nil || got != "FRAMES" {
+		t.Errorf("Proxy = %q, %v; want FRAMES", got, err)
+	}
+	if _, err := readProxy(t, fsys, "shots/a/notes.txt", "upper"); !errors.Is(err, ErrNoProxy) {
+		t.Errorf("Proxy of an unhandled file: %v, want ErrNoProxy", err)
+	}
+	if _, err := readProxy(t, fsys, "shots/a/plate.mov", "thumbnail"); !errors.Is(err, ErrNoProxy) {
+		t.Errorf("Proxy of another kind: %v, want ErrNoProxy", err)
+	}
+
+	// Proxies follow the content
+	fsys.Rename("shots/a/plate.mov", "shots/a/final.mov")
+	if got, err := readProxy(t, fsys, "shots/a/final.mov", "upper"); err != nil || got != "FRAMES" {
+		t.Errorf("Proxy after rename = %q, %v", got, err)
+	}
+
+	// Files written while extraction runs get their proxies
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- fsys.RunProxyExtraction(ctx, func(err error) { t.Error(err) }) }()
+	fsys.WriteFile("shots/b.mov", []byte("more frames"), 0644)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got, err := readProxy(t, fsys, "shots/b.mov", "upper")
+		if err == nil && got == "MORE FRAMES" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Proxy not made while running: %q, %v", got, err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("RunProxyExtraction returned %v", err)
+	}
+
+	// Recorded proxies survive garbage collection
+	if _, err := index.CollectGarbage(context.Background(), GCOptions{Live: fsys.ReferencedIDs()}); err != nil {
+		t.Fatalf("CollectGarbage failed: %v", err)
+	}
+	if got, err := readProxy(t, fsys, "shots/a/final.mov", "upper"); err != nil || got != "FRAMES" {
+		t.Errorf("Proxy after garbage collection = %q, %v", got, err)
+	}
+
+	if err := index.SetProxy(SnapshotID(fsys.Flatten()), "../x", SnapshotID(fsys.Flatten())); err == nil {
+		t.Error("Expected an error for an invalid kind")
+	}
+}
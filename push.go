@@ -0,0 +1,323 @@
+package c4fs
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/c4m"
+)
+
+// pushMagic opens a push session. It is followed by a single protocol
+// version byte.
+var pushMagic = []byte("C4PS")
+
+// pushVersion is the current push protocol version.
+const pushVersion = 1
+
+// Push session reply status bytes.
+const (
+	pushStatusOK    = 0
+	pushStatusError = 1
+)
+
+// maxPushOffer bounds the number of blobs a client may offer in one
+// session.
+const maxPushOffer = 1 << 24
+
+// PushOptions configures Push.
+type PushOptions struct {
+	// Base is the snapshot the remote already has, typically the value of
+	// the reference being updated. Blobs it references are not offered.
+	// If Base is nil or not in the local registry, every blob of the
+	// snapshot is offered and the remote skips the ones it has.
+	Base c4.ID
+
+	// Ref, if set, is the reference the remote points at the snapshot
+	// once all of its blobs have arrived.
+	Ref string
+}
+
+// PushStats describes a completed push.
+type PushStats struct {
+	Offered int   // Blobs not referenced by the base
+	Sent    int   // Blobs the remote was missing
+	Bytes   int64 // Content bytes sent
+}
+
+// Push sends the snapshot with the given ID from reg to a remote registry
+// served by ServePush on conn, transferring only the blobs the remote does
+// not have.
+//
+// The diff against opts.Base is computed locally. The remote is then
+// asked which of the remaining blobs it is missing, and stores each blob
+// as soon as it arrives and verifies, so a push that is interrupted can
+// simply be run again: blobs received the first time are not requested.
+func Push(ctx context.Context, reg *Registry, snapshot c4.ID, conn io.ReadWriter, opts PushOptions) (PushStats, error) {
+	var stats PushStats
+
+	m, err := reg.Get(snapshot)
+	if err != nil {
+		return stats, err
+	}
+	want := manifestBlobIDs(m)
+	if !opts.Base.IsNil() {
+		if base, err := reg.Get(opts.Base); err == nil {
+			for id := range manifestBlobIDs(base) {
+				delete(want, id)
+			}
+		}
+	}
+	// The snapshot itself goes first so the remote can check completeness
+	offer := make([]c4.ID, 0, len(want)+1)
+	offer = append(offer, snapshot)
+	for id := range want {
+		if id != snapshot {
+			offer = append(offer, id)
+		}
+	}
+	stats.Offered = len(offer)
+
+	bw := bufio.NewWriter(conn)
+	br := bufio.NewReader(conn)
+
+	bw.Write(pushMagic)
+	bw.WriteByte(pushVersion)
+	bw.Write(snapshot[:])
+	writeString(bw, opts.Ref)
+	writeIDs(bw, offer)
+	if err := bw.Flush(); err != nil {
+		return stats, err
+	}
+
+	if err := readPushStatus(br); err != nil {
+		return stats, err
+	}
+	missing, err := readIDs(br)
+	if err != nil {
+		return stats, err
+	}
+
+	buf := make([]byte, archiveChunkSize)
+	for _, id := range missing {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+		if !want[id] && id != snapshot {
+			return stats, fmt.Errorf("push: remote requested unoffered blob %s", id)
+		}
+		n, err := backupBlob(bw, reg.store.store, id, buf)
+		if err != nil {
+			return stats, err
+		}
+		stats.Sent++
+		stats.Bytes += n
+	}
+	bw.WriteByte(archiveTagEnd)
+	if err := bw.Flush(); err != nil {
+		return stats, err
+	}
+
+	return stats, readPushStatus(br)
+}
+
+// ServePush receives one push session from conn into r. Each blob is
+// verified against its C4 ID before it is stored. The reference named by
+// the client, if any, is set only once the snapshot and every blob it
+// references are present.
+func (r *Registry) ServePush(ctx context.Context, conn io.ReadWriter) error {
+	br := bufio.NewReader(conn)
+	bw := bufio.NewWriter(conn)
+
+	snapshot, ref, offer, err := readPushRequest(br)
+	if err != nil {
+		return err
+	}
+	if ref != "" {
+		if _, err := r.refPath(ref); err != nil {
+			return writePushError(bw, err)
+		}
+	}
+
+	var missing []c4.ID
+	requested := make(map[c4.ID]bool)
+	for _, id := range offer {
+		if !requested[id] && !r.store.Has(id) {
+			missing = append(missing, id)
+			requested[id] = true
+		}
+	}
+	bw.WriteByte(pushStatusOK)
+	writeIDs(bw, missing)
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		tag, err := br.ReadByte()
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+		if tag == archiveTagEnd {
+			break
+		}
+		if tag != archiveTagBlob {
+			return fmt.Errorf("push: unknown record tag %d", tag)
+		}
+		var id c4.ID
+		if _, err := io.ReadFull(br, id[:]); err != nil {
+			return unexpectedEOF(err)
+		}
+		if !requested[id] {
+			return writePushError(bw, fmt.Errorf("push: unrequested blob %s", id))
+		}
+		if err := restoreBlob(br, r.store.store, id); err != nil {
+			return writePushError(bw, err)
+		}
+	}
+
+	if err := r.checkComplete(snapshot); err != nil {
+		return writePushError(bw, err)
+	}
+	if ref != "" {
+		if err := r.SetRef(ref, snapshot); err != nil {
+			return writePushError(bw, err)
+		}
+	}
+	bw.WriteByte(pushStatusOK)
+	return bw.Flush()
+}
+
+// checkComplete verifies that the snapshot and all of its blobs are in
+// the registry's store.
+func (r *Registry) checkComplete(snapshot c4.ID) error {
+	m, err := r.Get(snapshot)
+	if err != nil {
+		return err
+	}
+	for id := range manifestBlobIDs(m) {
+		if !r.store.Has(id) {
+			return fmt.Errorf("push: snapshot %s is missing blob %s", snapshot, id)
+		}
+	}
+	return nil
+}
+
+// manifestBlobIDs returns the content IDs referenced by the regular files
+// of m, and the ID of its metadata (see Snapshot).
+func manifestBlobIDs(m *c4m.Manifest) map[c4.ID]bool {
+	ids := make(map[c4.ID]bool)
+	if !m.Data.IsNil() {
+		ids[m.Data] = true
+	}
+	for _, e := range m.Entries {
+		if !e.IsDir() && e.Size > 0 && !e.C4ID.IsNil() {
+			ids[e.C4ID] = true
+		}
+	}
+	return ids
+}
+
+func readPushRequest(br *bufio.Reader) (snapshot c4.ID, ref string, offer []c4.ID, err error) {
+	head := make([]byte, len(pushMagic)+1)
+	if _, err = io.ReadFull(br, head); err != nil {
+		return snapshot, "", nil, unexpectedEOF(err)
+	}
+	if !bytes.Equal(head[:len(pushMagic)], pushMagic) {
+		return snapshot, "", nil, errors.New("push: not a push session")
+	}
+	if v := head[len(pushMagic)]; v != pushVersion {
+		return snapshot, "", nil, fmt.Errorf("push: unsupported protocol version %d", v)
+	}
+	if _, err = io.ReadFull(br, snapshot[:]); err != nil {
+		return snapshot, "", nil, unexpectedEOF(err)
+	}
+	if ref, err = readString(br); err != nil {
+		return snapshot, "", nil, err
+	}
+	offer, err = readIDs(br)
+	return snapshot, ref, offer, err
+}
+
+// readPushStatus reads a status byte, and the message of an error status.
+func readPushStatus(br *bufio.Reader) error {
+	status, err := br.ReadByte()
+	if err != nil {
+		return unexpectedEOF(err)
+	}
+	switch status {
+	case pushStatusOK:
+		return nil
+	case pushStatusError:
+		msg, err := readString(br)
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("push: remote: %s", msg)
+	}
+	return fmt.Errorf("push: unknown status %d", status)
+}
+
+// writePushError reports err to the client and returns it.
+func writePushError(bw *bufio.Writer, err error) error {
+	bw.WriteByte(pushStatusError)
+	writeString(bw, err.Error())
+	bw.Flush()
+	return err
+}
+
+func writeString(bw *bufio.Writer, s string) {
+	var hdr [binary.MaxVarintLen64]byte
+	bw.Write(hdr[:binary.PutUvarint(hdr[:], uint64(len(s)))])
+	bw.WriteString(s)
+}
+
+func readString(br *bufio.Reader) (string, error) {
+	n, err := binary.ReadUvarint(br)
+	if err != nil {
+		return "", unexpectedEOF(err)
+	}
+	if n > 4096 {
+		return "", fmt.Errorf("push: string length %d too large", n)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(br, b); err != nil {
+		return "", unexpectedEOF(err)
+	}
+	return string(b), nil
+}
+
+func writeIDs(bw *bufio.Writer, ids []c4.ID) {
+	var hdr [binary.MaxVarintLen64]byte
+	bw.Write(hdr[:binary.PutUvarint(hdr[:], uint64(len(ids)))])
+	for _, id := range ids {
+		bw.Write(id[:])
+	}
+}
+
+func readIDs(br *bufio.Reader) ([]c4.ID, error) {
+	n, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if n > maxPushOffer {
+		return nil, fmt.Errorf("push: %d IDs exceeds the limit", n)
+	}
+	var ids []c4.ID
+	for range n {
+		var id c4.ID
+		if _, err := io.ReadFull(br, id[:]); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
@@ -0,0 +1,127 @@
+package c4fs
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/Avalanche-io/c4"
+)
+
+// pushTo runs a push session from local to remote over an in-memory
+// connection and returns the errors of both sides.
+func pushTo(t *testing.T, local, remote *Registry, id c4.ID, opts PushOptions) (PushStats, error, error) {
+	t.Helper()
+	cli, srv := net.Pipe()
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- remote.ServePush(context.Background(), srv)
+		srv.Close()
+	}()
+	stats, err := Push(context.Background(), local, id, cli, opts)
+	cli.Close()
+	return stats, err, <-serveErr
+}
+
+func TestPush(t *testing.T) {
+	local, remote := newTestRegistry(t), newTestRegistry(t)
+
+	c4fs := New(local.Store())
+	c4fs.MkdirAll("assets", 0755)
+	large := bytes.Repeat([]byte("0123456789"), 20000)
+	c4fs.WriteFile("assets/large.bin", large, 0644)
+	c4fs.WriteFile("assets/copy.bin", large, 0644)
+	c4fs.WriteFile("readme.txt", []byte("v1"), 0644)
+	v1, err := local.Put(c4fs.Flatten())
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	stats, err, serveErr := pushTo(t, local, remote, v1, PushOptions{Ref: "main"})
+	if err != nil || serveErr != nil {
+		t.Fatalf("first push: client %v, server %v", err, serveErr)
+	}
+	// Snapshot, large.bin (stored once) and readme.txt
+	if stats.Offered != 3 || stats.Sent != 3 {
+		t.Errorf("first push stats: %+v", stats)
+	}
+	if id, err := remote.Ref("main"); err != nil || id != v1 {
+		t.Fatalf("remote ref: got %v, %v", id, err)
+	}
+	m, err := remote.Get(v1)
+	if err != nil {
+		t.Fatalf("remote Get failed: %v", err)
+	}
+	data, err := New(remote.Store(), WithBase(m)).ReadFile("assets/copy.bin")
+	if err != nil || !bytes.Equal(data, large) {
+		t.Fatalf("remote content: %d bytes, %v", len(data), err)
+	}
+
+	// A new version only offers what changed since the remote's snapshot
+	c4fs.WriteFile("readme.txt", []byte("v2"), 0644)
+	c4fs.WriteFile("notes.txt", []byte("notes"), 0644)
+	v2, _ := local.Put(c4fs.Flatten())
+	stats, err, serveErr = pushTo(t, local, remote, v2, PushOptions{Base: v1, Ref: "main"})
+	if err != nil || serveErr != nil {
+		t.Fatalf("delta push: client %v, server %v", err, serveErr)
+	}
+	if stats.Offered != 3 || stats.Sent != 3 || stats.Bytes > 1024 {
+		t.Errorf("delta push stats: %+v", stats)
+	}
+	if id, _ := remote.Ref("main"); id != v2 {
+		t.Errorf("remote ref not updated")
+	}
+
+	// Pushing again transfers nothing
+	stats, err, serveErr = pushTo(t, local, remote, v2, PushOptions{})
+	if err != nil || serveErr != nil || stats.Sent != 0 {
+		t.Errorf("repeat push: %+v, client %v, server %v", stats, err, serveErr)
+	}
+}
+
+// TestPushResume tests that blobs a remote already holds, for example
+// from an interrupted push, are not sent again.
+func TestPushResume(t *testing.T) {
+	local, remote := newTestRegistry(t), newTestRegistry(t)
+
+	c4fs := New(local.Store())
+	c4fs.WriteFile("a.txt", []byte("a"), 0644)
+	c4fs.WriteFile("b.txt", []byte("b"), 0644)
+	c4fs.WriteFile("c.txt", []byte("c"), 0644)
+	id, _ := local.Put(c4fs.Flatten())
+
+	// The first session delivered a.txt before the connection dropped
+	remote.Store().Put(strings.NewReader("a"))
+
+	stats, err, serveErr := pushTo(t, local, remote, id, PushOptions{})
+	if err != nil || serveErr != nil {
+		t.Fatalf("push: client %v, server %v", err, serveErr)
+	}
+	if stats.Offered != 4 || stats.Sent != 3 {
+		t.Errorf("resumed push stats: %+v", stats)
+	}
+}
+
+func TestPushErrors(t *testing.T) {
+	local, remote := newTestRegistry(t), newTestRegistry(t)
+	c4fs := New(local.Store())
+	c4fs.WriteFile("file.txt", []byte("content"), 0644)
+	id, _ := local.Put(c4fs.Flatten())
+
+	_, err, serveErr := pushTo(t, local, remote, id, PushOptions{Ref: "../escape"})
+	if err == nil || serveErr == nil || !strings.Contains(err.Error(), "remote") {
+		t.Errorf("invalid ref: client %v, server %v", err, serveErr)
+	}
+
+	// A snapshot whose content is missing locally cannot be completed
+	local.Store().Delete(c4.Identify(strings.NewReader("content")))
+	_, err, _ = pushTo(t, local, remote, id, PushOptions{Ref: "main"})
+	if err == nil {
+		t.Error("push of incomplete snapshot succeeded")
+	}
+	if _, err := remote.Ref("main"); err == nil {
+		t.Error("reference set for incomplete snapshot")
+	}
+}
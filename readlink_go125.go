@@ -0,0 +1,11 @@
+//go:build go1.25
+
+package c4fs
+
+import "io/fs"
+
+// fs.ReadLinkFS was added in Go 1.25.
+var (
+	_ fs.ReadLinkFS = (*FS)(nil)
+	_ fs.ReadLinkFS = (*subFS)(nil)
+)
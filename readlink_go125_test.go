@@ -0,0 +1,43 @@
+//go:build go1.25
+
+package c4fs
+
+import (
+	"io/fs"
+	"testing"
+
+	"github.com/Avalanche-io/c4/store"
+)
+
+func TestReadLinkFS(t *testing.T) {
+	c4fs := New(NewStoreAdapter(store.NewRAM()))
+	c4fs.Mkdir("dir", 0755)
+	c4fs.WriteFile("dir/target.txt", []byte("target"), 0644)
+	c4fs.Symlink("target.txt", "dir/link")
+
+	sub, err := c4fs.Sub("dir")
+	if err != nil {
+		t.Fatalf("Sub failed: %v", err)
+	}
+
+	for name, fsys := range map[string]fs.FS{"FS": c4fs, "Sub": sub} {
+		prefix := ""
+		if name == "FS" {
+			prefix = "dir/"
+		}
+
+		target, err := fs.ReadLink(fsys, prefix+"link")
+		if err != nil {
+			t.Errorf("%s: fs.ReadLink failed: %v", name, err)
+		} else if target != "target.txt" {
+			t.Errorf("%s: fs.ReadLink: got %q, want %q", name, target, "target.txt")
+		}
+
+		info, err := fs.Lstat(fsys, prefix+"link")
+		if err != nil {
+			t.Errorf("%s: fs.Lstat failed: %v", name, err)
+		} else if info.Mode()&fs.ModeSymlink == 0 {
+			t.Errorf("%s: fs.Lstat did not report a symlink: %v", name, info.Mode())
+		}
+	}
+}
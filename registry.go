@@ -0,0 +1,374 @@
+package c4fs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/rand/v2"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/c4m"
+)
+
+// ErrRefNotFound is returned when a registry reference does not exist.
+var ErrRefNotFound = errors.New("reference not found")
+
+// Registry stores snapshots in a content store and tracks named
+// references to them. Snapshots are stored in their canonical encoding
+// (see SnapshotID), so the blob ID of a stored snapshot is its SnapshotID.
+// References are small files under a local directory, one per name;
+// names may contain slashes to group them, e.g. "releases/v1".
+type Registry struct {
+	store *StoreAdapter
+	dir   string
+}
+
+// NewRegistry returns a registry storing snapshots in store and
+// references under dir, which is created if needed.
+func NewRegistry(store *StoreAdapter, dir string) (*Registry, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create registry directory: %w", err)
+	}
+	return &Registry{store: store, dir: dir}, nil
+}
+
+// Store returns the content store holding the registry's snapshots.
+func (r *Registry) Store() *StoreAdapter {
+	return r.store
+}
+
+// Put stores m and returns its SnapshotID. The metadata stored with m by
+// Snapshot is referenced, not copied: it is in the registry's store when m
+// was taken from a filesystem using that store.
+func (r *Registry) Put(m *c4m.Manifest) (c4.ID, error) {
+	var buf bytes.Buffer
+	if err := writeCanonical(&buf, Canonicalize(m)); err != nil {
+		return c4.ID{}, err
+	}
+	return r.store.Put(&buf)
+}
+
+// Get loads the snapshot with the given ID.
+func (r *Registry) Get(id c4.ID) (*c4m.Manifest, error) {
+	rc, err := r.store.Get(id)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot %s: %w", id, err)
+	}
+	defer rc.Close()
+	return LoadSnapshot(rc)
+}
+
+// SetRef points the named reference at id, replacing any previous value.
+func (r *Registry) SetRef(name string, id c4.ID) error {
+	p, err := r.refPath(name)
+	if err != nil {
+		return err
+	}
+	unlock, err := lockRef(p)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return writeRef(p, id)
+}
+
+// CompareAndSwapRef points the named reference at id only if it currently
+// points at old. A nil old requires the reference to be unset. If the
+// reference has moved, nothing is changed and a *RefConflictError is
+// returned.
+//
+// Registries shared by several processes use this to publish snapshots
+// without overwriting each other: read the reference, build on that
+// snapshot, then swap, and on conflict rebase onto the new value.
+func (r *Registry) CompareAndSwapRef(name string, old, id c4.ID) error {
+	p, err := r.refPath(name)
+	if err != nil {
+		return err
+	}
+	unlock, err := lockRef(p)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	current, err := r.Ref(name)
+	if err != nil && !errors.Is(err, ErrRefNotFound) {
+		return err
+	}
+	if current != old {
+		return &RefConflictError{Name: name, Expected: old, Actual: current}
+	}
+	return writeRef(p, id)
+}
+
+// Publish stores m and points the named reference at it, provided the
+// reference still points at parent, the snapshot m was derived from. Use a
+// nil parent to create the reference. On conflict the snapshot is stored
+// but the reference is unchanged, and a *RefConflictError is returned.
+func (r *Registry) Publish(name string, parent c4.ID, m *c4m.Manifest) (c4.ID, error) {
+	id, err := r.Put(m)
+	if err != nil {
+		return c4.ID{}, err
+	}
+	return id, r.CompareAndSwapRef(name, parent, id)
+}
+
+// RefConflictError is returned when a reference does not point at the
+// snapshot a compare-and-swap expected. Actual is nil if the reference is
+// not set.
+type RefConflictError struct {
+	Name     string
+	Expected c4.ID
+	Actual   c4.ID
+}
+
+func (e *RefConflictError) Error() string {
+	return fmt.Sprintf("reference %s moved: expected %s, found %s", e.Name, refString(e.Expected), refString(e.Actual))
+}
+
+func refString(id c4.ID) string {
+	if id.IsNil() {
+		return "unset"
+	}
+	return id.String()
+}
+
+// writeRef atomically writes id to the reference file p.
+func writeRef(p string, id c4.ID) error {
+	return writeRefFile(p, id.String())
+}
+
+// writeRefFile atomically replaces the reference file p with a line
+// holding value.
+func writeRefFile(p, value string) error {
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+
+	// Write to a temporary file and rename, so readers never see a
+	// partially written reference
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".ref-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.WriteString(value + "\n"); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+// Reference lock timing. Locks are held only while a reference file is
+// read and replaced, so a lock older than refLockStale was left behind by
+// a process that died and is broken.
+const (
+	refLockTimeout = 10 * time.Second
+	refLockStale   = 30 * time.Second
+	refLockPoll    = 5 * time.Millisecond
+)
+
+// lockRef takes the update lock of the reference file p, which serializes
+// updates across processes sharing the registry directory. The lock is a
+// file created exclusively next to the reference; its ".ref-" prefix keeps
+// it out of Refs. A lock older than refLockStale, left by a process that
+// died, is broken.
+func lockRef(p string) (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return nil, err
+	}
+	lock := filepath.Join(filepath.Dir(p), ".ref-"+filepath.Base(p)+".lock")
+	deadline := time.Now().Add(refLockTimeout)
+	for {
+		f, err := os.OpenFile(lock, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			info, err := f.Stat()
+			f.Close()
+			if err != nil {
+				os.Remove(lock)
+				return nil, err
+			}
+			return func() { unlockRef(lock, info) }, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, err
+		}
+		if info, err := os.Stat(lock); err == nil && time.Since(info.ModTime()) > refLockStale {
+			breakRefLock(lock, info)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for reference lock %s", lock)
+		}
+		time.Sleep(refLockPoll)
+	}
+}
+
+// breakRefLock removes the stale lock file described by stale. Several
+// waiters may find the same lock stale, and by the time one acts another
+// may have broken it and taken a new lock, so the lock is first moved to
+// a name of its own, and only removed if it is the stale one; a newer
+// lock moved by mistake is put back.
+func breakRefLock(lock string, stale fs.FileInfo) {
+	moved := fmt.Sprintf("%s.%d.%d", lock, os.Getpid(), rand.Int64())
+	if err := os.Rename(lock, moved); err != nil {
+		return // Broken by another waiter
+	}
+	// Inodes are reused, so compare modification times too
+	info, err := os.Stat(moved)
+	if err == nil && os.SameFile(info, stale) && info.ModTime().Equal(stale.ModTime()) {
+		os.Remove(moved)
+		return
+	}
+	// Link rather than rename, so a lock taken meanwhile is not replaced
+	os.Link(moved, lock)
+	os.Remove(moved)
+}
+
+// unlockRef releases the lock file taken as info, unless it was broken
+// as stale and is now another's.
+func unlockRef(lock string, info fs.FileInfo) {
+	current, err := os.Stat(lock)
+	if err == nil && os.SameFile(current, info) && current.ModTime().Equal(info.ModTime()) {
+		os.Remove(lock)
+	}
+}
+
+// Ref returns the ID the named reference points at.
+// It returns an error wrapping ErrRefNotFound if the reference is not set.
+func (r *Registry) Ref(name string) (c4.ID, error) {
+	p, err := r.refPath(name)
+	if err != nil {
+		return c4.ID{}, err
+	}
+	data, err := os.ReadFile(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c4.ID{}, fmt.Errorf("%s: %w", name, ErrRefNotFound)
+	}
+	if err != nil {
+		return c4.ID{}, err
+	}
+	id, err := c4.Parse(strings.TrimSpace(string(data)))
+	if err != nil {
+		return c4.ID{}, fmt.Errorf("corrupt reference %s: %w", name, err)
+	}
+	return id, nil
+}
+
+// DeleteRef removes the named reference. Deleting a reference that is
+// not set is not an error. The snapshot itself stays in the store.
+func (r *Registry) DeleteRef(name string) error {
+	p, err := r.refPath(name)
+	if err != nil {
+		return err
+	}
+	unlock, err := lockRef(p)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Refs returns all references and the IDs they point at.
+func (r *Registry) Refs() (map[string]c4.ID, error) {
+	refs := make(map[string]c4.ID)
+	err := filepath.WalkDir(r.dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && p != r.dir && strings.HasPrefix(d.Name(), ".") {
+			// Not a reference group; pins live here
+			return filepath.SkipDir
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".ref-") {
+			return nil
+		}
+		rel, err := filepath.Rel(r.dir, p)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		id, err := r.Ref(name)
+		if err != nil {
+			return err
+		}
+		refs[name] = id
+		return nil
+	})
+	return refs, err
+}
+
+// pinDir is the directory of the registry holding pins, one empty file
+// per pinned blob named by its ID. No element of a reference name can
+// start with a dot, so it never clashes with a reference.
+const pinDir = ".pins"
+
+// Pin keeps the blob id from garbage collection by CollectGarbage until
+// it is unpinned, for content that is in use but referenced by no
+// snapshot yet, such as an upload being assembled. Only the blob itself
+// is kept; snapshots are kept, with their content, by references.
+func (r *Registry) Pin(id c4.ID) error {
+	dir := filepath.Join(r.dir, pinDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, id.String()), nil, 0644)
+}
+
+// Unpin removes the pin of id. Unpinning a blob that is not pinned is not
+// an error.
+func (r *Registry) Unpin(id c4.ID) error {
+	err := os.Remove(filepath.Join(r.dir, pinDir, id.String()))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Pins returns the pinned blobs.
+func (r *Registry) Pins() ([]c4.ID, error) {
+	entries, err := os.ReadDir(filepath.Join(r.dir, pinDir))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ids []c4.ID
+	for _, e := range entries {
+		if id, err := c4.Parse(e.Name()); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// refPath validates a reference name and returns its file path.
+func (r *Registry) refPath(name string) (string, error) {
+	// No element may start with a dot: Refs skips such directories, so a
+	// reference inside one would not protect its snapshot from collection
+	clean := path.Clean(name)
+	if name == "" || clean != name || path.IsAbs(name) {
+		return "", fmt.Errorf("invalid reference name %q", name)
+	}
+	for _, elem := range strings.Split(clean, "/") {
+		if strings.HasPrefix(elem, ".") {
+			return "", fmt.Errorf("invalid reference name %q", name)
+		}
+	}
+	return filepath.Join(r.dir, filepath.FromSlash(clean)), nil
+}
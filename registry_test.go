@@ -0,0 +1,226 @@
+package c4fs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/store"
+)
+
+func newTestRegistry(t *testing.T) *Registry {
+	t.Helper()
+	r, err := NewRegistry(NewStoreAdapter(store.NewRAM()), t.TempDir())
+	if err != nil {
+		t.Fatalf("NewRegistry failed: %v", err)
+	}
+	return r
+}
+
+func TestRegistryPutGet(t *testing.T) {
+	r := newTestRegistry(t)
+	c4fs := New(r.Store())
+	c4fs.MkdirAll("dir", 0755)
+	c4fs.WriteFile("dir/file.txt", []byte("content"), 0644)
+	m := c4fs.Flatten()
+
+	id, err := r.Put(m)
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if id != SnapshotID(m) {
+		t.Errorf("Put returned %s, want SnapshotID %s", id, SnapshotID(m))
+	}
+
+	got, err := r.Get(id)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if SnapshotID(got) != id {
+		t.Error("Loaded snapshot does not match the stored one")
+	}
+}
+
+func TestRegistryRefs(t *testing.T) {
+	r := newTestRegistry(t)
+	id, err := r.Put(New(r.Store()).Flatten())
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	if _, err := r.Ref("main"); !errors.Is(err, ErrRefNotFound) {
+		t.Errorf("Expected ErrRefNotFound, got %v", err)
+	}
+	if err := r.SetRef("main", id); err != nil {
+		t.Fatalf("SetRef failed: %v", err)
+	}
+	if err := r.SetRef("releases/v1", id); err != nil {
+		t.Fatalf("SetRef failed: %v", err)
+	}
+	if got, err := r.Ref("releases/v1"); err != nil || got != id {
+		t.Errorf("Ref: got %s, %v", got, err)
+	}
+
+	refs, err := r.Refs()
+	if err != nil {
+		t.Fatalf("Refs failed: %v", err)
+	}
+	if len(refs) != 2 || refs["main"] != id || refs["releases/v1"] != id {
+		t.Errorf("Refs: got %v", refs)
+	}
+
+	if err := r.DeleteRef("main"); err != nil {
+		t.Fatalf("DeleteRef failed: %v", err)
+	}
+	if _, err := r.Ref("main"); !errors.Is(err, ErrRefNotFound) {
+		t.Errorf("Ref after delete: got %v", err)
+	}
+
+	for _, name := range []string{"", "/abs", "../escape", "a/../b", ".hidden", "a//b", "team/.wip/snap"} {
+		if err := r.SetRef(name, id); err == nil {
+			t.Errorf("SetRef(%q) should fail", name)
+		}
+	}
+}
+
+func TestRegistryCompareAndSwapRef(t *testing.T) {
+	r := newTestRegistry(t)
+	c4fs := New(r.Store())
+	v1, _ := r.Put(c4fs.Flatten())
+	c4fs.WriteFile("file.txt", []byte("v2"), 0644)
+	v2, _ := r.Put(c4fs.Flatten())
+
+	// A nil old value creates the reference, but only once
+	if err := r.CompareAndSwapRef("main", c4.ID{}, v1); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	var conflict *RefConflictError
+	err := r.CompareAndSwapRef("main", c4.ID{}, v2)
+	if !errors.As(err, &conflict) || conflict.Actual != v1 || !conflict.Expected.IsNil() {
+		t.Fatalf("second create: got %v", err)
+	}
+
+	if err := r.CompareAndSwapRef("main", v1, v2); err != nil {
+		t.Fatalf("swap: %v", err)
+	}
+	if err := r.CompareAndSwapRef("main", v1, v1); !errors.As(err, &conflict) {
+		t.Fatalf("stale swap: got %v", err)
+	}
+	if got, _ := r.Ref("main"); got != v2 {
+		t.Errorf("reference changed by a failed swap")
+	}
+
+	refs, _ := r.Refs()
+	if len(refs) != 1 {
+		t.Errorf("lock files leaked into Refs: %v", refs)
+	}
+}
+
+// TestRegistryPublishConcurrent tests that committers sharing a registry
+// directory never lose each other's snapshots.
+func TestRegistryPublishConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	adapter := NewStoreAdapter(newSyncRAM())
+	base, err := NewRegistry(adapter, dir)
+	if err != nil {
+		t.Fatalf("NewRegistry failed: %v", err)
+	}
+	root, _ := base.Publish("main", c4.ID{}, New(adapter).Flatten())
+
+	const committers = 8
+	var wg sync.WaitGroup
+	for i := range committers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Each committer has its own Registry, as separate processes would
+			reg, _ := NewRegistry(adapter, dir)
+			for {
+				parent, err := reg.Ref("main")
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				m, _ := reg.Get(parent)
+				c4fs := New(adapter, WithBase(m))
+				c4fs.WriteFile(fmt.Sprintf("commit-%d", i), []byte("x"), 0644)
+				_, err = reg.Publish("main", parent, c4fs.Flatten())
+				var conflict *RefConflictError
+				if errors.As(err, &conflict) {
+					continue // rebase onto the new head
+				}
+				if err != nil {
+					t.Error(err)
+				}
+				return
+			}
+		}()
+	}
+	wg.Wait()
+
+	head, _ := base.Ref("main")
+	if head == root {
+		t.Fatal("no commit was published")
+	}
+	m, err := base.Get(head)
+	if err != nil {
+		t.Fatalf("Get head failed: %v", err)
+	}
+	if len(m.Entries) != committers {
+		t.Errorf("head has %d entries, want %d: a commit was overwritten", len(m.Entries), committers)
+	}
+}
+
+func TestRegistryStaleLock(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "main")
+	lock := filepath.Join(dir, ".ref-main.lock")
+	if err := os.WriteFile(lock, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * refLockStale)
+	os.Chtimes(lock, old, old)
+	stale, err := os.Stat(lock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Another waiter breaks the stale lock and takes a new one
+	unlock, err := lockRef(p)
+	if err != nil {
+		t.Fatalf("lockRef over a stale lock failed: %v", err)
+	}
+
+	// A waiter that found the old lock stale acts on it only now
+	breakRefLock(lock, stale)
+	if _, err := os.Stat(lock); err != nil {
+		t.Fatalf("new lock was broken as stale: %v", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		unlock, err := lockRef(p)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		unlock()
+	}()
+	select {
+	case <-done:
+		t.Fatal("lock taken while held")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	unlock()
+	<-done
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 0 {
+		t.Errorf("lock files left behind: %v", entries)
+	}
+}
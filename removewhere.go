@@ -0,0 +1,96 @@
+package c4fs
+
+import (
+	"errors"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/c4m"
+)
+
+// RemoveWhere removes every file, directory and link for which match
+// returns true, under a single lock acquisition instead of one per path,
+// and returns the number of paths removed. A directory matched is removed
+// with all its contents, as by RemoveAll; its contents are not matched
+// separately. The root, files made by CreateTemp and the trash are
+// never removed. For example, to remove the files older than a cutoff:
+//
+//	fsys.RemoveWhere(func(e *c4m.Entry) bool {
+//		return !e.IsDir() && e.Timestamp.Before(cutoff)
+//	})
+//
+// match is called with the filesystem locked, so it must not use the
+// filesystem; the entries must not be modified. Removals are moved to the
+// trash if it is enabled, and each is audited as a RemoveAll. On error,
+// the paths removed so far stay removed.
+func (c4fs *FS) RemoveWhere(match func(e *c4m.Entry) bool) (int, error) {
+	type removal struct {
+		name string
+		ids  []c4.ID
+	}
+	var removed []removal
+	var err error
+
+	c4fs.mu.Lock()
+	var names []string
+	for _, e := range c4fs.base.Entries {
+		if _, shadowed := c4fs.layerIndex[e.Name]; !shadowed && c4fs.removable(e) && match(e) {
+			names = append(names, e.Name)
+		}
+	}
+	for _, e := range c4fs.layer.Entries {
+		if e.Size != -1 && c4fs.removable(e) && match(e) {
+			names = append(names, e.Name)
+		}
+	}
+	// Parents sort before their contents, which go with them
+	sort.Strings(names)
+	for _, name := range names {
+		if _, lerr := c4fs.lookup(name); lerr != nil {
+			continue
+		}
+		var ids []c4.ID
+		if c4fs.trashing(name) {
+			ids, err = c4fs.trashLocked(name, true)
+		} else {
+			_, err = c4fs.removeAll(name, &ids)
+		}
+		if err != nil {
+			break
+		}
+		removed = append(removed, removal{name, ids})
+	}
+	c4fs.mu.Unlock()
+
+	errs := []error{err}
+	for _, r := range removed {
+		errs = append(errs, c4fs.audit(AuditRemoveAll, r.name, r.ids))
+	}
+	if len(removed) > 0 {
+		errs = append(errs, c4fs.expireTrash())
+	}
+	return len(removed), errors.Join(errs...)
+}
+
+// RemoveGlob removes every path matching pattern, in the syntax of
+// path.Match applied to whole slash-separated paths relative to the root,
+// such as "renders/*/*.tmp". It is RemoveWhere with a pattern; see there.
+// The only possible pattern error is path.ErrBadPattern.
+func (c4fs *FS) RemoveGlob(pattern string) (int, error) {
+	pattern = strings.TrimPrefix(cleanPath(pattern), "/")
+	if _, err := path.Match(pattern, ""); err != nil {
+		return 0, err
+	}
+	return c4fs.RemoveWhere(func(e *c4m.Entry) bool {
+		ok, _ := path.Match(pattern, e.Name)
+		return ok
+	})
+}
+
+// removable reports whether e may be removed by RemoveWhere. The caller
+// must hold c4fs.mu.
+func (c4fs *FS) removable(e *c4m.Entry) bool {
+	return e.Name != "" && !c4fs.isTemp(e.Name) && !isTrashPath(e.Name)
+}
@@ -0,0 +1,73 @@
+package c4fs
+
+import (
+	"errors"
+	"io/fs"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/Avalanche-io/c4/c4m"
+	"github.com/Avalanche-io/c4/store"
+)
+
+func TestRemoveGlob(t *testing.T) {
+	base := c4m.NewManifest()
+	base.AddEntry(&c4m.Entry{Name: "renders", Mode: fs.ModeDir | 0755})
+	base.AddEntry(&c4m.Entry{Name: "renders/a.tmp", Mode: 0644})
+	base.AddEntry(&c4m.Entry{Name: "renders/a.exr", Mode: 0644})
+	c4fs := New(NewStoreAdapter(store.NewRAM()), WithBase(base))
+	c4fs.MkdirAll("renders/b", 0755)
+	c4fs.WriteFile("renders/b.tmp", []byte("x"), 0644)
+	c4fs.WriteFile("renders/b/c.tmp", []byte("x"), 0644)
+
+	n, err := c4fs.RemoveGlob("renders/*.tmp")
+	if err != nil || n != 2 {
+		t.Fatalf("RemoveGlob = %d, %v; want 2", n, err)
+	}
+	for name, want := range map[string]bool{"renders/a.tmp": false, "renders/b.tmp": false, "renders/a.exr": true, "renders/b/c.tmp": true} {
+		if _, err := c4fs.Stat(name); (err == nil) != want {
+			t.Errorf("Stat(%s) = %v, want exists %v", name, err, want)
+		}
+	}
+
+	// A matched directory goes with its contents
+	if n, err := c4fs.RemoveGlob("/renders/b*"); err != nil || n != 1 {
+		t.Errorf("RemoveGlob of a directory = %d, %v; want 1", n, err)
+	}
+	if _, err := c4fs.Stat("renders/b/c.tmp"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Contents of a removed directory: %v", err)
+	}
+
+	if _, err := c4fs.RemoveGlob("["); !errors.Is(err, path.ErrBadPattern) {
+		t.Errorf("RemoveGlob of a bad pattern = %v", err)
+	}
+}
+
+func TestRemoveWhere(t *testing.T) {
+	var records []AuditRecord
+	c4fs := New(NewStoreAdapter(store.NewRAM()), WithAudit(AuditFunc(func(r AuditRecord) error {
+		records = append(records, r)
+		return nil
+	}), "tester"))
+	cutoff := time.Now().Add(-time.Hour)
+	c4fs.MkdirAll("logs", 0755)
+	for _, name := range []string{"logs/old1", "logs/old2", "logs/new"} {
+		c4fs.WriteFile(name, []byte(name), 0644)
+	}
+	c4fs.Chtimes("logs/old1", cutoff.Add(-time.Hour), cutoff.Add(-time.Hour))
+	c4fs.Chtimes("logs/old2", cutoff.Add(-time.Minute), cutoff.Add(-time.Minute))
+
+	n, err := c4fs.RemoveWhere(func(e *c4m.Entry) bool {
+		return !e.IsDir() && e.Timestamp.Before(cutoff)
+	})
+	if err != nil || n != 2 {
+		t.Fatalf("RemoveWhere = %d, %v; want 2", n, err)
+	}
+	if _, err := c4fs.Stat("logs/new"); err != nil {
+		t.Errorf("Newer file removed: %v", err)
+	}
+	if len(records) != 2 || records[0].Op != AuditRemoveAll || records[0].Path != "logs/old1" || len(records[0].IDs) != 1 {
+		t.Errorf("Audit records = %+v", records)
+	}
+}
@@ -0,0 +1,395 @@
+package c4fs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/store"
+)
+
+// ReplicationOptions configures a ReplicatedStore.
+type ReplicationOptions struct {
+	// QueueDir, if set, makes replication asynchronous: blobs are written
+	// to the first backend, and copied to the others in the background.
+	// Pending copies are recorded as files in QueueDir, so they survive a
+	// restart and are resumed by the next ReplicatedStore using the
+	// directory. If empty, every write goes to all backends before Close
+	// returns.
+	QueueDir string
+
+	// RetryInterval is how long a failed background copy waits before it
+	// is retried. Zero means one minute.
+	RetryInterval time.Duration
+}
+
+// ReplicatedStore writes each blob to several backends, for redundancy
+// without an external replication job, and reads from whichever backend
+// has been responding fastest.
+//
+// Close must be called to stop background replication.
+type ReplicatedStore struct {
+	backends []store.Store
+	latency  []atomic.Int64 // Smoothed Open latency in nanoseconds; 0 if unmeasured
+
+	queueDir string
+	retry    time.Duration
+	wake     chan struct{}
+	stop     chan struct{}
+	done     chan struct{}
+	idle     sync.Cond // broadcast when the queue is drained
+	pending  int       // queued copies, guarded by idle.L
+}
+
+var (
+	_ store.Store   = (*ReplicatedStore)(nil)
+	_ BlobLister    = (*ReplicatedStore)(nil)
+	_ BlobStater    = (*ReplicatedStore)(nil)
+	_ BlobChecker   = (*ReplicatedStore)(nil)
+	_ HealthChecker = (*ReplicatedStore)(nil)
+)
+
+// NewReplicatedStore returns a store replicating to all of backends. The
+// first backend is the primary: with asynchronous replication it receives
+// writes directly, and it is the one listed by ListIDs.
+func NewReplicatedStore(backends []store.Store, opts ReplicationOptions) (*ReplicatedStore, error) {
+	if len(backends) == 0 {
+		return nil, errors.New("replicated store needs at least one backend")
+	}
+	r := &ReplicatedStore{
+		backends: backends,
+		latency:  make([]atomic.Int64, len(backends)),
+		queueDir: opts.QueueDir,
+		retry:    opts.RetryInterval,
+		idle:     sync.Cond{L: new(sync.Mutex)},
+	}
+	if r.retry <= 0 {
+		r.retry = time.Minute
+	}
+	if r.queueDir == "" {
+		return r, nil
+	}
+
+	if err := os.MkdirAll(r.queueDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create replication queue: %w", err)
+	}
+	queued, err := os.ReadDir(r.queueDir)
+	if err != nil {
+		return nil, err
+	}
+	r.pending = len(queued)
+	r.wake = make(chan struct{}, 1)
+	r.stop = make(chan struct{})
+	r.done = make(chan struct{})
+	go r.replicate()
+	r.signal()
+	return r, nil
+}
+
+// Open opens the blob from the backend with the lowest observed latency
+// that has it.
+func (r *ReplicatedStore) Open(id c4.ID) (io.ReadCloser, error) {
+	var first error
+	for _, i := range r.byLatency() {
+		start := time.Now()
+		rc, err := r.backends[i].Open(id)
+		if err == nil {
+			r.observe(i, time.Since(start))
+			return rc, nil
+		}
+		if first == nil {
+			first = err
+		}
+	}
+	return nil, first
+}
+
+// byLatency returns backend indexes, fastest first. Unmeasured backends
+// come first so that each is tried.
+func (r *ReplicatedStore) byLatency() []int {
+	order := make([]int, len(r.backends))
+	lat := make([]int64, len(r.backends))
+	for i := range order {
+		order[i] = i
+		lat[i] = r.latency[i].Load()
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return lat[order[a]] < lat[order[b]]
+	})
+	return order
+}
+
+// observe folds a latency sample into backend i's moving average.
+func (r *ReplicatedStore) observe(i int, d time.Duration) {
+	sample := max(int64(d), 1)
+	old := r.latency[i].Load()
+	if old == 0 {
+		r.latency[i].Store(sample)
+		return
+	}
+	r.latency[i].Store(old - old/8 + sample/8)
+}
+
+// Create returns a writer for the blob. With synchronous replication the
+// content is streamed to every backend and Close fails if any of them
+// fails; otherwise it is written to the primary and queued for the
+// others when Close succeeds.
+func (r *ReplicatedStore) Create(id c4.ID) (io.WriteCloser, error) {
+	if r.queueDir != "" {
+		wc, err := r.backends[0].Create(id)
+		if err != nil {
+			return nil, err
+		}
+		return &queuedWriter{WriteCloser: wc, store: r, id: id}, nil
+	}
+
+	writers := make([]io.WriteCloser, 0, len(r.backends))
+	for _, b := range r.backends {
+		wc, err := b.Create(id)
+		if err != nil {
+			for _, w := range writers {
+				w.Close()
+			}
+			return nil, err
+		}
+		writers = append(writers, wc)
+	}
+	return &fanoutWriter{writers: writers}, nil
+}
+
+// Remove removes the blob from every backend and drops any pending copy.
+func (r *ReplicatedStore) Remove(id c4.ID) error {
+	if r.queueDir != "" {
+		if err := os.Remove(filepath.Join(r.queueDir, id.String())); err == nil {
+			r.dequeued()
+		}
+	}
+	var errs []error
+	for _, b := range r.backends {
+		errs = append(errs, b.Remove(id))
+	}
+	return errors.Join(errs...)
+}
+
+// StatBlob describes the blob in the fastest backend that has it.
+func (r *ReplicatedStore) StatBlob(id c4.ID) (BlobInfo, error) {
+	var first error
+	for _, i := range r.byLatency() {
+		info, err := NewStoreAdapter(r.backends[i]).Stat(id)
+		if err == nil {
+			return info, nil
+		}
+		if first == nil {
+			first = err
+		}
+	}
+	return BlobInfo{}, first
+}
+
+// HasBlob reports whether any backend has the blob, and so whether Open
+// can read it.
+func (r *ReplicatedStore) HasBlob(id c4.ID) bool {
+	for _, i := range r.byLatency() {
+		if NewStoreAdapter(r.backends[i]).Has(id) {
+			return true
+		}
+	}
+	return false
+}
+
+// ListIDs lists the primary backend, which holds every blob.
+func (r *ReplicatedStore) ListIDs() ([]c4.ID, error) {
+	return ListIDs(r.backends[0])
+}
+
+// Ping checks every backend.
+func (r *ReplicatedStore) Ping(ctx context.Context) error {
+	var errs []error
+	for _, b := range r.backends {
+		errs = append(errs, NewStoreAdapter(b).Ping(ctx))
+	}
+	return errors.Join(errs...)
+}
+
+// Pending returns the number of blobs waiting to be replicated.
+func (r *ReplicatedStore) Pending() int {
+	r.idle.L.Lock()
+	defer r.idle.L.Unlock()
+	return r.pending
+}
+
+// Drain waits until no blobs are waiting to be replicated or ctx is done.
+func (r *ReplicatedStore) Drain(ctx context.Context) error {
+	stop := context.AfterFunc(ctx, func() {
+		r.idle.L.Lock()
+		r.idle.Broadcast()
+		r.idle.L.Unlock()
+	})
+	defer stop()
+
+	r.idle.L.Lock()
+	defer r.idle.L.Unlock()
+	for r.pending > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		r.idle.Wait()
+	}
+	return nil
+}
+
+// Close stops background replication. Pending copies stay queued for the
+// next ReplicatedStore using the same queue directory.
+func (r *ReplicatedStore) Close() error {
+	if r.stop != nil {
+		close(r.stop)
+		<-r.done
+		r.stop = nil
+	}
+	return nil
+}
+
+// enqueue records that id must be copied to the secondary backends.
+func (r *ReplicatedStore) enqueue(id c4.ID) error {
+	// Count first, so the worker cannot finish the copy before it is counted
+	r.idle.L.Lock()
+	r.pending++
+	r.idle.L.Unlock()
+
+	f, err := os.OpenFile(filepath.Join(r.queueDir, id.String()), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		r.dequeued()
+		if errors.Is(err, fs.ErrExist) {
+			return nil // already queued
+		}
+		return fmt.Errorf("failed to queue %s for replication: %w", id, err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	r.signal()
+	return nil
+}
+
+// dequeued records that a queued copy finished.
+func (r *ReplicatedStore) dequeued() {
+	r.idle.L.Lock()
+	r.pending--
+	if r.pending <= 0 {
+		r.pending = 0
+		r.idle.Broadcast()
+	}
+	r.idle.L.Unlock()
+}
+
+func (r *ReplicatedStore) signal() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// replicate copies queued blobs until Close is called, retrying failed
+// copies every retry interval.
+func (r *ReplicatedStore) replicate() {
+	defer close(r.done)
+	var retry <-chan time.Time
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-r.wake:
+		case <-retry:
+		}
+		retry = nil
+		if !r.replicateQueued() {
+			retry = time.After(r.retry)
+		}
+	}
+}
+
+// replicateQueued makes one pass over the queue and reports whether every
+// queued blob was copied.
+func (r *ReplicatedStore) replicateQueued() bool {
+	queued, err := os.ReadDir(r.queueDir)
+	if err != nil {
+		return false
+	}
+	ok := true
+	for _, q := range queued {
+		select {
+		case <-r.stop:
+			return true
+		default:
+		}
+		id, err := c4.Parse(q.Name())
+		if err != nil {
+			continue
+		}
+		if err := r.copyToSecondaries(id); err != nil {
+			ok = false
+			continue
+		}
+		if os.Remove(filepath.Join(r.queueDir, q.Name())) == nil {
+			r.dequeued()
+		}
+	}
+	return ok
+}
+
+func (r *ReplicatedStore) copyToSecondaries(id c4.ID) error {
+	for _, b := range r.backends[1:] {
+		if NewStoreAdapter(b).Has(id) {
+			continue
+		}
+		if _, err := migrateBlob(r.backends[0], b, id, true, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queuedWriter queues its blob for replication once it is written.
+type queuedWriter struct {
+	io.WriteCloser
+	store *ReplicatedStore
+	id    c4.ID
+}
+
+func (w *queuedWriter) Close() error {
+	if err := w.WriteCloser.Close(); err != nil {
+		return err
+	}
+	return w.store.enqueue(w.id)
+}
+
+// fanoutWriter writes to several backends at once.
+type fanoutWriter struct {
+	writers []io.WriteCloser
+}
+
+func (w *fanoutWriter) Write(p []byte) (int, error) {
+	for _, wc := range w.writers {
+		if _, err := wc.Write(p); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (w *fanoutWriter) Close() error {
+	var errs []error
+	for _, wc := range w.writers {
+		errs = append(errs, wc.Close())
+	}
+	return errors.Join(errs...)
+}
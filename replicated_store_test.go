@@ -0,0 +1,143 @@
+package c4fs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/store"
+)
+
+// flakyStore fails writes while down is set and delays opens by delay.
+type flakyStore struct {
+	*syncRAM
+	down  atomic.Bool
+	delay time.Duration
+}
+
+func (s *flakyStore) Open(id c4.ID) (io.ReadCloser, error) {
+	time.Sleep(s.delay)
+	return s.syncRAM.Open(id)
+}
+
+func (s *flakyStore) Create(id c4.ID) (io.WriteCloser, error) {
+	if s.down.Load() {
+		return nil, errors.New("backend unavailable")
+	}
+	return s.syncRAM.Create(id)
+}
+
+func TestReplicatedStoreSync(t *testing.T) {
+	a, b, c := newSyncRAM(), newSyncRAM(), newSyncRAM()
+	r, err := NewReplicatedStore([]store.Store{a, b, c}, ReplicationOptions{})
+	if err != nil {
+		t.Fatalf("NewReplicatedStore failed: %v", err)
+	}
+	defer r.Close()
+	adapter := NewStoreAdapter(r)
+
+	id, err := adapter.Put(strings.NewReader("replicated"))
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	for i, s := range []store.Store{a, b, c} {
+		if !NewStoreAdapter(s).Has(id) {
+			t.Errorf("backend %d is missing the blob", i)
+		}
+	}
+
+	// Reads fall back when a backend loses a blob
+	a.Remove(id)
+	if !adapter.Has(id) {
+		t.Error("blob unreadable with one backend missing it")
+	}
+
+	if err := r.Remove(id); err == nil {
+		t.Error("Remove should report the backend that lacked the blob")
+	}
+	if adapter.Has(id) {
+		t.Error("blob readable after Remove")
+	}
+
+	// A synchronous write fails if any backend does
+	down := &flakyStore{syncRAM: newSyncRAM()}
+	down.down.Store(true)
+	r2, _ := NewReplicatedStore([]store.Store{a, down}, ReplicationOptions{})
+	if _, err := NewStoreAdapter(r2).Put(strings.NewReader("x")); err == nil {
+		t.Error("Put succeeded with a backend down")
+	}
+}
+
+func TestReplicatedStoreReadsFastest(t *testing.T) {
+	slow := &flakyStore{syncRAM: newSyncRAM(), delay: 5 * time.Millisecond}
+	fast := &flakyStore{syncRAM: newSyncRAM()}
+	r, _ := NewReplicatedStore([]store.Store{slow, fast}, ReplicationOptions{})
+	defer r.Close()
+
+	id, _ := NewStoreAdapter(r).Put(strings.NewReader("content"))
+	for range 3 {
+		rc, err := r.Open(id)
+		if err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+		rc.Close()
+	}
+	// Unmeasured backends are tried first, so both have been measured
+	if order := r.byLatency(); order[0] != 1 {
+		t.Errorf("fastest backend: got %d, want 1", order[0])
+	}
+}
+
+// TestReplicatedStoreAsync tests background replication through a
+// durable queue that survives a restart.
+func TestReplicatedStoreAsync(t *testing.T) {
+	queue := t.TempDir()
+	primary := newSyncRAM()
+	backup := &flakyStore{syncRAM: newSyncRAM()}
+	backup.down.Store(true)
+
+	r, err := NewReplicatedStore([]store.Store{primary, backup}, ReplicationOptions{
+		QueueDir:      queue,
+		RetryInterval: time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("NewReplicatedStore failed: %v", err)
+	}
+	id, err := NewStoreAdapter(r).Put(strings.NewReader("queued"))
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if !NewStoreAdapter(primary).Has(id) {
+		t.Fatal("primary is missing the blob")
+	}
+	if r.Pending() != 1 {
+		t.Errorf("Pending: got %d, want 1", r.Pending())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := r.Drain(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Drain with backup down: got %v", err)
+	}
+	r.Close()
+
+	// A new store on the same queue picks up the pending copy
+	backup.down.Store(false)
+	r, err = NewReplicatedStore([]store.Store{primary, backup}, ReplicationOptions{QueueDir: queue})
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer r.Close()
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := r.Drain(ctx); err != nil {
+		t.Fatalf("Drain failed: %v", err)
+	}
+	if !NewStoreAdapter(backup).Has(id) {
+		t.Error("backup is missing the blob after draining")
+	}
+}
@@ -3,6 +3,7 @@ package c4fs
 import (
 	"io/fs"
 	"testing"
+	"time"
 
 	"github.com/Avalanche-io/c4/c4m"
 	"github.com/Avalanche-io/c4/store"
@@ -12,7 +13,7 @@ import (
 func TestRootDirectoryEdgeCases(t *testing.T) {
 	adapter := NewStoreAdapter(store.NewRAM())
 	base := c4m.NewManifest()
-	c4fs := New(base, adapter)
+	c4fs := New(adapter, WithBase(base))
 
 	// Add some files to the root
 	err := c4fs.WriteFile("file1.txt", []byte("content1"), 0644)
@@ -131,7 +132,7 @@ func TestRootDirectoryEdgeCases(t *testing.T) {
 func TestRootDirectoryWithEmptyFilesystem(t *testing.T) {
 	adapter := NewStoreAdapter(store.NewRAM())
 	base := c4m.NewManifest()
-	c4fs := New(base, adapter)
+	c4fs := New(adapter, WithBase(base))
 
 	// Test 1: Stat("/") on empty filesystem should work
 	info, err := c4fs.Stat("/")
@@ -158,3 +159,50 @@ func TestRootDirectoryWithEmptyFilesystem(t *testing.T) {
 		f.Close()
 	}
 }
+
+func TestRootMetadata(t *testing.T) {
+	c4fs := New(NewStoreAdapter(store.NewRAM()))
+	first, err := c4fs.Stat("/")
+	if err != nil {
+		t.Fatalf("Stat(/) failed: %v", err)
+	}
+	time.Sleep(time.Millisecond)
+	for _, name := range []string{"/", ".", ""} {
+		info, err := c4fs.Stat(name)
+		if err != nil {
+			t.Fatalf("Stat(%q) failed: %v", name, err)
+		}
+		if !info.ModTime().Equal(first.ModTime()) {
+			t.Errorf("Stat(%q) ModTime changed from %v to %v", name, first.ModTime(), info.ModTime())
+		}
+		if !info.IsDir() || info.Mode().Perm() != 0755 {
+			t.Errorf("Stat(%q) mode = %v, want drwxr-xr-x", name, info.Mode())
+		}
+	}
+
+	mtime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	configured := New(NewStoreAdapter(store.NewRAM()), WithRoot(0700, mtime))
+	info, _ := configured.Stat("/")
+	if !info.ModTime().Equal(mtime) || info.Mode() != fs.ModeDir|0700 {
+		t.Errorf("configured root = %v %v, want %v %v", info.Mode(), info.ModTime(), fs.ModeDir|0700, mtime)
+	}
+
+	// Chmod and Chtimes change the root instead of adding a layer entry
+	later := mtime.Add(time.Hour)
+	if err := configured.Chmod("/", 0750); err != nil {
+		t.Fatalf("Chmod(/) failed: %v", err)
+	}
+	if err := configured.Chtimes(".", later, later); err != nil {
+		t.Fatalf("Chtimes(.) failed: %v", err)
+	}
+	info, _ = configured.Stat("/")
+	if !info.ModTime().Equal(later) || info.Mode() != fs.ModeDir|0750 {
+		t.Errorf("root after Chmod/Chtimes = %v %v", info.Mode(), info.ModTime())
+	}
+	if n := configured.LayerView().Len(); n != 0 {
+		t.Errorf("layer has %d entries after changing the root, want 0", n)
+	}
+	if info, _ := configured.Clone().Stat("/"); !info.ModTime().Equal(later) {
+		t.Error("Clone did not keep the root metadata")
+	}
+}
@@ -0,0 +1,105 @@
+package c4fs
+
+import (
+	"maps"
+
+	"github.com/Avalanche-io/c4/c4m"
+)
+
+// Sandbox runs fn against an isolated writable fork of the filesystem.
+// The fork starts with the current merged view and shares the store, so
+// content written in it is stored once and can be committed without
+// copying. It has the same options, so removals in it are trashed as they
+// would be here, and audited when made. If fn returns nil, the fork's
+// changes, with the metadata it keeps beside them such as hard links,
+// device numbers, access times and asset groups, are merged into this
+// filesystem's layer in a single atomic step and the fork's temporary
+// files (see CreateTemp) are removed; otherwise they are discarded and
+// fn's error is returned.
+//
+// Changes made to this filesystem while fn runs are not visible in the
+// fork. If both change the same path, the fork's version wins. Blobs
+// written by a discarded sandbox stay in the store until garbage collected.
+func (c4fs *FS) Sandbox(fn func(scratch *FS) error) error {
+	scratch := c4fs.fork()
+	before := maps.Clone(scratch.meta)
+	forked := scratch.nextLink
+	if err := fn(scratch); err != nil {
+		return err
+	}
+
+	// The metadata the fork changed, on its own entries or on ones it
+	// shares with this filesystem
+	type metaChange struct {
+		entry *c4m.Entry
+		meta  EntryMeta
+	}
+	var metas []metaChange
+	scratch.mu.RLock()
+	changes := make([]*c4m.Entry, 0, len(scratch.layer.Entries))
+	for _, e := range scratch.layer.Entries {
+		if !scratch.isTemp(e.Name) {
+			changes = append(changes, e)
+		}
+	}
+	for name, m := range scratch.meta {
+		if e, err := scratch.lookup(name); err == nil && e == m.entry && before[name] != m && !scratch.isTemp(name) {
+			metas = append(metas, metaChange{m.entry, m.meta})
+		}
+	}
+	for name, m := range before {
+		if _, kept := scratch.meta[name]; !kept {
+			if e, err := scratch.lookup(name); err == nil && e == m.entry {
+				metas = append(metas, metaChange{m.entry, EntryMeta{}})
+			}
+		}
+	}
+	scratch.mu.RUnlock()
+
+	c4fs.mu.Lock()
+	for _, e := range changes {
+		c4fs.updateEntryInLayer(e)
+	}
+	// Groups made in the fork are numbered after any made here meanwhile
+	shift := c4fs.nextLink - min(c4fs.nextLink, forked)
+	renumber := func(group uint64) uint64 {
+		if group > forked {
+			return group + shift
+		}
+		return group
+	}
+	for _, m := range metas {
+		// Entries of the fork's base are only updated if still current here
+		if e, err := c4fs.lookup(m.entry.Name); err != nil || e != m.entry {
+			continue
+		}
+		m.meta.Link, m.meta.Asset = renumber(m.meta.Link), renumber(m.meta.Asset)
+		c4fs.setEntryMetaLocked(m.entry, m.meta)
+	}
+	c4fs.mu.Unlock()
+
+	// Committing ends the fork's scratch files
+	return scratch.CleanTemp()
+}
+
+// fork returns a filesystem whose base is the current merged view, with
+// this filesystem's options. When the layer is empty that is just the
+// base, so the clone's is kept to avoid flattening. The fork keeps the
+// working directory, which exists in the merged view.
+func (c4fs *FS) fork() *FS {
+	scratch := c4fs.Clone()
+
+	c4fs.mu.RLock()
+	empty := len(c4fs.layer.Entries) == 0
+	cwd := c4fs.cwd
+	c4fs.mu.RUnlock()
+
+	if !empty {
+		base := flattenEntries(c4fs.entries())
+		scratch.base = base
+		scratch.baseIndex = c4fs.newBaseIndex(base)
+		scratch.baseFilter = c4fs.newBaseFilter(base)
+	}
+	scratch.cwd = cwd
+	return scratch
+}
@@ -0,0 +1,143 @@
+package c4fs
+
+import (
+	"errors"
+	"io/fs"
+	"slices"
+	"testing"
+
+	"github.com/Avalanche-io/c4/store"
+)
+
+func TestSandboxCommit(t *testing.T) {
+	c4fs := New(NewStoreAdapter(store.NewRAM()))
+	c4fs.WriteFile("keep.txt", []byte("keep"), 0644)
+	c4fs.WriteFile("remove.txt", []byte("remove"), 0644)
+
+	err := c4fs.Sandbox(func(scratch *FS) error {
+		if data, err := scratch.ReadFile("keep.txt"); err != nil || string(data) != "keep" {
+			t.Errorf("Sandbox should see current state, got %q, %v", data, err)
+		}
+		scratch.MkdirAll("out", 0755)
+		scratch.WriteFile("out/result.txt", []byte("result"), 0644)
+		scratch.Remove("remove.txt")
+		if c4fs.Exists("out/result.txt") {
+			t.Error("Sandbox changes visible before commit")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Sandbox failed: %v", err)
+	}
+
+	if data, err := c4fs.ReadFile("out/result.txt"); err != nil || string(data) != "result" {
+		t.Errorf("Committed file: got %q, %v", data, err)
+	}
+	if c4fs.Exists("remove.txt") {
+		t.Error("Removal in sandbox should be committed")
+	}
+	if !c4fs.Exists("keep.txt") {
+		t.Error("Untouched file should remain")
+	}
+}
+
+func TestSandboxDiscard(t *testing.T) {
+	c4fs := New(NewStoreAdapter(store.NewRAM()))
+	c4fs.WriteFile("file.txt", []byte("original"), 0644)
+	failed := errors.New("build failed")
+
+	err := c4fs.Sandbox(func(scratch *FS) error {
+		scratch.WriteFile("file.txt", []byte("changed"), 0644)
+		scratch.WriteFile("partial.txt", []byte("partial"), 0644)
+		return failed
+	})
+	if !errors.Is(err, failed) {
+		t.Fatalf("Expected callback error, got %v", err)
+	}
+
+	if data, _ := c4fs.ReadFile("file.txt"); string(data) != "original" {
+		t.Errorf("file.txt should be unchanged, got %q", data)
+	}
+	if c4fs.Exists("partial.txt") {
+		t.Error("Discarded sandbox leaked partial.txt")
+	}
+}
+
+func TestSandboxSkipsTemp(t *testing.T) {
+	c4fs := New(NewStoreAdapter(store.NewRAM()))
+
+	var tmp string
+	var fork *FS
+	err := c4fs.Sandbox(func(scratch *FS) error {
+		f, name, err := scratch.CreateTemp("", "scratch-*")
+		if err != nil {
+			return err
+		}
+		tmp, fork = name, scratch
+		return f.Close()
+	})
+	if err != nil {
+		t.Fatalf("Sandbox failed: %v", err)
+	}
+	if c4fs.Exists(tmp) {
+		t.Errorf("Temp file %s should not be committed", tmp)
+	}
+	if fork.Exists(tmp) {
+		t.Errorf("Committing kept the fork's temp file %s", tmp)
+	}
+}
+
+func TestSandboxMergesMeta(t *testing.T) {
+	c4fs := New(NewStoreAdapter(store.NewRAM()))
+	c4fs.WriteFile("a", []byte("linked"), 0644)
+	c4fs.WriteFile("shot.exr", []byte("image"), 0644)
+	c4fs.WriteFile("shot.xmp", []byte("sidecar"), 0644)
+
+	c4fs.WriteFile("x", []byte("x"), 0644)
+	err := c4fs.Sandbox(func(scratch *FS) error {
+		// A group made here meanwhile must not collide with the fork's
+		c4fs.Link("x", "y")
+		if err := scratch.Link("a", "b"); err != nil {
+			return err
+		}
+		if err := scratch.Mknod("dev", fs.ModeDevice|0600, 0x801); err != nil {
+			return err
+		}
+		return scratch.AddSidecar("shot.exr", "shot.xmp")
+	})
+	if err != nil {
+		t.Fatalf("Sandbox failed: %v", err)
+	}
+
+	if links, err := c4fs.Links("a"); err != nil || !slices.Equal(links, []string{"a", "b"}) {
+		t.Errorf("Links(a) = %v, %v; want [a b]", links, err)
+	}
+	if links, _ := c4fs.Links("x"); !slices.Equal(links, []string{"x", "y"}) {
+		t.Errorf("Links(x) = %v, want [x y]", links)
+	}
+	if dev, err := c4fs.Rdev("dev"); err != nil || dev != 0x801 {
+		t.Errorf("Rdev(dev) = %#x, %v; want 0x801", dev, err)
+	}
+	if sidecars, err := c4fs.Sidecars("shot.exr"); err != nil || !slices.Equal(sidecars, []string{"shot.xmp"}) {
+		t.Errorf("Sidecars(shot.exr) = %v, %v", sidecars, err)
+	}
+}
+
+func TestSandboxKeepsOptions(t *testing.T) {
+	c4fs := New(NewStoreAdapter(store.NewRAM()), WithTrash(0))
+	c4fs.WriteFile("y", []byte("y"), 0644)
+
+	// The parent has pending changes, so the fork gets a flattened base
+	err := c4fs.Sandbox(func(scratch *FS) error {
+		return scratch.Remove("y")
+	})
+	if err != nil {
+		t.Fatalf("Sandbox failed: %v", err)
+	}
+	if c4fs.Exists("y") {
+		t.Error("Removal in sandbox should be committed")
+	}
+	if trash := c4fs.Trash(); len(trash) != 1 || trash[0].Name != "y" {
+		t.Errorf("Trash() = %v, want the removal of y", trash)
+	}
+}
@@ -0,0 +1,299 @@
+package c4fs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/store"
+)
+
+// ScrubOptions controls the behavior of ScrubStore.
+type ScrubOptions struct {
+	// BytesPerSecond limits the rate at which blobs are read for
+	// verification, so a scrub does not starve foreground reads. Repairs
+	// are not counted. Zero means unlimited.
+	BytesPerSecond int64
+
+	// Progress, if set, is called after each blob is checked.
+	Progress func(ScrubProgress)
+}
+
+// ScrubProgress reports the state of a running scrub.
+type ScrubProgress struct {
+	Total    int           // Number of blobs to check
+	Done     int           // Blobs checked so far
+	Bytes    int64         // Bytes read for verification
+	Damaged  int           // Blobs with at least one corrupt or missing copy
+	Repaired int           // Damaged blobs whose copies were all repaired
+	Elapsed  time.Duration // Time since the scrub started
+}
+
+// ScrubReport is the outcome of a scrub.
+type ScrubReport struct {
+	ScrubProgress
+
+	// Unrepaired lists the damaged blobs that could not be repaired,
+	// because no intact copy was found or the damaged copy is read-only.
+	Unrepaired []c4.ID
+}
+
+// scrubCopy is one copy of the blobs of a store checked by a scrub.
+type scrubCopy struct {
+	store      store.Store
+	complete   bool // every blob should have a copy here
+	repairable bool // damaged copies may be replaced
+}
+
+// scrubCopies returns the copies of the blobs of s. Every backend of a
+// ReplicatedStore should hold every blob and may be repaired. The stores
+// of a UnionStore only hold some blobs each, and only the primary is
+// written to. Any other store is a single copy that can be checked but
+// not repaired.
+func scrubCopies(s store.Store) []scrubCopy {
+	switch st := s.(type) {
+	case *ReplicatedStore:
+		copies := make([]scrubCopy, len(st.backends))
+		for i, b := range st.backends {
+			copies[i] = scrubCopy{store: b, complete: true, repairable: true}
+		}
+		return copies
+	case *UnionStore:
+		copies := make([]scrubCopy, len(st.stores))
+		for i, u := range st.stores {
+			copies[i] = scrubCopy{store: u, repairable: i == 0}
+		}
+		return copies
+	}
+	return []scrubCopy{{store: s, complete: true}}
+}
+
+// ScrubStore reads back every blob in s and checks that its content still
+// hashes to its ID, to catch bit rot in long-lived archives. When s is a
+// ReplicatedStore or UnionStore every copy of a blob is checked, and a
+// corrupt or missing copy is replaced with an intact one from another
+// backend. Blobs that cannot be repaired are listed in the report.
+//
+// Every copy must be listable (see ListIDs). The scrub stops early if ctx
+// is cancelled; the returned report describes what was done.
+func ScrubStore(ctx context.Context, s store.Store, opts ScrubOptions) (ScrubReport, error) {
+	start := time.Now()
+	var r ScrubReport
+
+	copies := scrubCopies(s)
+	seen := make(map[c4.ID]bool)
+	var ids []c4.ID
+	for _, c := range copies {
+		listed, err := ListIDs(c.store)
+		if err != nil {
+			return r, fmt.Errorf("failed to list store: %w", err)
+		}
+		for _, id := range listed {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].Less(ids[j]) })
+	r.Total = len(ids)
+
+	var bucket *tokenBucket
+	if opts.BytesPerSecond > 0 {
+		bucket = newTokenBucket(opts.BytesPerSecond, defaultThrottleBurst)
+	}
+
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			r.Elapsed = time.Since(start)
+			return r, err
+		}
+
+		n, damaged, repaired := scrubBlob(copies, id, bucket)
+		r.Bytes += n
+		if damaged {
+			r.Damaged++
+			if repaired {
+				r.Repaired++
+			} else {
+				r.Unrepaired = append(r.Unrepaired, id)
+			}
+		}
+
+		r.Done++
+		r.Elapsed = time.Since(start)
+		if opts.Progress != nil {
+			opts.Progress(r.ScrubProgress)
+		}
+	}
+
+	r.Elapsed = time.Since(start)
+	return r, nil
+}
+
+// scrubBlob checks every copy of the blob id and repairs the damaged ones
+// it can from an intact one. It returns the bytes read, whether any copy
+// was damaged, and whether all damaged copies were repaired.
+func scrubBlob(copies []scrubCopy, id c4.ID, bucket *tokenBucket) (int64, bool, bool) {
+	var read int64
+	var good store.Store
+	var bad []scrubCopy
+	for _, c := range copies {
+		if !NewStoreAdapter(c.store).Has(id) {
+			if c.complete {
+				bad = append(bad, c)
+			}
+			continue
+		}
+		n, ok := verifyBlob(c.store, id, bucket)
+		read += n
+		if ok {
+			if good == nil {
+				good = c.store
+			}
+		} else {
+			bad = append(bad, c)
+		}
+	}
+	if len(bad) == 0 {
+		return read, false, false
+	}
+
+	repaired := good != nil
+	for _, c := range bad {
+		if good == nil || !c.repairable {
+			repaired = false
+			continue
+		}
+		// Create refuses an existing ID, so remove the corrupt copy first
+		c.store.Remove(id)
+		if _, err := migrateBlob(good, c.store, id, true, false); err != nil {
+			repaired = false
+		}
+	}
+	return read, true, repaired
+}
+
+// verifyBlob reads the blob id from s and reports whether its content
+// hashes to id. A blob that cannot be read is not intact.
+func verifyBlob(s store.Store, id c4.ID, bucket *tokenBucket) (int64, bool) {
+	rc, err := s.Open(id)
+	if err != nil {
+		return 0, false
+	}
+	defer rc.Close()
+	cr := &scrubReader{r: rc, bucket: bucket}
+	got := c4.Identify(cr)
+	return cr.n, cr.err == nil && got == id
+}
+
+// scrubReader counts the bytes read through it, charges them to an
+// optional rate limit, and keeps the first read error, which c4.Identify
+// does not report.
+type scrubReader struct {
+	r      io.Reader
+	bucket *tokenBucket
+	n      int64
+	err    error
+}
+
+func (r *scrubReader) Read(p []byte) (int, error) {
+	if r.bucket != nil && int64(len(p)) > r.bucket.burst {
+		p = p[:r.bucket.burst]
+	}
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	if r.bucket != nil {
+		r.bucket.take(int64(n))
+	}
+	if err != nil && err != io.EOF && r.err == nil {
+		r.err = err
+	}
+	return n, err
+}
+
+// ScrubStatus reports a Scrubber's progress, for monitoring.
+type ScrubStatus struct {
+	LastRun     time.Time   // When the last complete scrub finished
+	LastReport  ScrubReport // Outcome of the last complete scrub
+	LastError   error       // Error of the last failed scrub, nil after a success
+	LastErrorAt time.Time   // When the last scrub failed
+	Next        time.Time   // When Run starts the next scrub; zero if not running
+}
+
+// Scrubber scrubs a store in the background on a schedule, so damage is
+// found and repaired while intact copies remain.
+type Scrubber struct {
+	store    store.Store
+	schedule Schedule
+	opts     ScrubOptions
+
+	scrub  sync.Mutex // serializes scrubs
+	mu     sync.Mutex // guards status
+	status ScrubStatus
+}
+
+// NewScrubber returns a scrubber checking s at the times given by
+// schedule. Call Run to start it.
+func NewScrubber(s store.Store, schedule Schedule, opts ScrubOptions) *Scrubber {
+	return &Scrubber{store: s, schedule: schedule, opts: opts}
+}
+
+// Run scrubs the store at each time of the schedule until ctx is done or
+// the schedule ends. Failed scrubs do not stop it; they are reported by
+// Status. Run returns ctx.Err(), or nil when the schedule ends.
+func (s *Scrubber) Run(ctx context.Context) error {
+	defer s.setNext(time.Time{})
+	for {
+		next := s.schedule.Next(time.Now())
+		if next.IsZero() {
+			return nil
+		}
+		s.setNext(next)
+
+		t := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+			s.Scrub(ctx)
+		}
+	}
+}
+
+// Scrub scrubs the store now. The outcome is also recorded in Status.
+func (s *Scrubber) Scrub(ctx context.Context) (ScrubReport, error) {
+	s.scrub.Lock()
+	defer s.scrub.Unlock()
+
+	r, err := ScrubStore(ctx, s.store, s.opts)
+	now := time.Now().UTC()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.status.LastError, s.status.LastErrorAt = err, now
+	} else {
+		s.status.LastRun, s.status.LastReport = now, r
+		s.status.LastError = nil
+	}
+	return r, err
+}
+
+// Status returns the outcome of the latest scrubs.
+func (s *Scrubber) Status() ScrubStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
+
+func (s *Scrubber) setNext(t time.Time) {
+	s.mu.Lock()
+	s.status.Next = t
+	s.mu.Unlock()
+}
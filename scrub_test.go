@@ -0,0 +1,92 @@
+package c4fs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/store"
+)
+
+// TestScrubStore tests that corrupt and missing replicas are found and
+// repaired from an intact copy.
+func TestScrubStore(t *testing.T) {
+	a, b := store.NewRAM(), store.NewRAM()
+	r, err := NewReplicatedStore([]store.Store{a, b}, ReplicationOptions{})
+	if err != nil {
+		t.Fatalf("NewReplicatedStore failed: %v", err)
+	}
+	defer r.Close()
+
+	var ids []c4.ID
+	for i := 0; i < 5; i++ {
+		id, err := NewStoreAdapter(r).Put(bytes.NewReader([]byte(fmt.Sprintf("blob %d", i))))
+		if err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		ids = append(ids, id)
+	}
+
+	(*a)[ids[0]] = []byte("rotted")
+	delete(*b, ids[1])
+	(*a)[ids[2]] = []byte("rotted")
+	(*b)[ids[2]] = []byte("rotted too")
+
+	var calls int
+	rep, err := ScrubStore(context.Background(), r, ScrubOptions{
+		Progress: func(ScrubProgress) { calls++ },
+	})
+	if err != nil {
+		t.Fatalf("ScrubStore failed: %v", err)
+	}
+	if rep.Total != 5 || rep.Done != 5 || calls != 5 {
+		t.Errorf("Progress: got total=%d done=%d calls=%d, want 5/5/5", rep.Total, rep.Done, calls)
+	}
+	if rep.Damaged != 3 || rep.Repaired != 2 {
+		t.Errorf("Got damaged=%d repaired=%d, want 3/2", rep.Damaged, rep.Repaired)
+	}
+	if len(rep.Unrepaired) != 1 || rep.Unrepaired[0] != ids[2] {
+		t.Errorf("Unrepaired = %v, want [%s]", rep.Unrepaired, ids[2])
+	}
+	if string((*a)[ids[0]]) != "blob 0" {
+		t.Errorf("Corrupt copy not repaired: %q", (*a)[ids[0]])
+	}
+	if string((*b)[ids[1]]) != "blob 1" {
+		t.Errorf("Missing copy not restored: %q", (*b)[ids[1]])
+	}
+
+	rep, err = ScrubStore(context.Background(), r, ScrubOptions{})
+	if err != nil {
+		t.Fatalf("Second ScrubStore failed: %v", err)
+	}
+	if rep.Damaged != 1 {
+		t.Errorf("Second scrub found %d damaged blobs, want 1", rep.Damaged)
+	}
+}
+
+// TestScrubUnionStore tests that only the primary of a union is repaired,
+// and that blobs absent from a layer are not damage.
+func TestScrubUnionStore(t *testing.T) {
+	primary, archive := store.NewRAM(), store.NewRAM()
+	good := c4.Identify(bytes.NewReader([]byte("good")))
+	(*primary)[good] = []byte("rotted")
+	(*archive)[good] = []byte("good")
+	other := c4.Identify(bytes.NewReader([]byte("other")))
+	(*archive)[other] = []byte("rotted")
+
+	rep, err := ScrubStore(context.Background(), NewUnionStore(primary, archive), ScrubOptions{})
+	if err != nil {
+		t.Fatalf("ScrubStore failed: %v", err)
+	}
+	if rep.Damaged != 2 || rep.Repaired != 1 {
+		t.Errorf("Got damaged=%d repaired=%d, want 2/1", rep.Damaged, rep.Repaired)
+	}
+	if string((*primary)[good]) != "good" {
+		t.Errorf("Primary not repaired: %q", (*primary)[good])
+	}
+	if len(rep.Unrepaired) != 1 || rep.Unrepaired[0] != other {
+		t.Errorf("Unrepaired = %v, want [%s]", rep.Unrepaired, other)
+	}
+}
@@ -0,0 +1,294 @@
+package c4fs
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/c4m"
+)
+
+// Sidecars are files that belong with a primary asset, such as the XML
+// metadata and WAV audio delivered beside a .mov. A primary and its
+// sidecars form an asset group kept in their EntryMeta, so snapshots and
+// the manifests made by Snapshot and AssetManifest preserve it, and
+// RenameAsset, RemoveAsset and AssetManifest act on the whole group at
+// once. Groups follow their files through Rename and
+// survive rewrites; a file removed leaves its group.
+
+// AddSidecar declares the regular file sidecar a sidecar of the regular
+// file primary. A sidecar has one primary: one already in another group
+// is moved to this one. A primary cannot itself be a sidecar.
+func (c4fs *FS) AddSidecar(primary, sidecar string) error {
+	c4fs.mu.Lock()
+	defer c4fs.mu.Unlock()
+
+	p, err := c4fs.assetFileLocked("sidecar", primary)
+	if err != nil {
+		return err
+	}
+	s, err := c4fs.assetFileLocked("sidecar", sidecar)
+	if err != nil {
+		return err
+	}
+	pm, sm := c4fs.entryMetaLocked(p), c4fs.entryMetaLocked(s)
+	switch {
+	case p == s:
+		return &fs.PathError{Op: "sidecar", Path: sidecar, Err: errors.New("file cannot be its own sidecar")}
+	case pm.Sidecar:
+		return &fs.PathError{Op: "sidecar", Path: primary, Err: errors.New("primary is a sidecar")}
+	case sm.Asset != 0 && !sm.Sidecar && len(c4fs.assetMembersLocked(sm.Asset)) > 1:
+		return &fs.PathError{Op: "sidecar", Path: sidecar, Err: errors.New("file is the primary of other sidecars")}
+	}
+
+	group := pm.Asset
+	if group == 0 {
+		c4fs.nextLink++
+		group = c4fs.nextLink
+		c4fs.updateMetaLocked(p, func(m *EntryMeta) { m.Asset = group })
+	}
+	c4fs.updateMetaLocked(s, func(m *EntryMeta) { m.Asset, m.Sidecar = group, true })
+	return nil
+}
+
+// RemoveSidecar takes the file sidecar out of its asset group. The file
+// itself is kept. Removing a file that is not a sidecar is not an error.
+func (c4fs *FS) RemoveSidecar(sidecar string) error {
+	c4fs.mu.Lock()
+	defer c4fs.mu.Unlock()
+
+	s, err := c4fs.lookup(sidecar)
+	if err != nil {
+		return err
+	}
+	if c4fs.entryMetaLocked(s).Sidecar {
+		c4fs.updateMetaLocked(s, func(m *EntryMeta) { m.Asset, m.Sidecar = 0, false })
+	}
+	return nil
+}
+
+// Sidecars returns the sidecars of the file primary, sorted.
+func (c4fs *FS) Sidecars(primary string) ([]string, error) {
+	group, err := c4fs.Asset(primary)
+	if err != nil || len(group) == 0 || group[0] != cleanPath(primary) {
+		return nil, err
+	}
+	return group[1:], nil
+}
+
+// Asset returns the asset group of the named file, primary or sidecar:
+// the name of its primary, followed by those of its sidecars, sorted. A
+// file in no group has no asset group, and the result is empty.
+func (c4fs *FS) Asset(name string) ([]string, error) {
+	c4fs.mu.RLock()
+	defer c4fs.mu.RUnlock()
+
+	e, err := c4fs.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	group := c4fs.entryMetaLocked(e).Asset
+	if group == 0 {
+		return nil, nil
+	}
+	return c4fs.assetMembersLocked(group), nil
+}
+
+// RenameAsset renames the primary file oldname to newname and moves its
+// sidecars with it, all at once. Sidecars in the primary's directory or
+// below keep their place relative to it, and those named after the
+// primary, such as plate.xml beside plate.mov, are renamed to match: with
+// newname final.mov, it becomes final.xml. Other sidecars keep their
+// names. If any destination exists, nothing is renamed.
+func (c4fs *FS) RenameAsset(oldname, newname string) error {
+	oldname, newname = cleanPath(oldname), cleanPath(newname)
+
+	c4fs.mu.Lock()
+	defer c4fs.mu.Unlock()
+
+	p, err := c4fs.assetFileLocked("rename", oldname)
+	if err != nil {
+		return err
+	}
+	if c4fs.entryMetaLocked(p).Sidecar {
+		return &fs.PathError{Op: "rename", Path: oldname, Err: errors.New("file is a sidecar; rename its primary")}
+	}
+	members := []string{oldname}
+	if group := c4fs.entryMetaLocked(p).Asset; group != 0 {
+		members = c4fs.assetMembersLocked(group)
+	}
+
+	oldDir, newDir := path.Dir(oldname), path.Dir(newname)
+	oldStem, newStem := stem(path.Base(oldname)), stem(path.Base(newname))
+	targets := make([]string, len(members))
+	seen := make(map[string]bool, len(members))
+	for i, m := range members {
+		dir, base := path.Dir(m), path.Base(m)
+		if i == 0 {
+			dir, base = newDir, path.Base(newname)
+		} else {
+			switch {
+			case dir == oldDir:
+				dir = newDir
+			case oldDir == ".":
+				dir = path.Join(newDir, dir)
+			case strings.HasPrefix(dir, oldDir+"/"):
+				dir = path.Join(newDir, dir[len(oldDir)+1:])
+			}
+			if strings.HasPrefix(base, oldStem+".") {
+				base = newStem + base[len(oldStem):]
+			}
+		}
+		targets[i] = cleanPath(path.Join(dir, base))
+		if _, err := c4fs.lookup(targets[i]); err == nil || seen[targets[i]] {
+			return &fs.PathError{Op: "rename", Path: targets[i], Err: fs.ErrExist}
+		}
+		seen[targets[i]] = true
+	}
+
+	for i, m := range members {
+		if m == targets[i] {
+			continue
+		}
+		if err := c4fs.rename(m, targets[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemoveAsset removes the named file and the rest of its asset group, all
+// at once. Like Remove, it moves them to the trash if enabled and audits
+// each removal.
+func (c4fs *FS) RemoveAsset(name string) error {
+	name = cleanPath(name)
+	type removal struct {
+		name string
+		ids  []c4.ID
+	}
+	var removed []removal
+
+	c4fs.mu.Lock()
+	e, err := c4fs.assetFileLocked("remove", name)
+	if err != nil {
+		c4fs.mu.Unlock()
+		return err
+	}
+	members := []string{name}
+	if group := c4fs.entryMetaLocked(e).Asset; group != 0 {
+		members = c4fs.assetMembersLocked(group)
+	}
+	for _, m := range members {
+		var ids []c4.ID
+		if c4fs.trashing(m) {
+			ids, err = c4fs.trashLocked(m, false)
+		} else {
+			ids, err = c4fs.remove(m)
+		}
+		if err != nil {
+			break
+		}
+		removed = append(removed, removal{m, ids})
+	}
+	c4fs.mu.Unlock()
+
+	errs := []error{err}
+	for _, r := range removed {
+		errs = append(errs, c4fs.audit(AuditRemove, r.name, r.ids))
+	}
+	errs = append(errs, c4fs.expireTrash())
+	return errors.Join(errs...)
+}
+
+// AssetManifest returns a manifest of the asset group of the named file,
+// taken at one point in time, for exporting the group together. Parent
+// directories are not included. Like Snapshot, it stores the metadata of
+// the entries, including the group, with the manifest.
+func (c4fs *FS) AssetManifest(name string) (*c4m.Manifest, error) {
+	m, meta, err := c4fs.assetManifest(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := SetManifestMeta(m, c4fs.store, meta); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// assetManifest builds the manifest AssetManifest returns and collects the
+// metadata of its entries.
+func (c4fs *FS) assetManifest(name string) (*c4m.Manifest, map[string]EntryMeta, error) {
+	c4fs.mu.RLock()
+	defer c4fs.mu.RUnlock()
+
+	e, err := c4fs.assetFileLocked("asset", name)
+	if err != nil {
+		return nil, nil, err
+	}
+	members := []string{e.Name}
+	if group := c4fs.entryMetaLocked(e).Asset; group != 0 {
+		members = c4fs.assetMembersLocked(group)
+	}
+	m := c4m.NewManifest()
+	meta := make(map[string]EntryMeta)
+	for _, n := range members {
+		e, err := c4fs.lookup(n)
+		if err != nil {
+			return nil, nil, err
+		}
+		if em := c4fs.entryMetaLocked(e); em != (EntryMeta{}) {
+			meta[e.Name] = em
+		}
+		copied := *e
+		m.AddEntry(&copied)
+	}
+	return Canonicalize(m), meta, nil
+}
+
+// assetFileLocked returns the entry of the regular file name, for op.
+// The caller must hold c4fs.mu.
+func (c4fs *FS) assetFileLocked(op, name string) (*c4m.Entry, error) {
+	e, err := c4fs.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	if !e.Mode.IsRegular() {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fmt.Errorf("not a regular file")}
+	}
+	return e, nil
+}
+
+// assetMembersLocked returns the names of the files in an asset group:
+// its primary, if it still exists, followed by its sidecars, sorted.
+// The caller must hold c4fs.mu.
+func (c4fs *FS) assetMembersLocked(group uint64) []string {
+	var primary string
+	var sidecars []string
+	for n, m := range c4fs.meta {
+		if m.meta.Asset != group {
+			continue
+		}
+		if e, err := c4fs.lookup(n); err != nil || e != m.entry {
+			continue
+		}
+		if m.meta.Sidecar {
+			sidecars = append(sidecars, n)
+		} else {
+			primary = n
+		}
+	}
+	slices.Sort(sidecars)
+	if primary == "" {
+		return sidecars
+	}
+	return append([]string{primary}, sidecars...)
+}
+
+// stem returns base without its extension.
+func stem(base string) string {
+	return strings.TrimSuffix(base, path.Ext(base))
+}
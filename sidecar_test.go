@@ -0,0 +1,196 @@
+package c4fs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Avalanche-io/c4/store"
+)
+
+func TestSidecars(t *testing.T) {
+	s := NewStoreAdapter(store.NewRAM())
+	c4fs := New(s)
+	c4fs.MkdirAll("shots/a/audio", 0755)
+	c4fs.MkdirAll("final", 0755)
+	for _, name := range []string{"shots/a/plate.mov", "shots/a/plate.xml", "shots/a/audio/plate.wav", "shots/a/notes.txt", "shots/a/other.mov"} {
+		if err := c4fs.WriteFile(name, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, sc := range []string{"shots/a/plate.xml", "shots/a/audio/plate.wav", "shots/a/notes.txt"} {
+		if err := c4fs.AddSidecar("shots/a/plate.mov", sc); err != nil {
+			t.Fatalf("AddSidecar(%s) failed: %v", sc, err)
+		}
+	}
+
+	want := []string{"shots/a/audio/plate.wav", "shots/a/notes.txt", "shots/a/plate.xml"}
+	if got, err := c4fs.Sidecars("shots/a/plate.mov"); err != nil || !slices.Equal(got, want) {
+		t.Errorf("Sidecars = %v, %v; want %v", got, err, want)
+	}
+	if got, _ := c4fs.Asset("shots/a/plate.xml"); len(got) != 4 || got[0] != "shots/a/plate.mov" {
+		t.Errorf("Asset of a sidecar = %v", got)
+	}
+	if got, _ := c4fs.Asset("shots/a/other.mov"); len(got) != 0 {
+		t.Errorf("Asset of an ungrouped file = %v", got)
+	}
+	if err := c4fs.AddSidecar("shots/a/plate.xml", "shots/a/other.mov"); err == nil {
+		t.Error("Expected an error making a sidecar a primary")
+	}
+	if err := c4fs.AddSidecar("shots/a/other.mov", "shots/a/plate.mov"); err == nil {
+		t.Error("Expected an error making a primary with sidecars a sidecar")
+	}
+	if err := c4fs.AddSidecar("shots/a/plate.mov", "shots/a"); err == nil {
+		t.Error("Expected an error for a directory sidecar")
+	}
+
+	// Editing a sidecar keeps it in the group
+	c4fs.WriteFile("shots/a/plate.xml", []byte("<edited/>"), 0644)
+	if got, _ := c4fs.Sidecars("shots/a/plate.mov"); !slices.Equal(got, want) {
+		t.Errorf("Sidecars after edit = %v", got)
+	}
+
+	// Snapshots keep the groups
+	var buf bytes.Buffer
+	if err := c4fs.SaveSnapshot(&buf, SnapshotBinary); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := OpenSnapshot(&buf, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := loaded.Sidecars("shots/a/plate.mov"); !slices.Equal(got, want) {
+		t.Errorf("Sidecars after snapshot = %v", got)
+	}
+
+	// The group moves together, renaming sidecars named after the primary
+	if err := c4fs.WriteFile("final/shot.xml", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := c4fs.RenameAsset("shots/a/plate.mov", "final/shot.mov"); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("RenameAsset onto an existing sidecar name = %v, want ErrExist", err)
+	}
+	if _, err := c4fs.Stat("shots/a/plate.mov"); err != nil {
+		t.Errorf("Failed RenameAsset moved the primary: %v", err)
+	}
+	c4fs.Remove("final/shot.xml")
+	c4fs.MkdirAll("final/audio", 0755)
+	if err := c4fs.RenameAsset("shots/a/plate.mov", "final/shot.mov"); err != nil {
+		t.Fatalf("RenameAsset failed: %v", err)
+	}
+	want = []string{"final/audio/shot.wav", "final/notes.txt", "final/shot.xml"}
+	if got, err := c4fs.Sidecars("final/shot.mov"); err != nil || !slices.Equal(got, want) {
+		t.Errorf("Sidecars after RenameAsset = %v, %v; want %v", got, err, want)
+	}
+	if data, _ := c4fs.ReadFile("final/shot.xml"); string(data) != "<edited/>" {
+		t.Errorf("Renamed sidecar = %q", data)
+	}
+	if err := c4fs.RenameAsset("final/shot.xml", "x.xml"); err == nil {
+		t.Error("Expected an error renaming a sidecar as an asset")
+	}
+
+	m, err := c4fs.AssetManifest("final/notes.txt")
+	if err != nil || len(m.Entries) != 4 {
+		t.Errorf("AssetManifest = %v, %v", m, err)
+	}
+
+	if err := c4fs.RemoveSidecar("final/notes.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := c4fs.Sidecars("final/shot.mov"); len(got) != 2 {
+		t.Errorf("Sidecars after RemoveSidecar = %v", got)
+	}
+	if err := c4fs.RemoveAsset("final/shot.mov"); err != nil {
+		t.Fatalf("RemoveAsset failed: %v", err)
+	}
+	for _, name := range []string{"final/shot.mov", "final/shot.xml", "final/audio/shot.wav"} {
+		if _, err := c4fs.Stat(name); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("%s after RemoveAsset: %v", name, err)
+		}
+	}
+	if _, err := c4fs.Stat("final/notes.txt"); err != nil {
+		t.Errorf("Former sidecar removed with the asset: %v", err)
+	}
+}
+
+func TestAssetGroupsPersist(t *testing.T) {
+	src := store.NewRAM()
+	local, err := NewRegistry(NewStoreAdapter(src), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote := newTestRegistry(t)
+	c4fs := New(local.Store())
+	for _, name := range []string{"plate.mov", "plate.xml", "plate.wav"} {
+		c4fs.WriteFile(name, []byte(name), 0644)
+	}
+	c4fs.AddSidecar("plate.mov", "plate.xml")
+	c4fs.AddSidecar("plate.mov", "plate.wav")
+	want := []string{"plate.wav", "plate.xml"}
+	check := func(how string, fsys *FS) {
+		t.Helper()
+		if got, err := fsys.Sidecars("plate.mov"); err != nil || !slices.Equal(got, want) {
+			t.Errorf("%s: Sidecars = %v, %v; want %v", how, got, err, want)
+		}
+	}
+
+	m, err := c4fs.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("Snapshot", New(local.Store(), WithBase(m)))
+	am, err := c4fs.AssetManifest("plate.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("AssetManifest", New(local.Store(), WithBase(am)))
+
+	s := NewSnapshotter(c4fs, Every(time.Hour), NewRegistrySink(local, "snap/"), SnapshotterOptions{})
+	id, err := s.Snapshot(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := local.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("Snapshotter", New(local.Store(), WithBase(got)))
+
+	// Pushing sends the groups with the snapshot
+	if _, err, serveErr := pushTo(t, local, remote, id, PushOptions{}); err != nil || serveErr != nil {
+		t.Fatalf("push: client %v, server %v", err, serveErr)
+	}
+	if got, err = remote.Get(id); err != nil {
+		t.Fatal(err)
+	}
+	check("Push", New(remote.Store(), WithBase(got)))
+
+	// So does a backup
+	var archive bytes.Buffer
+	if err := Backup(context.Background(), src, local, &archive); err != nil {
+		t.Fatal(err)
+	}
+	dst := store.NewRAM()
+	if err := Restore(context.Background(), &archive, dst, nil); err != nil {
+		t.Fatal(err)
+	}
+	check("Restore", New(NewStoreAdapter(dst), WithBase(got)))
+
+	var buf bytes.Buffer
+	if err := c4fs.ExportJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	em, meta, err := ReadManifestJSONMeta(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !meta["plate.xml"].Sidecar || meta["plate.xml"].Asset != meta["plate.mov"].Asset {
+		t.Errorf("Exported metadata = %v", meta)
+	}
+	SetManifestMeta(em, local.Store(), meta)
+	check("ExportJSON", New(local.Store(), WithBase(em)))
+}
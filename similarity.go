@@ -0,0 +1,132 @@
+package c4fs
+
+import (
+	"fmt"
+	"io/fs"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/c4m"
+)
+
+// Similarity reports how much of some new content is already present in
+// older content, measured in content-defined chunks. Chunk boundaries
+// follow the content, so an edit near the start of a file does not make
+// the rest of it look new.
+type Similarity struct {
+	Chunks       int   // Chunks in the new content
+	SharedChunks int   // Chunks also present in the old content
+	Bytes        int64 // Size of the new content
+	SharedBytes  int64 // Bytes in shared chunks
+}
+
+// Ratio returns the fraction of the new content's bytes that are shared,
+// from 0 to 1. Empty content is considered identical.
+func (s Similarity) Ratio() float64 {
+	if s.Bytes == 0 {
+		return 1
+	}
+	return float64(s.SharedBytes) / float64(s.Bytes)
+}
+
+// add counts refs as new content, shared where present in old.
+func (s *Similarity) add(refs []chunkRef, old map[c4.ID]bool) {
+	for _, r := range refs {
+		s.Chunks++
+		s.Bytes += r.Size
+		if old[r.ID] {
+			s.SharedChunks++
+			s.SharedBytes += r.Size
+		}
+	}
+}
+
+// Similarity compares the content of the regular files oldName and
+// newName, reporting how much of newName is found in oldName.
+func (c4fs *FS) Similarity(oldName, newName string) (Similarity, error) {
+	oldRefs, err := c4fs.fileChunks(oldName)
+	if err != nil {
+		return Similarity{}, err
+	}
+	newRefs, err := c4fs.fileChunks(newName)
+	if err != nil {
+		return Similarity{}, err
+	}
+
+	var s Similarity
+	s.add(newRefs, chunkSet(oldRefs))
+	return s, nil
+}
+
+// fileChunks chunks the content of the named regular file.
+func (c4fs *FS) fileChunks(name string) ([]chunkRef, error) {
+	entry, err := c4fs.resolveSymlink(name, 40)
+	if err != nil {
+		return nil, err
+	}
+	if !entry.Mode.IsRegular() {
+		return nil, &fs.PathError{Op: "similarity", Path: name, Err: fmt.Errorf("not a regular file")}
+	}
+	return c4fs.store.chunks(entry)
+}
+
+// SnapshotSimilarity reports how much of the files in newer are found
+// anywhere in the files of older, for example to show that a new version of
+// a project is mostly unchanged or to estimate what a transfer would cost.
+// Content is chunked once per distinct C4 ID, so duplicated files are only
+// read once. The entries are collected first and read without holding
+// either filesystem's locks, so both can be written meanwhile.
+func SnapshotSimilarity(older, newer *FS) (Similarity, error) {
+	old := make(map[c4.ID]bool)
+	chunked := make(map[c4.ID]bool)
+	for _, e := range flattenEntries(older.Entries()).Entries {
+		if chunked[e.C4ID] {
+			continue
+		}
+		chunked[e.C4ID] = true
+		refs, err := older.store.chunks(e)
+		if err != nil {
+			return Similarity{}, err
+		}
+		for _, r := range refs {
+			old[r.ID] = true
+		}
+	}
+
+	var s Similarity
+	seen := make(map[c4.ID][]chunkRef)
+	for _, e := range flattenEntries(newer.Entries()).Entries {
+		refs, ok := seen[e.C4ID]
+		if !ok {
+			var err error
+			if refs, err = newer.store.chunks(e); err != nil {
+				return Similarity{}, err
+			}
+			seen[e.C4ID] = refs
+		}
+		s.add(refs, old)
+	}
+	return s, nil
+}
+
+// chunks reads and chunks the content of a regular file entry. Other
+// entries have no chunks.
+func (s *StoreAdapter) chunks(e *c4m.Entry) ([]chunkRef, error) {
+	if !e.Mode.IsRegular() || e.Size <= 0 || e.C4ID.IsNil() {
+		return nil, nil
+	}
+	rc, err := s.Get(e.C4ID)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", e.Name, err)
+	}
+	defer rc.Close()
+	return chunkRefs(rc)
+}
+
+// chunkSet returns the set of chunk IDs in refs.
+func chunkSet(refs []chunkRef) map[c4.ID]bool {
+	set := make(map[c4.ID]bool, len(refs))
+	for _, r := range refs {
+		set[r.ID] = true
+	}
+	return set
+}
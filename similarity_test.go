@@ -0,0 +1,161 @@
+package c4fs
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/store"
+)
+
+// randomContent returns n reproducible pseudo-random bytes.
+func randomContent(seed int64, n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+// TestChunker tests that chunks respect the size bounds, cover the input
+// and survive an insertion near the start.
+func TestChunker(t *testing.T) {
+	data := randomContent(1, 1<<20)
+
+	var joined []byte
+	c := newChunker(bytes.NewReader(data))
+	for {
+		chunk, err := c.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("next failed: %v", err)
+		}
+		if len(chunk) > maxChunkSize {
+			t.Errorf("chunk of %d bytes exceeds the maximum", len(chunk))
+		}
+		joined = append(joined, chunk...)
+	}
+	if !bytes.Equal(joined, data) {
+		t.Fatal("chunks do not reassemble the input")
+	}
+
+	refs, _ := chunkRefs(bytes.NewReader(data))
+	if n := len(refs); n < 64 || n > 256 {
+		t.Errorf("1 MiB split into %d chunks, expected about 128", n)
+	}
+
+	edited := append([]byte("inserted"), data...)
+	editedRefs, _ := chunkRefs(bytes.NewReader(edited))
+	set := chunkSet(refs)
+	var shared int
+	for _, r := range editedRefs {
+		if set[r.ID] {
+			shared++
+		}
+	}
+	if shared < len(refs)-2 {
+		t.Errorf("only %d of %d chunks survived an insertion", shared, len(refs))
+	}
+}
+
+// TestC4FSSimilarity tests file and snapshot comparisons.
+func TestC4FSSimilarity(t *testing.T) {
+	adapter := NewStoreAdapter(store.NewRAM())
+	older := New(adapter)
+	original := randomContent(2, 512<<10)
+	older.WriteFile("video.mov", original, 0644)
+	older.WriteFile("notes.txt", randomContent(3, 64<<10), 0644)
+
+	// Change a few bytes in the middle of the video
+	edited := bytes.Clone(original)
+	copy(edited[200<<10:], "an edit in the middle")
+	newer := New(adapter)
+	newer.WriteFile("video.mov", edited, 0644)
+	newer.WriteFile("unrelated.bin", randomContent(4, 64<<10), 0644)
+	newer.WriteFile("copy.mov", edited, 0644)
+
+	older.WriteFile("video-v2.mov", edited, 0644)
+	s, err := older.Similarity("video.mov", "video-v2.mov")
+	if err != nil {
+		t.Fatalf("Similarity failed: %v", err)
+	}
+	if s.Bytes != int64(len(edited)) || s.Ratio() < 0.9 || s.Ratio() == 1 {
+		t.Errorf("edited file: %+v (ratio %.3f)", s, s.Ratio())
+	}
+	older.Remove("video-v2.mov")
+
+	s, err = SnapshotSimilarity(older, newer)
+	if err != nil {
+		t.Fatalf("SnapshotSimilarity failed: %v", err)
+	}
+	total := int64(2*len(edited) + 64<<10)
+	if s.Bytes != total {
+		t.Errorf("snapshot bytes: got %d, want %d", s.Bytes, total)
+	}
+	// Two near-identical videos shared, the unrelated file new
+	if r := s.Ratio(); r < 0.85 || r > 0.95 {
+		t.Errorf("snapshot ratio: got %.3f", r)
+	}
+
+	if s, _ := SnapshotSimilarity(newer, newer); s.Ratio() != 1 {
+		t.Errorf("snapshot compared with itself: ratio %.3f", s.Ratio())
+	}
+
+	older.Mkdir("dir", 0755)
+	if _, err := older.Similarity("dir", "video.mov"); err == nil {
+		t.Error("Similarity of a directory succeeded")
+	}
+}
+
+// gateStore blocks opening the blob block until release is closed,
+// reporting the first attempt on opened.
+type gateStore struct {
+	*syncRAM
+	block   c4.ID
+	opened  chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func (s *gateStore) Open(id c4.ID) (io.ReadCloser, error) {
+	if id == s.block {
+		s.once.Do(func() { close(s.opened) })
+		<-s.release
+	}
+	return s.syncRAM.Open(id)
+}
+
+func TestSnapshotSimilarityUnlocked(t *testing.T) {
+	gate := &gateStore{syncRAM: newSyncRAM(), opened: make(chan struct{}), release: make(chan struct{})}
+	fsys := New(NewStoreAdapter(gate))
+	content := randomContent(5, 64<<10)
+	fsys.WriteFile("a.txt", content, 0644)
+	gate.block = c4.Identify(bytes.NewReader(content))
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := SnapshotSimilarity(fsys, fsys)
+		done <- err
+	}()
+	<-gate.opened
+
+	// Content is read without the filesystem locked
+	written := make(chan error, 1)
+	go func() { written <- fsys.WriteFile("b.txt", []byte("b"), 0644) }()
+	select {
+	case err := <-written:
+		if err != nil {
+			t.Errorf("WriteFile failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("WriteFile blocked while SnapshotSimilarity read content")
+	}
+	close(gate.release)
+	if err := <-done; err != nil {
+		t.Errorf("SnapshotSimilarity failed: %v", err)
+	}
+}
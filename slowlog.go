@@ -0,0 +1,241 @@
+package c4fs
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/store"
+)
+
+// SlowOp describes a filesystem or store operation that took longer than
+// the threshold given to WithSlowOps or NewInstrumentedStore.
+type SlowOp struct {
+	Op       string        // Operation, such as "stat", "writefile" or "open"
+	Path     string        // Path operated on; empty for store operations
+	ID       c4.ID         // Blob operated on by a store operation; nil otherwise
+	Size     int64         // Bytes read or written, or -1 if not known
+	Backend  string        // Store named in NewInstrumentedStore; empty for filesystem operations
+	Duration time.Duration // Time the operation took
+	Err      error         // Error the operation returned, if any
+}
+
+// WithSlowOps calls report for every filesystem operation that takes
+// threshold or longer: Open, Stat, ReadDir, ReadFile, WriteFile, Remove,
+// RemoveAll, Rename, and the Close of files written, which stores their
+// content. report is called synchronously, so it should be quick, such as
+// a log call. To see which store is slow, wrap each store, or each tier of
+// a TieredStore, with NewInstrumentedStore. Clones share the setting.
+func WithSlowOps(threshold time.Duration, report func(SlowOp)) Option {
+	return func(o *options) {
+		o.slowThreshold = threshold
+		o.slowReport = report
+	}
+}
+
+// opTimer times a filesystem operation for WithSlowOps. The zero value,
+// returned when slow operations are not reported, times nothing.
+type opTimer struct {
+	c4fs  *FS
+	op    string
+	path  string
+	start time.Time
+}
+
+// startOp starts timing op on path.
+func (c4fs *FS) startOp(op, path string) opTimer {
+	if c4fs.slowReport == nil {
+		return opTimer{}
+	}
+	return opTimer{c4fs, op, path, time.Now()}
+}
+
+// done reports the operation if it was slow. size is the number of bytes
+// transferred, or -1 if not known; err points to the operation's error.
+func (t opTimer) done(size int64, err *error) {
+	if t.c4fs == nil {
+		return
+	}
+	if d := time.Since(t.start); d >= t.c4fs.slowAfter {
+		t.c4fs.slowReport(SlowOp{Op: t.op, Path: t.path, Size: size, Duration: d, Err: *err})
+	}
+}
+
+// InstrumentedStore wraps a store and reports its operations that take
+// longer than a threshold, labeled with a backend name, to find which
+// store of a stack hurts interactive workloads. Opening a blob and
+// reading it are timed separately: a read is reported when the reader is
+// closed if the time spent in the wrapped store's Read calls, not in the
+// caller, reached the threshold. Writes are timed the same way, including
+// the final Close.
+//
+// InstrumentedStore passes RangeOpener, BlobStater, BlobChecker,
+// BlobLister and HealthChecker through to the wrapped store.
+type InstrumentedStore struct {
+	store     store.Store
+	backend   string
+	threshold time.Duration
+	report    func(SlowOp)
+}
+
+var (
+	_ store.Store   = (*InstrumentedStore)(nil)
+	_ RangeOpener   = (*InstrumentedStore)(nil)
+	_ BlobStater    = (*InstrumentedStore)(nil)
+	_ BlobChecker   = (*InstrumentedStore)(nil)
+	_ BlobLister    = (*InstrumentedStore)(nil)
+	_ HealthChecker = (*InstrumentedStore)(nil)
+)
+
+// NewInstrumentedStore returns s wrapped to call report for each of its
+// operations that takes threshold or longer, naming it backend, such as
+// "cache" or "s3". report may be called concurrently.
+func NewInstrumentedStore(s store.Store, backend string, threshold time.Duration, report func(SlowOp)) *InstrumentedStore {
+	return &InstrumentedStore{store: s, backend: backend, threshold: threshold, report: report}
+}
+
+// observe reports op on id if it took threshold or longer since start.
+func (s *InstrumentedStore) observe(op string, id c4.ID, size int64, start time.Time, err error) {
+	if d := time.Since(start); d >= s.threshold {
+		s.report(SlowOp{Op: op, ID: id, Size: size, Backend: s.backend, Duration: d, Err: err})
+	}
+}
+
+// Open opens the blob for reading.
+func (s *InstrumentedStore) Open(id c4.ID) (io.ReadCloser, error) {
+	start := time.Now()
+	rc, err := s.store.Open(id)
+	s.observe("open", id, -1, start, err)
+	if err != nil {
+		return nil, err
+	}
+	return &instrumentedReader{rc: rc, store: s, id: id}, nil
+}
+
+// OpenRange opens part of the blob for reading. Ranges are read natively
+// when the wrapped store supports them.
+func (s *InstrumentedStore) OpenRange(id c4.ID, off, length int64) (io.ReadCloser, error) {
+	start := time.Now()
+	rc, err := NewStoreAdapter(s.store).GetRange(id, off, length)
+	s.observe("openrange", id, -1, start, err)
+	if err != nil {
+		return nil, err
+	}
+	return &instrumentedReader{rc: rc, store: s, id: id}, nil
+}
+
+// Create opens a writer for the blob.
+func (s *InstrumentedStore) Create(id c4.ID) (io.WriteCloser, error) {
+	start := time.Now()
+	wc, err := s.store.Create(id)
+	if err != nil {
+		s.observe("create", id, -1, start, err)
+		return nil, err
+	}
+	return &instrumentedWriter{wc: wc, store: s, id: id, spent: time.Since(start)}, nil
+}
+
+// Remove removes the blob from the wrapped store.
+func (s *InstrumentedStore) Remove(id c4.ID) error {
+	start := time.Now()
+	err := s.store.Remove(id)
+	s.observe("remove", id, -1, start, err)
+	return err
+}
+
+// StatBlob describes the blob in the wrapped store.
+func (s *InstrumentedStore) StatBlob(id c4.ID) (BlobInfo, error) {
+	start := time.Now()
+	info, err := NewStoreAdapter(s.store).Stat(id)
+	s.observe("stat", id, info.Size, start, err)
+	return info, err
+}
+
+// HasBlob checks the wrapped store.
+func (s *InstrumentedStore) HasBlob(id c4.ID) bool {
+	start := time.Now()
+	ok := NewStoreAdapter(s.store).Has(id)
+	s.observe("has", id, -1, start, nil)
+	return ok
+}
+
+// ListIDs lists the wrapped store.
+func (s *InstrumentedStore) ListIDs() ([]c4.ID, error) {
+	start := time.Now()
+	ids, err := ListIDs(s.store)
+	s.observe("list", c4.ID{}, -1, start, err)
+	return ids, err
+}
+
+// Ping checks the wrapped store.
+func (s *InstrumentedStore) Ping(ctx context.Context) error {
+	start := time.Now()
+	err := NewStoreAdapter(s.store).Ping(ctx)
+	s.observe("ping", c4.ID{}, -1, start, err)
+	return err
+}
+
+// instrumentedReader times the reads of a blob.
+type instrumentedReader struct {
+	rc    io.ReadCloser
+	store *InstrumentedStore
+	id    c4.ID
+	n     int64
+	spent time.Duration
+	err   error
+}
+
+func (r *instrumentedReader) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := r.rc.Read(p)
+	r.spent += time.Since(start)
+	r.n += int64(n)
+	if err != nil && err != io.EOF && r.err == nil {
+		r.err = err
+	}
+	return n, err
+}
+
+func (r *instrumentedReader) Close() error {
+	if r.spent >= r.store.threshold {
+		r.store.report(SlowOp{Op: "read", ID: r.id, Size: r.n, Backend: r.store.backend, Duration: r.spent, Err: r.err})
+		r.spent = 0
+	}
+	return r.rc.Close()
+}
+
+// instrumentedWriter times the writes of a blob, reporting them when it
+// is closed.
+type instrumentedWriter struct {
+	wc    io.WriteCloser
+	store *InstrumentedStore
+	id    c4.ID
+	n     int64
+	spent time.Duration
+	err   error
+}
+
+func (w *instrumentedWriter) Write(p []byte) (int, error) {
+	start := time.Now()
+	n, err := w.wc.Write(p)
+	w.spent += time.Since(start)
+	w.n += int64(n)
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+	return n, err
+}
+
+func (w *instrumentedWriter) Close() error {
+	start := time.Now()
+	err := w.wc.Close()
+	w.spent += time.Since(start)
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+	if w.spent >= w.store.threshold {
+		w.store.report(SlowOp{Op: "write", ID: w.id, Size: w.n, Backend: w.store.backend, Duration: w.spent, Err: w.err})
+	}
+	return err
+}
@@ -0,0 +1,113 @@
+package c4fs
+
+import (
+	"bytes"
+	"io"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/store"
+)
+
+// slowOpLog collects reported slow operations.
+type slowOpLog struct {
+	mu  sync.Mutex
+	ops []SlowOp
+}
+
+func (l *slowOpLog) report(op SlowOp) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.ops = append(l.ops, op)
+}
+
+func (l *slowOpLog) names() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var names []string
+	for _, op := range l.ops {
+		names = append(names, op.Op)
+	}
+	return names
+}
+
+func TestWithSlowOps(t *testing.T) {
+	var log slowOpLog
+	fsys := New(NewStoreAdapter(store.NewRAM()), WithSlowOps(0, log.report))
+	fsys.WriteFile("a.txt", []byte("hello"), 0644)
+	fsys.ReadFile("a.txt")
+	fsys.Stat("missing.txt")
+	f, _ := fsys.Create("b.txt")
+	f.Write([]byte("abc"))
+	f.Close()
+	fsys.Clone().Rename("a.txt", "c.txt")
+
+	want := []string{"writefile", "open", "readfile", "stat", "close", "rename"}
+	if got := log.names(); !slices.Equal(got, want) {
+		t.Fatalf("Reported %v, want %v", got, want)
+	}
+	if op := log.ops[2]; op.Path != "a.txt" || op.Size != 5 || op.Err != nil || op.Backend != "" {
+		t.Errorf("readfile reported as %+v", op)
+	}
+	if op := log.ops[3]; op.Err == nil {
+		t.Error("stat of a missing file reported without its error")
+	}
+	if op := log.ops[4]; op.Path != "b.txt" || op.Size != 3 {
+		t.Errorf("close reported as %+v", op)
+	}
+
+	// Fast operations are not reported
+	var quiet slowOpLog
+	fsys = New(NewStoreAdapter(store.NewRAM()), WithSlowOps(time.Hour, quiet.report))
+	fsys.WriteFile("a.txt", []byte("hello"), 0644)
+	if got := quiet.names(); len(got) != 0 {
+		t.Errorf("Reported fast operations %v", got)
+	}
+}
+
+// delayStore delays opening blobs.
+type delayStore struct {
+	*store.RAM
+	delay time.Duration
+}
+
+func (s *delayStore) Open(id c4.ID) (io.ReadCloser, error) {
+	time.Sleep(s.delay)
+	return s.RAM.Open(id)
+}
+
+func TestInstrumentedStore(t *testing.T) {
+	var log slowOpLog
+	ram := store.NewRAM()
+	id, err := NewStoreAdapter(ram).Put(bytes.NewReader([]byte("archived")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hot := NewInstrumentedStore(store.NewRAM(), "hot", 20*time.Millisecond, log.report)
+	cold := NewInstrumentedStore(&delayStore{ram, 30 * time.Millisecond}, "cold", 20*time.Millisecond, log.report)
+
+	NewStoreAdapter(hot).Put(bytes.NewReader([]byte("recent")))
+	if !cold.HasBlob(c4.Identify(bytes.NewReader([]byte("archived")))) {
+		t.Fatal("HasBlob = false")
+	}
+	if got := log.names(); !slices.Equal(got, []string{"has"}) {
+		t.Fatalf("Reported %v, want the slow has", got)
+	}
+	log.ops = nil
+
+	rc, err := cold.Open(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.ReadAll(rc)
+	rc.Close()
+	if len(log.ops) != 1 {
+		t.Fatalf("Reported %v, want one open", log.names())
+	}
+	if op := log.ops[0]; op.Op != "open" || op.Backend != "cold" || op.ID != id || op.Duration < 20*time.Millisecond {
+		t.Errorf("Reported %+v", op)
+	}
+}
@@ -0,0 +1,663 @@
+package c4fs
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"time"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/c4m"
+)
+
+// SnapshotFormat selects the encoding used by SaveSnapshot.
+type SnapshotFormat int
+
+const (
+	// SnapshotText is the native c4m text format. It stores times to the
+	// second, and hard links and device numbers only as metadata in the
+	// store (see Snapshot); access times are not kept.
+	SnapshotText SnapshotFormat = iota
+
+	// SnapshotBinary is a compact binary encoding. Paths are prefix
+	// compressed and integers are varint encoded, which makes large
+	// snapshots several times smaller and faster to parse than c4m text.
+	// Times keep their nanoseconds, and access times, hard links and
+	// device numbers are saved with their entries.
+	SnapshotBinary
+)
+
+// snapshotMagic identifies a binary snapshot. It is followed by a
+// single format version byte and, from version 5, a uvarint of the
+// features the snapshot requires.
+var snapshotMagic = []byte("C4FS")
+
+// snapshotVersion is the current binary snapshot format version. Version
+// 2 added access times, version 3 hard links and version 4 device
+// numbers; older snapshots are still read. Version 5 made the format
+// extensible, so that later additions need not change the version:
+//
+//   - data that older readers cannot do without is announced by a bit in
+//     the required features of the header, and readers refuse snapshots
+//     requiring features they do not know;
+//   - records with tags other than those below are length-prefixed, and
+//     readers skip those they do not know;
+//   - entries may end with an extension block of length-prefixed fields,
+//     each a uvarint field number, a uvarint length and its data, and
+//     readers skip the fields they do not know.
+const snapshotVersion = 5
+
+// snapshotFeatures are the required features this package reads. None
+// are defined yet.
+const snapshotFeatures = 0
+
+// Record tags in a binary snapshot.
+const (
+	snapshotTagEnd   = 0
+	snapshotTagEntry = 1
+
+	// snapshotTagData is the ID of the manifest's Data, length-prefixed.
+	snapshotTagData = 2
+)
+
+// Entry flags in a binary snapshot. Bits 5 and 6 are reserved; as their
+// data could not be skipped, readers reject entries that set them.
+const (
+	snapshotHasID     = 1 << 0
+	snapshotHasTarget = 1 << 1
+	snapshotHasAtime  = 1 << 2
+	snapshotHasLink   = 1 << 3
+	snapshotHasRdev   = 1 << 4
+	snapshotHasExt    = 1 << 7
+
+	snapshotKnownFlags = snapshotHasID | snapshotHasTarget | snapshotHasAtime |
+		snapshotHasLink | snapshotHasRdev | snapshotHasExt
+)
+
+// Entry extension fields in a binary snapshot.
+const (
+	// snapshotExtAsset is the asset group of an entry, shifted left by one
+	// with the low bit set on sidecars, as a uvarint.
+	snapshotExtAsset = 1
+)
+
+// ErrSnapshotVersion is returned when a binary snapshot was written by a
+// newer, unsupported format version, or requires features this package
+// does not support.
+var ErrSnapshotVersion = errors.New("unsupported snapshot version")
+
+// SaveSnapshot writes the flattened filesystem to w in the given format.
+// The binary format is streamed entry by entry without materializing a
+// flattened manifest; writers are blocked until it completes. The text
+// format is written from Snapshot, which stores the metadata of the
+// entries in the store.
+func (c4fs *FS) SaveSnapshot(w io.Writer, format SnapshotFormat) error {
+	if format != SnapshotBinary {
+		m, err := c4fs.Snapshot()
+		if err != nil {
+			return err
+		}
+		return WriteSnapshot(w, m, format)
+	}
+
+	sw, err := NewSnapshotWriter(w)
+	if err != nil {
+		return err
+	}
+	err = c4fs.walkFlattened(func(e *c4m.Entry) error {
+		return sw.WriteEntryMeta(e, c4fs.entryMetaLocked(e))
+	})
+	if err != nil {
+		return err
+	}
+	return sw.Close()
+}
+
+// walkFlattened calls fn for every entry of Entries, stopping at the
+// first error.
+func (c4fs *FS) walkFlattened(fn func(*c4m.Entry) error) error {
+	for e := range c4fs.Entries() {
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OpenSnapshot reads a snapshot from r and returns a filesystem using it as
+// the base, configured by opts. Binary snapshots are decoded in a single
+// pass that builds the manifest and its path index together; with
+// WithEntryArena, their entries are allocated in blocks. With
+// WithValidation, the snapshot is checked with ValidateManifest. With
+// WithSparse, entries outside the view are skipped as they are read.
+func OpenSnapshot(r io.Reader, store *StoreAdapter, opts ...Option) (*FS, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	br := bufio.NewReader(r)
+	head, err := br.Peek(len(snapshotMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if !bytes.Equal(head, snapshotMagic) {
+		m, err := c4m.GenerateFromReader(br)
+		if err != nil {
+			return nil, err
+		}
+		m = fromTextModes(m)
+		if o.validate {
+			if err := ValidateManifest(m); err != nil {
+				return nil, err
+			}
+		}
+		return New(store, append(opts, WithBase(m))...), nil
+	}
+
+	sr, err := NewSnapshotReader(br)
+	if err != nil {
+		return nil, err
+	}
+	fsys := New(store, opts...)
+	sr.arena = fsys.arena
+	_, mapped := fsys.baseIndex.(mapIndex)
+	base := c4m.NewManifest()
+	var index map[string]*c4m.Entry
+	if mapped {
+		index = make(map[string]*c4m.Entry)
+	}
+	for {
+		e, err := sr.ReadEntry()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if fsys.sparse != nil && !fsys.sparse.keeps(e) {
+			continue
+		}
+		base.AddEntry(e)
+		if index != nil {
+			index[e.Name] = e
+		}
+		fsys.setEntryMetaLocked(e, sr.Meta())
+	}
+	if o.validate {
+		if err := ValidateManifest(base); err != nil {
+			return nil, err
+		}
+	}
+	n := len(base.Entries)
+	base = implicitDirs(base)
+	if index != nil {
+		for _, e := range base.Entries[n:] {
+			index[e.Name] = e
+		}
+	}
+
+	base.Data = sr.Data()
+
+	fsys.base = base
+	fsys.baseFilter = fsys.newBaseFilter(base)
+	if mapped {
+		fsys.baseIndex = mapIndex(index)
+	} else {
+		fsys.baseIndex = fsys.newBaseIndex(base)
+	}
+	fsys.loadBaseMeta(base)
+	return fsys, nil
+}
+
+// WriteSnapshot writes m to w in the given format.
+func WriteSnapshot(w io.Writer, m *c4m.Manifest, format SnapshotFormat) error {
+	switch format {
+	case SnapshotText:
+		_, err := toTextModes(m).WriteTo(w)
+		return err
+	case SnapshotBinary:
+		sw, err := NewSnapshotWriter(w)
+		if err != nil {
+			return err
+		}
+		if !m.Data.IsNil() {
+			if err := sw.WriteData(m.Data); err != nil {
+				return err
+			}
+		}
+		for _, e := range m.Entries {
+			if err := sw.WriteEntry(e); err != nil {
+				return err
+			}
+		}
+		return sw.Close()
+	}
+	return fmt.Errorf("unknown snapshot format %d", format)
+}
+
+// LoadSnapshot reads a snapshot written by SaveSnapshot.
+// The format is detected automatically. The manifest is not checked;
+// use ValidateManifest for snapshots from untrusted sources.
+func LoadSnapshot(r io.Reader) (*c4m.Manifest, error) {
+	br := bufio.NewReader(r)
+	head, err := br.Peek(len(snapshotMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	if !bytes.Equal(head, snapshotMagic) {
+		m, err := c4m.GenerateFromReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return fromTextModes(m), nil
+	}
+
+	sr, err := NewSnapshotReader(br)
+	if err != nil {
+		return nil, err
+	}
+	m := c4m.NewManifest()
+	for {
+		e, err := sr.ReadEntry()
+		if err == io.EOF {
+			m.Data = sr.Data()
+			return m, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		m.AddEntry(e)
+	}
+}
+
+// SnapshotWriter encodes entries in the binary snapshot format.
+type SnapshotWriter struct {
+	w    *bufio.Writer
+	prev string
+	buf  []byte
+}
+
+// NewSnapshotWriter writes the snapshot header to w and returns a writer
+// for its entries. Close must be called to write the end marker.
+func NewSnapshotWriter(w io.Writer) (*SnapshotWriter, error) {
+	bw := bufio.NewWriter(w)
+	if _, err := bw.Write(snapshotMagic); err != nil {
+		return nil, err
+	}
+	if err := bw.WriteByte(snapshotVersion); err != nil {
+		return nil, err
+	}
+	if _, err := bw.Write(binary.AppendUvarint(nil, snapshotFeatures)); err != nil {
+		return nil, err
+	}
+	return &SnapshotWriter{w: bw}, nil
+}
+
+// WriteEntry encodes a single entry.
+func (sw *SnapshotWriter) WriteEntry(e *c4m.Entry) error {
+	return sw.WriteEntryMeta(e, EntryMeta{})
+}
+
+// WriteEntryMeta encodes a single entry with its metadata. Zero fields
+// are omitted.
+func (sw *SnapshotWriter) WriteEntryMeta(e *c4m.Entry, meta EntryMeta) error {
+	// Share the longest common prefix with the previous path
+	shared := 0
+	for shared < len(e.Name) && shared < len(sw.prev) && e.Name[shared] == sw.prev[shared] {
+		shared++
+	}
+	suffix := e.Name[shared:]
+
+	var flags byte
+	if !e.C4ID.IsNil() {
+		flags |= snapshotHasID
+	}
+	if e.Target != "" {
+		flags |= snapshotHasTarget
+	}
+	if !meta.Atime.IsZero() {
+		flags |= snapshotHasAtime
+	}
+	if meta.Link != 0 {
+		flags |= snapshotHasLink
+	}
+	if meta.Rdev != 0 {
+		flags |= snapshotHasRdev
+	}
+	if meta.Asset != 0 {
+		flags |= snapshotHasExt
+	}
+
+	b := sw.buf[:0]
+	b = append(b, snapshotTagEntry, flags)
+	b = binary.AppendUvarint(b, uint64(shared))
+	b = binary.AppendUvarint(b, uint64(len(suffix)))
+	b = append(b, suffix...)
+	b = binary.AppendUvarint(b, uint64(e.Mode))
+	b = binary.AppendVarint(b, e.Timestamp.UnixNano())
+	b = binary.AppendVarint(b, e.Size)
+	if flags&snapshotHasID != 0 {
+		b = append(b, e.C4ID[:]...)
+	}
+	if flags&snapshotHasTarget != 0 {
+		b = binary.AppendUvarint(b, uint64(len(e.Target)))
+		b = append(b, e.Target...)
+	}
+	if flags&snapshotHasAtime != 0 {
+		b = binary.AppendVarint(b, meta.Atime.UnixNano())
+	}
+	if flags&snapshotHasLink != 0 {
+		b = binary.AppendUvarint(b, meta.Link)
+	}
+	if flags&snapshotHasRdev != 0 {
+		b = binary.AppendUvarint(b, meta.Rdev)
+	}
+	if flags&snapshotHasExt != 0 {
+		asset := meta.Asset << 1
+		if meta.Sidecar {
+			asset |= 1
+		}
+		value := binary.AppendUvarint(nil, asset)
+		var ext []byte
+		ext = binary.AppendUvarint(ext, snapshotExtAsset)
+		ext = binary.AppendUvarint(ext, uint64(len(value)))
+		ext = append(ext, value...)
+		b = binary.AppendUvarint(b, uint64(len(ext)))
+		b = append(b, ext...)
+	}
+	sw.buf = b
+	sw.prev = e.Name
+
+	_, err := sw.w.Write(b)
+	return err
+}
+
+// WriteData records the ID of the manifest's Data.
+func (sw *SnapshotWriter) WriteData(id c4.ID) error {
+	_, err := sw.w.Write(appendData(nil, id))
+	return err
+}
+
+// appendData appends a record of the Data id to b.
+func appendData(b []byte, id c4.ID) []byte {
+	b = append(b, snapshotTagData)
+	b = binary.AppendUvarint(b, uint64(len(id)))
+	return append(b, id[:]...)
+}
+
+// Close writes the end marker and flushes buffered output.
+// It does not close the underlying writer.
+func (sw *SnapshotWriter) Close() error {
+	if err := sw.w.WriteByte(snapshotTagEnd); err != nil {
+		return err
+	}
+	return sw.w.Flush()
+}
+
+// SnapshotReader decodes entries from the binary snapshot format.
+type SnapshotReader struct {
+	r       *bufio.Reader
+	version int
+	prev    string
+	arena   *entryArena // nil to allocate entries individually
+	buf     []byte
+	meta    EntryMeta // metadata of the last entry read
+	data    c4.ID     // the manifest's Data, if read
+	skipped int       // unknown records and fields skipped
+}
+
+// NewSnapshotReader reads and checks the snapshot header from r.
+func NewSnapshotReader(r io.Reader) (*SnapshotReader, error) {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+
+	head := make([]byte, len(snapshotMagic)+1)
+	if _, err := io.ReadFull(br, head); err != nil {
+		return nil, fmt.Errorf("failed to read snapshot header: %w", err)
+	}
+	if !bytes.Equal(head[:len(snapshotMagic)], snapshotMagic) {
+		return nil, fmt.Errorf("not a binary snapshot")
+	}
+	v := int(head[len(snapshotMagic)])
+	if v < 1 || v > snapshotVersion {
+		return nil, fmt.Errorf("%w: %d", ErrSnapshotVersion, v)
+	}
+	if v >= 5 {
+		features, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read snapshot header: %w", unexpectedEOF(err))
+		}
+		if unknown := features &^ snapshotFeatures; unknown != 0 {
+			return nil, fmt.Errorf("%w: requires features %#x", ErrSnapshotVersion, unknown)
+		}
+	}
+	return &SnapshotReader{r: br, version: v}, nil
+}
+
+// Version returns the format version of the snapshot.
+func (sr *SnapshotReader) Version() int {
+	return sr.version
+}
+
+// Skipped returns the number of records and entry fields skipped so far
+// because they were added by a later version of the format. Their data
+// is lost if the snapshot is read and written again.
+func (sr *SnapshotReader) Skipped() int {
+	return sr.skipped
+}
+
+// ReadEntry decodes the next entry. It returns io.EOF after the last one.
+func (sr *SnapshotReader) ReadEntry() (*c4m.Entry, error) {
+	for {
+		tag, err := sr.r.ReadByte()
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		switch {
+		case tag == snapshotTagEnd:
+			return nil, io.EOF
+		case tag == snapshotTagEntry:
+			return sr.readEntry()
+		case sr.version < 5:
+			return nil, fmt.Errorf("corrupt snapshot: unknown record tag %d", tag)
+		case tag == snapshotTagData:
+			b, err := sr.readBytes()
+			if err != nil {
+				return nil, err
+			}
+			if len(b) != len(sr.data) {
+				return nil, fmt.Errorf("corrupt snapshot: invalid data ID")
+			}
+			copy(sr.data[:], b)
+			continue
+		}
+		if err := sr.skip(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// readEntry decodes an entry record after its tag.
+func (sr *SnapshotReader) readEntry() (*c4m.Entry, error) {
+	flags, err := sr.r.ReadByte()
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if flags&^snapshotKnownFlags != 0 || (sr.version < 5 && flags&snapshotHasExt != 0) {
+		return nil, fmt.Errorf("corrupt snapshot: unknown entry flags %#x", flags)
+	}
+	shared, err := binary.ReadUvarint(sr.r)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if shared > uint64(len(sr.prev)) {
+		return nil, fmt.Errorf("corrupt snapshot: invalid path prefix")
+	}
+	suffix, err := sr.readBytes()
+	if err != nil {
+		return nil, err
+	}
+	mode, err := binary.ReadUvarint(sr.r)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	nanos, err := binary.ReadVarint(sr.r)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	size, err := binary.ReadVarint(sr.r)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+
+	var e *c4m.Entry
+	if sr.arena != nil {
+		e = sr.arena.newEntry()
+		e.Name = sr.arena.concatBytes(sr.prev[:shared], suffix)
+	} else {
+		e = &c4m.Entry{Name: sr.prev[:shared] + string(suffix)}
+	}
+	e.Mode = fs.FileMode(mode)
+	e.Timestamp = time.Unix(0, nanos).UTC()
+	e.Size = size
+	if flags&snapshotHasID != 0 {
+		if _, err := io.ReadFull(sr.r, e.C4ID[:]); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+	}
+	if flags&snapshotHasTarget != 0 {
+		if e.Target, err = sr.readString(); err != nil {
+			return nil, err
+		}
+	}
+	sr.meta = EntryMeta{}
+	if flags&snapshotHasAtime != 0 {
+		nanos, err := binary.ReadVarint(sr.r)
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		sr.meta.Atime = time.Unix(0, nanos).UTC()
+	}
+	if flags&snapshotHasLink != 0 {
+		if sr.meta.Link, err = binary.ReadUvarint(sr.r); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+	}
+	if flags&snapshotHasRdev != 0 {
+		if sr.meta.Rdev, err = binary.ReadUvarint(sr.r); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+	}
+	if flags&snapshotHasExt != 0 {
+		if err := sr.readExt(); err != nil {
+			return nil, err
+		}
+	}
+
+	sr.prev = e.Name
+	return e, nil
+}
+
+// readExt reads the extension block of an entry. Unknown fields are
+// skipped.
+func (sr *SnapshotReader) readExt() error {
+	ext, err := sr.readBytes()
+	if err != nil {
+		return err
+	}
+	r := bytes.NewReader(ext)
+	for r.Len() > 0 {
+		field, err := binary.ReadUvarint(r)
+		if err != nil {
+			return fmt.Errorf("corrupt snapshot: invalid entry extension")
+		}
+		n, err := binary.ReadUvarint(r)
+		if err != nil || n > uint64(r.Len()) {
+			return fmt.Errorf("corrupt snapshot: invalid entry extension")
+		}
+		data := ext[len(ext)-r.Len():][:n]
+		r.Seek(int64(n), io.SeekCurrent)
+
+		switch field {
+		case snapshotExtAsset:
+			asset, k := binary.Uvarint(data)
+			if k <= 0 {
+				return fmt.Errorf("corrupt snapshot: invalid asset group")
+			}
+			sr.meta.Asset, sr.meta.Sidecar = asset>>1, asset&1 != 0
+		default:
+			sr.skipped++
+		}
+	}
+	return nil
+}
+
+// skip discards a length-prefixed record of an unknown type.
+func (sr *SnapshotReader) skip() error {
+	n, err := binary.ReadUvarint(sr.r)
+	if err != nil {
+		return unexpectedEOF(err)
+	}
+	if int64(n) < 0 {
+		return fmt.Errorf("corrupt snapshot: record length %d too large", n)
+	}
+	if _, err := io.CopyN(io.Discard, sr.r, int64(n)); err != nil {
+		return unexpectedEOF(err)
+	}
+	sr.skipped++
+	return nil
+}
+
+// Data returns the ID of the manifest's Data, or a nil ID if the
+// snapshot has none. It is known once ReadEntry has returned io.EOF.
+func (sr *SnapshotReader) Data() c4.ID {
+	return sr.data
+}
+
+// Meta returns the metadata of the entry last read.
+func (sr *SnapshotReader) Meta() EntryMeta {
+	return sr.meta
+}
+
+// readString reads a uvarint length-prefixed string.
+func (sr *SnapshotReader) readString() (string, error) {
+	b, err := sr.readBytes()
+	return string(b), err
+}
+
+// readBytes reads a uvarint length-prefixed string into a buffer that is
+// reused by the next call.
+func (sr *SnapshotReader) readBytes() ([]byte, error) {
+	n, err := binary.ReadUvarint(sr.r)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if n > 1<<20 {
+		return nil, fmt.Errorf("corrupt snapshot: string length %d too large", n)
+	}
+	if uint64(cap(sr.buf)) < n {
+		sr.buf = make([]byte, n)
+	}
+	b := sr.buf[:n]
+	if _, err := io.ReadFull(sr.r, b); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return b, nil
+}
+
+// unexpectedEOF converts io.EOF inside a record into io.ErrUnexpectedEOF,
+// so a truncated snapshot is not mistaken for a complete one.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
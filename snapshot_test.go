@@ -0,0 +1,159 @@
+package c4fs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/Avalanche-io/c4/store"
+)
+
+func TestSnapshotBinaryRoundTrip(t *testing.T) {
+	c4fs := New(NewStoreAdapter(store.NewRAM()))
+	c4fs.MkdirAll("project/src", 0755)
+	for i := 0; i < 50; i++ {
+		c4fs.WriteFile(fmt.Sprintf("project/src/file%02d.go", i), []byte(fmt.Sprintf("package f%d", i)), 0644)
+	}
+	c4fs.WriteFile("project/empty", nil, 0600)
+	c4fs.Symlink("project/src", "src")
+
+	var bin bytes.Buffer
+	if err := c4fs.SaveSnapshot(&bin, SnapshotBinary); err != nil {
+		t.Fatalf("SaveSnapshot binary failed: %v", err)
+	}
+	var text bytes.Buffer
+	if err := c4fs.SaveSnapshot(&text, SnapshotText); err != nil {
+		t.Fatalf("SaveSnapshot text failed: %v", err)
+	}
+	if bin.Len() >= text.Len() {
+		t.Errorf("Binary snapshot (%d bytes) not smaller than text (%d bytes)", bin.Len(), text.Len())
+	}
+
+	m, err := LoadSnapshot(&bin)
+	if err != nil {
+		t.Fatalf("LoadSnapshot failed: %v", err)
+	}
+	orig := c4fs.Flatten()
+	if len(m.Entries) != len(orig.Entries) {
+		t.Fatalf("Entry count: got %d, want %d", len(m.Entries), len(orig.Entries))
+	}
+	for i, e := range orig.Entries {
+		got := m.Entries[i]
+		if got.Name != e.Name || got.Mode != e.Mode || got.Size != e.Size ||
+			got.C4ID != e.C4ID || got.Target != e.Target || !got.Timestamp.Equal(e.Timestamp) {
+			t.Errorf("Entry %d mismatch: got %+v, want %+v", i, got, e)
+		}
+	}
+
+	restored := New(c4fs.Store(), WithBase(m))
+	data, err := restored.ReadFile("src/file07.go")
+	if err != nil || string(data) != "package f7" {
+		t.Errorf("ReadFile from restored snapshot: got %q, %v", data, err)
+	}
+}
+
+func TestSnapshotBinaryErrors(t *testing.T) {
+	// Unknown version
+	_, err := LoadSnapshot(bytes.NewReader([]byte("C4FS\x09\x00")))
+	if !errors.Is(err, ErrSnapshotVersion) {
+		t.Errorf("Expected ErrSnapshotVersion, got %v", err)
+	}
+
+	// Truncated snapshot
+	c4fs := New(NewStoreAdapter(store.NewRAM()))
+	c4fs.WriteFile("a.txt", []byte("a"), 0644)
+	var buf bytes.Buffer
+	c4fs.SaveSnapshot(&buf, SnapshotBinary)
+	truncated := buf.Bytes()[:buf.Len()-10]
+	if _, err := LoadSnapshot(bytes.NewReader(truncated)); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Expected io.ErrUnexpectedEOF for truncated snapshot, got %v", err)
+	}
+}
+
+func TestSnapshotForwardCompatible(t *testing.T) {
+	header := append(append([]byte{}, snapshotMagic...), snapshotVersion, 0)
+
+	// A record of an unknown type, then an entry with an unknown field
+	b := append([]byte{}, header...)
+	b = append(b, 9)
+	b = binary.AppendUvarint(b, 3)
+	b = append(b, "abc"...)
+	b = append(b, snapshotTagEntry, snapshotHasExt)
+	b = binary.AppendUvarint(b, 0)
+	b = binary.AppendUvarint(b, 5)
+	b = append(b, "a.txt"...)
+	b = binary.AppendUvarint(b, 0644)
+	b = binary.AppendVarint(b, 0)
+	b = binary.AppendVarint(b, 0)
+	ext := binary.AppendUvarint(nil, 42)
+	ext = binary.AppendUvarint(ext, 2)
+	ext = append(ext, "xy"...)
+	b = binary.AppendUvarint(b, uint64(len(ext)))
+	b = append(b, ext...)
+	b = append(b, snapshotTagEnd)
+
+	sr, err := NewSnapshotReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := sr.ReadEntry()
+	if err != nil || e.Name != "a.txt" || e.Mode != 0644 {
+		t.Fatalf("ReadEntry = %v, %v", e, err)
+	}
+	if _, err := sr.ReadEntry(); err != io.EOF {
+		t.Errorf("ReadEntry at end = %v, want io.EOF", err)
+	}
+	if sr.Version() != snapshotVersion || sr.Skipped() != 2 {
+		t.Errorf("Version, Skipped = %d, %d", sr.Version(), sr.Skipped())
+	}
+
+	// Required features that are not known are refused
+	b = append([]byte{}, snapshotMagic...)
+	b = append(b, snapshotVersion, 1<<3, snapshotTagEnd)
+	if _, err := LoadSnapshot(bytes.NewReader(b)); !errors.Is(err, ErrSnapshotVersion) {
+		t.Errorf("LoadSnapshot requiring unknown features = %v, want ErrSnapshotVersion", err)
+	}
+
+	// Reserved entry flags cannot be skipped
+	b = append(append([]byte{}, header...), snapshotTagEntry, 1<<5)
+	if _, err := LoadSnapshot(bytes.NewReader(b)); err == nil {
+		t.Error("LoadSnapshot accepted reserved entry flags")
+	}
+}
+
+func TestSnapshotStreaming(t *testing.T) {
+	adapter := NewStoreAdapter(store.NewRAM())
+	baseFS := New(adapter)
+	baseFS.WriteFile("a.txt", []byte("base a"), 0644)
+	baseFS.WriteFile("b.txt", []byte("base b"), 0644)
+
+	c4fs := New(adapter, WithBase(baseFS.Flatten()))
+	c4fs.WriteFile("a.txt", []byte("layer a"), 0644) // shadows base
+	c4fs.Remove("b.txt")                             // tombstones base
+	c4fs.WriteFile("c.txt", []byte("layer c"), 0644)
+
+	var buf bytes.Buffer
+	if err := c4fs.SaveSnapshot(&buf, SnapshotBinary); err != nil {
+		t.Fatalf("SaveSnapshot failed: %v", err)
+	}
+
+	restored, err := OpenSnapshot(&buf, adapter)
+	if err != nil {
+		t.Fatalf("OpenSnapshot failed: %v", err)
+	}
+	if n := len(restored.Base().Entries); n != 2 {
+		t.Errorf("Expected 2 entries without shadowed duplicates, got %d", n)
+	}
+	if data, _ := restored.ReadFile("a.txt"); string(data) != "layer a" {
+		t.Errorf("a.txt: got %q, want %q", data, "layer a")
+	}
+	if restored.Exists("b.txt") {
+		t.Error("Tombstoned b.txt present in snapshot")
+	}
+	if data, _ := restored.ReadFile("c.txt"); string(data) != "layer c" {
+		t.Errorf("c.txt: got %q, want %q", data, "layer c")
+	}
+}
@@ -0,0 +1,217 @@
+package c4fs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/c4m"
+)
+
+// SnapshotSink persists the snapshots taken by a Snapshotter.
+type SnapshotSink interface {
+	// Save persists m, the filesystem as flattened at time at, and
+	// returns its ID.
+	Save(ctx context.Context, m *c4m.Manifest, at time.Time) (c4.ID, error)
+
+	// Prune deletes all but the newest keep snapshots.
+	Prune(ctx context.Context, keep int) error
+}
+
+// SnapshotterOptions configure a Snapshotter.
+type SnapshotterOptions struct {
+	// Keep is the number of snapshots to retain; older ones are pruned
+	// after each successful snapshot. Zero keeps them all.
+	Keep int
+}
+
+// SnapshotStatus reports a Snapshotter's progress, for monitoring.
+type SnapshotStatus struct {
+	LastSuccess time.Time // When the last snapshot was saved
+	LastID      c4.ID     // ID of the last snapshot saved
+	LastError   error     // Error of the last failed attempt, nil after a success
+	LastErrorAt time.Time // When the last attempt failed
+	Next        time.Time // When Run takes the next snapshot; zero if not running
+}
+
+// Snapshotter takes snapshots of a filesystem on a schedule and saves them
+// to a sink, pruning old ones. It runs the loop every embedder would
+// otherwise write around Flatten.
+type Snapshotter struct {
+	fsys     *FS
+	schedule Schedule
+	sink     SnapshotSink
+	opts     SnapshotterOptions
+
+	snap   sync.Mutex // serializes snapshots
+	mu     sync.Mutex // guards status
+	status SnapshotStatus
+}
+
+// NewSnapshotter returns a snapshotter saving snapshots of fsys to sink at
+// the times given by schedule. Call Run to start it.
+func NewSnapshotter(fsys *FS, schedule Schedule, sink SnapshotSink, opts SnapshotterOptions) *Snapshotter {
+	return &Snapshotter{fsys: fsys, schedule: schedule, sink: sink, opts: opts}
+}
+
+// Run takes a snapshot at each time of the schedule until ctx is done or
+// the schedule ends. Failed snapshots do not stop it; they are reported by
+// Status. Run returns ctx.Err(), or nil when the schedule ends.
+func (s *Snapshotter) Run(ctx context.Context) error {
+	defer s.setNext(time.Time{})
+	for {
+		next := s.schedule.Next(time.Now())
+		if next.IsZero() {
+			return nil
+		}
+		s.setNext(next)
+
+		t := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+			s.Snapshot(ctx)
+		}
+	}
+}
+
+// Snapshot takes a snapshot now, saves it and prunes old snapshots. The
+// outcome is also recorded in Status.
+func (s *Snapshotter) Snapshot(ctx context.Context) (c4.ID, error) {
+	s.snap.Lock()
+	defer s.snap.Unlock()
+
+	at := time.Now().UTC()
+	var id c4.ID
+	m, err := s.fsys.Snapshot()
+	if err == nil {
+		id, err = s.sink.Save(ctx, m, at)
+	}
+	if err == nil && s.opts.Keep > 0 {
+		if perr := s.sink.Prune(ctx, s.opts.Keep); perr != nil {
+			err = fmt.Errorf("snapshot %s saved, pruning failed: %w", id, perr)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !id.IsNil() {
+		s.status.LastSuccess, s.status.LastID = at, id
+	}
+	if err != nil {
+		s.status.LastError, s.status.LastErrorAt = err, at
+	} else {
+		s.status.LastError = nil
+	}
+	return id, err
+}
+
+// Status returns the outcome of the latest snapshots.
+func (s *Snapshotter) Status() SnapshotStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
+
+func (s *Snapshotter) setNext(t time.Time) {
+	s.mu.Lock()
+	s.status.Next = t
+	s.mu.Unlock()
+}
+
+// RegistrySink is a SnapshotSink storing snapshots in a registry, each
+// under a reference named by a prefix and the snapshot time, such as
+// "backups/20240301T120000Z". Pruning deletes the references of old
+// snapshots; the manifests stay in the store until garbage collected.
+type RegistrySink struct {
+	reg    *Registry
+	prefix string
+}
+
+// registrySinkTime formats snapshot times in reference names. It sorts
+// chronologically and is a valid file name on every platform.
+const registrySinkTime = "20060102T150405Z"
+
+// NewRegistrySink returns a sink storing snapshots in reg under references
+// named prefix/<time>.
+func NewRegistrySink(reg *Registry, prefix string) *RegistrySink {
+	return &RegistrySink{reg: reg, prefix: strings.TrimSuffix(prefix, "/")}
+}
+
+// Save stores m and points a reference named after at to it. A snapshot
+// taken in the same second as the previous one replaces it.
+func (r *RegistrySink) Save(ctx context.Context, m *c4m.Manifest, at time.Time) (c4.ID, error) {
+	id, err := r.reg.Put(m)
+	if err != nil {
+		return c4.ID{}, err
+	}
+	if err := r.reg.SetRef(r.prefix+"/"+at.UTC().Format(registrySinkTime), id); err != nil {
+		return c4.ID{}, err
+	}
+	return id, nil
+}
+
+// Prune deletes the references of all but the newest keep snapshots.
+func (r *RegistrySink) Prune(ctx context.Context, keep int) error {
+	names, err := r.Snapshots()
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for len(names) > keep {
+		if err := r.reg.DeleteRef(names[0]); err != nil {
+			errs = append(errs, err)
+		}
+		names = names[1:]
+	}
+	return errors.Join(errs...)
+}
+
+// Snapshots returns the reference names of the stored snapshots, oldest
+// first.
+func (r *RegistrySink) Snapshots() ([]string, error) {
+	refs, err := r.reg.Refs()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for name := range refs {
+		stamp, ok := strings.CutPrefix(name, r.prefix+"/")
+		if !ok {
+			continue
+		}
+		if _, err := time.Parse(registrySinkTime, stamp); err == nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Snapshot loads the snapshot a reference returned by Snapshots points at.
+func (r *RegistrySink) Snapshot(name string) (*c4m.Manifest, time.Time, error) {
+	stamp, ok := strings.CutPrefix(name, r.prefix+"/")
+	if !ok {
+		return nil, time.Time{}, fmt.Errorf("snapshot %q: %w", name, ErrRefNotFound)
+	}
+	taken, err := time.Parse(registrySinkTime, stamp)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("snapshot %q: %w", name, err)
+	}
+	id, err := r.reg.Ref(name)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	m, err := r.reg.Get(id)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return m, taken, nil
+}
@@ -0,0 +1,123 @@
+package c4fs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/c4m"
+)
+
+func TestRegistrySink(t *testing.T) {
+	r := newTestRegistry(t)
+	r.SetRef("other", c4.ID{1})
+	sink := NewRegistrySink(r, "backups/")
+	ctx := context.Background()
+
+	fsys := New(r.Store())
+	start := time.Date(2024, 3, 6, 10, 0, 0, 0, time.UTC)
+	var ids []c4.ID
+	for i := range 4 {
+		fsys.WriteFile("n.txt", []byte{byte(i)}, 0644)
+		id, err := sink.Save(ctx, fsys.Flatten(), start.Add(time.Duration(i)*time.Hour))
+		if err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := sink.Prune(ctx, 2); err != nil {
+		t.Fatalf("Prune failed: %v", err)
+	}
+
+	names, err := sink.Snapshots()
+	if err != nil {
+		t.Fatalf("Snapshots failed: %v", err)
+	}
+	want := []string{"backups/20240306T120000Z", "backups/20240306T130000Z"}
+	if len(names) != 2 || names[0] != want[0] || names[1] != want[1] {
+		t.Fatalf("Snapshots = %v, want %v", names, want)
+	}
+	if id, _ := r.Ref(names[1]); id != ids[3] {
+		t.Errorf("newest snapshot points at %s, want %s", id, ids[3])
+	}
+	if _, err := r.Ref("other"); err != nil {
+		t.Errorf("Prune removed an unrelated reference: %v", err)
+	}
+}
+
+// failingSink fails to save while err is set.
+type failingSink struct {
+	err   error
+	saved int
+}
+
+func (s *failingSink) Save(ctx context.Context, m *c4m.Manifest, at time.Time) (c4.ID, error) {
+	if s.err != nil {
+		return c4.ID{}, s.err
+	}
+	s.saved++
+	return SnapshotID(m), nil
+}
+
+func (s *failingSink) Prune(ctx context.Context, keep int) error {
+	return nil
+}
+
+func TestSnapshotterStatus(t *testing.T) {
+	fsys := New(NewStoreAdapter(newSyncRAM()))
+	fsys.WriteFile("a.txt", []byte("a"), 0644)
+	sink := &failingSink{err: errors.New("sink down")}
+	s := NewSnapshotter(fsys, Every(time.Hour), sink, SnapshotterOptions{Keep: 3})
+	ctx := context.Background()
+
+	if _, err := s.Snapshot(ctx); err == nil {
+		t.Fatal("Snapshot succeeded with the sink down")
+	}
+	st := s.Status()
+	if st.LastError == nil || st.LastErrorAt.IsZero() || !st.LastSuccess.IsZero() {
+		t.Errorf("status after failure = %+v", st)
+	}
+
+	sink.err = nil
+	id, err := s.Snapshot(ctx)
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	st = s.Status()
+	if st.LastError != nil || st.LastID != id || st.LastSuccess.IsZero() {
+		t.Errorf("status after success = %+v", st)
+	}
+	if id != SnapshotID(fsys.Flatten()) {
+		t.Errorf("snapshot %s is not the flattened filesystem", id)
+	}
+}
+
+func TestSnapshotterRun(t *testing.T) {
+	fsys := New(NewStoreAdapter(newSyncRAM()))
+	sink := &failingSink{}
+	s := NewSnapshotter(fsys, Every(10*time.Millisecond), sink, SnapshotterOptions{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- s.Run(ctx) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for s.Status().LastSuccess.IsZero() {
+		if time.Now().After(deadline) {
+			t.Fatal("Run took no snapshot")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if s.Status().Next.IsZero() {
+		t.Error("Status.Next is zero while running")
+	}
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Run = %v, want context.Canceled", err)
+	}
+	if !s.Status().Next.IsZero() {
+		t.Error("Status.Next is set after Run returned")
+	}
+}
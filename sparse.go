@@ -0,0 +1,97 @@
+package c4fs
+
+import (
+	"path"
+	"strings"
+
+	"github.com/Avalanche-io/c4/c4m"
+)
+
+// WithSparse shows only the subtrees of the base manifest selected by
+// patterns, like a sparse checkout: paths relative to the root in the
+// syntax of path.Match, such as "shots/sh010" or "shots/*/plates". A
+// selected directory brings all its contents, and the directories leading
+// to a selection are shown so it can be reached; everything else in the
+// base is hidden. Hidden entries are dropped as the base is loaded, so
+// OpenSnapshot never indexes them or keeps them in memory. Malformed
+// patterns select nothing.
+//
+// The filesystem works as usual within its view, and Flatten and
+// snapshots cover only the view. To carry changes back to the full
+// snapshot, apply the layer to it, as with New(store, WithBase(full),
+// WithLayer(sparse.Layer())). Clones and bases swapped in by a Mirror are
+// filtered the same way.
+func WithSparse(patterns ...string) Option {
+	return func(o *options) {
+		o.sparse = append(o.sparse, patterns...)
+	}
+}
+
+// sparseFilter selects the base entries shown by a sparse filesystem.
+type sparseFilter struct {
+	patterns []string
+	parts    [][]string // patterns split into path elements
+}
+
+// newSparseFilter returns the filter for patterns, or nil if there are
+// none.
+func newSparseFilter(patterns []string) *sparseFilter {
+	if len(patterns) == 0 {
+		return nil
+	}
+	f := &sparseFilter{}
+	for _, p := range patterns {
+		p = strings.Trim(cleanPath(p), "/")
+		f.patterns = append(f.patterns, p)
+		f.parts = append(f.parts, strings.Split(p, "/"))
+	}
+	return f
+}
+
+// keeps reports whether e is shown: it or a directory containing it is
+// selected, or it is a directory leading to a selection.
+func (f *sparseFilter) keeps(e *c4m.Entry) bool {
+	for dir := e.Name; dir != ""; dir = parentPath(dir) {
+		for _, p := range f.patterns {
+			if ok, _ := path.Match(p, dir); ok {
+				return true
+			}
+		}
+	}
+	if !e.IsDir() {
+		return false
+	}
+	depth := strings.Count(e.Name, "/") + 1
+	for _, parts := range f.parts {
+		if depth < len(parts) {
+			if ok, _ := path.Match(strings.Join(parts[:depth], "/"), e.Name); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// apply returns the entries of m that f keeps, or m itself if f is nil.
+func (f *sparseFilter) apply(m *c4m.Manifest) *c4m.Manifest {
+	if f == nil {
+		return m
+	}
+	cp := *m
+	cp.Entries = nil
+	for _, e := range m.Entries {
+		if f.keeps(e) {
+			cp.Entries = append(cp.Entries, e)
+		}
+	}
+	return &cp
+}
+
+// Sparse returns the patterns given to WithSparse, or nil if the
+// filesystem shows the whole base.
+func (c4fs *FS) Sparse() []string {
+	if c4fs.sparse == nil {
+		return nil
+	}
+	return append([]string(nil), c4fs.sparse.patterns...)
+}
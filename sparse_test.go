@@ -0,0 +1,72 @@
+package c4fs
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"slices"
+	"testing"
+
+	"github.com/Avalanche-io/c4/store"
+)
+
+func TestSparse(t *testing.T) {
+	s := NewStoreAdapter(store.NewRAM())
+	full := New(s)
+	for _, dir := range []string{"shots/sh010/plates", "shots/sh010/comp", "shots/sh020/plates", "assets/chars"} {
+		full.MkdirAll(dir, 0755)
+	}
+	for _, name := range []string{"shots/sh010/plates/a.exr", "shots/sh010/comp/v1.nk", "shots/sh020/plates/b.exr", "assets/chars/hero.usd", "README"} {
+		full.WriteFile(name, []byte(name), 0644)
+	}
+	m := full.Flatten()
+
+	shown := []string{"shots", "shots/sh010", "shots/sh010/plates", "shots/sh010/plates/a.exr", "shots/sh020", "shots/sh020/plates", "shots/sh020/plates/b.exr"}
+	check := func(t *testing.T, fsys *FS) {
+		t.Helper()
+		var names []string
+		for e := range fsys.Entries() {
+			names = append(names, e.Name)
+		}
+		slices.Sort(names)
+		if !slices.Equal(names, shown) {
+			t.Errorf("Entries = %v, want %v", names, shown)
+		}
+		for _, name := range []string{"README", "assets", "shots/sh010/comp"} {
+			if _, err := fsys.Stat(name); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("Stat(%s) = %v, want ErrNotExist", name, err)
+			}
+		}
+		if data, err := fsys.ReadFile("shots/sh020/plates/b.exr"); err != nil || string(data) != "shots/sh020/plates/b.exr" {
+			t.Errorf("ReadFile = %q, %v", data, err)
+		}
+	}
+
+	sparse := New(s, WithBase(m), WithSparse("shots/*/plates"))
+	check(t, sparse)
+	check(t, sparse.Clone())
+	if got := sparse.Sparse(); !slices.Equal(got, []string{"shots/*/plates"}) {
+		t.Errorf("Sparse = %v", got)
+	}
+
+	// Binary snapshots are filtered as they are read
+	var buf bytes.Buffer
+	if err := WriteSnapshot(&buf, m, SnapshotBinary); err != nil {
+		t.Fatal(err)
+	}
+	opened, err := OpenSnapshot(&buf, s, WithSparse("/shots/*/plates/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, opened)
+
+	// Changes made in the view carry back to the full snapshot
+	sparse.WriteFile("shots/sh010/plates/c.exr", []byte("c"), 0644)
+	sparse.Remove("shots/sh020/plates/b.exr")
+	merged := New(s, WithBase(m), WithLayer(sparse.Layer()))
+	for name, want := range map[string]error{"shots/sh010/plates/c.exr": nil, "shots/sh010/comp/v1.nk": nil, "README": nil, "shots/sh020/plates/b.exr": fs.ErrNotExist} {
+		if _, err := merged.Stat(name); !errors.Is(err, want) {
+			t.Errorf("Stat(%s) after merge = %v, want %v", name, err, want)
+		}
+	}
+}
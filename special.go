@@ -0,0 +1,121 @@
+package c4fs
+
+import (
+	"errors"
+	"io/fs"
+	"time"
+
+	"github.com/Avalanche-io/c4/c4m"
+)
+
+// Special files are devices, named pipes and sockets. They have no
+// content: their entries hold only a mode and times, and the number of a
+// device is kept in its EntryMeta. They appear in listings, Lstat and
+// snapshots, and ImportDir and ExportDir copy them, but they cannot be
+// opened.
+
+// isSpecial reports whether mode is that of a special file.
+func isSpecial(mode fs.FileMode) bool {
+	return mode&(fs.ModeDevice|fs.ModeCharDevice|fs.ModeNamedPipe|fs.ModeSocket) != 0
+}
+
+// specialError is returned when opening a special file.
+func specialError(op, name string) error {
+	return &fs.PathError{Op: op, Path: name, Err: errors.ErrUnsupported}
+}
+
+// Mknod creates the special file name. The type bits of mode must be
+// fs.ModeNamedPipe, fs.ModeSocket, fs.ModeDevice for a block device, or
+// fs.ModeDevice|fs.ModeCharDevice for a character device; dev is the
+// device number, as the platform encodes it, and is ignored for other
+// types.
+func (c4fs *FS) Mknod(name string, mode fs.FileMode, dev uint64) error {
+	switch mode.Type() {
+	case fs.ModeNamedPipe, fs.ModeSocket:
+		dev = 0
+	case fs.ModeDevice, fs.ModeDevice | fs.ModeCharDevice:
+	default:
+		return &fs.PathError{Op: "mknod", Path: name, Err: fs.ErrInvalid}
+	}
+
+	c4fs.mu.Lock()
+	defer c4fs.mu.Unlock()
+	if _, err := c4fs.lookup(name); err == nil {
+		return &fs.PathError{Op: "mknod", Path: name, Err: fs.ErrExist}
+	}
+	c4fs.mknodLocked(cleanPath(name), mode, dev, time.Now().UTC())
+	return nil
+}
+
+// mknodLocked adds the special file name and returns its entry.
+// The caller must hold c4fs.mu for writing.
+func (c4fs *FS) mknodLocked(name string, mode fs.FileMode, dev uint64, mtime time.Time) *c4m.Entry {
+	entry := &c4m.Entry{
+		Mode:      mode.Type() | mode.Perm(),
+		Timestamp: mtime,
+		Name:      name,
+	}
+	c4fs.updateEntryInLayer(entry)
+	c4fs.setRdevLocked(entry, dev)
+	return entry
+}
+
+// Rdev returns the device number of the named device file. It does not
+// follow symbolic links, and returns zero for other files.
+func (c4fs *FS) Rdev(name string) (uint64, error) {
+	entry, err := c4fs.lstatEntry(name)
+	if err != nil {
+		return 0, err
+	}
+	c4fs.mu.RLock()
+	defer c4fs.mu.RUnlock()
+	return c4fs.rdevLocked(entry), nil
+}
+
+// rdevLocked returns the device number of e.
+// The caller must hold c4fs.mu.
+func (c4fs *FS) rdevLocked(e *c4m.Entry) uint64 {
+	return c4fs.entryMetaLocked(e).Rdev
+}
+
+// setRdevLocked sets the device number of e.
+// The caller must hold c4fs.mu for writing.
+func (c4fs *FS) setRdevLocked(e *c4m.Entry, dev uint64) {
+	c4fs.updateMetaLocked(e, func(m *EntryMeta) { m.Rdev = dev })
+}
+
+// c4m text writes a character device as "c" only when its mode has
+// fs.ModeCharDevice alone, while Go sets fs.ModeDevice as well. The
+// helpers below convert between the two for text snapshots.
+
+// toTextModes returns m, or a copy of it if it has character devices,
+// with their modes as c4m text expects.
+func toTextModes(m *c4m.Manifest) *c4m.Manifest {
+	const char = fs.ModeDevice | fs.ModeCharDevice
+	out := m
+	for i, e := range m.Entries {
+		if e.Mode&char != char {
+			continue
+		}
+		if out == m {
+			cp := *m
+			cp.Entries = append([]*c4m.Entry(nil), m.Entries...)
+			out = &cp
+		}
+		c := *e
+		c.Mode &^= fs.ModeDevice
+		out.Entries[i] = &c
+	}
+	return out
+}
+
+// fromTextModes sets fs.ModeDevice on the character devices of m, read
+// from c4m text.
+func fromTextModes(m *c4m.Manifest) *c4m.Manifest {
+	for _, e := range m.Entries {
+		if e.Mode&fs.ModeCharDevice != 0 {
+			e.Mode |= fs.ModeDevice
+		}
+	}
+	return m
+}
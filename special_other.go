@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !freebsd && !netbsd
+
+package c4fs
+
+import (
+	"errors"
+	"io/fs"
+)
+
+// mknod reports that special files cannot be created on this platform.
+func mknod(p string, mode fs.FileMode, dev uint64) error {
+	return &fs.PathError{Op: "mknod", Path: p, Err: errors.ErrUnsupported}
+}
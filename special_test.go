@@ -0,0 +1,167 @@
+package c4fs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Avalanche-io/c4/store"
+)
+
+func TestMknod(t *testing.T) {
+	c4fs := New(NewStoreAdapter(store.NewRAM()))
+	const char = fs.ModeDevice | fs.ModeCharDevice
+	if err := c4fs.Mknod("null", char|0666, 0x103); err != nil {
+		t.Fatalf("Mknod failed: %v", err)
+	}
+	if err := c4fs.Mknod("sda", fs.ModeDevice|0660, 0x800); err != nil {
+		t.Fatal(err)
+	}
+	if err := c4fs.Mknod("pipe", fs.ModeNamedPipe|0644, 7); err != nil {
+		t.Fatal(err)
+	}
+	if err := c4fs.Mknod("sock", fs.ModeSocket|0755, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]fs.FileMode{
+		"null": char | 0666,
+		"sda":  fs.ModeDevice | 0660,
+		"pipe": fs.ModeNamedPipe | 0644,
+		"sock": fs.ModeSocket | 0755,
+	} {
+		info, err := c4fs.Lstat(name)
+		if err != nil || info.Mode() != want || info.Size() != 0 {
+			t.Errorf("Lstat(%s) = %v, %v; want %v", name, info.Mode(), err, want)
+		}
+	}
+	if dev, err := c4fs.Rdev("null"); err != nil || dev != 0x103 {
+		t.Errorf("Rdev(null) = %#x, %v", dev, err)
+	}
+	if dev, _ := c4fs.Rdev("pipe"); dev != 0 {
+		t.Errorf("Rdev(pipe) = %#x, want 0", dev)
+	}
+
+	if _, err := c4fs.Open("null"); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("Open of a device = %v, want ErrUnsupported", err)
+	}
+	if _, err := c4fs.ReadFile("pipe"); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("ReadFile of a pipe = %v, want ErrUnsupported", err)
+	}
+	if err := c4fs.Mknod("null", char|0666, 0); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("Mknod of an existing name = %v, want ErrExist", err)
+	}
+	if err := c4fs.Mknod("file", 0644, 0); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("Mknod of a regular file = %v, want ErrInvalid", err)
+	}
+
+	// Changing times, mode or name keeps the device
+	if err := c4fs.Chmod("null", 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := c4fs.Rename("null", "dev-null"); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := c4fs.Lstat("dev-null"); info.Mode() != char|0600 {
+		t.Errorf("mode after Chmod = %v", info.Mode())
+	}
+	if dev, _ := c4fs.Rdev("dev-null"); dev != 0x103 {
+		t.Errorf("Rdev after Chmod and Rename = %#x", dev)
+	}
+}
+
+func TestSpecialSnapshot(t *testing.T) {
+	c4fs := New(NewStoreAdapter(store.NewRAM()))
+	const char = fs.ModeDevice | fs.ModeCharDevice
+	if err := c4fs.Mknod("tty", char|0620, 0x8801); err != nil {
+		t.Fatal(err)
+	}
+	if err := c4fs.Mknod("fifo", fs.ModeNamedPipe|0600, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range []SnapshotFormat{SnapshotBinary, SnapshotText} {
+		var buf bytes.Buffer
+		if err := c4fs.SaveSnapshot(&buf, format); err != nil {
+			t.Fatal(err)
+		}
+		restored, err := OpenSnapshot(&buf, c4fs.Store())
+		if err != nil {
+			t.Fatalf("format %d: %v", format, err)
+		}
+		if info, err := restored.Lstat("tty"); err != nil || info.Mode() != char|0620 {
+			t.Errorf("format %d: Lstat(tty) = %v, %v", format, info.Mode(), err)
+		}
+		if info, err := restored.Lstat("fifo"); err != nil || info.Mode() != fs.ModeNamedPipe|0600 {
+			t.Errorf("format %d: Lstat(fifo) = %v, %v", format, info.Mode(), err)
+		}
+		// Text snapshots keep device numbers in the store
+		if dev, _ := restored.Rdev("tty"); dev != 0x8801 {
+			t.Errorf("format %d: Rdev(tty) = %#x, want 0x8801", format, dev)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := c4fs.ExportJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `"type":"chardev"`) || !strings.Contains(buf.String(), `"type":"fifo"`) {
+		t.Errorf("ExportJSON = %s", buf.String())
+	}
+	m, err := ReadManifestJSON(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range m.Entries {
+		if e.Name == "tty" && e.Mode != char|0620 {
+			t.Errorf("ReadManifestJSON mode of tty = %v", e.Mode)
+		}
+	}
+}
+
+func TestImportExportSpecial(t *testing.T) {
+	src := t.TempDir()
+	writeTree(t, src, map[string]string{"a.txt": "a"})
+	if err := mknod(filepath.Join(src, "fifo"), fs.ModeNamedPipe|0640, 0); err != nil {
+		t.Skipf("named pipes not supported: %v", err)
+	}
+
+	c4fs := New(NewStoreAdapter(store.NewRAM()))
+	if err := c4fs.ImportDir(context.Background(), src, "", ImportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	info, err := c4fs.Lstat("fifo")
+	if err != nil || info.Mode() != fs.ModeNamedPipe|0640 {
+		t.Fatalf("Lstat(fifo) = %v, %v", info, err)
+	}
+
+	skipped := New(NewStoreAdapter(store.NewRAM()))
+	if err := skipped.ImportDir(context.Background(), src, "", ImportOptions{SkipSpecial: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := skipped.Lstat("fifo"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Lstat(fifo) with SkipSpecial = %v, want ErrNotExist", err)
+	}
+
+	dst := t.TempDir()
+	if err := c4fs.ExportDir(context.Background(), "", dst, ExportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	info, err = os.Lstat(filepath.Join(dst, "fifo"))
+	if err != nil || info.Mode() != fs.ModeNamedPipe|0640 {
+		t.Errorf("exported fifo = %v, %v", info, err)
+	}
+
+	dst = t.TempDir()
+	if err := c4fs.ExportDir(context.Background(), "", dst, ExportOptions{SkipSpecial: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(filepath.Join(dst, "fifo")); !os.IsNotExist(err) {
+		t.Errorf("exported fifo with SkipSpecial: %v", err)
+	}
+}
@@ -0,0 +1,29 @@
+//go:build linux || darwin || netbsd
+
+package c4fs
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// mknod creates the local special file p. Sockets cannot be created
+// without a listener and are reported as unsupported.
+func mknod(p string, mode fs.FileMode, dev uint64) error {
+	perm := uint32(mode.Perm())
+	var err error
+	switch {
+	case mode&fs.ModeNamedPipe != 0:
+		err = syscall.Mkfifo(p, perm)
+	case mode&fs.ModeCharDevice != 0:
+		err = syscall.Mknod(p, syscall.S_IFCHR|perm, int(dev))
+	case mode&fs.ModeDevice != 0:
+		err = syscall.Mknod(p, syscall.S_IFBLK|perm, int(dev))
+	default:
+		err = syscall.ENOTSUP
+	}
+	if err != nil {
+		return &fs.PathError{Op: "mknod", Path: p, Err: err}
+	}
+	return nil
+}
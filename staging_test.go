@@ -0,0 +1,94 @@
+package c4fs
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"syscall"
+	"testing"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/store"
+)
+
+func TestStageFile(t *testing.T) {
+	c4fs := New(NewStoreAdapter(store.NewRAM()))
+	content := []byte("delivered asset")
+
+	sf := c4fs.StageFile(0644)
+	if _, err := sf.Write(content); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	// Publishing before Close must fail
+	if err := sf.Publish("asset.bin"); err == nil {
+		t.Error("Publish before Close should fail")
+	}
+
+	if err := sf.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// Content is dehydrated but not yet visible
+	if c4fs.Exists("asset.bin") {
+		t.Error("Staged file visible before Publish")
+	}
+	if want := c4.Identify(bytes.NewReader(content)); sf.ID() != want {
+		t.Errorf("ID: got %s, want %s", sf.ID(), want)
+	}
+	if !c4fs.Store().Has(sf.ID()) {
+		t.Error("Staged content not in store after Close")
+	}
+
+	if err := sf.Publish("asset.bin"); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	data, err := c4fs.ReadFile("asset.bin")
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Errorf("Published content: got %q, want %q", data, content)
+	}
+	if size, _ := c4fs.Size("asset.bin"); size != int64(len(content)) {
+		t.Errorf("Published size: got %d, want %d", size, len(content))
+	}
+}
+
+func TestStagedFilePublishChecksPath(t *testing.T) {
+	c4fs := New(NewStoreAdapter(store.NewRAM()))
+	c4fs.MkdirAll("d/sub", 0755)
+	c4fs.WriteFile("d/sub/file", []byte("kept"), 0644)
+	c4fs.WriteFile("plain", []byte("plain"), 0644)
+
+	sf := c4fs.StageFile(0644)
+	sf.WriteString("staged")
+	sf.Close()
+
+	for _, tt := range []struct {
+		name string
+		want error
+	}{
+		{"d", syscall.EISDIR},
+		{"nodir/x/y", fs.ErrNotExist},
+		{"plain/x", syscall.ENOTDIR},
+	} {
+		if err := sf.Publish(tt.name); !errors.Is(err, tt.want) {
+			t.Errorf("Publish(%q) = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+	if !c4fs.IsDir("d") || !c4fs.Exists("d/sub/file") {
+		t.Error("Publish over a directory changed it")
+	}
+	if c4fs.Exists("nodir/x/y") {
+		t.Error("Publish created an entry without a parent directory")
+	}
+
+	if err := sf.Publish("d/sub/new"); err != nil {
+		t.Errorf("Publish(d/sub/new) failed: %v", err)
+	}
+	c4fs.Close()
+	if err := sf.Publish("late"); !errors.Is(err, ErrFSClosed) {
+		t.Errorf("Publish after Close = %v, want ErrFSClosed", err)
+	}
+}
@@ -0,0 +1,38 @@
+package c4fs
+
+import (
+	"io/fs"
+	"syscall"
+	"time"
+)
+
+// fileAtime returns the access time of a local file, or the zero time if
+// it is not known.
+func fileAtime(info fs.FileInfo) time.Time {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return time.Time{}
+	}
+	return time.Unix(st.Atim.Unix()).UTC()
+}
+
+// fileInode returns the device and inode numbers of a local file with
+// more than one link, for detecting hard links. It reports false for
+// files with a single link or if the numbers are not known.
+func fileInode(info fs.FileInfo) (inode, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || st.Nlink < 2 {
+		return inode{}, false
+	}
+	return inode{dev: uint64(st.Dev), ino: uint64(st.Ino)}, true
+}
+
+// fileRdev returns the device number of a local device file, or zero if
+// it is not known.
+func fileRdev(info fs.FileInfo) uint64 {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0
+	}
+	return uint64(st.Rdev)
+}
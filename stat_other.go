@@ -0,0 +1,24 @@
+//go:build !linux && !darwin && !freebsd && !netbsd
+
+package c4fs
+
+import (
+	"io/fs"
+	"time"
+)
+
+// fileAtime returns the zero time: access times are not read on this
+// platform.
+func fileAtime(info fs.FileInfo) time.Time {
+	return time.Time{}
+}
+
+// fileInode reports false: hard links are not detected on this platform.
+func fileInode(info fs.FileInfo) (inode, bool) {
+	return inode{}, false
+}
+
+// fileRdev returns zero: device numbers are not read on this platform.
+func fileRdev(info fs.FileInfo) uint64 {
+	return 0
+}
@@ -2,17 +2,123 @@ package c4fs
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha512"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/Avalanche-io/c4"
 	"github.com/Avalanche-io/c4/store"
 )
 
+// BlobLister is implemented by stores that can enumerate their contents.
+// The c4/store.Store interface has no listing operation, so tools that need
+// to walk every blob (migration, export, scrubbing) check for this interface.
+type BlobLister interface {
+	ListIDs() ([]c4.ID, error)
+}
+
+// ListIDs returns the IDs of every blob in s.
+// Stores implementing BlobLister are asked directly; the RAM and Folder
+// stores from c4/store are enumerated natively.
+func ListIDs(s store.Store) ([]c4.ID, error) {
+	switch st := s.(type) {
+	case BlobLister:
+		return st.ListIDs()
+	case *store.RAM:
+		ids := make([]c4.ID, 0, len(*st))
+		for id := range *st {
+			ids = append(ids, id)
+		}
+		return ids, nil
+	case store.Folder:
+		entries, err := os.ReadDir(string(st))
+		if err != nil {
+			return nil, err
+		}
+		var ids []c4.ID
+		for _, e := range entries {
+			if e.IsDir() {
+				continue
+			}
+			id, err := c4.Parse(e.Name())
+			if err != nil {
+				continue
+			}
+			ids = append(ids, id)
+		}
+		return ids, nil
+	}
+	return nil, fmt.Errorf("store %T cannot list its contents", s)
+}
+
+// Compacter is implemented by stores that can reclaim the space held by
+// removed blobs, such as PackStore. Compact returns the number of bytes
+// reclaimed.
+type Compacter interface {
+	Compact() (int64, error)
+}
+
+// CompactStore reclaims the space held by removed blobs in s and returns
+// the number of bytes reclaimed. Stores implementing Compacter are asked
+// directly. The RAM and Folder stores from c4/store free the space of a
+// blob when it is removed and keep no directories to prune, so there is
+// nothing to do for them.
+func CompactStore(s store.Store) (int64, error) {
+	switch st := s.(type) {
+	case Compacter:
+		return st.Compact()
+	case *store.RAM, store.Folder:
+		return 0, nil
+	}
+	return 0, fmt.Errorf("store %T cannot be compacted: %w", s, errors.ErrUnsupported)
+}
+
+// RangeOpener is implemented by stores that can read part of a blob without
+// transferring the rest, for example using HTTP range requests. A negative
+// length reads to the end of the blob; an offset past the end yields an
+// empty reader.
+type RangeOpener interface {
+	OpenRange(id c4.ID, off, length int64) (io.ReadCloser, error)
+}
+
+// BlobInfo describes a stored blob.
+type BlobInfo struct {
+	Size    int64     // Length of the content in bytes
+	ModTime time.Time // When the blob was stored; zero if unknown
+}
+
+// BlobStater is implemented by stores that can describe a blob without
+// reading it, for example with os.Stat or an HTTP HEAD request.
+type BlobStater interface {
+	StatBlob(id c4.ID) (BlobInfo, error)
+}
+
+// BlobChecker is implemented by stores that can check for a blob without
+// opening it, for example with a HEAD request or an index lookup. Has uses
+// it, which makes the deduplication checks of bulk imports much cheaper.
+type BlobChecker interface {
+	HasBlob(id c4.ID) bool
+}
+
+// HealthChecker is implemented by stores that can report whether their
+// backend is reachable. Services embedding c4fs can wire this into
+// readiness probes via FS.Ping.
+type HealthChecker interface {
+	Ping(ctx context.Context) error
+}
+
 // StoreAdapter wraps a c4/store.Store and provides high-level Put/Get operations
 // that compute C4 IDs from content.
 type StoreAdapter struct {
-	store store.Store
+	store    store.Store
+	sessions ingestSessions
 }
 
 // NewStoreAdapter creates a StoreAdapter from a c4/store.Store.
@@ -20,43 +126,214 @@ func NewStoreAdapter(s store.Store) *StoreAdapter {
 	return &StoreAdapter{store: s}
 }
 
+// PutIfAbsenter is implemented by stores, typically remote ones, that can
+// check for a blob and upload it in a single operation. PutIfAbsent reads
+// r only if the store does not already hold id, and reports whether the
+// content was stored.
+type PutIfAbsenter interface {
+	PutIfAbsent(id c4.ID, r io.Reader) (bool, error)
+}
+
+// putMemoryLimit is the amount of content Put holds in memory while
+// computing its ID; larger content is spooled to a temporary file.
+const putMemoryLimit = 4 << 20
+
 // Put stores content and returns its C4 ID.
-// The C4 ID is computed from the content using SHA-512.
-// If the content already exists in the store, it returns the ID without error.
+// The C4 ID is computed from the content using SHA-512 before anything is
+// sent to the store, so content the store already holds is never
+// transferred. If the content already exists in the store, it returns the
+// ID without error.
 func (s *StoreAdapter) Put(r io.Reader) (c4.ID, error) {
-	// Read content to compute C4 ID
-	data, err := io.ReadAll(r)
+	sp, err := spool(r)
 	if err != nil {
-		return c4.ID{}, fmt.Errorf("failed to read content: %w", err)
+		return c4.ID{}, err
 	}
+	defer sp.Close()
 
-	// Compute C4 ID from content
-	id := c4.Identify(bytes.NewReader(data))
+	_, err = s.putIfAbsent(sp.id, sp.reader(), sp.size(), false)
+	return sp.id, err
+}
 
+// PutExpecting stores content only if it hashes to expected.
+// If the computed C4 ID differs, nothing is stored and an *IDMismatchError
+// is returned.
+func (s *StoreAdapter) PutExpecting(r io.Reader, expected c4.ID) (c4.ID, error) {
+	sp, err := spool(r)
+	if err != nil {
+		return c4.ID{}, err
+	}
+	defer sp.Close()
+
+	if sp.id != expected {
+		return sp.id, &IDMismatchError{Expected: expected, Actual: sp.id}
+	}
+	_, err = s.putIfAbsent(sp.id, sp.reader(), sp.size(), false)
+	return sp.id, err
+}
+
+// PutIfAbsent stores the content of r under id unless the store already
+// holds it, in which case r is not read. It reports whether the content was
+// stored. The content is verified against id while it is written; on a
+// mismatch the blob is removed and an *IDMismatchError is returned.
+//
+// Stores implementing PutIfAbsenter are asked directly; for other stores
+// the check and the write are separate operations.
+//
+// Ingest sessions count the bytes skipped only if r reports its length
+// with a Len method, as bytes.Reader does.
+func (s *StoreAdapter) PutIfAbsent(id c4.ID, r io.Reader) (bool, error) {
+	return s.putIfAbsent(id, r, sizeOf(r), true)
+}
+
+// putIfAbsent implements PutIfAbsent for content of size bytes, or of
+// unknown size if negative, and counts it in the ingest sessions. Unless
+// verify is set, the content is known to hash to id and is not hashed
+// again unless the store does it itself.
+func (s *StoreAdapter) putIfAbsent(id c4.ID, r io.Reader, size int64, verify bool) (bool, error) {
+	cr := &countingReader{r: r}
+	stored, err := s.storeIfAbsent(id, cr, verify)
+	if err != nil {
+		return false, err
+	}
+	if stored {
+		size = cr.n
+	}
+	s.recordPut(stored, size)
+	return stored, nil
+}
+
+// storeIfAbsent writes r to the store unless it already holds id.
+func (s *StoreAdapter) storeIfAbsent(id c4.ID, r io.Reader, verify bool) (bool, error) {
+	if p, ok := s.store.(PutIfAbsenter); ok {
+		touchBlob(s.store, id)
+		return p.PutIfAbsent(id, r)
+	}
 	// Check if already exists (deduplication)
 	if s.Has(id) {
-		return id, nil
+		return false, nil
+	}
+	err := s.write(id, r, verify)
+	if errors.Is(err, os.ErrExist) {
+		// A concurrent writer stored the same content first
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// write copies r to the store under id, optionally checking that it
+// hashes to id.
+func (s *StoreAdapter) write(id c4.ID, r io.Reader, verify bool) error {
+	var h hash.Hash
+	if verify {
+		h = sha512.New()
+		r = io.TeeReader(r, h)
 	}
 
 	// Create write handle in store
 	wc, err := s.store.Create(id)
 	if err != nil {
-		return c4.ID{}, fmt.Errorf("failed to create in store: %w", err)
+		return fmt.Errorf("failed to create in store: %w", err)
 	}
 
 	// Write content
-	_, err = io.Copy(wc, bytes.NewReader(data))
+	_, err = io.Copy(wc, r)
 	if err != nil {
 		wc.Close()
-		return c4.ID{}, fmt.Errorf("failed to write content: %w", err)
+		return fmt.Errorf("failed to write content: %w", err)
 	}
 
 	// Close writer
 	if err := wc.Close(); err != nil {
-		return c4.ID{}, fmt.Errorf("failed to close writer: %w", err)
+		return fmt.Errorf("failed to close writer: %w", err)
 	}
 
-	return id, nil
+	if verify {
+		if actual := digestID(h); actual != id {
+			s.store.Remove(id)
+			return &IDMismatchError{Expected: id, Actual: actual}
+		}
+	}
+	return nil
+}
+
+// spooled is content read ahead of storing it, so its ID is known before
+// the store is contacted.
+type spooled struct {
+	id   c4.ID
+	data []byte
+	file *os.File // content beyond putMemoryLimit, or nil
+}
+
+// spool reads r to the end, computing its ID. Content up to
+// putMemoryLimit is kept in memory, larger content in a temporary file.
+func spool(r io.Reader) (*spooled, error) {
+	h := sha512.New()
+	tr := io.TeeReader(r, h)
+	data, err := io.ReadAll(io.LimitReader(tr, putMemoryLimit+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read content: %w", err)
+	}
+	if len(data) <= putMemoryLimit {
+		return &spooled{id: digestID(h), data: data}, nil
+	}
+
+	f, err := os.CreateTemp("", "c4fs-put-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to spool content: %w", err)
+	}
+	sp := &spooled{file: f}
+	if _, err := f.Write(data); err != nil {
+		sp.Close()
+		return nil, fmt.Errorf("failed to spool content: %w", err)
+	}
+	if _, err := io.Copy(f, tr); err != nil {
+		sp.Close()
+		return nil, fmt.Errorf("failed to read content: %w", err)
+	}
+	sp.id = digestID(h)
+	return sp, nil
+}
+
+// reader returns a reader of the spooled content from its start.
+func (sp *spooled) reader() io.Reader {
+	if sp.file == nil {
+		return bytes.NewReader(sp.data)
+	}
+	return io.NewSectionReader(sp.file, 0, sp.size())
+}
+
+// size returns the length of the spooled content.
+func (sp *spooled) size() int64 {
+	if sp.file == nil {
+		return int64(len(sp.data))
+	}
+	size, _ := sp.file.Seek(0, io.SeekCurrent)
+	return size
+}
+
+// Close removes the spool file, if any.
+func (sp *spooled) Close() error {
+	if sp.file == nil {
+		return nil
+	}
+	sp.file.Close()
+	return os.Remove(sp.file.Name())
+}
+
+// digestID returns the C4 ID of the content written to a SHA-512 hash.
+func digestID(h hash.Hash) c4.ID {
+	return c4.Digest(h.Sum(nil)).ID()
+}
+
+// IDMismatchError is returned when content does not hash to the C4 ID
+// the caller declared for it.
+type IDMismatchError struct {
+	Expected c4.ID
+	Actual   c4.ID
+}
+
+func (e *IDMismatchError) Error() string {
+	return fmt.Sprintf("c4 id mismatch: expected %s, got %s", e.Expected, e.Actual)
 }
 
 // Get retrieves content by C4 ID.
@@ -66,8 +343,23 @@ func (s *StoreAdapter) Get(id c4.ID) (io.ReadCloser, error) {
 }
 
 // Has checks if content exists for the given C4 ID.
-// This is a best-effort check - tries to open and immediately close.
+// Stores implementing BlobChecker are asked directly, and the RAM and
+// Folder stores from c4/store are checked natively. Other stores are
+// checked by opening the blob and closing it again. A blob found is kept
+// by garbage collections in progress (see CollectGarbage).
 func (s *StoreAdapter) Has(id c4.ID) bool {
+	touchBlob(s.store, id)
+	switch st := s.store.(type) {
+	case BlobChecker:
+		return st.HasBlob(id)
+	case *store.RAM:
+		_, ok := (*st)[id]
+		return ok
+	case store.Folder:
+		_, err := os.Stat(filepath.Join(string(st), id.String()))
+		return err == nil
+	}
+
 	rc, err := s.store.Open(id)
 	if err != nil {
 		return false
@@ -80,3 +372,134 @@ func (s *StoreAdapter) Has(id c4.ID) bool {
 func (s *StoreAdapter) Delete(id c4.ID) error {
 	return s.store.Remove(id)
 }
+
+// Ping checks that the underlying store is available.
+// Stores that do not implement HealthChecker are assumed to be healthy.
+func (s *StoreAdapter) Ping(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if hc, ok := s.store.(HealthChecker); ok {
+		return hc.Ping(ctx)
+	}
+	return nil
+}
+
+// Offline reports whether the blob is held offline (see Recaller). Stores
+// that do not implement Recaller keep everything online.
+func (s *StoreAdapter) Offline(id c4.ID) bool {
+	if r, ok := s.store.(Recaller); ok {
+		return r.Offline(id)
+	}
+	return false
+}
+
+// Recall brings an offline blob back online (see Recaller). It does
+// nothing for stores that do not implement Recaller.
+func (s *StoreAdapter) Recall(ctx context.Context, id c4.ID) error {
+	if r, ok := s.store.(Recaller); ok {
+		return r.Recall(ctx, id)
+	}
+	return ctx.Err()
+}
+
+// Stat describes the blob with the given ID without reading its content
+// where possible, so callers can check sizes against manifests, account
+// for quota or plan range reads. Stores implementing BlobStater are
+// asked directly, the RAM and Folder stores from c4/store are inspected
+// natively, and other stores' blobs are opened and, unless the reader
+// can report its size, read through to count their bytes. An error
+// wrapping fs.ErrNotExist is returned if the store does not hold id.
+func (s *StoreAdapter) Stat(id c4.ID) (BlobInfo, error) {
+	switch st := s.store.(type) {
+	case BlobStater:
+		return st.StatBlob(id)
+	case *store.RAM:
+		data, ok := (*st)[id]
+		if !ok {
+			return BlobInfo{}, &fs.PathError{Op: "stat", Path: id.String(), Err: fs.ErrNotExist}
+		}
+		return BlobInfo{Size: int64(len(data))}, nil
+	case store.Folder:
+		info, err := os.Stat(filepath.Join(string(st), id.String()))
+		if err != nil {
+			return BlobInfo{}, err
+		}
+		return BlobInfo{Size: info.Size(), ModTime: info.ModTime()}, nil
+	}
+
+	rc, err := s.store.Open(id)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	defer rc.Close()
+	if f, ok := rc.(interface{ Stat() (fs.FileInfo, error) }); ok {
+		if info, err := f.Stat(); err == nil {
+			return BlobInfo{Size: info.Size(), ModTime: info.ModTime()}, nil
+		}
+	}
+	n, err := io.Copy(io.Discard, rc)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	return BlobInfo{Size: n}, nil
+}
+
+// GetRange retrieves length bytes of content starting at off, or everything
+// from off on if length is negative. Stores implementing RangeOpener and the
+// RAM store read only the requested range; readers that can seek, such as
+// the files of a Folder store, are seeked; other stores are read and
+// discarded up to off.
+func (s *StoreAdapter) GetRange(id c4.ID, off, length int64) (io.ReadCloser, error) {
+	if off < 0 {
+		return nil, fmt.Errorf("invalid range offset %d", off)
+	}
+
+	switch st := s.store.(type) {
+	case RangeOpener:
+		return st.OpenRange(id, off, length)
+	case *store.RAM:
+		data, ok := (*st)[id]
+		if !ok {
+			return st.Open(id)
+		}
+		return io.NopCloser(bytes.NewReader(sliceRange(data, off, length))), nil
+	}
+
+	rc, err := s.store.Open(id)
+	if err != nil {
+		return nil, err
+	}
+	if sk, ok := rc.(io.Seeker); ok {
+		if _, err := sk.Seek(off, io.SeekStart); err != nil {
+			rc.Close()
+			return nil, err
+		}
+	} else if _, err := io.CopyN(io.Discard, rc, off); err != nil && err != io.EOF {
+		rc.Close()
+		return nil, err
+	}
+	if length < 0 {
+		return rc, nil
+	}
+	return &limitedReadCloser{Reader: io.LimitReader(rc, length), Closer: rc}, nil
+}
+
+// sliceRange returns the part of data covered by off and length, clamped
+// to its bounds.
+func sliceRange(data []byte, off, length int64) []byte {
+	if off >= int64(len(data)) {
+		return nil
+	}
+	data = data[off:]
+	if length >= 0 && length < int64(len(data)) {
+		data = data[:length]
+	}
+	return data
+}
+
+// limitedReadCloser closes the underlying reader of a limited read.
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,225 @@
+package c4fs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/store"
+)
+
+// syncRAM is a RAM store safe for concurrent use. store.RAM is a plain map,
+// so stress tests need their own locking around it.
+type syncRAM struct {
+	mu  sync.Mutex
+	ram *store.RAM
+}
+
+func newSyncRAM() *syncRAM {
+	return &syncRAM{ram: store.NewRAM()}
+}
+
+func (s *syncRAM) Open(id c4.ID) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := (*s.ram)[id]
+	if !ok {
+		return s.ram.Open(id)
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (s *syncRAM) Create(id c4.ID) (io.WriteCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := (*s.ram)[id]; ok {
+		return s.ram.Create(id)
+	}
+	return &syncRAMWriter{s: s, id: id}, nil
+}
+
+func (s *syncRAM) Remove(id c4.ID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ram.Remove(id)
+}
+
+type syncRAMWriter struct {
+	s   *syncRAM
+	id  c4.ID
+	buf bytes.Buffer
+}
+
+func (w *syncRAMWriter) Write(p []byte) (int, error) { return w.buf.Write(p) }
+
+func (w *syncRAMWriter) Close() error {
+	w.s.mu.Lock()
+	defer w.s.mu.Unlock()
+	(*w.s.ram)[w.id] = w.buf.Bytes()
+	return nil
+}
+
+// TestConcurrentStress runs many goroutines doing mixed operations against
+// one filesystem. It is most useful under -race.
+func TestConcurrentStress(t *testing.T) {
+	workers, ops := 16, 300
+	if testing.Short() {
+		workers, ops = 4, 50
+	}
+
+	adapter := NewStoreAdapter(newSyncRAM())
+	base := New(adapter)
+	for i := 0; i < 20; i++ {
+		base.WriteFile(fmt.Sprintf("base/f%d", i), []byte(fmt.Sprintf("base %d", i)), 0644)
+	}
+	c4fs := New(adapter, WithBase(base.Flatten()))
+
+	dirs := []string{"base", "a", "b", "a/x"}
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(seed))
+			for i := 0; i < ops; i++ {
+				dir := dirs[r.Intn(len(dirs))]
+				name := fmt.Sprintf("%s/f%d", dir, r.Intn(20))
+				other := fmt.Sprintf("%s/f%d", dirs[r.Intn(len(dirs))], r.Intn(20))
+
+				switch r.Intn(12) {
+				case 0, 1:
+					c4fs.MkdirAll(dir, 0755)
+					c4fs.WriteFile(name, []byte(name), 0644)
+				case 2, 3:
+					c4fs.ReadFile(name)
+				case 4:
+					c4fs.Rename(name, other)
+				case 5:
+					c4fs.Remove(name)
+				case 6:
+					c4fs.RemoveAll(dirs[r.Intn(len(dirs))])
+				case 7:
+					c4fs.Flatten()
+				case 8:
+					c4fs.ReadDir(dir)
+				case 9:
+					c4fs.Stat(name)
+					c4fs.Glob(dir + "/*")
+				case 10:
+					c4fs.ReferencedIDs()
+				case 11:
+					if f, err := c4fs.Create(name); err == nil {
+						f.Write([]byte("via handle"))
+						f.Close()
+					}
+				}
+			}
+		}(int64(w))
+	}
+	wg.Wait()
+
+	// The merged view must have one entry per path, and every file it
+	// references must still be in the store
+	seen := make(map[string]bool)
+	for _, e := range c4fs.Flatten().Entries {
+		if seen[e.Name] {
+			t.Errorf("Flatten returned %q more than once", e.Name)
+		}
+		seen[e.Name] = true
+		if e.IsDir() || e.Size <= 0 {
+			continue
+		}
+		if !adapter.Has(e.C4ID) {
+			t.Errorf("Content of %q missing from store", e.Name)
+		}
+	}
+
+	// Directories can be recreated after the churn
+	for _, dir := range dirs {
+		c4fs.RemoveAll(dir)
+		if err := c4fs.MkdirAll(dir, 0755); err != nil {
+			t.Errorf("MkdirAll(%q) after stress failed: %v", dir, err)
+		}
+		if err := c4fs.WriteFile(dir+"/final", []byte("final"), 0644); err != nil {
+			t.Fatalf("WriteFile after stress failed: %v", err)
+		}
+		if data, err := c4fs.ReadFile(dir + "/final"); err != nil || string(data) != "final" {
+			t.Errorf("ReadFile(%q) after stress: got %q, %v", dir+"/final", data, err)
+		}
+	}
+}
+
+// TestConcurrentRenameSameTarget tests that only one of several racing
+// renames onto the same destination succeeds, so no source is lost.
+func TestConcurrentRenameSameTarget(t *testing.T) {
+	const n = 32
+	c4fs := New(NewStoreAdapter(newSyncRAM()))
+	for i := 0; i < n; i++ {
+		c4fs.WriteFile(fmt.Sprintf("src%d", i), []byte(fmt.Sprintf("content %d", i)), 0644)
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, n)
+	start := make(chan struct{})
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			errs[i] = c4fs.Rename(fmt.Sprintf("src%d", i), "dst")
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	succeeded := 0
+	for i, err := range errs {
+		src := fmt.Sprintf("src%d", i)
+		if err == nil {
+			succeeded++
+			continue
+		}
+		if !c4fs.Exists(src) {
+			t.Errorf("Failed rename removed its source %s", src)
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("Expected exactly 1 successful rename, got %d", succeeded)
+	}
+}
+
+// TestConcurrentMkdirAll tests that racing MkdirAll calls for overlapping
+// paths all succeed.
+func TestConcurrentMkdirAll(t *testing.T) {
+	const n = 32
+	c4fs := New(NewStoreAdapter(newSyncRAM()))
+
+	var wg sync.WaitGroup
+	errs := make([]error, n)
+	start := make(chan struct{})
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			errs[i] = c4fs.MkdirAll(fmt.Sprintf("a/b/c/d%d", i%4), 0755)
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("MkdirAll %d failed: %v", i, err)
+		}
+	}
+	for i := 0; i < 4; i++ {
+		if dir := fmt.Sprintf("a/b/c/d%d", i); !c4fs.IsDir(dir) {
+			t.Errorf("%s should be a directory", dir)
+		}
+	}
+}
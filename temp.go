@@ -0,0 +1,178 @@
+package c4fs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"math/rand"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Avalanche-io/c4/c4m"
+)
+
+// tempNamespace is the directory CreateTemp uses when none is given. It is
+// an ordinary directory: only the files and directories CreateTemp itself
+// creates are scratch, so anything else written to it is kept.
+const tempNamespace = "tmp"
+
+// TempDir returns the directory used for temporary files.
+func (c4fs *FS) TempDir() string {
+	return "/" + tempNamespace
+}
+
+// CreateTemp creates a new temporary file in dir, opens it for writing and
+// returns it with its path. If dir is empty, TempDir is used, and missing
+// directories are created. The filename is generated by taking pattern and
+// replacing the last "*" with a random string, as in os.CreateTemp.
+//
+// Temporary files, and the directories CreateTemp created for them, are
+// not included in snapshots and are removed when a Sandbox commits or the
+// filesystem is closed, so frontends can use them for partial uploads
+// without leaking scratch data into commits. Renaming a temporary file
+// makes it an ordinary file, and writing anything else into a directory
+// CreateTemp created makes that directory an ordinary one.
+func (c4fs *FS) CreateTemp(dir, pattern string) (File, string, error) {
+	if dir == "" {
+		dir = c4fs.TempDir()
+	}
+	dir = strings.TrimPrefix(cleanPath(dir), "/")
+
+	if strings.Contains(pattern, "/") {
+		return nil, "", &fs.PathError{
+			Op:   "createtemp",
+			Path: pattern,
+			Err:  fs.ErrInvalid,
+		}
+	}
+	if c4fs.isClosed() {
+		return nil, "", closedError("createtemp", path.Join(dir, pattern))
+	}
+
+	// The file starts empty, so that it exists until it is closed
+	id, err := c4fs.store.Put(bytes.NewReader(nil))
+	if err != nil {
+		return nil, "", &fs.PathError{Op: "createtemp", Path: path.Join(dir, pattern), Err: err}
+	}
+	name, err := c4fs.reserveTemp(dir, pattern, &c4m.Entry{
+		Mode:      0600,
+		Timestamp: time.Now().UTC(),
+		C4ID:      id,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	f, err := newDehydratingFile(c4fs, name, 0600)
+	if err != nil {
+		return nil, "", err
+	}
+	return f, name, nil
+}
+
+// reserveTemp picks an unused name in dir for pattern and creates it as
+// entry, creating dir as needed, all under one lock so that concurrent
+// callers cannot pick the same name.
+func (c4fs *FS) reserveTemp(dir, pattern string, entry *c4m.Entry) (string, error) {
+	prefix, suffix := pattern, ""
+	if i := strings.LastIndex(pattern, "*"); i >= 0 {
+		prefix, suffix = pattern[:i], pattern[i+1:]
+	}
+
+	c4fs.mu.Lock()
+	defer c4fs.mu.Unlock()
+
+	if c4fs.temps == nil {
+		c4fs.temps = make(map[string]bool)
+	}
+	// Directories made here are scratch too. They are marked before they
+	// are made, so that making one does not make its parent ordinary.
+	var made []string
+	for p := dir; p != ""; p = parentPath(p) {
+		if _, err := c4fs.lookup(p); err != nil {
+			made = append(made, p)
+			c4fs.temps[p] = true
+		}
+	}
+	if dir != "" {
+		if err := c4fs.mkdirAll(dir, 0700); err != nil {
+			for _, p := range made {
+				delete(c4fs.temps, p)
+			}
+			return "", err
+		}
+	}
+
+	for try := 0; try < 10000; try++ {
+		name := path.Join(dir, prefix+strconv.FormatUint(uint64(rand.Uint32()), 10)+suffix)
+		if _, err := c4fs.lookup(name); err == nil {
+			continue
+		}
+		entry.Name = name
+		c4fs.temps[name] = true
+		c4fs.updateEntryInLayer(entry)
+		return name, nil
+	}
+
+	return "", &fs.PathError{
+		Op:   "createtemp",
+		Path: path.Join(dir, pattern),
+		Err:  fs.ErrExist,
+	}
+}
+
+// isTemp reports whether name was created by CreateTemp and is still
+// scratch. The caller must hold c4fs.mu.
+func (c4fs *FS) isTemp(name string) bool {
+	return c4fs.temps[name]
+}
+
+// untrackTemp updates the scratch paths for entry, which is about to be
+// written to the layer: a path removed is no longer scratch, and writing
+// an ordinary path makes the directories holding it ordinary. The caller
+// must hold c4fs.mu for writing.
+func (c4fs *FS) untrackTemp(entry *c4m.Entry) {
+	if entry.Size == -1 {
+		delete(c4fs.temps, entry.Name)
+		return
+	}
+	if c4fs.temps[entry.Name] {
+		return
+	}
+	for dir := parentPath(entry.Name); dir != ""; dir = parentPath(dir) {
+		delete(c4fs.temps, dir)
+	}
+}
+
+// CleanTemp removes the files and directories created by CreateTemp. It
+// does not move them to the trash or audit their removal.
+func (c4fs *FS) CleanTemp() error {
+	c4fs.mu.Lock()
+	defer c4fs.mu.Unlock()
+
+	names := make([]string, 0, len(c4fs.temps))
+	for name := range c4fs.temps {
+		names = append(names, name)
+	}
+	// Children sort after their directories, so remove in reverse
+	slices.Sort(names)
+	slices.Reverse(names)
+
+	var errs []error
+	for _, name := range names {
+		if _, err := c4fs.remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			errs = append(errs, err)
+		}
+		delete(c4fs.temps, name)
+	}
+	return errors.Join(errs...)
+}
+
+// Close shuts the filesystem down, waiting for pending uploads without a
+// deadline. Use Shutdown to bound the wait.
+func (c4fs *FS) Close() error {
+	return c4fs.Shutdown(context.Background())
+}
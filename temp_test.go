@@ -0,0 +1,162 @@
+package c4fs
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Avalanche-io/c4/store"
+)
+
+func TestCreateTemp(t *testing.T) {
+	c4fs := New(NewStoreAdapter(store.NewRAM()))
+
+	if got := c4fs.TempDir(); got != "/tmp" {
+		t.Errorf("TempDir: got %q, want %q", got, "/tmp")
+	}
+
+	f, name, err := c4fs.CreateTemp("", "upload-*.part")
+	if err != nil {
+		t.Fatalf("CreateTemp failed: %v", err)
+	}
+	if !strings.HasPrefix(name, "tmp/upload-") || !strings.HasSuffix(name, ".part") {
+		t.Errorf("Unexpected temp name %q", name)
+	}
+	if _, err := f.WriteString("partial"); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	data, err := c4fs.ReadFile(name)
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	if string(data) != "partial" {
+		t.Errorf("Temp content: got %q, want %q", data, "partial")
+	}
+
+	// Two temp files must not collide
+	f2, name2, err := c4fs.CreateTemp("", "upload-*.part")
+	if err != nil {
+		t.Fatalf("Second CreateTemp failed: %v", err)
+	}
+	f2.Close()
+	if name2 == name {
+		t.Error("CreateTemp returned the same name twice")
+	}
+
+	// Scratch files, and the directory made for them, are excluded from snapshots
+	c4fs.WriteFile("keep.txt", []byte("keep"), 0644)
+	for _, e := range c4fs.Flatten().Entries {
+		if strings.HasPrefix(e.Name, "tmp") {
+			t.Errorf("Snapshot contains temp entry %q", e.Name)
+		}
+	}
+
+	// Close discards them
+	if err := c4fs.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if c4fs.Exists(name) || c4fs.Exists("tmp") {
+		t.Error("Temp file still exists after Close")
+	}
+	if !c4fs.Exists("keep.txt") {
+		t.Error("Regular file removed by Close")
+	}
+}
+
+func TestCreateTempKeepsUserTmp(t *testing.T) {
+	c4fs := New(NewStoreAdapter(store.NewRAM()), WithTrash(0))
+	c4fs.MkdirAll("tmp", 0755)
+	c4fs.WriteFile("tmp/user.txt", []byte("mine"), 0644)
+
+	f, name, err := c4fs.CreateTemp("", "scratch-*")
+	if err != nil {
+		t.Fatalf("CreateTemp failed: %v", err)
+	}
+	f.Close()
+
+	var names []string
+	for _, e := range c4fs.Flatten().Entries {
+		names = append(names, e.Name)
+	}
+	if got := strings.Join(names, " "); got != "tmp tmp/user.txt" {
+		t.Errorf("Snapshot entries = %q, want the user's tmp only", got)
+	}
+
+	if err := c4fs.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if !c4fs.Exists("tmp/user.txt") {
+		t.Error("Close removed tmp/user.txt")
+	}
+	if c4fs.Exists(name) {
+		t.Error("Temp file still exists after Close")
+	}
+	if n := len(c4fs.Trash()); n != 0 {
+		t.Errorf("Cleaning temp files put %d removals in the trash", n)
+	}
+}
+
+func TestCreateTempBecomesOrdinary(t *testing.T) {
+	c4fs := New(NewStoreAdapter(store.NewRAM()))
+
+	// Renaming a finished upload keeps it
+	f, name, _ := c4fs.CreateTemp("", "upload-*")
+	f.WriteString("done")
+	f.Close()
+	if err := c4fs.Rename(name, "final.txt"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+
+	// Writing into the directory CreateTemp made keeps the directory
+	c4fs.WriteFile("tmp/note.txt", []byte("note"), 0644)
+
+	if err := c4fs.CleanTemp(); err != nil {
+		t.Fatalf("CleanTemp failed: %v", err)
+	}
+	if data, err := c4fs.ReadFile("final.txt"); err != nil || string(data) != "done" {
+		t.Errorf("final.txt = %q, %v", data, err)
+	}
+	if !c4fs.Exists("tmp/note.txt") {
+		t.Error("CleanTemp removed tmp/note.txt")
+	}
+}
+
+func TestCreateTempConcurrent(t *testing.T) {
+	c4fs := New(NewStoreAdapter(newSyncRAM()))
+
+	var mu sync.Mutex
+	seen := make(map[string]bool)
+	var wg sync.WaitGroup
+	for range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 50 {
+				f, name, err := c4fs.CreateTemp("", "x")
+				if err != nil {
+					t.Errorf("CreateTemp failed: %v", err)
+					return
+				}
+				f.Close()
+				mu.Lock()
+				if seen[name] {
+					t.Errorf("CreateTemp returned %s twice", name)
+				}
+				seen[name] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestCreateTempBadPattern(t *testing.T) {
+	c4fs := New(NewStoreAdapter(store.NewRAM()))
+	if _, _, err := c4fs.CreateTemp("", "bad/pattern*"); err == nil {
+		t.Error("Expected error for pattern containing a separator")
+	}
+}
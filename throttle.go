@@ -0,0 +1,242 @@
+package c4fs
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/store"
+)
+
+// defaultThrottleBurst is the burst size used when ThrottleOptions.Burst
+// is zero.
+const defaultThrottleBurst = 64 << 10
+
+// ThrottleOptions configures a ThrottledStore.
+type ThrottleOptions struct {
+	// BytesPerSecond limits the combined bandwidth of reads and writes.
+	// Zero means unlimited.
+	BytesPerSecond int64
+
+	// Burst is the number of bytes that may be transferred at once before
+	// the rate limit applies. Zero means 64 KiB.
+	Burst int64
+
+	// MaxConcurrent limits how many blobs may be open for reading at the
+	// same time. Further calls to Open block until a reader is closed.
+	// FS file handles briefly open a second reader for Seek and ReadAt, so
+	// a limit of one can block a handle on itself. Zero means unlimited.
+	MaxConcurrent int
+}
+
+// ThrottledStore wraps a store and limits its bandwidth and the number of
+// concurrent hydrations, so that a bulk export or migration does not
+// saturate a shared remote store or network link. The limits are shared by
+// every FS using the store.
+//
+// ThrottledStore passes BlobLister and HealthChecker through to the wrapped
+// store, and implements RangeOpener so that seeks do not read and discard
+// throttled bytes.
+type ThrottledStore struct {
+	store  store.Store
+	bucket *tokenBucket
+	sem    chan struct{}
+}
+
+var (
+	_ store.Store   = (*ThrottledStore)(nil)
+	_ RangeOpener   = (*ThrottledStore)(nil)
+	_ BlobStater    = (*ThrottledStore)(nil)
+	_ BlobChecker   = (*ThrottledStore)(nil)
+	_ BlobLister    = (*ThrottledStore)(nil)
+	_ HealthChecker = (*ThrottledStore)(nil)
+)
+
+// NewThrottledStore returns s limited according to opts.
+func NewThrottledStore(s store.Store, opts ThrottleOptions) *ThrottledStore {
+	t := &ThrottledStore{store: s}
+	if opts.BytesPerSecond > 0 {
+		burst := opts.Burst
+		if burst <= 0 {
+			burst = defaultThrottleBurst
+		}
+		t.bucket = newTokenBucket(opts.BytesPerSecond, burst)
+	}
+	if opts.MaxConcurrent > 0 {
+		t.sem = make(chan struct{}, opts.MaxConcurrent)
+	}
+	return t
+}
+
+// Open opens the blob for reading, waiting for a free slot if
+// MaxConcurrent readers are already open.
+func (t *ThrottledStore) Open(id c4.ID) (io.ReadCloser, error) {
+	return t.open(func() (io.ReadCloser, error) {
+		return t.store.Open(id)
+	})
+}
+
+// OpenRange opens part of the blob for reading. Ranges are read natively
+// when the wrapped store supports them.
+func (t *ThrottledStore) OpenRange(id c4.ID, off, length int64) (io.ReadCloser, error) {
+	return t.open(func() (io.ReadCloser, error) {
+		return NewStoreAdapter(t.store).GetRange(id, off, length)
+	})
+}
+
+func (t *ThrottledStore) open(fn func() (io.ReadCloser, error)) (io.ReadCloser, error) {
+	if t.sem != nil {
+		t.sem <- struct{}{}
+	}
+	rc, err := fn()
+	if err != nil {
+		t.release()
+		return nil, err
+	}
+	return &throttledReader{rc: rc, store: t}, nil
+}
+
+func (t *ThrottledStore) release() {
+	if t.sem != nil {
+		<-t.sem
+	}
+}
+
+// Create opens a writer for the blob. Writes count against the same
+// bandwidth limit as reads but not against MaxConcurrent.
+func (t *ThrottledStore) Create(id c4.ID) (io.WriteCloser, error) {
+	wc, err := t.store.Create(id)
+	if err != nil || t.bucket == nil {
+		return wc, err
+	}
+	return &throttledWriter{wc: wc, bucket: t.bucket}, nil
+}
+
+// Remove removes the blob from the wrapped store.
+func (t *ThrottledStore) Remove(id c4.ID) error {
+	return t.store.Remove(id)
+}
+
+// StatBlob describes the blob in the wrapped store. It transfers no
+// content, so it is not throttled.
+func (t *ThrottledStore) StatBlob(id c4.ID) (BlobInfo, error) {
+	return NewStoreAdapter(t.store).Stat(id)
+}
+
+// HasBlob checks the wrapped store without throttling.
+func (t *ThrottledStore) HasBlob(id c4.ID) bool {
+	return NewStoreAdapter(t.store).Has(id)
+}
+
+// ListIDs lists the wrapped store.
+func (t *ThrottledStore) ListIDs() ([]c4.ID, error) {
+	return ListIDs(t.store)
+}
+
+// Ping checks the wrapped store.
+func (t *ThrottledStore) Ping(ctx context.Context) error {
+	return NewStoreAdapter(t.store).Ping(ctx)
+}
+
+// throttledReader charges reads against the store's bandwidth limit and
+// frees its concurrency slot when closed.
+type throttledReader struct {
+	rc     io.ReadCloser
+	store  *ThrottledStore
+	closed bool
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	b := r.store.bucket
+	if b == nil {
+		return r.rc.Read(p)
+	}
+	if int64(len(p)) > b.burst {
+		p = p[:b.burst]
+	}
+	n, err := r.rc.Read(p)
+	b.take(int64(n))
+	return n, err
+}
+
+func (r *throttledReader) Close() error {
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	r.store.release()
+	return r.rc.Close()
+}
+
+// throttledWriter charges writes against the store's bandwidth limit.
+type throttledWriter struct {
+	wc     io.WriteCloser
+	bucket *tokenBucket
+}
+
+func (w *throttledWriter) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		chunk := p
+		if int64(len(chunk)) > w.bucket.burst {
+			chunk = chunk[:w.bucket.burst]
+		}
+		w.bucket.take(int64(len(chunk)))
+		n, err := w.wc.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (w *throttledWriter) Close() error {
+	return w.wc.Close()
+}
+
+// tokenBucket is a byte rate limiter. Callers take tokens and sleep off
+// any deficit, so concurrent transfers share the rate between them.
+type tokenBucket struct {
+	rate  float64 // bytes per second
+	burst int64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate, burst int64) *tokenBucket {
+	return &tokenBucket{
+		rate:   float64(rate),
+		burst:  burst,
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// take consumes n tokens, waiting until the bucket has been refilled
+// enough to cover them.
+func (b *tokenBucket) take(n int64) {
+	if n <= 0 {
+		return
+	}
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > float64(b.burst) {
+		b.tokens = float64(b.burst)
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	var wait time.Duration
+	if b.tokens < 0 {
+		wait = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	b.mu.Unlock()
+
+	time.Sleep(wait)
+}
@@ -0,0 +1,131 @@
+package c4fs
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/store"
+)
+
+// TestThrottledStoreBandwidth tests that reads and writes are held to the
+// configured rate once the burst is used up.
+func TestThrottledStoreBandwidth(t *testing.T) {
+	ts := NewThrottledStore(store.NewRAM(), ThrottleOptions{
+		BytesPerSecond: 1 << 20,
+		Burst:          32 << 10,
+	})
+	adapter := NewStoreAdapter(ts)
+	content := bytes.Repeat([]byte("x"), 160<<10)
+
+	start := time.Now()
+	id, err := adapter.Put(bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	rc, err := adapter.Get(id)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	got, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+	elapsed := time.Since(start)
+
+	if !bytes.Equal(got, content) {
+		t.Fatal("content mismatch")
+	}
+	// 320 KiB at 1 MiB/s with a 32 KiB burst takes at least 280ms
+	if elapsed < 250*time.Millisecond {
+		t.Errorf("transfer took %v, expected the rate limit to apply", elapsed)
+	}
+}
+
+// TestThrottledStoreMaxConcurrent tests that Open blocks while
+// MaxConcurrent readers are open.
+func TestThrottledStoreMaxConcurrent(t *testing.T) {
+	ts := NewThrottledStore(store.NewRAM(), ThrottleOptions{MaxConcurrent: 1})
+	adapter := NewStoreAdapter(ts)
+	id, err := adapter.Put(bytes.NewReader([]byte("content")))
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	first, err := ts.Open(id)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	opened := make(chan io.ReadCloser)
+	go func() {
+		rc, err := ts.Open(id)
+		if err != nil {
+			t.Errorf("second Open failed: %v", err)
+		}
+		opened <- rc
+	}()
+
+	select {
+	case <-opened:
+		t.Fatal("second Open did not wait for the first reader")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	first.Close()
+	first.Close() // closing twice must not free a second slot
+	select {
+	case rc := <-opened:
+		rc.Close()
+	case <-time.After(time.Second):
+		t.Fatal("second Open still blocked after the first reader closed")
+	}
+
+	// A failed Open does not hold a slot
+	if _, err := ts.Open(c4.Identify(strings.NewReader("missing"))); err == nil {
+		t.Fatal("Open of missing blob succeeded")
+	}
+	rc, err := ts.Open(id)
+	if err != nil {
+		t.Fatalf("Open after failure: %v", err)
+	}
+	rc.Close()
+}
+
+// TestThrottledStoreFS tests an FS backed by a throttled store, including
+// seeks served by range reads.
+func TestThrottledStoreFS(t *testing.T) {
+	ts := NewThrottledStore(store.NewRAM(), ThrottleOptions{
+		BytesPerSecond: 100 << 20,
+		MaxConcurrent:  2,
+	})
+	fsys := New(NewStoreAdapter(ts))
+	if err := fsys.WriteFile("file.txt", []byte("0123456789"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	f, err := fsys.Open("file.txt")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer f.Close()
+	if _, err := f.(io.Seeker).Seek(6, io.SeekStart); err != nil {
+		t.Fatalf("Seek failed: %v", err)
+	}
+	got, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+	if string(got) != "6789" {
+		t.Errorf("read after seek: got %q", got)
+	}
+
+	ids, err := ts.ListIDs()
+	if err != nil || len(ids) != 1 {
+		t.Errorf("ListIDs: got %d IDs, err %v", len(ids), err)
+	}
+}
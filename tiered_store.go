@@ -0,0 +1,251 @@
+package c4fs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"time"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/store"
+)
+
+// ErrContentOffline is returned when reading content that has been
+// offloaded to a cold tier. Recall brings it back online.
+var ErrContentOffline = errors.New("content offline")
+
+// Recaller is implemented by stores that keep some content offline, such
+// as TieredStore. StoreAdapter passes it through, and FS.Offline and
+// FS.Recall use it.
+type Recaller interface {
+	// Offline reports whether the blob is held but cannot be read until
+	// it is recalled.
+	Offline(id c4.ID) bool
+
+	// Recall brings the blob back online, waiting until it can be read.
+	Recall(ctx context.Context, id c4.ID) error
+}
+
+// Restorer is implemented by cold stores whose blobs must be restored
+// before they can be read, like archive tiers of object storage or tape
+// libraries. Restore requests the blob and waits until it can be opened.
+// Such stores should implement BlobChecker, since Has otherwise opens the
+// blob to find it.
+type Restorer interface {
+	Restore(ctx context.Context, id c4.ID) error
+}
+
+// TieredStore keeps recent content in a hot store and moves older content
+// to a cold store, for archives that cannot afford to keep everything on
+// fast storage. Offloaded blobs stay listed and can be described, but
+// reading them fails with ErrContentOffline until they are recalled,
+// which copies them back to the hot store. Filesystems over the store
+// keep their entries, so a partially online archive remains browsable.
+//
+// New content is written to the hot store. Recalled blobs keep their
+// cold copy, so offloading them again only removes the hot one.
+type TieredStore struct {
+	hot  store.Store
+	cold store.Store
+}
+
+var (
+	_ store.Store   = (*TieredStore)(nil)
+	_ RangeOpener   = (*TieredStore)(nil)
+	_ BlobStater    = (*TieredStore)(nil)
+	_ BlobChecker   = (*TieredStore)(nil)
+	_ BlobLister    = (*TieredStore)(nil)
+	_ HealthChecker = (*TieredStore)(nil)
+	_ Recaller      = (*TieredStore)(nil)
+)
+
+// NewTieredStore returns a store over the tiers hot and cold. If cold
+// implements Restorer, Recall restores blobs before copying them.
+func NewTieredStore(hot, cold store.Store) *TieredStore {
+	return &TieredStore{hot: hot, cold: cold}
+}
+
+// Open opens the blob from the hot store. An offloaded blob fails with
+// ErrContentOffline.
+func (t *TieredStore) Open(id c4.ID) (io.ReadCloser, error) {
+	rc, err := t.hot.Open(id)
+	if err != nil && t.offloaded(id) {
+		return nil, offlineError("open", id)
+	}
+	return rc, err
+}
+
+// OpenRange opens part of the blob from the hot store. An offloaded blob
+// fails with ErrContentOffline.
+func (t *TieredStore) OpenRange(id c4.ID, off, length int64) (io.ReadCloser, error) {
+	rc, err := NewStoreAdapter(t.hot).GetRange(id, off, length)
+	if err != nil && t.offloaded(id) {
+		return nil, offlineError("open", id)
+	}
+	return rc, err
+}
+
+// StatBlob describes the blob in the hot store, or in the cold store if
+// it has been offloaded.
+func (t *TieredStore) StatBlob(id c4.ID) (BlobInfo, error) {
+	if info, err := NewStoreAdapter(t.hot).Stat(id); err == nil {
+		return info, nil
+	}
+	return NewStoreAdapter(t.cold).Stat(id)
+}
+
+// HasBlob reports whether either tier has the blob, online or not.
+func (t *TieredStore) HasBlob(id c4.ID) bool {
+	return NewStoreAdapter(t.hot).Has(id) || NewStoreAdapter(t.cold).Has(id)
+}
+
+// Create writes the blob to the hot store.
+func (t *TieredStore) Create(id c4.ID) (io.WriteCloser, error) {
+	return t.hot.Create(id)
+}
+
+// Remove removes the blob from both tiers. If neither has it, the hot
+// store's error is returned.
+func (t *TieredStore) Remove(id c4.ID) error {
+	herr := t.hot.Remove(id)
+	cerr := t.cold.Remove(id)
+	switch hmissing, cmissing := errors.Is(herr, fs.ErrNotExist), errors.Is(cerr, fs.ErrNotExist); {
+	case hmissing && cmissing:
+		return herr
+	case hmissing:
+		herr = nil
+	case cmissing:
+		cerr = nil
+	}
+	return errors.Join(herr, cerr)
+}
+
+// ListIDs returns the IDs of the blobs in both tiers, without duplicates.
+// Both stores must be listable.
+func (t *TieredStore) ListIDs() ([]c4.ID, error) {
+	return NewUnionStore(t.hot, t.cold).ListIDs()
+}
+
+// Ping checks the hot store, which must be available for reads and
+// writes.
+func (t *TieredStore) Ping(ctx context.Context) error {
+	return NewStoreAdapter(t.hot).Ping(ctx)
+}
+
+// Offline reports whether the blob has been offloaded and not recalled.
+func (t *TieredStore) Offline(id c4.ID) bool {
+	return t.offloaded(id)
+}
+
+// offloaded reports whether only the cold store holds the blob.
+func (t *TieredStore) offloaded(id c4.ID) bool {
+	return !NewStoreAdapter(t.hot).Has(id) && NewStoreAdapter(t.cold).Has(id)
+}
+
+// Recall copies an offloaded blob back to the hot store, restoring it
+// first if the cold store implements Restorer. Restores can take hours;
+// ctx bounds the wait. Recalling a blob that is online does nothing.
+func (t *TieredStore) Recall(ctx context.Context, id c4.ID) error {
+	hot := NewStoreAdapter(t.hot)
+	if hot.Has(id) {
+		return nil
+	}
+	if !NewStoreAdapter(t.cold).Has(id) {
+		return &fs.PathError{Op: "recall", Path: id.String(), Err: fs.ErrNotExist}
+	}
+	if r, ok := t.cold.(Restorer); ok {
+		if err := r.Restore(ctx, id); err != nil {
+			return &fs.PathError{Op: "recall", Path: id.String(), Err: err}
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if _, err := migrateBlob(t.cold, t.hot, id, true, false); err != nil && !hot.Has(id) {
+		// A concurrent recall of the same blob wins the race harmlessly
+		return &fs.PathError{Op: "recall", Path: id.String(), Err: err}
+	}
+	return nil
+}
+
+// Offload moves the blobs of the hot store stored more than age ago to
+// the cold store, verifying each copy before removing the hot one, and
+// returns how many it moved. Blobs whose age the hot store cannot tell
+// (see BlobInfo) are kept. The hot store must be listable. Offload stops
+// early if ctx is cancelled.
+func (t *TieredStore) Offload(ctx context.Context, age time.Duration) (int, error) {
+	ids, err := ListIDs(t.hot)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list hot store: %w", err)
+	}
+	cutoff := time.Now().Add(-age)
+	hot, cold := NewStoreAdapter(t.hot), NewStoreAdapter(t.cold)
+
+	var moved int
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return moved, err
+		}
+		info, err := hot.Stat(id)
+		if err != nil || info.ModTime.IsZero() || info.ModTime.After(cutoff) {
+			continue
+		}
+		if !cold.Has(id) {
+			if _, err := migrateBlob(t.hot, t.cold, id, true, false); err != nil {
+				return moved, err
+			}
+		}
+		if err := t.hot.Remove(id); err != nil {
+			return moved, fmt.Errorf("failed to remove %s from hot store: %w", id, err)
+		}
+		moved++
+	}
+	return moved, nil
+}
+
+// offlineError returns the error for reading the offloaded blob id.
+func offlineError(op string, id c4.ID) error {
+	return &fs.PathError{Op: op, Path: id.String(), Err: ErrContentOffline}
+}
+
+// Offline reports whether the content of the file name has been offloaded
+// by its store (see Recaller), so that reading it fails with
+// ErrContentOffline until it is recalled. Directories and empty files are
+// never offline.
+func (c4fs *FS) Offline(name string) (bool, error) {
+	entry, err := c4fs.resolveSymlink(name, 40)
+	if err != nil {
+		return false, err
+	}
+	id, ok := contentID(entry)
+	return ok && c4fs.store.Offline(id), nil
+}
+
+// Recall brings the content of the file name back online, or that of
+// every file below it if it is a directory, waiting until it can be read.
+func (c4fs *FS) Recall(ctx context.Context, name string) error {
+	entry, err := c4fs.resolveSymlink(name, 40)
+	if err != nil {
+		return err
+	}
+	var ids []c4.ID
+	if entry.IsDir() {
+		c4fs.mu.RLock()
+		c4fs.subtreeIDs(entry.Name, &ids)
+		c4fs.mu.RUnlock()
+	} else if id, ok := contentID(entry); ok {
+		ids = append(ids, id)
+	}
+
+	for _, id := range ids {
+		if !c4fs.store.Offline(id) {
+			continue
+		}
+		if err := c4fs.store.Recall(ctx, id); err != nil {
+			return &fs.PathError{Op: "recall", Path: name, Err: err}
+		}
+	}
+	return nil
+}
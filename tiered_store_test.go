@@ -0,0 +1,99 @@
+package c4fs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/store"
+)
+
+// glacierStore is a cold store whose blobs must be restored before they
+// can be opened.
+type glacierStore struct {
+	*store.RAM
+	restored map[c4.ID]bool
+}
+
+func (g *glacierStore) Open(id c4.ID) (io.ReadCloser, error) {
+	if !g.restored[id] {
+		return nil, errors.New("blob not restored")
+	}
+	return g.RAM.Open(id)
+}
+
+func (g *glacierStore) HasBlob(id c4.ID) bool {
+	_, ok := (*g.RAM)[id]
+	return ok
+}
+
+func (g *glacierStore) Restore(ctx context.Context, id c4.ID) error {
+	g.restored[id] = true
+	return nil
+}
+
+func TestTieredStore(t *testing.T) {
+	dir := t.TempDir()
+	hot := store.Folder(dir)
+	cold := &glacierStore{RAM: store.NewRAM(), restored: make(map[c4.ID]bool)}
+	tiered := NewTieredStore(hot, cold)
+
+	fsys := New(NewStoreAdapter(tiered))
+	if err := fsys.WriteFile("old.txt", []byte("old content"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if err := fsys.WriteFile("new.txt", []byte("new content"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	e, err := fsys.Entry("old.txt")
+	if err != nil {
+		t.Fatalf("Entry failed: %v", err)
+	}
+	month := time.Now().AddDate(0, -1, 0)
+	if err := os.Chtimes(filepath.Join(dir, e.C4ID.String()), month, month); err != nil {
+		t.Fatal(err)
+	}
+
+	moved, err := tiered.Offload(context.Background(), 7*24*time.Hour)
+	if err != nil {
+		t.Fatalf("Offload failed: %v", err)
+	}
+	if moved != 1 {
+		t.Errorf("Offload moved %d blobs, want 1", moved)
+	}
+
+	// The entry stays, but its content is offline
+	if off, err := fsys.Offline("old.txt"); err != nil || !off {
+		t.Errorf("Offline(old.txt) = %v, %v; want true", off, err)
+	}
+	if off, _ := fsys.Offline("new.txt"); off {
+		t.Error("new.txt should be online")
+	}
+	if info, err := fsys.Stat("old.txt"); err != nil || info.Size() != int64(len("old content")) {
+		t.Errorf("Stat(old.txt) = %v, %v", info, err)
+	}
+	if _, err := fsys.ReadFile("old.txt"); !errors.Is(err, ErrContentOffline) {
+		t.Errorf("ReadFile of offline file: got %v, want ErrContentOffline", err)
+	}
+
+	if err := fsys.Recall(context.Background(), "/"); err != nil {
+		t.Fatalf("Recall failed: %v", err)
+	}
+	if !cold.restored[e.C4ID] {
+		t.Error("Recall did not restore the cold blob")
+	}
+	data, err := fsys.ReadFile("old.txt")
+	if err != nil || string(data) != "old content" {
+		t.Errorf("ReadFile after recall = %q, %v", data, err)
+	}
+
+	// Recalled content is fresh in the hot store and is not offloaded again
+	if moved, _ := tiered.Offload(context.Background(), 7*24*time.Hour); moved != 0 {
+		t.Errorf("Second Offload moved %d blobs, want 0", moved)
+	}
+}
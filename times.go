@@ -0,0 +1,36 @@
+package c4fs
+
+import (
+	"time"
+
+	"github.com/Avalanche-io/c4/c4m"
+)
+
+// Atime returns the access time of the named file, following symbolic
+// links. It is the time last set with Chtimes, or the modification time
+// if none was.
+func (c4fs *FS) Atime(name string) (time.Time, error) {
+	entry, err := c4fs.resolveSymlink(name, 40)
+	if err != nil {
+		return time.Time{}, err
+	}
+	c4fs.mu.RLock()
+	defer c4fs.mu.RUnlock()
+	if atime, ok := c4fs.atimeLocked(entry); ok {
+		return atime, nil
+	}
+	return entry.Timestamp, nil
+}
+
+// atimeLocked returns the access time set on e, if any.
+// The caller must hold c4fs.mu.
+func (c4fs *FS) atimeLocked(e *c4m.Entry) (time.Time, bool) {
+	atime := c4fs.entryMetaLocked(e).Atime
+	return atime, !atime.IsZero()
+}
+
+// setAtimeLocked sets the access time of e.
+// The caller must hold c4fs.mu for writing.
+func (c4fs *FS) setAtimeLocked(e *c4m.Entry, atime time.Time) {
+	c4fs.updateMetaLocked(e, func(m *EntryMeta) { m.Atime = atime.UTC() })
+}
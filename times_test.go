@@ -0,0 +1,138 @@
+package c4fs
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Avalanche-io/c4/store"
+)
+
+func TestAtime(t *testing.T) {
+	c4fs := New(NewStoreAdapter(store.NewRAM()))
+	if err := c4fs.WriteFile("a.txt", []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := c4fs.Stat("a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if atime, err := c4fs.Atime("a.txt"); err != nil || !atime.Equal(info.ModTime()) {
+		t.Errorf("Atime before Chtimes = %v, %v; want the modification time", atime, err)
+	}
+
+	atime := time.Date(2024, 3, 1, 12, 0, 0, 123456789, time.UTC)
+	mtime := time.Date(2023, 7, 4, 8, 30, 0, 987654321, time.UTC)
+	if err := c4fs.Chtimes("a.txt", atime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	check := func(fsys *FS, name string, wantAtime, wantMtime time.Time) {
+		t.Helper()
+		info, err := fsys.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !info.ModTime().Equal(wantMtime) {
+			t.Errorf("%s ModTime = %v, want %v", name, info.ModTime(), wantMtime)
+		}
+		if got, err := fsys.Atime(name); err != nil || !got.Equal(wantAtime) {
+			t.Errorf("%s Atime = %v, %v; want %v", name, got, err, wantAtime)
+		}
+	}
+	check(c4fs, "a.txt", atime, mtime)
+
+	// Zero times are left unchanged
+	later := mtime.Add(time.Hour)
+	if err := c4fs.Chtimes("a.txt", time.Time{}, later); err != nil {
+		t.Fatal(err)
+	}
+	check(c4fs, "a.txt", atime, later)
+	if err := c4fs.Chtimes("a.txt", atime.Add(time.Minute), time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	check(c4fs, "a.txt", atime.Add(time.Minute), later)
+
+	// Links report the times of their targets
+	if err := c4fs.Symlink("a.txt", "link"); err != nil {
+		t.Fatal(err)
+	}
+	check(c4fs, "link", atime.Add(time.Minute), later)
+
+	// The root keeps its access time too
+	if err := c4fs.Chtimes("/", atime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	check(c4fs, "/", atime, mtime)
+
+	// Rewriting a file drops the access time set on the old content
+	if err := c4fs.WriteFile("a.txt", []byte("rewritten"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err = c4fs.Stat("a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(c4fs, "a.txt", info.ModTime(), info.ModTime())
+}
+
+func TestAtimeSnapshot(t *testing.T) {
+	c4fs := New(NewStoreAdapter(store.NewRAM()))
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if err := c4fs.WriteFile(name, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	atime := time.Date(2024, 3, 1, 12, 0, 0, 123456789, time.UTC)
+	mtime := time.Date(2023, 7, 4, 8, 30, 0, 987654321, time.UTC)
+	if err := c4fs.Chtimes("a.txt", atime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := c4fs.SaveSnapshot(&buf, SnapshotBinary); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := OpenSnapshot(bytes.NewReader(buf.Bytes()), c4fs.Store())
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := restored.Stat("a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(mtime) {
+		t.Errorf("ModTime = %v, want %v with nanoseconds", info.ModTime(), mtime)
+	}
+	if got, _ := restored.Atime("a.txt"); !got.Equal(atime) {
+		t.Errorf("Atime = %v, want %v", got, atime)
+	}
+	info, err = restored.Stat("b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := restored.Atime("b.txt"); !got.Equal(info.ModTime()) {
+		t.Errorf("Atime of b.txt = %v, want its modification time", got)
+	}
+
+	// Clones share the base and its access times
+	if got, _ := restored.Clone().Atime("a.txt"); !got.Equal(atime) {
+		t.Errorf("Atime in clone = %v, want %v", got, atime)
+	}
+
+	// Version 1 snapshots, without access times, are still read
+	var v1 bytes.Buffer
+	if err := New(NewStoreAdapter(store.NewRAM()), WithBase(c4fs.Flatten())).SaveSnapshot(&v1, SnapshotBinary); err != nil {
+		t.Fatal(err)
+	}
+	// Drop the required features, which version 1 headers do not have
+	old := slices.Delete(v1.Bytes(), len(snapshotMagic)+1, len(snapshotMagic)+2)
+	old[len(snapshotMagic)] = 1
+	m, err := LoadSnapshot(bytes.NewReader(old))
+	if err != nil {
+		t.Fatalf("LoadSnapshot of version 1 failed: %v", err)
+	}
+	if len(m.Entries) != 2 {
+		t.Errorf("version 1 snapshot has %d entries, want 2", len(m.Entries))
+	}
+}
@@ -0,0 +1,230 @@
+package c4fs
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Avalanche-io/c4"
+)
+
+// trashNamespace is the directory removals are moved to when the trash is
+// enabled. Each removal gets a directory of its own in it, named for the
+// time of the removal and the path removed, holding the removed entry.
+const trashNamespace = ".trash"
+
+// trashStamp is the time format of trash directory names. It sorts in
+// time order.
+const trashStamp = "20060102T150405.000000000Z"
+
+// WithTrash makes Remove and RemoveAll move what they remove into the
+// trash, from where Undelete can restore it. Removals older than
+// retention are purged by later removals, and EmptyTrash purges them on
+// demand; zero retention keeps them until EmptyTrash. Trashed files stay
+// in snapshots and keep their content referenced, so nothing is lost
+// until a removal is purged and the store garbage collected.
+func WithTrash(retention time.Duration) Option {
+	return func(o *options) {
+		o.trash = true
+		o.trashRetention = retention
+	}
+}
+
+// TrashEntry describes a removal kept in the trash.
+type TrashEntry struct {
+	Name    string    // Path the entry was removed from
+	Removed time.Time // Time of the removal
+	Path    string    // Path of the entry in the trash
+}
+
+// isTrashPath reports whether p lies inside the trash namespace.
+func isTrashPath(p string) bool {
+	p = strings.TrimPrefix(cleanPath(p), "/")
+	return p == trashNamespace || strings.HasPrefix(p, trashNamespace+"/")
+}
+
+// trashing reports whether removing name moves it to the trash.
+func (c4fs *FS) trashing(name string) bool {
+	return c4fs.trash && name != "" && !isTrashPath(name)
+}
+
+// trashLocked moves name into the trash and returns the IDs of the
+// content it holds. Unless all is set, a directory must be empty. The
+// caller must hold c4fs.mu for writing.
+func (c4fs *FS) trashLocked(name string, all bool) ([]c4.ID, error) {
+	entry, err := c4fs.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []c4.ID
+	if entry.IsDir() {
+		if !all && !c4fs.isEmptyDir(name) {
+			return nil, &fs.PathError{
+				Op:   "remove",
+				Path: name,
+				Err:  fmt.Errorf("directory not empty"),
+			}
+		}
+		c4fs.subtreeIDs(name, &ids)
+	} else if id, ok := contentID(entry); ok {
+		ids = append(ids, id)
+	}
+
+	// Removals in the same instant get distinct directories
+	now := time.Now().UTC()
+	slot := trashSlot(now, name)
+	for c4fs.exists(slot) {
+		now = now.Add(time.Nanosecond)
+		slot = trashSlot(now, name)
+	}
+	if err := c4fs.mkdirAll(slot, 0700); err != nil {
+		return nil, err
+	}
+	return ids, c4fs.rename(name, path.Join(slot, path.Base(name)))
+}
+
+// subtreeIDs appends the IDs of the content below the directory dir to
+// ids. The caller must hold c4fs.mu.
+func (c4fs *FS) subtreeIDs(dir string, ids *[]c4.ID) {
+	for _, e := range c4fs.children(dir) {
+		if e.IsDir() {
+			c4fs.subtreeIDs(e.Name, ids)
+		} else if id, ok := contentID(e); ok {
+			*ids = append(*ids, id)
+		}
+	}
+}
+
+// exists reports whether name exists. The caller must hold c4fs.mu.
+func (c4fs *FS) exists(name string) bool {
+	_, err := c4fs.lookup(name)
+	return err == nil
+}
+
+// trashSlot returns the trash directory of a removal of name at t.
+func trashSlot(t time.Time, name string) string {
+	return path.Join(trashNamespace, t.Format(trashStamp)+"_"+url.PathEscape(name))
+}
+
+// parseTrashSlot parses the base name of a trash directory.
+func parseTrashSlot(base string) (TrashEntry, bool) {
+	stamp, escaped, ok := strings.Cut(base, "_")
+	if !ok {
+		return TrashEntry{}, false
+	}
+	removed, err := time.Parse(trashStamp, stamp)
+	if err != nil {
+		return TrashEntry{}, false
+	}
+	name, err := url.PathUnescape(escaped)
+	if err != nil || name == "" {
+		return TrashEntry{}, false
+	}
+	return TrashEntry{
+		Name:    name,
+		Removed: removed,
+		Path:    path.Join(trashNamespace, base, path.Base(name)),
+	}, true
+}
+
+// trashEntries lists the removals in the trash, oldest first. The caller
+// must hold c4fs.mu.
+func (c4fs *FS) trashEntries() []TrashEntry {
+	var entries []TrashEntry
+	for _, e := range c4fs.children(trashNamespace) {
+		if t, ok := parseTrashSlot(path.Base(e.Name)); ok && e.IsDir() {
+			entries = append(entries, t)
+		}
+	}
+	slices.SortFunc(entries, func(a, b TrashEntry) int {
+		return a.Removed.Compare(b.Removed)
+	})
+	return entries
+}
+
+// Trash lists the removals kept in the trash, oldest first.
+func (c4fs *FS) Trash() []TrashEntry {
+	c4fs.mu.RLock()
+	defer c4fs.mu.RUnlock()
+	return c4fs.trashEntries()
+}
+
+// Undelete restores the most recent removal of name from the trash. It
+// fails with fs.ErrNotExist if the trash holds no removal of name and
+// with fs.ErrExist if name has been created again since. Missing parent
+// directories are recreated.
+func (c4fs *FS) Undelete(name string) error {
+	name = cleanPath(name)
+
+	c4fs.mu.Lock()
+	defer c4fs.mu.Unlock()
+
+	entries := c4fs.trashEntries()
+	i := len(entries) - 1
+	for i >= 0 && entries[i].Name != name {
+		i--
+	}
+	if i < 0 {
+		return &fs.PathError{Op: "undelete", Path: name, Err: fs.ErrNotExist}
+	}
+	if c4fs.exists(name) {
+		return &fs.PathError{Op: "undelete", Path: name, Err: fs.ErrExist}
+	}
+	if dir := path.Dir(name); dir != "." {
+		if err := c4fs.mkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	if err := c4fs.rename(entries[i].Path, name); err != nil {
+		return err
+	}
+	_, err := c4fs.remove(path.Dir(entries[i].Path))
+	return err
+}
+
+// EmptyTrash purges the removals in the trash older than age, or all of
+// them if age is zero. Purged content is no longer referenced and is
+// removed from the store by its next garbage collection.
+func (c4fs *FS) EmptyTrash(age time.Duration) error {
+	cutoff := time.Now().Add(-age)
+
+	type purge struct {
+		name string
+		ids  []c4.ID
+	}
+	var purged []purge
+	var errs []error
+	c4fs.mu.Lock()
+	for _, t := range c4fs.trashEntries() {
+		if age > 0 && t.Removed.After(cutoff) {
+			break
+		}
+		var ids []c4.ID
+		if _, err := c4fs.removeAll(path.Dir(t.Path), &ids); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		purged = append(purged, purge{t.Name, ids})
+	}
+	c4fs.mu.Unlock()
+
+	for _, p := range purged {
+		errs = append(errs, c4fs.audit(AuditPurge, p.name, p.ids))
+	}
+	return errors.Join(errs...)
+}
+
+// expireTrash purges removals older than the trash retention, if it has
+// one.
+func (c4fs *FS) expireTrash() error {
+	if !c4fs.trash || c4fs.trashAge <= 0 {
+		return nil
+	}
+	return c4fs.EmptyTrash(c4fs.trashAge)
+}
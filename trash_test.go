@@ -0,0 +1,106 @@
+package c4fs
+
+import (
+	"errors"
+	"io/fs"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/store"
+)
+
+func TestTrash(t *testing.T) {
+	c4fs := New(NewStoreAdapter(store.NewRAM()), WithTrash(0))
+	c4fs.MkdirAll("shots/010", 0755)
+	c4fs.WriteFile("shots/010/a.exr", []byte("frame a"), 0644)
+	c4fs.WriteFile("notes_v1.txt", []byte("notes"), 0644)
+
+	if err := c4fs.Remove("notes_v1.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c4fs.RemoveAll("shots"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c4fs.Remove("shots"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Remove of a trashed path = %v, want ErrNotExist", err)
+	}
+
+	trash := c4fs.Trash()
+	if len(trash) != 2 || trash[0].Name != "notes_v1.txt" || trash[1].Name != "shots" {
+		t.Fatalf("Trash() = %+v", trash)
+	}
+	if data, err := c4fs.ReadFile(trash[1].Path + "/010/a.exr"); err != nil || string(data) != "frame a" {
+		t.Errorf("trashed file = %q, %v", data, err)
+	}
+	// Trashed content stays referenced
+	if refs := c4fs.ReferencedIDs(); !refs[c4.Identify(strings.NewReader("frame a"))] {
+		t.Error("trashed content not referenced")
+	}
+
+	if err := c4fs.Undelete("shots"); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := c4fs.ReadFile("shots/010/a.exr"); err != nil || string(data) != "frame a" {
+		t.Errorf("undeleted file = %q, %v", data, err)
+	}
+	if err := c4fs.Undelete("shots"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("second Undelete = %v, want ErrNotExist", err)
+	}
+
+	// A name created again is not overwritten
+	c4fs.WriteFile("notes_v1.txt", []byte("new notes"), 0644)
+	if err := c4fs.Undelete("notes_v1.txt"); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("Undelete over a file = %v, want ErrExist", err)
+	}
+
+	if err := c4fs.EmptyTrash(0); err != nil {
+		t.Fatal(err)
+	}
+	if trash := c4fs.Trash(); len(trash) != 0 {
+		t.Errorf("Trash() after EmptyTrash = %+v", trash)
+	}
+	if refs := c4fs.ReferencedIDs(); refs[c4.Identify(strings.NewReader("notes"))] {
+		t.Error("purged content still referenced")
+	}
+}
+
+func TestTrashRetention(t *testing.T) {
+	var purged []AuditRecord
+	sink := AuditFunc(func(r AuditRecord) error {
+		if r.Op == AuditPurge {
+			purged = append(purged, r)
+		}
+		return nil
+	})
+	c4fs := New(NewStoreAdapter(store.NewRAM()), WithTrash(time.Hour), WithAudit(sink, ""))
+	c4fs.WriteFile("old.txt", []byte("old"), 0644)
+	c4fs.WriteFile("new.txt", []byte("new"), 0644)
+
+	// A removal from two hours ago
+	c4fs.mu.Lock()
+	slot := trashSlot(time.Now().UTC().Add(-2*time.Hour), "old.txt")
+	c4fs.mkdirAll(slot, 0700)
+	c4fs.rename("old.txt", slot+"/old.txt")
+	c4fs.mu.Unlock()
+
+	if err := c4fs.Remove("new.txt"); err != nil {
+		t.Fatal(err)
+	}
+	trash := c4fs.Trash()
+	if len(trash) != 1 || trash[0].Name != "new.txt" {
+		t.Errorf("Trash() = %+v, want only new.txt", trash)
+	}
+	if len(purged) != 1 || purged[0].Path != "old.txt" || len(purged[0].IDs) != 1 {
+		t.Errorf("purge records = %+v", purged)
+	}
+
+	// Removals inside the trash are immediate
+	if err := c4fs.RemoveAll(trashNamespace); err != nil {
+		t.Fatal(err)
+	}
+	if c4fs.Exists(trashNamespace) || len(c4fs.Trash()) != 0 {
+		t.Error("trash survived RemoveAll")
+	}
+}
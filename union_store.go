@@ -0,0 +1,126 @@
+package c4fs
+
+import (
+	"context"
+	"io"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/store"
+)
+
+// UnionStore reads from an ordered list of stores and writes to the first
+// of them, the primary. A workstation might put a local store first,
+// then the team NAS, then cloud storage: new content is written locally,
+// and reads are served by the nearest store that has the blob.
+//
+// Remove only affects the primary; blobs in the other stores are never
+// modified.
+type UnionStore struct {
+	stores []store.Store
+}
+
+var (
+	_ store.Store   = (*UnionStore)(nil)
+	_ RangeOpener   = (*UnionStore)(nil)
+	_ BlobStater    = (*UnionStore)(nil)
+	_ BlobChecker   = (*UnionStore)(nil)
+	_ BlobLister    = (*UnionStore)(nil)
+	_ HealthChecker = (*UnionStore)(nil)
+)
+
+// NewUnionStore returns a store writing to primary and reading from
+// primary and then each of others in order.
+func NewUnionStore(primary store.Store, others ...store.Store) *UnionStore {
+	return &UnionStore{stores: append([]store.Store{primary}, others...)}
+}
+
+// Open opens the blob from the first store that has it. If none does,
+// the primary's error is returned.
+func (u *UnionStore) Open(id c4.ID) (io.ReadCloser, error) {
+	var first error
+	for _, s := range u.stores {
+		rc, err := s.Open(id)
+		if err == nil {
+			return rc, nil
+		}
+		if first == nil {
+			first = err
+		}
+	}
+	return nil, first
+}
+
+// OpenRange opens part of the blob from the first store that has it.
+func (u *UnionStore) OpenRange(id c4.ID, off, length int64) (io.ReadCloser, error) {
+	var first error
+	for _, s := range u.stores {
+		rc, err := NewStoreAdapter(s).GetRange(id, off, length)
+		if err == nil {
+			return rc, nil
+		}
+		if first == nil {
+			first = err
+		}
+	}
+	return nil, first
+}
+
+// StatBlob describes the blob in the first store that has it.
+func (u *UnionStore) StatBlob(id c4.ID) (BlobInfo, error) {
+	var first error
+	for _, s := range u.stores {
+		info, err := NewStoreAdapter(s).Stat(id)
+		if err == nil {
+			return info, nil
+		}
+		if first == nil {
+			first = err
+		}
+	}
+	return BlobInfo{}, first
+}
+
+// HasBlob reports whether any store has the blob.
+func (u *UnionStore) HasBlob(id c4.ID) bool {
+	for _, s := range u.stores {
+		if NewStoreAdapter(s).Has(id) {
+			return true
+		}
+	}
+	return false
+}
+
+// Create writes the blob to the primary store.
+func (u *UnionStore) Create(id c4.ID) (io.WriteCloser, error) {
+	return u.stores[0].Create(id)
+}
+
+// Remove removes the blob from the primary store.
+func (u *UnionStore) Remove(id c4.ID) error {
+	return u.stores[0].Remove(id)
+}
+
+// ListIDs returns the IDs of the blobs in all stores, without duplicates.
+// Every store must be listable.
+func (u *UnionStore) ListIDs() ([]c4.ID, error) {
+	seen := make(map[c4.ID]bool)
+	var ids []c4.ID
+	for _, s := range u.stores {
+		list, err := ListIDs(s)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range list {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids, nil
+}
+
+// Ping checks the primary store, which must be available for writes.
+func (u *UnionStore) Ping(ctx context.Context) error {
+	return NewStoreAdapter(u.stores[0]).Ping(ctx)
+}
@@ -0,0 +1,66 @@
+package c4fs
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/Avalanche-io/c4/store"
+)
+
+func TestUnionStore(t *testing.T) {
+	local, nas, cloud := store.NewRAM(), store.NewRAM(), store.NewRAM()
+	nasID, _ := NewStoreAdapter(nas).Put(strings.NewReader("on the nas"))
+	cloudID, _ := NewStoreAdapter(cloud).Put(strings.NewReader("in the cloud"))
+	NewStoreAdapter(cloud).Put(strings.NewReader("on the nas"))
+
+	u := NewUnionStore(local, nas, cloud)
+	adapter := NewStoreAdapter(u)
+
+	read := func(r io.ReadCloser, err error) string {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("read failed: %v", err)
+		}
+		defer r.Close()
+		data, _ := io.ReadAll(r)
+		return string(data)
+	}
+	if got := read(adapter.Get(nasID)); got != "on the nas" {
+		t.Errorf("nas blob: got %q", got)
+	}
+	if got := read(adapter.Get(cloudID)); got != "in the cloud" {
+		t.Errorf("cloud blob: got %q", got)
+	}
+	if got := read(adapter.GetRange(cloudID, 3, 3)); got != "the" {
+		t.Errorf("range read: got %q", got)
+	}
+
+	// Writes go to the primary only
+	newID, err := adapter.Put(bytes.NewReader([]byte("new work")))
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if _, ok := (*local)[newID]; !ok || len(*nas) != 1 || len(*cloud) != 2 {
+		t.Error("Put did not write to the primary only")
+	}
+
+	ids, err := u.ListIDs()
+	if err != nil || len(ids) != 3 {
+		t.Errorf("ListIDs: got %d IDs, %v", len(ids), err)
+	}
+
+	// Removal leaves the other stores untouched
+	u.Remove(nasID)
+	if !adapter.Has(nasID) {
+		t.Error("blob in a secondary store was removed")
+	}
+	u.Remove(newID)
+	if adapter.Has(newID) {
+		t.Error("blob in the primary was not removed")
+	}
+	if _, err := u.Open(newID); err == nil {
+		t.Error("Open of a blob in no store succeeded")
+	}
+}
@@ -0,0 +1,191 @@
+package c4fs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"time"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/c4m"
+)
+
+// WriteFileOptions are preconditions for WriteFileWithOptions. They are
+// checked under the filesystem lock together with the write, so two
+// writers cannot both see a precondition hold.
+type WriteFileOptions struct {
+	// Exclusive fails the write with fs.ErrExist if the file exists, like
+	// O_EXCL.
+	Exclusive bool
+
+	// IfMatch, if set, writes only if the file currently has this C4 ID.
+	// If it has another, or does not exist, nothing is written and a
+	// *WriteConflictError is returned.
+	IfMatch c4.ID
+}
+
+// WriteFileWithOptions is WriteFile with preconditions. Writers sharing a
+// file use IfMatch for optimistic updates: read the file and note its ID
+// (from ReadDirEntries, or c4.Identify of the content), build the new
+// content, write it if the ID is unchanged, and on conflict read again
+// and retry.
+//
+// The content is stored before the preconditions are checked, so a failed
+// write can leave an unreferenced blob for garbage collection.
+func (c4fs *FS) WriteFileWithOptions(name string, data []byte, perm fs.FileMode, opts WriteFileOptions) error {
+	id, err := c4fs.store.Put(bytes.NewReader(data))
+	if err != nil {
+		return &fs.PathError{
+			Op:   "write",
+			Path: name,
+			Err:  fmt.Errorf("failed to dehydrate content: %w", err),
+		}
+	}
+
+	entry := &c4m.Entry{
+		Mode:      perm,
+		Timestamp: time.Now().UTC(),
+		Size:      int64(len(data)),
+		Name:      cleanPath(name),
+		C4ID:      id,
+	}
+
+	c4fs.mu.Lock()
+	defer c4fs.mu.Unlock()
+
+	prev, _ := c4fs.lookup(entry.Name)
+	if opts.Exclusive && prev != nil {
+		return &fs.PathError{Op: "write", Path: name, Err: fs.ErrExist}
+	}
+	if !opts.IfMatch.IsNil() {
+		if actual := c4fs.currentIDLocked(entry.Name); actual != opts.IfMatch {
+			return &WriteConflictError{Path: name, Expected: opts.IfMatch, Actual: actual}
+		}
+	}
+	c4fs.updateEntryInLayer(entry)
+	return nil
+}
+
+// updateFileRetries bounds how often UpdateFile retries after losing a
+// race with another writer.
+const updateFileRetries = 100
+
+// UpdateFile replaces the content of the named file with fn applied to it,
+// as an atomic read-modify-write. fn receives nil if the file does not
+// exist, in which case it is created with mode 0644; an existing file
+// keeps its mode. If fn returns an error, the file is left unchanged and
+// the error is returned.
+//
+// The store is read and written without holding the filesystem lock; the
+// new content is swapped in only if the file still has the content fn was
+// given, and otherwise fn is called again on the new content. fn may
+// therefore run several times and must not have side effects. After
+// updateFileRetries lost races the *WriteConflictError is returned.
+func (c4fs *FS) UpdateFile(name string, fn func(old []byte) ([]byte, error)) error {
+	var err error
+	for range updateFileRetries {
+		err = c4fs.updateFile(name, fn)
+		var conflict *WriteConflictError
+		if !errors.As(err, &conflict) && !errors.Is(err, fs.ErrExist) {
+			return err
+		}
+	}
+	return err
+}
+
+// updateFile makes one attempt at UpdateFile.
+func (c4fs *FS) updateFile(name string, fn func(old []byte) ([]byte, error)) error {
+	var (
+		old  []byte
+		perm fs.FileMode = 0644
+		opts             = WriteFileOptions{Exclusive: true}
+	)
+	prev, err := c4fs.getEntry(name)
+	if err == nil {
+		if !prev.Mode.IsRegular() {
+			return &fs.PathError{Op: "update", Path: name, Err: fs.ErrInvalid}
+		}
+		rc, err := c4fs.store.Get(prev.C4ID)
+		if err != nil {
+			return &fs.PathError{Op: "update", Path: name, Err: err}
+		}
+		old, err = io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return &fs.PathError{Op: "update", Path: name, Err: err}
+		}
+		perm = prev.Mode.Perm()
+		opts = WriteFileOptions{IfMatch: prev.C4ID}
+	}
+
+	data, err := fn(old)
+	if err != nil {
+		return err
+	}
+	return c4fs.WriteFileWithOptions(name, data, perm, opts)
+}
+
+// WithStaleWriteGuard makes files opened for writing remember the C4 ID
+// of the file when they were opened, and Close fail with a
+// *WriteConflictError, writing nothing, if another writer changed the
+// file in the meantime. Without it the last writer to close wins. Use
+// CreateIfMatch to guard against changes since an earlier read, and
+// WriteFileWithOptions for WriteFile.
+func WithStaleWriteGuard() Option {
+	return func(o *options) {
+		o.staleGuard = true
+	}
+}
+
+// CreateIfMatch is Create for a file the caller read when it had the C4 ID
+// id, or a nil ID if it did not exist. Close writes the file only if it
+// still has that ID, and otherwise fails with a *WriteConflictError.
+func (c4fs *FS) CreateIfMatch(name string, id c4.ID) (File, error) {
+	if err := c4fs.checkNotDir("open", name); err != nil {
+		return nil, err
+	}
+	f, err := newDehydratingFile(c4fs, name, 0644)
+	if err != nil {
+		return nil, err
+	}
+	f.guarded, f.ifMatch = true, id
+	return f, nil
+}
+
+// currentID returns the C4 ID of the regular file name, or a nil ID if it
+// does not exist or is not a regular file.
+func (c4fs *FS) currentID(name string) c4.ID {
+	c4fs.mu.RLock()
+	defer c4fs.mu.RUnlock()
+	return c4fs.currentIDLocked(name)
+}
+
+// currentIDLocked is currentID for callers that already hold c4fs.mu.
+func (c4fs *FS) currentIDLocked(name string) c4.ID {
+	if e, err := c4fs.lookup(name); err == nil && e.Mode.IsRegular() {
+		return e.C4ID
+	}
+	return c4.ID{}
+}
+
+// WriteConflictError is returned when a conditional write finds the file
+// does not have the C4 ID it expected. Actual is nil if the file does not
+// exist or is not a regular file.
+type WriteConflictError struct {
+	Path     string
+	Expected c4.ID
+	Actual   c4.ID
+}
+
+func (e *WriteConflictError) Error() string {
+	return fmt.Sprintf("write %s: file changed: expected %s, found %s", e.Path, e.Expected, idString(e.Actual))
+}
+
+func idString(id c4.ID) string {
+	if id.IsNil() {
+		return "none"
+	}
+	return id.String()
+}
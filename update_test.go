@@ -0,0 +1,227 @@
+package c4fs
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/store"
+)
+
+func TestWriteFileWithOptions(t *testing.T) {
+	c4fs := New(NewStoreAdapter(store.NewRAM()))
+
+	if err := c4fs.WriteFileWithOptions("config.json", []byte("v1"), 0644, WriteFileOptions{Exclusive: true}); err != nil {
+		t.Fatalf("exclusive create failed: %v", err)
+	}
+	err := c4fs.WriteFileWithOptions("config.json", []byte("v2"), 0644, WriteFileOptions{Exclusive: true})
+	if !errors.Is(err, fs.ErrExist) {
+		t.Errorf("second exclusive create = %v, want ErrExist", err)
+	}
+
+	v1 := c4.Identify(bytes.NewReader([]byte("v1")))
+	if err := c4fs.WriteFileWithOptions("config.json", []byte("v2"), 0644, WriteFileOptions{IfMatch: v1}); err != nil {
+		t.Fatalf("matching write failed: %v", err)
+	}
+
+	// v1 is stale now
+	err = c4fs.WriteFileWithOptions("config.json", []byte("v3"), 0644, WriteFileOptions{IfMatch: v1})
+	var conflict *WriteConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatalf("stale write = %v, want *WriteConflictError", err)
+	}
+	if v2 := c4.Identify(bytes.NewReader([]byte("v2"))); conflict.Actual != v2 {
+		t.Errorf("conflict Actual = %s, want %s", conflict.Actual, v2)
+	}
+	if data, _ := c4fs.ReadFile("config.json"); string(data) != "v2" {
+		t.Errorf("content after conflict = %q, want v2", data)
+	}
+
+	// IfMatch requires the file to exist
+	err = c4fs.WriteFileWithOptions("missing.json", []byte("x"), 0644, WriteFileOptions{IfMatch: v1})
+	if !errors.As(err, &conflict) || !conflict.Actual.IsNil() {
+		t.Errorf("write to missing file = %v, want conflict with nil Actual", err)
+	}
+	if c4fs.Exists("missing.json") {
+		t.Error("conflicting write created the file")
+	}
+
+	// A removed file can be created exclusively again
+	c4fs.Remove("config.json")
+	if err := c4fs.WriteFileWithOptions("config.json", []byte("v4"), 0644, WriteFileOptions{Exclusive: true}); err != nil {
+		t.Errorf("exclusive create after Remove failed: %v", err)
+	}
+}
+
+func TestWriteFileWithOptionsConcurrent(t *testing.T) {
+	c4fs := New(NewStoreAdapter(newSyncRAM()))
+	c4fs.WriteFile("counter", []byte("0"), 0644)
+
+	// Every increment retries until its compare-and-swap succeeds, so none
+	// is lost
+	const writers, increments = 8, 25
+	var wg sync.WaitGroup
+	for range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range increments {
+				for {
+					old, err := c4fs.ReadFile("counter")
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					n, _ := strconv.Atoi(string(old))
+					next := []byte(strconv.Itoa(n + 1))
+					err = c4fs.WriteFileWithOptions("counter", next, 0644, WriteFileOptions{IfMatch: c4.Identify(bytes.NewReader(old))})
+					if err == nil {
+						break
+					}
+					var conflict *WriteConflictError
+					if !errors.As(err, &conflict) {
+						t.Error(err)
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	data, _ := c4fs.ReadFile("counter")
+	if want := strconv.Itoa(writers * increments); string(data) != want {
+		t.Errorf("counter = %s, want %s", data, want)
+	}
+}
+
+func TestUpdateFile(t *testing.T) {
+	c4fs := New(NewStoreAdapter(store.NewRAM()))
+	appendLine := func(line string) func([]byte) ([]byte, error) {
+		return func(old []byte) ([]byte, error) {
+			return append(old, line+"\n"...), nil
+		}
+	}
+
+	// A missing file is created from nil
+	if err := c4fs.UpdateFile("log.txt", appendLine("one")); err != nil {
+		t.Fatalf("UpdateFile failed: %v", err)
+	}
+	c4fs.Chmod("log.txt", 0600)
+	if err := c4fs.UpdateFile("log.txt", appendLine("two")); err != nil {
+		t.Fatalf("UpdateFile failed: %v", err)
+	}
+	if data, _ := c4fs.ReadFile("log.txt"); string(data) != "one\ntwo\n" {
+		t.Errorf("content = %q, want %q", data, "one\ntwo\n")
+	}
+	if info, _ := c4fs.Stat("log.txt"); info.Mode().Perm() != 0600 {
+		t.Errorf("mode = %v, want 0600 kept", info.Mode())
+	}
+
+	errAbort := errors.New("abort")
+	err := c4fs.UpdateFile("log.txt", func([]byte) ([]byte, error) { return nil, errAbort })
+	if err != errAbort {
+		t.Errorf("UpdateFile = %v, want the error from fn", err)
+	}
+	if data, _ := c4fs.ReadFile("log.txt"); string(data) != "one\ntwo\n" {
+		t.Errorf("content after aborted update = %q", data)
+	}
+
+	c4fs.Mkdir("dir", 0755)
+	if err := c4fs.UpdateFile("dir", appendLine("x")); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("UpdateFile on a directory = %v, want ErrInvalid", err)
+	}
+}
+
+func TestUpdateFileConcurrent(t *testing.T) {
+	c4fs := New(NewStoreAdapter(newSyncRAM()))
+
+	const writers, increments = 8, 25
+	var wg sync.WaitGroup
+	for range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range increments {
+				err := c4fs.UpdateFile("counter", func(old []byte) ([]byte, error) {
+					n, _ := strconv.Atoi(string(old))
+					return []byte(strconv.Itoa(n + 1)), nil
+				})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	data, _ := c4fs.ReadFile("counter")
+	if want := strconv.Itoa(writers * increments); string(data) != want {
+		t.Errorf("counter = %s, want %s", data, want)
+	}
+}
+
+func TestStaleWriteGuard(t *testing.T) {
+	c4fs := New(NewStoreAdapter(store.NewRAM()), WithStaleWriteGuard())
+	c4fs.WriteFile("doc.txt", []byte("v1"), 0644)
+
+	// Another writer changes the file while it is open
+	f, err := c4fs.Create("doc.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("mine"))
+	c4fs.WriteFile("doc.txt", []byte("theirs"), 0644)
+	var conflict *WriteConflictError
+	if err := f.Close(); !errors.As(err, &conflict) {
+		t.Fatalf("Close after a concurrent write = %v, want *WriteConflictError", err)
+	}
+	if data, _ := c4fs.ReadFile("doc.txt"); string(data) != "theirs" {
+		t.Errorf("content after conflict = %q, want theirs", data)
+	}
+
+	// An undisturbed write succeeds
+	f, _ = c4fs.Create("doc.txt")
+	f.Write([]byte("v3"))
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// A new file created meanwhile by someone else conflicts too
+	f, _ = c4fs.Create("new.txt")
+	c4fs.WriteFile("new.txt", []byte("first"), 0644)
+	if err := f.Close(); !errors.As(err, &conflict) || conflict.Expected != (c4.ID{}) {
+		t.Errorf("Close of a raced create = %v", err)
+	}
+}
+
+func TestCreateIfMatch(t *testing.T) {
+	c4fs := New(NewStoreAdapter(store.NewRAM()))
+	c4fs.WriteFile("doc.txt", []byte("v1"), 0644)
+	v1 := c4.Identify(bytes.NewReader([]byte("v1")))
+
+	f, err := c4fs.CreateIfMatch("doc.txt", v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("v2"))
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// v1 is stale now, whenever the file was opened
+	f, _ = c4fs.CreateIfMatch("doc.txt", v1)
+	f.Write([]byte("v3"))
+	var conflict *WriteConflictError
+	if err := f.Close(); !errors.As(err, &conflict) {
+		t.Errorf("Close with a stale ID = %v, want *WriteConflictError", err)
+	}
+	if data, _ := c4fs.ReadFile("doc.txt"); string(data) != "v2" {
+		t.Errorf("content after conflict = %q, want v2", data)
+	}
+}
@@ -0,0 +1,131 @@
+package c4fs
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/Avalanche-io/c4/c4m"
+)
+
+// Problems reported by ValidateManifest, each wrapped in an
+// *fs.PathError naming the entry.
+var (
+	// ErrInvalidPath is reported for an empty, absolute or unclean path,
+	// or one with "." or ".." elements.
+	ErrInvalidPath = errors.New("invalid path")
+
+	// ErrDuplicatePath is reported for a path that appears more than once.
+	ErrDuplicatePath = errors.New("duplicate path")
+
+	// ErrParentNotDir is reported for an entry whose parent is not a
+	// directory.
+	ErrParentNotDir = errors.New("parent is not a directory")
+
+	// ErrInvalidSize is reported for a negative size other than -1, which
+	// marks a removed path.
+	ErrInvalidSize = errors.New("invalid size")
+
+	// ErrInvalidMode is reported for a mode with more than one file type,
+	// and for a symbolic link without a target or a target on another
+	// type of file.
+	ErrInvalidMode = errors.New("invalid mode")
+)
+
+// ValidationError lists the problems found by ValidateManifest. errors.Is
+// matches it against each of them.
+type ValidationError struct {
+	Errs []error
+}
+
+func (e *ValidationError) Error() string {
+	if len(e.Errs) == 1 {
+		return "invalid manifest: " + e.Errs[0].Error()
+	}
+	return fmt.Sprintf("invalid manifest: %v (and %d more problems)", e.Errs[0], len(e.Errs)-1)
+}
+
+// Unwrap returns the problems found.
+func (e *ValidationError) Unwrap() []error {
+	return e.Errs
+}
+
+// ValidateManifest checks that m can be used as the base of a filesystem,
+// as manifests from untrusted sources should be before they are given to
+// New. It returns a *ValidationError listing every problem found, or nil.
+func ValidateManifest(m *c4m.Manifest) error {
+	var errs []error
+	report := func(name string, err error) {
+		errs = append(errs, &fs.PathError{Op: "validate", Path: name, Err: err})
+	}
+
+	entries := make(map[string]*c4m.Entry, len(m.Entries))
+	for _, e := range m.Entries {
+		if !validPath(e.Name) {
+			report(e.Name, ErrInvalidPath)
+			continue
+		}
+		if _, ok := entries[e.Name]; ok {
+			report(e.Name, ErrDuplicatePath)
+			continue
+		}
+		entries[e.Name] = e
+
+		if e.Size < -1 {
+			report(e.Name, ErrInvalidSize)
+		}
+		if !validMode(e) {
+			report(e.Name, ErrInvalidMode)
+		}
+	}
+
+	// Parents are checked once all paths are known, whatever their order
+	for _, e := range m.Entries {
+		if !validPath(e.Name) {
+			continue
+		}
+		for dir := parentPath(e.Name); dir != ""; dir = parentPath(dir) {
+			if p, ok := entries[dir]; ok && p.Size != -1 && !p.IsDir() {
+				report(e.Name, ErrParentNotDir)
+				break
+			}
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return &ValidationError{Errs: errs}
+}
+
+// validPath reports whether name is a clean relative path without "."
+// or ".." elements.
+func validPath(name string) bool {
+	if name == "" || name == "." || strings.HasPrefix(name, "/") || path.Clean(name) != name {
+		return false
+	}
+	for _, elem := range strings.Split(name, "/") {
+		if elem == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// validMode reports whether the mode of e has a single file type and
+// agrees with its symlink target.
+func validMode(e *c4m.Entry) bool {
+	if e.Size == -1 {
+		return true // tombstones carry no mode
+	}
+	switch e.Mode.Type() {
+	case 0, fs.ModeDir, fs.ModeNamedPipe, fs.ModeSocket, fs.ModeDevice,
+		fs.ModeDevice | fs.ModeCharDevice:
+		return e.Target == ""
+	case fs.ModeSymlink:
+		return e.Target != ""
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+package c4fs
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"testing"
+	"time"
+
+	"github.com/Avalanche-io/c4/c4m"
+	"github.com/Avalanche-io/c4/store"
+)
+
+func TestValidateManifest(t *testing.T) {
+	now := time.Now().UTC()
+	valid := c4m.NewManifest()
+	valid.AddEntry(&c4m.Entry{Name: "dir", Mode: fs.ModeDir | 0755, Timestamp: now})
+	valid.AddEntry(&c4m.Entry{Name: "dir/a.txt", Mode: 0644, Timestamp: now})
+	valid.AddEntry(&c4m.Entry{Name: "implied/b.txt", Mode: 0644, Timestamp: now})
+	valid.AddEntry(&c4m.Entry{Name: "link", Mode: fs.ModeSymlink | 0777, Target: "dir/a.txt", Timestamp: now})
+	valid.AddEntry(&c4m.Entry{Name: "tty", Mode: fs.ModeDevice | fs.ModeCharDevice | 0620, Timestamp: now})
+	if err := ValidateManifest(valid); err != nil {
+		t.Errorf("ValidateManifest of a valid manifest = %v", err)
+	}
+
+	bad := c4m.NewManifest()
+	for _, e := range []*c4m.Entry{
+		{Name: "/abs", Mode: 0644},
+		{Name: "a/../../escape", Mode: 0644},
+		{Name: "../up", Mode: 0644},
+		{Name: "dup", Mode: 0644},
+		{Name: "dup", Mode: 0644},
+		{Name: "file", Mode: 0644},
+		{Name: "file/child", Mode: 0644},
+		{Name: "neg", Mode: 0644, Size: -2},
+		{Name: "types", Mode: fs.ModeDir | fs.ModeSymlink},
+		{Name: "nolink", Mode: fs.ModeSymlink},
+	} {
+		bad.AddEntry(e)
+	}
+	err := ValidateManifest(bad)
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("ValidateManifest = %v, want *ValidationError", err)
+	}
+	want := map[string]error{
+		"/abs":           ErrInvalidPath,
+		"a/../../escape": ErrInvalidPath,
+		"../up":          ErrInvalidPath,
+		"dup":            ErrDuplicatePath,
+		"file/child":     ErrParentNotDir,
+		"neg":            ErrInvalidSize,
+		"types":          ErrInvalidMode,
+		"nolink":         ErrInvalidMode,
+	}
+	if len(verr.Errs) != len(want) {
+		t.Errorf("got %d problems, want %d: %v", len(verr.Errs), len(want), verr.Errs)
+	}
+	for _, e := range verr.Errs {
+		var pe *fs.PathError
+		if !errors.As(e, &pe) || !errors.Is(e, want[pe.Path]) {
+			t.Errorf("unexpected problem %v", e)
+		}
+	}
+	if !errors.Is(err, ErrDuplicatePath) {
+		t.Error("errors.Is does not match a listed problem")
+	}
+
+	// OpenSnapshot checks snapshots only when asked
+	var buf bytes.Buffer
+	if err := WriteSnapshot(&buf, bad, SnapshotBinary); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	adapter := NewStoreAdapter(store.NewRAM())
+	if _, err := OpenSnapshot(bytes.NewReader(data), adapter, WithValidation()); !errors.As(err, &verr) {
+		t.Errorf("OpenSnapshot with validation = %v, want *ValidationError", err)
+	}
+	if _, err := OpenSnapshot(bytes.NewReader(data), adapter); err != nil {
+		t.Errorf("OpenSnapshot without validation = %v", err)
+	}
+}
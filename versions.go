@@ -0,0 +1,121 @@
+package c4fs
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"time"
+
+	"github.com/Avalanche-io/c4"
+	"github.com/Avalanche-io/c4/c4m"
+)
+
+// ErrNoHistory is returned by Versions and OpenVersion when the filesystem
+// has no snapshot history.
+var ErrNoHistory = errors.New("no snapshot history")
+
+// SnapshotHistory gives access to the past snapshots of a filesystem, in
+// which Versions looks for earlier versions of files. RegistrySink
+// implements it for the snapshots a Snapshotter saves.
+type SnapshotHistory interface {
+	// Snapshots returns the names of the snapshots, oldest first.
+	Snapshots() ([]string, error)
+
+	// Snapshot loads the named snapshot and returns it with the time it
+	// was taken.
+	Snapshot(name string) (*c4m.Manifest, time.Time, error)
+}
+
+var _ SnapshotHistory = (*RegistrySink)(nil)
+
+// WithHistory makes the earlier versions of files recorded in h available
+// through Versions and OpenVersion. Clones share the history.
+func WithHistory(h SnapshotHistory) Option {
+	return func(o *options) {
+		o.history = h
+	}
+}
+
+// Version is a past version of a file, as recorded in snapshot history.
+type Version struct {
+	ID       c4.ID     // Content ID
+	Size     int64     // Content size
+	ModTime  time.Time // Modification time
+	Snapshot string    // First snapshot holding this version
+	Taken    time.Time // When that snapshot was taken
+
+	entry *c4m.Entry
+}
+
+// Versions lists the versions of the regular file name found in the
+// filesystem's snapshot history, oldest first. A version held by several
+// consecutive snapshots is listed once, from the first of them. The
+// content of a version stays readable with OpenVersion until the store is
+// garbage collected without the snapshot.
+func (c4fs *FS) Versions(name string) ([]Version, error) {
+	name = cleanPath(name)
+	if c4fs.history == nil {
+		return nil, &fs.PathError{Op: "versions", Path: name, Err: ErrNoHistory}
+	}
+	snapshots, err := c4fs.history.Snapshots()
+	if err != nil {
+		return nil, &fs.PathError{Op: "versions", Path: name, Err: err}
+	}
+
+	var versions []Version
+	for _, snap := range snapshots {
+		m, taken, err := c4fs.history.Snapshot(snap)
+		if err != nil {
+			return nil, &fs.PathError{Op: "versions", Path: name, Err: err}
+		}
+		e := manifestEntry(m, name)
+		if e == nil || !e.Mode.IsRegular() || e.C4ID.IsNil() {
+			continue
+		}
+		if n := len(versions); n > 0 && versions[n-1].ID == e.C4ID {
+			continue
+		}
+		versions = append(versions, Version{
+			ID:       e.C4ID,
+			Size:     e.Size,
+			ModTime:  e.Timestamp,
+			Snapshot: snap,
+			Taken:    taken,
+			entry:    e,
+		})
+	}
+	return versions, nil
+}
+
+// OpenVersion opens the version of the file name with content id, which
+// is either its current content or a version listed by Versions.
+func (c4fs *FS) OpenVersion(name string, id c4.ID) (fs.File, error) {
+	if c4fs.isClosed() {
+		return nil, closedError("open", name)
+	}
+	name = cleanPath(name)
+
+	if e, err := c4fs.lstatEntry(name); err == nil && e.Mode.IsRegular() && e.C4ID == id {
+		return c4fs.openContent(context.Background(), name, e)
+	}
+	versions, err := c4fs.Versions(name)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range versions {
+		if v.ID == id {
+			return c4fs.openContent(context.Background(), name, v.entry)
+		}
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+// manifestEntry returns the entry of m named name, or nil.
+func manifestEntry(m *c4m.Manifest, name string) *c4m.Entry {
+	for _, e := range m.Entries {
+		if e.Name == name {
+			return e
+		}
+	}
+	return nil
+}
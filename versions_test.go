@@ -0,0 +1,63 @@
+package c4fs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Avalanche-io/c4"
+)
+
+func TestVersions(t *testing.T) {
+	r := newTestRegistry(t)
+	sink := NewRegistrySink(r, "backups")
+	fsys := New(r.Store(), WithHistory(sink))
+	ctx := context.Background()
+
+	start := time.Date(2024, 3, 6, 10, 0, 0, 0, time.UTC)
+	for i, content := range []string{"draft", "draft", "review", "final"} {
+		fsys.WriteFile("doc.txt", []byte(content), 0644)
+		if _, err := sink.Save(ctx, fsys.Flatten(), start.Add(time.Duration(i)*time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fsys.WriteFile("doc.txt", []byte("unsaved"), 0644)
+
+	versions, err := fsys.Versions("doc.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 3 || versions[0].Snapshot != "backups/20240306T100000Z" ||
+		!versions[1].Taken.Equal(start.Add(2*time.Hour)) || versions[2].Size != 5 {
+		t.Fatalf("Versions = %+v", versions)
+	}
+
+	for _, want := range []string{"draft", "review", "final", "unsaved"} {
+		f, err := fsys.OpenVersion("doc.txt", c4.Identify(strings.NewReader(want)))
+		if err != nil {
+			t.Fatalf("OpenVersion(%s) failed: %v", want, err)
+		}
+		data, _ := io.ReadAll(f.(io.Reader))
+		f.Close()
+		if string(data) != want {
+			t.Errorf("OpenVersion = %q, want %q", data, want)
+		}
+	}
+
+	// Content recorded under another path is not reachable
+	fsys.WriteFile("other.txt", []byte("secret"), 0644)
+	if _, err := fsys.OpenVersion("doc.txt", c4.Identify(strings.NewReader("secret"))); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("OpenVersion of foreign content = %v, want ErrNotExist", err)
+	}
+	if versions, err := fsys.Versions("missing.txt"); err != nil || len(versions) != 0 {
+		t.Errorf("Versions of a missing file = %v, %v", versions, err)
+	}
+
+	if _, err := New(r.Store()).Versions("doc.txt"); !errors.Is(err, ErrNoHistory) {
+		t.Errorf("Versions without history = %v, want ErrNoHistory", err)
+	}
+}
@@ -0,0 +1,121 @@
+package c4fs
+
+import (
+	"iter"
+
+	"github.com/Avalanche-io/c4/c4m"
+)
+
+// ManifestView is a read-only view of the base or layer manifest of an FS.
+// Unlike Base and Layer it does not copy the manifest, which makes it
+// suitable for hot paths such as metrics. The entries it yields are shared
+// with the filesystem and must not be modified.
+type ManifestView struct {
+	c4fs  *FS
+	layer bool
+}
+
+// BaseView returns a read-only view of the base manifest.
+func (c4fs *FS) BaseView() ManifestView {
+	return ManifestView{c4fs: c4fs}
+}
+
+// LayerView returns a read-only view of the layer manifest.
+// Tombstones (entries with Size -1) are included.
+func (c4fs *FS) LayerView() ManifestView {
+	return ManifestView{c4fs: c4fs, layer: true}
+}
+
+func (v ManifestView) manifest() (*c4m.Manifest, pathIndex) {
+	if v.layer {
+		return v.c4fs.layer, mapIndex(v.c4fs.layerIndex)
+	}
+	return v.c4fs.base, v.c4fs.baseIndex
+}
+
+// Len returns the number of entries in the manifest.
+func (v ManifestView) Len() int {
+	v.c4fs.mu.RLock()
+	defer v.c4fs.mu.RUnlock()
+	m, _ := v.manifest()
+	return len(m.Entries)
+}
+
+// Get returns the entry with the given path, if present.
+func (v ManifestView) Get(name string) (*c4m.Entry, bool) {
+	v.c4fs.mu.RLock()
+	defer v.c4fs.mu.RUnlock()
+	_, index := v.manifest()
+	e, ok := index.get(cleanPath(name))
+	return e, ok
+}
+
+// All returns an iterator over the entries in manifest order.
+// The filesystem's read lock is held while iterating, so the loop body
+// must not modify the filesystem.
+func (v ManifestView) All() iter.Seq[*c4m.Entry] {
+	return func(yield func(*c4m.Entry) bool) {
+		v.c4fs.mu.RLock()
+		defer v.c4fs.mu.RUnlock()
+		m, _ := v.manifest()
+		for _, e := range m.Entries {
+			if !yield(e) {
+				return
+			}
+		}
+	}
+}
+
+// Entry returns the merged entry at name, from the layer or the base,
+// without following symbolic links. It holds the mode, size, time, C4 ID
+// and link target of the file. The entry is shared with the filesystem
+// and must not be modified.
+func (c4fs *FS) Entry(name string) (*c4m.Entry, error) {
+	return c4fs.lstatEntry(name)
+}
+
+// Entries returns an iterator over the entries of the merged view, as in
+// Flatten but without copying them: base entries not replaced by the
+// layer, then layer entries, leaving out removed paths and temp files.
+// The filesystem's read lock is held while iterating, so the loop body
+// must not modify the filesystem. The entries must not be modified.
+//
+// Iteration starts once the batches in progress have finished, and
+// batches started meanwhile wait for it to end, so the entries never
+// hold part of a batch. See Batch.
+func (c4fs *FS) Entries() iter.Seq[*c4m.Entry] {
+	return func(yield func(*c4m.Entry) bool) {
+		c4fs.epoch.Lock()
+		defer c4fs.epoch.Unlock()
+		for e := range c4fs.entries() {
+			if !yield(e) {
+				return
+			}
+		}
+	}
+}
+
+// entries is Entries without waiting for batches.
+func (c4fs *FS) entries() iter.Seq[*c4m.Entry] {
+	return func(yield func(*c4m.Entry) bool) {
+		c4fs.mu.RLock()
+		defer c4fs.mu.RUnlock()
+
+		for _, e := range c4fs.base.Entries {
+			if _, shadowed := c4fs.layerIndex[e.Name]; shadowed {
+				continue
+			}
+			if !yield(e) {
+				return
+			}
+		}
+		for _, e := range c4fs.layer.Entries {
+			if e.Size == -1 || c4fs.isTemp(e.Name) {
+				continue
+			}
+			if !yield(e) {
+				return
+			}
+		}
+	}
+}
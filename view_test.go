@@ -0,0 +1,97 @@
+package c4fs
+
+import (
+	"errors"
+	"io/fs"
+	"slices"
+	"testing"
+
+	"github.com/Avalanche-io/c4/store"
+)
+
+func TestManifestView(t *testing.T) {
+	adapter := NewStoreAdapter(store.NewRAM())
+	baseFS := New(adapter)
+	baseFS.WriteFile("a.txt", []byte("a"), 0644)
+	baseFS.WriteFile("b.txt", []byte("b"), 0644)
+
+	c4fs := New(adapter, WithBase(baseFS.Flatten()))
+	c4fs.WriteFile("c.txt", []byte("c"), 0644)
+	c4fs.Remove("b.txt")
+
+	base, layer := c4fs.BaseView(), c4fs.LayerView()
+	if base.Len() != 2 {
+		t.Errorf("BaseView Len: got %d, want 2", base.Len())
+	}
+	if layer.Len() != 2 {
+		t.Errorf("LayerView Len: got %d, want 2", layer.Len())
+	}
+
+	if e, ok := base.Get("b.txt"); !ok || e.Size != 1 {
+		t.Error("BaseView should still contain b.txt")
+	}
+	if e, ok := layer.Get("b.txt"); !ok || e.Size != -1 {
+		t.Error("LayerView should contain the b.txt tombstone")
+	}
+	if _, ok := base.Get("c.txt"); ok {
+		t.Error("BaseView should not contain layer entries")
+	}
+
+	// Views are live; later writes are visible without a new view
+	c4fs.WriteFile("d.txt", []byte("d"), 0644)
+	var names []string
+	for e := range layer.All() {
+		names = append(names, e.Name)
+	}
+	if len(names) != 3 || names[2] != "d.txt" {
+		t.Errorf("LayerView All: got %v", names)
+	}
+
+	// Stopping early releases the lock
+	for range base.All() {
+		break
+	}
+	if err := c4fs.WriteFile("e.txt", []byte("e"), 0644); err != nil {
+		t.Fatalf("WriteFile after early break failed: %v", err)
+	}
+}
+
+func TestEntries(t *testing.T) {
+	adapter := NewStoreAdapter(store.NewRAM())
+	baseFS := New(adapter)
+	baseFS.WriteFile("a.txt", []byte("a"), 0644)
+	baseFS.WriteFile("b.txt", []byte("b"), 0644)
+
+	c4fs := New(adapter, WithBase(baseFS.Flatten()))
+	c4fs.WriteFile("a.txt", []byte("new a"), 0644)
+	c4fs.Remove("b.txt")
+	c4fs.Symlink("a.txt", "link")
+
+	e, err := c4fs.Entry("a.txt")
+	if err != nil || e.Size != 5 {
+		t.Errorf("Entry(a.txt) = %v, %v; want the layer entry", e, err)
+	}
+	if e, err := c4fs.Entry("link"); err != nil || e.Target != "a.txt" {
+		t.Errorf("Entry(link) = %v, %v; want the link", e, err)
+	}
+	if _, err := c4fs.Entry("b.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Entry of a removed file = %v, want ErrNotExist", err)
+	}
+
+	var names []string
+	for e := range c4fs.Entries() {
+		names = append(names, e.Name)
+	}
+	slices.Sort(names)
+	if !slices.Equal(names, []string{"a.txt", "link"}) {
+		t.Errorf("Entries: got %v", names)
+	}
+
+	// Stopping early releases the lock
+	for range c4fs.Entries() {
+		break
+	}
+	if err := c4fs.WriteFile("c.txt", []byte("c"), 0644); err != nil {
+		t.Fatalf("WriteFile after early break failed: %v", err)
+	}
+}
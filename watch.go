@@ -0,0 +1,105 @@
+package c4fs
+
+import "github.com/Avalanche-io/c4/c4m"
+
+// ChangeOp is the kind of a ChangeEvent.
+type ChangeOp int
+
+const (
+	ChangeCreate ChangeOp = iota + 1
+	ChangeModify
+	ChangeRemove
+
+	// ChangeOverflow reports that the subscriber fell behind and events
+	// were dropped. Consumers should resynchronize, for example by
+	// re-reading the directories they mirror.
+	ChangeOverflow
+)
+
+func (op ChangeOp) String() string {
+	switch op {
+	case ChangeCreate:
+		return "create"
+	case ChangeModify:
+		return "modify"
+	case ChangeRemove:
+		return "remove"
+	case ChangeOverflow:
+		return "overflow"
+	}
+	return "unknown"
+}
+
+// ChangeEvent describes a change to one path of the filesystem.
+// A rename is reported as the removal of the old path and the creation of
+// the new one.
+type ChangeEvent struct {
+	Op    ChangeOp
+	Path  string
+	Entry *c4m.Entry // The new entry; nil for ChangeRemove and ChangeOverflow
+}
+
+// watcher is one subscription.
+type watcher struct {
+	ch       chan ChangeEvent
+	overflow bool
+}
+
+// Subscribe returns a channel that receives an event for every change made
+// to the filesystem from now on, and a function that ends the
+// subscription and closes the channel. Changes are never blocked by slow
+// subscribers: once buffer events are queued, further events are dropped
+// and a ChangeOverflow event is delivered when there is room again.
+// Files in the temp namespace are not reported.
+func (c4fs *FS) Subscribe(buffer int) (<-chan ChangeEvent, func()) {
+	w := &watcher{ch: make(chan ChangeEvent, max(buffer, 1))}
+
+	c4fs.mu.Lock()
+	if c4fs.watchers == nil {
+		c4fs.watchers = make(map[*watcher]struct{})
+	}
+	c4fs.watchers[w] = struct{}{}
+	c4fs.mu.Unlock()
+
+	cancel := func() {
+		c4fs.mu.Lock()
+		defer c4fs.mu.Unlock()
+		if _, ok := c4fs.watchers[w]; ok {
+			delete(c4fs.watchers, w)
+			close(w.ch)
+		}
+	}
+	return w.ch, cancel
+}
+
+// notify delivers ev to every subscriber. The caller must hold c4fs.mu
+// for writing.
+func (c4fs *FS) notify(ev ChangeEvent) {
+	for w := range c4fs.watchers {
+		if w.overflow {
+			select {
+			case w.ch <- ChangeEvent{Op: ChangeOverflow}:
+				w.overflow = false
+			default:
+				continue
+			}
+		}
+		select {
+		case w.ch <- ev:
+		default:
+			w.overflow = true
+		}
+	}
+}
+
+// changeEvent returns the event for replacing prev, the entry previously
+// visible at the path or nil, with entry.
+func changeEvent(prev, entry *c4m.Entry) ChangeEvent {
+	switch {
+	case entry.Size == -1:
+		return ChangeEvent{Op: ChangeRemove, Path: entry.Name}
+	case prev == nil:
+		return ChangeEvent{Op: ChangeCreate, Path: entry.Name, Entry: entry}
+	}
+	return ChangeEvent{Op: ChangeModify, Path: entry.Name, Entry: entry}
+}
@@ -0,0 +1,79 @@
+package c4fs
+
+import (
+	"testing"
+
+	"github.com/Avalanche-io/c4/store"
+)
+
+func TestC4FSSubscribe(t *testing.T) {
+	c4fs := New(NewStoreAdapter(store.NewRAM()))
+	events, cancel := c4fs.Subscribe(16)
+
+	c4fs.Mkdir("dir", 0755)
+	c4fs.WriteFile("dir/a.txt", []byte("one"), 0644)
+	c4fs.WriteFile("dir/a.txt", []byte("two"), 0644)
+	c4fs.Rename("dir/a.txt", "dir/b.txt")
+	c4fs.Remove("dir/b.txt")
+	c4fs.Remove("dir/b.txt") // already gone: no event
+	f, _, _ := c4fs.CreateTemp("", "scratch-*")
+	f.Close()
+
+	want := []struct {
+		op   ChangeOp
+		path string
+	}{
+		{ChangeCreate, "dir"},
+		{ChangeCreate, "dir/a.txt"},
+		{ChangeModify, "dir/a.txt"},
+		{ChangeCreate, "dir/b.txt"},
+		{ChangeRemove, "dir/a.txt"},
+		{ChangeRemove, "dir/b.txt"},
+	}
+	cancel()
+	var got []ChangeEvent
+	for ev := range events {
+		got = append(got, ev)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d events, want %d: %v", len(got), len(want), got)
+	}
+	for i, w := range want {
+		if got[i].Op != w.op || got[i].Path != w.path {
+			t.Errorf("event %d: got %s %s, want %s %s", i, got[i].Op, got[i].Path, w.op, w.path)
+		}
+	}
+	if got[2].Entry == nil || got[2].Entry.Size != 3 {
+		t.Errorf("modify event entry: %+v", got[2].Entry)
+	}
+
+	// Cancelling twice is harmless and later changes are not delivered
+	cancel()
+	c4fs.WriteFile("after.txt", []byte("x"), 0644)
+}
+
+// TestC4FSSubscribeOverflow tests that a slow subscriber loses events
+// without blocking writers, and is told so.
+func TestC4FSSubscribeOverflow(t *testing.T) {
+	c4fs := New(NewStoreAdapter(store.NewRAM()))
+	events, cancel := c4fs.Subscribe(2)
+	defer cancel()
+
+	for _, name := range []string{"a", "b", "c", "d"} {
+		c4fs.WriteFile(name, []byte(name), 0644)
+	}
+	if ev := <-events; ev.Path != "a" {
+		t.Errorf("first event: got %s", ev.Path)
+	}
+	if ev := <-events; ev.Path != "b" {
+		t.Errorf("second event: got %s", ev.Path)
+	}
+
+	c4fs.WriteFile("e", []byte("e"), 0644)
+	if ev := <-events; ev.Op != ChangeOverflow {
+		t.Errorf("expected overflow, got %s %s", ev.Op, ev.Path)
+	}
+	if ev := <-events; ev.Path != "e" {
+		t.Errorf("event after overflow: got %s %s", ev.Op, ev.Path)
+	}
+}